@@ -0,0 +1,264 @@
+package miya
+
+import (
+	"fmt"
+	goruntime "runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zipreport/miya/loader"
+)
+
+type benchProduct struct {
+	ID          int
+	Name        string
+	Description string
+	Price       float64
+	Tags        []string
+}
+
+// newBenchEnvironment returns an environment with the templates of the
+// benchmark suite
+func newBenchEnvironment(tb testing.TB) *Environment {
+	tb.Helper()
+	l := loader.NewStringLoader(loader.NewDirectTemplateParser())
+
+	l.AddTemplate("products.html", `<table>{% for p in products %}<tr class="{{ loop.cycle('odd', 'even') }}"><td>{{ p.ID }}</td><td>{{ p.Name }}</td><td>{{ "%.2f"|format(p.Price) }}</td><td>{{ p.Tags|join(", ") }}</td></tr>{% endfor %}</table>`)
+
+	l.AddTemplate("base.html", `<html><head>{% block head %}<title>{% block title %}{% endblock %}</title>{% endblock %}</head><body>{% block body %}{% endblock %}</body></html>`)
+	var includes strings.Builder
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("widget%d.html", i)
+		l.AddTemplate(name, fmt.Sprintf(`<aside>{{ user.name }} %d</aside>`, i))
+		fmt.Fprintf(&includes, `{%% include %q %%}`, name)
+	}
+	l.AddTemplate("layout.html", `{% extends "base.html" %}{% block body %}<main>{% block content %}{% endblock %}</main>`+includes.String()+`{% endblock %}`)
+	l.AddTemplate("section.html", `{% extends "layout.html" %}{% block title %}Shop - {% block subtitle %}{% endblock %}{% endblock %}`)
+	l.AddTemplate("page.html", `{% extends "section.html" %}{% block subtitle %}{{ user.name }}{% endblock %}{% block content %}{{ super() }}<p>{{ user.bio }}</p>{% endblock %}`)
+
+	l.AddTemplate("filters.html", `{% for p in products %}{{ p.Name|trim|lower|replace("product", "item")|title|truncate(20) }} {{ p.Tags|join("/")|upper|replace("/", " ")|lower|length }} {{ p.Description|wordcount }} {{ p.Price|round(1)|string|center(12) }}{% endfor %}`)
+
+	l.AddTemplate("form.html", `{% macro input(name, value="", type="text") %}<input type="{{ type }}" name="{{ name }}" value="{{ value }}">{% endmacro %}`+
+		`{% macro field(label, name, value="", errors=[]) %}<div class="field"><label for="{{ name }}">{{ label }}</label>{{ input(name, value) }}{% for e in errors %}<span class="error">{{ e }}</span>{% endfor %}</div>{% endmacro %}`+
+		`<form>{% for p in products %}{{ field(p.Name, "product" ~ p.ID, p.Price, p.Tags) }}{% endfor %}{{ input("submit", "Save", "submit") }}</form>`)
+
+	l.AddTemplate("escaped.html", `<ul>{% for p in products %}<li title="{{ p.Name }}">{{ p.Description }} {{ p.Tags|join(" & ") }}</li>{% endfor %}</ul>`)
+
+	return NewEnvironment(WithLoader(l), WithAutoEscape(true))
+}
+
+func newBenchContext() Context {
+	products := make([]benchProduct, 500)
+	for i := range products {
+		products[i] = benchProduct{
+			ID:          i,
+			Name:        fmt.Sprintf("  Product %d  ", i),
+			Description: fmt.Sprintf(`<b>Product %d</b> is "great" & <i>cheap</i>`, i),
+			Price:       float64(i) * 1.25,
+			Tags:        []string{"new", fmt.Sprintf("tag%d", i%7)},
+		}
+	}
+	return NewContextFrom(map[string]interface{}{
+		"products": products,
+		"user":     map[string]interface{}{"name": "<Ada>", "bio": "Writes & tests"},
+	})
+}
+
+// BenchmarkRender renders representative templates: a large loop over
+// structs, a four level inheritance chain with includes, heavy filter chains,
+// macro-heavy form rendering and autoescaped HTML
+func BenchmarkRender(b *testing.B) {
+	env := newBenchEnvironment(b)
+	ctx := newBenchContext()
+
+	for _, bench := range []struct{ name, template string }{
+		{"LargeLoop", "products.html"},
+		{"DeepInheritance", "page.html"},
+		{"FilterChains", "filters.html"},
+		{"MacroForm", "form.html"},
+		{"AutoescapedHTML", "escaped.html"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tmpl, err := env.GetTemplate(bench.template)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := tmpl.Render(ctx); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := tmpl.Render(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRenderSimple renders a text-only template and "Hello {{ name }}",
+// which skip the evaluator, and the latter with the evaluator for comparison
+func BenchmarkRenderSimple(b *testing.B) {
+	env := NewEnvironment(WithAutoEscape(true))
+	ctx := NewContextFrom(map[string]interface{}{"name": "Ada & Grace"})
+
+	for _, bench := range []struct {
+		name, template string
+		evaluate       bool
+	}{
+		{"Constant", "Your order has shipped.", false},
+		{"Variable", "Hello {{ name }}", false},
+		{"VariableEvaluated", "Hello {{ name }}", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tmpl, err := env.FromString(bench.template)
+			if err != nil {
+				b.Fatal(err)
+			}
+			render := func() (string, error) { return tmpl.Render(ctx) }
+			if bench.evaluate {
+				render = func() (string, error) { return evaluate(tmpl, ctx) }
+			}
+			if _, err := render(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := render(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newBatchContexts returns n contexts of a personalized email
+func newBatchContexts(n int) []Context {
+	contexts := make([]Context, n)
+	for i := range contexts {
+		contexts[i] = NewContextFrom(map[string]interface{}{
+			"user": map[string]interface{}{
+				"name":   fmt.Sprintf("user <%d>", i),
+				"orders": []interface{}{map[string]interface{}{"item": "pen", "total": 1.5 * float64(i%9)}, map[string]interface{}{"item": "ink", "total": 2.25}},
+			},
+		})
+	}
+	return contexts
+}
+
+func newBatchTemplate(tb testing.TB) *Template {
+	tb.Helper()
+	l := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	l.AddTemplate("mail_base.html", `<html><body>{% block body %}{% endblock %}<footer>{% block footer %}Thanks{% endblock %}</footer></body></html>`)
+	l.AddTemplate("mail.html", `{% extends "mail_base.html" %}{% block body %}{% macro money(v) %}${{ "%.2f"|format(v) }}{% endmacro %}<p>Hi {{ user.name|title }},</p><ul>{% for o in user.orders %}<li>{{ o.item }}: {{ money(o.total) }}</li>{% endfor %}</ul>{% endblock %}`)
+	tmpl, err := NewEnvironment(WithLoader(l), WithAutoEscape(true)).GetTemplate("mail.html")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tmpl
+}
+
+// BenchmarkRenderMany renders an email template for 10k contexts with Render
+// in a loop and with RenderMany, one at a time and on as many workers as
+// CPUs
+func BenchmarkRenderMany(b *testing.B) {
+	tmpl := newBatchTemplate(b)
+	contexts := newBatchContexts(10000)
+
+	b.Run("LoopedRender", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, ctx := range contexts {
+				if _, err := tmpl.Render(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	workerCounts := []int{1}
+	if n := goruntime.GOMAXPROCS(0); n > 1 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("RenderMany/workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, errs := tmpl.RenderMany(contexts, BatchWorkers(workers)); errs[0] != nil {
+					b.Fatal(errs[0])
+				}
+			}
+		})
+	}
+}
+
+// TestRenderManyMatchesRender checks that batch renders of the benchmark's
+// contexts give the outputs of Render, in order
+func TestRenderManyMatchesRender(t *testing.T) {
+	tmpl := newBatchTemplate(t)
+	contexts := newBatchContexts(10000)
+	for _, workers := range []int{1, 8} {
+		outputs, errs := tmpl.RenderMany(contexts, BatchWorkers(workers))
+		for i, ctx := range contexts {
+			want, err := tmpl.Render(ctx)
+			if err != nil || errs[i] != nil {
+				t.Fatalf("context %d: %v, %v", i, err, errs[i])
+			}
+			if outputs[i] != want {
+				t.Fatalf("workers=%d, context %d: got %q, want %q", workers, i, outputs[i], want)
+			}
+		}
+	}
+}
+
+// TestPooledRenderStateNotShared renders templates one after another and
+// concurrently on one environment, whose evaluators and loop objects are
+// reused, and checks that no render sees another's variables or macros
+func TestPooledRenderStateNotShared(t *testing.T) {
+	env := NewEnvironment(WithAutoEscape(false))
+	first, err := env.FromString(`{% macro secret() %}s3cr3t{% endmacro %}{% set token = "t0ken" %}{% set ns = namespace(loop=none) %}` +
+		`{% for x in items %}{% set ns.loop = loop %}{{ x }}{% endfor %}|{{ ns.loop.index }},{{ ns.loop.previtem }},{{ ns.loop.length }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := env.FromString(`{{ secret is defined }},{{ token is defined }},{{ ns is defined }}|{% for y in items %}{{ loop.index }}{{ loop.previtem }}{% endfor %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(tmpl *Template, items ...interface{}) string {
+		out, err := tmpl.Render(NewContextFrom(map[string]interface{}{"items": items}))
+		if err != nil {
+			t.Error(err)
+		}
+		return out
+	}
+
+	if got, want := render(first, "a", "b", "c"), "abc|3,b,3"; got != want {
+		t.Errorf("first render = %q, want %q", got, want)
+	}
+	if got, want := render(second, "z"), "false,false,false|1"; got != want {
+		t.Errorf("second render = %q, want %q", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("%d%d|2,%d,2", i, i+1, i)
+			if got := render(first, i, i+1); got != want {
+				t.Errorf("first render = %q, want %q", got, want)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if got, want := render(second, "p", "q"), "false,false,false|12p"; got != want {
+				t.Errorf("second render = %q, want %q", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
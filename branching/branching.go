@@ -1,12 +1,14 @@
 package branching
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/zipreport/miya/runtime"
 )
@@ -16,8 +18,9 @@ type TestFunc func(value interface{}, args ...interface{}) (bool, error)
 
 // TestRegistry manages template tests
 type TestRegistry struct {
-	tests map[string]TestFunc
-	mutex sync.RWMutex
+	tests  map[string]TestFunc
+	mutex  sync.RWMutex
+	parent *TestRegistry // consulted for names not registered here
 }
 
 // NewTestRegistry creates a new test registry
@@ -32,6 +35,15 @@ func NewTestRegistry() *TestRegistry {
 	return registry
 }
 
+// NewChildTestRegistry creates an empty registry layered over parent. Tests
+// registered on the child shadow the parent's; other lookups fall through.
+func NewChildTestRegistry(parent *TestRegistry) *TestRegistry {
+	return &TestRegistry{
+		tests:  make(map[string]TestFunc),
+		parent: parent,
+	}
+}
+
 // Register registers a test function
 func (r *TestRegistry) Register(name string, test TestFunc) error {
 	r.mutex.Lock()
@@ -51,6 +63,9 @@ func (r *TestRegistry) Get(name string) (TestFunc, bool) {
 	defer r.mutex.RUnlock()
 
 	test, ok := r.tests[name]
+	if !ok && r.parent != nil {
+		return r.parent.Get(name)
+	}
 	return test, ok
 }
 
@@ -63,10 +78,50 @@ func (r *TestRegistry) List() []string {
 	for name := range r.tests {
 		names = append(names, name)
 	}
+	if r.parent != nil {
+		for _, name := range r.parent.List() {
+			if _, shadowed := r.tests[name]; !shadowed {
+				names = append(names, name)
+			}
+		}
+	}
 	return names
 }
 
 // Apply applies a test to a value
+// RegisterAlias makes alias call the test registered as target, replacing
+// any test already named alias. Like FilterRegistry.RegisterAlias, the target
+// is resolved on first use and its errors are wrapped in a *runtime.AliasError.
+func (r *TestRegistry) RegisterAlias(alias, target string) error {
+	if alias == "" || target == "" || alias == target {
+		return fmt.Errorf("invalid test alias %q -> %q", alias, target)
+	}
+
+	var resolved atomic.Pointer[TestFunc]
+	aliasFn := func(value interface{}, args ...interface{}) (bool, error) {
+		fn := resolved.Load()
+		if fn == nil {
+			targetFn, ok := r.Get(target)
+			if !ok {
+				return false, &runtime.AliasError{Kind: "test", Alias: alias, Target: target, Err: fmt.Errorf("unknown test: %s", target)}
+			}
+			fn = &targetFn
+			resolved.Store(fn)
+		}
+
+		result, err := (*fn)(value, args...)
+		if err != nil {
+			return false, &runtime.AliasError{Kind: "test", Alias: alias, Target: target, Err: err}
+		}
+		return result, nil
+	}
+
+	r.mutex.Lock()
+	r.tests[alias] = aliasFn
+	r.mutex.Unlock()
+	return nil
+}
+
 func (r *TestRegistry) Apply(name string, value interface{}, args ...interface{}) (bool, error) {
 	test, ok := r.Get(name)
 	if !ok {
@@ -124,6 +179,19 @@ func (r *TestRegistry) registerBuiltinTests() {
 	r.tests["gt"] = testGreaterThan
 	r.tests["ge"] = testGreaterThanOrEqual
 	r.tests["equalto"] = testEqual // alias
+
+	// Jinja2's operator names for the comparison tests, for select("<", 3)
+	r.tests["=="] = testEqual
+	r.tests["!="] = testNotEqual
+	r.tests["<"] = testLessThan
+	r.tests["lessthan"] = testLessThan
+	r.tests["<="] = testLessThanOrEqual
+	r.tests[">"] = testGreaterThan
+	r.tests["greaterthan"] = testGreaterThan
+	r.tests[">="] = testGreaterThanOrEqual
+
+	r.tests["true"] = testTrue
+	r.tests["false"] = testFalse
 }
 
 // Built-in test implementations
@@ -163,6 +231,18 @@ func testBoolean(value interface{}, args ...interface{}) (bool, error) {
 	return ok, nil
 }
 
+// testTrue checks if a value is the boolean true
+func testTrue(value interface{}, args ...interface{}) (bool, error) {
+	b, ok := value.(bool)
+	return ok && b, nil
+}
+
+// testFalse checks if a value is the boolean false
+func testFalse(value interface{}, args ...interface{}) (bool, error) {
+	b, ok := value.(bool)
+	return ok && !b, nil
+}
+
 // testString checks if a value is a string
 func testString(value interface{}, args ...interface{}) (bool, error) {
 	_, ok := value.(string)
@@ -212,7 +292,8 @@ func testSequence(value interface{}, args ...interface{}) (bool, error) {
 	case reflect.String:
 		return true, nil // strings are sequences in Jinja2
 	default:
-		return false, nil
+		_, ok := runtime.CollectionLen(value)
+		return ok, nil
 	}
 }
 
@@ -221,6 +302,9 @@ func testMapping(value interface{}, args ...interface{}) (bool, error) {
 	if value == nil {
 		return false, nil
 	}
+	if _, ok := value.(*runtime.Dict); ok {
+		return true, nil
+	}
 
 	rv := reflect.ValueOf(value)
 	return rv.Kind() == reflect.Map, nil
@@ -237,7 +321,8 @@ func testIterable(value interface{}, args ...interface{}) (bool, error) {
 	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
 		return true, nil
 	default:
-		return false, nil
+		_, ok := runtime.CollectionItems(value)
+		return ok, nil
 	}
 }
 
@@ -372,14 +457,15 @@ func testMatch(value interface{}, args ...interface{}) (bool, error) {
 	return matched, nil
 }
 
-// testIn checks if a value is in a container
+// testIn checks if a value is in a container, by the same rules as the in
+// operator
 func testIn(value interface{}, args ...interface{}) (bool, error) {
 	if len(args) != 1 {
 		return false, fmt.Errorf("in test requires exactly one argument")
 	}
 
 	container := args[0]
-	return contains(container, value)
+	return runtime.Contains(container, value)
 }
 
 // testContains checks if a container contains a value
@@ -389,7 +475,7 @@ func testContains(value interface{}, args ...interface{}) (bool, error) {
 	}
 
 	item := args[0]
-	return contains(value, item)
+	return runtime.Contains(value, item)
 }
 
 // testEmpty checks if a value is empty (empty string, empty container, zero, etc.)
@@ -444,10 +530,14 @@ func testEmpty(value interface{}, args ...interface{}) (bool, error) {
 		case reflect.String:
 			return rv.String() == "", nil
 		case reflect.Ptr, reflect.Interface:
-			return rv.IsNil(), nil
-		default:
-			return false, nil
+			if rv.IsNil() {
+				return true, nil
+			}
 		}
+		if n, ok := runtime.CollectionLen(value); ok {
+			return n == 0, nil
+		}
+		return false, nil
 	}
 }
 
@@ -458,54 +548,36 @@ func testSameAs(value interface{}, args ...interface{}) (bool, error) {
 	}
 
 	other := args[0]
-
-	// For basic types, sameas is the same as equality
-	// For reference types, we check if they point to the same memory location
-	if value == nil && other == nil {
-		return true, nil
-	}
 	if value == nil || other == nil {
-		return false, nil
+		return value == nil && other == nil, nil
 	}
 
-	// Use reflect to check if they are the same object for reference types
+	// Values of different types are never the same object
 	v1 := reflect.ValueOf(value)
 	v2 := reflect.ValueOf(other)
-
-	// If types don't match, they can't be the same object
 	if v1.Type() != v2.Type() {
 		return false, nil
 	}
 
-	// For pointer, slice, map, chan, func types check if they point to the same location
 	switch v1.Kind() {
-	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func:
-		if !v1.IsValid() || !v2.IsValid() {
-			return v1.IsValid() == v2.IsValid(), nil
-		}
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
 		return v1.Pointer() == v2.Pointer(), nil
+	case reflect.Slice:
+		// Slices of one array are only the same when they also cover the
+		// same items
+		return v1.Pointer() == v2.Pointer() && v1.Len() == v2.Len(), nil
 	default:
-		// For value types, sameas is equivalent to equality
+		// Strings, numbers, booleans and structs are copied when passed
+		// around, so they have no identity and are the same when equal
 		return reflect.DeepEqual(value, other), nil
 	}
 }
 
-// testEscaped checks if a value is marked as escaped/safe
+// testEscaped checks if a value is marked safe, so it isn't escaped on output:
+// the result of the safe filter, or a runtime.SafeString set from Go code
 func testEscaped(value interface{}, args ...interface{}) (bool, error) {
-	// Check if the value is wrapped in a SafeValue (from filters package)
-	// We need to check the type name since we can't import the filters package here
-	// due to potential circular imports
-	valueType := reflect.TypeOf(value)
-	if valueType != nil {
-		// Check if the type name suggests it's a safe/escaped value
-		typeName := valueType.String()
-		if strings.Contains(typeName, "SafeValue") || strings.Contains(typeName, "Safe") {
-			return true, nil
-		}
-	}
-
-	// By default, values are not considered escaped
-	return false, nil
+	_, safe := runtime.UnwrapSafe(value)
+	return safe, nil
 }
 
 // testEqual checks if two values are equal
@@ -593,6 +665,12 @@ func toInt(value interface{}) (int, error) {
 		return int(v), nil
 	case float64:
 		return int(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), nil
+		}
+		f, err := v.Float64()
+		return int(f), err
 	case string:
 		return strconv.Atoi(v)
 	default:
@@ -600,47 +678,6 @@ func toInt(value interface{}) (int, error) {
 	}
 }
 
-// contains checks if a container contains an item
-func contains(container, item interface{}) (bool, error) {
-	if container == nil {
-		return false, nil
-	}
-
-	switch v := container.(type) {
-	case string:
-		itemStr := fmt.Sprintf("%v", item)
-		return strings.Contains(v, itemStr), nil
-	case []interface{}:
-		for _, elem := range v {
-			if reflect.DeepEqual(elem, item) {
-				return true, nil
-			}
-		}
-		return false, nil
-	case map[string]interface{}:
-		itemStr := fmt.Sprintf("%v", item)
-		_, ok := v[itemStr]
-		return ok, nil
-	default:
-		// Use reflection for other slice/array types
-		rv := reflect.ValueOf(container)
-		switch rv.Kind() {
-		case reflect.Slice, reflect.Array:
-			for i := 0; i < rv.Len(); i++ {
-				if reflect.DeepEqual(rv.Index(i).Interface(), item) {
-					return true, nil
-				}
-			}
-			return false, nil
-		case reflect.Map:
-			itemValue := reflect.ValueOf(item)
-			return rv.MapIndex(itemValue).IsValid(), nil
-		default:
-			return false, fmt.Errorf("cannot check containment in %T", container)
-		}
-	}
-}
-
 // compareValues compares two values and applies a comparison function
 func compareValues(v1, v2 interface{}, compFunc func(int) bool) (bool, error) {
 	// Handle nil values
@@ -722,6 +759,8 @@ func toFloat(value interface{}) (float64, error) {
 		return float64(v), nil
 	case float64:
 		return v, nil
+	case json.Number:
+		return v.Float64()
 	case string:
 		return strconv.ParseFloat(v, 64)
 	default:
@@ -4,8 +4,12 @@ import (
 	"testing"
 
 	"github.com/zipreport/miya/filters"
+	"github.com/zipreport/miya/runtime"
 )
 
+// SafeMode has a name like a safe value's, but isn't one
+type SafeMode bool
+
 func TestBuiltinTests(t *testing.T) {
 	registry := NewTestRegistry()
 
@@ -679,6 +683,8 @@ func TestUtilityFunctions(t *testing.T) {
 	t.Run("testSameAs", func(t *testing.T) {
 		obj1 := &struct{ val int }{val: 1}
 		obj2 := &struct{ val int }{val: 1}
+		list := []interface{}{1, 2, 3}
+		dict := map[string]interface{}{"a": 1}
 
 		tests := []struct {
 			name     string
@@ -691,6 +697,13 @@ func TestUtilityFunctions(t *testing.T) {
 			{"nil same as nil", nil, []interface{}{nil}, true},
 			{"value types equal", 5, []interface{}{5}, true}, // value types are compared by value
 			{"strings equal", "hello", []interface{}{"hello"}, true},
+			{"nil not same as value", nil, []interface{}{0}, false},
+			{"different types", 1, []interface{}{1.0}, false},
+			{"same slice", list, []interface{}{list}, true},
+			{"equal slices", list, []interface{}{[]interface{}{1, 2, 3}}, false},
+			{"shorter slice of same array", list, []interface{}{list[:2]}, false},
+			{"same map", dict, []interface{}{dict}, true},
+			{"equal maps", dict, []interface{}{map[string]interface{}{"a": 1}}, false},
 		}
 
 		for _, tt := range tests {
@@ -713,6 +726,9 @@ func TestUtilityFunctions(t *testing.T) {
 			expected bool
 		}{
 			{"SafeValue is escaped", filters.SafeValue{Value: "safe"}, true},
+			{"runtime SafeValue is escaped", runtime.SafeValue{Value: "safe"}, true},
+			{"SafeString is escaped", runtime.SafeString("safe"), true},
+			{"type named Safe not escaped", SafeMode(true), false},
 			{"string not escaped", "unsafe", false},
 			{"number not escaped", 123, false},
 			{"nil not escaped", nil, false},
@@ -225,10 +225,16 @@ func NewTemplatePool(template *Template) *TemplatePool {
 			New: func() interface{} {
 				// Return a copy of the template for concurrent use
 				return &Template{
-					name:   template.name,
-					source: template.source,
-					env:    template.env,
-					ast:    template.ast,
+					name:       template.name,
+					source:     template.source,
+					filename:   template.filename,
+					env:        template.env,
+					ast:        template.ast,
+					metadata:   template.metadata,
+					settings:   template.settings,
+					fast:       template.fast,
+					sourceHash: template.sourceHash,
+					version:    template.version,
 				}
 			},
 		},
@@ -242,10 +248,16 @@ func (tp *TemplatePool) Get() *Template {
 	}
 	// Fallback: create new template if pool returns unexpected type
 	return &Template{
-		name:   tp.template.name,
-		source: tp.template.source,
-		env:    tp.template.env,
-		ast:    tp.template.ast,
+		name:       tp.template.name,
+		source:     tp.template.source,
+		filename:   tp.template.filename,
+		env:        tp.template.env,
+		ast:        tp.template.ast,
+		metadata:   tp.template.metadata,
+		settings:   tp.template.settings,
+		fast:       tp.template.fast,
+		sourceHash: tp.template.sourceHash,
+		version:    tp.template.version,
 	}
 }
 
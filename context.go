@@ -2,10 +2,15 @@ package miya
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/zipreport/miya/runtime"
 )
 
 // capitalizeFirst capitalizes the first letter of a string.
@@ -22,13 +27,32 @@ func capitalizeFirst(s string) string {
 	return string(unicode.ToUpper(r)) + s[size:]
 }
 
+// Context holds the variables a template renders with. Push starts a nested
+// scope whose variables shadow the outer ones until Pop returns to the outer
+// scope; Set, Delete and Update only change the current scope.
 type Context interface {
 	Get(key string) (interface{}, bool)
 	Set(key string, value interface{})
+	// Has reports whether Get would find key, in any scope or among the
+	// environment's globals
+	Has(key string) bool
+	// Delete removes key from the current scope. A variable of the same name
+	// in an outer scope becomes visible again.
+	Delete(key string)
+	// Update sets every variable in values. With preserve set, variables Has
+	// already reports are left as they are, so values act as defaults.
+	Update(values map[string]interface{}, preserve bool)
+	// Keys returns the sorted names of the variables in every scope, without
+	// the environment's globals
+	Keys() []string
 	Push() Context
 	Pop() Context
 	All() map[string]interface{}
 	GetEnv() *Environment
+	// Clone returns a context with a copy of the current scope's variable map,
+	// so setting or deleting a variable in one doesn't affect the other. The
+	// values are not copied: a nested map or slice changed in place through
+	// one context is changed in both. Outer scopes are shared.
 	Clone() Context
 }
 
@@ -47,9 +71,9 @@ type context struct {
 	data   map[string]interface{}
 	env    *Environment
 
-	// Phase 3b optimization: Cache for All() method
-	cachedAll     map[string]interface{}
-	allCacheValid bool
+	// Phase 3b optimization: Cache for All() method. Renders only read the
+	// context they are given, so several may share it; the cache is atomic.
+	cachedAll atomic.Pointer[map[string]interface{}]
 }
 
 func NewContext() Context {
@@ -98,7 +122,7 @@ func (c *context) Get(key string) (interface{}, bool) {
 
 		// Check environment globals
 		if c.env != nil {
-			if val, ok := c.env.globals[key]; ok {
+			if val, ok := c.env.lookupGlobal(key); ok {
 				return val, true
 			}
 		}
@@ -120,7 +144,7 @@ func (c *context) Get(key string) (interface{}, bool) {
 
 	// Check environment globals (only for simple keys)
 	if c.env != nil && len(parts) == 1 {
-		if val, ok := c.env.globals[key]; ok {
+		if val, ok := c.env.lookupGlobal(key); ok {
 			return val, true
 		}
 	}
@@ -157,25 +181,34 @@ func (c *context) getAttribute(obj interface{}, attr string) interface{} {
 	switch v := obj.(type) {
 	case map[string]interface{}:
 		return v[attr]
+	case *runtime.Dict:
+		value, _ := v.Get(attr)
+		return value
 	case map[string]string:
 		return v[attr]
 	default:
-		// Use reflection for struct fields
+		// Use reflection for struct fields, including those promoted from
+		// embedded structs, and the values of maps with string keys
 		rv := reflect.ValueOf(obj)
 		if rv.Kind() == reflect.Ptr {
 			rv = rv.Elem()
 		}
 
 		if rv.Kind() == reflect.Struct {
-			field := rv.FieldByName(attr)
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
+			for _, name := range [2]string{attr, capitalizeFirst(attr)} {
+				f, ok := rv.Type().FieldByName(name)
+				if !ok || !f.IsExported() {
+					continue
+				}
+				// A field of a nil embedded pointer isn't there
+				if field, err := rv.FieldByIndexErr(f.Index); err == nil {
+					return field.Interface()
+				}
 			}
-
-			// Try with capitalized field name
-			field = rv.FieldByName(capitalizeFirst(attr))
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
+		}
+		if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+			if value := rv.MapIndex(reflect.ValueOf(attr).Convert(rv.Type().Key())); value.IsValid() {
+				return value.Interface()
 			}
 		}
 
@@ -224,7 +257,37 @@ func safeMethodCall(rv reflect.Value, methodName string) (result interface{}) {
 func (c *context) Set(key string, value interface{}) {
 	c.data[key] = value
 	// Invalidate All() cache (Phase 3b optimization)
-	c.allCacheValid = false
+	c.cachedAll.Store(nil)
+}
+
+func (c *context) Has(key string) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+func (c *context) Delete(key string) {
+	delete(c.data, key)
+	c.cachedAll.Store(nil)
+}
+
+func (c *context) Update(values map[string]interface{}, preserve bool) {
+	for k, v := range values {
+		if preserve && c.Has(k) {
+			continue
+		}
+		c.data[k] = v
+	}
+	c.cachedAll.Store(nil)
+}
+
+func (c *context) Keys() []string {
+	keys := make(map[string]struct{}, len(c.data))
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		for k := range ctx.data {
+			keys[k] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(keys))
 }
 
 func (c *context) Push() Context {
@@ -244,17 +307,15 @@ func (c *context) Pop() Context {
 
 func (c *context) All() map[string]interface{} {
 	// Use cached version if valid (Phase 3b optimization)
-	if c.allCacheValid {
-		return c.cachedAll
+	if cached := c.cachedAll.Load(); cached != nil {
+		return *cached
 	}
 
 	result := make(map[string]interface{})
 
 	// Phase 3b optimization: Start with environment globals (if present)
 	if c.env != nil {
-		for k, v := range c.env.globals {
-			result[k] = v
-		}
+		c.env.collectGlobals(result)
 	}
 
 	// Collect all values from parent contexts (overrides globals)
@@ -274,8 +335,7 @@ func (c *context) All() map[string]interface{} {
 	collect(c)
 
 	// Cache the result (Phase 3b optimization)
-	c.cachedAll = result
-	c.allCacheValid = true
+	c.cachedAll.Store(&result)
 
 	return result
 }
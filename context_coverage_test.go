@@ -1,6 +1,7 @@
 package miya
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -232,6 +233,91 @@ func TestContextClone(t *testing.T) {
 	}
 }
 
+// TestContextVariableHelpers tests Has, Delete, Update and Keys on each
+// Context implementation
+func TestContextVariableHelpers(t *testing.T) {
+	for _, impl := range []struct {
+		name string
+		new  func() Context
+	}{
+		{"context", NewContext},
+		{"cowContext", NewCOWContext},
+		{"MemoryOptimizedContext", func() Context { return NewMemoryOptimizedContext() }},
+	} {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := impl.new()
+			ctx.Set("title", "Home")
+			ctx.Set("user", map[string]interface{}{"name": "Ada"})
+			scope := ctx.Push()
+			scope.Set("title", "Inner")
+			scope.Set("page", 2)
+
+			if !scope.Has("title") || !scope.Has("user") || scope.Has("missing") {
+				t.Errorf("Has = %v, %v, %v; want true, true, false", scope.Has("title"), scope.Has("user"), scope.Has("missing"))
+			}
+			if got, want := strings.Join(scope.Keys(), ","), "page,title,user"; got != want {
+				t.Errorf("Keys() = %s, want %s", got, want)
+			}
+
+			scope.Delete("title")
+			if val, _ := scope.Get("title"); val != "Home" {
+				t.Errorf("after Delete, Get('title') = %v, want the outer scope's Home", val)
+			}
+			scope.Delete("page")
+			if scope.Has("page") {
+				t.Error("Delete('page') left the variable defined")
+			}
+
+			scope.Update(map[string]interface{}{"title": "Default", "lang": "en"}, true)
+			if val, _ := scope.Get("title"); val != "Home" {
+				t.Errorf("Update with preserve overwrote title with %v", val)
+			}
+			if val, _ := scope.Get("lang"); val != "en" {
+				t.Errorf("Update with preserve didn't set lang, got %v", val)
+			}
+			scope.Update(map[string]interface{}{"title": "Replaced"}, false)
+			if val, _ := scope.Get("title"); val != "Replaced" {
+				t.Errorf("Update without preserve left title as %v", val)
+			}
+			if val, _ := ctx.Get("title"); val != "Home" {
+				t.Errorf("Update changed the outer scope's title to %v", val)
+			}
+		})
+	}
+}
+
+// TestContextCloneDepth tests that clones copy the variable map but share
+// the values in it
+func TestContextCloneDepth(t *testing.T) {
+	for _, impl := range []struct {
+		name string
+		new  func() Context
+	}{
+		{"context", NewContext},
+		{"cowContext", NewCOWContext},
+	} {
+		t.Run(impl.name, func(t *testing.T) {
+			ctx := impl.new()
+			ctx.Set("user", map[string]interface{}{"name": "Ada"})
+			ctx.Set("title", "Home")
+
+			clone := ctx.Clone()
+			clone.Delete("title")
+			clone.Set("page", 1)
+			if !ctx.Has("title") || ctx.Has("page") {
+				t.Error("Delete or Set on a clone changed the original")
+			}
+
+			user, _ := clone.Get("user")
+			user.(map[string]interface{})["name"] = "Grace"
+			original, _ := ctx.Get("user")
+			if name := original.(map[string]interface{})["name"]; name != "Grace" {
+				t.Errorf("nested map isn't shared: original sees %v", name)
+			}
+		})
+	}
+}
+
 // TestContextAll tests All method
 func TestContextAll(t *testing.T) {
 	env := NewEnvironment()
@@ -7,6 +7,8 @@ package miya
 // and only copy when modifications occur.
 
 import (
+	"maps"
+	"slices"
 	"sync"
 )
 
@@ -78,7 +80,7 @@ func (c *cowContext) Get(key string) (interface{}, bool) {
 
 	// Check environment globals
 	if c.env != nil {
-		if val, ok := c.env.globals[key]; ok {
+		if val, ok := c.env.lookupGlobal(key); ok {
 			return val, true
 		}
 	}
@@ -100,6 +102,67 @@ func (c *cowContext) Set(key string, value interface{}) {
 	c.local[key] = value
 }
 
+// Has reports whether Get would find key
+func (c *cowContext) Has(key string) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+// Delete removes a variable from this context. The shared data is copied
+// first, as for Set.
+func (c *cowContext) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.shared[key]; ok {
+		shared := make(map[string]interface{}, len(c.shared)+len(c.local))
+		for k, v := range c.shared {
+			shared[k] = v
+		}
+		for k, v := range c.local {
+			shared[k] = v
+		}
+		c.shared = shared
+		c.local = nil
+		c.isShared = false
+		delete(c.shared, key)
+		return
+	}
+	delete(c.local, key)
+}
+
+// Update sets the variables in values, skipping those Has reports if
+// preserve is set
+func (c *cowContext) Update(values map[string]interface{}, preserve bool) {
+	for k, v := range values {
+		if preserve && c.Has(k) {
+			continue
+		}
+		c.Set(k, v)
+	}
+}
+
+// Keys returns the sorted names of the variables in this context and its
+// parents
+func (c *cowContext) Keys() []string {
+	c.mu.RLock()
+	keys := make(map[string]struct{}, len(c.shared)+len(c.local))
+	for k := range c.shared {
+		keys[k] = struct{}{}
+	}
+	for k := range c.local {
+		keys[k] = struct{}{}
+	}
+	c.mu.RUnlock()
+
+	if c.parent != nil {
+		for _, k := range c.parent.Keys() {
+			keys[k] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
+
 // Clone creates a copy-on-write clone
 func (c *cowContext) Clone() Context {
 	c.mu.RLock()
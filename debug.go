@@ -5,6 +5,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/zipreport/miya/parser"
 )
 
 // DebugLevel represents the level of debug information
@@ -453,3 +455,35 @@ func (id *InteractiveDebugger) ShouldBreak(templateName string, line int) bool {
 
 	return false
 }
+
+// DebugTokens returns the tokens the lexer produces for source, one per line
+// with its position, type and value, after the source preprocessor and
+// whitespace control apply as they do when compiling it. Values are quoted
+// and long ones shortened, so the result is safe to log.
+func (e *Environment) DebugTokens(source string) (string, error) {
+	if e.templateParent != nil {
+		return e.templateParent.DebugTokens(source)
+	}
+	_, _, tokens, err := e.tokenize(stringTemplateName, source)
+	if err != nil {
+		return "", err
+	}
+	return parser.DumpTokens(tokens), nil
+}
+
+// DebugAST returns the AST the template renders as an indented tree, with
+// the type and position of each node and its key fields, such as filter and
+// variable names and literal values, shortened when long. When the template
+// extends another, the tree is the one with the parent's blocks filled in.
+// Templates whose parent is chosen by a variable fail to resolve, since
+// there are no variables to choose it with.
+func (t *Template) DebugAST() (string, error) {
+	if t.ast == nil {
+		return "", nil
+	}
+	ast, _, err := t.resolveInheritance(newContextWithEnv(t.env))
+	if err != nil {
+		return "", err
+	}
+	return parser.Dump(ast), nil
+}
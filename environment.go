@@ -1,11 +1,18 @@
 package miya
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"log"
+	"reflect"
+	goruntime "runtime"
 	"strings"
 	"sync"
 	"time"
+	"weak"
 
 	"github.com/zipreport/miya/branching"
 	"github.com/zipreport/miya/extensions"
@@ -21,6 +28,8 @@ import (
 
 type Environment struct {
 	loader              Loader
+	watchedLoader       Loader // The loader notifying the environment of changes, if any
+	unwatchLoader       func() // Ends the subscription to watchedLoader
 	filterRegistry      *filters.FilterRegistry
 	inheritanceResolver *inheritance.InheritanceResolver
 	macroRegistry       *macros.MacroRegistry
@@ -32,6 +41,13 @@ type Environment struct {
 	cache               map[string]*Template
 	cacheMutex          sync.RWMutex
 
+	// Supplies templates the loader doesn't have (see
+	// SetTemplateNotFoundHandler); guarded by cacheMutex
+	notFound *notFoundHandler
+
+	// Templates compiled by FromFile, by absolute path; guarded by cacheMutex
+	files map[string]fileTemplate
+
 	// New inheritance caching system
 	inheritanceCache      *runtime.InheritanceCache
 	inheritanceProcessor  *runtime.InheritanceProcessor
@@ -42,12 +58,75 @@ type Environment struct {
 	importSystem  *runtime.ImportSystem
 
 	autoEscape          bool
+	autoEscapeSelector  AutoescapeSelector // overrides autoEscape when set
 	trimBlocks          bool
 	lstripBlocks        bool
 	keepTrailingNewline bool
 	undefinedBehavior   runtime.UndefinedBehavior
+	undefinedFactory    runtime.UndefinedFactory
 	extensionConfig     map[string]interface{} // Extension-specific configuration
 
+	nodeTransformers   []NodeTransformer
+	sourcePreprocessor SourcePreprocessor
+	transformersMutex  sync.RWMutex
+
+	// Variable holding the template's preprocessor metadata; "" for none
+	metadataVariable string
+
+	// Expose the read-only _template object during renders
+	templateIntrospection bool
+
+	// Register the switch and unless tags (see WithExtraTags)
+	extraTags bool
+
+	// Time returned by now(); zero means the current time
+	fixedNow time.Time
+
+	finalizer Finalizer
+
+	// Processors of the output of blocks, by block name, and of templates
+	// (see AddBlockPostProcessor and AddTemplatePostProcessor)
+	blockPostProcessors    map[string][]PostProcessor
+	templatePostProcessors []templatePostProcessor
+
+	maxRecursionDepth int // 0 for runtime.DefaultMaxRecursionDepth
+	maxRenderMemory   int // 0 for no limit
+
+	// Collapse the whitespace of template text in HTML renders (see
+	// WithHTMLMinifyWhitespace)
+	htmlMinifyWhitespace bool
+
+	// Fall back to matching Go map keys ignoring case (see
+	// WithCaseInsensitiveMapKeys)
+	caseInsensitiveMapKeys bool
+
+	// Reject render contexts defining reserved names (see
+	// WithReservedNameProtection)
+	reservedNameProtection bool
+
+	// Types whose methods templates may call; empty allows any type
+	safeTypes map[reflect.Type]bool
+
+	// Sandbox settings (see NewSandboxedEnvironment)
+	sandboxed     bool
+	sandboxLimits SandboxLimits
+	unsafeFilters map[string]bool
+	unsafeGlobals map[string]bool
+
+	childContentPolicy ChildContentPolicy
+	warningHandler     WarningHandler
+
+	tracer        Tracer
+	fragmentCache FragmentCache
+
+	// Overlay support: parent supplies fallback filters, tests and globals;
+	// templateParent (nil if the overlay parses for itself) supplies parsed
+	// templates. overlays lets invalidation reach child environments.
+	parent         *Environment
+	templateParent *Environment
+	overlays       []weak.Pointer[Environment]
+	overlaysMutex  sync.Mutex
+
 	varStartString     string
 	varEndString       string
 	blockStartString   string
@@ -100,6 +179,7 @@ func NewEnvironment(opts ...EnvironmentOption) *Environment {
 		New: func() interface{} {
 			eval := runtime.NewEvaluator()
 			eval.SetUndefinedBehavior(env.undefinedBehavior)
+			eval.SetUndefinedFactory(env.undefinedFactory)
 			return eval
 		},
 	}
@@ -118,6 +198,7 @@ func NewEnvironment(opts ...EnvironmentOption) *Environment {
 	// Create inheritance resolver if we have a loader
 	if env.loader != nil {
 		env.inheritanceResolver = inheritance.NewInheritanceResolver(&loaderAdapter{env.loader})
+		env.watchLoader(env.loader)
 	}
 
 	registerBuiltinTests(env)
@@ -126,12 +207,29 @@ func NewEnvironment(opts ...EnvironmentOption) *Environment {
 	// Set up extension registry with environment reference
 	env.extensionRegistry.SetEnvironment(env)
 
+	if env.extraTags {
+		for _, ext := range extensions.NewExtraTags() {
+			_ = env.extensionRegistry.Register(ext)
+		}
+	}
+
 	// Note: inheritanceProcessor will be initialized lazily to avoid import cycles
 
 	return env
 }
 
 func (e *Environment) GetTemplate(name string) (*Template, error) {
+	if e.templateParent != nil {
+		return e.overlayTemplate(name, e.templateParent.GetTemplate)
+	}
+
+	// Let the loader canonicalize the name so equivalent spellings share a cache entry
+	if advancedLoader, ok := e.loader.(loader.AdvancedLoader); ok {
+		if resolved := advancedLoader.ResolveTemplateName(name); resolved != "" {
+			name = resolved
+		}
+	}
+
 	e.cacheMutex.RLock()
 	if tmpl, ok := e.cache[name]; ok {
 		e.cacheMutex.RUnlock()
@@ -140,24 +238,63 @@ func (e *Environment) GetTemplate(name string) (*Template, error) {
 	e.cacheMutex.RUnlock()
 
 	if e.loader == nil {
+		if tmpl, handled, err := e.templateNotFound(name); handled {
+			return tmpl, err
+		}
 		return nil, fmt.Errorf("no loader configured for environment")
 	}
 
-	// Try to load from advanced loader first if available
-	if advancedLoader, ok := e.loader.(loader.AdvancedLoader); ok {
+	// Try to load from advanced loader first if available. Its parser doesn't
+	// know the source preprocessor, so with one set the source is parsed here.
+	if advancedLoader, ok := e.loader.(loader.AdvancedLoader); ok && e.preprocessor() == nil {
 		templateNode, err := advancedLoader.LoadTemplate(name)
 		if err != nil {
+			if isTemplateNotFound(err) {
+				if tmpl, handled, handlerErr := e.templateNotFound(name); handled {
+					return tmpl, handlerErr
+				}
+			}
 			return nil, fmt.Errorf("failed to load template %q: %w", name, err)
 		}
+		// The loader may cache and share its AST with other environments and
+		// goroutines, so the references are resolved in, and the
+		// transformers applied to, a copy
+		templateNode = parser.Clone(templateNode).(*parser.TemplateNode)
+		if len(e.transformers()) > 0 || runtime.HasConstantExpressions(templateNode) {
+			if templateNode.Name == "" {
+				templateNode.Name = name
+			}
+			if templateNode, err = e.applyNodeTransformers(name, templateNode); err != nil {
+				return nil, err
+			}
+		}
+		if err := resolveRelativeReferences(name, templateNode); err != nil {
+			return nil, fmt.Errorf("failed to load template %q: %w", name, err)
+		}
+		scopeFragmentCaches(name, templateNode)
 
 		// NOTE: Old inheritance resolution removed - now handled at render-time
 		// This allows templates to preserve their raw AST with ExtendsNode and SuperNode
 		// for processing by the new runtime inheritance system
 
 		tmpl := &Template{
-			name: name,
-			env:  e,
-			ast:  templateNode,
+			name:    name,
+			env:     e,
+			ast:     templateNode,
+			fast:    classifyTemplate(name, templateNode),
+			version: templateVersions.Add(1),
+		}
+		// The loader parsed the source, so read it again for error messages
+		// and the template's pragma
+		if source, filename, err := e.loadSource(name); err == nil {
+			tmpl.source, tmpl.filename = source, filename
+			tmpl.sourceHash = sha256.Sum256([]byte(source))
+			if tmpl.settings, err = e.templatePragma(source); err != nil {
+				return nil, fmt.Errorf("parser error in template %s: %w", name, err)
+			}
+		} else {
+			// Compiled loaders keep no source; the AST stands for it
+			tmpl.sourceHash = sha256.Sum256([]byte(parser.Print(templateNode)))
 		}
 
 		e.cacheMutex.Lock()
@@ -168,8 +305,13 @@ func (e *Environment) GetTemplate(name string) (*Template, error) {
 	}
 
 	// Fallback to basic loader
-	source, err := e.loader.GetSource(name)
+	source, filename, err := e.loadSource(name)
 	if err != nil {
+		if isTemplateNotFound(err) {
+			if tmpl, handled, handlerErr := e.templateNotFound(name); handled {
+				return tmpl, handlerErr
+			}
+		}
 		return nil, fmt.Errorf("failed to load template %q: %w", name, err)
 	}
 
@@ -177,6 +319,11 @@ func (e *Environment) GetTemplate(name string) (*Template, error) {
 	if err != nil {
 		return nil, err
 	}
+	tmpl.filename = filename
+	if err := resolveRelativeReferences(name, tmpl.ast); err != nil {
+		return nil, fmt.Errorf("failed to load template %q: %w", name, err)
+	}
+	scopeFragmentCaches(name, tmpl.ast)
 
 	e.cacheMutex.Lock()
 	e.cache[name] = tmpl
@@ -185,6 +332,60 @@ func (e *Environment) GetTemplate(name string) (*Template, error) {
 	return tmpl, nil
 }
 
+// loadSource reads the source of the template called name from the loader,
+// with the file it comes from when the loader is a SourceLoader
+func (e *Environment) loadSource(name string) (string, string, error) {
+	if sourceLoader, ok := e.loader.(loader.SourceLoader); ok {
+		return sourceLoader.GetSourceWithFilename(name)
+	}
+	source, err := e.loader.GetSource(name)
+	return source, "", err
+}
+
+// resolveRelativeReferences rewrites "./" and "../" names in extends, include,
+// embed, import and from statements into loader-root names, so a template
+// referenced relatively and absolutely shares one cache entry. Only string
+// literals are rewritten, in place, so ast must not be shared.
+func resolveRelativeReferences(name string, ast parser.Node) error {
+	var resolveErr error
+	parser.Walk(ast, func(node parser.Node) bool {
+		if resolveErr != nil {
+			return false
+		}
+
+		var ref parser.ExpressionNode
+		switch n := node.(type) {
+		case *parser.ExtendsNode:
+			ref = n.Template
+		case *parser.IncludeNode:
+			ref = n.Template
+		case *parser.EmbedNode:
+			ref = n.Template
+		case *parser.ImportNode:
+			ref = n.Template
+		case *parser.FromNode:
+			ref = n.Template
+		default:
+			return true
+		}
+
+		literal, ok := ref.(*parser.LiteralNode)
+		if !ok {
+			return true
+		}
+		if refName, ok := literal.Value.(string); ok && loader.IsRelativeName(refName) {
+			resolved, err := loader.ResolveRelativeName(name, refName)
+			if err != nil {
+				resolveErr = fmt.Errorf("line %d: %w", node.Line(), err)
+				return false
+			}
+			literal.Value = resolved
+		}
+		return true
+	})
+	return resolveErr
+}
+
 // hasInlineWhitespaceControl checks if template contains inline whitespace control syntax
 func (e *Environment) hasInlineWhitespaceControl(source string) bool {
 	// Fast path: if no '-' exists, no whitespace control syntax is present
@@ -200,9 +401,44 @@ func (e *Environment) hasInlineWhitespaceControl(source string) bool {
 		strings.Contains(source, "-#}")
 }
 
+// stringTemplateName is the name of templates created with FromString
+const stringTemplateName = "<string>"
+
 func (e *Environment) FromString(source string) (*Template, error) {
+	return e.fromString(stringTemplateName, source)
+}
+
+// FromStringNamed compiles source as a template called name. The name
+// appears in errors and selects autoescaping like a loaded template's, so a
+// template named "welcome.txt" isn't escaped.
+func (e *Environment) FromStringNamed(name, source string) (*Template, error) {
+	return e.fromString(name, source)
+}
+
+func (e *Environment) fromString(name, source string) (*Template, error) {
+	return e.compileString(source, templateOptions{name: name})
+}
+
+// compileString compiles source as a template with options, caching it by
+// its name, settings and source
+func (e *Environment) compileString(source string, options templateOptions) (*Template, error) {
+	name := options.name
+
 	// Generate cache key from content hash (Phase 2 optimization)
-	cacheKey := hashString(source)
+	key := source
+	if name != stringTemplateName {
+		key = name + "\x00" + source
+	}
+	if settings := options.renderOptions(); len(settings) > 0 {
+		key = fmt.Sprintf("%t\x00%s\x00%s", options.autoEscape, options.escapeContext, key)
+	}
+	cacheKey := hashString(key)
+
+	if e.templateParent != nil {
+		return e.overlayTemplate(cacheKey, func(string) (*Template, error) {
+			return e.templateParent.compileString(source, options)
+		})
+	}
 
 	// Check cache first
 	e.cacheMutex.RLock()
@@ -212,11 +448,12 @@ func (e *Environment) FromString(source string) (*Template, error) {
 	}
 	e.cacheMutex.RUnlock()
 
-	// Compile if not cached (use "<string>" as display name)
-	tmpl, err := e.compile("<string>", source)
+	tmpl, err := e.compile(name, source)
 	if err != nil {
 		return nil, err
 	}
+	tmpl.settings = append(tmpl.settings, options.renderOptions()...)
+	scopeFragmentCaches(cacheKey, tmpl.ast)
 
 	// Store in cache with hash key
 	e.cacheMutex.Lock()
@@ -234,6 +471,35 @@ func (e *Environment) SetLoader(loader Loader) {
 	} else {
 		e.inheritanceResolver = nil
 	}
+	e.watchLoader(loader)
+}
+
+// watchLoader subscribes to change notifications from loaders that support them,
+// so updated templates are dropped from the environment caches. The
+// subscription to the previous loader ends, and watching the same loader
+// again changes nothing. The loader holds the environment weakly: an
+// environment nothing else references is collected, ending its subscription.
+func (e *Environment) watchLoader(l Loader) {
+	if l == e.watchedLoader {
+		return
+	}
+	if e.unwatchLoader != nil {
+		e.unwatchLoader()
+	}
+	e.watchedLoader, e.unwatchLoader = nil, nil
+
+	notifier, ok := l.(loader.Notifier)
+	if !ok {
+		return
+	}
+	env := weak.Make(e)
+	unsubscribe := notifier.Subscribe(func(name string) {
+		if watching := env.Value(); watching != nil {
+			watching.InvalidateTemplate(name)
+		}
+	})
+	e.watchedLoader, e.unwatchLoader = l, unsubscribe
+	goruntime.AddCleanup(e, func(unsubscribe func()) { unsubscribe() }, unsubscribe)
 }
 
 func (e *Environment) AddFilterLegacy(name string, filter FilterFunc) error {
@@ -248,16 +514,78 @@ func (e *Environment) GetFilter(name string) (FilterFunc, bool) {
 	return FilterFunc(f), true
 }
 
+// ApplyFilter applies the filter called name outside a render. Filters
+// naming tests or filters in their arguments, such as select("odd"), look
+// them up in e.
 func (e *Environment) ApplyFilter(name string, value interface{}, args ...interface{}) (interface{}, error) {
-	return e.filterRegistry.Apply(name, value, args...)
+	return e.filterRegistry.ApplyWithContext(&TemplateContextAdapter{ctx: newContextWithEnv(e), env: e}, name, value, args, nil)
 }
 
 func (e *Environment) AddGlobal(name string, value interface{}) {
 	e.globals[name] = value
 }
 
+// RegisterSafeType allows templates to call the methods of values of type t,
+// or pointers to it. Once a type is registered, calling a method of any type
+// that isn't fails the render with an AccessError; fields stay readable and
+// Go functions passed in as values stay callable. Without registered types,
+// the methods of any type may be called. Overlays allow their parents' safe
+// types as well as their own. Register types before rendering.
+func (e *Environment) RegisterSafeType(t reflect.Type) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if e.safeTypes == nil {
+		e.safeTypes = make(map[reflect.Type]bool)
+	}
+	e.safeTypes[t] = true
+}
+
+// safeTypeCheck returns the check for the evaluator's SetSafeTypes, nil when
+// no environment up the overlay chain restricts methods
+func (e *Environment) safeTypeCheck() func(reflect.Type) bool {
+	for env := e; env != nil; env = env.parent {
+		if len(env.safeTypes) > 0 {
+			return e.isSafeType
+		}
+	}
+	return nil
+}
+
+func (e *Environment) isSafeType(t reflect.Type) bool {
+	for env := e; env != nil; env = env.parent {
+		if env.safeTypes[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupGlobal finds a global on this environment or, for overlays, its parents
+func (e *Environment) lookupGlobal(name string) (interface{}, bool) {
+	for env := e; env != nil; env = env.parent {
+		if val, ok := env.globals[name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// collectGlobals copies all visible globals into dst, nearest environment winning
+func (e *Environment) collectGlobals(dst map[string]interface{}) {
+	if e.parent != nil {
+		e.parent.collectGlobals(dst)
+	}
+	for k, v := range e.globals {
+		dst[k] = v
+	}
+}
+
 // AddExtension registers an extension with the environment
 func (e *Environment) AddExtension(extension extensions.Extension) error {
+	if e.parent != nil {
+		return fmt.Errorf("extensions change how templates parse and must be added to the root environment, not an overlay")
+	}
 	return e.extensionRegistry.Register(extension)
 }
 
@@ -284,22 +612,78 @@ func (e *Environment) GetExtension(name string) (extensions.Extension, bool) {
 
 // AddFilter adds a custom filter (for extensions and legacy support)
 func (e *Environment) AddFilter(name string, filter interface{}) error {
+	filterFunc, err := toFilterFunc(filter)
+	if err != nil {
+		return err
+	}
+	return e.filterRegistry.Register(name, filterFunc)
+}
+
+// AddSafeFilter adds a filter that returns HTML, such as a markdown renderer.
+// Its output is marked safe when autoescaping is enabled for the surrounding
+// template or {% autoescape %} block, so it is not escaped again; with
+// autoescaping off it behaves like a filter added with AddFilter. The filter
+// is responsible for escaping any untrusted input it embeds.
+func (e *Environment) AddSafeFilter(name string, filter interface{}) error {
+	filterFunc, err := toFilterFunc(filter)
+	if err != nil {
+		return err
+	}
+	return e.filterRegistry.RegisterSafe(name, filterFunc)
+}
+
+// AddFilterKW adds a filter that receives keyword arguments, so
+// {{ x|pad(width=3) }} calls filter with kwargs {"width": 3}. Filters added
+// with AddFilter are never passed keyword arguments.
+func (e *Environment) AddFilterKW(name string, filter KeywordFilterFunc) error {
+	return e.filterRegistry.RegisterKeyword(name, filters.KeywordFilterFunc(filter))
+}
+
+// AddContextFilter adds a filter that receives keyword arguments and a
+// FilterContext for reading variables of the render, e.g. a translation
+// filter that needs the current locale.
+func (e *Environment) AddContextFilter(name string, filter ContextFilterFunc) error {
+	return e.filterRegistry.RegisterContext(name, func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return filter(filterContext{ctx: ctx, env: e}, value, args, kwargs)
+	})
+}
+
+// filterContext implements FilterContext over the runtime context a filter
+// is applied in
+type filterContext struct {
+	ctx runtime.Context
+	env *Environment
+}
+
+func (c filterContext) GetVariable(name string) (interface{}, bool) {
+	if c.ctx == nil {
+		return nil, false
+	}
+	return c.ctx.GetVariable(name)
+}
+
+func (c filterContext) Environment() *Environment {
+	return c.env
+}
+
+// toFilterFunc converts the filter function types accepted by AddFilter
+func toFilterFunc(filter interface{}) (filters.FilterFunc, error) {
 	// Handle legacy FilterFunc type
 	if filterFunc, ok := filter.(FilterFunc); ok {
-		return e.filterRegistry.Register(name, filters.FilterFunc(filterFunc))
+		return filters.FilterFunc(filterFunc), nil
 	}
 
 	// Handle filters.FilterFunc type
 	if filterFunc, ok := filter.(filters.FilterFunc); ok {
-		return e.filterRegistry.Register(name, filterFunc)
+		return filterFunc, nil
 	}
 
 	// For other types, try to convert to filters.FilterFunc
 	if filterFunc, ok := filter.(func(interface{}, ...interface{}) (interface{}, error)); ok {
-		return e.filterRegistry.Register(name, filters.FilterFunc(filterFunc))
+		return filters.FilterFunc(filterFunc), nil
 	}
 
-	return fmt.Errorf("unsupported filter type: %T", filter)
+	return nil, fmt.Errorf("unsupported filter type: %T", filter)
 }
 
 // AddTest adds a custom test (for extensions and legacy support)
@@ -337,6 +721,18 @@ func (e *Environment) AddTest(name string, test interface{}) error {
 	return fmt.Errorf("unsupported test type: %T", test)
 }
 
+// AddFilterAlias makes alias an alternative name for the target filter, e.g.
+// to keep templates written for another engine working. An existing filter
+// named alias is replaced. Errors from the target mention both names.
+func (e *Environment) AddFilterAlias(alias, target string) error {
+	return e.filterRegistry.RegisterAlias(alias, target)
+}
+
+// AddTestAlias makes alias an alternative name for the target test
+func (e *Environment) AddTestAlias(alias, target string) error {
+	return e.testRegistry.RegisterAlias(alias, target)
+}
+
 // GetConfig gets environment-level configuration for extensions
 func (e *Environment) GetConfig(key string) (interface{}, bool) {
 	value, exists := e.extensionConfig[key]
@@ -404,11 +800,17 @@ func (e *Environment) CallMacro(name string, ctx Context, args []interface{}, kw
 
 	// Create evaluator
 	evaluator := runtime.NewEvaluator()
-
-	// Set up import system for the evaluator
-	loader := runtime.NewSimpleTemplateLoader(e)
-	importSystem := runtime.NewImportSystem(loader, evaluator)
-	evaluator.SetImportSystem(importSystem)
+	evaluator.SetUndefinedBehavior(e.undefinedBehavior)
+	evaluator.SetUndefinedFactory(e.undefinedFactory)
+	evaluator.SetFinalizer(e.finalizer)
+	evaluator.SetMaxRecursionDepth(e.recursionLimit())
+	evaluator.SetMaxRenderMemory(e.maxRenderMemory)
+	evaluator.SetSafeTypes(e.safeTypeCheck())
+	evaluator.SetCaseInsensitiveMapKeys(e.caseInsensitiveMapKeys)
+	evaluator.SetSandbox(e.runtimeSandbox())
+
+	// Share the environment's import system and its cached namespaces
+	evaluator.SetImportSystem(e.importSystem)
 
 	return e.macroRegistry.CallMacro(name, runtimeCtx, evaluator, args, kwargs)
 }
@@ -472,30 +874,14 @@ func (e *Environment) registerMacrosFromAST(node parser.Node, templateName strin
 }
 
 func (e *Environment) compile(name, source string) (*Template, error) {
-	// Apply whitespace preprocessing if whitespace control is enabled
-	preprocessedSource := source
-	if e.trimBlocks || e.lstripBlocks || e.hasInlineWhitespaceControl(source) {
-		processor := whitespace.NewAdvancedWhitespaceProcessor(e.trimBlocks, e.lstripBlocks, e.keepTrailingNewline)
-		preprocessedSource = processor.ProcessTemplate(source)
-	}
-
-	// Create lexer configuration from environment settings
-	lexerConfig := &lexer.LexerConfig{
-		VarStartString:     e.varStartString,
-		VarEndString:       e.varEndString,
-		BlockStartString:   e.blockStartString,
-		BlockEndString:     e.blockEndString,
-		CommentStartString: e.commentStartString,
-		CommentEndString:   e.commentEndString,
-		TrimBlocks:         e.trimBlocks,
-		LstripBlocks:       e.lstripBlocks,
+	sourceHash := sha256.Sum256([]byte(source))
+	source, metadata, tokens, err := e.tokenize(name, source)
+	if err != nil {
+		return nil, err
 	}
-
-	// Tokenize the preprocessed source
-	l := lexer.NewLexer(preprocessedSource, lexerConfig)
-	tokens, err := l.Tokenize()
+	settings, err := e.templatePragma(source)
 	if err != nil {
-		return nil, fmt.Errorf("lexer error in template %s: %v", name, err)
+		return nil, fmt.Errorf("parser error in template %s: %w", name, err)
 	}
 
 	// Parse the tokens into an AST
@@ -522,6 +908,11 @@ func (e *Environment) compile(name, source string) (*Template, error) {
 	// Set the template name in the AST
 	ast.Name = name
 
+	ast, err = e.applyNodeTransformers(name, ast)
+	if err != nil {
+		return nil, err
+	}
+
 	// Register any macros found in the template
 	e.registerMacrosFromAST(ast, name)
 
@@ -530,13 +921,86 @@ func (e *Environment) compile(name, source string) (*Template, error) {
 	// template hierarchy loading without compilation-time circular references
 
 	return &Template{
-		name:   name,
-		source: source,
-		env:    e,
-		ast:    ast,
+		name:       name,
+		source:     source,
+		env:        e,
+		ast:        ast,
+		metadata:   metadata,
+		settings:   settings,
+		fast:       classifyTemplate(name, ast),
+		sourceHash: sourceHash,
+		version:    templateVersions.Add(1),
 	}, nil
 }
 
+// ParseAll parses source as compiling a template called name does, but
+// reports every syntax error instead of only the first: after an error the
+// parser skips to the next tag boundary and carries on (see
+// parser.Parser.ParseAll). The errors are in source order and unwrap to a
+// *parser.SyntaxError giving the position. The AST holds what parsed, for
+// tooling; it is not meant to be rendered when there are errors. An error
+// of the preprocessor or lexer stops parsing and is returned alone, with a
+// nil AST.
+func (e *Environment) ParseAll(name, source string) (*parser.TemplateNode, []error) {
+	_, _, tokens, err := e.tokenize(name, source)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	p := parser.NewParser(tokens)
+	if len(e.extensionRegistry.GetAllExtensions()) > 0 {
+		// Extension tags are parsed through the tag handler at any depth
+		p = extensions.NewExtensionAwareParser(tokens, e.extensionRegistry).Parser
+	}
+	ast, errs := p.ParseAll()
+	ast.Name = name
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("parser error in template %s: %w", name, err)
+	}
+	return ast, errs
+}
+
+// tokenize runs the source preprocessor, whitespace control and the lexer
+// over source, as compiling it does. It returns the source as preprocessed,
+// which templates keep, with the preprocessor's metadata and the tokens.
+func (e *Environment) tokenize(name, source string) (string, map[string]interface{}, []*lexer.Token, error) {
+	var metadata map[string]interface{}
+	if preprocess := e.preprocessor(); preprocess != nil {
+		var err error
+		if source, metadata, err = preprocess(name, source); err != nil {
+			return "", nil, nil, fmt.Errorf("parser error in template %s: %w", name, err)
+		}
+	}
+
+	// Apply whitespace preprocessing if whitespace control is enabled
+	preprocessedSource := source
+	if e.trimBlocks || e.lstripBlocks || e.hasInlineWhitespaceControl(source) {
+		processor := whitespace.NewAdvancedWhitespaceProcessor(e.trimBlocks, e.lstripBlocks, e.keepTrailingNewline)
+		preprocessedSource = processor.ProcessTemplate(source)
+	}
+
+	// Create lexer configuration from environment settings
+	lexerConfig := &lexer.LexerConfig{
+		VarStartString:     e.varStartString,
+		VarEndString:       e.varEndString,
+		BlockStartString:   e.blockStartString,
+		BlockEndString:     e.blockEndString,
+		CommentStartString: e.commentStartString,
+		CommentEndString:   e.commentEndString,
+		TrimBlocks:         e.trimBlocks,
+		LstripBlocks:       e.lstripBlocks,
+	}
+
+	// Tokenize the preprocessed source
+	l := lexer.NewLexer(preprocessedSource, lexerConfig)
+	tokens, err := l.Tokenize()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("lexer error in template %s: %v", name, err)
+	}
+
+	return source, metadata, tokens, nil
+}
+
 func WithLoader(loader Loader) EnvironmentOption {
 	return func(e *Environment) {
 		e.loader = loader
@@ -547,10 +1011,77 @@ func WithLoader(loader Loader) EnvironmentOption {
 	}
 }
 
-func WithAutoEscape(enabled bool) EnvironmentOption {
+// WithAutoEscape turns HTML escaping of {{ expression }} output on or off for
+// all templates, or, given an AutoescapeSelector such as SelectAutoescape
+// returns, per template name. Templates named .txt or .text are not escaped
+// either way.
+func WithAutoEscape[T bool | AutoescapeSelector | func(templateName string) bool](setting T) EnvironmentOption {
 	return func(e *Environment) {
-		e.autoEscape = enabled
+		switch s := any(setting).(type) {
+		case bool:
+			e.autoEscape = s
+			e.autoEscapeSelector = nil
+		case AutoescapeSelector:
+			e.autoEscape = true
+			e.autoEscapeSelector = s
+		case func(string) bool:
+			e.autoEscape = true
+			e.autoEscapeSelector = s
+		}
+	}
+}
+
+// SelectAutoescape returns an AutoescapeSelector like Jinja2's
+// select_autoescape: templates whose names end in one of enabledExtensions
+// are escaped, those ending in one of disabledExtensions are not, and others
+// follow defaultValue. Templates created with FromString follow
+// defaultForString. Extensions are matched case-insensitively, with or without
+// a leading dot.
+func SelectAutoescape(enabledExtensions, disabledExtensions []string, defaultForString, defaultValue bool) AutoescapeSelector {
+	suffixes := func(extensions []string) []string {
+		result := make([]string, len(extensions))
+		for i, ext := range extensions {
+			result[i] = "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+		}
+		return result
+	}
+	enabled, disabled := suffixes(enabledExtensions), suffixes(disabledExtensions)
+
+	return func(templateName string) bool {
+		if templateName == "" {
+			return defaultForString
+		}
+		name := strings.ToLower(templateName)
+		for _, suffix := range enabled {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		}
+		for _, suffix := range disabled {
+			if strings.HasSuffix(name, suffix) {
+				return false
+			}
+		}
+		return defaultValue
+	}
+}
+
+// textTemplateDetector finds plain-text templates by their file extension
+var textTemplateDetector = runtime.NewAutoEscaper(nil)
+
+// autoescapeFor reports whether output of the template called name, read
+// from filename, is escaped. name is "" for templates created from strings
+// without a name, and filename "" when the loader doesn't report one.
+// Templates whose name or file has a plain-text extension aren't escaped.
+func (e *Environment) autoescapeFor(name, filename string) bool {
+	enabled := e.autoEscape
+	if e.autoEscapeSelector != nil {
+		enabled = e.autoEscapeSelector(name)
 	}
+	if filename != "" && textTemplateDetector.DetectContext(filename) == runtime.EscapeContextText {
+		return false
+	}
+	return enabled && (name == "" || textTemplateDetector.DetectContext(name) != runtime.EscapeContextText)
 }
 
 func WithTrimBlocks(enabled bool) EnvironmentOption {
@@ -593,20 +1124,189 @@ func WithDebugUndefined(enabled bool) EnvironmentOption {
 	}
 }
 
-// WithUndefinedBehavior sets the undefined variable behavior
-func WithUndefinedBehavior(behavior runtime.UndefinedBehavior) EnvironmentOption {
+// WithUndefinedBehavior sets how undefined variables are handled:
+//
+//	UndefinedSilent     {{ user.name }} renders "" for a missing user (default)
+//	UndefinedStrict     any use of a missing variable fails the render
+//	UndefinedDebug      renders "{{ undefined variable: user.name (...) }}" markers
+//	UndefinedChainFail  {{ user }} renders "", but {{ user.name }} fails
+//
+// In every mode `default` still substitutes its fallback for an undefined
+// operand and `is defined` still works.
+func WithUndefinedBehavior(behavior UndefinedBehavior) EnvironmentOption {
 	return func(e *Environment) {
 		e.undefinedBehavior = behavior
 	}
 }
 
+// WithUndefinedFactory installs a factory for the values of undefined
+// variables, e.g. to record which names a template is missing. The Behavior
+// of each returned Undefined decides how it renders and whether attribute
+// access or calls on it fail; returning nil falls back to the configured
+// behavior.
+func WithUndefinedFactory(factory UndefinedFactory) EnvironmentOption {
+	return func(e *Environment) {
+		e.undefinedFactory = factory
+	}
+}
+
+// WithFilterAliases registers filter aliases, mapping alias to target name.
+// Entries that AddFilterAlias would reject (empty or self-referencing) are skipped.
+func WithFilterAliases(aliases map[string]string) EnvironmentOption {
+	return func(e *Environment) {
+		for alias, target := range aliases {
+			_ = e.AddFilterAlias(alias, target)
+		}
+	}
+}
+
+// WithTestAliases registers test aliases, mapping alias to target name
+func WithTestAliases(aliases map[string]string) EnvironmentOption {
+	return func(e *Environment) {
+		for alias, target := range aliases {
+			_ = e.AddTestAlias(alias, target)
+		}
+	}
+}
+
+// WithTemplateIntrospection exposes a read-only _template object during renders with
+// the current template name, innermost block, extends chain and include stack.
+// Disabled by default to avoid the bookkeeping overhead.
+func WithTemplateIntrospection(enabled bool) EnvironmentOption {
+	return func(e *Environment) {
+		e.templateIntrospection = enabled
+	}
+}
+
+// WithExtraTags enables the tags miya adds to Jinja2's: switch, which renders
+// the first case equal to a value, and unless, an if with the condition
+// negated:
+//
+//	{% switch order.status %}{% case "shipped" %}...{% case "pending", "processing" %}...{% default %}...{% endswitch %}
+//	{% unless user.verified %}...{% else %}...{% endunless %}
+//
+// They are extensions, also available to AddExtension from
+// extensions.NewExtraTags. Like other extensions they can't be enabled for
+// an overlay alone, which ignores this option.
+func WithExtraTags(enabled bool) EnvironmentOption {
+	return func(e *Environment) {
+		e.extraTags = enabled
+	}
+}
+
+// WithFinalizer sets a function applied to the value of every {{ expression }}
+// before it is escaped and written, like Jinja2's finalize. It sees output
+// values in loops, macros, includes and filter blocks alike, but not the
+// intermediate values of an expression or undefined variables, which follow
+// the undefined behavior. Returning "" for nil hides none values.
+func WithFinalizer(finalizer Finalizer) EnvironmentOption {
+	return func(e *Environment) {
+		e.finalizer = finalizer
+	}
+}
+
+// WithMaxRecursionDepth sets how deeply macro calls, includes and recursive
+// loops may nest before the render fails with a runtime error naming the
+// cycle, instead of exhausting the stack. The default is 500; 0 or less
+// restores it.
+func WithMaxRecursionDepth(depth int) EnvironmentOption {
+	return func(e *Environment) {
+		e.maxRecursionDepth = depth
+	}
+}
+
+// WithMaxRenderMemory bounds the bytes of the strings and sequences a render
+// may create with operators such as * and ~, range() and filters such as
+// join, batch and center. A render going past it fails with a MemoryError
+// naming the operation and its position. The default, 0, means no limit.
+func WithMaxRenderMemory(bytes int) EnvironmentOption {
+	return func(e *Environment) {
+		e.maxRenderMemory = bytes
+	}
+}
+
+// WithHTMLMinifyWhitespace collapses the whitespace between tags that
+// template text adds to HTML output, instead of writing {%- -%} everywhere.
+// Runs of whitespace in the text of templates rendered with HTML
+// autoescaping become one space, or nothing next to block-level tags such
+// as <div>, <li> or </p>. The content of pre, textarea, script and style
+// elements, comments, attribute values and the output of {{ expressions }}
+// keep their whitespace.
+func WithHTMLMinifyWhitespace(enabled bool) EnvironmentOption {
+	return func(e *Environment) {
+		e.htmlMinifyWhitespace = enabled
+	}
+}
+
+// WithCaseInsensitiveMapKeys makes {{ m.key }} and {{ m["key"] }} on a Go
+// map fall back to the string key equal to key ignoring case, so data
+// decoded from JSON or YAML with keys such as "Content-Type" is found as
+// headers["content-type"]. An exact key is always preferred, and when keys
+// differ only by case the least of them is used. The fallback scans every
+// key of the map, so a missing key costs time in proportion to the map's
+// size. Dicts written in templates and the attribute arguments of filters
+// such as map and selectattr still match keys exactly.
+func WithCaseInsensitiveMapKeys(enabled bool) EnvironmentOption {
+	return func(e *Environment) {
+		e.caseInsensitiveMapKeys = enabled
+	}
+}
+
+// WithFixedNow makes the now() global return t instead of the current time,
+// so templates that print or compare against now() render reproducibly.
+func WithFixedNow(t time.Time) EnvironmentOption {
+	return func(e *Environment) {
+		e.fixedNow = t
+	}
+}
+
+// WithChildContentPolicy sets what happens to output a child template places
+// outside its blocks, which inheritance never renders. ChildContentIgnore, the
+// default, discards it like Jinja2; ChildContentWarn reports it to the warning
+// handler; ChildContentError fails with a *runtime.OrphanedContentError giving
+// its position. Whitespace, comments and statements such as set, import and
+// macro are not content. Templates are checked when their inheritance chain is
+// first resolved, by CompileAll or the first render.
+func WithChildContentPolicy(policy ChildContentPolicy) EnvironmentOption {
+	return func(e *Environment) {
+		e.childContentPolicy = policy
+	}
+}
+
+// WithWarningHandler sets the function that receives template warnings, such
+// as those of ChildContentWarn. Without one, warnings are written to the
+// standard logger.
+func WithWarningHandler(handler WarningHandler) EnvironmentOption {
+	return func(e *Environment) {
+		e.warningHandler = handler
+	}
+}
+
+// warn passes a template warning to the warning handler
+func (e *Environment) warn(warning TemplateWarning) {
+	if e.warningHandler != nil {
+		e.warningHandler(warning)
+		return
+	}
+	log.Printf("miya: warning: %s", warning)
+}
+
 // Additional Environment methods
 
 // ClearCache clears the template cache
 func (e *Environment) ClearCache() {
 	e.cacheMutex.Lock()
-	defer e.cacheMutex.Unlock()
 	e.cache = make(map[string]*Template)
+	e.files = nil
+	e.cacheMutex.Unlock()
+
+	if e.importSystem != nil {
+		e.importSystem.Clear()
+	}
+
+	for _, overlay := range e.liveOverlays() {
+		overlay.ClearCache()
+	}
 }
 
 // GetCacheSize returns the number of cached templates
@@ -636,6 +1336,15 @@ func (e *Environment) InvalidateTemplate(templateName string) {
 	e.inheritanceCacheMutex.Lock()
 	e.inheritanceCache.InvalidateTemplate(templateName)
 	e.inheritanceCacheMutex.Unlock()
+
+	// Remove cached import namespaces
+	if e.importSystem != nil {
+		e.importSystem.InvalidateTemplate(templateName)
+	}
+
+	for _, overlay := range e.liveOverlays() {
+		overlay.InvalidateTemplate(templateName)
+	}
 }
 
 // GetInheritanceCacheStats returns inheritance cache performance statistics
@@ -662,6 +1371,7 @@ func (e *Environment) getInheritanceProcessor() *runtime.InheritanceProcessor {
 	if e.inheritanceProcessor == nil {
 		// Initialize processor with shared cache (lazy initialization to avoid import cycles)
 		e.inheritanceProcessor = runtime.NewInheritanceProcessorWithCache(&environmentAdapter{env: e}, e.inheritanceCache)
+		e.inheritanceProcessor.SetChildContentPolicy(e.childContentPolicy, e.warn)
 	}
 
 	return e.inheritanceProcessor
@@ -675,6 +1385,55 @@ func (e *Environment) ListTemplates() ([]string, error) {
 	return e.loader.ListTemplates()
 }
 
+// CompileAll parses every template the loader lists and resolves static
+// inheritance chains up front, so first renders don't pay for parsing. It
+// keeps going after a failure and returns all errors joined together.
+func (e *Environment) CompileAll() error {
+	names, err := e.ListTemplates()
+	if err != nil {
+		return err
+	}
+
+	processor := e.getInheritanceProcessor()
+	var errs []error
+	for _, name := range names {
+		tmpl, err := e.GetTemplate(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := processor.PrepareHierarchy(&templateAdapter{template: tmpl}); err != nil {
+			errs = append(errs, fmt.Errorf("template %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// EncodeTemplates compiles every template the loader lists and writes them as
+// a bundle that loader.NewCompiledLoader can serve without parsing
+func (e *Environment) EncodeTemplates(w io.Writer) error {
+	if err := e.CompileAll(); err != nil {
+		return err
+	}
+
+	names, err := e.ListTemplates()
+	if err != nil {
+		return err
+	}
+
+	templates := make([]*parser.TemplateNode, 0, len(names))
+	for _, name := range names {
+		tmpl, err := e.GetTemplate(name)
+		if err != nil {
+			return err
+		}
+		templates = append(templates, tmpl.GetASTAsTemplateNode())
+	}
+
+	return parser.Encode(w, templates...)
+}
+
 // RenderString is a convenience method to compile and render a template from string
 func (e *Environment) RenderString(source string, context Context) (string, error) {
 	template, err := e.FromString(source)
@@ -693,33 +1452,6 @@ func (e *Environment) RenderTemplate(name string, context Context) (string, erro
 	return template.Render(context)
 }
 
-// applyBlockTrimming applies block trimming rules to output
-func (e *Environment) applyBlockTrimming(output string) string {
-	if !e.trimBlocks && !e.lstripBlocks {
-		return output
-	}
-
-	lines := strings.Split(output, "\n")
-	for i, line := range lines {
-		if e.lstripBlocks {
-			// Remove leading whitespace from lines that start with block tags
-			if strings.Contains(line, e.blockStartString) {
-				lines[i] = strings.TrimLeft(line, " \t")
-			}
-		}
-		if e.trimBlocks {
-			// Remove trailing newline after block tags
-			if strings.Contains(line, e.blockEndString) {
-				if i < len(lines)-1 && lines[i+1] == "" {
-					lines = append(lines[:i+1], lines[i+2:]...)
-				}
-			}
-		}
-	}
-
-	return strings.Join(lines, "\n")
-}
-
 // loaderAdapter adapts loader.Loader to inheritance.TemplateLoader interface
 type loaderAdapter struct {
 	loader Loader
@@ -798,8 +1530,14 @@ func registerBuiltinGlobals(env *Environment) {
 	// range() function
 	env.AddGlobal("range", rangeFunction)
 
+	// default() function
+	env.AddGlobal("default", defaultFunction)
+
 	// dict() function
-	env.AddGlobal("dict", dictFunction)
+	env.AddGlobal("dict", runtime.OrderedKeywordsFunc(dictFunction))
+
+	// list() function
+	env.AddGlobal("list", listFunction)
 
 	// cycler() function
 	env.AddGlobal("cycler", cyclerFunction)
@@ -818,4 +1556,7 @@ func registerBuiltinGlobals(env *Environment) {
 
 	// url_for() function
 	env.AddGlobal("url_for", urlForFunction)
+
+	// now() function
+	env.AddGlobal("now", env.nowFunction)
 }
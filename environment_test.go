@@ -1,6 +1,7 @@
 package miya
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/zipreport/miya/loader"
 	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
 )
 
 func TestEnvironmentCreation(t *testing.T) {
@@ -123,6 +125,85 @@ func TestEnvironmentFilters(t *testing.T) {
 	}
 }
 
+func TestFilterChainOptimizerUsesEnvironmentFilters(t *testing.T) {
+	env := NewEnvironment()
+	shout := func(value interface{}, args ...interface{}) (interface{}, error) {
+		return strings.ToUpper(value.(string)) + "!", nil
+	}
+	if err := env.AddFilter("shout", shout); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.AddFilterKW("pad", func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return fmt.Sprintf("%-*s|", kwargs["width"], value), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	//lint:ignore SA1019 the deprecated optimizer must still match DefaultEvaluator
+	optimized := runtime.NewOptimizedFilterEvaluator()
+	ctx := &TemplateContextAdapter{ctx: NewContextFrom(map[string]interface{}{"name": "alice"}), env: env}
+	eval := func(t *testing.T, node parser.ExpressionNode) interface{} {
+		t.Helper()
+		want, err := runtime.NewEvaluator().EvalNode(node, ctx)
+		if err != nil {
+			t.Fatalf("DefaultEvaluator: %v", err)
+		}
+		got, err := optimized.EvalNode(node, ctx)
+		if err != nil {
+			t.Fatalf("optimized: %v", err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("optimized got %v, DefaultEvaluator %v", got, want)
+		}
+		return got
+	}
+	parse := func(t *testing.T, source string) parser.ExpressionNode {
+		t.Helper()
+		node, err := parser.ParseExpression(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+
+	t.Run("SameAsDefaultEvaluator", func(t *testing.T) {
+		if got := eval(t, parse(t, `name|shout|replace("!", "?")`)); got != "ALICE?" {
+			t.Errorf("got %v", got)
+		}
+		if got := eval(t, parse(t, `name|pad(width=7)`)); got != "alice  |" {
+			t.Errorf("keyword arguments: got %v", got)
+		}
+		if got := eval(t, parse(t, `nothing|default("none")|upper`)); got != "NONE" {
+			t.Errorf("default: got %v", got)
+		}
+		eval(t, parse(t, `[1, 2, 3]|selectexpr("item > 1")|list`))
+	})
+
+	t.Run("ReregisteredFilter", func(t *testing.T) {
+		node := parse(t, `name|shout`)
+		if got := eval(t, node); got != "ALICE!" {
+			t.Fatalf("got %v", got)
+		}
+
+		env.filterRegistry.Unregister("shout")
+		if err := env.AddFilter("shout", func(value interface{}, args ...interface{}) (interface{}, error) {
+			return strings.ToUpper(value.(string)) + "!!!", nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got := eval(t, node); got != "ALICE!!!" {
+			t.Errorf("after re-registering: got %v", got)
+		}
+
+		// Without a filter, a function in scope of the same name is applied
+		env.filterRegistry.Unregister("shout")
+		ctx.SetVariable("shout", func(value string) string { return value + "?" })
+		if got := eval(t, node); got != "alice?" {
+			t.Errorf("after removing: got %v", got)
+		}
+	})
+}
+
 func TestEnvironmentGlobals(t *testing.T) {
 	env := NewEnvironment()
 
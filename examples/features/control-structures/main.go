@@ -127,7 +127,7 @@ func main() {
 	fmt.Println("   ✓ Conditional iteration (if clause)")
 	fmt.Println("   ✓ Dictionary unpacking")
 	fmt.Println("   ✓ Else clause for empty collections")
-	fmt.Println("   ✓ Nested loops with loop.parent.loop")
+	fmt.Println("   ✓ Nested loops with loop.parent")
 	fmt.Println()
 
 	fmt.Println("3. INLINE CONDITIONALS:")
@@ -131,12 +131,11 @@ func inheritanceExample() {
 func recursiveExample() {
 	env := miya.NewEnvironment(miya.WithAutoEscape(false))
 
-	// Note: Miya doesn't support Python-style string repetition ("  " * n)
-	// We use a workaround with the range function to create indentation
+	// Each level is indented with string repetition
 	template := `
 Menu Structure:
 {% for item in menu recursive %}
-{% for _ in range(loop.depth0) %}  {% endfor %}{{ item.title }}
+{{ "  " * loop.depth0 }}{{ item.title }}
 {%- if item.children %}
 {{ loop(item.children) }}
 {%- endif %}
@@ -0,0 +1,156 @@
+// Markdown example: registering a markdown renderer as a safe filter.
+//
+// Miya does not ship a markdown implementation. Any library that turns
+// markdown into HTML can be wired in with AddSafeFilter; with goldmark
+// (github.com/yuin/goldmark) the filter body becomes:
+//
+//	var buf bytes.Buffer
+//	if err := goldmark.Convert([]byte(source), &buf); err != nil {
+//		return nil, err
+//	}
+//	return buf.String(), nil
+//
+// To keep this example runnable without extra dependencies it uses the small
+// renderMarkdown function below, which understands headings, paragraphs,
+// bullet lists, **bold**, *emphasis*, `code` and [links](url).
+//
+// Run from the project root:
+//
+//	go run ./examples/go/markdown
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func main() {
+	templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	templates.AddTemplate("post.html", `<article>
+<h1>{{ post.title }}</h1>
+{{ post.body|markdown }}
+<footer>
+{% filter markdown %}
+Written by **{{ post.author }}**. Found a mistake? [Edit this post]({{ post.edit_url }}).
+{% endfilter %}
+</footer>
+</article>`)
+
+	env := miya.NewEnvironment(
+		miya.WithLoader(templates),
+		miya.WithAutoEscape(true),
+	)
+
+	// The filter returns HTML, so it is registered as safe: autoescaping
+	// leaves its output alone instead of printing the tags as text.
+	if err := env.AddSafeFilter("markdown", func(value interface{}, args ...interface{}) (interface{}, error) {
+		return renderMarkdown(fmt.Sprint(value)), nil
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := miya.NewContext()
+	ctx.Set("post", map[string]interface{}{
+		"title":    "Release <notes>",
+		"author":   "Dana",
+		"edit_url": "https://example.com/posts/42/edit",
+		"body": `## What's new
+
+Templates can now register *safe* filters.
+
+- Markdown renders as **HTML**
+- Raw <script> tags in the source are still escaped
+- Works with ` + "`{% filter markdown %}`" + ` blocks`,
+	})
+
+	out, err := env.RenderTemplate("post.html", ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(out)
+}
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	listPattern    = regexp.MustCompile(`^[-*]\s+(.*)$`)
+	codePattern    = regexp.MustCompile("`([^`]+)`")
+	boldPattern    = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emPattern      = regexp.MustCompile(`\*([^*]+)\*`)
+	linkPattern    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	entityPattern  = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+)
+
+// renderMarkdown converts a small subset of markdown to HTML
+func renderMarkdown(source string) string {
+	var out, paragraph strings.Builder
+	inList := false
+
+	flushParagraph := func() {
+		if paragraph.Len() > 0 {
+			out.WriteString("<p>" + renderInline(paragraph.String()) + "</p>\n")
+			paragraph.Reset()
+		}
+	}
+	closeList := func() {
+		if inList {
+			out.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flushParagraph()
+			closeList()
+		case headingPattern.MatchString(line):
+			flushParagraph()
+			closeList()
+			m := headingPattern.FindStringSubmatch(line)
+			level := len(m[1])
+			out.WriteString(fmt.Sprintf("<h%d>%s</h%d>\n", level, renderInline(m[2]), level))
+		case listPattern.MatchString(line):
+			flushParagraph()
+			if !inList {
+				out.WriteString("<ul>\n")
+				inList = true
+			}
+			out.WriteString("<li>" + renderInline(listPattern.FindStringSubmatch(line)[1]) + "</li>\n")
+		default:
+			closeList()
+			if paragraph.Len() > 0 {
+				paragraph.WriteString(" ")
+			}
+			paragraph.WriteString(line)
+		}
+	}
+	flushParagraph()
+	closeList()
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// renderInline escapes text and applies inline formatting. Like CommonMark,
+// it keeps entity references intact, so text that was already escaped by
+// autoescaping inside a {% filter markdown %} block is not escaped twice.
+func renderInline(text string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '&' && entityPattern.MatchString(text[i:]) {
+			escaped.WriteByte('&')
+			continue
+		}
+		escaped.WriteString(html.EscapeString(text[i : i+1]))
+	}
+
+	result := codePattern.ReplaceAllString(escaped.String(), "<code>$1</code>")
+	result = boldPattern.ReplaceAllString(result, "<strong>$1</strong>")
+	result = emPattern.ReplaceAllString(result, "<em>$1</em>")
+	return linkPattern.ReplaceAllString(result, `<a href="$2">$1</a>`)
+}
@@ -5,9 +5,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime"
 	"time"
 
 	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/httprender"
 	"github.com/zipreport/miya/loader"
 	"github.com/zipreport/miya/parser"
 )
@@ -21,15 +23,10 @@ type Product struct {
 	Category    string
 }
 
-type PageData struct {
-	Title       string
-	CurrentTime time.Time
-	User        map[string]interface{}
-	Products    []Product
-	Message     string
-}
-
-var env *miya.Environment
+var (
+	env      *miya.Environment
+	renderer *httprender.Renderer
+)
 
 // SimpleTemplateParser implements loader.TemplateParser interface
 type SimpleTemplateParser struct {
@@ -87,6 +84,7 @@ func init() {
             <a href="/">Home</a>
             <a href="/products">Products</a>
             <a href="/about">About</a>
+            <a href="/api/products">JSON</a>
         </nav>
     </header>
     
@@ -164,12 +162,13 @@ func init() {
 
 <p>Browse our selection of {{ products|length }} products:</p>
 
+{% cache "products" timeout="5m" %}
 {% set categories = products|groupby("Category") %}
 
-{% for category, items in categories %}
-<h3>{{ category }}</h3>
+{% for group in categories %}
+<h3>{{ group.grouper }} ({{ group.list|length }})</h3>
 <div class="category-products">
-    {% for product in items %}
+    {% for product in group.list %}
     <div class="product">
         <h4>{{ product.Name }} (#{{ product.ID }})</h4>
         <p>{{ product.Description }}</p>
@@ -183,6 +182,7 @@ func init() {
     {% endfor %}
 </div>
 {% endfor %}
+{% endcache %}
 
 <h3>Statistics:</h3>
 <ul>
@@ -242,9 +242,23 @@ func init() {
     <li>Current Time: {{ current_time.Format("Jan 02, 2006 3:04:05 PM MST") }}</li>
     <li>Go Version: {{ runtime_version }}</li>
 </ul>
+{% endblock %}`
+
+	// Rendered by the renderer when a page fails; error is only set in debug mode
+	errorTemplate := `{% extends "base.html" %}
+
+{% block title %}Error {{ status }} - {{ super() }}{% endblock %}
+
+{% block content %}
+<h2>{{ status }} {{ status_text }}</h2>
+<p>Something went wrong rendering {{ path }}.</p>
+{% if error %}
+<pre>{{ template }}: {{ error }}</pre>
+{% endif %}
 {% endblock %}`
 
 	// Save templates
+	os.WriteFile("templates/error.html", []byte(errorTemplate), 0644)
 	os.WriteFile("templates/base.html", []byte(baseTemplate), 0644)
 	os.WriteFile("templates/home.html", []byte(homeTemplate), 0644)
 	os.WriteFile("templates/products.html", []byte(productsTemplate), 0644)
@@ -255,6 +269,23 @@ func init() {
 	templateParser := NewSimpleTemplateParser(env)
 	fsLoader := loader.NewFileSystemLoader([]string{"templates"}, templateParser)
 	env.SetLoader(fsLoader)
+
+	// The grouped product listing is wrapped in {% cache %}, so it is rendered
+	// at most once every five minutes
+	env.SetFragmentCache(miya.NewMemoryFragmentCache(100))
+
+	// Every page gets the request path and the current time. Set MIYA_DEBUG=1
+	// to show render errors on the error page.
+	renderer = httprender.New(env,
+		httprender.WithContextProcessor(func(req *http.Request) map[string]interface{} {
+			return map[string]interface{}{
+				"path":         req.URL.Path,
+				"current_time": time.Now(),
+			}
+		}),
+		httprender.WithErrorTemplate("error.html"),
+		httprender.WithDebug(os.Getenv("MIYA_DEBUG") == "1"),
+	)
 }
 
 func getSampleProducts() []Product {
@@ -268,65 +299,41 @@ func getSampleProducts() []Product {
 	}
 }
 
-func renderTemplate(w http.ResponseWriter, templateName string, data PageData) {
-	tmpl, err := env.GetTemplate(templateName)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	ctx := miya.NewContext()
-	ctx.Set("title", data.Title)
-	ctx.Set("current_time", data.CurrentTime)
-	ctx.Set("user", data.User)
-	ctx.Set("products", data.Products)
-	ctx.Set("message", data.Message)
-
-	result, err := tmpl.Render(ctx)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Render error: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(result))
-}
-
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	data := PageData{
-		Title:       "Home",
-		CurrentTime: time.Now(),
-		User: map[string]interface{}{
+	renderer.ForRequest(r).HTML(w, http.StatusOK, "home.html", map[string]interface{}{
+		"title": "Home",
+		"user": map[string]interface{}{
 			"name":   "john doe",
 			"email":  "john@example.com",
 			"joined": "2024-01-15",
 		},
-		Products: getSampleProducts(),
-	}
-	renderTemplate(w, "home.html", data)
+		"products": getSampleProducts(),
+	})
 }
 
 func productsHandler(w http.ResponseWriter, r *http.Request) {
-	data := PageData{
-		Title:       "Products",
-		CurrentTime: time.Now(),
-		Products:    getSampleProducts(),
-	}
-	renderTemplate(w, "products.html", data)
+	renderer.ForRequest(r).HTML(w, http.StatusOK, "products.html", map[string]interface{}{
+		"title":    "Products",
+		"products": getSampleProducts(),
+	})
 }
 
 func aboutHandler(w http.ResponseWriter, r *http.Request) {
-	data := PageData{
-		Title:       "About",
-		CurrentTime: time.Now(),
-	}
-	renderTemplate(w, "about.html", data)
+	renderer.ForRequest(r).HTML(w, http.StatusOK, "about.html", map[string]interface{}{
+		"title":           "About",
+		"runtime_version": runtime.Version(),
+	})
+}
+
+func productsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	renderer.JSON(w, http.StatusOK, getSampleProducts())
 }
 
 func main() {
 	http.HandleFunc("/", homeHandler)
 	http.HandleFunc("/products", productsHandler)
 	http.HandleFunc("/about", aboutHandler)
+	http.HandleFunc("/api/products", productsAPIHandler)
 
 	fmt.Println("Server starting on http://localhost:8080")
 	fmt.Println("Press Ctrl+C to stop")
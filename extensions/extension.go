@@ -20,20 +20,25 @@ type ExtensionError struct {
 }
 
 func (ee *ExtensionError) Error() string {
+	message := ee.Message
+	if ee.Cause != nil {
+		message += ": " + ee.Cause.Error()
+	}
+
 	if ee.TemplateName != "" && ee.Line > 0 {
 		return fmt.Sprintf("extension '%s' error in template '%s' at line %d:%d: %s",
-			ee.ExtensionName, ee.TemplateName, ee.Line, ee.Column, ee.Message)
+			ee.ExtensionName, ee.TemplateName, ee.Line, ee.Column, message)
 	} else if ee.TemplateName != "" {
 		return fmt.Sprintf("extension '%s' error in template '%s': %s",
-			ee.ExtensionName, ee.TemplateName, ee.Message)
+			ee.ExtensionName, ee.TemplateName, message)
 	} else if ee.TagName != "" && ee.Line > 0 {
 		return fmt.Sprintf("extension '%s' error in tag '%s' at line %d:%d: %s",
-			ee.ExtensionName, ee.TagName, ee.Line, ee.Column, ee.Message)
+			ee.ExtensionName, ee.TagName, ee.Line, ee.Column, message)
 	} else if ee.TagName != "" {
 		return fmt.Sprintf("extension '%s' error in tag '%s': %s",
-			ee.ExtensionName, ee.TagName, ee.Message)
+			ee.ExtensionName, ee.TagName, message)
 	}
-	return fmt.Sprintf("extension '%s' error: %s", ee.ExtensionName, ee.Message)
+	return fmt.Sprintf("extension '%s' error: %s", ee.ExtensionName, message)
 }
 
 func (ee *ExtensionError) Unwrap() error {
@@ -143,6 +148,14 @@ type ExtensionParser interface {
 	// ParseBlock parses a block extension body until the end tag
 	ParseBlock(endTag string) ([]parser.Node, error)
 
+	// ParseUntil parses nodes until a block tag named by one of tags, which
+	// is left unconsumed, or the end of input
+	ParseUntil(tags ...string) ([]parser.Node, error)
+
+	// PeekTag returns the name of the block tag starting at the current
+	// token, or "" when the current token doesn't start a block tag
+	PeekTag() string
+
 	// Error creates a parser error with the given message
 	Error(message string) error
 
@@ -742,6 +742,11 @@ func (mrc *MockRuntimeContext) GetVariable(name string) (interface{}, bool) {
 	return val, exists
 }
 
+func (mrc *MockRuntimeContext) HasVariable(name string) bool {
+	_, exists := mrc.variables[name]
+	return exists
+}
+
 func (mrc *MockRuntimeContext) SetVariable(name string, value interface{}) {
 	mrc.variables[name] = value
 }
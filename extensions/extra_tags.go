@@ -0,0 +1,173 @@
+package extensions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zipreport/miya/lexer"
+	"github.com/zipreport/miya/parser"
+)
+
+// NewExtraTags returns the extensions adding the tags that aren't part of
+// Jinja2 but come with miya: switch and unless
+func NewExtraTags() []Extension {
+	return []Extension{NewSwitchExtension(), NewUnlessExtension()}
+}
+
+// SwitchExtension adds switch blocks, which render the first case with a
+// value equal to the subject, or the default when no case matches:
+//
+//	{% switch order.status %}
+//	{% case "shipped" %}On its way
+//	{% case "pending", "processing" %}Being prepared
+//	{% default %}Unknown
+//	{% endswitch %}
+//
+// The subject is evaluated once and compared with == to the values. Cases
+// don't fall through, and the default is optional.
+type SwitchExtension struct {
+	*BaseExtension
+}
+
+// NewSwitchExtension creates a new switch extension
+func NewSwitchExtension() *SwitchExtension {
+	ext := NewBlockExtension("switch", map[string]string{"switch": "endswitch"})
+	ext.tags = append(ext.tags, "case", "default")
+	return &SwitchExtension{BaseExtension: ext}
+}
+
+// ParseTag parses a switch block into a SwitchNode. Its case and default
+// tags are parsed with it, so reaching them here means they are outside one.
+func (se *SwitchExtension) ParseTag(tagName string, p ExtensionParser) (parser.Node, error) {
+	if tagName != "switch" {
+		return nil, p.Error(fmt.Sprintf("unexpected '%s' outside a switch block", tagName))
+	}
+
+	start := p.Current()
+	if p.Check(lexer.TokenBlockEnd) || p.Check(lexer.TokenBlockEndTrim) {
+		return nil, p.Error("expected a value after 'switch'")
+	}
+	subject, err := p.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ExpectBlockEnd(); err != nil {
+		return nil, err
+	}
+	node := parser.NewSwitchNode(subject, start.Line, start.Column)
+
+	for {
+		switch p.PeekTag() {
+		case "case":
+			p.Advance() // consume {%
+			caseToken := p.Advance()
+			c := parser.NewCaseNode(caseToken.Line, caseToken.Column)
+			for {
+				if p.Check(lexer.TokenBlockEnd) || p.Check(lexer.TokenBlockEndTrim) {
+					return nil, p.Error("expected a value after 'case'")
+				}
+				value, err := p.ParseExpression()
+				if err != nil {
+					return nil, err
+				}
+				c.Values = append(c.Values, value)
+				if !p.Check(lexer.TokenComma) {
+					break
+				}
+				p.Advance() // consume ,
+			}
+			if err := p.ExpectBlockEnd(); err != nil {
+				return nil, err
+			}
+			if c.Body, err = p.ParseUntil("case", "default", "endswitch"); err != nil {
+				return nil, err
+			}
+			node.Cases = append(node.Cases, c)
+
+		case "default":
+			if node.Default != nil {
+				return nil, p.Error("duplicate 'default' in switch block")
+			}
+			p.Advance() // consume {%
+			p.Advance() // consume default
+			if err := p.ExpectBlockEnd(); err != nil {
+				return nil, err
+			}
+			body, err := p.ParseUntil("case", "default", "endswitch")
+			if err != nil {
+				return nil, err
+			}
+			node.Default = append([]parser.Node{}, body...)
+
+		case "endswitch":
+			// The end tag is consumed by the parser
+			return node, nil
+
+		default:
+			if p.IsAtEnd() || p.Check(lexer.TokenEOF) {
+				return nil, p.Error("unclosed 'switch' tag, expected '{% endswitch %}'")
+			}
+			// Only whitespace can come before the first case
+			if len(node.Cases) == 0 && node.Default == nil && p.Check(lexer.TokenText) && strings.TrimSpace(p.Current().Value) == "" {
+				p.Advance()
+				continue
+			}
+			return nil, p.Error("unexpected content in switch block, expected 'case' or 'default'")
+		}
+	}
+}
+
+// UnlessExtension adds unless blocks, which render their body when the
+// condition is false, with an optional else:
+//
+//	{% unless user.verified %}Please verify your email{% endunless %}
+//
+// They are parsed into if blocks with the condition negated.
+type UnlessExtension struct {
+	*BaseExtension
+}
+
+// NewUnlessExtension creates a new unless extension
+func NewUnlessExtension() *UnlessExtension {
+	return &UnlessExtension{
+		BaseExtension: NewBlockExtension("unless", map[string]string{"unless": "endunless"}),
+	}
+}
+
+// ParseTag parses an unless block into an IfNode
+func (ue *UnlessExtension) ParseTag(tagName string, p ExtensionParser) (parser.Node, error) {
+	if tagName != "unless" {
+		return nil, p.Error(fmt.Sprintf("unexpected '%s' outside an unless block", tagName))
+	}
+
+	start := p.Current()
+	if p.Check(lexer.TokenBlockEnd) || p.Check(lexer.TokenBlockEndTrim) {
+		return nil, p.Error("expected a condition after 'unless'")
+	}
+	condition, err := p.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ExpectBlockEnd(); err != nil {
+		return nil, err
+	}
+
+	node := parser.NewIfNode(parser.NewUnaryOpNode("not", condition, start.Line, start.Column), start.Line, start.Column)
+	if node.Body, err = p.ParseUntil("else", "endunless"); err != nil {
+		return nil, err
+	}
+	if p.PeekTag() == "else" {
+		p.Advance() // consume {%
+		p.Advance() // consume else
+		if err := p.ExpectBlockEnd(); err != nil {
+			return nil, err
+		}
+		if node.Else, err = p.ParseUntil("endunless"); err != nil {
+			return nil, err
+		}
+	}
+	if p.PeekTag() != "endunless" {
+		return nil, p.Error("unclosed 'unless' tag, expected '{% endunless %}'")
+	}
+	return node, nil
+}
@@ -0,0 +1,90 @@
+package extensions
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zipreport/miya/parser"
+)
+
+func parseWithExtraTags(t *testing.T, source string) *parser.TemplateNode {
+	t.Helper()
+	registry := NewRegistry()
+	for _, ext := range NewExtraTags() {
+		if err := registry.Register(ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokens, err := CreateTokensFromString(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, err := NewExtensionAwareParser(tokens, registry).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", source, err)
+	}
+	return ast
+}
+
+func TestSwitchExtension(t *testing.T) {
+	source := `{% switch order.status %}{% case "a" %}A{% case "b", "c" %}{% if x %}BC{% endif %}{% default %}D{% endswitch %}`
+
+	t.Run("ParsesIntoSwitchNode", func(t *testing.T) {
+		ast := parseWithExtraTags(t, source)
+		if len(ast.Children) != 1 {
+			t.Fatalf("expected 1 node, got %d", len(ast.Children))
+		}
+		node, ok := ast.Children[0].(*parser.SwitchNode)
+		if !ok {
+			t.Fatalf("expected *parser.SwitchNode, got %T", ast.Children[0])
+		}
+		if len(node.Cases) != 2 || len(node.Cases[0].Values) != 1 || len(node.Cases[1].Values) != 2 {
+			t.Errorf("unexpected cases: %s", node)
+		}
+		if len(node.Default) != 1 {
+			t.Errorf("expected a default with 1 node, got %d", len(node.Default))
+		}
+	})
+
+	t.Run("PrintsBack", func(t *testing.T) {
+		printed := parser.Print(parseWithExtraTags(t, source))
+		if printed != source {
+			t.Errorf("got %s\nwant %s", printed, source)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		var names []string
+		parser.Walk(parseWithExtraTags(t, source), func(n parser.Node) bool {
+			if id, ok := n.(*parser.IdentifierNode); ok {
+				names = append(names, id.Name)
+			}
+			return true
+		})
+		if len(names) != 2 || names[0] != "order" || names[1] != "x" {
+			t.Errorf("got identifiers %v", names)
+		}
+	})
+
+	t.Run("EncodeDecode", func(t *testing.T) {
+		ast := parseWithExtraTags(t, source)
+		var buf bytes.Buffer
+		if err := parser.Encode(&buf, ast); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := parser.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed := parser.Print(decoded[0]); printed != source {
+			t.Errorf("got %s\nwant %s", printed, source)
+		}
+	})
+}
+
+func TestUnlessExtension(t *testing.T) {
+	ast := parseWithExtraTags(t, `{% unless a %}x{% else %}y{% endunless %}`)
+	if printed := parser.Print(ast); printed != `{% if not a %}x{% else %}y{% endif %}` {
+		t.Errorf("got %s", printed)
+	}
+}
@@ -2,6 +2,7 @@ package extensions
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/zipreport/miya/lexer"
 	"github.com/zipreport/miya/parser"
@@ -114,6 +115,42 @@ func (pa *ParserAdapter) ParseBlock(endTag string) ([]parser.Node, error) {
 	return nodes, nil
 }
 
+// ParseUntil parses nodes until a block tag named by one of tags, which is
+// left unconsumed, or the end of input
+func (pa *ParserAdapter) ParseUntil(tags ...string) ([]parser.Node, error) {
+	var nodes []parser.Node
+
+	for !pa.IsAtEnd() && !pa.Check(lexer.TokenEOF) {
+		if slices.Contains(tags, pa.PeekTag()) {
+			break
+		}
+
+		node, err := pa.parser.ParseTopLevelPublic()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+// PeekTag returns the name of the block tag starting at the current token,
+// or "" when the current token doesn't start a block tag
+func (pa *ParserAdapter) PeekTag() string {
+	if !pa.Check(lexer.TokenBlockStart) && !pa.Check(lexer.TokenBlockStartTrim) {
+		return ""
+	}
+	tokens := pa.parser.GetTokens()
+	next := pa.parser.GetCurrentPosition() + 1
+	if next >= len(tokens) {
+		return ""
+	}
+	return tokens[next].Value
+}
+
 // Error creates a parser error with the given message
 func (pa *ParserAdapter) Error(message string) error {
 	return pa.parser.ErrorPublic(message)
@@ -177,10 +214,21 @@ type ExtensionAwareParser struct {
 
 // NewExtensionAwareParser creates a parser with extension support
 func NewExtensionAwareParser(tokens []*lexer.Token, registry *Registry) *ExtensionAwareParser {
-	return &ExtensionAwareParser{
+	eap := &ExtensionAwareParser{
 		Parser:   parser.NewParser(tokens),
 		registry: registry,
 	}
+	// Custom tags nested in built-in blocks reach the extensions through the
+	// tag handler
+	eap.SetTagHandler(func(tagName string) (parser.Node, bool, error) {
+		ext, ok := registry.GetExtensionForTag(tagName)
+		if !ok {
+			return nil, false, nil
+		}
+		node, err := eap.parseCustomTag(ext, tagName)
+		return node, true, err
+	})
+	return eap
 }
 
 // Parse parses the tokens into a template AST with extension support
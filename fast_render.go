@@ -0,0 +1,154 @@
+package miya
+
+import (
+	"html"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// fastTemplate is a template made only of text and {{ name }} tags, such as
+// "Hello {{ name }}" or a subject line, which renders without the evaluator
+type fastTemplate struct {
+	segments []fastSegment
+	tail     string // Text after the last variable
+	size     int    // Length of the text
+
+	// Set when the template is text only: tail is its whole output and
+	// normalized what Render returns for it
+	constant   bool
+	normalized string
+}
+
+// fastSegment is text followed by the value of the variable name
+type fastSegment struct {
+	text string
+	name string
+}
+
+// classifyTemplate returns the fast form of the template called name, or
+// nil when ast has more than text, comments and tags printing a variable
+// without filters
+func classifyTemplate(name string, ast parser.Node) *fastTemplate {
+	root, ok := ast.(*parser.TemplateNode)
+	if !ok {
+		return nil
+	}
+
+	fast := &fastTemplate{}
+	var text strings.Builder
+	for _, child := range root.Children {
+		switch n := child.(type) {
+		case *parser.TextNode:
+			text.WriteString(n.Content)
+		case *parser.RawNode:
+			text.WriteString(n.Content)
+		case *parser.CommentNode:
+		case *parser.VariableNode:
+			identifier, ok := n.Expression.(*parser.IdentifierNode)
+			if !ok {
+				return nil
+			}
+			fast.segments = append(fast.segments, fastSegment{text: text.String(), name: identifier.Name})
+			text.Reset()
+		default:
+			return nil
+		}
+	}
+	fast.tail = text.String()
+	fast.size = len(fast.tail)
+	for _, segment := range fast.segments {
+		fast.size += len(segment.text)
+	}
+	if len(fast.segments) == 0 {
+		fast.constant = true
+		fast.normalized = normalizeOutput(name, fast.tail)
+	}
+	return fast
+}
+
+// render returns the output of the template for context, as the evaluator
+// would with options, or false when the render needs the evaluator: when a
+// variable isn't in context, or is undefined or a function, it's left to
+// report the error or apply the undefined behavior
+func (f *fastTemplate) render(context Context, options *renderOptions) (string, bool) {
+	if f.constant {
+		return f.tail, true
+	}
+	if context == nil {
+		return "", false
+	}
+
+	var out strings.Builder
+	out.Grow(f.size + 32*len(f.segments))
+	for _, segment := range f.segments {
+		value, ok := lookupDirect(context, segment.name)
+		if !ok {
+			return "", false
+		}
+		if _, undefined := value.(*runtime.Undefined); undefined {
+			return "", false
+		}
+		if options.finalizer != nil {
+			value = options.finalizer(value)
+			if _, undefined := value.(*runtime.Undefined); undefined {
+				return "", false
+			}
+		}
+		if runtime.IsFunction(value) {
+			return "", false
+		}
+		out.WriteString(segment.text)
+		out.WriteString(fastOutput(value, options))
+	}
+	out.WriteString(f.tail)
+	return out.String(), true
+}
+
+// fastOutput returns the text {{ value }} prints, escaped as EvalVariableNode
+// escapes it
+func fastOutput(value interface{}, options *renderOptions) string {
+	if value == nil {
+		return ""
+	}
+	if options.escaper != nil {
+		if options.autoEscape {
+			return options.escaper.Escape(value, options.escapeContext)
+		}
+	} else if s, ok := value.(string); ok && options.autoEscape {
+		return html.EscapeString(s)
+	}
+	return runtime.ToString(value)
+}
+
+// lookupDirect returns the variable name of the context given to a render.
+// Globals are left to the evaluator, but the variables of a context take
+// precedence over them, as when the render copies the context.
+func lookupDirect(ctx Context, name string) (interface{}, bool) {
+	if c, ok := ctx.(*context); ok {
+		for current := c; current != nil; current = current.parent {
+			if value, ok := current.data[name]; ok {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+	value, ok := ctx.All()[name]
+	return value, ok
+}
+
+// rendersDirectly reports whether the environment lets simple templates
+// skip the evaluator: nothing traces, limits, minifies or post-processes
+// their renders
+func (e *Environment) rendersDirectly() bool {
+	if e.htmlMinifyWhitespace || e.sandboxed || e.maxRenderMemory > 0 || e.reservedNameProtection {
+		return false
+	}
+	for env := e; env != nil; env = env.parent {
+		if env.tracer != nil || len(env.templatePostProcessors) > 0 {
+			return false
+		}
+	}
+	return true
+}
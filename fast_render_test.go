@@ -0,0 +1,163 @@
+package miya
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// evaluate renders tmpl with the evaluator, as Render does for templates
+// without a fast form
+func evaluate(tmpl *Template, context Context, opts ...RenderOption) (string, error) {
+	return tmpl.newRenderPlan(tmpl.renderOptions(opts)).evaluate(context)
+}
+
+type shouting string
+
+func (s shouting) String() string { return strings.ToUpper(string(s)) }
+
+func TestFastRenderMatchesEvaluator(t *testing.T) {
+	vars := map[string]interface{}{
+		"name":    "Tom & <Jerry>",
+		"count":   42,
+		"price":   9.5,
+		"ok":      true,
+		"nothing": nil,
+		"tags":    []string{"a", "b"},
+		"user":    map[string]interface{}{"name": "Ann"},
+		"safe":    runtime.SafeValue{Value: "<b>bold</b>"},
+		"html":    runtime.SafeString("<i>it</i>"),
+		"loud":    shouting("<hey>"),
+	}
+
+	templates := []struct {
+		name     string
+		template string
+	}{
+		{"text", "Hello, world!"},
+		{"empty", ""},
+		{"comment and raw", "a{# note #}b{% raw %}{{ c }}{% endraw %}"},
+		{"whitespace control", "Hi   {{- name -}}   !"},
+		{"variable", "Hello {{ name }}"},
+		{"variables", "{{ name }} has {{ count }} items at {{ price }} ({{ ok }})"},
+		{"nil", "[{{ nothing }}]"},
+		{"collections", "{{ tags }} {{ user }}"},
+		{"safe values", "{{ safe }} {{ html }}"},
+		{"stringer", "{{ loud }}"},
+		{"option element", "<option>\n  {{ name }}\n</option>"},
+	}
+	renders := []struct {
+		name string
+		env  *Environment
+		opts []RenderOption
+	}{
+		{"autoescape", NewEnvironment(WithAutoEscape(true)), nil},
+		{"no autoescape", NewEnvironment(WithAutoEscape(false)), nil},
+		{"escape context", NewEnvironment(), []RenderOption{RenderEscapeContext(EscapeContextJS)}},
+		{"escape context off", NewEnvironment(), []RenderOption{RenderEscapeContext(EscapeContextURL), RenderAutoescape(false)}},
+		{"finalizer", NewEnvironment(WithFinalizer(func(v interface{}) interface{} {
+			if v == nil {
+				return "-"
+			}
+			return v
+		})), nil},
+	}
+
+	for _, r := range renders {
+		for _, tt := range templates {
+			t.Run(r.name+"/"+tt.name, func(t *testing.T) {
+				tmpl, err := r.env.FromString(tt.template)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tmpl.fast == nil {
+					t.Fatalf("Expected a fast form of %q", tt.template)
+				}
+				want, err := evaluate(tmpl, NewContextFrom(vars), r.opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := tmpl.RenderWith(NewContextFrom(vars), r.opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestFastRenderFallsBack(t *testing.T) {
+	t.Run("not classified", func(t *testing.T) {
+		for _, source := range []string{"{{ name|upper }}", "{{ user.name }}", "{{ 'x' }}", "{% if ok %}x{% endif %}", "{% set x = 1 %}{{ x }}"} {
+			tmpl, err := NewEnvironment().FromString(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tmpl.fast != nil {
+				t.Errorf("%s: expected no fast form", source)
+			}
+		}
+	})
+
+	// Renders the fast form declines give the evaluator's output or error
+	tests := []struct {
+		name     string
+		env      *Environment
+		template string
+		vars     map[string]interface{}
+	}{
+		{"missing", NewEnvironment(WithStrictUndefined(false)), "Hi {{ name }}", nil},
+		{"missing strict", NewEnvironment(WithStrictUndefined(true)), "Hi {{ name }}", nil},
+		{"debug undefined", NewEnvironment(WithDebugUndefined(true)), "Hi {{ name }}", nil},
+		{"global", NewEnvironment(), "{{ site }}", nil},
+		{"function", NewEnvironment(), "{{ f }}", map[string]interface{}{"f": func() string { return "x" }}},
+		{"undefined value", NewEnvironment(), "{{ u }}", map[string]interface{}{"u": runtime.NewUndefined("u", runtime.UndefinedSilent, nil)}},
+		{"minified", NewEnvironment(WithAutoEscape(true), WithHTMLMinifyWhitespace(true)), "<p>\n   {{ name }}   </p>", map[string]interface{}{"name": "x"}},
+		{"sandboxed", NewSandboxedEnvironment(WithSandboxLimits(SandboxLimits{MaxOutputSize: 4, MaxRangeSize: 10})), "{{ name }}", map[string]interface{}{"name": "too long"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env.AddGlobal("site", "example.com")
+			tmpl, err := tt.env.FromString(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, wantErr := evaluate(tmpl, NewContextFrom(tt.vars))
+			got, err := tmpl.Render(NewContextFrom(tt.vars))
+			if got != want || fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Errorf("Expected %q, %v, got %q, %v", want, wantErr, got, err)
+			}
+		})
+	}
+
+	t.Run("post-processor", func(t *testing.T) {
+		env := NewEnvironment()
+		if err := env.AddTemplatePostProcessor("*", func(s string) (string, error) { return strings.ToUpper(s), nil }); err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := env.FromString("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.Render(nil); err != nil || got != "HELLO" {
+			t.Errorf("Expected %q, got %q, %v", "HELLO", got, err)
+		}
+	})
+
+	t.Run("context variables win over globals", func(t *testing.T) {
+		env := NewEnvironment()
+		env.AddGlobal("name", "global")
+		tmpl, err := env.FromString("{{ name }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.Render(NewContextFrom(map[string]interface{}{"name": "local"})); err != nil || got != "local" {
+			t.Errorf("Expected %q, got %q, %v", "local", got, err)
+		}
+	})
+}
@@ -5,6 +5,9 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/zipreport/miya/runtime"
 )
 
 // FirstFilter returns the first item in a sequence
@@ -40,7 +43,8 @@ func FirstFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		}
 	case string:
 		if len(v) > 0 {
-			return string(v[0]), nil
+			_, size := utf8.DecodeRuneInString(v)
+			return v[:size], nil
 		}
 	default:
 		// Use reflection for other slice types
@@ -88,7 +92,8 @@ func LastFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		}
 	case string:
 		if len(v) > 0 {
-			return string(v[len(v)-1]), nil
+			_, size := utf8.DecodeLastRuneInString(v)
+			return v[len(v)-size:], nil
 		}
 	default:
 		// Use reflection for other slice types
@@ -139,6 +144,9 @@ func LengthFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		case reflect.String:
 			return len([]rune(rv.String())), nil
 		}
+		if n, ok := runtime.CollectionLen(value); ok {
+			return n, nil
+		}
 	}
 
 	return 0, fmt.Errorf("object of type %T has no len()", value)
@@ -533,84 +541,18 @@ func ListFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 }
 
-// SelectAttrFilter selects items with a specific attribute value
+// SelectAttrFilter selects the items of a sequence whose attribute passes a
+// test: without one, those whose attribute is truthy. See SelectFilter for
+// the tests it takes; a value other than a test, as in
+// selectattr("count", 3), keeps the items whose attribute equals it.
 func SelectAttrFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("selectattr filter requires attribute name")
-	}
-
-	attrName := ToString(args[0])
-	var testValue interface{}
-
-	if len(args) > 1 {
-		testValue = args[1]
-	}
-
-	switch v := value.(type) {
-	case []interface{}:
-		var result []interface{}
-
-		for _, item := range v {
-			attr := extractAttribute(item, attrName)
-
-			if len(args) == 1 {
-				// Just check if attribute is truthy
-				if ToBool(attr) {
-					result = append(result, item)
-				}
-			} else {
-				// Check if attribute equals test value
-				if reflect.DeepEqual(attr, testValue) {
-					result = append(result, item)
-				}
-			}
-		}
-
-		return result, nil
-
-	default:
-		return nil, fmt.Errorf("selectattr filter requires a sequence")
-	}
+	return filterItems("selectattr", value, args, true, true, nil)
 }
 
-// RejectAttrFilter rejects items with a specific attribute value
+// RejectAttrFilter rejects the items of a sequence whose attribute passes a
+// test, as SelectAttrFilter selects them
 func RejectAttrFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("rejectattr filter requires attribute name")
-	}
-
-	attrName := ToString(args[0])
-	var testValue interface{}
-
-	if len(args) > 1 {
-		testValue = args[1]
-	}
-
-	switch v := value.(type) {
-	case []interface{}:
-		var result []interface{}
-
-		for _, item := range v {
-			attr := extractAttribute(item, attrName)
-
-			if len(args) == 1 {
-				// Just check if attribute is falsy
-				if !ToBool(attr) {
-					result = append(result, item)
-				}
-			} else {
-				// Check if attribute doesn't equal test value
-				if !reflect.DeepEqual(attr, testValue) {
-					result = append(result, item)
-				}
-			}
-		}
-
-		return result, nil
-
-	default:
-		return nil, fmt.Errorf("rejectattr filter requires a sequence")
-	}
+	return filterItems("rejectattr", value, args, true, false, nil)
 }
 
 // Helper functions
@@ -639,41 +581,27 @@ func makeStringSlice(value interface{}, attribute string) ([]string, error) {
 	}
 }
 
+// extractAttribute returns the attribute of obj as obj.attribute reads it
+// in a template, or nil
 func extractAttribute(obj interface{}, attribute string) interface{} {
-	if obj == nil {
-		return nil
-	}
-
-	switch v := obj.(type) {
-	case map[string]interface{}:
-		return v[attribute]
-	case map[string]string:
-		return v[attribute]
-	default:
-		// Use reflection for struct fields
-		rv := reflect.ValueOf(obj)
-		if rv.Kind() == reflect.Ptr {
-			rv = rv.Elem()
-		}
-
-		if rv.Kind() == reflect.Struct {
-			field := rv.FieldByName(attribute)
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
-			}
+	value, _ := runtime.LookupAttribute(obj, attribute)
+	return value
+}
 
-			// Try with capitalized field name
-			field = rv.FieldByName(capitalizeFirst(attribute))
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
+func compareValues(a, b interface{}, caseSensitive bool) int {
+	// Numbers, including decoded JSON numbers, compare by value
+	if aNum, ok := numberOf(a); ok {
+		if bNum, ok := numberOf(b); ok {
+			switch {
+			case aNum < bNum:
+				return -1
+			case aNum > bNum:
+				return 1
 			}
+			return 0
 		}
-
-		return nil
 	}
-}
 
-func compareValues(a, b interface{}, caseSensitive bool) int {
 	// Convert to strings for comparison
 	aStr := ToString(a)
 	bStr := ToString(b)
@@ -691,6 +619,17 @@ func compareValues(a, b interface{}, caseSensitive bool) int {
 	return 0
 }
 
+// numberOf returns the value of a number of any Go type or a json.Number.
+// Strings and booleans are not numbers here.
+func numberOf(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case nil, string, bool:
+		return 0, false
+	}
+	f, err := ToFloat(value)
+	return f, err == nil
+}
+
 func reverseStringSlice(slice []string) {
 	for i, j := 0, len(slice)-1; i < j; i, j = i+1, j-1 {
 		slice[i], slice[j] = slice[j], slice[i]
@@ -704,6 +643,10 @@ func ItemsFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 
 	switch v := value.(type) {
+	case *runtime.Dict:
+		// A dict made by the template lists its keys in the order they were added
+		return v.Pairs(), nil
+
 	case map[string]interface{}:
 		var items []interface{}
 		for key, val := range v {
@@ -738,6 +681,13 @@ func KeysFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 
 	switch v := value.(type) {
+	case *runtime.Dict:
+		keys := make([]interface{}, 0, v.Len())
+		for _, key := range v.Keys() {
+			keys = append(keys, key)
+		}
+		return keys, nil
+
 	case map[string]interface{}:
 		var keys []interface{}
 		for key := range v {
@@ -772,6 +722,14 @@ func ValuesFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 
 	switch v := value.(type) {
+	case *runtime.Dict:
+		values := make([]interface{}, 0, v.Len())
+		for _, key := range v.Keys() {
+			value, _ := v.Get(key)
+			values = append(values, value)
+		}
+		return values, nil
+
 	case map[string]interface{}:
 		var values []interface{}
 		// Get keys first and sort them for consistent order
@@ -28,6 +28,10 @@ func TimeFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		format = ToString(args[0])
 	}
 
+	if d, ok := value.(time.Duration); ok {
+		return formatDuration(d, format), nil
+	}
+
 	t, err := parseTimeValue(value)
 	if err != nil {
 		return nil, fmt.Errorf("time filter requires a date/time value: %v", err)
@@ -58,6 +62,10 @@ func StrftimeFilter(value interface{}, args ...interface{}) (interface{}, error)
 	}
 
 	format := ToString(args[0])
+	if d, ok := value.(time.Duration); ok {
+		return formatDuration(d, format), nil
+	}
+
 	t, err := parseTimeValue(value)
 	if err != nil {
 		return nil, fmt.Errorf("strftime filter requires a date/time value: %v", err)
@@ -231,6 +239,21 @@ func convertStrftimeFormat(strftimeFormat string) string {
 	return result
 }
 
+// formatDuration formats d as a clock reading. Hours are not wrapped at 24,
+// and either the strftime (%H:%M:%S) or the Go (15:04:05) spelling works.
+func formatDuration(d time.Duration, format string) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	hours := fmt.Sprintf("%02d", int64(d/time.Hour))
+	minutes := fmt.Sprintf("%02d", int64(d%time.Hour/time.Minute))
+	seconds := fmt.Sprintf("%02d", int64(d%time.Minute/time.Second))
+	replacer := strings.NewReplacer("%H", hours, "%M", minutes, "%S", seconds, "15", hours, "04", minutes, "05", seconds)
+	return sign + replacer.Replace(format)
+}
+
 func formatRelativeTime(duration time.Duration, future bool) string {
 	seconds := int(duration.Seconds())
 	minutes := seconds / 60
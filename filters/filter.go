@@ -1,21 +1,46 @@
 package filters
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zipreport/miya/runtime"
 )
 
 type FilterFunc func(value interface{}, args ...interface{}) (interface{}, error)
 
+// KeywordFilterFunc is a filter that receives keyword arguments separately
+// from positional ones
+type KeywordFilterFunc func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// ContextFilterFunc is a filter that also receives the context it is applied
+// in. ctx is nil when the filter is applied outside a render, e.g. through
+// Apply.
+type ContextFilterFunc func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
 type FilterRegistry struct {
-	filters map[string]FilterFunc
-	mutex   sync.RWMutex
+	filters  map[string]FilterFunc
+	extended map[string]ContextFilterFunc // keyword and context filters
+	safe     map[string]bool              // filters whose output is already HTML
+	aliases  map[string]string            // alias name -> target name
+	costs    map[string]memoryCostFunc    // result sizes of built-in filters
+	mutex    sync.RWMutex
+	parent   *FilterRegistry // consulted for names not registered here
+
+	generation atomic.Uint64 // counts registrations and removals
 }
 
 func NewRegistry() *FilterRegistry {
 	registry := &FilterRegistry{
-		filters: make(map[string]FilterFunc),
+		filters:  make(map[string]FilterFunc),
+		extended: make(map[string]ContextFilterFunc),
+		safe:     make(map[string]bool),
+		aliases:  make(map[string]string),
+		costs:    make(map[string]memoryCostFunc),
 	}
 
 	// Register all built-in filters
@@ -24,6 +49,20 @@ func NewRegistry() *FilterRegistry {
 	return registry
 }
 
+// NewChildRegistry creates an empty registry layered over parent. Filters
+// registered on the child shadow the parent's; all other lookups fall through,
+// including filters added to the parent later.
+func NewChildRegistry(parent *FilterRegistry) *FilterRegistry {
+	return &FilterRegistry{
+		filters:  make(map[string]FilterFunc),
+		extended: make(map[string]ContextFilterFunc),
+		safe:     make(map[string]bool),
+		aliases:  make(map[string]string),
+		costs:    make(map[string]memoryCostFunc),
+		parent:   parent,
+	}
+}
+
 func (r *FilterRegistry) Register(name string, fn FilterFunc) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -32,17 +71,118 @@ func (r *FilterRegistry) Register(name string, fn FilterFunc) error {
 		return fmt.Errorf("filter %q already registered", name)
 	}
 	r.filters[name] = fn
+	r.generation.Add(1)
+	return nil
+}
+
+// RegisterKeyword registers a filter that takes keyword arguments. Applied
+// through Get or Apply it receives no keyword arguments.
+func (r *FilterRegistry) RegisterKeyword(name string, fn KeywordFilterFunc) error {
+	return r.RegisterContext(name, func(_ runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return fn(value, args, kwargs)
+	})
+}
+
+// RegisterContext registers a filter that takes keyword arguments and the
+// context it is applied in. Applied through Get or Apply it receives neither.
+func (r *FilterRegistry) RegisterContext(name string, fn ContextFilterFunc) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.filters[name]; exists {
+		return fmt.Errorf("filter %q already registered", name)
+	}
+	r.filters[name] = func(value interface{}, args ...interface{}) (interface{}, error) {
+		return fn(nil, value, args, nil)
+	}
+	r.extended[name] = fn
+	r.generation.Add(1)
 	return nil
 }
 
+// RegisterSafe registers a filter whose output is HTML, such as rendered
+// markdown. Where autoescaping is on, its results are marked safe so they
+// are not escaped again.
+func (r *FilterRegistry) RegisterSafe(name string, fn FilterFunc) error {
+	if err := r.Register(name, fn); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.safe[name] = true
+	r.mutex.Unlock()
+	return nil
+}
+
+// IsSafe reports whether name, or the filter an alias named name points to,
+// was registered with RegisterSafe
+func (r *FilterRegistry) IsSafe(name string) bool {
+	r.mutex.RLock()
+	_, local := r.filters[name]
+	safe := r.safe[name]
+	target, isAlias := r.aliases[name]
+	r.mutex.RUnlock()
+
+	switch {
+	case safe:
+		return true
+	case isAlias:
+		return r.IsSafe(target)
+	case !local && r.parent != nil:
+		return r.parent.IsSafe(name)
+	}
+	return false
+}
+
 func (r *FilterRegistry) Get(name string) (FilterFunc, bool) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
 	fn, ok := r.filters[name]
+	if !ok && r.parent != nil {
+		return r.parent.Get(name)
+	}
 	return fn, ok
 }
 
+// RegisterAlias makes alias call the filter registered as target, replacing
+// any filter already named alias. The target is looked up on the alias's
+// first use, so it may be registered later, and errors it returns are
+// wrapped in a *runtime.AliasError naming both filters.
+func (r *FilterRegistry) RegisterAlias(alias, target string) error {
+	if alias == "" || target == "" || alias == target {
+		return fmt.Errorf("invalid filter alias %q -> %q", alias, target)
+	}
+
+	var resolved atomic.Pointer[FilterFunc]
+	aliasFn := func(value interface{}, args ...interface{}) (interface{}, error) {
+		fn := resolved.Load()
+		if fn == nil {
+			targetFn, ok := r.Get(target)
+			if !ok {
+				return nil, &runtime.AliasError{Kind: "filter", Alias: alias, Target: target, Err: fmt.Errorf("unknown filter: %s", target)}
+			}
+			fn = &targetFn
+			resolved.Store(fn)
+		}
+
+		result, err := (*fn)(value, args...)
+		if err != nil {
+			return nil, &runtime.AliasError{Kind: "filter", Alias: alias, Target: target, Err: err}
+		}
+		return result, nil
+	}
+
+	r.mutex.Lock()
+	r.filters[alias] = aliasFn
+	r.aliases[alias] = target
+	delete(r.extended, alias)
+	delete(r.safe, alias)
+	delete(r.costs, alias)
+	r.generation.Add(1)
+	r.mutex.Unlock()
+	return nil
+}
+
 func (r *FilterRegistry) Apply(name string, value interface{}, args ...interface{}) (interface{}, error) {
 	fn, ok := r.Get(name)
 	if !ok {
@@ -51,6 +191,46 @@ func (r *FilterRegistry) Apply(name string, value interface{}, args ...interface
 	return fn(value, args...)
 }
 
+// ApplyWithContext applies a filter as a template does. Filters registered
+// with RegisterKeyword or RegisterContext receive kwargs and ctx; other
+// filters are called with args alone.
+func (r *FilterRegistry) ApplyWithContext(ctx runtime.Context, name string, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if fn, ok := r.getExtended(name); ok {
+		return fn(ctx, value, args, kwargs)
+	}
+	return r.Apply(name, value, args...)
+}
+
+// getExtended finds the keyword or context form of the filter named name,
+// following aliases the way IsSafe does
+func (r *FilterRegistry) getExtended(name string) (ContextFilterFunc, bool) {
+	r.mutex.RLock()
+	_, local := r.filters[name]
+	fn, extended := r.extended[name]
+	target, isAlias := r.aliases[name]
+	r.mutex.RUnlock()
+
+	switch {
+	case extended:
+		return fn, true
+	case isAlias:
+		targetFn, ok := r.getExtended(target)
+		if !ok {
+			return nil, false
+		}
+		return func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			result, err := targetFn(ctx, value, args, kwargs)
+			if err != nil {
+				return nil, &runtime.AliasError{Kind: "filter", Alias: name, Target: target, Err: err}
+			}
+			return result, nil
+		}, true
+	case !local && r.parent != nil:
+		return r.parent.getExtended(name)
+	}
+	return nil, false
+}
+
 func (r *FilterRegistry) List() []string {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -59,6 +239,13 @@ func (r *FilterRegistry) List() []string {
 	for name := range r.filters {
 		names = append(names, name)
 	}
+	if r.parent != nil {
+		for _, name := range r.parent.List() {
+			if _, shadowed := r.filters[name]; !shadowed {
+				names = append(names, name)
+			}
+		}
+	}
 	return names
 }
 
@@ -68,11 +255,27 @@ func (r *FilterRegistry) Unregister(name string) bool {
 
 	if _, exists := r.filters[name]; exists {
 		delete(r.filters, name)
+		delete(r.extended, name)
+		delete(r.safe, name)
+		delete(r.aliases, name)
+		delete(r.costs, name)
+		r.generation.Add(1)
 		return true
 	}
 	return false
 }
 
+// Generation returns a count that changes whenever a filter is registered
+// or removed, here or in the parent registry, so what callers resolve
+// against the registry's filters can be cached until it changes
+func (r *FilterRegistry) Generation() uint64 {
+	generation := r.generation.Load()
+	if r.parent != nil {
+		generation += r.parent.Generation()
+	}
+	return generation
+}
+
 // registerBuiltinFilters registers all built-in filters
 func (r *FilterRegistry) registerBuiltinFilters() {
 	// String filters
@@ -84,11 +287,14 @@ func (r *FilterRegistry) registerBuiltinFilters() {
 	r.filters["strip"] = TrimFilter // alias
 	r.filters["lstrip"] = LstripFilter
 	r.filters["rstrip"] = RstripFilter
+	r.filters["removeprefix"] = RemoveprefixFilter
+	r.filters["removesuffix"] = RemovesuffixFilter
 	r.filters["replace"] = ReplaceFilter
 	r.filters["truncate"] = TruncateFilter
 	r.filters["wordwrap"] = WordwrapFilter
 	r.filters["center"] = CenterFilter
 	r.filters["indent"] = IndentFilter
+	r.filters["nindent"] = NIndentFilter
 	r.filters["regex_replace"] = RegexReplaceFilter
 	r.filters["regex_search"] = RegexSearchFilter
 	r.filters["regex_findall"] = RegexFindallFilter
@@ -158,9 +364,13 @@ func (r *FilterRegistry) registerBuiltinFilters() {
 	r.filters["select"] = SelectFilter
 	r.filters["reject"] = RejectFilter
 	r.filters["attr"] = AttrFilter
+	r.filters["getitem"] = GetItemFilter
 	r.filters["format"] = FormatFilter
 	r.filters["filesizeformat"] = FileSizeFormatFilter
 	r.filters["pprint"] = PPrintFilter
+	r.extended["pprint"] = func(_ runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return PPrintKeywordFilter(value, args, kwargs)
+	}
 	r.filters["dictsort"] = DictSortFilter
 	r.filters["groupby"] = GroupByFilter
 
@@ -178,7 +388,30 @@ func (r *FilterRegistry) registerBuiltinFilters() {
 	// Utility filters
 	r.filters["string"] = StringFilter
 	r.filters["tojson"] = ToJSONFilter
+	r.extended["tojson"] = func(_ runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return ToJSONKeywordFilter(value, args, kwargs)
+	}
 	r.filters["fromjson"] = FromJSONFilter
+	r.filters["toyaml"] = ToYAMLFilter
+	r.extended["toyaml"] = func(_ runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return ToYAMLKeywordFilter(value, args, kwargs)
+	}
+
+	// apply calls template macros, so it is given the render context
+	r.extended["apply"] = ApplyFilter
+	r.filters["apply"] = func(value interface{}, args ...interface{}) (interface{}, error) {
+		return ApplyFilter(nil, value, args, nil)
+	}
+
+	for name, spec := range builtinInputs {
+		if fn, ok := r.filters[name]; ok {
+			r.filters[name] = normalizeInput(fn, spec)
+		}
+	}
+	r.registerKeywordParams()
+	r.registerItemFilters()
+	r.registerMarkupFilters()
+	r.registerMemoryCosts()
 }
 
 func ToString(value interface{}) string {
@@ -191,9 +424,12 @@ func ToString(value interface{}) string {
 		return v
 	case []byte:
 		return string(v)
-	case fmt.Stringer:
-		return v.String()
+	case time.Duration:
+		return runtime.FormatDuration(v)
 	default:
+		if text, ok := runtime.TextOf(value); ok {
+			return text
+		}
 		return fmt.Sprintf("%v", value)
 	}
 }
@@ -224,6 +460,12 @@ func ToInt(value interface{}) (int, error) {
 		return int(v), nil
 	case float64:
 		return int(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), nil
+		}
+		f, err := v.Float64()
+		return int(f), err
 	case string:
 		var i int
 		_, err := fmt.Sscanf(v, "%d", &i)
@@ -233,30 +475,8 @@ func ToInt(value interface{}) (int, error) {
 	}
 }
 
+// ToBool reports whether value holds in a condition, as runtime.IsTruthy
+// does
 func ToBool(value interface{}) bool {
-	if value == nil {
-		return false
-	}
-
-	switch v := value.(type) {
-	case bool:
-		return v
-	case string:
-		return v != ""
-	case int, int8, int16, int32, int64:
-		return reflect.ValueOf(v).Int() != 0
-	case uint, uint8, uint16, uint32, uint64:
-		return reflect.ValueOf(v).Uint() != 0
-	case float32, float64:
-		return reflect.ValueOf(v).Float() != 0
-	default:
-		// Check if it's a slice, map, or array
-		rv := reflect.ValueOf(value)
-		switch rv.Kind() {
-		case reflect.Slice, reflect.Map, reflect.Array:
-			return rv.Len() > 0
-		default:
-			return true
-		}
-	}
+	return runtime.IsTruthy(value)
 }
@@ -64,6 +64,41 @@ func TestFilterRegistry(t *testing.T) {
 	}
 }
 
+func TestFilterRegistryGeneration(t *testing.T) {
+	parent := NewRegistry()
+	child := NewChildRegistry(parent)
+	identity := func(value interface{}, args ...interface{}) (interface{}, error) { return value, nil }
+
+	changes := []struct {
+		name   string
+		change func()
+	}{
+		{"register", func() { _ = parent.Register("one", identity) }},
+		{"register on child", func() { _ = child.Register("two", identity) }},
+		{"register context", func() {
+			_ = parent.RegisterContext("three", func(_ runtime.Context, value interface{}, _ []interface{}, _ map[string]interface{}) (interface{}, error) {
+				return value, nil
+			})
+		}},
+		{"alias", func() { _ = parent.RegisterAlias("uno", "one") }},
+		{"unregister", func() { parent.Unregister("one") }},
+	}
+	for _, c := range changes {
+		before := child.Generation()
+		c.change()
+		if child.Generation() == before {
+			t.Errorf("%s: generation unchanged", c.name)
+		}
+	}
+
+	before := parent.Generation()
+	_ = parent.Register("three", identity) // already registered
+	parent.Unregister("absent")
+	if parent.Generation() != before {
+		t.Error("generation changed without a change to the filters")
+	}
+}
+
 func TestStringFilters(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -79,6 +114,16 @@ func TestStringFilters(t *testing.T) {
 		{"title", TitleFilter, "hello world", nil, "Hello World", false},
 		{"trim", TrimFilter, "  spaced  ", nil, "spaced", false},
 		{"trim with chars", TrimFilter, "...trimmed...", []interface{}{"."}, "trimmed", false},
+		{"trim multi-byte chars", TrimFilter, "→★ pick ★←", []interface{}{"★←→"}, " pick ", false},
+		{"trim none chars", TrimFilter, "\t spaced \n", []interface{}{nil}, "spaced", false},
+		{"lstrip chars", LstripFilter, "//a/b/", []interface{}{"/"}, "a/b/", false},
+		{"rstrip chars", RstripFilter, "//a/b/", []interface{}{"/"}, "//a/b", false},
+		{"rstrip multi-byte chars", RstripFilter, "über…。", []interface{}{"。…"}, "über", false},
+		{"removeprefix", RemoveprefixFilter, "https://https://x", []interface{}{"https://"}, "https://x", false},
+		{"removeprefix absent", RemoveprefixFilter, "http://x", []interface{}{"https://"}, "http://x", false},
+		{"removesuffix", RemovesuffixFilter, "report.tar.gz", []interface{}{".gz"}, "report.tar", false},
+		{"removesuffix multi-byte", RemovesuffixFilter, "naïveté", []interface{}{"té"}, "naïve", false},
+		{"removesuffix no argument", RemovesuffixFilter, "x", nil, nil, true},
 		{"replace", ReplaceFilter, "hello world", []interface{}{"world", "Go"}, "hello Go", false},
 		{"replace count", ReplaceFilter, "foo foo foo", []interface{}{"foo", "bar", 2}, "bar bar foo", false},
 		{"truncate", TruncateFilter, "this is a long string", []interface{}{10}, "this is a...", false},
@@ -111,6 +156,51 @@ func TestStringFilters(t *testing.T) {
 	}
 }
 
+func TestUnicodeStringFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   FilterFunc
+		input    interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"title german", TitleFilter, "örtliche bäckerei", nil, "Örtliche Bäckerei"},
+		{"title lowers the rest", TitleFilter, "hello wORLD-foo bar's", nil, "Hello World-Foo Bar's"},
+		{"title brackets", TitleFilter, "über (ärger) [öl]", nil, "Über (Ärger) [Öl]"},
+		{"title dotless i", TitleFilter, "ısparta ılıca", nil, "Isparta Ilıca"},
+		{"title non-breaking space", TitleFilter, "straße\u00a0ähre", nil, "Straße\u00a0Ähre"},
+		{"title cjk", TitleFilter, "東京 tower", nil, "東京 Tower"},
+		{"capitalize german", CapitalizeFilter, "ÄPFEL UND BIRNEN", nil, "Äpfel und birnen"},
+		{"capitalize dotted I", CapitalizeFilter, "İstanbul", nil, "İstanbul"},
+		{"capitalize dotted I inside", CapitalizeFilter, "DİYARBAKIR", nil, "Di\u0307yarbakir"},
+		{"capitalize final sigma", CapitalizeFilter, "ΟΔΟΣ ΣΟΦΙΑΣ", nil, "Οδος σοφιας"},
+		{"capitalize empty", CapitalizeFilter, "", nil, ""},
+		{"wordcount german", WordcountFilter, "Grüße aus Köln", nil, 3},
+		{"wordcount hyphen and apostrophe", WordcountFilter, "a well-known writer's café", nil, 6},
+		{"wordcount non-breaking space", WordcountFilter, "zwei\u00a0Wörter", nil, 2},
+		{"wordcount combining marks", WordcountFilter, "cafe\u0301 noir", nil, 2},
+		{"wordcount cjk runs", WordcountFilter, "東京タワー は 高い", nil, 3},
+		{"wordcount punctuation only", WordcountFilter, " -- ... ", nil, 0},
+		{"center runes", CenterFilter, "äö", []interface{}{6}, "  äö  "},
+		{"center odd padding", CenterFilter, "äb", []interface{}{5}, "  äb "},
+		{"center even width", CenterFilter, "äbc", []interface{}{6}, " äbc  "},
+		{"truncate runes", TruncateFilter, "äöü äöü äöü", []interface{}{9}, "äöü äöü..."},
+		{"truncate killwords runes", TruncateFilter, "日本語のテキスト", []interface{}{3, true}, "日本語..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.filter(tt.input, tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestHTMLFilters(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -162,6 +252,8 @@ func TestCollectionFilters(t *testing.T) {
 	}{
 		{"first", FirstFilter, []interface{}{"a", "b", "c"}, nil, "a", false},
 		{"last", LastFilter, []interface{}{"a", "b", "c"}, nil, "c", false},
+		{"first string", FirstFilter, "Émile", nil, "É", false},
+		{"last string", LastFilter, "José", nil, "é", false},
 		{"length", LengthFilter, []interface{}{"a", "b", "c"}, nil, 3, false},
 		{"length string", LengthFilter, "hello", nil, 5, false},
 		{"join", JoinFilter, []interface{}{"a", "b", "c"}, []interface{}{", "}, "a, b, c", false},
@@ -210,9 +302,27 @@ func TestNumericFilters(t *testing.T) {
 		{"round", RoundFilter, 3.14159, []interface{}{2}, "3.14", false},
 		{"round ceil", RoundFilter, 3.14159, []interface{}{2, "ceil"}, "3.15", false},
 		{"round floor", RoundFilter, 3.14159, []interface{}{2, "floor"}, "3.14", false},
+		{"round common half", RoundFilter, 2.5, nil, 3.0, false},
+		{"round even half", RoundFilter, 2.5, []interface{}{0, "even"}, "2", false},
+		{"round even odd half", RoundFilter, 3.5, []interface{}{0, "even"}, "4", false},
+		{"round common negative half", RoundFilter, -2.5, nil, -3.0, false},
+		{"round even negative half", RoundFilter, -2.5, []interface{}{0, "even"}, "-2", false},
+		{"round even decimals", RoundFilter, 0.125, []interface{}{2, "even"}, "0.12", false},
+		{"round binary value", RoundFilter, 1.005, []interface{}{2}, "1.00", false},
+		{"round binary value up", RoundFilter, 2.665, []interface{}{2}, "2.67", false},
+		{"round exact half", RoundFilter, 0.125, []interface{}{2}, "0.13", false},
+		{"round negative precision", RoundFilter, 1234, []interface{}{-2}, 1200.0, false},
+		{"round invalid method", RoundFilter, 2.5, []interface{}{0, "up"}, nil, true},
 		{"int", IntFilter, "123", nil, 123, false},
 		{"int default", IntFilter, "invalid", []interface{}{0}, 0, false},
+		{"int float string", IntFilter, "1.5", nil, 1, false},
+		{"int base", IntFilter, "ff", []interface{}{0, 16}, 255, false},
+		{"int base prefix", IntFilter, "0x1A", []interface{}{0, 16}, 26, false},
+		{"int detect base", IntFilter, "0b101", []interface{}{0, 0}, 5, false},
+		{"int invalid digits", IntFilter, "12z", []interface{}{-1, 10}, -1, false},
+		{"int invalid base", IntFilter, "10", []interface{}{0, 1}, nil, true},
 		{"float", FloatFilter, "3.14", nil, 3.14, false},
+		{"float default", FloatFilter, "x", []interface{}{2.5}, 2.5, false},
 		{"sum", SumFilter, []interface{}{1, 2, 3, 4}, nil, 10.0, false},
 		{"sum with start", SumFilter, []interface{}{1, 2, 3}, []interface{}{10}, 16.0, false},
 		{"min", MinFilter, []interface{}{3, 1, 4, 1, 5}, nil, 1, false},
@@ -252,7 +362,13 @@ func TestUtilityFilters(t *testing.T) {
 		hasError bool
 	}{
 		{"default with value", DefaultFilter, "exists", []interface{}{"fallback"}, "exists", false},
-		{"default without value", DefaultFilter, "", []interface{}{"fallback"}, "fallback", false},
+		{"default without value", DefaultFilter, nil, []interface{}{"fallback"}, "fallback", false},
+		{"default keeps empty string", DefaultFilter, "", []interface{}{"fallback"}, "", false},
+		{"default boolean empty string", DefaultFilter, "", []interface{}{"fallback", true}, "fallback", false},
+		{"default boolean zero", DefaultFilter, 0, []interface{}{"fallback", true}, "fallback", false},
+		{"default boolean empty list", DefaultFilter, []interface{}{}, []interface{}{"fallback", true}, "fallback", false},
+		{"default boolean safe empty", DefaultFilter, runtime.SafeValue{Value: ""}, []interface{}{"fallback", true}, "fallback", false},
+		{"default keeps zero", DefaultFilter, 0, []interface{}{"fallback"}, 0, false},
 		{"default boolean true", DefaultFilter, true, []interface{}{"fallback", true}, true, false},
 		{"default boolean false", DefaultFilter, false, []interface{}{"fallback", true}, "fallback", false},
 		{"tojson", ToJSONFilter, map[string]interface{}{"key": "value"}, nil, runtime.SafeValue{Value: `{"key":"value"}`}, false},
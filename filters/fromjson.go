@@ -0,0 +1,88 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// fromJSONSnippetRadius is how many bytes of the input either side of a
+// parse error are quoted in the error
+const fromJSONSnippetRadius = 20
+
+// FromJSONFilter decodes a JSON string into map[string]interface{},
+// []interface{} and scalar values the template can traverse like any other
+// context data. Numbers are decoded as json.Number, so large integers keep
+// every digit; they render as written and compare and calculate as numbers.
+// An empty string decodes to none.
+func FromJSONFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	s := ToString(value)
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fromJSONError(s, err)
+	}
+
+	offset := int(decoder.InputOffset())
+	if rest := strings.TrimLeft(s[offset:], " \t\r\n"); rest != "" {
+		offset = len(s) - len(rest)
+		return nil, fromJSONParseError(s, offset, "unexpected data after the JSON value")
+	}
+	return result, nil
+}
+
+// fromJSONError reports err from decoding s at the offset it occurred at
+func fromJSONError(s string, err error) error {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset is just past the byte the error was found at
+		offset := int(syntaxErr.Offset) - 1
+		if offset < 0 {
+			offset = 0
+		}
+		return fromJSONParseError(s, offset, syntaxErr.Error())
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fromJSONParseError(s, len(s), "unexpected end of JSON input")
+	}
+	return runtime.NewFilterError("fromjson", fmt.Errorf("invalid JSON: %w", err), nil)
+}
+
+// fromJSONParseError is the error for the JSON in s being invalid at the
+// byte offset, quoting the input around it
+func fromJSONParseError(s string, offset int, message string) error {
+	return runtime.NewFilterError("fromjson",
+		fmt.Errorf("invalid JSON at offset %d: %s, near `%s`", offset, message, jsonSnippet(s, offset)), nil)
+}
+
+// jsonSnippet returns the part of s around offset, on one line, with ...
+// where it is cut
+func jsonSnippet(s string, offset int) string {
+	start := max(offset-fromJSONSnippetRadius, 0)
+	end := min(offset+fromJSONSnippetRadius, len(s))
+	for start > 0 && !utf8.RuneStart(s[start]) {
+		start--
+	}
+	for end < len(s) && !utf8.RuneStart(s[end]) {
+		end++
+	}
+
+	snippet := strings.Join(strings.Fields(s[start:end]), " ")
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(s) {
+		snippet += "..."
+	}
+	return snippet
+}
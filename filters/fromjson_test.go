@@ -0,0 +1,67 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestFromJSONFilter(t *testing.T) {
+	got, err := FromJSONFilter(`{"id": 9007199254740993, "price": 1.5, "tags": ["a", null, true]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"id":    json.Number("9007199254740993"),
+		"price": json.Number("1.5"),
+		"tags":  []interface{}{"a", nil, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if got, err := FromJSONFilter("  "); err != nil || got != nil {
+		t.Errorf("blank input: got %v, %v, want nil", got, err)
+	}
+}
+
+func TestFromJSONFilterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "syntax error",
+			input: `{"name": "Ann", "tags": ["a", "b",], "id": 1}`,
+			want:  "invalid JSON at offset 34: invalid character ']' looking for beginning of value, near `..., \"tags\": [\"a\", \"b\",], \"id\": 1}`",
+		},
+		{
+			name:  "truncated",
+			input: `{"name": "Ann"`,
+			want:  "invalid JSON at offset 14: unexpected end of JSON input, near `{\"name\": \"Ann\"`",
+		},
+		{
+			name:  "trailing data",
+			input: "[1, 2]\n[3]",
+			want:  "invalid JSON at offset 7: unexpected data after the JSON value, near `[1, 2] [3]`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromJSONFilter(tt.input)
+			var runtimeErr *runtime.RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeFilter {
+				t.Fatalf("expected a FilterError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want it to contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/zipreport/miya/runtime"
 )
 
 // Pre-compiled regex patterns for HTML filters (performance optimization)
@@ -28,14 +30,22 @@ func (s SafeValue) String() string {
 // EscapeFilter escapes HTML characters
 func EscapeFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	// Don't escape if already marked as safe
-	if _, ok := value.(SafeValue); ok {
+	if _, safe := unwrapSafe(value); safe {
 		return value, nil
 	}
+	if runtime.IsFunction(value) {
+		return nil, runtime.ErrFunctionValue
+	}
 
 	s := ToString(value)
 	return html.EscapeString(s), nil
 }
 
+// SafeContent returns the value held, so the runtime knows SafeValue as safe
+func (s SafeValue) SafeContent() interface{} {
+	return s.Value
+}
+
 // SafeFilter marks a value as safe (won't be escaped)
 func SafeFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	return SafeValue{Value: value}, nil
@@ -56,14 +66,22 @@ func XMLAttrFilter(value interface{}, args ...interface{}) (interface{}, error)
 	var result strings.Builder
 	attrCount := 0
 
+	// A dict made by the template keeps its keys in the order they were added
+	var keys []string
+	if d, ok := value.(*runtime.Dict); ok {
+		keys, value = d.Keys(), d.Map()
+	}
+
 	switch v := value.(type) {
 	case map[string]interface{}:
 		// Sort keys for consistent output
-		keys := make([]string, 0, len(v))
-		for k := range v {
-			keys = append(keys, k)
+		if keys == nil {
+			keys = make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
 		}
-		sort.Strings(keys)
 
 		for _, key := range keys {
 			val := v[key]
@@ -310,12 +328,11 @@ func MarkSafeFilter(value interface{}, args ...interface{}) (interface{}, error)
 // ForceEscapeFilter forces HTML escaping even for safe values
 func ForceEscapeFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	// Force escape even if marked as safe
-	var s string
-	if safeVal, ok := value.(SafeValue); ok {
-		s = ToString(safeVal.Value)
-	} else {
-		s = ToString(value)
+	value, _ = unwrapSafe(value)
+	if runtime.IsFunction(value) {
+		return nil, runtime.ErrFunctionValue
 	}
+	s := ToString(value)
 	// Return as SafeValue to prevent double-escaping
 	return SafeValue{Value: html.EscapeString(s)}, nil
 }
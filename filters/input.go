@@ -0,0 +1,193 @@
+package filters
+
+import (
+	"github.com/zipreport/miya/runtime"
+)
+
+// inputKind describes the value a built-in filter operates on. Before the
+// filter runs, safe values are unwrapped and nil or undefined inputs are
+// replaced with the empty value of that kind.
+type inputKind int
+
+const (
+	// inputAny filters see the value exactly as given
+	inputAny inputKind = iota
+	// inputValue filters see safe values unwrapped; nil and undefined are
+	// passed through for the filter to accept or reject
+	inputValue
+	inputString
+	inputSequence
+	inputMapping
+)
+
+type inputSpec struct {
+	kind inputKind
+	// keepsSafe marks filters that only transform text, so a safe input
+	// yields a safe result as it does with Jinja2's Markup
+	keepsSafe bool
+}
+
+// builtinInputs classifies every built-in filter. A filter missing from this
+// table is registered unchanged.
+var builtinInputs = map[string]inputSpec{
+	// String filters
+	"upper":         {inputString, true},
+	"lower":         {inputString, true},
+	"capitalize":    {inputString, true},
+	"title":         {inputString, true},
+	"trim":          {inputString, true},
+	"strip":         {inputString, true},
+	"lstrip":        {inputString, true},
+	"rstrip":        {inputString, true},
+	"removeprefix":  {inputString, true},
+	"removesuffix":  {inputString, true},
+	"replace":       {inputString, true},
+	"truncate":      {inputString, true},
+	"center":        {inputString, true},
+	"indent":        {inputString, true},
+	"nindent":       {inputString, true},
+	"string":        {inputString, true},
+	"wordwrap":      {inputString, false},
+	"regex_replace": {inputString, false},
+	"regex_search":  {inputString, false},
+	"regex_findall": {inputString, false},
+	"split":         {inputString, false},
+	"startswith":    {inputString, false},
+	"endswith":      {inputString, false},
+	"contains":      {inputString, false},
+	"slugify":       {inputString, false},
+	"pad_left":      {inputString, false},
+	"pad_right":     {inputString, false},
+	"wordcount":     {inputString, false},
+	"format":        {inputString, false},
+	"fromjson":      {inputString, false},
+
+	// HTML filters. escape, safe and friends decide on safety themselves.
+	"escape":         {inputAny, false},
+	"e":              {inputAny, false},
+	"safe":           {inputAny, false},
+	"forceescape":    {inputAny, false},
+	"autoescape":     {inputAny, false},
+	"marksafe":       {inputAny, false},
+	"urlencode":      {inputString, false},
+	"urlize":         {inputString, false},
+	"urlizetruncate": {inputString, false},
+	"urlizetarget":   {inputString, false},
+	"truncatehtml":   {inputString, false},
+	"striptags":      {inputString, false},
+	"nl2br":          {inputString, false},
+	"xmlattr":        {inputMapping, false},
+
+	// Collection filters
+	"first":      {inputSequence, false},
+	"last":       {inputSequence, false},
+	"length":     {inputSequence, false},
+	"count":      {inputSequence, false},
+	"join":       {inputSequence, false},
+	"sort":       {inputSequence, false},
+	"reverse":    {inputSequence, false},
+	"unique":     {inputSequence, false},
+	"slice":      {inputSequence, false},
+	"batch":      {inputSequence, false},
+	"list":       {inputSequence, false},
+	"selectattr": {inputSequence, false},
+	"rejectattr": {inputSequence, false},
+	"zip":        {inputSequence, false},
+	"map":        {inputSequence, false},
+	"select":     {inputSequence, false},
+	"reject":     {inputSequence, false},
+	"groupby":    {inputSequence, false},
+	"sum":        {inputSequence, false},
+	"min":        {inputSequence, false},
+	"max":        {inputSequence, false},
+	"random":     {inputSequence, false},
+	"items":      {inputMapping, false},
+	"keys":       {inputMapping, false},
+	"values":     {inputMapping, false},
+	"dictsort":   {inputMapping, false},
+
+	// Numeric filters. Like Jinja2, most of them reject a missing number.
+	"abs":            {inputValue, false},
+	"round":          {inputValue, false},
+	"int":            {inputValue, false},
+	"float":          {inputValue, false},
+	"ceil":           {inputValue, false},
+	"floor":          {inputValue, false},
+	"pow":            {inputValue, false},
+	"currency":       {inputValue, false},
+	"format_number":  {inputValue, false},
+	"filesizeformat": {inputValue, false},
+
+	// Utility filters
+	"default": {inputAny, false},
+	"d":       {inputAny, false},
+	"attr":    {inputValue, false},
+	"getitem": {inputValue, false},
+	"pprint":  {inputAny, false},
+	"tojson":  {inputAny, false},
+	"toyaml":  {inputAny, false},
+	"apply":   {inputAny, false},
+
+	// Date and time filters
+	"date":          {inputValue, false},
+	"time":          {inputValue, false},
+	"datetime":      {inputValue, false},
+	"strftime":      {inputValue, false},
+	"timestamp":     {inputValue, false},
+	"age":           {inputValue, false},
+	"relative_date": {inputValue, false},
+	"weekday":       {inputValue, false},
+	"month_name":    {inputValue, false},
+}
+
+// normalizeInput wraps fn so it receives its input in the form described by
+// spec. A strict undefined input is reported instead of calling fn.
+func normalizeInput(fn FilterFunc, spec inputSpec) FilterFunc {
+	if spec.kind == inputAny {
+		return fn
+	}
+	return func(value interface{}, args ...interface{}) (interface{}, error) {
+		value, safe := unwrapSafe(value)
+
+		if u, ok := value.(*runtime.Undefined); ok {
+			if u.Behavior == runtime.UndefinedStrict {
+				return nil, u.Error()
+			}
+			if spec.kind == inputString {
+				value = u.String()
+			} else if spec.kind != inputValue {
+				value = nil
+			}
+		}
+		if spec.kind == inputString && runtime.IsFunction(value) {
+			return nil, runtime.ErrFunctionValue
+		}
+		if spec.kind == inputSequence {
+			if items, ok := runtime.CollectionItems(value); ok {
+				value = items
+			}
+		}
+		if value == nil {
+			switch spec.kind {
+			case inputString:
+				value = ""
+			case inputSequence:
+				value = []interface{}{}
+			case inputMapping:
+				value = map[string]interface{}{}
+			}
+		}
+
+		result, err := fn(value, args...)
+		if err != nil || !safe || !spec.keepsSafe {
+			return result, err
+		}
+		return runtime.SafeValue{Value: result}, nil
+	}
+}
+
+// unwrapSafe returns the value held by any kind of safe value and whether
+// value was one
+func unwrapSafe(value interface{}) (interface{}, bool) {
+	return runtime.UnwrapSafe(value)
+}
@@ -0,0 +1,153 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestBuiltinFiltersHaveInputKind(t *testing.T) {
+	for _, name := range NewRegistry().List() {
+		if _, ok := builtinInputs[name]; !ok {
+			t.Errorf("built-in filter %q has no entry in builtinInputs", name)
+		}
+	}
+}
+
+// TestFiltersTolerateEdgeInputs runs every built-in filter against inputs
+// templates commonly produce by accident. Errors are allowed, panics are not.
+func TestFiltersTolerateEdgeInputs(t *testing.T) {
+	inputs := map[string]interface{}{
+		"nil":              nil,
+		"undefined":        runtime.NewUndefined("nope", runtime.UndefinedSilent, nil),
+		"debug undefined":  runtime.NewDebugUndefined("nope", "", nil),
+		"strict undefined": runtime.NewStrictUndefined("nope", nil),
+		"runtime safe":     runtime.SafeValue{Value: "<b>hi</b>"},
+		"filters safe":     SafeValue{Value: "<b>hi</b>"},
+		"struct":           struct{ Name string }{"x"},
+		"int":              42,
+		"map":              map[string]interface{}{"a": 1},
+	}
+	argLists := [][]interface{}{nil, {"a"}, {"a", "b"}, {1, 2}}
+
+	registry := NewRegistry()
+	for _, name := range registry.List() {
+		fn, _ := registry.Get(name)
+		for label, input := range inputs {
+			for _, args := range argLists {
+				err := callWithoutPanic(fn, input, args)
+				if label == "strict undefined" && builtinInputs[name].kind != inputAny && err == nil {
+					t.Errorf("%s(%s, %v): expected an undefined error", name, label, args)
+				}
+				if p, ok := err.(panicError); ok {
+					t.Errorf("%s(%s, %v) panicked: %v", name, label, args, p.value)
+				}
+			}
+		}
+	}
+}
+
+type panicError struct{ value interface{} }
+
+func (p panicError) Error() string { return fmt.Sprint("panic: ", p.value) }
+
+func callWithoutPanic(fn FilterFunc, value interface{}, args []interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{r}
+		}
+	}()
+	_, err = fn(value, args...)
+	return err
+}
+
+func TestFilterEdgeInputResults(t *testing.T) {
+	undefined := runtime.NewUndefined("nope", runtime.UndefinedSilent, nil)
+
+	tests := []struct {
+		filter string
+		value  interface{}
+		args   []interface{}
+		want   interface{}
+	}{
+		// Text transformations keep their input's safety
+		{"upper", runtime.SafeValue{Value: "<b>hi</b>"}, nil, runtime.SafeValue{Value: "<B>HI</B>"}},
+		{"upper", SafeValue{Value: "<b>hi</b>"}, nil, runtime.SafeValue{Value: "<B>HI</B>"}},
+		{"trim", runtime.SafeValue{Value: " <b>hi</b> "}, nil, runtime.SafeValue{Value: "<b>hi</b>"}},
+		{"replace", runtime.SafeValue{Value: "<b>hi</b>"}, []interface{}{"hi", "yo"}, runtime.SafeValue{Value: "<b>yo</b>"}},
+		{"upper", "<b>hi</b>", nil, "<B>HI</B>"},
+
+		// Other filters see the unwrapped value
+		{"striptags", runtime.SafeValue{Value: "<b>hi</b>"}, nil, "hi"},
+		{"length", runtime.SafeValue{Value: "<b>"}, nil, 3},
+		{"float", runtime.SafeValue{Value: "2.5"}, nil, 2.5},
+
+		// nil and undefined read as empty
+		{"upper", nil, nil, ""},
+		{"upper", undefined, nil, ""},
+		{"length", undefined, nil, 0},
+		{"join", undefined, []interface{}{","}, ""},
+		{"join", nil, []interface{}{","}, ""},
+		{"first", undefined, nil, nil},
+		{"int", undefined, nil, 0},
+
+		// escape leaves both kinds of safe value alone
+		{"escape", runtime.SafeValue{Value: "<b>"}, nil, runtime.SafeValue{Value: "<b>"}},
+		{"escape", SafeValue{Value: "<b>"}, nil, SafeValue{Value: "<b>"}},
+		{"forceescape", runtime.SafeValue{Value: "<b>"}, nil, SafeValue{Value: "&lt;b&gt;"}},
+
+		{"tojson", runtime.SafeValue{Value: "a"}, nil, runtime.SafeValue{Value: `"a"`}},
+		{"tojson", undefined, nil, runtime.SafeValue{Value: "null"}},
+	}
+
+	registry := NewRegistry()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s(%v)", tt.filter, tt.value), func(t *testing.T) {
+			got, err := registry.Apply(tt.filter, tt.value, tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterEdgeInputErrors(t *testing.T) {
+	strict := runtime.NewStrictUndefined("nope", nil)
+
+	tests := []struct {
+		filter string
+		value  interface{}
+	}{
+		{"upper", strict},
+		{"length", strict},
+		{"items", strict},
+		{"tojson", strict},
+		// Like Jinja2, arithmetic needs a number
+		{"abs", nil},
+		{"round", runtime.NewUndefined("nope", runtime.UndefinedSilent, nil)},
+	}
+
+	registry := NewRegistry()
+	for _, tt := range tests {
+		if _, err := registry.Apply(tt.filter, tt.value); err == nil {
+			t.Errorf("%s(%v): expected an error", tt.filter, tt.value)
+		}
+	}
+}
+
+func TestFiltersRejectFunctions(t *testing.T) {
+	fn := func() string { return "text" }
+
+	registry := NewRegistry()
+	for _, filter := range []string{"upper", "trim", "replace", "escape", "forceescape", "string"} {
+		if _, err := registry.Apply(filter, fn, "a", "b"); !errors.Is(err, runtime.ErrFunctionValue) {
+			t.Errorf("%s: expected ErrFunctionValue, got %v", filter, err)
+		}
+	}
+}
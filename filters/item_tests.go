@@ -0,0 +1,173 @@
+package filters
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// TestApplier applies the test called name, with args, to value
+type TestApplier func(name string, value interface{}, args ...interface{}) (bool, error)
+
+// filterItems keeps the items of value, a sequence, that pass the test in
+// args, or that fail it unless keep is set. With byAttribute the first
+// argument names the attribute of the items tested. Test names are looked
+// up with tests, which is nil outside environments, where the built-in tests
+// are used.
+func filterItems(filter string, value interface{}, args []interface{}, byAttribute, keep bool, tests TestApplier) (interface{}, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s filter requires a sequence", filter)
+	}
+	var attribute string
+	if byAttribute {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s filter requires attribute name", filter)
+		}
+		attribute, args = ToString(args[0]), args[1:]
+	}
+	test, err := itemTest(filter, args, byAttribute, tests)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []interface{}
+	for _, item := range items {
+		tested := item
+		if byAttribute {
+			tested = extractAttribute(item, attribute)
+		}
+		passed, err := test(tested)
+		if err != nil {
+			return nil, fmt.Errorf("%s filter: %w", filter, err)
+		}
+		if passed == keep {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// itemTest returns the test select, reject, selectattr and rejectattr apply
+// to items given their arguments after the attribute. Names are looked up
+// as they are, so tests named like keywords, such as "in", "none" or
+// "true", and like operators, such as "==", can be given.
+func itemTest(filter string, args []interface{}, byAttribute bool, tests TestApplier) (func(interface{}) (bool, error), error) {
+	if len(args) == 0 {
+		return func(value interface{}) (bool, error) { return ToBool(value), nil }, nil
+	}
+	switch test := args[0].(type) {
+	case string:
+		if tests == nil {
+			tests = runtime.ApplyBuiltinTest
+		}
+		testArgs := args[1:]
+		return func(value interface{}) (bool, error) { return tests(test, value, testArgs...) }, nil
+	case func(interface{}) bool:
+		return func(value interface{}) (bool, error) { return test(value), nil }, nil
+	}
+	if byAttribute && len(args) == 1 {
+		want := args[0]
+		return func(value interface{}) (bool, error) { return reflect.DeepEqual(value, want), nil }, nil
+	}
+	return nil, fmt.Errorf("%s filter requires a test name, got %T", filter, args[0])
+}
+
+// mapItems is the map filter as applied by an environment: the items'
+// attribute given by keyword or, given by position, their attribute or,
+// for items without one, the result of the environment's filter of that
+// name, applied with the arguments that follow, as in map("upper")
+func mapItems(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	for keyword := range kwargs {
+		if keyword != "attribute" {
+			return nil, fmt.Errorf("map got an unexpected keyword argument '%s'", keyword)
+		}
+	}
+	if attribute, ok := kwargs["attribute"]; ok {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("map takes a filter name or an attribute, not both")
+		}
+		return MapFilter(value, attribute)
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("map filter requires attribute or filter name")
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("map filter requires a sequence")
+	}
+
+	name := ToString(args[0])
+	env := runtime.EnvironmentOf(ctx)
+	isFilter := false
+	if lookup, ok := env.(runtime.FilterLookupContext); ok {
+		isFilter = lookup.HasFilter(name)
+	}
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		if attr := extractAttribute(item, name); attr != nil || !isFilter {
+			if attr == nil {
+				attr = item
+			}
+			result[i] = attr
+			continue
+		}
+		var mapped interface{}
+		var err error
+		if kwEnv, ok := env.(runtime.KeywordFilterContext); ok {
+			mapped, err = kwEnv.ApplyFilterWithContext(ctx, name, item, args[1:], nil)
+		} else {
+			mapped, err = env.ApplyFilter(name, item, args[1:]...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("map filter: %w", err)
+		}
+		result[i] = mapped
+	}
+	return result, nil
+}
+
+// registerItemFilters registers the forms of select, reject, selectattr,
+// rejectattr and map applied by environments, which look up the tests and
+// filters their arguments name in the environment applying them, and of attr
+// and getitem, whose missing results are undefined as the render's are
+func (r *FilterRegistry) registerItemFilters() {
+	type itemFilter struct {
+		byAttribute, keep bool
+	}
+	for name, f := range map[string]itemFilter{
+		"select":     {false, true},
+		"reject":     {false, false},
+		"selectattr": {true, true},
+		"rejectattr": {true, false},
+	} {
+		r.extended[name] = func(ctx runtime.Context, value interface{}, args []interface{}, _ map[string]interface{}) (interface{}, error) {
+			var tests TestApplier
+			if env := runtime.EnvironmentOf(ctx); env != nil {
+				tests = env.ApplyTest
+			}
+			return normalizeInput(func(value interface{}, args ...interface{}) (interface{}, error) {
+				return filterItems(name, value, args, f.byAttribute, f.keep, tests)
+			}, builtinInputs[name])(value, args...)
+		}
+	}
+	r.extended["map"] = func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return normalizeInput(func(value interface{}, args ...interface{}) (interface{}, error) {
+			return mapItems(ctx, value, args, kwargs)
+		}, builtinInputs["map"])(value, args...)
+	}
+
+	r.extended["attr"] = func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return normalizeInput(func(value interface{}, args ...interface{}) (interface{}, error) {
+			return lookupFilter(ctx, "attr", value, args, kwargs, attrPath)
+		}, builtinInputs["attr"])(value, args...)
+	}
+	r.extended["getitem"] = func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		return normalizeInput(func(value interface{}, args ...interface{}) (interface{}, error) {
+			return lookupFilter(ctx, "getitem", value, args, kwargs, func(value, key interface{}) (interface{}, bool) {
+				return runtime.LookupItem(value, key)
+			})
+		}, builtinInputs["getitem"])(value, args...)
+	}
+}
@@ -0,0 +1,61 @@
+package filters
+
+import (
+	"fmt"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// keywordParam is a parameter of a built-in filter that can be passed by
+// keyword, with the value it takes when left out
+type keywordParam struct {
+	name  string
+	value interface{}
+}
+
+// keywordParams lists, in order, the parameters of the built-in filters that
+// take their arguments by keyword as in Jinja2, e.g. round(method="floor")
+var keywordParams = map[string][]keywordParam{
+	"round":  {{"precision", 0}, {"method", "common"}},
+	"int":    {{"default", 0}, {"base", 10}},
+	"float":  {{"default", 0.0}},
+	"trim":   {{"chars", nil}},
+	"strip":  {{"chars", nil}},
+	"lstrip": {{"chars", nil}},
+	"rstrip": {{"chars", nil}},
+}
+
+// registerKeywordParams lets the filters in keywordParams be called with
+// keyword arguments, which are passed at the position of their parameter
+func (r *FilterRegistry) registerKeywordParams() {
+	for name, params := range keywordParams {
+		plain := r.filters[name]
+		r.extended[name] = func(_ runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if len(args) > len(params) {
+				return nil, fmt.Errorf("%s takes at most %d arguments, got %d", name, len(params), len(args))
+			}
+			if len(kwargs) == 0 {
+				return plain(value, args...)
+			}
+
+			positional := append([]interface{}{}, args...)
+			for keyword, arg := range kwargs {
+				i := 0
+				for i < len(params) && params[i].name != keyword {
+					i++
+				}
+				if i == len(params) {
+					return nil, fmt.Errorf("%s got an unexpected keyword argument '%s'", name, keyword)
+				}
+				if i < len(args) {
+					return nil, fmt.Errorf("%s got multiple values for argument '%s'", name, keyword)
+				}
+				for len(positional) <= i {
+					positional = append(positional, params[len(positional)].value)
+				}
+				positional[i] = arg
+			}
+			return plain(value, positional...)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package filters
+
+import (
+	"strings"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// markupFunc applies a filter the way Jinja2 does with Markup strings, where
+// autoescaping is on and the input or an argument is safe: the text that
+// isn't safe is escaped, and the result is safe. ok is false when no safe
+// value is involved, for the filter to run as usual.
+type markupFunc func(ctx runtime.Context, value interface{}, args []interface{}) (result interface{}, ok bool, err error)
+
+// markupFilters are the built-in filters that combine their input with other
+// text, so a safe part must not make the rest of the result safe, nor an
+// unsafe part get the safe parts escaped
+var markupFilters = map[string]markupFunc{
+	"join":    markupJoin,
+	"replace": markupReplace,
+	"format":  markupFormat,
+}
+
+// escapeMarkup returns the text of value, escaped for ctx unless it is safe
+func escapeMarkup(ctx runtime.Context, value interface{}) string {
+	if content, safe := unwrapSafe(value); safe {
+		return ToString(content)
+	}
+	return runtime.EscapeFor(ctx, ToString(value))
+}
+
+// markupJoin joins a sequence holding safe items, or with a safe separator,
+// escaping the other items and the separator unless it is safe
+func markupJoin(ctx runtime.Context, value interface{}, args []interface{}) (interface{}, bool, error) {
+	value, _ = unwrapSafe(value)
+	if items, ok := runtime.CollectionItems(value); ok {
+		value = items
+	}
+
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []string:
+		items = make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+	default:
+		return nil, false, nil
+	}
+
+	var separator interface{} = ""
+	if len(args) > 0 {
+		separator = args[0]
+	}
+	if len(args) > 1 {
+		attribute := ToString(args[1])
+		attributes := make([]interface{}, len(items))
+		for i, item := range items {
+			attributes[i] = extractAttribute(item, attribute)
+		}
+		items = attributes
+	}
+
+	_, safe := unwrapSafe(separator)
+	for _, item := range items {
+		if safe {
+			break
+		}
+		_, safe = unwrapSafe(item)
+	}
+	if !safe {
+		return nil, false, nil
+	}
+
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = escapeMarkup(ctx, item)
+	}
+	return runtime.SafeValue{Value: strings.Join(parts, escapeMarkup(ctx, separator))}, true, nil
+}
+
+// markupReplace replaces in a safe string, escaping the old and new text
+// unless they are safe. A string that isn't safe is escaped first when the
+// old or new text is safe.
+func markupReplace(ctx runtime.Context, value interface{}, args []interface{}) (interface{}, bool, error) {
+	if len(args) < 2 {
+		return nil, false, nil
+	}
+	_, safe := unwrapSafe(value)
+	_, oldSafe := unwrapSafe(args[0])
+	_, newSafe := unwrapSafe(args[1])
+	if !safe && !oldSafe && !newSafe {
+		return nil, false, nil
+	}
+
+	count := -1
+	if len(args) > 2 {
+		if c, err := ToInt(args[2]); err == nil {
+			count = c
+		}
+	}
+	s := strings.Replace(escapeMarkup(ctx, value), escapeMarkup(ctx, args[0]), escapeMarkup(ctx, args[1]), count)
+	return runtime.SafeValue{Value: s}, true, nil
+}
+
+// markupFormat formats the arguments into a safe format string, escaping
+// the arguments that aren't safe. Numbers and booleans are passed as they are,
+// so verbs like %d and %.2f still apply.
+func markupFormat(ctx runtime.Context, value interface{}, args []interface{}) (interface{}, bool, error) {
+	format, safe := unwrapSafe(value)
+	if !safe || len(args) == 0 {
+		return nil, false, nil
+	}
+
+	escaped := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+			escaped[i] = arg
+		default:
+			escaped[i] = escapeMarkup(ctx, arg)
+		}
+	}
+	result, err := FormatFilter(ToString(format), escaped...)
+	if err != nil {
+		return nil, true, err
+	}
+	return runtime.SafeValue{Value: result}, true, nil
+}
+
+// registerMarkupFilters gives join, replace and format their Markup
+// behaviour where autoescaping is on, falling back to the filters already
+// registered under those names
+func (r *FilterRegistry) registerMarkupFilters() {
+	for name, markup := range markupFilters {
+		plain := r.filters[name]
+		r.extended[name] = func(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			if ctx != nil && runtime.AutoescapeEnabled(ctx) {
+				if result, ok, err := markup(ctx, value, args); ok {
+					return result, err
+				}
+			}
+			return plain(value, args...)
+		}
+	}
+}
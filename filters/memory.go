@@ -0,0 +1,171 @@
+package filters
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// memoryCostFunc returns the bytes the result of a filter applied to value
+// with args takes
+type memoryCostFunc func(value interface{}, args []interface{}) int
+
+// memoryCosts lists the built-in filters whose result can be much larger
+// than their input, with the size of their result. Renders with a memory
+// limit charge it before the filter runs, so "x"|center(10**9) fails
+// without allocating a gigabyte.
+var memoryCosts = map[string]memoryCostFunc{
+	"center":    paddedCost,
+	"pad_left":  paddedCost,
+	"pad_right": paddedCost,
+	"indent":    indentCost,
+	"nindent":   indentCost,
+	"replace":   replaceCost,
+	"join":      joinCost,
+	"batch":     batchCost,
+	"list":      listCost,
+}
+
+// MemoryCost returns the bytes the result of the named filter, applied to
+// value with args, is expected to take, following aliases. It is 0 for
+// filters whose result is about the size of their input.
+func (r *FilterRegistry) MemoryCost(name string, value interface{}, args []interface{}) int {
+	r.mutex.RLock()
+	_, local := r.filters[name]
+	cost := r.costs[name]
+	target, isAlias := r.aliases[name]
+	r.mutex.RUnlock()
+
+	switch {
+	case cost != nil:
+		return cost(value, args)
+	case isAlias:
+		return r.MemoryCost(target, value, args)
+	case !local && r.parent != nil:
+		return r.parent.MemoryCost(name, value, args)
+	}
+	return 0
+}
+
+// registerMemoryCosts records the result sizes of the built-in filters
+func (r *FilterRegistry) registerMemoryCosts() {
+	for name, cost := range memoryCosts {
+		r.costs[name] = cost
+	}
+}
+
+// intArg returns args[i] as an int, or def when it is missing or not a number
+func intArg(args []interface{}, i, def int) int {
+	if i >= len(args) {
+		return def
+	}
+	n, err := ToInt(args[i])
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// stringArg returns args[i] as a string, or def when it is missing
+func stringArg(args []interface{}, i int, def string) string {
+	if i >= len(args) || args[i] == nil {
+		return def
+	}
+	return ToString(args[i])
+}
+
+// textLength returns the length of value as text, without converting
+// values other than strings
+func textLength(value interface{}) int {
+	value, _ = unwrapSafe(value)
+	if s, ok := value.(string); ok {
+		return len(s)
+	}
+	return 0
+}
+
+// sequenceLength returns the number of items of value, or 0 when it isn't
+// a sequence
+func sequenceLength(value interface{}) int {
+	value, _ = unwrapSafe(value)
+	switch v := value.(type) {
+	case []interface{}:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len()
+	}
+	return 0
+}
+
+// paddedCost is the size of center, pad_left and pad_right: width fill
+// characters at most, which may take several bytes each
+func paddedCost(value interface{}, args []interface{}) int {
+	fill := stringArg(args, 1, " ")
+	return textLength(value) + runtime.MemorySize(intArg(args, 0, 0), max(len(fill), 1))
+}
+
+// indentCost is the size of indent and nindent: the text with the indent
+// added to each line
+func indentCost(value interface{}, args []interface{}) int {
+	value, _ = unwrapSafe(value)
+	s, _ := value.(string)
+	indent := runtime.MemorySize(intArg(args, 0, 0), max(len(stringArg(args, 2, " ")), 1))
+	return len(s) + runtime.MemorySize(strings.Count(s, "\n")+1, indent)
+}
+
+// replaceCost is the size of replace: the text with each replaced
+// occurrence grown by the difference between the new and old strings
+func replaceCost(value interface{}, args []interface{}) int {
+	value, _ = unwrapSafe(value)
+	s, _ := value.(string)
+	old, replacement := stringArg(args, 0, ""), stringArg(args, 1, "")
+	if len(replacement) <= len(old) {
+		return len(s)
+	}
+	count := strings.Count(s, old)
+	if limit := intArg(args, 2, -1); limit >= 0 && limit < count {
+		count = limit
+	}
+	return len(s) + runtime.MemorySize(count, len(replacement)-len(old))
+}
+
+// joinCost is the size of join: the string items and a separator between
+// each two
+func joinCost(value interface{}, args []interface{}) int {
+	value, _ = unwrapSafe(value)
+	items, ok := value.([]interface{})
+	if !ok {
+		return 0
+	}
+	size := runtime.MemorySize(len(items)-1, len(stringArg(args, 0, "")))
+	for _, item := range items {
+		size += textLength(item)
+	}
+	return size
+}
+
+// batchCost is the size of batch, whose batches all hold size items
+func batchCost(value interface{}, args []interface{}) int {
+	size := intArg(args, 0, 0)
+	if size <= 0 {
+		return 0
+	}
+	n := sequenceLength(value)
+	if n == 0 {
+		return 0
+	}
+	batches := (n-1)/size + 1
+	return runtime.MemorySize(batches, runtime.MemorySize(size, runtime.MemoryItemSize))
+}
+
+// listCost is the size of list, an item for each item of a sequence or
+// character of a string
+func listCost(value interface{}, args []interface{}) int {
+	return runtime.MemorySize(sequenceLength(value), runtime.MemoryItemSize)
+}
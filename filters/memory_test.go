@@ -0,0 +1,61 @@
+package filters
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMemoryCost(t *testing.T) {
+	r := NewRegistry()
+	tests := []struct {
+		name  string
+		value interface{}
+		args  []interface{}
+		want  int
+	}{
+		{"center", "ab", []interface{}{10}, 12},
+		{"pad_left", "ab", []interface{}{10, "→"}, 32},
+		{"indent", "a\nb\nc", []interface{}{4}, 17},
+		{"replace", "abab", []interface{}{"a", "xyz"}, 8},
+		{"replace", "abab", []interface{}{"a", "xyz", 1}, 6},
+		{"replace", "abab", []interface{}{"ab", "x"}, 4},
+		{"join", []interface{}{"ab", "c", 1}, []interface{}{", "}, 7},
+		{"batch", []interface{}{1, 2, 3}, []interface{}{2}, 4 * 16},
+		{"batch", []interface{}{1, 2, 3}, []interface{}{math.MaxInt}, math.MaxInt},
+		{"list", "abc", nil, 3 * 16},
+		{"upper", "abc", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := r.MemoryCost(tt.name, tt.value, tt.args); got != tt.want {
+			t.Errorf("%s(%v, %v): got %d, want %d", tt.name, tt.value, tt.args, got, tt.want)
+		}
+	}
+
+	t.Run("Aliases", func(t *testing.T) {
+		if err := r.RegisterAlias("middle", "center"); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.MemoryCost("middle", "", []interface{}{8}); got != 8 {
+			t.Errorf("got %d", got)
+		}
+		if err := r.RegisterAlias("center", "upper"); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.MemoryCost("center", "", []interface{}{8}); got != 0 {
+			t.Errorf("alias replacing center: got %d", got)
+		}
+	})
+
+	t.Run("Replaced", func(t *testing.T) {
+		child := NewChildRegistry(NewRegistry())
+		if got := child.MemoryCost("join", []interface{}{"a", "b"}, []interface{}{"--"}); got != 4 {
+			t.Errorf("inherited join: got %d", got)
+		}
+		if err := child.Register("join", JoinFilter); err != nil {
+			t.Fatal(err)
+		}
+		if got := child.MemoryCost("join", []interface{}{"a", "b"}, nil); got != 0 {
+			t.Errorf("join registered on the child: got %d", got)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package filters
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"reflect"
@@ -50,10 +51,12 @@ func AbsFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 }
 
-// RoundFilter rounds a number to specified precision
+// RoundFilter rounds a number to specified precision. The method is common
+// (halves away from zero, the default), even (halves to the even digit, as
+// bankers round), ceil or floor.
 func RoundFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	precision := 0
-	method := "common" // common, ceil, floor
+	method := "common"
 
 	if len(args) > 0 {
 		p, err := ToInt(args[0])
@@ -65,6 +68,11 @@ func RoundFilter(value interface{}, args ...interface{}) (interface{}, error) {
 
 	if len(args) > 1 {
 		method = ToString(args[1])
+		switch method {
+		case "common", "even", "ceil", "floor":
+		default:
+			return nil, fmt.Errorf("round method must be 'common', 'even', 'ceil' or 'floor', not %q", method)
+		}
 	}
 
 	f, err := ToFloat(value)
@@ -72,17 +80,7 @@ func RoundFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("round filter requires a number: %v", err)
 	}
 
-	multiplier := math.Pow(10, float64(precision))
-
-	var result float64
-	switch method {
-	case "ceil":
-		result = math.Ceil(f*multiplier) / multiplier
-	case "floor":
-		result = math.Floor(f*multiplier) / multiplier
-	default: // common
-		result = math.Round(f*multiplier) / multiplier
-	}
+	result := roundDecimal(f, precision, method)
 
 	// If precision was specified, format as string to preserve decimal places
 	if len(args) > 0 && precision >= 0 {
@@ -98,7 +96,82 @@ func RoundFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	return result, nil
 }
 
-// IntFilter converts value to integer
+// roundDecimal rounds f to precision decimal places, or to tens, hundreds and
+// so on for a negative precision. Like Python, it rounds the binary value of
+// f, so 1.005, slightly less than it reads, rounds to 1.0.
+func roundDecimal(f float64, precision int, method string) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return f
+	}
+
+	// A float with binary exponent exp has at most 53-exp fractional binary
+	// digits, and as many decimal ones, so this is its exact value
+	_, exp := math.Frexp(f)
+	digits := strconv.FormatFloat(math.Abs(f), 'f', max(0, 53-exp), 64)
+	if strings.IndexByte(digits, '.') >= 0 {
+		digits = strings.TrimRight(strings.TrimRight(digits, "0"), ".")
+	}
+	point := len(digits)
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		digits, point = digits[:i]+digits[i+1:], i
+	}
+	keep := point + precision
+	if keep >= len(digits) {
+		return f
+	}
+	if keep < 0 {
+		digits = strings.Repeat("0", -keep) + digits
+		keep = 0
+	}
+	kept, dropped := digits[:keep], digits[keep:]
+
+	exact := strings.TrimRight(dropped, "0") == ""
+	up := false
+	switch method {
+	case "ceil":
+		up = f > 0 && !exact
+	case "floor":
+		up = f < 0 && !exact
+	case "even":
+		if dropped[0] == '5' && strings.TrimRight(dropped[1:], "0") == "" {
+			up = kept != "" && (kept[len(kept)-1]-'0')%2 == 1
+		} else {
+			up = dropped[0] > '5'
+		}
+	default:
+		up = dropped[0] >= '5'
+	}
+
+	if kept == "" {
+		kept = "0"
+	}
+	if up {
+		kept = incrementDigits(kept)
+	}
+	result, _ := strconv.ParseFloat(kept+"e"+strconv.Itoa(-precision), 64)
+	if f < 0 && result != 0 {
+		result = -result
+	}
+	return result
+}
+
+// incrementDigits adds one to the decimal number written by digits
+func incrementDigits(digits string) string {
+	b := []byte(digits)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
+
+// IntFilter converts value to integer. Strings are parsed in base, 10 by
+// default, and may have a 0x, 0o or 0b prefix matching it; base 0 takes the
+// base from the prefix. Strings holding a decimal number are truncated, and
+// values that can't be converted give default.
 func IntFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	defaultValue := 0
 	base := 10
@@ -116,6 +189,9 @@ func IntFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("int base must be integer: %v", err)
 		}
+		if b != 0 && (b < 2 || b > 36) {
+			return nil, fmt.Errorf("int base must be 0 or between 2 and 36, not %d", b)
+		}
 		base = b
 	}
 
@@ -127,21 +203,14 @@ func IntFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	case float32, float64:
 		return int64(reflect.ValueOf(v).Float()), nil
 	case string:
-		if v == "" {
-			return defaultValue, nil
+		if i, err := parseIntBase(v, base); err == nil {
+			return int(i), nil
 		}
-		if base == 10 {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				return defaultValue, nil
-			}
-			return i, nil
-		}
-		i, err := strconv.ParseInt(v, base, 64)
-		if err != nil {
-			return defaultValue, nil
+		// As in Jinja2, "42.23"|int gives 42
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return int(f), nil
 		}
-		return int(i), nil
+		return defaultValue, nil
 	case bool:
 		if v {
 			return 1, nil
@@ -152,7 +221,39 @@ func IntFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 }
 
-// FloatFilter converts value to float
+// integerPrefixes are the prefixes of integers written in bases other than 10
+var integerPrefixes = map[int]string{16: "0x", 8: "0o", 2: "0b"}
+
+// parseIntBase parses s the way Python's int(s, base) does: surrounding
+// whitespace, a sign and a prefix matching base are allowed, and base 0
+// takes the base from the prefix
+func parseIntBase(s string, base int) (int64, error) {
+	s = strings.TrimSpace(s)
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+
+	lower := strings.ToLower(s)
+	if base == 0 {
+		base = 10
+		for b, prefix := range integerPrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				base = b
+			}
+		}
+	}
+	if prefix, ok := integerPrefixes[base]; ok && strings.HasPrefix(lower, prefix) {
+		s = s[len(prefix):]
+	}
+	if s == "" || strings.ContainsAny(s[:1], "+-") {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+	return strconv.ParseInt(sign+s, base, 64)
+}
+
+// FloatFilter converts value to float. Values that can't be converted give
+// default.
 func FloatFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	defaultValue := 0.0
 
@@ -172,10 +273,7 @@ func FloatFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	case float32, float64:
 		return reflect.ValueOf(v).Float(), nil
 	case string:
-		if v == "" {
-			return defaultValue, nil
-		}
-		f, err := strconv.ParseFloat(v, 64)
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
 		if err != nil {
 			return defaultValue, nil
 		}
@@ -570,6 +668,8 @@ func ToFloat(value interface{}) (float64, error) {
 		return float64(v), nil
 	case float64:
 		return v, nil
+	case json.Number:
+		return v.Float64()
 	case string:
 		return strconv.ParseFloat(v, 64)
 	case bool:
@@ -0,0 +1,276 @@
+package filters
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// Defaults of the pprint filter's limits
+const (
+	defaultPPrintDepth    = 4
+	defaultPPrintMaxItems = 20
+	defaultPPrintWidth    = 80
+)
+
+// PPrintFilter formats value for debugging: maps with sorted keys, sequences
+// and structs with their field names, one element per line once a collection
+// is wider than the line width. Its positional arguments are verbose, depth
+// and max_items; see PPrintKeywordFilter.
+func PPrintFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	return PPrintKeywordFilter(value, args, nil)
+}
+
+// PPrintKeywordFilter is the pprint filter taking keyword arguments:
+// verbose=true shows the Go type of every value, depth (default 4) is how
+// many levels of nested collections are expanded, max_items (default 20) how
+// many elements of a collection are shown and width (default 80) the line
+// width collections are wrapped at. Collections that contain themselves are
+// printed as <cycle>.
+func PPrintKeywordFilter(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	p := &prettyPrinter{
+		depth:    defaultPPrintDepth,
+		maxItems: defaultPPrintMaxItems,
+		width:    defaultPPrintWidth,
+		visiting: make(map[prettyVisit]bool),
+	}
+
+	names := []string{"verbose", "depth", "max_items", "width"}
+	if len(args) > len(names) {
+		return nil, fmt.Errorf("pprint takes at most %d arguments, got %d", len(names), len(args))
+	}
+	options := make(map[string]interface{}, len(args)+len(kwargs))
+	for i, arg := range args {
+		options[names[i]] = arg
+	}
+	for name, arg := range kwargs {
+		options[name] = arg
+	}
+	for name, arg := range options {
+		var err error
+		switch name {
+		case "verbose":
+			p.verbose = ToBool(arg)
+		case "depth":
+			p.depth, err = ToInt(arg)
+		case "max_items":
+			p.maxItems, err = ToInt(arg)
+		case "width":
+			p.width, err = ToInt(arg)
+		default:
+			return nil, fmt.Errorf("pprint got an unexpected keyword argument '%s'", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pprint %s must be a number: %w", name, err)
+		}
+	}
+
+	if u, ok := value.(*runtime.Undefined); ok && u.Behavior == runtime.UndefinedStrict {
+		return nil, u.Error()
+	}
+	return p.format(value, 0, 0), nil
+}
+
+// prettyPrinter formats values for pprint
+type prettyPrinter struct {
+	verbose  bool
+	depth    int
+	maxItems int
+	width    int
+	visiting map[prettyVisit]bool // collections being formatted, for cycle detection
+}
+
+type prettyVisit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// format formats value nested level collections deep, on a line indented by
+// indent spaces
+func (p *prettyPrinter) format(value interface{}, level, indent int) string {
+	value, _ = unwrapSafe(value)
+	if value == nil {
+		return "none"
+	}
+	if _, ok := value.(*runtime.Undefined); ok {
+		return "undefined"
+	}
+
+	rv := reflect.ValueOf(value)
+	prefix := ""
+	if p.verbose {
+		prefix = "(" + rv.Type().String() + ") "
+	}
+
+	if d, ok := value.(*runtime.Dict); ok {
+		visit := prettyVisit{rv.Pointer(), rv.Type()}
+		if p.visiting[visit] {
+			return prefix + "<cycle>"
+		}
+		p.visiting[visit] = true
+		defer delete(p.visiting, visit)
+		return prefix + p.formatDict(d, level, indent)
+	}
+
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return prefix + "none"
+		}
+		if _, ok := rv.Interface().(fmt.Stringer); ok {
+			break
+		}
+		if rv.Kind() == reflect.Ptr {
+			visit := prettyVisit{rv.Pointer(), rv.Type()}
+			if p.visiting[visit] {
+				return prefix + "<cycle>"
+			}
+			p.visiting[visit] = true
+			defer delete(p.visiting, visit)
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return prefix + "none"
+		}
+		visit := prettyVisit{rv.Pointer(), rv.Type()}
+		if p.visiting[visit] {
+			return prefix + "<cycle>"
+		}
+		p.visiting[visit] = true
+		defer delete(p.visiting, visit)
+	}
+
+	if rv.CanInterface() {
+		if stringer, ok := rv.Interface().(fmt.Stringer); ok && rv.Kind() != reflect.Map && rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return prefix + stringer.String()
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return prefix + quotePPrint(rv.String())
+	case reflect.Bool:
+		return prefix + strconv.FormatBool(rv.Bool())
+	case reflect.Map:
+		return prefix + p.formatMap(rv, level, indent)
+	case reflect.Slice, reflect.Array:
+		items := make([]func(indent int) string, rv.Len())
+		for i := range items {
+			elem := rv.Index(i)
+			items[i] = func(indent int) string { return p.format(elem.Interface(), level+1, indent) }
+		}
+		return prefix + p.formatCollection("[", "]", items, level, indent)
+	case reflect.Struct:
+		return prefix + p.formatStruct(rv, level, indent)
+	case reflect.Func:
+		return prefix + "<function>"
+	}
+	return prefix + fmt.Sprintf("%v", rv.Interface())
+}
+
+func (p *prettyPrinter) formatMap(rv reflect.Value, level, indent int) string {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	verbose := p.verbose
+	p.verbose = false // keys are shown as they would be written
+	for iter.Next() {
+		entries = append(entries, entry{p.format(iter.Key().Interface(), level+1, 0), iter.Value()})
+	}
+	p.verbose = verbose
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	items := make([]func(indent int) string, len(entries))
+	for i, e := range entries {
+		items[i] = func(indent int) string { return e.key + ": " + p.format(e.value.Interface(), level+1, indent) }
+	}
+	return p.formatCollection("{", "}", items, level, indent)
+}
+
+// formatDict shows a dict made by the template as a map, with its keys in
+// the order they were added
+func (p *prettyPrinter) formatDict(d *runtime.Dict, level, indent int) string {
+	keys := d.Keys()
+	items := make([]func(indent int) string, len(keys))
+	for i, key := range keys {
+		value, _ := d.Get(key)
+		items[i] = func(indent int) string { return quotePPrint(key) + ": " + p.format(value, level+1, indent) }
+	}
+	return p.formatCollection("{", "}", items, level, indent)
+}
+
+// formatStruct shows the exported fields of a struct by name
+func (p *prettyPrinter) formatStruct(rv reflect.Value, level, indent int) string {
+	typ := rv.Type()
+	var items []func(indent int) string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := rv.Field(i)
+		items = append(items, func(indent int) string { return field.Name + ": " + p.format(value.Interface(), level+1, indent) })
+	}
+	return p.formatCollection(typ.Name()+"{", "}", items, level, indent)
+}
+
+// formatCollection lays out the items of a collection between open and
+// close, on one line if it fits and one item per line otherwise. Items beyond
+// the depth and item limits are elided.
+func (p *prettyPrinter) formatCollection(open, close string, items []func(indent int) string, level, indent int) string {
+	if len(items) == 0 {
+		return open + close
+	}
+	if level >= p.depth {
+		return open + "..." + close
+	}
+
+	more := 0
+	if p.maxItems > 0 && len(items) > p.maxItems {
+		more = len(items) - p.maxItems
+		items = items[:p.maxItems]
+	}
+
+	formatted := make([]string, 0, len(items)+1)
+	for _, item := range items {
+		formatted = append(formatted, item(indent+2))
+	}
+	if more > 0 {
+		formatted = append(formatted, fmt.Sprintf("... (+%d more)", more))
+	}
+
+	line := open + strings.Join(formatted, ", ") + close
+	if !strings.Contains(line, "\n") && indent+len(line) <= p.width {
+		return line
+	}
+
+	pad := strings.Repeat(" ", indent+2)
+	var sb strings.Builder
+	sb.WriteString(open)
+	for i, item := range formatted {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString("\n" + pad + item)
+	}
+	sb.WriteString("\n" + strings.Repeat(" ", indent) + close)
+	return sb.String()
+}
+
+// quotePPrint quotes s in single quotes, as template string literals are
+// commonly written
+func quotePPrint(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted[1:len(quoted)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(quoted, "'", `\'`) + "'"
+}
@@ -0,0 +1,120 @@
+package filters
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+type pprintUser struct {
+	Name   string
+	Tags   []string
+	Joined time.Time
+	secret string
+}
+
+func TestPPrintFilter(t *testing.T) {
+	numbers := make([]interface{}, 40)
+	for i := range numbers {
+		numbers[i] = i
+	}
+	self := map[string]interface{}{"name": "loop"}
+	self["self"] = self
+	user := &pprintUser{Name: "Ann", Tags: []string{"admin"}, Joined: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), secret: "x"}
+
+	tests := []struct {
+		name   string
+		value  interface{}
+		args   []interface{}
+		kwargs map[string]interface{}
+		want   string
+	}{
+		{
+			name:  "sorted keys and quoted strings",
+			value: map[string]interface{}{"b": "it's", "a": 1, "c": nil, "d": true},
+			want:  `{'a': 1, 'b': 'it\'s', 'c': none, 'd': true}`,
+		},
+		{
+			name: "wrapped at the line width",
+			value: map[string]interface{}{
+				"database": map[string]interface{}{"host": "db.internal.example.com", "port": 5432, "user": "report"},
+				"debug":    false,
+			},
+			want: "{\n  'database': {'host': 'db.internal.example.com', 'port': 5432, 'user': 'report'},\n  'debug': false\n}",
+		},
+		{
+			name:   "depth limit",
+			value:  map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1}}},
+			kwargs: map[string]interface{}{"depth": 1},
+			want:   `{'a': {...}}`,
+		},
+		{
+			name:  "default depth",
+			value: []interface{}{[]interface{}{[]interface{}{[]interface{}{[]interface{}{1}}}}},
+			want:  `[[[[[...]]]]]`,
+		},
+		{
+			name:   "item limit",
+			value:  numbers,
+			kwargs: map[string]interface{}{"max_items": 3},
+			want:   `[0, 1, 2, ... (+37 more)]`,
+		},
+		{
+			name:  "cycle",
+			value: self,
+			want:  `{'name': 'loop', 'self': <cycle>}`,
+		},
+		{
+			name:  "struct fields",
+			value: user,
+			want:  `pprintUser{Name: 'Ann', Tags: ['admin'], Joined: 2024-01-02 00:00:00 +0000 UTC}`,
+		},
+		{
+			name:  "verbose positional",
+			value: map[string]interface{}{"n": 1},
+			args:  []interface{}{true},
+			want:  `(map[string]interface {}) {'n': (int) 1}`,
+		},
+		{
+			name:  "undefined",
+			value: runtime.NewUndefined("nope", runtime.UndefinedSilent, nil),
+			want:  "undefined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := PPrintKeywordFilter(tt.value, tt.args, tt.kwargs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", result, tt.want)
+			}
+		})
+	}
+
+	t.Run("deterministic", func(t *testing.T) {
+		value := map[string]interface{}{}
+		for _, key := range strings.Split("q w e r t y u i o p", " ") {
+			value[key] = map[string]interface{}{key: key}
+		}
+		first, _ := PPrintFilter(value)
+		for i := 0; i < 20; i++ {
+			if again, _ := PPrintFilter(value); again != first {
+				t.Fatalf("output changed between calls:\n%s\n%s", first, again)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := PPrintKeywordFilter(1, nil, map[string]interface{}{"indent": 2}); err == nil {
+			t.Error("unknown keyword accepted")
+		}
+		if _, err := PPrintKeywordFilter(runtime.NewStrictUndefined("nope", nil), nil, nil); err == nil {
+			t.Error("strict undefined printed")
+		}
+	})
+}
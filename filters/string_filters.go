@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/zipreport/miya/runtime"
 )
 
 // Pre-compiled regex patterns for string filters (performance optimization)
@@ -16,19 +19,62 @@ var (
 	reRegexReplaceTest = regexp.MustCompile(`^/(.+)/([gimsuy]*)$`)
 )
 
-// titleCase converts a string to title case (capitalize first letter of each word).
-// This replaces the deprecated strings.Title function.
+// titleCase converts a string to title case the way Jinja2 does: words
+// start after whitespace, hyphens and opening brackets, and each word is
+// capitalized. An apostrophe doesn't start a word, so "bar's" becomes "Bar's".
 func titleCase(s string) string {
-	// Use a simple state machine to capitalize letters after spaces
-	prev := ' '
-	return strings.Map(func(r rune) rune {
-		if unicode.IsSpace(prev) {
-			prev = r
-			return unicode.ToTitle(r)
+	var b strings.Builder
+	b.Grow(len(s))
+	runes := []rune(s)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !isTitleSeparator(runes[i]) {
+			continue
+		}
+		if start < i {
+			capitalizeRunes(&b, runes[start:i])
+		}
+		if i < len(runes) {
+			b.WriteRune(runes[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+func isTitleSeparator(r rune) bool {
+	switch r {
+	case '-', '(', '{', '[', '<':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// capitalizeRunes writes runes with the first title cased and the rest lower
+// cased, like Python's str.capitalize
+func capitalizeRunes(b *strings.Builder, runes []rune) {
+	if len(runes) == 0 {
+		return
+	}
+	b.WriteRune(unicode.ToTitle(runes[0]))
+	for i := 1; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == 'İ':
+			// Python lower cases the dotted capital I to i and a combining
+			// dot, keeping the dot Go's simple case mapping drops
+			b.WriteString("i\u0307")
+		case r == 'Σ' && isFinalSigma(runes, i):
+			b.WriteRune('ς')
+		default:
+			b.WriteRune(unicode.ToLower(r))
 		}
-		prev = r
-		return r
-	}, s)
+	}
+}
+
+// isFinalSigma reports whether the capital sigma at runes[i] ends a word, so
+// it lower cases to the final form ς as in Python
+func isFinalSigma(runes []rune, i int) bool {
+	return i > 0 && unicode.IsLetter(runes[i-1]) && (i+1 == len(runes) || !unicode.IsLetter(runes[i+1]))
 }
 
 // capitalizeFirst capitalizes only the first letter of a string.
@@ -56,61 +102,81 @@ func LowerFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	return strings.ToLower(s), nil
 }
 
-// CapitalizeFilter capitalizes the first character
+// CapitalizeFilter capitalizes the first character and lower cases the rest
 func CapitalizeFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	s := ToString(value)
-	if len(s) == 0 {
-		return s, nil
-	}
-
-	runes := []rune(s)
-	runes[0] = unicode.ToUpper(runes[0])
-	for i := 1; i < len(runes); i++ {
-		runes[i] = unicode.ToLower(runes[i])
-	}
-
-	return string(runes), nil
+	var b strings.Builder
+	b.Grow(len(s))
+	capitalizeRunes(&b, []rune(s))
+	return b.String(), nil
 }
 
-// TitleFilter converts string to title case
+// TitleFilter capitalizes each word and lower cases the rest
 func TitleFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	s := ToString(value)
 	return titleCase(s), nil
 }
 
-// TrimFilter removes leading and trailing whitespace
+// TrimFilter removes leading and trailing whitespace, or any of the
+// characters given as argument
 func TrimFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	s := ToString(value)
-	if len(args) > 0 {
-		// Trim specific characters
-		chars := ToString(args[0])
+	if chars, ok := trimChars(args); ok {
 		return strings.Trim(s, chars), nil
 	}
 	return strings.TrimSpace(s), nil
 }
 
-// LstripFilter removes leading whitespace
+// LstripFilter removes leading whitespace, or any of the characters given
+// as argument
 func LstripFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	s := ToString(value)
-	if len(args) > 0 {
-		// Trim specific characters from left
-		chars := ToString(args[0])
+	if chars, ok := trimChars(args); ok {
 		return strings.TrimLeft(s, chars), nil
 	}
 	return strings.TrimLeftFunc(s, unicode.IsSpace), nil
 }
 
-// RstripFilter removes trailing whitespace
+// RstripFilter removes trailing whitespace, or any of the characters given
+// as argument
 func RstripFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	s := ToString(value)
-	if len(args) > 0 {
-		// Trim specific characters from right
-		chars := ToString(args[0])
+	if chars, ok := trimChars(args); ok {
 		return strings.TrimRight(s, chars), nil
 	}
 	return strings.TrimRightFunc(s, unicode.IsSpace), nil
 }
 
+// trimChars returns the characters to strip passed to trim, lstrip or
+// rstrip. None or an undefined value strips whitespace, as in Jinja2.
+func trimChars(args []interface{}) (string, bool) {
+	if len(args) == 0 || args[0] == nil {
+		return "", false
+	}
+	if _, undefined := args[0].(*runtime.Undefined); undefined {
+		return "", false
+	}
+	return ToString(args[0]), true
+}
+
+// RemoveprefixFilter removes the prefix given as argument once, if the
+// string starts with it
+func RemoveprefixFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("removeprefix filter requires prefix argument")
+	}
+	return strings.TrimPrefix(ToString(value), ToString(args[0])), nil
+}
+
+// RemovesuffixFilter removes the suffix given as argument once, if the
+// string ends with it
+func RemovesuffixFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("removesuffix filter requires suffix argument")
+	}
+	return strings.TrimSuffix(ToString(value), ToString(args[0])), nil
+}
+
 // ReplaceFilter replaces occurrences of old with new
 func ReplaceFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	if len(args) < 2 {
@@ -167,13 +233,15 @@ func TruncateFilter(value interface{}, args ...interface{}) (interface{}, error)
 	}
 
 	// Try to break at word boundary
-	truncated := string(runes[:length])
-	lastSpace := strings.LastIndex(truncated, " ")
-	if lastSpace > 0 && lastSpace > length/2 {
-		truncated = truncated[:lastSpace]
+	truncated := runes[:length]
+	for i := length - 1; i > length/2; i-- {
+		if truncated[i] == ' ' {
+			truncated = truncated[:i]
+			break
+		}
 	}
 
-	return truncated + end, nil
+	return string(truncated) + end, nil
 }
 
 // WordwrapFilter wraps words at specified width
@@ -267,8 +335,9 @@ func CenterFilter(value interface{}, args ...interface{}) (interface{}, error) {
 		return s, nil
 	}
 
+	// Like Python's str.center, odd padding goes left when width is odd
 	padding := width - sLen
-	leftPad := padding / 2
+	leftPad := padding/2 + padding&width&1
 	rightPad := padding - leftPad
 
 	result := strings.Repeat(fillchar, leftPad) + s + strings.Repeat(fillchar, rightPad)
@@ -334,8 +403,17 @@ func FormatFilter(value interface{}, args ...interface{}) (result interface{}, e
 		}
 	}()
 
+	// Durations format as they render, e.g. 2h30m
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if d, ok := arg.(time.Duration); ok {
+			arg = runtime.FormatDuration(d)
+		}
+		values[i] = arg
+	}
+
 	// Use the string as format and args as values
-	return fmt.Sprintf(s, args...), nil
+	return fmt.Sprintf(s, values...), nil
 }
 
 // Helper function to convert regex patterns for case-insensitive matching
@@ -576,17 +654,21 @@ func PadRightFilter(value interface{}, args ...interface{}) (interface{}, error)
 	return s + strings.Repeat(fillchar, padding), nil
 }
 
-// WordcountFilter counts words in a string
+// WordcountFilter counts words in a string. As in Jinja2, a word is a run
+// of letters, digits and underscores, so "don't" and "well-known" are two
+// words each and text without spaces, like Chinese or Japanese, counts as
+// one word per run.
 func WordcountFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	s := ToString(value)
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, nil
+	count := 0
+	inWord := false
+	for _, r := range ToString(value) {
+		isWordRune := unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_'
+		if isWordRune && !inWord {
+			count++
+		}
+		inWord = isWordRune
 	}
-
-	// Split on whitespace and count non-empty parts
-	words := strings.Fields(s)
-	return len(words), nil
+	return count, nil
 }
 
 // Helper function to extract regex flags from string
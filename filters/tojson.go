@@ -0,0 +1,230 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// ToJSONFilter converts value to JSON with encoding/json, so structs follow
+// their json tags and times are written in RFC 3339. <, >, &, ' and the line
+// separators U+2028 and U+2029 are written as \u escapes, and the result is a
+// SafeValue, so it can be placed in a <script> block or an HTML attribute as
+// is, like Jinja2's tojson. The optional argument is the indent.
+func ToJSONFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	return ToJSONKeywordFilter(value, args, nil)
+}
+
+// ToJSONKeywordFilter is the tojson filter taking its indent as a keyword
+// argument, as in {{ data|tojson(indent=2) }}
+func ToJSONKeywordFilter(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	indent := 0
+	indentArg, ok := kwargs["indent"]
+	if !ok && len(args) > 0 {
+		indentArg, ok = args[0], true
+	}
+	if ok && indentArg != nil {
+		i, err := ToInt(indentArg)
+		if err == nil && i > 0 {
+			indent = i
+		}
+	}
+
+	value, err := jsonValue(value, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	if indent > 0 {
+		encoder.SetIndent("", strings.Repeat(" ", indent))
+	}
+	if err := encoder.Encode(value); err != nil {
+		return nil, jsonError(value, err)
+	}
+
+	// The encoder escapes <, >, &, U+2028 and U+2029 already
+	data := strings.ReplaceAll(strings.TrimSuffix(buf.String(), "\n"), "'", `\u0027`)
+	return runtime.SafeValue{Value: data}, nil
+}
+
+// jsonValue prepares the maps and sequences templates build for encoding:
+// safe values are unwrapped, undefined values become null and map keys
+// strings. Go maps are written with their keys sorted, dicts made by the
+// template with their keys in order. Structs and other values are left to encoding/json. path is the
+// position of value in the filtered value, for errors.
+func jsonValue(value interface{}, path string) (interface{}, error) {
+	value, _ = unwrapSafe(value)
+	if u, ok := value.(*runtime.Undefined); ok {
+		if u.Behavior == runtime.UndefinedStrict {
+			return nil, u.Error()
+		}
+		return nil, nil // encoded as null
+	}
+	if value == nil {
+		return nil, nil
+	}
+	// A dict made by the template is written with its keys in order
+	if d, ok := value.(*runtime.Dict); ok {
+		result := runtime.NewDict()
+		for _, key := range d.Keys() {
+			elem, _ := d.Get(key)
+			elem, err := jsonValue(elem, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			result.Set(key, elem)
+		}
+		return result, nil
+	}
+	if _, ok := value.(json.Marshaler); ok {
+		return value, nil
+	}
+	if items, ok := runtime.CollectionItems(value); ok {
+		value = items
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		result := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, ok := jsonKey(iter.Key())
+			if !ok {
+				return nil, unsupportedJSON(iter.Key().Type(), path, " key")
+			}
+			elem, err := jsonValue(iter.Value().Interface(), path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = elem
+		}
+		return result, nil
+
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && (rv.IsNil() || rv.Type().Elem().Kind() == reflect.Uint8) {
+			return value, nil // nil slices are null and byte slices base64
+		}
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			elem, err := jsonValue(rv.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = elem
+		}
+		return result, nil
+
+	case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, unsupportedJSON(rv.Type(), path, "")
+	}
+	return value, nil
+}
+
+// jsonKey converts a map key to the string it is written as, as
+// encoding/json does, with floats and bools allowed too
+func jsonKey(key reflect.Value) (string, bool) {
+	for key.Kind() == reflect.Interface && !key.IsNil() {
+		key = key.Elem()
+	}
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(key.Float(), 'g', -1, 64), true
+	case reflect.Bool:
+		return strconv.FormatBool(key.Bool()), true
+	}
+	return "", false
+}
+
+// jsonError explains an encoding failure of value, finding the path of the
+// value encoding/json rejected where it can
+func jsonError(value interface{}, err error) error {
+	if typ, path, ok := findUnsupportedJSON(reflect.ValueOf(value), ""); ok {
+		return unsupportedJSON(typ, path, "")
+	}
+	return runtime.NewFilterError("tojson", err, nil)
+}
+
+func unsupportedJSON(typ reflect.Type, path, what string) error {
+	location := ""
+	if path != "" {
+		location = " at " + strings.TrimPrefix(path, ".")
+	}
+	return runtime.NewFilterError("tojson", fmt.Errorf("cannot encode %s%s as JSON%s", typ, what, location), nil)
+}
+
+// findUnsupportedJSON finds a value encoding/json cannot encode within rv,
+// naming struct fields as they are encoded
+func findUnsupportedJSON(rv reflect.Value, path string) (reflect.Type, string, bool) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, "", false
+		}
+		rv = rv.Elem()
+	}
+	if rv.CanInterface() {
+		if _, ok := rv.Interface().(json.Marshaler); ok {
+			return nil, "", false
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return rv.Type(), path, true
+	case reflect.Float32, reflect.Float64:
+		if f := rv.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return rv.Type(), path, true
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, _ := jsonKey(iter.Key())
+			if typ, found, ok := findUnsupportedJSON(iter.Value(), path+"."+key); ok {
+				return typ, found, true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if typ, found, ok := findUnsupportedJSON(rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); ok {
+				return typ, found, true
+			}
+		}
+	case reflect.Struct:
+		typ := rv.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fieldPath := path + "." + name
+			if field.Anonymous && field.Tag.Get("json") == "" {
+				fieldPath = path // promoted fields
+			}
+			if found, at, ok := findUnsupportedJSON(rv.Field(i), fieldPath); ok {
+				return found, at, true
+			}
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,114 @@
+package filters
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+type jsonAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type jsonUser struct {
+	Name     string            `json:"name"`
+	Password string            `json:"-"`
+	Joined   time.Time         `json:"joined"`
+	Address  *jsonAddress      `json:"address"`
+	Hooks    map[string]func() `json:"hooks,omitempty"`
+	Plain    int
+	internal string
+}
+
+func TestToJSONFilter(t *testing.T) {
+	joined := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  interface{}
+		args   []interface{}
+		kwargs map[string]interface{}
+		want   string
+	}{
+		{
+			name:  "struct tags",
+			value: jsonUser{Name: "Ann", Password: "secret", Joined: joined, Address: &jsonAddress{City: "Oslo"}, Plain: 1, internal: "x"},
+			want:  `{"name":"Ann","joined":"2024-03-01T09:30:00Z","address":{"city":"Oslo"},"Plain":1}`,
+		},
+		{
+			name:  "time",
+			value: map[string]interface{}{"at": joined},
+			want:  `{"at":"2024-03-01T09:30:00Z"}`,
+		},
+		{
+			name:  "html safe",
+			value: "</script><script>alert('x & y')</script>\u2028\u2029",
+			want:  `"\u003c/script\u003e\u003cscript\u003ealert(\u0027x \u0026 y\u0027)\u003c/script\u003e\u2028\u2029"`,
+		},
+		{
+			name:  "template values",
+			value: map[interface{}]interface{}{1: runtime.SafeValue{Value: "<b>"}, "u": runtime.NewUndefined("u", runtime.UndefinedSilent, nil)},
+			want:  `{"1":"\u003cb\u003e","u":null}`,
+		},
+		{
+			name:   "indent keyword",
+			value:  []interface{}{1},
+			kwargs: map[string]interface{}{"indent": 2},
+			want:   "[\n  1\n]",
+		},
+		{
+			name:  "indent positional",
+			value: map[string]interface{}{"a": 1},
+			args:  []interface{}{4},
+			want:  "{\n    \"a\": 1\n}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ToJSONKeywordFilter(tt.value, tt.args, tt.kwargs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			safe, ok := result.(runtime.SafeValue)
+			if !ok {
+				t.Fatalf("expected a SafeValue, got %T", result)
+			}
+			if safe.Value != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", safe.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestToJSONFilterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"function in a map", map[string]interface{}{"items": []interface{}{1, map[string]interface{}{"cb": func() {}}}}, "cannot encode func() as JSON at items[1].cb"},
+		{"function in a struct", jsonUser{Hooks: map[string]func(){"save": func() {}}}, "cannot encode func() as JSON at hooks.save"},
+		{"channel", make(chan int), "cannot encode chan int as JSON"},
+		{"NaN", map[string]interface{}{"ratio": math.NaN()}, "cannot encode float64 as JSON at ratio"},
+		{"map key", map[[2]int]string{{1, 2}: "x"}, "cannot encode [2]int key as JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToJSONFilter(tt.value)
+			var runtimeErr *runtime.RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeFilter {
+				t.Fatalf("expected a FilterError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
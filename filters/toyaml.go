@@ -0,0 +1,514 @@
+package filters
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// defaultYAMLIndent is how many spaces toyaml indents each nesting level by
+const defaultYAMLIndent = 2
+
+// ToYAMLFilter converts value to block style YAML: map keys sorted, strings
+// quoted wherever YAML would read them as something else, and multi-line
+// strings written as literal blocks. Structs use their yaml or json tags.
+// The result is a SafeValue, since YAML isn't HTML. The optional argument is
+// the indent.
+func ToYAMLFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	return ToYAMLKeywordFilter(value, args, nil)
+}
+
+// ToYAMLKeywordFilter is the toyaml filter taking its indent as a keyword
+// argument, as in {{ values|toyaml(indent=4) }}
+func ToYAMLKeywordFilter(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	indent := defaultYAMLIndent
+	indentArg, ok := kwargs["indent"]
+	if !ok && len(args) > 0 {
+		indentArg, ok = args[0], true
+	}
+	if ok && indentArg != nil {
+		i, err := ToInt(indentArg)
+		if err != nil {
+			return nil, fmt.Errorf("toyaml indent must be a number: %w", err)
+		}
+		if i > 0 {
+			indent = i
+		}
+	}
+
+	b := &yamlBuilder{visiting: make(map[prettyVisit]bool)}
+	node, err := b.node(reflect.ValueOf(value), "")
+	if err != nil {
+		return nil, err
+	}
+	w := &yamlWriter{indent: indent}
+	w.writeTop(node)
+	return runtime.SafeValue{Value: w.sb.String()}, nil
+}
+
+// NIndentFilter indents every line of value by width spaces after starting it
+// on a new line, for placing toyaml output under a key as in Helm:
+// {{ values.resources|toyaml|nindent(4) }}. Empty lines are left empty.
+func NIndentFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("nindent filter requires width argument")
+	}
+	width, err := ToInt(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("nindent width must be integer: %v", err)
+	}
+
+	prefix := strings.Repeat(" ", max(width, 0))
+	lines := strings.Split(ToString(value), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return "\n" + strings.Join(lines, "\n"), nil
+}
+
+// yamlNode is a value prepared for writing: a scalar, a map or a sequence
+type yamlNode struct {
+	kind    yamlKind
+	scalar  string   // Written form of a scalar, or the header of a literal block
+	lines   []string // Lines of a literal block scalar
+	entries []yamlEntry
+	items   []yamlNode
+}
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlMap
+	yamlSeq
+)
+
+type yamlEntry struct {
+	key   string // Written form of the key
+	value yamlNode
+}
+
+// yamlBuilder converts values to yamlNodes
+type yamlBuilder struct {
+	visiting map[prettyVisit]bool // collections being converted, for cycle detection
+}
+
+// node converts rv, found at path in the filtered value, to a yamlNode
+func (b *yamlBuilder) node(rv reflect.Value, path string) (yamlNode, error) {
+	for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return yamlNull(), nil
+		}
+		if rv.Kind() == reflect.Ptr {
+			if _, ok := rv.Interface().(*runtime.Undefined); ok {
+				break
+			}
+			visit := prettyVisit{rv.Pointer(), rv.Type()}
+			if b.visiting[visit] {
+				return yamlNode{}, yamlCycle(path)
+			}
+			b.visiting[visit] = true
+			defer delete(b.visiting, visit)
+			if d, ok := rv.Interface().(*runtime.Dict); ok {
+				return b.dictNode(d, path)
+			}
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return yamlNull(), nil
+	}
+
+	if rv.CanInterface() {
+		switch v := rv.Interface().(type) {
+		case *runtime.Undefined:
+			if v.Behavior == runtime.UndefinedStrict {
+				return yamlNode{}, v.Error()
+			}
+			return yamlNull(), nil
+		case runtime.SafeValue, SafeValue, runtime.SafeString:
+			value, _ := unwrapSafe(v)
+			return b.node(reflect.ValueOf(value), path)
+		case json.Number:
+			return yamlNode{kind: yamlScalar, scalar: v.String()}, nil
+		case encoding.TextMarshaler:
+			text, err := v.MarshalText()
+			if err != nil {
+				return yamlNode{}, runtime.NewFilterError("toyaml", err, nil)
+			}
+			return yamlStringNode(string(text)), nil
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return yamlStringNode(rv.String()), nil
+	case reflect.Bool:
+		return yamlNode{kind: yamlScalar, scalar: strconv.FormatBool(rv.Bool())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return yamlNode{kind: yamlScalar, scalar: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return yamlNode{kind: yamlScalar, scalar: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return yamlNode{kind: yamlScalar, scalar: yamlFloat(rv.Float())}, nil
+	case reflect.Map:
+		return b.mapNode(rv, path)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return yamlNull(), nil
+		}
+		if rv.Kind() == reflect.Slice {
+			visit := prettyVisit{rv.Pointer(), rv.Type()}
+			if b.visiting[visit] && rv.Len() > 0 {
+				return yamlNode{}, yamlCycle(path)
+			}
+			b.visiting[visit] = true
+			defer delete(b.visiting, visit)
+		}
+		node := yamlNode{kind: yamlSeq, items: make([]yamlNode, rv.Len())}
+		for i := range node.items {
+			item, err := b.node(rv.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return yamlNode{}, err
+			}
+			node.items[i] = item
+		}
+		return node, nil
+	case reflect.Struct:
+		node := yamlNode{kind: yamlMap}
+		if err := b.structEntries(rv, path, &node); err != nil {
+			return yamlNode{}, err
+		}
+		return node, nil
+	}
+	return yamlNode{}, unsupportedYAML(rv.Type(), path, "")
+}
+
+// mapNode converts a map, sorting its entries by key
+func (b *yamlBuilder) mapNode(rv reflect.Value, path string) (yamlNode, error) {
+	if rv.IsNil() {
+		return yamlNull(), nil
+	}
+	visit := prettyVisit{rv.Pointer(), rv.Type()}
+	if b.visiting[visit] {
+		return yamlNode{}, yamlCycle(path)
+	}
+	b.visiting[visit] = true
+	defer delete(b.visiting, visit)
+
+	type keyed struct {
+		key      string
+		isString bool
+		value    reflect.Value
+	}
+	pairs := make([]keyed, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, ok := jsonKey(iter.Key())
+		if !ok {
+			return yamlNode{}, unsupportedYAML(iter.Key().Type(), path, " key")
+		}
+		keyValue := iter.Key()
+		for keyValue.Kind() == reflect.Interface {
+			keyValue = keyValue.Elem()
+		}
+		pairs = append(pairs, keyed{key, keyValue.Kind() == reflect.String, iter.Value()})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+	node := yamlNode{kind: yamlMap, entries: make([]yamlEntry, len(pairs))}
+	for i, pair := range pairs {
+		value, err := b.node(pair.value, path+"."+pair.key)
+		if err != nil {
+			return yamlNode{}, err
+		}
+		node.entries[i] = yamlEntry{yamlKey(pair.key, pair.isString), value}
+	}
+	return node, nil
+}
+
+// dictNode converts a dict made by the template, keeping its keys in the
+// order they were added
+func (b *yamlBuilder) dictNode(d *runtime.Dict, path string) (yamlNode, error) {
+	keys := d.Keys()
+	node := yamlNode{kind: yamlMap, entries: make([]yamlEntry, len(keys))}
+	for i, key := range keys {
+		value, _ := d.Get(key)
+		child, err := b.node(reflect.ValueOf(value), path+"."+key)
+		if err != nil {
+			return yamlNode{}, err
+		}
+		node.entries[i] = yamlEntry{yamlKey(key, true), child}
+	}
+	return node, nil
+}
+
+// structEntries adds the exported fields of a struct to node, named by their
+// yaml or json tags, with the fields of untagged embedded structs promoted
+func (b *yamlBuilder) structEntries(rv reflect.Value, path string, node *yamlNode) error {
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		// Embedded structs of unexported types still promote their fields
+		if !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("yaml")
+		if !ok {
+			tag = field.Tag.Get("json")
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+
+		value := rv.Field(i)
+		if field.Anonymous && name == "" {
+			embedded := value
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := b.structEntries(embedded, path, node); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.Contains(options, "omitempty") && value.IsZero() {
+			continue
+		}
+
+		child, err := b.node(value, path+"."+name)
+		if err != nil {
+			return err
+		}
+		node.entries = append(node.entries, yamlEntry{yamlKey(name, true), child})
+	}
+	return nil
+}
+
+func yamlNull() yamlNode {
+	return yamlNode{kind: yamlScalar, scalar: "null"}
+}
+
+// yamlFloat writes f so that it reads back as a float, or as the integer it
+// is equal to
+func yamlFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return ".nan"
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// yamlStringNode converts a string to a plain or quoted scalar, or to a
+// literal block if it spans lines
+func yamlStringNode(s string) yamlNode {
+	if lines, header, ok := yamlLiteral(s); ok {
+		return yamlNode{kind: yamlScalar, scalar: header, lines: lines}
+	}
+	return yamlNode{kind: yamlScalar, scalar: yamlString(s)}
+}
+
+// yamlKey writes a map key, quoting string keys that need it
+func yamlKey(key string, isString bool) string {
+	if isString {
+		return yamlString(key)
+	}
+	return key
+}
+
+// yamlLiteral splits a multi-line string into the lines of a literal block
+// with the chomping header that keeps its trailing newline or its absence.
+// ok is false for strings a literal block can't hold exactly.
+func yamlLiteral(s string) (lines []string, header string, ok bool) {
+	body, trailing := strings.CutSuffix(s, "\n")
+	if !strings.Contains(body, "\n") || strings.HasSuffix(body, "\n") {
+		return nil, "", false
+	}
+	if strings.HasPrefix(body, " ") || strings.HasPrefix(body, "\t") {
+		return nil, "", false // would need an indentation indicator
+	}
+	for _, r := range body {
+		if r != '\n' && r != '\t' && !unicode.IsPrint(r) {
+			return nil, "", false
+		}
+	}
+	header = "|-"
+	if trailing {
+		header = "|"
+	}
+	return strings.Split(body, "\n"), header, true
+}
+
+var (
+	// yamlSexagesimal matches what YAML 1.1 reads as base 60 numbers, such
+	// as the port mapping 22:22
+	yamlSexagesimal = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(:[0-5]?[0-9])+(\.[0-9_]*)?$`)
+	// yamlDate matches the start of YAML timestamps
+	yamlDate = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+)
+
+// yamlReserved are the plain scalars YAML 1.1 or 1.2 read as booleans, null
+// or special floats, compared in lower case
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true,
+	"null": true, "~": true, ".inf": true, "-.inf": true, "+.inf": true, ".nan": true,
+}
+
+// yamlString writes s as a plain scalar if YAML reads it back as the same
+// string, and double-quoted otherwise
+func yamlString(s string) string {
+	if yamlNeedsQuotes(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func yamlNeedsQuotes(s string) bool {
+	if s == "" || yamlReserved[strings.ToLower(s)] {
+		return true
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(s[0])) {
+		return true
+	}
+	if strings.TrimSpace(s) != s || strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+	return yamlSexagesimal.MatchString(s) || yamlDate.MatchString(s)
+}
+
+func yamlCycle(path string) error {
+	location := ""
+	if path != "" {
+		location = " at " + strings.TrimPrefix(path, ".")
+	}
+	return runtime.NewFilterError("toyaml", fmt.Errorf("cannot encode a value containing itself as YAML%s", location), nil)
+}
+
+func unsupportedYAML(typ reflect.Type, path, what string) error {
+	location := ""
+	if path != "" {
+		location = " at " + strings.TrimPrefix(path, ".")
+	}
+	return runtime.NewFilterError("toyaml", fmt.Errorf("cannot encode %s%s as YAML%s", typ, what, location), nil)
+}
+
+// yamlWriter writes yamlNodes in block style
+type yamlWriter struct {
+	indent int
+	sb     strings.Builder
+}
+
+func (w *yamlWriter) writeTop(node yamlNode) {
+	switch {
+	case node.kind == yamlMap && len(node.entries) > 0:
+		w.writeMap(node.entries, 0, false)
+	case node.kind == yamlSeq && len(node.items) > 0:
+		w.writeSeq(node.items, 0, false)
+	default:
+		w.writeScalar(node, w.indent)
+	}
+}
+
+// newline starts a line indented to col
+func (w *yamlWriter) newline(col int) {
+	if w.sb.Len() > 0 {
+		w.sb.WriteByte('\n')
+	}
+	w.sb.WriteString(strings.Repeat(" ", col))
+}
+
+// writeMap writes entries at col. With inline set the first entry continues
+// the current line, after the "- " of a sequence item.
+func (w *yamlWriter) writeMap(entries []yamlEntry, col int, inline bool) {
+	for i, entry := range entries {
+		if i > 0 || !inline {
+			w.newline(col)
+		}
+		w.sb.WriteString(entry.key + ":")
+		switch value := entry.value; {
+		case value.kind == yamlMap && len(value.entries) > 0:
+			w.writeMap(value.entries, col+w.indent, false)
+		case value.kind == yamlSeq && len(value.items) > 0:
+			w.writeSeq(value.items, col+w.indent, false)
+		default:
+			w.sb.WriteByte(' ')
+			w.writeScalar(value, col+w.indent)
+		}
+	}
+}
+
+// writeSeq writes items at col, continuing the current line with the first
+// item if inline is set
+func (w *yamlWriter) writeSeq(items []yamlNode, col int, inline bool) {
+	for i, item := range items {
+		if i > 0 || !inline {
+			w.newline(col)
+		}
+		w.sb.WriteString("- ")
+		switch {
+		case item.kind == yamlMap && len(item.entries) > 0:
+			w.writeMap(item.entries, col+2, true)
+		case item.kind == yamlSeq && len(item.items) > 0:
+			w.writeSeq(item.items, col+2, true)
+		default:
+			w.writeScalar(item, col+w.indent)
+		}
+	}
+}
+
+// writeScalar writes a scalar, or an empty collection in flow style, with the
+// lines of a literal block indented to col
+func (w *yamlWriter) writeScalar(node yamlNode, col int) {
+	switch node.kind {
+	case yamlMap:
+		w.sb.WriteString("{}")
+		return
+	case yamlSeq:
+		w.sb.WriteString("[]")
+		return
+	}
+	w.sb.WriteString(node.scalar)
+	for _, line := range node.lines {
+		if line == "" {
+			w.sb.WriteByte('\n')
+			continue
+		}
+		w.newline(col)
+		w.sb.WriteString(line)
+	}
+}
@@ -0,0 +1,233 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+type yamlMeta struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type yamlPort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort"`
+	Protocol      string `yaml:"protocol,omitempty"`
+}
+
+type yamlContainer struct {
+	yamlMeta
+	Image   string     `json:"image"`
+	Ports   []yamlPort `json:"ports"`
+	Secret  string     `json:"-"`
+	Started time.Time  `json:"started"`
+}
+
+func TestToYAMLFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		args   []interface{}
+		kwargs map[string]interface{}
+		want   string
+	}{
+		{
+			name: "nested maps and sequences",
+			value: map[string]interface{}{
+				"resources": map[string]interface{}{
+					"limits":   map[string]interface{}{"cpu": "500m", "memory": "128Mi"},
+					"requests": map[string]interface{}{"cpu": 0.25},
+				},
+				"args":     []interface{}{"--port", 8080, []interface{}{"a", "b"}},
+				"env":      []interface{}{map[string]interface{}{"name": "MODE", "value": "prod"}},
+				"empty":    map[string]interface{}{},
+				"none":     []interface{}{},
+				"optional": nil,
+			},
+			want: `args:
+  - "--port"
+  - 8080
+  - - a
+    - b
+empty: {}
+env:
+  - name: MODE
+    value: prod
+none: []
+optional: null
+resources:
+  limits:
+    cpu: 500m
+    memory: 128Mi
+  requests:
+    cpu: 0.25`,
+		},
+		{
+			name: "strings that need quotes",
+			value: map[string]interface{}{
+				"colon":   "host: db",
+				"comment": "a #b",
+				"bool":    "true",
+				"yes":     "Yes",
+				"number":  "8080",
+				"float":   "1e3",
+				"octal":   "0755",
+				"ports":   "22:22",
+				"date":    "2024-05-06",
+				"star":    "*.example.com",
+				"dash":    "-x",
+				"space":   " padded",
+				"empty":   "",
+				"quote":   `say "hi"`,
+				"tab":     "a\tb",
+				"plain":   "http://example.com/a:b",
+				"":        "empty key",
+				"key: x":  "odd key",
+			},
+			want: `"": empty key
+bool: "true"
+colon: "host: db"
+comment: "a #b"
+dash: "-x"
+date: "2024-05-06"
+empty: ""
+float: "1e3"
+"key: x": odd key
+number: "8080"
+octal: "0755"
+plain: http://example.com/a:b
+ports: "22:22"
+quote: say "hi"
+space: " padded"
+star: "*.example.com"
+tab: "a\tb"
+"yes": "Yes"`,
+		},
+		{
+			name: "multi-line strings",
+			value: map[string]interface{}{
+				"script": "set -e\n\necho done\n",
+				"motd":   "line one\nline two",
+				"list":   []interface{}{"a\nb"},
+				"indent": "  leading\nspace",
+			},
+			want: `indent: "  leading\nspace"
+list:
+  - |-
+    a
+    b
+motd: |-
+  line one
+  line two
+script: |
+  set -e
+
+  echo done`,
+		},
+		{
+			name: "structs",
+			value: yamlContainer{
+				yamlMeta: yamlMeta{Name: "web"},
+				Image:    "nginx:1.25",
+				Ports:    []yamlPort{{Name: "http", ContainerPort: 80}},
+				Secret:   "hidden",
+				Started:  time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+			},
+			want: `name: web
+image: nginx:1.25
+ports:
+  - name: http
+    containerPort: 80
+started: "2024-05-06T07:08:09Z"`,
+		},
+		{
+			name:   "indent",
+			value:  map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1, map[string]interface{}{"c": 2, "d": 3}}}},
+			kwargs: map[string]interface{}{"indent": 4},
+			want: `a:
+    b:
+        - 1
+        - c: 2
+          d: 3`,
+		},
+		{
+			name:  "scalars",
+			value: []interface{}{true, json.Number("9007199254740993"), 1.5, 1e21, nil, runtime.SafeValue{Value: "<b>"}, map[int]string{2: "b", 1: "a"}},
+			want: `- true
+- 9007199254740993
+- 1.5
+- 1e+21
+- null
+- <b>
+- 1: a
+  2: b`,
+		},
+		{
+			name:  "top-level scalar",
+			value: "on",
+			want:  `"on"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToYAMLKeywordFilter(tt.value, tt.args, tt.kwargs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			safe, ok := got.(runtime.SafeValue)
+			if !ok {
+				t.Fatalf("expected a SafeValue, got %T", got)
+			}
+			if safe.Value != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", safe.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestToYAMLFilterErrors(t *testing.T) {
+	cyclic := map[string]interface{}{"name": "loop"}
+	cyclic["self"] = cyclic
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"function", map[string]interface{}{"hooks": []interface{}{1, func() {}}}, "cannot encode func() as YAML at hooks[1]"},
+		{"cycle", cyclic, "cannot encode a value containing itself as YAML at self"},
+		{"strict undefined", map[string]interface{}{"x": runtime.NewUndefined("port", runtime.UndefinedStrict, nil)}, "port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToYAMLFilter(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+			var runtimeErr *runtime.RuntimeError
+			if tt.name != "strict undefined" && (!errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeFilter) {
+				t.Errorf("expected a FilterError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNIndentFilter(t *testing.T) {
+	got, err := NIndentFilter("cpu: 1\n\nmemory: 2Gi", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\n    cpu: 1\n\n    memory: 2Gi"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := NIndentFilter("x"); err == nil {
+		t.Error("expected an error without a width")
+	}
+}
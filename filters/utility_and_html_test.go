@@ -1,6 +1,7 @@
 package filters
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -69,6 +70,32 @@ func TestAdditionalUtilityFilters(t *testing.T) {
 		if result == nil {
 			t.Error("Expected some result from RejectFilter")
 		}
+		if !reflect.DeepEqual(result, []interface{}{1, 3, 5}) {
+			t.Errorf("Expected [1 3 5], got %v", result)
+		}
+
+		// Reject even numbers using a test function
+		result, err = RejectFilter(items, func(x interface{}) bool {
+			return x.(int)%2 == 0
+		})
+		if err != nil {
+			t.Errorf("RejectFilter failed: %v", err)
+		}
+		if !reflect.DeepEqual(result, []interface{}{1, 3, 5}) {
+			t.Errorf("Expected [1 3 5], got %v", result)
+		}
+
+		// Built-in tests take their arguments outside an environment too
+		result, err = SelectFilter(items, "divisibleby", 3)
+		if err != nil {
+			t.Errorf("SelectFilter failed: %v", err)
+		}
+		if !reflect.DeepEqual(result, []interface{}{3, 6}) {
+			t.Errorf("Expected [3 6], got %v", result)
+		}
+		if _, err := RejectFilter(items, "nosuchtest"); err == nil {
+			t.Error("Expected an error for an unknown test")
+		}
 	})
 
 	// Test AttrFilter
@@ -150,11 +177,13 @@ func TestAdditionalUtilityFilters(t *testing.T) {
 			t.Errorf("GroupByFilter failed: %v", err)
 		}
 
-		// Result format may vary - just check it's not nil
-		if result == nil {
-			t.Error("Expected non-nil result from GroupByFilter")
-		} else {
-			t.Logf("GroupByFilter returned type: %T", result)
+		groups, ok := result.([]interface{})
+		if !ok || len(groups) != 2 {
+			t.Fatalf("Expected 2 groups, got %#v", result)
+		}
+		first := groups[0].(Group)
+		if first.Grouper != "A" || len(first.List) != 2 || first.Len() != 2 || first.Index(0) != "A" {
+			t.Errorf("Expected group A with 2 items, got %#v", first)
 		}
 	})
 }
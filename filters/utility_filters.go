@@ -1,15 +1,19 @@
 package filters
 
 import (
-	"encoding/json"
 	"fmt"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zipreport/miya/runtime"
 )
 
-// DefaultFilter returns default value if input is falsy
+// DefaultFilter returns the default value if the input is undefined or nil,
+// which miya uses for missing values. With the boolean argument set, any
+// falsy input ("", 0, false, empty collections) is replaced too.
 func DefaultFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	if len(args) == 0 {
 		return value, nil
@@ -22,28 +26,17 @@ func DefaultFilter(value interface{}, args ...interface{}) (interface{}, error)
 		boolean = ToBool(args[1])
 	}
 
-	// Check if value is undefined
-	if _, ok := value.(*runtime.Undefined); ok {
+	if _, ok := value.(*runtime.Undefined); ok || value == nil {
 		return defaultValue, nil
 	}
 
 	if boolean {
-		// Use boolean test
-		if ToBool(value) {
-			return value, nil
-		}
-	} else {
-		// Use undefined test (nil, empty string, etc.)
-		if value != nil {
-			if s, ok := value.(string); ok && s != "" {
-				return value, nil
-			} else if !ok {
-				return value, nil
-			}
+		if inner, _ := unwrapSafe(value); !ToBool(inner) {
+			return defaultValue, nil
 		}
 	}
 
-	return defaultValue, nil
+	return value, nil
 }
 
 // MapFilter applies an attribute or filter to each item
@@ -74,101 +67,123 @@ func MapFilter(value interface{}, args ...interface{}) (interface{}, error) {
 	}
 }
 
-// SelectFilter selects items that pass a test
+// SelectFilter selects the items of a sequence passing a test: without one,
+// the truthy items. The test is a func(interface{}) bool or the name of a
+// test, one of the environment's when it applies the filter and a built-in
+// one otherwise, followed by the test's arguments, as in
+// select("divisibleby", 3).
 func SelectFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	// This is a simplified version - full implementation would support test functions
-	switch v := value.(type) {
-	case []interface{}:
-		var result []interface{}
-		for _, item := range v {
-			if ToBool(item) {
-				result = append(result, item)
-			}
-		}
-		return result, nil
-
-	default:
-		return nil, fmt.Errorf("select filter requires a sequence")
-	}
+	return filterItems("select", value, args, false, true, nil)
 }
 
-// RejectFilter rejects items that pass a test
+// RejectFilter rejects the items of a sequence passing a test, as
+// SelectFilter selects them
 func RejectFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	// This is a simplified version - full implementation would support test functions
-	switch v := value.(type) {
-	case []interface{}:
-		var result []interface{}
-		for _, item := range v {
-			if !ToBool(item) {
-				result = append(result, item)
-			}
-		}
-		return result, nil
-
-	default:
-		return nil, fmt.Errorf("reject filter requires a sequence")
-	}
+	return filterItems("reject", value, args, false, false, nil)
 }
 
-// AttrFilter extracts an attribute from an object
+// AttrFilter returns the attribute of value named by its argument, a dotted
+// path such as "address.city" read as value.address.city is. Path segments
+// that are integers also index lists. A missing attribute is undefined, or
+// the default given as the second argument.
 func AttrFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("attr filter requires attribute name")
-	}
+	return lookupFilter(nil, "attr", value, args, nil, attrPath)
+}
 
-	attrName := ToString(args[0])
-	return extractAttribute(value, attrName), nil
+// GetItemFilter returns the item of value under its argument, a map key or
+// list index, read as value[key] is. The key isn't split on dots. A missing
+// item is undefined, or the default given as the second argument.
+func GetItemFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	return lookupFilter(nil, "getitem", value, args, nil, func(value, key interface{}) (interface{}, bool) {
+		return runtime.LookupItem(value, key)
+	})
 }
 
-// PPrintFilter formats value for pretty printing
-func PPrintFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	verbose := false
-	if len(args) > 0 {
-		verbose = ToBool(args[0])
+// attrPath looks up the dotted attribute path in value
+func attrPath(value, path interface{}) (interface{}, bool) {
+	for _, name := range strings.Split(ToString(path), ".") {
+		next, found := runtime.LookupAttribute(value, name)
+		if !found {
+			next, found = runtime.LookupItem(value, name)
+		}
+		if !found {
+			index, err := strconv.Atoi(name)
+			if err != nil {
+				return nil, false
+			}
+			if next, found = runtime.LookupItem(value, index); !found {
+				return nil, false
+			}
+		}
+		value = next
 	}
+	return value, true
+}
 
-	if verbose {
-		// More detailed representation
-		return fmt.Sprintf("%#v", value), nil
+// lookupFilter applies attr or getitem, which look up their first argument
+// in value with find. The default, given as the second argument or by
+// keyword, replaces an undefined input and a missing result; without one a
+// missing result is undefined as ctx's render makes missing variables, so
+// strict renders fail.
+func lookupFilter(ctx runtime.Context, filter string, value interface{}, args []interface{}, kwargs map[string]interface{}, find func(value, key interface{}) (interface{}, bool)) (interface{}, error) {
+	for keyword := range kwargs {
+		if keyword != "default" {
+			return nil, fmt.Errorf("%s got an unexpected keyword argument '%s'", filter, keyword)
+		}
 	}
-
-	// Simple pretty print using strings.Builder for efficiency
-	switch v := value.(type) {
-	case map[string]interface{}:
-		keys := make([]string, 0, len(v))
-		for k := range v {
-			keys = append(keys, k)
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s filter requires attribute name or key", filter)
+	}
+	if len(args) > 2 {
+		return nil, fmt.Errorf("%s filter takes at most 2 arguments, got %d", filter, len(args))
+	}
+	fallback, hasDefault := kwargs["default"]
+	if len(args) == 2 {
+		if hasDefault {
+			return nil, fmt.Errorf("%s got multiple values for argument 'default'", filter)
 		}
-		sort.Strings(keys)
+		fallback, hasDefault = args[1], true
+	}
 
-		var result strings.Builder
-		result.WriteByte('{')
-		for i, k := range keys {
-			if i > 0 {
-				result.WriteString(", ")
-			}
-			result.WriteString(k)
-			result.WriteString(": ")
-			fmt.Fprintf(&result, "%v", v[k])
+	if undefined, ok := value.(*runtime.Undefined); ok {
+		if hasDefault {
+			return fallback, nil
 		}
-		result.WriteByte('}')
-		return result.String(), nil
+		return undefined, nil
+	}
+	if result, found := find(value, args[0]); found {
+		return result, nil
+	}
+	if hasDefault {
+		return fallback, nil
+	}
+	undefined, err := runtime.UndefinedIn(ctx, fmt.Sprintf("%s(%s)", filter, ToString(args[0])))
+	if err != nil {
+		return nil, err
+	}
+	return undefined, nil
+}
 
-	case []interface{}:
-		var result strings.Builder
-		result.WriteByte('[')
-		for i, item := range v {
-			if i > 0 {
-				result.WriteString(", ")
-			}
-			fmt.Fprintf(&result, "%v", item)
-		}
-		result.WriteByte(']')
-		return result.String(), nil
+// ApplyFilter calls a macro or function with the filtered value as its first
+// argument, followed by the filter's own arguments, so
+// {{ content|apply(ui.card, "Title") }} is ui.card(content, "Title")
+func ApplyFilter(ctx runtime.Context, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("apply filter requires a macro or function to call")
+	}
 
-	default:
-		return fmt.Sprintf("%v", value), nil
+	fn := args[0]
+	if u, ok := fn.(*runtime.Undefined); ok {
+		return nil, fmt.Errorf("apply filter cannot call undefined %s", u.Name)
 	}
+	if fn == nil || reflect.ValueOf(fn).Kind() != reflect.Func {
+		return nil, fmt.Errorf("apply filter cannot call a value of type %T", fn)
+	}
+
+	callArgs := make([]interface{}, 0, len(args))
+	callArgs = append(callArgs, value)
+	callArgs = append(callArgs, args[1:]...)
+	return runtime.CallValue(fn, callArgs, kwargs, ctx)
 }
 
 // DictSortFilter sorts a dictionary by keys or values
@@ -186,6 +201,9 @@ func DictSortFilter(value interface{}, args ...interface{}) (interface{}, error)
 	if len(args) > 2 {
 		reverse = ToBool(args[2])
 	}
+	if d, ok := value.(*runtime.Dict); ok {
+		value = d.Map()
+	}
 
 	switch v := value.(type) {
 	case map[string]interface{}:
@@ -200,21 +218,18 @@ func DictSortFilter(value interface{}, args ...interface{}) (interface{}, error)
 		}
 
 		sort.Slice(pairs, func(i, j int) bool {
-			var a, b string
+			var a, b interface{}
 			if byKey {
 				a, b = pairs[i].key, pairs[j].key
 			} else {
-				a, b = ToString(pairs[i].value), ToString(pairs[j].value)
-			}
-
-			if !caseSensitive {
-				a, b = strings.ToLower(a), strings.ToLower(b)
+				a, b = pairs[i].value, pairs[j].value
 			}
 
+			result := compareValues(a, b, caseSensitive)
 			if reverse {
-				return a > b
+				return result > 0
 			}
-			return a < b
+			return result < 0
 		})
 
 		result := make([][]interface{}, len(pairs))
@@ -229,85 +244,84 @@ func DictSortFilter(value interface{}, args ...interface{}) (interface{}, error)
 	}
 }
 
-// GroupByFilter groups sequence items by attribute
-func GroupByFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	if len(args) < 1 {
-		return nil, fmt.Errorf("groupby filter requires attribute name")
-	}
-
-	attrName := ToString(args[0])
-
-	switch v := value.(type) {
-	case []interface{}:
-		groups := make(map[string][]interface{})
-
-		for _, item := range v {
-			key := ToString(extractAttribute(item, attrName))
-			groups[key] = append(groups[key], item)
-		}
-
-		// Convert to list of [key, items] pairs
-		var result [][]interface{}
-		keys := make([]string, 0, len(groups))
-		for k := range groups {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		for _, key := range keys {
-			result = append(result, []interface{}{key, groups[key]})
-		}
+// Group is one group of groupby: the value shared by its items, and the
+// items in their order in the sequence. Templates read it as group.grouper
+// and group.list, or unpack it as a (grouper, list) pair.
+type Group struct {
+	Grouper interface{}
+	List    []interface{}
+}
 
-		return result, nil
+// Len returns 2, the length of the (grouper, list) pair
+func (g Group) Len() int { return 2 }
 
-	default:
-		return nil, fmt.Errorf("groupby filter requires a sequence")
+// Index returns the grouper for 0 and the list for 1
+func (g Group) Index(i int) interface{} {
+	switch i {
+	case 0:
+		return g.Grouper
+	case 1:
+		return g.List
 	}
+	return nil
 }
 
-// ToJSONFilter converts value to JSON string.
-// Returns a SafeValue to prevent HTML auto-escaping, matching Jinja2's
-// tojson behavior which returns Markup. This is necessary for safe
-// embedding of JSON in <script> blocks where HTML entities are not decoded.
-func ToJSONFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	indent := 0
-	if len(args) > 0 {
-		i, err := ToInt(args[0])
-		if err == nil && i > 0 {
-			indent = i
-		}
+// GroupByFilter groups sequence items by attribute, which may be a dotted
+// path such as "author.name". Groups are sorted by their grouper, the
+// attribute value of their first item, of its own type; items with equal
+// values keep their order.
+func GroupByFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("groupby filter requires attribute name")
 	}
 
-	var data []byte
-	var err error
-
-	if indent > 0 {
-		data, err = json.MarshalIndent(value, "", strings.Repeat(" ", indent))
-	} else {
-		data, err = json.Marshal(value)
+	if _, ok := value.(string); ok {
+		return nil, fmt.Errorf("groupby filter requires a sequence")
 	}
-
+	items, err := toInterfaceSlice(value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert to JSON: %v", err)
+		return nil, fmt.Errorf("groupby filter requires a sequence")
 	}
 
-	return runtime.SafeValue{Value: string(data)}, nil
-}
-
-// FromJSONFilter parses JSON string to value
-func FromJSONFilter(value interface{}, args ...interface{}) (interface{}, error) {
-	s := ToString(value)
-	if s == "" {
-		return nil, nil
+	type keyed struct {
+		key  interface{}
+		item interface{}
 	}
-
-	var result interface{}
-	err := json.Unmarshal([]byte(s), &result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+	sorted := make([]keyed, len(items))
+	for i, item := range items {
+		key, _ := attrPath(item, args[0])
+		sorted[i] = keyed{key, item}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareGroupers(sorted[i].key, sorted[j].key) < 0
+	})
+
+	result := []interface{}{}
+	var group *Group
+	for _, entry := range sorted {
+		if group == nil || compareGroupers(group.Grouper, entry.key) != 0 {
+			if group != nil {
+				result = append(result, *group)
+			}
+			group = &Group{Grouper: entry.key}
+		}
+		group.List = append(group.List, entry.item)
+	}
+	if group != nil {
+		result = append(result, *group)
 	}
-
 	return result, nil
 }
 
+// compareGroupers orders groupby keys: times chronologically, anything else
+// as sort does, case-sensitively
+func compareGroupers(a, b interface{}) int {
+	if aTime, ok := a.(time.Time); ok {
+		if bTime, ok := b.(time.Time); ok {
+			return aTime.Compare(bTime)
+		}
+	}
+	return compareValues(a, b, true)
+}
+
 // Helper functions are defined in filter.go
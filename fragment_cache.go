@@ -0,0 +1,152 @@
+package miya
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// FragmentCache stores the output of {% cache %} blocks
+type FragmentCache = runtime.FragmentCache
+
+// SetFragmentCache sets the store for {% cache key timeout=300 %} blocks.
+// Without a store, cache blocks render their body every time. Overlays use
+// their parent's store unless they set their own.
+//
+// The key expression is evaluated on each render; lists such as
+// ["product", product.id] are joined with colons. The timeout is a number of
+// seconds or a duration string like "5m". Entries are stored under the
+// evaluated key followed by "|" and the block's template and position, so
+// store.DeletePrefix("product:42|") drops one product's fragments everywhere.
+// On a cache hit the body is not evaluated, so it should not set variables
+// the rest of the template relies on.
+func (e *Environment) SetFragmentCache(store FragmentCache) {
+	e.fragmentCache = store
+}
+
+// activeFragmentCache returns the store of the environment or its closest parent
+func (e *Environment) activeFragmentCache() FragmentCache {
+	for env := e; env != nil; env = env.parent {
+		if env.fragmentCache != nil {
+			return env.fragmentCache
+		}
+	}
+	return nil
+}
+
+// scopeFragmentCaches records the defining template on the cache blocks of a
+// loaded template, so blocks at the same position in different templates get
+// separate entries. The blocks are written in place, so ast must not be
+// shared, such as a loader's cached AST.
+func scopeFragmentCaches(scope string, ast parser.Node) {
+	parser.Walk(ast, func(node parser.Node) bool {
+		if cache, ok := node.(*parser.CacheNode); ok {
+			cache.Scope = scope
+		}
+		return true
+	})
+}
+
+// MemoryFragmentCache is an in-memory FragmentCache that evicts the least
+// recently used entry once it holds maxEntries entries
+type MemoryFragmentCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // front is most recently used
+}
+
+type fragmentEntry struct {
+	key     string
+	value   string
+	expires time.Time // zero for no expiry
+}
+
+// NewMemoryFragmentCache creates an in-memory store holding up to maxEntries
+// fragments; maxEntries <= 0 means no limit
+func NewMemoryFragmentCache(maxEntries int) *MemoryFragmentCache {
+	return &MemoryFragmentCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Get returns the fragment stored under key unless it has expired
+func (c *MemoryFragmentCache) Get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*fragmentEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(elem)
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set stores a fragment; a zero ttl keeps it until it is evicted
+func (c *MemoryFragmentCache) Set(key string, value string, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*fragmentEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&fragmentEntry{key: key, value: value, expires: expires})
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// DeletePrefix removes every fragment whose key starts with prefix
+func (c *MemoryFragmentCache) DeletePrefix(prefix string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, elem := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(elem)
+		}
+	}
+}
+
+// Len returns the number of stored fragments, including expired ones not yet
+// removed
+func (c *MemoryFragmentCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Clear removes every fragment
+func (c *MemoryFragmentCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *MemoryFragmentCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*fragmentEntry).key)
+}
@@ -0,0 +1,203 @@
+// Package httprender writes rendered templates as HTTP responses:
+//
+//	renderer := httprender.New(env,
+//		httprender.WithContextProcessor(func(req *http.Request) map[string]interface{} {
+//			return map[string]interface{}{"path": req.URL.Path}
+//		}),
+//		httprender.WithErrorTemplate("error.html"),
+//	)
+//
+//	func home(w http.ResponseWriter, req *http.Request) {
+//		renderer.ForRequest(req).HTML(w, http.StatusOK, "home.html", map[string]interface{}{
+//			"products": products,
+//		})
+//	}
+//
+// Output is rendered in full before anything is written, so a template that
+// fails halfway through produces an error response instead of half a page
+// sent with a 200 status.
+package httprender
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/zipreport/miya"
+	"github.com/zipreport/miya/filters"
+)
+
+// ContextProcessor returns variables derived from the request, such as its
+// URL or flash messages, for every template rendered for it
+type ContextProcessor func(req *http.Request) map[string]interface{}
+
+// Renderer renders the templates of an environment as HTTP responses. It is
+// safe for concurrent use.
+type Renderer struct {
+	env           *miya.Environment
+	processors    []ContextProcessor
+	errorTemplate string
+	debug         bool
+	errorLog      *log.Logger
+
+	req *http.Request // set by ForRequest
+}
+
+// Option configures a Renderer
+type Option func(*Renderer)
+
+// WithContextProcessor adds a processor whose variables are set for every
+// render of a Renderer bound to a request with ForRequest. Processors run in
+// the order they were added; later ones, and the data passed to a render,
+// override earlier variables of the same name.
+func WithContextProcessor(processor ContextProcessor) Option {
+	return func(r *Renderer) {
+		r.processors = append(r.processors, processor)
+	}
+}
+
+// WithErrorTemplate renders the template called name, with a 500 status,
+// when a render fails. It sees status, the status code, and status_text, and
+// in debug mode error, the message of the render error, and template, the
+// name of the template that failed. Without an error template, a plain text
+// response is written.
+func WithErrorTemplate(name string) Option {
+	return func(r *Renderer) {
+		r.errorTemplate = name
+	}
+}
+
+// WithDebug includes the details of render errors in error responses. Leave
+// it off in production: messages can reveal template source and data.
+func WithDebug(debug bool) Option {
+	return func(r *Renderer) {
+		r.debug = debug
+	}
+}
+
+// WithErrorLog sets the logger render errors are reported to; the default is
+// the standard logger
+func WithErrorLog(logger *log.Logger) Option {
+	return func(r *Renderer) {
+		r.errorLog = logger
+	}
+}
+
+// New creates a Renderer for the templates of env
+func New(env *miya.Environment, opts ...Option) *Renderer {
+	r := &Renderer{env: env, errorLog: log.Default()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ForRequest returns a Renderer that runs the context processors on req for
+// each render. It is cheap, meant to be called once per request.
+func (r *Renderer) ForRequest(req *http.Request) *Renderer {
+	bound := *r
+	bound.req = req
+	return &bound
+}
+
+// HTML renders the template called name with data and writes it with the
+// status code and, unless the handler set one, a text/html Content-Type.
+// A render error is logged and answered as WithErrorTemplate describes, and
+// returned.
+func (r *Renderer) HTML(w http.ResponseWriter, status int, name string, data map[string]interface{}) error {
+	return r.render(w, status, name, data, "text/html; charset=utf-8")
+}
+
+// Text is HTML for plain text templates: the output isn't HTML escaped, and
+// the Content-Type is text/plain
+func (r *Renderer) Text(w http.ResponseWriter, status int, name string, data map[string]interface{}) error {
+	return r.render(w, status, name, data, "text/plain; charset=utf-8", miya.RenderAutoescape(false))
+}
+
+// JSON writes value encoded as the tojson filter encodes it, with the status
+// code and, unless the handler set one, an application/json Content-Type.
+// A value that can't be encoded is logged and answered as a render error,
+// and returned.
+func (r *Renderer) JSON(w http.ResponseWriter, status int, value interface{}) error {
+	encoded, err := filters.ToJSONFilter(value)
+	if err != nil {
+		err = fmt.Errorf("encoding JSON: %w", err)
+		r.fail(w, "", err)
+		return err
+	}
+	r.write(w, status, "application/json", filters.ToString(encoded))
+	return nil
+}
+
+func (r *Renderer) render(w http.ResponseWriter, status int, name string, data map[string]interface{}, contentType string, opts ...miya.RenderOption) error {
+	output, err := r.renderTemplate(name, data, opts...)
+	if err != nil {
+		r.fail(w, name, err)
+		return err
+	}
+	r.write(w, status, contentType, output)
+	return nil
+}
+
+// renderTemplate renders the template called name with the context
+// processors' variables and data
+func (r *Renderer) renderTemplate(name string, data map[string]interface{}, opts ...miya.RenderOption) (string, error) {
+	tmpl, err := r.env.GetTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	ctx := miya.NewContext()
+	if r.req != nil {
+		for _, processor := range r.processors {
+			ctx.Update(processor(r.req), false)
+		}
+	}
+	ctx.Update(data, false)
+	return tmpl.RenderWith(ctx, opts...)
+}
+
+// fail logs err, raised rendering the template called name, and writes the
+// error response
+func (r *Renderer) fail(w http.ResponseWriter, name string, err error) {
+	if name != "" {
+		r.errorLog.Printf("httprender: rendering %s: %v", name, err)
+	} else {
+		r.errorLog.Printf("httprender: %v", err)
+	}
+
+	status := http.StatusInternalServerError
+	if r.errorTemplate != "" && r.errorTemplate != name {
+		data := map[string]interface{}{
+			"status":      status,
+			"status_text": http.StatusText(status),
+		}
+		if r.debug {
+			data["error"] = err.Error()
+			data["template"] = name
+		}
+		output, pageErr := r.renderTemplate(r.errorTemplate, data)
+		if pageErr == nil {
+			r.write(w, status, "text/html; charset=utf-8", output)
+			return
+		}
+		r.errorLog.Printf("httprender: rendering error template %s: %v", r.errorTemplate, pageErr)
+	}
+
+	message := http.StatusText(status)
+	if r.debug {
+		message += ": " + err.Error()
+	}
+	r.write(w, status, "text/plain; charset=utf-8", message+"\n")
+}
+
+// write sends body with status, setting Content-Type to contentType unless
+// the handler set it
+func (r *Renderer) write(w http.ResponseWriter, status int, contentType, body string) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(body)); err != nil {
+		r.errorLog.Printf("httprender: writing response: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package httprender
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func newTestRenderer(opts ...Option) (*Renderer, *bytes.Buffer) {
+	templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	templates.AddTemplate("home.html", `<p>{{ greeting }}, {{ user }} at {{ path }}</p>`)
+	templates.AddTemplate("note.txt", `{{ greeting }} <{{ user }}>`)
+	templates.AddTemplate("broken.html", `<html><body>{{ items }}{{ items.missing() }}</body></html>`)
+	templates.AddTemplate("error.html", `<h1>{{ status }} {{ status_text }}</h1>{% if error %}<pre>{{ template }}: {{ error }}</pre>{% endif %}`)
+	env := miya.NewEnvironment(miya.WithLoader(templates))
+
+	var logs bytes.Buffer
+	opts = append([]Option{WithErrorLog(log.New(&logs, "", 0))}, opts...)
+	return New(env, opts...), &logs
+}
+
+func TestHTML(t *testing.T) {
+	renderer, _ := newTestRenderer(
+		WithContextProcessor(func(req *http.Request) map[string]interface{} {
+			return map[string]interface{}{"path": req.URL.Path, "user": "guest"}
+		}),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/home", nil)
+
+	w := httptest.NewRecorder()
+	err := renderer.ForRequest(req).HTML(w, http.StatusCreated, "home.html", map[string]interface{}{
+		"greeting": "Hi",
+		"user":     "<Ada>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("got %d, %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Body.String(); got != "<p>Hi, &lt;Ada&gt; at /home</p>" {
+		t.Errorf("got %q", got)
+	}
+
+	// Without a request, processors don't run
+	w = httptest.NewRecorder()
+	renderer.HTML(w, http.StatusOK, "home.html", map[string]interface{}{"greeting": "Hi"})
+	if got := w.Body.String(); got != "<p>Hi,  at </p>" {
+		t.Errorf("got %q", got)
+	}
+
+	// A Content-Type set by the handler is kept
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/xhtml+xml")
+	renderer.HTML(w, http.StatusOK, "home.html", nil)
+	if got := w.Header().Get("Content-Type"); got != "application/xhtml+xml" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	renderer, _ := newTestRenderer()
+	w := httptest.NewRecorder()
+	if err := renderer.Text(w, http.StatusOK, "note.txt", map[string]interface{}{"greeting": "Hi", "user": "a&b"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); got != "Hi <a&b>" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("got %q, %q", got, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestJSON(t *testing.T) {
+	renderer, logs := newTestRenderer()
+	w := httptest.NewRecorder()
+	if err := renderer.JSON(w, http.StatusOK, map[string]interface{}{"name": "<Ada>", "tags": []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); got != `{"name":"\u003cAda\u003e","tags":["a"]}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %q, %q", got, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	if err := renderer.JSON(w, http.StatusOK, map[string]interface{}{"f": func() {}}); err == nil {
+		t.Fatal("expected an encoding error")
+	}
+	if w.Code != http.StatusInternalServerError || !strings.Contains(logs.String(), "encoding JSON") {
+		t.Errorf("got %d, log %q", w.Code, logs.String())
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	data := map[string]interface{}{"items": []interface{}{1, 2}}
+
+	t.Run("Plain", func(t *testing.T) {
+		renderer, logs := newTestRenderer()
+		w := httptest.NewRecorder()
+		if err := renderer.HTML(w, http.StatusOK, "broken.html", data); err == nil {
+			t.Fatal("expected a render error")
+		}
+		if w.Code != http.StatusInternalServerError || w.Body.String() != "Internal Server Error\n" {
+			t.Errorf("got %d %q", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "<html>") {
+			t.Error("a partial page was written")
+		}
+		if !strings.Contains(logs.String(), "rendering broken.html") {
+			t.Errorf("error not logged: %q", logs.String())
+		}
+	})
+
+	t.Run("ErrorTemplate", func(t *testing.T) {
+		renderer, _ := newTestRenderer(WithErrorTemplate("error.html"))
+		w := httptest.NewRecorder()
+		renderer.HTML(w, http.StatusOK, "broken.html", data)
+		if w.Code != http.StatusInternalServerError || w.Body.String() != "<h1>500 Internal Server Error</h1>" {
+			t.Errorf("got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Debug", func(t *testing.T) {
+		renderer, _ := newTestRenderer(WithErrorTemplate("error.html"), WithDebug(true))
+		w := httptest.NewRecorder()
+		renderer.HTML(w, http.StatusOK, "broken.html", data)
+		if body := w.Body.String(); !strings.HasPrefix(body, "<h1>500 Internal Server Error</h1><pre>broken.html: ") {
+			t.Errorf("got %q", body)
+		}
+
+		renderer, _ = newTestRenderer(WithDebug(true))
+		w = httptest.NewRecorder()
+		renderer.HTML(w, http.StatusOK, "missing.html", nil)
+		if body := w.Body.String(); !strings.HasPrefix(body, "Internal Server Error: ") || !strings.Contains(body, "missing.html") {
+			t.Errorf("got %q", body)
+		}
+	})
+
+	t.Run("BrokenErrorTemplate", func(t *testing.T) {
+		renderer, logs := newTestRenderer(WithErrorTemplate("missing.html"))
+		w := httptest.NewRecorder()
+		renderer.HTML(w, http.StatusOK, "broken.html", data)
+		if w.Code != http.StatusInternalServerError || w.Body.String() != "Internal Server Error\n" || !strings.Contains(logs.String(), "rendering error template missing.html") {
+			t.Errorf("got %d %q, log %q", w.Code, w.Body.String(), logs.String())
+		}
+	})
+}
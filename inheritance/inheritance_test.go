@@ -529,6 +529,11 @@ func (c *testContext) GetVariable(key string) (interface{}, bool) {
 	return val, exists
 }
 
+func (c *testContext) HasVariable(key string) bool {
+	_, exists := c.data[key]
+	return exists
+}
+
 func (c *testContext) SetVariable(key string, value interface{}) {
 	c.data[key] = value
 }
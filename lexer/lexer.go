@@ -16,6 +16,10 @@ type LexerConfig struct {
 	CommentEndString   string
 	TrimBlocks         bool
 	LstripBlocks       bool
+
+	// KeepComments emits comments as a CommentStart, Text, CommentEnd token
+	// sequence instead of skipping them, for tools that reproduce the source
+	KeepComments bool
 }
 
 func DefaultConfig() *LexerConfig {
@@ -41,6 +45,16 @@ type Lexer struct {
 	ch byte // current char
 
 	state lexerState
+
+	// rawPending marks a block opened by a raw tag, whose end switches to
+	// stateRaw; rawTrim records that the tag closed with -%}
+	rawPending bool
+	rawTrim    bool
+
+	// tagLine and tagColumn locate the delimiter that opened the current
+	// variable or block tag, for reporting it when it is never closed
+	tagLine   int
+	tagColumn int
 }
 
 type lexerState int
@@ -50,6 +64,7 @@ const (
 	stateVariable
 	stateBlock
 	stateComment
+	stateRaw
 )
 
 func NewLexer(input string, config *LexerConfig) *Lexer {
@@ -79,6 +94,8 @@ func (l *Lexer) NextToken() (*Token, error) {
 		return l.lexBlock()
 	case stateComment:
 		return l.lexComment()
+	case stateRaw:
+		return l.lexRaw()
 	default:
 		return nil, fmt.Errorf("unexpected lexer state: %v", l.state)
 	}
@@ -106,6 +123,23 @@ func (l *Lexer) Tokenize() ([]*Token, error) {
 	return tokens, nil
 }
 
+// TokenizeExpression tokenizes the input as a lone expression without
+// delimiters, such as "item.age > 30", ending with an EOF token
+func (l *Lexer) TokenizeExpression() ([]*Token, error) {
+	var tokens []*Token
+	for {
+		l.skipWhitespace()
+		if l.ch == 0 {
+			return append(tokens, l.makeToken(TokenEOF, "")), nil
+		}
+		tok, err := l.lexExpression()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
 func (l *Lexer) lexText() (*Token, error) {
 	if l.ch == 0 {
 		return l.makeToken(TokenEOF, ""), nil
@@ -182,6 +216,7 @@ func (l *Lexer) lexText() (*Token, error) {
 func (l *Lexer) lexVarStart() (*Token, error) {
 	line := l.line
 	column := l.column
+	l.tagLine, l.tagColumn = line, column
 
 	// Check for trim variant {{-
 	trimRight := false
@@ -211,6 +246,7 @@ func (l *Lexer) lexVarStart() (*Token, error) {
 func (l *Lexer) lexBlockStart() (*Token, error) {
 	line := l.line
 	column := l.column
+	l.tagLine, l.tagColumn = line, column
 
 	// Check for trim variant {%-
 	trimRight := false
@@ -218,6 +254,7 @@ func (l *Lexer) lexBlockStart() (*Token, error) {
 		l.consumeString(l.config.BlockStartString + "-")
 		trimRight = true
 		l.state = stateBlock
+		l.rawPending = l.peekKeyword("raw")
 		return &Token{
 			Type:      TokenBlockStartTrim,
 			Value:     l.config.BlockStartString + "-",
@@ -229,6 +266,7 @@ func (l *Lexer) lexBlockStart() (*Token, error) {
 
 	l.consumeString(l.config.BlockStartString)
 	l.state = stateBlock
+	l.rawPending = l.peekKeyword("raw")
 	return &Token{
 		Type:   TokenBlockStart,
 		Value:  l.config.BlockStartString,
@@ -241,7 +279,24 @@ func (l *Lexer) lexCommentStart() (*Token, error) {
 	startLine := l.line
 	startColumn := l.column
 
-	l.consumeString(l.config.CommentStartString)
+	// Check for trim variant {#-
+	open := l.config.CommentStartString
+	trimRight := l.peekString(open + "-")
+	if trimRight {
+		open += "-"
+	}
+	l.consumeString(open)
+
+	if l.config.KeepComments {
+		l.state = stateComment
+		return &Token{
+			Type:      TokenCommentStart,
+			Value:     open,
+			Line:      startLine,
+			Column:    startColumn,
+			TrimRight: trimRight,
+		}, nil
+	}
 
 	// Skip everything until comment end
 	for !l.peekString(l.config.CommentEndString) && l.ch != 0 {
@@ -301,7 +356,7 @@ func (l *Lexer) lexBlock() (*Token, error) {
 		line := l.line
 		column := l.column
 		l.consumeString("-" + l.config.BlockEndString)
-		l.state = stateText
+		l.endBlock(true)
 		return &Token{
 			Type:     TokenBlockEndTrim,
 			Value:    "-" + l.config.BlockEndString,
@@ -315,7 +370,7 @@ func (l *Lexer) lexBlock() (*Token, error) {
 		line := l.line
 		column := l.column
 		l.consumeString(l.config.BlockEndString)
-		l.state = stateText
+		l.endBlock(false)
 		return &Token{
 			Type:   TokenBlockEnd,
 			Value:  l.config.BlockEndString,
@@ -327,9 +382,123 @@ func (l *Lexer) lexBlock() (*Token, error) {
 	return l.lexExpression()
 }
 
+// lexComment produces the body and closing delimiter of a comment; it is only
+// reached when KeepComments is set
 func (l *Lexer) lexComment() (*Token, error) {
-	// Comments are handled in lexCommentStart
-	return nil, fmt.Errorf("unexpected call to lexComment")
+	line := l.line
+	column := l.column
+
+	// Check for trim variant -#}
+	if l.peekString("-" + l.config.CommentEndString) {
+		l.consumeString("-" + l.config.CommentEndString)
+		l.state = stateText
+		return &Token{
+			Type:     TokenCommentEnd,
+			Value:    "-" + l.config.CommentEndString,
+			Line:     line,
+			Column:   column,
+			TrimLeft: true,
+		}, nil
+	}
+
+	if l.peekString(l.config.CommentEndString) {
+		l.consumeString(l.config.CommentEndString)
+		l.state = stateText
+		return l.makeTokenAt(TokenCommentEnd, l.config.CommentEndString, line, column), nil
+	}
+
+	startPos := l.pos
+	for !l.peekString("-"+l.config.CommentEndString) && !l.peekString(l.config.CommentEndString) && l.ch != 0 {
+		l.readChar()
+	}
+	if l.ch == 0 {
+		return nil, fmt.Errorf("unclosed comment at line %d, column %d", line, column)
+	}
+
+	return &Token{
+		Type:   TokenText,
+		Value:  l.input[startPos:l.pos],
+		Line:   line,
+		Column: column,
+	}, nil
+}
+
+// endBlock returns to text after a block tag, or to the body of a raw block
+func (l *Lexer) endBlock(trim bool) {
+	l.state = stateText
+	if l.rawPending {
+		l.state = stateRaw
+		l.rawTrim = trim
+		l.rawPending = false
+	}
+}
+
+// lexRaw produces the body of a raw block verbatim as one text token, up to
+// the endraw tag. The -%} of the raw tag and the {%- of the endraw tag strip
+// the whitespace at the start and end of the body. A missing endraw is left
+// for the parser to report.
+func (l *Lexer) lexRaw() (*Token, error) {
+	line := l.line
+	column := l.column
+
+	startPos := l.pos
+	trimEnd := false
+	for l.ch != 0 {
+		if end, trim := l.peekEndraw(); end {
+			trimEnd = trim
+			break
+		}
+		l.readChar()
+	}
+	l.state = stateText
+
+	body := l.input[startPos:l.pos]
+	if l.rawTrim {
+		body = strings.TrimLeftFunc(body, unicode.IsSpace)
+	}
+	if trimEnd {
+		body = strings.TrimRightFunc(body, unicode.IsSpace)
+	}
+	if body == "" {
+		return l.NextToken()
+	}
+	return &Token{
+		Type:   TokenText,
+		Value:  body,
+		Line:   line,
+		Column: column,
+	}, nil
+}
+
+// peekEndraw reports whether an endraw tag starts at the current position and
+// whether it opens with a trim marker
+func (l *Lexer) peekEndraw() (found bool, trim bool) {
+	rest := l.input[l.pos:]
+	if !strings.HasPrefix(rest, l.config.BlockStartString) {
+		return false, false
+	}
+	rest = rest[len(l.config.BlockStartString):]
+	if strings.HasPrefix(rest, "-") {
+		trim = true
+		rest = rest[1:]
+	}
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	if !strings.HasPrefix(rest, "endraw") {
+		return false, false
+	}
+	rest = strings.TrimLeft(rest[len("endraw"):], " \t\r\n")
+	rest = strings.TrimPrefix(rest, "-")
+	return strings.HasPrefix(rest, l.config.BlockEndString), trim
+}
+
+// peekKeyword reports whether the next word, after any whitespace, is keyword
+func (l *Lexer) peekKeyword(keyword string) bool {
+	rest := strings.TrimLeft(l.input[l.pos:], " \t\r\n")
+	if !strings.HasPrefix(rest, keyword) {
+		return false
+	}
+	rest = rest[len(keyword):]
+	return rest == "" || !(isAlphaNumeric(rest[0]) || rest[0] == '_')
 }
 
 func (l *Lexer) lexExpression() (*Token, error) {
@@ -338,6 +507,12 @@ func (l *Lexer) lexExpression() (*Token, error) {
 	line := l.line
 	column := l.column
 
+	// Newlines inside a tag are whitespace like any other, so a tag only
+	// ends at its closing delimiter
+	if err := l.unclosedTag(); err != nil {
+		return nil, err
+	}
+
 	switch l.ch {
 	case 0:
 		return nil, fmt.Errorf("unexpected EOF in expression at line %d, column %d", line, column)
@@ -471,6 +646,25 @@ func (l *Lexer) lexExpression() (*Token, error) {
 	return nil, fmt.Errorf("unexpected character %q at line %d, column %d", l.ch, line, column)
 }
 
+// unclosedTag reports a variable or block tag that reaches the end of the
+// template, or the start of a block tag, without its closing delimiter, at
+// the position of its opening delimiter
+func (l *Lexer) unclosedTag() error {
+	var open, end string
+	switch l.state {
+	case stateVariable:
+		open, end = l.config.VarStartString, l.config.VarEndString
+	case stateBlock:
+		open, end = l.config.BlockStartString, l.config.BlockEndString
+	default:
+		return nil
+	}
+	if l.ch != 0 && !l.peekString(l.config.BlockStartString) {
+		return nil
+	}
+	return fmt.Errorf("unclosed tag '%s', expected '%s' at line %d, column %d", open, end, l.tagLine, l.tagColumn)
+}
+
 func (l *Lexer) lexString() (*Token, error) {
 	line := l.line
 	column := l.column
@@ -580,17 +774,19 @@ func (l *Lexer) lexIdentifier() (*Token, error) {
 	}, nil
 }
 
+// readChar advances to the next character, keeping line and column at the
+// position of l.ch: a newline belongs to the line it ends
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else if l.readPos > 0 {
+		l.column++
+	}
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPos]
-		if l.ch == '\n' {
-			l.line++
-			l.column = 1
-		} else {
-			l.column++
-		}
 	}
 	l.pos = l.readPos
 	l.readPos++
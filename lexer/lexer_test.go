@@ -149,6 +149,13 @@ func TestLexerBlocks(t *testing.T) {
 				TokenBlockStartTrim, TokenIf, TokenTrue, TokenBlockEndTrim, TokenEOF,
 			},
 		},
+		{
+			name:  "block spanning lines",
+			input: "{% if a\n    and b\n-%}",
+			expected: []TokenType{
+				TokenBlockStart, TokenIf, TokenIdentifier, TokenAnd, TokenIdentifier, TokenBlockEndTrim, TokenEOF,
+			},
+		},
 		{
 			name:  "set statement",
 			input: "{% set x = 10 %}",
@@ -231,6 +238,108 @@ func TestLexerComments(t *testing.T) {
 	}
 }
 
+func TestLexerKeepComments(t *testing.T) {
+	config := DefaultConfig()
+	config.KeepComments = true
+
+	tokens, err := NewLexer("a{# note #}{##}b", config).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, tok.Type.String()+":"+tok.Value)
+	}
+	expected := []string{
+		"TEXT:a", "COMMENT_START:{#", "TEXT: note ", "COMMENT_END:#}",
+		"COMMENT_START:{#", "COMMENT_END:#}", "TEXT:b", "EOF:",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, got)
+	}
+
+	if _, err := NewLexer("{# open", config).Tokenize(); err == nil {
+		t.Error("expected an error for an unclosed comment")
+	}
+
+	tokens, err = NewLexer("a {#- note -#} b", config).Tokenize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start, body, end := tokens[1], tokens[2], tokens[3]
+	if start.Value != "{#-" || !start.TrimRight || body.Value != " note " || end.Value != "-#}" || !end.TrimLeft {
+		t.Errorf("trim markers not recognized: %v %v %v", start, body, end)
+	}
+}
+
+func TestLexerRaw(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "verbatim body",
+			input:    "{% raw %} {{ x }}  {%if%}\n{% endraw %}",
+			expected: []string{"BLOCK_START:{%", "RAW:raw", "BLOCK_END:%}", "TEXT: {{ x }}  {%if%}\n", "BLOCK_START:{%", "ENDRAW:endraw", "BLOCK_END:%}", "EOF:"},
+		},
+		{
+			name:     "trim markers",
+			input:    "{%- raw -%}\n  {{- x -}}\n{%- endraw -%}",
+			expected: []string{"BLOCK_START_TRIM:{%-", "RAW:raw", "BLOCK_END_TRIM:-%}", "TEXT:{{- x -}}", "BLOCK_START_TRIM:{%-", "ENDRAW:endraw", "BLOCK_END_TRIM:-%}", "EOF:"},
+		},
+		{
+			name:     "empty body",
+			input:    "{% raw -%}  {%- endraw %}",
+			expected: []string{"BLOCK_START:{%", "RAW:raw", "BLOCK_END_TRIM:-%}", "BLOCK_START_TRIM:{%-", "ENDRAW:endraw", "BLOCK_END:%}", "EOF:"},
+		},
+		{
+			name:     "raw prefix is not raw",
+			input:    "{% rawx %}{{ y }}",
+			expected: []string{"BLOCK_START:{%", "IDENTIFIER:rawx", "BLOCK_END:%}", "VAR_START:{{", "IDENTIFIER:y", "VAR_END:}}", "EOF:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := NewLexer(tt.input, nil).Tokenize()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, tok := range tokens {
+				got = append(got, tok.Type.String()+":"+tok.Value)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected tokens %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	tokens, err := NewLexer("ab {{ x }}\n{% raw %}{{ y }}{% endraw %}", nil).Tokenize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, tok.String())
+	}
+	expected := []string{
+		`TEXT("ab ") at 1:1`, `VAR_START("{{") at 1:4`, `IDENTIFIER("x") at 1:7`, `VAR_END("}}") at 1:9`,
+		`TEXT("\n") at 1:11`, `BLOCK_START("{%") at 2:1`, `RAW("raw") at 2:4`, `BLOCK_END("%}") at 2:8`,
+		`TEXT("{{ y }}") at 2:10`, `BLOCK_START("{%") at 2:17`, `ENDRAW("endraw") at 2:20`, `BLOCK_END("%}") at 2:27`,
+		`EOF at 2:29`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, got)
+	}
+}
+
 func TestLexerExpressions(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -391,6 +500,24 @@ func TestLexerErrors(t *testing.T) {
 	}
 }
 
+func TestLexerUnclosedTagPosition(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"a\n{{ items\n  |first", "unclosed tag '{{', expected '}}' at line 2, column 1"},
+		{"a\n\n  {% if a\n  and b", "unclosed tag '{%', expected '%}' at line 3, column 3"},
+		{"{{ name\n{% if x %}{% endif %}", "unclosed tag '{{', expected '}}' at line 1, column 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewLexer(tt.input, nil).Tokenize()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
 func TestTokenString(t *testing.T) {
 	tok := &Token{
 		Type:   TokenIdentifier,
@@ -0,0 +1,494 @@
+package miya
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// DiagnosticCode identifies the kind of problem a Diagnostic reports. Codes
+// don't change between releases, so CI can allowlist diagnostics by code,
+// template and line.
+type DiagnosticCode string
+
+const (
+	// DiagnosticUnusedMacro is a macro no template of the set calls or imports
+	DiagnosticUnusedMacro DiagnosticCode = "unused-macro"
+	// DiagnosticUnknownBlock is a block of a child template that none of its
+	// parents defines, so it renders nothing. The same goes for the blocks
+	// of an embed tag and the template it embeds.
+	DiagnosticUnknownBlock DiagnosticCode = "unknown-block"
+	// DiagnosticShadowedVariable is a set of a loop variable inside its loop,
+	// or of a macro parameter inside the macro
+	DiagnosticShadowedVariable DiagnosticCode = "shadowed-variable"
+	// DiagnosticBrokenInclude is an include or embed of a template that fails
+	// to compile
+	DiagnosticBrokenInclude DiagnosticCode = "broken-include"
+	// DiagnosticReservedName is a set tag, loop, with tag, macro, macro
+	// parameter or import naming a variable after one of ReservedNames,
+	// which hides the engine's meaning of the name where it is visible
+	DiagnosticReservedName DiagnosticCode = "reserved-name"
+)
+
+// Diagnostic is a likely mistake Lint finds in a template, which doesn't
+// stop it rendering
+type Diagnostic struct {
+	Code     DiagnosticCode
+	Template string
+	Line     int
+	Column   int
+	Message  string
+}
+
+// String returns the diagnostic as "template:line:column: code: message"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.Template, d.Line, d.Column, d.Code, d.Message)
+}
+
+// Lint compiles every template the loader lists, as CompileAll does, and
+// looks for mistakes that don't fail a render:
+//
+//   - macros that no template of the set calls or imports
+//   - blocks of child templates that no parent defines, such as a misspelled
+//     block name, which silently render nothing
+//   - set tags assigning a loop variable inside its loop, or a macro
+//     parameter inside the macro
+//   - variables named like the engine's variables or the built-in globals,
+//     such as caller or range
+//   - includes and embeds of templates that fail to compile
+//
+// The diagnostics are sorted by template, line and column. Only templates
+// and parents named by string literals are followed. Templates that fail to
+// compile are left out of the analysis and their errors returned joined
+// together, along with the diagnostics of the others.
+func (e *Environment) Lint() ([]Diagnostic, error) {
+	names, err := e.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{env: e, templates: make(map[string]*lintedTemplate)}
+	var errs []error
+	for _, name := range names {
+		if _, err := l.load(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l.names = append(l.names, name)
+	}
+
+	for _, name := range l.names {
+		l.scan(name)
+	}
+	for _, name := range l.names {
+		l.checkBlocks(name)
+		l.checkShadowing(name)
+	}
+	l.checkMacros()
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.Template != b.Template {
+			return a.Template < b.Template
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diagnostics, errors.Join(errs...)
+}
+
+// linter holds the state of a Lint run
+type linter struct {
+	env         *Environment
+	names       []string // Templates listed by the loader that compile
+	templates   map[string]*lintedTemplate
+	diagnostics []Diagnostic
+}
+
+// lintedTemplate is what the linter knows about a template
+type lintedTemplate struct {
+	ast *parser.TemplateNode
+	err error
+
+	scanned    bool
+	parent     string                       // Literal name of the template extended, if any
+	dynamic    bool                         // The template extends a template named by an expression
+	includes   []string                     // Templates included with literal names
+	macros     []*parser.MacroNode          // Macros defined anywhere in the template
+	references map[string]bool              // Names the template reads, calls or filters with
+	imported   map[string]map[string]bool   // Names the template takes from each template it imports
+	wholesale  map[string]bool              // Templates imported as a namespace used other than by attribute
+	blocks     map[string]*parser.BlockNode // Every block of the template, by name
+}
+
+// load compiles the template called name, once per Lint run
+func (l *linter) load(name string) (*lintedTemplate, error) {
+	if t, ok := l.templates[name]; ok {
+		return t, t.err
+	}
+	t := &lintedTemplate{}
+	tmpl, err := l.env.GetTemplate(name)
+	if err == nil {
+		if t.ast = tmpl.GetASTAsTemplateNode(); t.ast == nil {
+			err = fmt.Errorf("template %q has no syntax tree", name)
+		}
+	}
+	t.err = err
+	l.templates[name] = t
+	return t, err
+}
+
+// report adds a diagnostic at node
+func (l *linter) report(code DiagnosticCode, template string, node parser.Node, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Code:     code,
+		Template: template,
+		Line:     node.Line(),
+		Column:   node.Column(),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// scan collects the definitions and references of the template called name,
+// and reports includes of templates that fail to compile
+func (l *linter) scan(name string) *lintedTemplate {
+	t, err := l.load(name)
+	if err != nil || t.scanned {
+		return t
+	}
+	t.scanned = true
+	t.references = make(map[string]bool)
+	t.imported = make(map[string]map[string]bool)
+	t.wholesale = make(map[string]bool)
+	t.blocks = make(map[string]*parser.BlockNode)
+
+	// Import aliases first, so namespace attributes are told apart from
+	// other uses of the alias wherever the import is
+	aliases := make(map[string]string)
+	parser.Walk(t.ast, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.ImportNode:
+			if source, ok := literalString(n.Template); ok {
+				aliases[n.Alias] = source
+			}
+		case *parser.FromNode:
+			if source, ok := literalString(n.Template); ok {
+				for _, imported := range n.Names {
+					t.importName(source, imported)
+				}
+			}
+		}
+		return true
+	})
+
+	var visit func(node parser.Node) bool
+	visit = func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.ExtendsNode:
+			if parent, ok := literalString(n.Template); ok {
+				t.parent = parent
+			} else {
+				t.dynamic = true
+			}
+		case *parser.IncludeNode:
+			l.checkInclude(name, t, n, n.Template)
+		case *parser.EmbedNode:
+			l.checkInclude(name, t, n, n.Template)
+		case *parser.MacroNode:
+			t.macros = append(t.macros, n)
+		case *parser.BlockNode:
+			if _, seen := t.blocks[n.Name]; !seen {
+				t.blocks[n.Name] = n
+			}
+		case *parser.IdentifierNode:
+			t.references[n.Name] = true
+			if source, ok := aliases[n.Name]; ok {
+				t.wholesale[source] = true
+			}
+		case *parser.AttributeNode:
+			if object, ok := n.Object.(*parser.IdentifierNode); ok {
+				if source, ok := aliases[object.Name]; ok {
+					t.importName(source, n.Attribute)
+					return false
+				}
+			}
+		case *parser.FilterNode:
+			t.references[n.FilterName] = true
+		case *parser.SetNode:
+			// Assigning a name doesn't use it
+			for _, target := range n.Targets {
+				if _, ok := target.(*parser.IdentifierNode); !ok {
+					parser.Walk(target, visit)
+				}
+			}
+			parser.Walk(n.Value, visit)
+			return false
+		}
+		return true
+	}
+	parser.Walk(t.ast, visit)
+	return t
+}
+
+// importName records that the template takes name from source
+func (t *lintedTemplate) importName(source, name string) {
+	if t.imported[source] == nil {
+		t.imported[source] = make(map[string]bool)
+	}
+	t.imported[source][name] = true
+}
+
+// checkInclude reports an include or embed of a template that exists but
+// fails to compile. A list of names is checked name by name.
+func (l *linter) checkInclude(name string, t *lintedTemplate, node parser.Node, template parser.ExpressionNode) {
+	targets := []parser.ExpressionNode{template}
+	if list, ok := template.(*parser.ListNode); ok {
+		targets = list.Elements
+	}
+	for _, target := range targets {
+		included, ok := literalString(target)
+		if !ok {
+			continue
+		}
+		t.includes = append(t.includes, included)
+		if _, err := l.load(included); err != nil && !isTemplateNotFound(err) {
+			l.report(DiagnosticBrokenInclude, name, node, "included template %q fails to compile: %v", included, err)
+		}
+	}
+}
+
+// ancestorBlocks returns the names of the blocks the template called name
+// and its parents define. ok is false when the chain can't be followed to
+// its root, through a dynamic or missing parent or a cycle.
+func (l *linter) ancestorBlocks(name string) (blocks map[string]bool, chain []string, ok bool) {
+	blocks = make(map[string]bool)
+	for name != "" {
+		if slices.Contains(chain, name) {
+			return nil, nil, false
+		}
+		t := l.scan(name)
+		if t.err != nil || t.dynamic {
+			return nil, nil, false
+		}
+		chain = append(chain, name)
+		for block := range t.blocks {
+			blocks[block] = true
+		}
+		name = t.parent
+	}
+	return blocks, chain, true
+}
+
+// checkBlocks reports the blocks of the template called name that its
+// parents don't define, and the blocks of its embed tags that the embedded
+// template doesn't
+func (l *linter) checkBlocks(name string) {
+	t := l.scan(name)
+	if t.parent != "" {
+		if blocks, chain, ok := l.ancestorBlocks(t.parent); ok {
+			l.checkOverrides(name, t.ast.Children, blocks, fmt.Sprintf("any parent of %q (%s)", name, strings.Join(chain, ", ")))
+		}
+	}
+
+	parser.Walk(t.ast, func(node parser.Node) bool {
+		if embed, ok := node.(*parser.EmbedNode); ok {
+			if embedded, ok := literalString(embed.Template); ok {
+				if blocks, _, ok := l.ancestorBlocks(embedded); ok {
+					l.checkOverrides(name, embed.Body, blocks, fmt.Sprintf("embedded template %q", embedded))
+				}
+			}
+		}
+		return true
+	})
+}
+
+// checkOverrides reports the outermost blocks among nodes whose names aren't
+// in blocks. Blocks nested in those are new blocks of the overriding block,
+// which render with it.
+func (l *linter) checkOverrides(name string, nodes []parser.Node, blocks map[string]bool, where string) {
+	var defined []string
+	for block := range blocks {
+		defined = append(defined, block)
+	}
+	for _, node := range nodes {
+		parser.Walk(node, func(node parser.Node) bool {
+			switch n := node.(type) {
+			case *parser.EmbedNode:
+				return false
+			case *parser.BlockNode:
+				if !blocks[n.Name] {
+					hint := ""
+					if suggestion := runtime.ClosestName(n.Name, defined); suggestion != "" {
+						hint = fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+					}
+					l.report(DiagnosticUnknownBlock, name, n, "block %q is not defined in %s, so it renders nothing%s", n.Name, where, hint)
+				}
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// lintScope is a loop or macro enclosing a set tag
+type lintScope struct {
+	node  parser.Node
+	names []string
+}
+
+// shadowChecker reports set tags assigning names of the loops and macros
+// around them
+type shadowChecker struct {
+	l      *linter
+	name   string
+	scopes []lintScope
+}
+
+func (c *shadowChecker) Enter(node parser.Node) bool {
+	switch n := node.(type) {
+	case *parser.ForNode:
+		c.reserved(n, "loop variable", n.Variables...)
+		c.scopes = append(c.scopes, lintScope{n, append([]string{"loop"}, n.Variables...)})
+	case *parser.MacroNode:
+		c.reserved(n, "macro", n.Name)
+		for _, param := range n.Parameters {
+			// A macro may take its caller, varargs and kwargs as parameters
+			if param != "caller" && param != "varargs" && param != "kwargs" {
+				c.reserved(n, "parameter", param)
+			}
+		}
+		c.scopes = append(c.scopes, lintScope{n, n.Parameters})
+	case *parser.SetNode:
+		for _, target := range n.Targets {
+			if ident, ok := target.(*parser.IdentifierNode); ok && !c.check(n, ident.Name) {
+				c.reserved(n, "set", ident.Name)
+			}
+		}
+	case *parser.BlockSetNode:
+		if !c.check(n, n.Variable) {
+			c.reserved(n, "set", n.Variable)
+		}
+	case *parser.WithNode:
+		c.reserved(n, "with variable", slices.Sorted(maps.Keys(n.Assignments))...)
+	case *parser.ImportNode:
+		c.reserved(n, "import alias", n.Alias)
+	case *parser.FromNode:
+		for _, name := range n.Names {
+			if alias, ok := n.Aliases[name]; ok {
+				name = alias
+			}
+			c.reserved(n, "imported name", name)
+		}
+	}
+	return true
+}
+
+// reserved reports node for each of names that is reserved, a what such as
+// "set", and whether any was
+func (c *shadowChecker) reserved(node parser.Node, what string, names ...string) bool {
+	found := false
+	for _, name := range names {
+		if meaning := reservedMeaning(name); meaning != "" {
+			c.l.report(DiagnosticReservedName, c.name, node, "%s %q hides %s", what, name, meaning)
+			found = true
+		}
+	}
+	return found
+}
+
+func (c *shadowChecker) Exit(node parser.Node) {
+	if len(c.scopes) > 0 && c.scopes[len(c.scopes)-1].node == node {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	}
+}
+
+// check reports the set tag node if it assigns a name of the innermost loops
+// up to the enclosing macro, and returns whether it did
+func (c *shadowChecker) check(node parser.Node, variable string) bool {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		scope := c.scopes[i]
+		if !slices.Contains(scope.names, variable) {
+			if _, isMacro := scope.node.(*parser.MacroNode); isMacro {
+				return false
+			}
+			continue
+		}
+		switch s := scope.node.(type) {
+		case *parser.ForNode:
+			c.l.report(DiagnosticShadowedVariable, c.name, node, "set of %q shadows the loop variable of the for loop at line %d", variable, s.Line())
+		case *parser.MacroNode:
+			c.l.report(DiagnosticShadowedVariable, c.name, node, "set of %q shadows the parameter of macro %q", variable, s.Name)
+		}
+		return true
+	}
+	return false
+}
+
+// checkShadowing reports the set tags of the template called name that
+// assign loop variables or macro parameters
+func (l *linter) checkShadowing(name string) {
+	parser.WalkVisitor(l.scan(name).ast, &shadowChecker{l: l, name: name})
+}
+
+// checkMacros reports the macros no template of the set uses. A macro is
+// used by a reference to its name in its own template, in the templates
+// extending it or in those it includes, and by imports of it, by name or as
+// an attribute of the imported namespace.
+func (l *linter) checkMacros() {
+	for _, name := range l.names {
+		t := l.scan(name)
+		for _, macro := range t.macros {
+			if !l.macroUsed(name, macro.Name) {
+				l.report(DiagnosticUnusedMacro, name, macro, "macro %q is never called", macro.Name)
+			}
+		}
+	}
+}
+
+// macroUsed reports whether a template of the set uses the macro called
+// macro of the template called name
+func (l *linter) macroUsed(name, macro string) bool {
+	t := l.scan(name)
+	if t.references[macro] {
+		return true
+	}
+	for _, included := range t.includes {
+		if i := l.scan(included); i.err == nil && i.references[macro] {
+			return true
+		}
+	}
+	for _, other := range l.names {
+		o := l.scan(other)
+		if o.imported[name][macro] || o.wholesale[name] {
+			return true
+		}
+		if other != name && l.extends(other, name) && o.references[macro] {
+			return true
+		}
+	}
+	return false
+}
+
+// extends reports whether the template called name has ancestor among its
+// parents
+func (l *linter) extends(name, ancestor string) bool {
+	var seen []string
+	for t := l.scan(name); t.err == nil && t.parent != ""; t = l.scan(t.parent) {
+		if t.parent == ancestor {
+			return true
+		}
+		if slices.Contains(seen, t.parent) {
+			return false
+		}
+		seen = append(seen, t.parent)
+	}
+	return false
+}
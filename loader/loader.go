@@ -2,14 +2,19 @@ package loader
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zipreport/miya/parser"
@@ -23,12 +28,20 @@ var (
 	reFromPattern    = regexp.MustCompile(`{%\s*from\s+['"]([^'"]+)['"]`)
 )
 
+// Errors returned (wrapped) by loaders so callers can tell a missing template
+// from a name that was rejected
+var (
+	ErrTemplateNotFound  = errors.New("template not found")
+	ErrOutsideSearchPath = errors.New("template outside search path")
+)
+
 // TemplateSource represents a template source with metadata
 type TemplateSource struct {
 	Name     string
 	Content  string
 	ModTime  time.Time
 	Checksum string
+	Filename string // File the content was read from; the name for loaders without files
 }
 
 // Base Loader interface (keeping compatibility with existing code)
@@ -46,6 +59,21 @@ type AdvancedLoader interface {
 	GetSourceWithMetadata(name string) (*TemplateSource, error)
 }
 
+// SourceLoader is implemented by loaders that report the file a template's
+// source comes from. Environments keep the source and filename of the
+// templates loaded from one, so runtime errors show the lines around the
+// error and text templates are told apart by their file's extension.
+type SourceLoader interface {
+	GetSourceWithFilename(name string) (source, filename string, err error)
+}
+
+// Notifier is implemented by loaders whose templates can change at runtime.
+// Subscribers are called with the template name after it is updated or removed,
+// until they call the unsubscribe function Subscribe returns.
+type Notifier interface {
+	Subscribe(fn func(name string)) (unsubscribe func())
+}
+
 // DiscoveryLoader interface for advanced template discovery
 type DiscoveryLoader interface {
 	AdvancedLoader
@@ -125,28 +153,58 @@ func ReadAll(r io.Reader) (string, error) {
 	return string(data), nil
 }
 
+// DefaultIgnorePatterns lists the file name patterns a FileSystemLoader never
+// loads or lists unless configured otherwise: dotfiles and editor leftovers
+var DefaultIgnorePatterns = []string{".*", "*~", "*.swp", "*.swo", "*.tmp"}
+
 // FileSystemLoader loads templates from the filesystem
 type FileSystemLoader struct {
-	searchPaths []string
-	extensions  []string
-	encoding    string
-	followLinks bool
-	cache       map[string]*cachedTemplate
-	cacheMutex  sync.RWMutex
-	parser      TemplateParser
-	stats       CacheStats
+	searchPaths    []string
+	extensions     []string
+	ignorePatterns []string
+	encoding       string
+	followLinks    bool
+	cache          map[string]*cachedTemplate
+	cacheMutex     sync.RWMutex
+	parser         TemplateParser
+	stats          CacheStats // Hits and Misses are updated atomically
+}
+
+// FileSystemOption configures a FileSystemLoader
+type FileSystemOption func(*FileSystemLoader)
+
+// WithFollowSymlinks controls whether symbolic links to files and directories
+// inside the search paths are followed. Links are followed by default.
+func WithFollowSymlinks(follow bool) FileSystemOption {
+	return func(f *FileSystemLoader) {
+		f.followLinks = follow
+	}
+}
+
+// WithIgnorePatterns replaces DefaultIgnorePatterns. Patterns use path.Match
+// syntax and are matched against every path segment of a template name, and
+// against the whole name when they contain a '/'.
+func WithIgnorePatterns(patterns ...string) FileSystemOption {
+	return func(f *FileSystemLoader) {
+		f.ignorePatterns = patterns
+	}
 }
 
 // NewFileSystemLoader creates a new filesystem loader
-func NewFileSystemLoader(searchPaths []string, parser TemplateParser) *FileSystemLoader {
-	return &FileSystemLoader{
-		searchPaths: searchPaths,
-		extensions:  []string{".html", ".htm", ".jinja", ".jinja2", ".j2"},
-		encoding:    "utf-8",
-		followLinks: false,
-		cache:       make(map[string]*cachedTemplate),
-		parser:      parser,
+func NewFileSystemLoader(searchPaths []string, parser TemplateParser, opts ...FileSystemOption) *FileSystemLoader {
+	f := &FileSystemLoader{
+		searchPaths:    searchPaths,
+		extensions:     []string{".html", ".htm", ".jinja", ".jinja2", ".j2"},
+		ignorePatterns: DefaultIgnorePatterns,
+		encoding:       "utf-8",
+		followLinks:    true,
+		cache:          make(map[string]*cachedTemplate),
+		parser:         parser,
 	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
 // SetExtensions sets the file extensions to search for
@@ -159,6 +217,11 @@ func (f *FileSystemLoader) SetFollowLinks(follow bool) {
 	f.followLinks = follow
 }
 
+// SetIgnorePatterns sets the file name patterns that are never loaded or listed
+func (f *FileSystemLoader) SetIgnorePatterns(patterns []string) {
+	f.ignorePatterns = patterns
+}
+
 // GetSource implements the base Loader interface
 func (f *FileSystemLoader) GetSource(name string) (string, error) {
 	source, err := f.GetSourceWithMetadata(name)
@@ -170,6 +233,11 @@ func (f *FileSystemLoader) GetSource(name string) (string, error) {
 
 // IsCached checks if a template is cached
 func (f *FileSystemLoader) IsCached(name string) bool {
+	name, err := canonicalTemplateName(name)
+	if err != nil {
+		return false
+	}
+
 	f.cacheMutex.RLock()
 	defer f.cacheMutex.RUnlock()
 
@@ -179,15 +247,20 @@ func (f *FileSystemLoader) IsCached(name string) bool {
 
 // LoadTemplate loads and parses a template by name
 func (f *FileSystemLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
+	name, err := canonicalTemplateName(name)
+	if err != nil {
+		return nil, err
+	}
+
 	f.cacheMutex.RLock()
 	if cached, ok := f.cache[name]; ok && !f.isExpired(cached) {
 		f.cacheMutex.RUnlock()
-		f.stats.Hits++
+		atomic.AddInt64(&f.stats.Hits, 1)
 		return cached.template, nil
 	}
 	f.cacheMutex.RUnlock()
 
-	f.stats.Misses++
+	atomic.AddInt64(&f.stats.Misses, 1)
 
 	source, err := f.GetSourceWithMetadata(name)
 	if err != nil {
@@ -213,6 +286,11 @@ func (f *FileSystemLoader) LoadTemplate(name string) (*parser.TemplateNode, erro
 
 // GetSourceWithMetadata retrieves the source content of a template with metadata
 func (f *FileSystemLoader) GetSourceWithMetadata(name string) (*TemplateSource, error) {
+	name, err := canonicalTemplateName(name)
+	if err != nil {
+		return nil, err
+	}
+
 	resolvedPath, err := f.findTemplate(name)
 	if err != nil {
 		return nil, err
@@ -229,23 +307,30 @@ func (f *FileSystemLoader) GetSourceWithMetadata(name string) (*TemplateSource,
 	}
 
 	return &TemplateSource{
-		Name:    name,
-		Content: string(content),
-		ModTime: stat.ModTime(),
+		Name:     name,
+		Content:  NormalizeSource(content),
+		ModTime:  stat.ModTime(),
+		Filename: resolvedPath,
 	}, nil
 }
 
-// ResolveTemplateName resolves a template name to its canonical form
-func (f *FileSystemLoader) ResolveTemplateName(name string) string {
-	// Clean the path and remove any directory traversal attempts
-	name = filepath.Clean(name)
-	name = strings.TrimPrefix(name, "/")
+// GetSourceWithFilename implements SourceLoader, returning the path of the
+// template's file
+func (f *FileSystemLoader) GetSourceWithFilename(name string) (string, string, error) {
+	source, err := f.GetSourceWithMetadata(name)
+	if err != nil {
+		return "", "", err
+	}
+	return source.Content, source.Filename, nil
+}
 
-	// Ensure no directory traversal
-	if strings.Contains(name, "..") {
+// ResolveTemplateName resolves a template name to its canonical form, returning
+// an empty string for names that escape the search paths
+func (f *FileSystemLoader) ResolveTemplateName(name string) string {
+	name, err := canonicalTemplateName(name)
+	if err != nil {
 		return ""
 	}
-
 	return name
 }
 
@@ -255,56 +340,114 @@ func (f *FileSystemLoader) ListTemplates() ([]string, error) {
 	seen := make(map[string]bool)
 
 	for _, searchPath := range f.searchPaths {
-		err := filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				// Skip directories we can't read
-				if d != nil && d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
+		f.walkTemplates(searchPath, searchPath, make(map[string]bool), func(templateName string) {
+			if !seen[templateName] {
+				templates = append(templates, templateName)
+				seen[templateName] = true
 			}
+		})
+	}
 
-			if d.IsDir() {
-				return nil
-			}
+	return templates, nil
+}
 
-			// Check if file has a valid extension
-			ext := filepath.Ext(path)
-			validExt := false
-			for _, validExtension := range f.extensions {
-				if ext == validExtension {
-					validExt = true
-					break
-				}
-			}
+// walkTemplates visits every template below dir, following directory symlinks
+// when enabled. Directories already on the current path are skipped so link
+// cycles terminate; unreadable directories are skipped silently.
+func (f *FileSystemLoader) walkTemplates(root, dir string, ancestors map[string]bool, visit func(name string)) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil || ancestors[realDir] {
+		return
+	}
+	ancestors[realDir] = true
+	defer delete(ancestors, realDir)
 
-			if !validExt {
-				return nil
-			}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		fullPath := filepath.Join(dir, entry.Name())
+		relPath, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			continue
+		}
+
+		// Convert to forward slashes for consistency
+		templateName := filepath.ToSlash(relPath)
+		if f.ignoredBy(templateName) != "" {
+			continue
+		}
 
-			// Get relative path from search path
-			relPath, err := filepath.Rel(searchPath, path)
+		isDir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if !f.followLinks {
+				continue
+			}
+			stat, err := os.Stat(fullPath)
 			if err != nil {
-				return nil
+				continue
 			}
+			isDir = stat.IsDir()
+		}
 
-			// Convert to forward slashes for consistency
-			templateName := filepath.ToSlash(relPath)
+		if isDir {
+			f.walkTemplates(root, fullPath, ancestors, visit)
+			continue
+		}
 
-			if !seen[templateName] {
-				templates = append(templates, templateName)
-				seen[templateName] = true
-			}
+		if f.hasValidExtension(templateName) {
+			visit(templateName)
+		}
+	}
+}
 
-			return nil
-		})
+// hasValidExtension checks if a template name has one of the configured extensions
+func (f *FileSystemLoader) hasValidExtension(name string) bool {
+	ext := filepath.Ext(name)
+	for _, validExtension := range f.extensions {
+		if ext == validExtension {
+			return true
+		}
+	}
+	return false
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to walk directory %s: %v", searchPath, err)
+// ignoredBy returns the ignore pattern matching a template name, or "" if none does
+func (f *FileSystemLoader) ignoredBy(name string) string {
+	segments := strings.Split(name, "/")
+	for _, pattern := range f.ignorePatterns {
+		if strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, name); matched {
+				return pattern
+			}
+			continue
+		}
+		for _, segment := range segments {
+			if matched, _ := path.Match(pattern, segment); matched {
+				return pattern
+			}
 		}
 	}
+	return ""
+}
 
-	return templates, nil
+// canonicalTemplateName cleans a template name so equivalent spellings such as
+// "./pages//home.html" and "pages/../pages/home.html" share one cache entry.
+// Names that would resolve above the search root are rejected.
+func canonicalTemplateName(name string) (string, error) {
+	cleaned := strings.ReplaceAll(name, "\\", "/")
+	cleaned = path.Clean(strings.TrimLeft(cleaned, "/"))
+
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %s", ErrOutsideSearchPath, name)
+	}
+	if cleaned == "." {
+		return "", fmt.Errorf("invalid template name %q", name)
+	}
+
+	return cleaned, nil
 }
 
 // SearchTemplates searches for templates matching a pattern (glob-style)
@@ -479,23 +622,46 @@ func (f *FileSystemLoader) GetCacheStats() CacheStats {
 	f.cacheMutex.RLock()
 	defer f.cacheMutex.RUnlock()
 
-	stats := f.stats
-	stats.Size = len(f.cache)
-	return stats
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&f.stats.Hits),
+		Misses: atomic.LoadInt64(&f.stats.Misses),
+		Size:   len(f.cache),
+	}
+}
+
+// IsRelativeName reports whether a template reference is relative to the
+// template that contains it, i.e. starts with "./" or "../"
+func IsRelativeName(name string) bool {
+	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../")
+}
+
+// ResolveRelativeName resolves a "./" or "../" reference made from the template
+// named from. Other names are returned unchanged. References that climb above
+// the loader root wrap ErrOutsideSearchPath.
+func ResolveRelativeName(from, name string) (string, error) {
+	if !IsRelativeName(name) {
+		return name, nil
+	}
+
+	resolved, err := canonicalTemplateName(path.Join(path.Dir(from), name))
+	if errors.Is(err, ErrOutsideSearchPath) {
+		return "", fmt.Errorf("%w: %s (relative to %s)", ErrOutsideSearchPath, name, from)
+	}
+	return resolved, err
 }
 
-// findTemplate finds the full path to a template file
+// findTemplate finds the full path to a template file. The name must
+// already be canonical.
 func (f *FileSystemLoader) findTemplate(name string) (string, error) {
-	name = f.ResolveTemplateName(name)
-	if name == "" {
-		return "", fmt.Errorf("invalid template name")
+	if pattern := f.ignoredBy(name); pattern != "" {
+		return "", fmt.Errorf("%w: %s (matches ignore pattern %q)", ErrTemplateNotFound, name, pattern)
 	}
 
 	// Try each search path
 	for _, searchPath := range f.searchPaths {
 		// Try the name as-is first
-		fullPath := filepath.Join(searchPath, name)
-		if f.fileExists(fullPath) {
+		fullPath := filepath.Join(searchPath, filepath.FromSlash(name))
+		if f.fileExists(searchPath, fullPath) {
 			return fullPath, nil
 		}
 
@@ -503,45 +669,43 @@ func (f *FileSystemLoader) findTemplate(name string) (string, error) {
 		if filepath.Ext(name) == "" {
 			for _, ext := range f.extensions {
 				fullPathWithExt := fullPath + ext
-				if f.fileExists(fullPathWithExt) {
+				if f.fileExists(searchPath, fullPathWithExt) {
 					return fullPathWithExt, nil
 				}
 			}
 		}
 	}
 
-	return "", fmt.Errorf("template not found: %s", name)
+	return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 }
 
-// fileExists checks if a file exists and is readable
-func (f *FileSystemLoader) fileExists(path string) bool {
-	// Use Lstat to check symlinks without following them
-	stat, err := os.Lstat(path)
-	if err != nil {
-		return false
-	}
-
-	// If followLinks is false, check if it's a symlink
+// fileExists checks if a file below searchPath exists and is readable. When
+// links are not followed, a symlink anywhere between searchPath and the file
+// hides it.
+func (f *FileSystemLoader) fileExists(searchPath, fullPath string) bool {
 	if !f.followLinks {
-		if stat.Mode()&os.ModeSymlink != 0 {
-			return false
-		}
-	}
-
-	// If it's a symlink and we're following links, use Stat to check the target
-	if stat.Mode()&os.ModeSymlink != 0 && f.followLinks {
-		stat, err = os.Stat(path)
+		relPath, err := filepath.Rel(searchPath, fullPath)
 		if err != nil {
 			return false
 		}
+		current := searchPath
+		for _, segment := range strings.Split(relPath, string(filepath.Separator)) {
+			current = filepath.Join(current, segment)
+			stat, err := os.Lstat(current)
+			if err != nil || stat.Mode()&os.ModeSymlink != 0 {
+				return false
+			}
+		}
 	}
 
-	// Check if it's a file and not a directory
-	if stat.IsDir() {
+	// Stat follows links; link cycles surface as errors here
+	stat, err := os.Stat(fullPath)
+	if err != nil {
 		return false
 	}
 
-	return true
+	// Check if it's a file and not a directory
+	return !stat.IsDir()
 }
 
 // isExpired checks if a cached template has expired
@@ -557,7 +721,7 @@ type EmbedLoader struct {
 	cache      map[string]*cachedTemplate
 	cacheMutex sync.RWMutex
 	parser     TemplateParser
-	stats      CacheStats
+	stats      CacheStats // Hits and Misses are updated atomically
 }
 
 // NewEmbedLoader creates a new embed filesystem loader
@@ -599,12 +763,12 @@ func (e *EmbedLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
 	e.cacheMutex.RLock()
 	if cached, ok := e.cache[name]; ok {
 		e.cacheMutex.RUnlock()
-		e.stats.Hits++
+		atomic.AddInt64(&e.stats.Hits, 1)
 		return cached.template, nil
 	}
 	e.cacheMutex.RUnlock()
 
-	e.stats.Misses++
+	atomic.AddInt64(&e.stats.Misses, 1)
 
 	source, err := e.GetSourceWithMetadata(name)
 	if err != nil {
@@ -634,16 +798,27 @@ func (e *EmbedLoader) GetSourceWithMetadata(name string) (*TemplateSource, error
 
 	content, err := e.fs.ReadFile(resolvedPath)
 	if err != nil {
-		return nil, fmt.Errorf("template not found: %s", name)
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 	}
 
 	return &TemplateSource{
-		Name:    name,
-		Content: string(content),
-		ModTime: time.Time{}, // Embedded files don't have meaningful mod times
+		Name:     name,
+		Content:  NormalizeSource(content),
+		ModTime:  time.Time{}, // Embedded files don't have meaningful mod times
+		Filename: resolvedPath,
 	}, nil
 }
 
+// GetSourceWithFilename implements SourceLoader, returning the path of the
+// template's file in the embedded filesystem
+func (e *EmbedLoader) GetSourceWithFilename(name string) (string, string, error) {
+	source, err := e.GetSourceWithMetadata(name)
+	if err != nil {
+		return "", "", err
+	}
+	return source.Content, source.Filename, nil
+}
+
 // ResolveTemplateName resolves a template name to its canonical form
 func (e *EmbedLoader) ResolveTemplateName(name string) string {
 	// Clean the path and remove any directory traversal attempts
@@ -715,9 +890,11 @@ func (e *EmbedLoader) GetCacheStats() CacheStats {
 	e.cacheMutex.RLock()
 	defer e.cacheMutex.RUnlock()
 
-	stats := e.stats
-	stats.Size = len(e.cache)
-	return stats
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&e.stats.Hits),
+		Misses: atomic.LoadInt64(&e.stats.Misses),
+		Size:   len(e.cache),
+	}
 }
 
 // resolvePath resolves a template name to a filesystem path
@@ -786,7 +963,7 @@ func (c *ChainLoader) GetSource(name string) (string, error) {
 		return "", lastErr
 	}
 
-	return "", fmt.Errorf("template not found: %s", name)
+	return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 }
 
 // IsCached checks if any loader has the template cached
@@ -815,7 +992,7 @@ func (c *ChainLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
 		return nil, lastErr
 	}
 
-	return nil, fmt.Errorf("template not found: %s", name)
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 }
 
 // GetSourceWithMetadata gets template source with metadata using the first loader that succeeds
@@ -834,7 +1011,17 @@ func (c *ChainLoader) GetSourceWithMetadata(name string) (*TemplateSource, error
 		return nil, lastErr
 	}
 
-	return nil, fmt.Errorf("template not found: %s", name)
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// GetSourceWithFilename implements SourceLoader using the first loader that
+// has the template. The filename is "" when that loader doesn't report one.
+func (c *ChainLoader) GetSourceWithFilename(name string) (string, string, error) {
+	source, err := c.GetSourceWithMetadata(name)
+	if err != nil {
+		return "", "", err
+	}
+	return source.Content, source.Filename, nil
 }
 
 // ResolveTemplateName resolves using the first loader
@@ -867,10 +1054,20 @@ func (c *ChainLoader) ListTemplates() ([]string, error) {
 	return allTemplates, nil
 }
 
-// StringLoader loads templates from string content (useful for testing)
+// StringLoader loads templates from string content (useful for testing).
+// It is safe to add, update and remove templates while renders are running.
 type StringLoader struct {
-	templates map[string]string
-	parser    TemplateParser
+	templates   map[string]string
+	parser      TemplateParser
+	mu          sync.RWMutex
+	subscribers []subscriber
+	nextID      uint64
+}
+
+// subscriber is a function subscribed to a StringLoader's changes
+type subscriber struct {
+	id uint64
+	fn func(name string)
 }
 
 // NewStringLoader creates a new string loader
@@ -881,31 +1078,122 @@ func NewStringLoader(parser TemplateParser) *StringLoader {
 	}
 }
 
-// AddTemplate adds a template with the given name and content
+// AddTemplate adds a template with the given name and content, replacing any
+// existing template with the same name
 func (s *StringLoader) AddTemplate(name, content string) {
+	s.mu.Lock()
+	_, existed := s.templates[name]
 	s.templates[name] = content
+	s.mu.Unlock()
+
+	if existed {
+		s.notify(name)
+	}
+}
+
+// UpdateTemplate replaces the content of an existing template
+func (s *StringLoader) UpdateTemplate(name, content string) error {
+	s.mu.Lock()
+	if _, exists := s.templates[name]; !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	s.templates[name] = content
+	s.mu.Unlock()
+
+	s.notify(name)
+	return nil
+}
+
+// RemoveTemplate removes a template, reporting whether it existed
+func (s *StringLoader) RemoveTemplate(name string) bool {
+	s.mu.Lock()
+	_, existed := s.templates[name]
+	delete(s.templates, name)
+	s.mu.Unlock()
+
+	if existed {
+		s.notify(name)
+	}
+	return existed
+}
+
+// HasTemplate reports whether a template with the given name exists
+func (s *StringLoader) HasTemplate(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.templates[name]
+	return exists
+}
+
+// Names returns the sorted names of all templates
+func (s *StringLoader) Names() []string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Subscribe implements Notifier. Unsubscribing more than once does nothing.
+func (s *StringLoader) Subscribe(fn func(name string)) func() {
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.subscribers = append(s.subscribers, subscriber{id: id, fn: fn})
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.subscribers = slices.DeleteFunc(s.subscribers, func(sub subscriber) bool {
+			return sub.id == id
+		})
+	}
+}
+
+// notify calls subscribers outside the lock so they may read back from the loader
+func (s *StringLoader) notify(name string) {
+	s.mu.RLock()
+	subscribers := slices.Clone(s.subscribers)
+	s.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.fn(name)
+	}
+}
+
+// lookup returns a template's content under the read lock
+func (s *StringLoader) lookup(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	content, exists := s.templates[name]
+	return content, exists
 }
 
 // GetSource implements the base Loader interface
 func (s *StringLoader) GetSource(name string) (string, error) {
-	content, exists := s.templates[name]
+	content, exists := s.lookup(name)
 	if !exists {
-		return "", fmt.Errorf("template not found: %s", name)
+		return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 	}
 	return content, nil
 }
 
 // IsCached always returns true for string loader (templates are in memory)
 func (s *StringLoader) IsCached(name string) bool {
-	_, exists := s.templates[name]
-	return exists
+	return s.HasTemplate(name)
 }
 
 // LoadTemplate loads and parses a template by name
 func (s *StringLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
-	content, exists := s.templates[name]
+	content, exists := s.lookup(name)
 	if !exists {
-		return nil, fmt.Errorf("template not found: %s", name)
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 	}
 
 	return s.parser.ParseTemplate(name, content)
@@ -913,18 +1201,29 @@ func (s *StringLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
 
 // GetSourceWithMetadata retrieves the source content of a template with metadata
 func (s *StringLoader) GetSourceWithMetadata(name string) (*TemplateSource, error) {
-	content, exists := s.templates[name]
+	content, exists := s.lookup(name)
 	if !exists {
-		return nil, fmt.Errorf("template not found: %s", name)
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
 	}
 
 	return &TemplateSource{
-		Name:    name,
-		Content: content,
-		ModTime: time.Now(),
+		Name:     name,
+		Content:  content,
+		ModTime:  time.Now(),
+		Filename: name,
 	}, nil
 }
 
+// GetSourceWithFilename implements SourceLoader. String templates have no
+// file, so their name stands for it.
+func (s *StringLoader) GetSourceWithFilename(name string) (string, string, error) {
+	content, exists := s.lookup(name)
+	if !exists {
+		return "", "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return content, name, nil
+}
+
 // ResolveTemplateName resolves a template name (identity function for string loader)
 func (s *StringLoader) ResolveTemplateName(name string) string {
 	return name
@@ -932,9 +1231,76 @@ func (s *StringLoader) ResolveTemplateName(name string) string {
 
 // ListTemplates returns a list of all available templates
 func (s *StringLoader) ListTemplates() ([]string, error) {
-	templates := make([]string, 0, len(s.templates))
-	for name := range s.templates {
-		templates = append(templates, name)
+	return s.Names(), nil
+}
+
+// CompiledLoader serves templates from a bundle written by parser.Encode, so
+// templates are available without parsing at runtime. It has no template
+// sources, only their parsed form.
+type CompiledLoader struct {
+	templates map[string]*parser.TemplateNode
+}
+
+// NewCompiledLoader reads a compiled template bundle. Bundles written by a
+// different parser.EncodingVersion are rejected.
+func NewCompiledLoader(r io.Reader) (*CompiledLoader, error) {
+	templates, err := parser.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compiled templates: %w", err)
 	}
-	return templates, nil
+
+	c := &CompiledLoader{templates: make(map[string]*parser.TemplateNode, len(templates))}
+	for _, template := range templates {
+		c.templates[template.Name] = template
+	}
+	return c, nil
+}
+
+// GetSource implements the base Loader interface. Compiled bundles carry no
+// source text, so this always fails.
+func (c *CompiledLoader) GetSource(name string) (string, error) {
+	if _, exists := c.templates[name]; !exists {
+		return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return "", fmt.Errorf("template %s is compiled and has no source", name)
+}
+
+// IsCached reports whether the bundle contains the template
+func (c *CompiledLoader) IsCached(name string) bool {
+	_, exists := c.templates[name]
+	return exists
+}
+
+// LoadTemplate returns the decoded template
+func (c *CompiledLoader) LoadTemplate(name string) (*parser.TemplateNode, error) {
+	template, exists := c.templates[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return template, nil
+}
+
+// GetSourceWithMetadata implements AdvancedLoader; see GetSource
+func (c *CompiledLoader) GetSourceWithMetadata(name string) (*TemplateSource, error) {
+	_, err := c.GetSource(name)
+	return nil, err
+}
+
+// ResolveTemplateName resolves a template name to its canonical form
+func (c *CompiledLoader) ResolveTemplateName(name string) string {
+	name, err := canonicalTemplateName(name)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// ListTemplates returns the sorted names of all templates in the bundle
+func (c *CompiledLoader) ListTemplates() ([]string, error) {
+	names := make([]string, 0, len(c.templates))
+	for name := range c.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
 }
@@ -242,7 +242,7 @@ func TestFileSystemLoaderSymlinks(t *testing.T) {
 	mockParser := &MockParser{}
 	loader := NewFileSystemLoader([]string{templatesDir}, mockParser)
 
-	// By default, symlinks are not followed
+	// Disable following symlinks
 	loader.SetFollowLinks(false)
 
 	// Create a symlink
@@ -2,6 +2,7 @@ package loader
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -362,6 +363,63 @@ func TestStringLoader(t *testing.T) {
 			t.Errorf("Expected 'template.html', got '%s'", resolved)
 		}
 	})
+
+	t.Run("UpdateRemoveAndNotify", func(t *testing.T) {
+		var changed []string
+		loader.Subscribe(func(name string) {
+			changed = append(changed, name)
+		})
+
+		if err := loader.UpdateTemplate("missing.html", "x"); err == nil {
+			t.Error("Expected error when updating non-existent template")
+		}
+
+		loader.AddTemplate("mutable.html", "v1")
+		if err := loader.UpdateTemplate("mutable.html", "v2"); err != nil {
+			t.Fatalf("Failed to update template: %v", err)
+		}
+		if source, _ := loader.GetSource("mutable.html"); source != "v2" {
+			t.Errorf("Expected updated content 'v2', got '%s'", source)
+		}
+
+		if !loader.HasTemplate("mutable.html") {
+			t.Error("Expected HasTemplate to report mutable.html")
+		}
+		if !loader.RemoveTemplate("mutable.html") {
+			t.Error("Expected RemoveTemplate to report an existing template")
+		}
+		if loader.RemoveTemplate("mutable.html") {
+			t.Error("Expected RemoveTemplate to report a missing template")
+		}
+		if loader.HasTemplate("mutable.html") {
+			t.Error("Removed template should not exist")
+		}
+
+		if len(changed) != 2 || changed[0] != "mutable.html" || changed[1] != "mutable.html" {
+			t.Errorf("Expected two notifications for mutable.html, got %v", changed)
+		}
+
+		unsubscribe := loader.Subscribe(func(name string) {
+			t.Errorf("Unsubscribed function notified of %s", name)
+		})
+		unsubscribe()
+		unsubscribe()
+		loader.AddTemplate("mutable.html", "v3")
+		if err := loader.UpdateTemplate("mutable.html", "v4"); err != nil {
+			t.Fatalf("Failed to update template: %v", err)
+		}
+		if len(changed) != 3 {
+			t.Errorf("Expected three notifications, got %v", changed)
+		}
+
+		names := loader.Names()
+		for i := 1; i < len(names); i++ {
+			if names[i-1] > names[i] {
+				t.Errorf("Expected sorted names, got %v", names)
+				break
+			}
+		}
+	})
 }
 
 func TestChainLoader(t *testing.T) {
@@ -551,6 +609,30 @@ func TestReadAllFunction(t *testing.T) {
 	}
 }
 
+func TestNormalizeSource(t *testing.T) {
+	tests := map[string]string{
+		"plain\n":             "plain\n",
+		"\ufeffwith bom":      "with bom",
+		"a\r\nb\r\n":          "a\nb\n",
+		"old\rmac":            "old\nmac",
+		"inner \ufeff kept\r": "inner \ufeff kept\n",
+	}
+	for input, expected := range tests {
+		if result := NormalizeSource([]byte(input)); result != expected {
+			t.Errorf("NormalizeSource(%q): expected %q, got %q", input, expected, result)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "crlf.html"), []byte("\ufeff<p>\r\n{{ x }}\r\n</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err := NewFileSystemLoader([]string{dir}, &MockParser{}).GetSource("crlf.html")
+	if err != nil || content != "<p>\n{{ x }}\n</p>" {
+		t.Errorf("FileSystemLoader: got %q, %v", content, err)
+	}
+}
+
 func TestCacheExpiration(t *testing.T) {
 	templatesDir := createTestTemplates(t)
 	parser := &MockParser{}
@@ -606,7 +688,7 @@ func TestSecurityFeatures(t *testing.T) {
 	})
 
 	t.Run("SymlinkHandling", func(t *testing.T) {
-		// Test that symlinks are not followed by default
+		// Test that symlinks are not followed when disabled
 		loader.SetFollowLinks(false)
 
 		// Create a symlink to a file within the template directory
@@ -619,7 +701,7 @@ func TestSecurityFeatures(t *testing.T) {
 		}
 
 		_, err := loader.LoadTemplate("symlink.html")
-		// Should fail because symlinks aren't followed by default
+		// Should fail because symlinks aren't followed
 		if err == nil {
 			t.Error("Expected error when accessing symlink with followLinks=false")
 		}
@@ -629,6 +711,157 @@ func TestSecurityFeatures(t *testing.T) {
 	})
 }
 
+func TestFileSystemLoaderNamesAndLinks(t *testing.T) {
+	templatesDir := createTestTemplates(t)
+	parser := &MockParser{}
+
+	sharedDir := filepath.Join(t.TempDir(), "shared")
+	if err := os.MkdirAll(sharedDir, 0755); err != nil {
+		t.Fatalf("Failed to create shared directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "widget.html"), []byte("<widget>"), 0644); err != nil {
+		t.Fatalf("Failed to create shared template: %v", err)
+	}
+	if err := os.Symlink(sharedDir, filepath.Join(templatesDir, "shared")); err != nil {
+		t.Skipf("Cannot create symlink for testing: %v", err)
+	}
+	// A link back to the root creates a cycle that listing must survive
+	if err := os.Symlink(templatesDir, filepath.Join(templatesDir, "sub", "loop")); err != nil {
+		t.Fatalf("Failed to create cyclic symlink: %v", err)
+	}
+	for _, name := range []string{".DS_Store", "page.html.swp", "sub/.hidden.html"} {
+		if err := os.WriteFile(filepath.Join(templatesDir, name), []byte("junk"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	t.Run("FollowsSymlinkedDirectories", func(t *testing.T) {
+		loader := NewFileSystemLoader([]string{templatesDir}, parser)
+
+		source, err := loader.GetSource("shared/widget.html")
+		if err != nil {
+			t.Fatalf("Failed to load template through symlinked directory: %v", err)
+		}
+		if source != "<widget>" {
+			t.Errorf("Expected '<widget>', got '%s'", source)
+		}
+
+		templates, err := loader.ListTemplates()
+		if err != nil {
+			t.Fatalf("Failed to list templates: %v", err)
+		}
+		listed := strings.Join(templates, ",")
+		if !strings.Contains(listed, "shared/widget.html") {
+			t.Errorf("Expected symlinked template in listing, got %v", templates)
+		}
+	})
+
+	t.Run("SymlinksDisabled", func(t *testing.T) {
+		loader := NewFileSystemLoader([]string{templatesDir}, parser, WithFollowSymlinks(false))
+
+		_, err := loader.GetSource("shared/widget.html")
+		if !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Expected ErrTemplateNotFound through symlinked directory, got %v", err)
+		}
+
+		templates, _ := loader.ListTemplates()
+		for _, name := range templates {
+			if strings.HasPrefix(name, "shared/") || strings.HasPrefix(name, "sub/loop/") {
+				t.Errorf("Did not expect symlinked template %s in listing", name)
+			}
+		}
+	})
+
+	t.Run("CanonicalNamesShareCache", func(t *testing.T) {
+		loader := NewFileSystemLoader([]string{templatesDir}, parser)
+
+		for _, name := range []string{"sub/nested.html", "./sub//nested.html", "sub/../sub/nested.html"} {
+			template, err := loader.LoadTemplate(name)
+			if err != nil {
+				t.Fatalf("Failed to load %s: %v", name, err)
+			}
+			if template.Name != "sub/nested.html" {
+				t.Errorf("Expected canonical name 'sub/nested.html', got '%s'", template.Name)
+			}
+		}
+
+		stats := loader.GetCacheStats()
+		if stats.Misses != 1 || stats.Size != 1 {
+			t.Errorf("Expected a single parse for equivalent names, got %d misses and %d entries", stats.Misses, stats.Size)
+		}
+	})
+
+	t.Run("OutsideSearchPath", func(t *testing.T) {
+		loader := NewFileSystemLoader([]string{templatesDir}, parser)
+
+		_, err := loader.LoadTemplate("sub/../../base.html")
+		if !errors.Is(err, ErrOutsideSearchPath) {
+			t.Errorf("Expected ErrOutsideSearchPath, got %v", err)
+		}
+
+		_, err = loader.LoadTemplate("missing.html")
+		if !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+		}
+	})
+
+	t.Run("IgnorePatterns", func(t *testing.T) {
+		loader := NewFileSystemLoader([]string{templatesDir}, parser)
+
+		for _, name := range []string{".DS_Store", "page.html.swp", "sub/.hidden.html"} {
+			if _, err := loader.GetSource(name); !errors.Is(err, ErrTemplateNotFound) {
+				t.Errorf("Expected ignored file %s to be not found, got %v", name, err)
+			}
+		}
+
+		templates, _ := loader.ListTemplates()
+		for _, name := range templates {
+			if strings.Contains(name, ".hidden") {
+				t.Errorf("Did not expect ignored template %s in listing", name)
+			}
+		}
+
+		custom := NewFileSystemLoader([]string{templatesDir}, parser, WithIgnorePatterns("sub/*"))
+		if _, err := custom.GetSource("sub/.hidden.html"); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Expected sub/* to be ignored, got %v", err)
+		}
+		if _, err := custom.GetSource(".DS_Store"); err != nil {
+			t.Errorf("Expected custom patterns to replace the defaults, got %v", err)
+		}
+	})
+}
+
+func TestResolveRelativeName(t *testing.T) {
+	tests := []struct {
+		from, name, expected string
+		outside              bool
+	}{
+		{"emails/order/confirm.html", "./items.html", "emails/order/items.html", false},
+		{"emails/order/confirm.html", "../base.html", "emails/base.html", false},
+		{"emails/order/confirm.html", ".././/order/./x.html", "emails/order/x.html", false},
+		{"emails/order/confirm.html", "emails/base.html", "emails/base.html", false},
+		{"top.html", "./side.html", "side.html", false},
+		{"emails/confirm.html", "../../base.html", "", true},
+	}
+
+	for _, tt := range tests {
+		resolved, err := ResolveRelativeName(tt.from, tt.name)
+		if tt.outside {
+			if !errors.Is(err, ErrOutsideSearchPath) {
+				t.Errorf("%s from %s: expected ErrOutsideSearchPath, got %v", tt.name, tt.from, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s from %s: unexpected error: %v", tt.name, tt.from, err)
+			continue
+		}
+		if resolved != tt.expected {
+			t.Errorf("%s from %s: expected '%s', got '%s'", tt.name, tt.from, tt.expected, resolved)
+		}
+	}
+}
+
 func TestAdvancedLoaderInterface(t *testing.T) {
 	parser := &MockParser{}
 	loader := NewStringLoader(parser)
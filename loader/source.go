@@ -0,0 +1,22 @@
+package loader
+
+import (
+	"bytes"
+	"strings"
+)
+
+// utf8BOM marks UTF-8 text written by some editors
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// NormalizeSource returns the text of a template file as templates are
+// parsed from: without a leading UTF-8 byte order mark, and with Windows
+// (\r\n) and old Mac (\r) line endings turned into \n, as Jinja2 does, so a
+// file renders the same whichever editor saved it. File loaders and
+// Environment.FromFile and FromReader apply it to what they read.
+func NormalizeSource(content []byte) string {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	if bytes.IndexByte(content, '\r') < 0 {
+		return string(content)
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(string(content), "\r\n", "\n"), "\r", "\n")
+}
@@ -23,6 +23,11 @@ func (c *mockContext) GetVariable(name string) (interface{}, bool) {
 	return value, exists
 }
 
+func (c *mockContext) HasVariable(name string) bool {
+	_, exists := c.variables[name]
+	return exists
+}
+
 func (c *mockContext) SetVariable(name string, value interface{}) {
 	c.variables[name] = value
 }
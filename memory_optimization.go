@@ -1,6 +1,8 @@
 package miya
 
 import (
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -319,6 +321,34 @@ func (moc *MemoryOptimizedContext) Set(key string, value interface{}) {
 	moc.data[internedKey] = value
 }
 
+// Has reports whether Get would find key
+func (moc *MemoryOptimizedContext) Has(key string) bool {
+	_, ok := moc.Get(key)
+	return ok
+}
+
+// Delete removes a variable from this scope
+func (moc *MemoryOptimizedContext) Delete(key string) {
+	delete(moc.data, key)
+}
+
+// Update sets the variables in values, skipping those Has reports if
+// preserve is set
+func (moc *MemoryOptimizedContext) Update(values map[string]interface{}, preserve bool) {
+	for k, v := range values {
+		if preserve && moc.Has(k) {
+			continue
+		}
+		moc.Set(k, v)
+	}
+}
+
+// Keys returns the sorted names of the variables in this scope and its
+// parents
+func (moc *MemoryOptimizedContext) Keys() []string {
+	return slices.Sorted(maps.Keys(moc.All()))
+}
+
 // Push creates a new scope
 func (moc *MemoryOptimizedContext) Push() Context {
 	return &MemoryOptimizedContext{
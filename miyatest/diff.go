@@ -0,0 +1,178 @@
+package miyatest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change
+const contextLines = 3
+
+// Diff returns a unified diff turning want into got, labelled wantName and
+// gotName, or "" if they are equal. Every line shows its number in want and
+// in got, and is quoted when it differs only in whitespace from its
+// counterpart, so changed spaces, tabs and line endings are visible.
+func Diff(wantName, gotName, want, got string) string {
+	if want == got {
+		return ""
+	}
+	a, b := splitLines(want), splitLines(got)
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", wantName, gotName)
+	width := len(strconv.Itoa(max(len(a), len(b))))
+	quote := whitespaceOnlyChange(a, b, ops)
+
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk, merging changes
+		// whose context would overlap
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*contextLines {
+				break
+			}
+		}
+		from := max(first-contextLines, start)
+		to := min(end+contextLines, len(ops))
+
+		aStart, bStart, aCount, bCount := 0, 0, 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				if aCount == 0 {
+					aStart = op.a + 1
+				}
+				aCount++
+			}
+			if op.kind != '-' {
+				if bCount == 0 {
+					bStart = op.b + 1
+				}
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+
+		for _, op := range ops[from:to] {
+			aNum, bNum := "", ""
+			text := ""
+			if op.kind != '+' {
+				aNum = strconv.Itoa(op.a + 1)
+				text = a[op.a]
+			}
+			if op.kind != '-' {
+				bNum = strconv.Itoa(op.b + 1)
+				text = b[op.b]
+			}
+			if quote {
+				text = strconv.Quote(text)
+			} else {
+				text = strings.TrimSuffix(text, "\n")
+			}
+			fmt.Fprintf(&out, "%c %*s %*s | %s\n", op.kind, width, aNum, width, bNum, text)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// diffOp is a line of a diff: kept (' ', at a in want and b in got), removed
+// from want ('-', at a) or added to got ('+', at b)
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// diffLines returns the edit script turning a into b, based on their longest
+// common subsequence of lines
+func diffLines(a, b []string) []diffOp {
+	// Lines common to both ends need no table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', i, i})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', prefix + i, prefix + j})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', prefix + i, -1})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', -1, prefix + j})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, diffOp{' ', len(a) - suffix + k, len(b) - suffix + k})
+	}
+	return ops
+}
+
+// whitespaceOnlyChange reports whether some line removed from a equals a
+// line added to b once whitespace is ignored, so the diff must quote lines
+// for the change to be visible
+func whitespaceOnlyChange(a, b []string, ops []diffOp) bool {
+	removed := make(map[string]bool)
+	for _, op := range ops {
+		if op.kind == '-' {
+			removed[strings.Join(strings.Fields(a[op.a]), " ")] = true
+		}
+	}
+	for _, op := range ops {
+		if op.kind == '+' && removed[strings.Join(strings.Fields(b[op.b]), " ")] {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLines splits s after each newline, keeping the newlines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
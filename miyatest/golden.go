@@ -0,0 +1,109 @@
+// Package miyatest compares rendered templates against expected output in
+// tests, with golden files that an environment variable regenerates:
+//
+//	func TestWelcomeEmail(t *testing.T) {
+//		ctx := miya.NewContextFrom(map[string]interface{}{"name": "Ada"})
+//		miyatest.RenderGolden(t, env, "welcome.txt", ctx, "testdata/welcome.golden",
+//			miyatest.TrimTrailingSpace(), miyatest.CollapseBlankLines())
+//	}
+//
+// Running go test with MIYA_UPDATE_GOLDEN=1 writes the rendered output to
+// the golden files instead of comparing. Mismatches are reported as a unified diff numbering
+// the lines of both sides.
+//
+// AssertHTMLContains, AssertSelectorText and AssertNoUnescaped check parts
+// of rendered HTML regardless of attribute order and whitespace.
+package miyatest
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zipreport/miya"
+)
+
+// UpdateEnv is an environment variable that, set to anything but "" or "0",
+// makes RenderGolden rewrite golden files
+const UpdateEnv = "MIYA_UPDATE_GOLDEN"
+
+// UpdateFlag is the name of a boolean command line flag that also makes
+// RenderGolden rewrite golden files. miyatest doesn't define it, so it never
+// clashes with a test package's flags; a test package defining it, e.g.
+//
+//	var update = flag.Bool("update", false, "rewrite golden files")
+//
+// can pass -update instead of setting MIYA_UPDATE_GOLDEN.
+const UpdateFlag = "update"
+
+// Updating reports whether golden files are being rewritten, by the
+// MIYA_UPDATE_GOLDEN environment variable or an -update flag of the test
+// package. It is read on every call, after flags are parsed.
+func Updating() bool {
+	if f := flag.Lookup(UpdateFlag); f != nil {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if update, ok := getter.Get().(bool); ok && update {
+				return true
+			}
+		}
+	}
+	value := os.Getenv(UpdateEnv)
+	return value != "" && value != "0"
+}
+
+// RenderGolden renders the template called templateName and compares the
+// output with the contents of goldenPath, after normalizing both as opts
+// select. A mismatch fails t with a diff. When Updating, the output is
+// written to goldenPath as rendered, creating its directory if needed, and
+// nothing is compared.
+func RenderGolden(t testing.TB, env *miya.Environment, templateName string, ctx miya.Context, goldenPath string, opts ...Option) {
+	t.Helper()
+	got, err := env.RenderTemplate(templateName, ctx)
+	if err != nil {
+		t.Fatalf("rendering %s: %v", templateName, err)
+		return
+	}
+
+	if Updating() {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+			t.Fatalf("updating golden file: %v", err)
+			return
+		}
+		if err := os.WriteFile(goldenPath, []byte(got), 0o644); err != nil {
+			t.Fatalf("updating golden file: %v", err)
+			return
+		}
+		t.Logf("updated golden file %s", goldenPath)
+		return
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist; run go test with %s=1 to create it", goldenPath, UpdateEnv)
+		return
+	}
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+		return
+	}
+	if diff := Diff(goldenPath, templateName, Normalize(string(want), opts...), Normalize(got, opts...)); diff != "" {
+		t.Errorf("%s does not match %s (run go test with %s=1 to accept the output):\n%s", templateName, goldenPath, UpdateEnv, diff)
+	}
+}
+
+// AssertRenderEqual renders the template source and compares the output
+// with expected, after normalizing both as opts select. A mismatch fails t
+// with a diff.
+func AssertRenderEqual(t testing.TB, env *miya.Environment, source string, ctx miya.Context, expected string, opts ...Option) {
+	t.Helper()
+	got, err := env.RenderString(source, ctx)
+	if err != nil {
+		t.Fatalf("rendering template: %v", err)
+		return
+	}
+	if diff := Diff("expected", "rendered", Normalize(expected, opts...), Normalize(got, opts...)); diff != "" {
+		t.Errorf("rendered output does not match:\n%s", diff)
+	}
+}
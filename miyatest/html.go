@@ -0,0 +1,377 @@
+package miyatest
+
+import (
+	"fmt"
+	"html"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// htmlNode is an element of parsed HTML, or a text node when tag is ""
+type htmlNode struct {
+	tag      string
+	attrs    map[string]string
+	text     string
+	parent   *htmlNode
+	children []*htmlNode
+}
+
+// voidElements have no content or end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements hold text up to their end tag, without elements
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// closedBy lists, for a start tag, the open elements it closes, so that
+// lists, paragraphs and table cells written without end tags nest as in
+// browsers
+var closedBy = map[string]map[string]bool{
+	"li":     {"li": true},
+	"p":      {"p": true},
+	"dt":     {"dt": true, "dd": true},
+	"dd":     {"dt": true, "dd": true},
+	"option": {"option": true},
+	"tr":     {"tr": true, "td": true, "th": true},
+	"td":     {"td": true, "th": true},
+	"th":     {"td": true, "th": true},
+}
+
+// parseHTML parses s leniently into a tree under a root node with no tag:
+// comments and doctypes are dropped, end tags without a start tag are
+// ignored and elements left open end with s. Text and attribute values
+// have their character references decoded.
+func parseHTML(s string) *htmlNode {
+	root := &htmlNode{}
+	current := root
+	for s != "" {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			current.appendText(s)
+			break
+		}
+		current.appendText(s[:lt])
+		s = s[lt:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s[4:], "-->")
+			if end < 0 {
+				return root
+			}
+			s = s[4+end+3:]
+		case strings.HasPrefix(s, "<!"), strings.HasPrefix(s, "<?"):
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return root
+			}
+			s = s[end+1:]
+		case strings.HasPrefix(s, "</") && len(s) > 2 && isLetter(s[2]):
+			i := 2
+			for i < len(s) && isNameByte(s[i]) {
+				i++
+			}
+			name := strings.ToLower(s[2:i])
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return root
+			}
+			s = s[i+end+1:]
+			for n := current; n != root; n = n.parent {
+				if n.tag == name {
+					current = n.parent
+					break
+				}
+			}
+		default:
+			name, attributes, selfClosing, n := scanStartTag(s)
+			if n == 0 {
+				current.appendText("<")
+				s = s[1:]
+				continue
+			}
+			s = s[n:]
+
+			element := &htmlNode{tag: strings.ToLower(name), attrs: make(map[string]string, len(attributes))}
+			for _, attribute := range attributes {
+				name, value, _ := strings.Cut(attribute, "=")
+				if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+					value = value[1 : len(value)-1]
+				}
+				element.attrs[strings.ToLower(name)] = html.UnescapeString(value)
+			}
+			for current != root && closedBy[element.tag][current.tag] {
+				current = current.parent
+			}
+			current.append(element)
+
+			switch {
+			case selfClosing || voidElements[element.tag]:
+			case rawTextElements[element.tag]:
+				end := strings.Index(strings.ToLower(s), "</"+element.tag)
+				if end < 0 {
+					end = len(s)
+				}
+				if element.tag == "script" || element.tag == "style" {
+					element.append(&htmlNode{text: s[:end]})
+				} else {
+					element.appendText(s[:end])
+				}
+				s = s[end:]
+				current = element
+			default:
+				current = element
+			}
+		}
+	}
+	return root
+}
+
+func (n *htmlNode) append(child *htmlNode) {
+	child.parent = n
+	n.children = append(n.children, child)
+}
+
+// appendText adds text to n with its character references decoded,
+// extending the last child if it is text
+func (n *htmlNode) appendText(text string) {
+	if text == "" {
+		return
+	}
+	text = html.UnescapeString(text)
+	if last := len(n.children) - 1; last >= 0 && n.children[last].tag == "" {
+		n.children[last].text += text
+		return
+	}
+	n.append(&htmlNode{text: text})
+}
+
+// content returns the children of n without the text nodes that are only
+// whitespace
+func (n *htmlNode) content() []*htmlNode {
+	var nodes []*htmlNode
+	for _, child := range n.children {
+		if child.tag != "" || normalizeText(child.text) != "" {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// textContent returns the text of n and its descendants with whitespace
+// normalized
+func (n *htmlNode) textContent() string {
+	var b strings.Builder
+	var walk func(*htmlNode)
+	walk = func(n *htmlNode) {
+		if n.tag == "" {
+			b.WriteString(n.text)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(n)
+	return normalizeText(b.String())
+}
+
+// elements calls f for n and each element below it in document order
+func (n *htmlNode) elements(f func(*htmlNode)) {
+	if n.tag != "" {
+		f(n)
+	}
+	for _, child := range n.children {
+		if child.tag != "" {
+			child.elements(f)
+		}
+	}
+}
+
+// normalizeText collapses runs of whitespace into single spaces and trims
+// the ends
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// canonicalHTML prints nodes one tag or text per line, indented by depth,
+// with attributes sorted by name, the classes of class attributes sorted
+// and text normalized, so that nodes differing only in what the assertions
+// ignore print the same
+func canonicalHTML(nodes []*htmlNode) string {
+	var b strings.Builder
+	var write func(n *htmlNode, depth int)
+	write = func(n *htmlNode, depth int) {
+		indent := strings.Repeat("  ", depth)
+		if n.tag == "" {
+			fmt.Fprintf(&b, "%s%s\n", indent, normalizeText(n.text))
+			return
+		}
+		fmt.Fprintf(&b, "%s<%s", indent, n.tag)
+		for _, name := range slices.Sorted(maps.Keys(n.attrs)) {
+			value := n.attrs[name]
+			if name == "class" {
+				classes := strings.Fields(value)
+				slices.Sort(classes)
+				value = strings.Join(slices.Compact(classes), " ")
+			}
+			if value == "" {
+				fmt.Fprintf(&b, " %s", name)
+			} else {
+				fmt.Fprintf(&b, " %s=%q", name, value)
+			}
+		}
+		b.WriteString(">\n")
+		if voidElements[n.tag] {
+			return
+		}
+		for _, child := range n.content() {
+			write(child, depth+1)
+		}
+		fmt.Fprintf(&b, "%s</%s>\n", indent, n.tag)
+	}
+	for _, n := range nodes {
+		write(n, 0)
+	}
+	return b.String()
+}
+
+// AssertHTMLContains fails t unless the rendered HTML contains fragment:
+// sibling nodes that match the nodes of fragment in order, with the same
+// tags, the same attributes in any order, the same classes in any order
+// and the same text once whitespace is collapsed. Both sides are parsed
+// leniently, and character references are decoded, so &amp; and &#38;
+// match. A mismatch is reported as a diff against the closest candidate.
+//
+//	miyatest.AssertHTMLContains(t, rendered, `<div class="price">$29.99</div>`)
+func AssertHTMLContains(t testing.TB, rendered, fragment string) {
+	t.Helper()
+	want := parseHTML(fragment).content()
+	if len(want) == 0 {
+		t.Fatalf("AssertHTMLContains: empty HTML fragment %q", fragment)
+		return
+	}
+	wantText := canonicalHTML(want)
+
+	// Candidates are runs of siblings as long as the fragment starting with
+	// a node of the same tag as its first
+	var best string
+	bestScore := -1
+	found := false
+	check := func(parent *htmlNode) {
+		nodes := parent.content()
+		for i := 0; i+len(want) <= len(nodes) && !found; i++ {
+			if nodes[i].tag != want[0].tag {
+				continue
+			}
+			got := canonicalHTML(nodes[i : i+len(want)])
+			if got == wantText {
+				found = true
+				return
+			}
+			if score := commonLines(wantText, got); score > bestScore {
+				best, bestScore = got, score
+			}
+		}
+	}
+	document := parseHTML(rendered)
+	check(document)
+	document.elements(func(n *htmlNode) {
+		if !found {
+			check(n)
+		}
+	})
+	if found {
+		return
+	}
+
+	if bestScore < 0 {
+		what := "text"
+		if want[0].tag != "" {
+			what = "<" + want[0].tag + "> element"
+		}
+		t.Errorf("rendered HTML does not contain the fragment: it has no %s. Rendered HTML:\n%s", what, canonicalHTML(document.content()))
+		return
+	}
+	t.Errorf("rendered HTML does not contain the fragment; closest match:\n%s", Diff("fragment", "rendered", wantText, best))
+}
+
+// commonLines returns the number of lines a and b have in common
+func commonLines(a, b string) int {
+	count := 0
+	for _, op := range diffLines(splitLines(a), splitLines(b)) {
+		if op.kind == ' ' {
+			count++
+		}
+	}
+	return count
+}
+
+// AssertSelectorText fails t unless an element of the rendered HTML
+// matching the CSS selector has the text want, once whitespace is
+// collapsed in both. Selectors combine tag names, *, .class, #id, [attr]
+// and [attr=value] with the descendant and > combinators, such as
+// ".product > h4" or "ul#menu li.active a[href]". The report of a failure
+// lists the elements that do match.
+func AssertSelectorText(t testing.TB, rendered, selector, want string) {
+	t.Helper()
+	steps, err := parseSelector(selector)
+	if err != nil {
+		t.Fatalf("AssertSelectorText: %v", err)
+		return
+	}
+	var matches []*htmlNode
+	parseHTML(rendered).elements(func(n *htmlNode) {
+		if matchSelector(n, steps) {
+			matches = append(matches, n)
+		}
+	})
+	want = normalizeText(want)
+	for _, n := range matches {
+		if n.textContent() == want {
+			return
+		}
+	}
+
+	if len(matches) == 0 {
+		t.Errorf("no element of the rendered HTML matches %q", selector)
+		return
+	}
+	var b strings.Builder
+	for _, n := range matches {
+		fmt.Fprintf(&b, "text %q of\n%s", n.textContent(), canonicalHTML([]*htmlNode{n}))
+	}
+	t.Errorf("no element matching %q has text %q; %d do:\n%s", selector, want, len(matches), b.String())
+}
+
+// AssertNoUnescaped fails t if the rendered output contains raw, compared
+// without regard to case, such as markup from user data that should have
+// been escaped:
+//
+//	miyatest.AssertNoUnescaped(t, rendered, "<script>")
+//
+// Each occurrence is reported with its line.
+func AssertNoUnescaped(t testing.TB, rendered, raw string) {
+	t.Helper()
+	if raw == "" {
+		t.Fatalf("AssertNoUnescaped: empty string")
+		return
+	}
+	needle := strings.ToLower(raw)
+	var found []string
+	for number, line := range strings.Split(rendered, "\n") {
+		if strings.Contains(strings.ToLower(line), needle) {
+			found = append(found, fmt.Sprintf("  line %d: %s", number+1, strings.TrimRight(line, "\r")))
+		}
+	}
+	if found == nil && strings.Contains(strings.ToLower(rendered), needle) {
+		// raw spans lines
+		found = append(found, "  across lines")
+	}
+	if found != nil {
+		t.Errorf("rendered output contains unescaped %q:\n%s", raw, strings.Join(found, "\n"))
+	}
+}
@@ -0,0 +1,290 @@
+package miyatest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+// Test packages importing miyatest define their own -update flag, which
+// would panic if miyatest defined it too
+var _ = flag.Bool(UpdateFlag, false, "rewrite golden files")
+
+// recorder captures the failures the helpers report instead of failing the
+// test running them
+type recorder struct {
+	testing.TB
+	failed bool
+	fatal  bool
+	output string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Logf(format string, args ...interface{}) {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.output += fmt.Sprintf(format, args...)
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	r.fatal = true
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input string
+		opts  []Option
+		want  string
+	}{
+		{"a  \nb\t\r\n", []Option{TrimTrailingSpace()}, "a\nb\n"},
+		{"a\n\n \n\t\nb\n\n", []Option{CollapseBlankLines()}, "a\n\nb\n\n"},
+		{"a \n\n\nb ", nil, "a \n\n\nb "},
+		{`<img src="x.png"  alt='X' width="10"/>`, []Option{SortAttributes()}, `<img alt='X' src="x.png" width="10" />`},
+		{`<input checked type="checkbox" data-id = "1">`, []Option{SortAttributes()}, `<input checked data-id="1" type="checkbox">`},
+		{`a < b and <!-- <x b a> --> <p class="x>y" id=a>`, []Option{SortAttributes()}, `a < b and <!-- <x a b> --> <p class="x>y" id=a>`},
+		{`<div class="unterminated`, []Option{SortAttributes()}, `<div class="unterminated`},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.input, tt.opts...); got != tt.want {
+			t.Errorf("Normalize(%q): got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if diff := Diff("a", "b", "same\n", "same\n"); diff != "" {
+		t.Errorf("equal inputs: got %q", diff)
+	}
+
+	want := strings.Repeat("line\n", 10) + "old\n" + strings.Repeat("line\n", 10)
+	got := strings.Repeat("line\n", 10) + "new\n" + strings.Repeat("line\n", 10) + "extra\n"
+	expected := `--- golden
++++ rendered
+@@ -8,7 +8,7 @@
+   8  8 | line
+   9  9 | line
+  10 10 | line
+- 11    | old
++    11 | new
+  12 12 | line
+  13 13 | line
+  14 14 | line
+@@ -19,3 +19,4 @@
+  19 19 | line
+  20 20 | line
+  21 21 | line
++    22 | extra
+`
+	if diff := Diff("golden", "rendered", want, got); diff != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", diff, expected)
+	}
+
+	// Changes in whitespace alone are quoted to be visible
+	if diff := Diff("a", "b", "x\ny \n", "x\ny\n"); !strings.Contains(diff, `- 2   | "y \n"`) || !strings.Contains(diff, `+   2 | "y\n"`) {
+		t.Errorf("whitespace change not visible:\n%s", diff)
+	}
+}
+
+func TestRenderGolden(t *testing.T) {
+	templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	templates.AddTemplate("card.html", "<div id=\"c\" class=\"card\">{{ name }}</div>   \n\n\n")
+	env := miya.NewEnvironment(miya.WithLoader(templates))
+	ctx := miya.NewContextFrom(map[string]interface{}{"name": "Ada"})
+	golden := filepath.Join(t.TempDir(), "golden", "card.golden")
+
+	r := &recorder{TB: t}
+	RenderGolden(r, env, "card.html", ctx, golden)
+	if !r.fatal || !strings.Contains(r.output, "run go test with MIYA_UPDATE_GOLDEN=1 to create it") {
+		t.Errorf("missing golden file: got %q", r.output)
+	}
+
+	t.Setenv(UpdateEnv, "1")
+	r = &recorder{TB: t}
+	RenderGolden(r, env, "card.html", ctx, golden)
+	t.Setenv(UpdateEnv, "0")
+	if data, err := os.ReadFile(golden); r.failed || err != nil || string(data) != "<div id=\"c\" class=\"card\">Ada</div>   \n\n\n" {
+		t.Fatalf("update: got %q, %v (%s)", data, err, r.output)
+	}
+
+	if err := os.WriteFile(golden, []byte("<div class=\"card\" id=\"c\">Ada</div>\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r = &recorder{TB: t}
+	RenderGolden(r, env, "card.html", ctx, golden)
+	if !r.failed || !strings.Contains(r.output, "@@ -1,2 +1,3 @@") {
+		t.Errorf("mismatch: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	RenderGolden(r, env, "card.html", ctx, golden, TrimTrailingSpace(), CollapseBlankLines(), SortAttributes())
+	if r.failed {
+		t.Errorf("normalized output should match: %s", r.output)
+	}
+
+	// A flag the test package defines is honored too
+	if Updating() {
+		t.Fatal("updating without the flag or the environment variable")
+	}
+	if err := flag.Set(UpdateFlag, "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set(UpdateFlag, "false")
+	if !Updating() {
+		t.Errorf("-%s doesn't enable updating", UpdateFlag)
+	}
+}
+
+func TestAssertRenderEqual(t *testing.T) {
+	env := miya.NewEnvironment()
+	ctx := miya.NewContextFrom(map[string]interface{}{"items": []int{1, 2}})
+	source := "{% for i in items %}\n  <li>{{ i }}</li>  \n{% endfor %}"
+
+	r := &recorder{TB: t}
+	AssertRenderEqual(r, env, source, ctx, "\n  <li>1</li>\n\n  <li>2</li>\n", TrimTrailingSpace())
+	if r.failed {
+		t.Errorf("normalized output should match: %s", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertRenderEqual(r, env, source, ctx, "\n  <li>1</li>\n\n  <li>3</li>\n", TrimTrailingSpace())
+	if !r.failed || !strings.Contains(r.output, "- 4   |   <li>3</li>") {
+		t.Errorf("mismatch: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertRenderEqual(r, env, "{{ ", ctx, "")
+	if !r.fatal {
+		t.Error("a template error should be fatal")
+	}
+}
+
+const productsHTML = `<!DOCTYPE html>
+<ul class="products">
+  <!-- featured first -->
+  <li class="product featured" data-id="1">
+    <h4>Laptop   Pro</h4>
+    <div id="p1" class="price sale">$1,299.99</div>
+  <li class="product" data-id="2">
+    <h4>Mouse &amp; Pad</h4>
+    <div class="price">$29.99</div>
+    <input type=checkbox checked>
+</ul>
+<p>Say <b>hi</b><p>Bye
+<script>if (a < b) { tag = "<div>" }</script>`
+
+func TestAssertHTMLContains(t *testing.T) {
+	for _, fragment := range []string{
+		`<div class="price">$29.99</div>`,
+		`<div class="sale price" id="p1">$1,299.99</div>`,
+		`<h4>Laptop Pro</h4>`,
+		`<h4> Mouse &#38; Pad </h4><div class="price">$29.99</div>`,
+		`<input checked type="checkbox"/>`,
+		`<p>Say <b>hi</b></p>`,
+		`<li data-id="2" class="product"><h4>Mouse &amp; Pad</h4><div class="price">$29.99</div><input type="checkbox" checked></li>`,
+		`Bye`,
+	} {
+		r := &recorder{TB: t}
+		AssertHTMLContains(r, productsHTML, fragment)
+		if r.failed {
+			t.Errorf("%s should match: %s", fragment, r.output)
+		}
+	}
+
+	r := &recorder{TB: t}
+	AssertHTMLContains(r, productsHTML, `<div class="price">$19.99</div>`)
+	if !r.failed || !strings.Contains(r.output, "closest match") || !strings.Contains(r.output, "- 2   |   $19.99") || !strings.Contains(r.output, "+   2 |   $29.99") {
+		t.Errorf("mismatch: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertHTMLContains(r, productsHTML, `<div class="price" id="p1">$1,299.99</div>`)
+	if !r.failed || !strings.Contains(r.output, `+   1 | <div class="price sale" id="p1">`) {
+		t.Errorf("class mismatch: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertHTMLContains(r, productsHTML, `<table></table>`)
+	if !r.failed || !strings.Contains(r.output, "has no <table> element") {
+		t.Errorf("missing tag: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertHTMLContains(r, productsHTML, " <!-- --> ")
+	if !r.fatal {
+		t.Error("an empty fragment should be fatal")
+	}
+}
+
+func TestAssertSelectorText(t *testing.T) {
+	for selector, want := range map[string]string{
+		".product h4":                "Laptop Pro",
+		"ul.products > li > h4":      "Mouse & Pad",
+		"li.featured.product .price": "$1,299.99",
+		"#p1":                        "$1,299.99",
+		`li[data-id="2"] div`:        "$29.99",
+		"li[data-id] *[class=price]": "$29.99",
+		"p b":                        "hi",
+		"P":                          "Say hi",
+		"script":                     `if (a < b) { tag = "<div>" }`,
+	} {
+		r := &recorder{TB: t}
+		AssertSelectorText(r, productsHTML, selector, want)
+		if r.failed {
+			t.Errorf("%s should have text %q: %s", selector, want, r.output)
+		}
+	}
+
+	r := &recorder{TB: t}
+	AssertSelectorText(r, productsHTML, "ul > h4", "Laptop Pro")
+	if !r.failed || !strings.Contains(r.output, `no element of the rendered HTML matches "ul > h4"`) {
+		t.Errorf("no match: got %q", r.output)
+	}
+
+	r = &recorder{TB: t}
+	AssertSelectorText(r, productsHTML, ".product h4", "Laptop")
+	if !r.failed || !strings.Contains(r.output, `text "Laptop Pro" of`) || !strings.Contains(r.output, `text "Mouse & Pad" of`) {
+		t.Errorf("wrong text: got %q", r.output)
+	}
+
+	for _, selector := range []string{"", "> li", "li >", "li[", ".", "a, b", "li:first-child"} {
+		r := &recorder{TB: t}
+		AssertSelectorText(r, productsHTML, selector, "")
+		if !r.fatal {
+			t.Errorf("%q: expected an invalid selector error", selector)
+		}
+	}
+}
+
+func TestAssertNoUnescaped(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(true))
+	ctx := miya.NewContextFrom(map[string]interface{}{"comment": "<script>alert(1)</script>"})
+
+	rendered, err := env.RenderString("<p>\n{{ comment }}\n</p>", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{TB: t}
+	AssertNoUnescaped(r, rendered, "<script>")
+	if r.failed {
+		t.Errorf("escaped output should pass: %s", r.output)
+	}
+
+	rendered, err = env.RenderString("<p>\n{{ comment|safe }}\n</p>", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = &recorder{TB: t}
+	AssertNoUnescaped(r, rendered, "<SCRIPT>")
+	if !r.failed || !strings.Contains(r.output, "line 2: <script>alert(1)</script>") {
+		t.Errorf("unescaped output: got %q", r.output)
+	}
+}
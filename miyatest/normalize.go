@@ -0,0 +1,207 @@
+package miyatest
+
+import (
+	"sort"
+	"strings"
+)
+
+// Option selects a normalization applied to both the rendered and the
+// expected output before they are compared
+type Option func(*options)
+
+type options struct {
+	trimTrailingSpace  bool
+	collapseBlankLines bool
+	sortAttributes     bool
+}
+
+// TrimTrailingSpace ignores spaces, tabs and carriage returns at the end of
+// lines
+func TrimTrailingSpace() Option {
+	return func(o *options) { o.trimTrailingSpace = true }
+}
+
+// CollapseBlankLines treats a run of blank lines as a single blank line. A
+// line holding only spaces and tabs counts as blank.
+func CollapseBlankLines() Option {
+	return func(o *options) { o.collapseBlankLines = true }
+}
+
+// SortAttributes ignores the order of attributes inside HTML start tags, and
+// the spacing between them. Tags it can't parse are compared as written.
+func SortAttributes() Option {
+	return func(o *options) { o.sortAttributes = true }
+}
+
+// Normalize applies the normalizations opts select to s
+func Normalize(s string, opts ...Option) string {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.sortAttributes {
+		s = sortTagAttributes(s)
+	}
+	if !o.trimTrailingSpace && !o.collapseBlankLines {
+		return s
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	var out strings.Builder
+	out.Grow(len(s))
+	previousBlank := false
+	for _, line := range lines {
+		text, newline := strings.CutSuffix(line, "\n")
+		blank := strings.TrimRight(text, " \t\r") == ""
+		if o.collapseBlankLines && blank && newline && previousBlank {
+			continue
+		}
+		previousBlank = blank && newline
+		if o.trimTrailingSpace {
+			text = strings.TrimRight(text, " \t\r")
+		}
+		out.WriteString(text)
+		if newline {
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// sortTagAttributes rewrites the HTML start tags in s with their attributes
+// sorted by name and separated by single spaces
+func sortTagAttributes(s string) string {
+	var out strings.Builder
+	out.Grow(len(s))
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			out.WriteString(s)
+			return out.String()
+		}
+		out.WriteString(s[:start])
+		s = s[start:]
+
+		tag, n := sortedTag(s)
+		if n == 0 {
+			out.WriteByte('<')
+			s = s[1:]
+			continue
+		}
+		out.WriteString(tag)
+		s = s[n:]
+	}
+}
+
+// sortedTag parses the start tag s begins with, returning it with its
+// attributes sorted and the length of the original, or 0 if s doesn't begin
+// with a start tag
+func sortedTag(s string) (string, int) {
+	name, attributes, selfClosing, n := scanStartTag(s)
+	if n == 0 {
+		return "", 0
+	}
+	if selfClosing {
+		return buildTag(name, attributes, " />"), n
+	}
+	return buildTag(name, attributes, ">"), n
+}
+
+// scanStartTag parses the start tag s begins with, returning its name, its
+// attributes as written but for the spacing around "=", whether it ends with
+// "/>" and its length, or a length of 0 if s doesn't begin with a start tag
+func scanStartTag(s string) (name string, attributes []string, selfClosing bool, n int) {
+	i := 1
+	for i < len(s) && isNameByte(s[i]) {
+		i++
+	}
+	if i == 1 || !isLetter(s[1]) {
+		return "", nil, false, 0
+	}
+	name = s[1:i]
+
+	for {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return "", nil, false, 0
+		}
+		switch {
+		case s[i] == '>':
+			return name, attributes, false, i + 1
+		case strings.HasPrefix(s[i:], "/>"):
+			return name, attributes, true, i + 2
+		}
+
+		attrStart := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' && s[i] != '"' && s[i] != '\'' {
+			i++
+		}
+		if i == attrStart {
+			return "", nil, false, 0
+		}
+		attribute := s[attrStart:i]
+
+		j := i
+		for j < len(s) && isSpace(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			j++
+			for j < len(s) && isSpace(s[j]) {
+				j++
+			}
+			if j == len(s) {
+				return "", nil, false, 0
+			}
+			valueStart := j
+			if quote := s[j]; quote == '"' || quote == '\'' {
+				end := strings.IndexByte(s[j+1:], quote)
+				if end < 0 {
+					return "", nil, false, 0
+				}
+				j += end + 2
+			} else {
+				for j < len(s) && !isSpace(s[j]) && s[j] != '>' {
+					j++
+				}
+			}
+			attribute += "=" + s[valueStart:j]
+			i = j
+		}
+		attributes = append(attributes, attribute)
+	}
+}
+
+func buildTag(name string, attributes []string, end string) string {
+	sort.SliceStable(attributes, func(a, b int) bool {
+		return attributeName(attributes[a]) < attributeName(attributes[b])
+	})
+	var b strings.Builder
+	b.WriteByte('<')
+	b.WriteString(name)
+	for _, attribute := range attributes {
+		b.WriteByte(' ')
+		b.WriteString(attribute)
+	}
+	b.WriteString(end)
+	return b.String()
+}
+
+func attributeName(attribute string) string {
+	name, _, _ := strings.Cut(attribute, "=")
+	return strings.ToLower(name)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameByte(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9' || c == '-' || c == ':' || c == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
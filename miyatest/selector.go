@@ -0,0 +1,145 @@
+package miyatest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// selectorStep is a compound selector, such as div.card[data-id], and how
+// it relates to the step before it
+type selectorStep struct {
+	// child is set for the > combinator; steps are otherwise descendants
+	child   bool
+	tag     string
+	id      string
+	classes []string
+	attrs   []selectorAttr
+}
+
+// selectorAttr is an [name] or [name=value] condition
+type selectorAttr struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// parseSelector parses the subset of CSS selectors AssertSelectorText
+// supports
+func parseSelector(selector string) ([]selectorStep, error) {
+	var steps []selectorStep
+	s := strings.TrimSpace(selector)
+	child := false
+	for s != "" {
+		if s[0] == '>' {
+			if len(steps) == 0 || child {
+				return nil, fmt.Errorf("misplaced > in selector %q", selector)
+			}
+			child = true
+			s = strings.TrimLeft(s[1:], " \t\n")
+			continue
+		}
+
+		step := selectorStep{child: child}
+		child = false
+		if s[0] == '*' {
+			step.tag = "*"
+			s = s[1:]
+		} else if isLetter(s[0]) {
+			name := selectorName(s)
+			step.tag = strings.ToLower(name)
+			s = s[len(name):]
+		}
+		for s != "" && !isSpace(s[0]) && s[0] != '>' {
+			switch c := s[0]; {
+			case c == '.' || c == '#':
+				name := selectorName(s[1:])
+				if name == "" {
+					return nil, fmt.Errorf("missing name after %c in selector %q", c, selector)
+				}
+				if c == '.' {
+					step.classes = append(step.classes, name)
+				} else {
+					step.id = name
+				}
+				s = s[1+len(name):]
+			case c == '[':
+				end := strings.IndexByte(s, ']')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated [ in selector %q", selector)
+				}
+				name, value, hasValue := strings.Cut(s[1:end], "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					return nil, fmt.Errorf("missing attribute name in selector %q", selector)
+				}
+				value = strings.TrimSpace(value)
+				if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+					value = value[1 : len(value)-1]
+				}
+				step.attrs = append(step.attrs, selectorAttr{name, value, hasValue})
+				s = s[end+1:]
+			default:
+				return nil, fmt.Errorf("unsupported %q in selector %q", s[0], selector)
+			}
+		}
+		steps = append(steps, step)
+		s = strings.TrimLeft(s, " \t\n")
+	}
+	if len(steps) == 0 || child {
+		return nil, fmt.Errorf("incomplete selector %q", selector)
+	}
+	return steps, nil
+}
+
+// selectorName returns the class, id or tag name s begins with
+func selectorName(s string) string {
+	i := 0
+	for i < len(s) && isNameByte(s[i]) && s[i] != ':' {
+		i++
+	}
+	return s[:i]
+}
+
+// matchSelector reports whether the element n matches the last of steps,
+// with ancestors matching the steps before it
+func matchSelector(n *htmlNode, steps []selectorStep) bool {
+	last := steps[len(steps)-1]
+	if !last.matches(n) {
+		return false
+	}
+	if len(steps) == 1 {
+		return true
+	}
+	for ancestor := n.parent; ancestor != nil && ancestor.tag != ""; ancestor = ancestor.parent {
+		if matchSelector(ancestor, steps[:len(steps)-1]) {
+			return true
+		}
+		if last.child {
+			return false
+		}
+	}
+	return false
+}
+
+func (s selectorStep) matches(n *htmlNode) bool {
+	if s.tag != "" && s.tag != "*" && s.tag != n.tag {
+		return false
+	}
+	if s.id != "" && n.attrs["id"] != s.id {
+		return false
+	}
+	classes := strings.Fields(n.attrs["class"])
+	for _, class := range s.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	for _, attr := range s.attrs {
+		value, ok := n.attrs[attr.name]
+		if !ok || attr.hasValue && value != attr.value {
+			return false
+		}
+	}
+	return true
+}
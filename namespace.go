@@ -5,7 +5,9 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/zipreport/miya/filters"
 	"github.com/zipreport/miya/runtime"
 )
 
@@ -72,6 +74,12 @@ func (ns *Namespace) Clear() {
 	ns.data = make(map[string]interface{})
 }
 
+// RenderCopy returns a namespace holding the same values, so a namespace
+// cached with an imported template is not shared between renders
+func (ns *Namespace) RenderCopy() interface{} {
+	return &Namespace{data: ns.All()}
+}
+
 // String returns a string representation of the namespace
 func (ns *Namespace) String() string {
 	ns.mu.RLock()
@@ -85,10 +93,16 @@ func namespaceFunction(args ...interface{}) (interface{}, error) {
 
 	// If keyword arguments are provided, initialize the namespace with them
 	if len(args) == 1 {
-		if kwargs, ok := args[0].(map[string]interface{}); ok {
+		switch kwargs := args[0].(type) {
+		case map[string]interface{}:
 			for k, v := range kwargs {
 				ns.Set(k, v)
 			}
+		case *runtime.Dict:
+			for _, k := range kwargs.Keys() {
+				v, _ := kwargs.Get(k)
+				ns.Set(k, v)
+			}
 		}
 	}
 
@@ -116,11 +130,74 @@ func (nw *NamespaceWrapper) SetAttr(name string, value interface{}) error {
 	return nil
 }
 
+// defaultFunc is the type of the default() global, whose arguments may be
+// undefined variables even with strict undefined handling
+type defaultFunc func(args ...interface{}) (interface{}, error)
+
+// AcceptsUndefined marks default() as taking undefined arguments
+func (f defaultFunc) AcceptsUndefined() {}
+
+// defaultFunction implements default(value, fallback="", boolean=false), the
+// function form of the default filter
+var defaultFunction = defaultFunc(func(args ...interface{}) (interface{}, error) {
+	if len(args) == 0 || len(args) > 3 {
+		return nil, fmt.Errorf("default() takes 1 to 3 arguments, got %d", len(args))
+	}
+	if len(args) == 1 {
+		args = append(args, "")
+	}
+	return filters.DefaultFilter(args[0], args[1:]...)
+})
+
+// rangeFunc is the type of the range() global, which tells renders with a
+// memory limit the size of the sequence it is about to produce
+type rangeFunc func(args ...interface{}) (interface{}, error)
+
+// MemoryCost returns the bytes taken by the items of range(args...)
+func (f rangeFunc) MemoryCost(args []interface{}) int {
+	_, _, n, err := rangeBounds(args)
+	if err != nil {
+		return 0
+	}
+	return runtime.MemorySize(n, runtime.MemoryItemSize)
+}
+
 // rangeFunction implements the range() global function
 // It generates a sequence of numbers similar to Python's range()
-func rangeFunction(args ...interface{}) (interface{}, error) {
-	var start, stop, step int
+var rangeFunction = rangeFunc(func(args ...interface{}) (interface{}, error) {
+	return rangeValues(0, args)
+})
+
+// limitedRange returns the range() of sandboxed environments, which refuses
+// to produce more than max items; 0 means no limit
+func limitedRange(max int) rangeFunc {
+	return func(args ...interface{}) (interface{}, error) {
+		return rangeValues(max, args)
+	}
+}
+
+// rangeValues implements range(), failing with a SecurityError when the
+// sequence would have more than max items, unless max is 0
+func rangeValues(max int, args []interface{}) (interface{}, error) {
+	start, step, n, err := rangeBounds(args)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && n > max {
+		return nil, runtime.NewSecurityError("range", fmt.Sprintf("range() of %d items exceeds the sandbox limit of %d", n, max), nil)
+	}
+
+	result := make([]interface{}, n)
+	for i := range result {
+		result[i] = start + i*step
+	}
+	return result, nil
+}
 
+// rangeBounds reads the arguments of range() and counts the items of the
+// sequence they describe
+func rangeBounds(args []interface{}) (start, step, n int, err error) {
+	var stop int
 	switch len(args) {
 	case 1:
 		// range(stop)
@@ -138,61 +215,65 @@ func rangeFunction(args ...interface{}) (interface{}, error) {
 		stop = toInt(args[1])
 		step = toInt(args[2])
 		if step == 0 {
-			return nil, fmt.Errorf("range() step argument must not be zero")
+			return 0, 0, 0, fmt.Errorf("range() step argument must not be zero")
 		}
 	default:
-		return nil, fmt.Errorf("range() takes 1 to 3 arguments, got %d", len(args))
+		return 0, 0, 0, fmt.Errorf("range() takes 1 to 3 arguments, got %d", len(args))
 	}
 
-	// Generate the sequence
-	var result []interface{}
-	if step > 0 {
-		for i := start; i < stop; i += step {
-			result = append(result, i)
-		}
-	} else {
-		for i := start; i > stop; i += step {
-			result = append(result, i)
-		}
+	if step > 0 && start < stop {
+		n = (stop-start-1)/step + 1
+	} else if step < 0 && start > stop {
+		n = (start-stop-1)/-step + 1
 	}
-
-	return result, nil
+	return start, step, n, nil
 }
 
-// dictFunction implements the dict() global function
-// It creates a dictionary from key-value pairs
-func dictFunction(args ...interface{}) (interface{}, error) {
-	result := make(map[string]interface{})
-
-	// Handle different argument patterns
-	if len(args) == 0 {
-		return result, nil
-	}
-
-	// If single argument is a map, use it as kwargs
-	if len(args) == 1 {
-		if kwargs, ok := args[0].(map[string]interface{}); ok {
-			for k, v := range kwargs {
-				result[k] = v
+// dictFunction implements the dict() global function. It creates a dict
+// that keeps its keys in the order they are given: those of a dict, a
+// mapping or a sequence of [key, value] pairs passed as the argument, then
+// the keyword arguments in the order the call writes them. Alternating keys
+// and values, as in dict("a", 1, "b", 2), are accepted too.
+func dictFunction(args []interface{}, kwargs *runtime.Dict) (interface{}, error) {
+	result := runtime.NewDict()
+
+	switch {
+	case len(args) == 1:
+		if err := result.Update(args[0]); err != nil {
+			return nil, fmt.Errorf("dict() %w", err)
+		}
+	case len(args)%2 != 0:
+		return nil, fmt.Errorf("dict() requires an even number of arguments when not using keyword arguments")
+	default:
+		for i := 0; i < len(args); i += 2 {
+			key, ok := args[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("dict() keys must be strings, got %T", args[i])
 			}
-			return result, nil
+			result.Set(key, args[i+1])
 		}
 	}
 
-	// Otherwise, expect pairs of arguments
-	if len(args)%2 != 0 {
-		return nil, fmt.Errorf("dict() requires an even number of arguments when not using keyword arguments")
+	if err := result.Update(kwargs); err != nil {
+		return nil, fmt.Errorf("dict() %w", err)
 	}
+	return result, nil
+}
 
-	for i := 0; i < len(args); i += 2 {
-		key, ok := args[i].(string)
-		if !ok {
-			return nil, fmt.Errorf("dict() keys must be strings, got %T", args[i])
-		}
-		result[key] = args[i+1]
+// listFunction implements the list() global function. It creates a list
+// templates can change in place, empty or holding the items of a sequence.
+func listFunction(args ...interface{}) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("list() takes at most 1 argument (%d given)", len(args))
 	}
-
-	return result, nil
+	if len(args) == 0 {
+		return runtime.NewList(), nil
+	}
+	items, err := makeIterable(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("list(): %w", err)
+	}
+	return runtime.NewList(items...), nil
 }
 
 // cyclerFunction creates a cycler object that cycles through values
@@ -459,6 +540,39 @@ func makeIterable(value interface{}) ([]interface{}, error) {
 			}
 			return result, nil
 		}
+		if items, ok := runtime.CollectionItems(value); ok {
+			return items, nil
+		}
 		return nil, fmt.Errorf("value is not iterable: %T", value)
 	}
 }
+
+// nowFunction returns the current time, or the time set with WithFixedNow.
+// An optional IANA timezone name ("UTC", "Europe/Berlin") selects the
+// location the time is expressed in.
+func (e *Environment) nowFunction(args ...interface{}) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("now() takes at most 1 argument (timezone), got %d", len(args))
+	}
+
+	now := e.fixedNow
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if len(args) == 0 {
+		return now, nil
+	}
+
+	switch tz := args[0].(type) {
+	case *time.Location:
+		return now.In(tz), nil
+	case string:
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("now(): unknown timezone %q", tz)
+		}
+		return now.In(location), nil
+	default:
+		return nil, fmt.Errorf("now() timezone must be a string, got %T", args[0])
+	}
+}
@@ -0,0 +1,187 @@
+package miya
+
+import (
+	"sync"
+	"weak"
+
+	"github.com/zipreport/miya/branching"
+	"github.com/zipreport/miya/filters"
+	"github.com/zipreport/miya/runtime"
+	"github.com/zipreport/miya/whitespace"
+)
+
+// Overlay returns a child environment, e.g. for per-tenant customization.
+//
+// The child shares the parent's loader, parsed templates and inheritance
+// cache, so templates are parsed once no matter how many overlays render them.
+// Filters, tests and globals registered on the child shadow the parent's;
+// everything else falls back to the parent, including entries the parent
+// gains after the overlay is created. Options apply to the child only.
+//
+// Options that change how templates are parsed (a different loader,
+// delimiters or whitespace control) give the child a private template cache.
+// Extensions and node transformers also affect parsing and can only be added
+// to the root.
+func (e *Environment) Overlay(opts ...EnvironmentOption) *Environment {
+	child := &Environment{
+		loader:              e.loader,
+		filterRegistry:      filters.NewChildRegistry(e.filterRegistry),
+		inheritanceResolver: e.inheritanceResolver,
+		macroRegistry:       e.macroRegistry,
+		testRegistry:        branching.NewChildTestRegistry(e.testRegistry),
+		extensionRegistry:   e.extensionRegistry,
+		globals:             make(map[string]interface{}),
+		tests:               make(map[string]TestFunc),
+		cache:               make(map[string]*Template),
+		notFound:            e.notFound,
+		inheritanceCache:    e.inheritanceCache,
+		extensionConfig:     make(map[string]interface{}, len(e.extensionConfig)),
+
+		autoEscape:             e.autoEscape,
+		autoEscapeSelector:     e.autoEscapeSelector,
+		trimBlocks:             e.trimBlocks,
+		lstripBlocks:           e.lstripBlocks,
+		keepTrailingNewline:    e.keepTrailingNewline,
+		undefinedBehavior:      e.undefinedBehavior,
+		undefinedFactory:       e.undefinedFactory,
+		templateIntrospection:  e.templateIntrospection,
+		fixedNow:               e.fixedNow,
+		finalizer:              e.finalizer,
+		maxRecursionDepth:      e.maxRecursionDepth,
+		maxRenderMemory:        e.maxRenderMemory,
+		htmlMinifyWhitespace:   e.htmlMinifyWhitespace,
+		caseInsensitiveMapKeys: e.caseInsensitiveMapKeys,
+		reservedNameProtection: e.reservedNameProtection,
+		sandboxed:              e.sandboxed,
+		sandboxLimits:          e.sandboxLimits,
+		childContentPolicy:     e.childContentPolicy,
+		warningHandler:         e.warningHandler,
+		metadataVariable:       e.metadataVariable,
+
+		varStartString:     e.varStartString,
+		varEndString:       e.varEndString,
+		blockStartString:   e.blockStartString,
+		blockEndString:     e.blockEndString,
+		commentStartString: e.commentStartString,
+		commentEndString:   e.commentEndString,
+
+		parent:         e,
+		templateParent: e,
+	}
+	for k, v := range e.extensionConfig {
+		child.extensionConfig[k] = v
+	}
+
+	for _, opt := range opts {
+		opt(child)
+	}
+	if !child.fixedNow.Equal(e.fixedNow) {
+		child.globals["now"] = child.nowFunction
+	}
+	if child.sandboxed && child.sandboxLimits.MaxRangeSize != e.sandboxLimits.MaxRangeSize {
+		child.globals["range"] = limitedRange(child.sandboxLimits.MaxRangeSize)
+	}
+
+	if !child.parsesLike(e) {
+		child.templateParent = nil
+		child.inheritanceCache = runtime.NewInheritanceCache()
+		child.whitespaceProcessor = whitespace.NewWhitespaceProcessor(child.trimBlocks, child.lstripBlocks, child.keepTrailingNewline)
+		if child.loader != e.loader && child.loader != nil {
+			child.watchLoader(child.loader)
+		}
+	} else {
+		child.whitespaceProcessor = e.whitespaceProcessor
+		if child.childContentPolicy != e.childContentPolicy {
+			// Hierarchies are checked against the policy when they are built
+			child.inheritanceCache = runtime.NewInheritanceCache()
+		}
+	}
+
+	child.evaluatorPool = sync.Pool{
+		New: func() interface{} {
+			eval := runtime.NewEvaluator()
+			eval.SetUndefinedBehavior(child.undefinedBehavior)
+			eval.SetUndefinedFactory(child.undefinedFactory)
+			return eval
+		},
+	}
+	child.importSystem = runtime.NewImportSystem(runtime.NewSimpleTemplateLoader(child), nil)
+
+	e.overlaysMutex.Lock()
+	e.overlays = append(e.overlays, weak.Make(child))
+	e.overlaysMutex.Unlock()
+
+	return child
+}
+
+// Parent returns the environment an overlay was created from, or nil
+func (e *Environment) Parent() *Environment {
+	return e.parent
+}
+
+// parsesLike reports whether templates parsed by other are valid for e
+func (e *Environment) parsesLike(other *Environment) bool {
+	return e.loader == other.loader &&
+		e.trimBlocks == other.trimBlocks &&
+		e.lstripBlocks == other.lstripBlocks &&
+		e.keepTrailingNewline == other.keepTrailingNewline &&
+		e.varStartString == other.varStartString &&
+		e.varEndString == other.varEndString &&
+		e.blockStartString == other.blockStartString &&
+		e.blockEndString == other.blockEndString &&
+		e.commentStartString == other.commentStartString &&
+		e.commentEndString == other.commentEndString
+}
+
+// overlayTemplate returns the overlay's view of a template parsed by its
+// template parent: the same AST, bound to the overlay for rendering
+func (e *Environment) overlayTemplate(key string, load func(string) (*Template, error)) (*Template, error) {
+	e.cacheMutex.RLock()
+	tmpl, ok := e.cache[key]
+	e.cacheMutex.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	shared, err := load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl = &Template{
+		name:       shared.name,
+		source:     shared.source,
+		filename:   shared.filename,
+		env:        e,
+		ast:        shared.ast,
+		metadata:   shared.metadata,
+		settings:   shared.settings,
+		fast:       shared.fast,
+		sourceHash: shared.sourceHash,
+		version:    shared.version,
+	}
+
+	e.cacheMutex.Lock()
+	e.cache[key] = tmpl
+	e.cacheMutex.Unlock()
+
+	return tmpl, nil
+}
+
+// liveOverlays returns the overlays that haven't been garbage collected,
+// dropping the rest from the list
+func (e *Environment) liveOverlays() []*Environment {
+	e.overlaysMutex.Lock()
+	defer e.overlaysMutex.Unlock()
+
+	live := make([]*Environment, 0, len(e.overlays))
+	kept := e.overlays[:0]
+	for _, ptr := range e.overlays {
+		if overlay := ptr.Value(); overlay != nil {
+			live = append(live, overlay)
+			kept = append(kept, ptr)
+		}
+	}
+	e.overlays = kept
+	return live
+}
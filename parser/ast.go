@@ -2,6 +2,8 @@ package parser
 
 import (
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -59,6 +61,12 @@ func (n *TemplateNode) String() string {
 type TextNode struct {
 	baseNode
 	Content string
+
+	// TrimLeading and TrimTrailing record whitespace control markers on the
+	// neighbouring tags ("-%}" before the text, "{%-" after it). They are only
+	// set by Parse; the Environment applies whitespace control before parsing.
+	TrimLeading  bool
+	TrimTrailing bool
 }
 
 func NewTextNode(content string, line, column int) *TextNode {
@@ -158,6 +166,32 @@ func (n *ListNode) String() string {
 
 func (n *ListNode) ExpressionNode() {}
 
+// DictNode represents dict literals {"a": 1, "b": x}. Keys[i] maps to
+// Values[i], in source order.
+type DictNode struct {
+	baseNode
+	Keys   []ExpressionNode
+	Values []ExpressionNode
+}
+
+func NewDictNode(keys, values []ExpressionNode, line, column int) *DictNode {
+	return &DictNode{
+		baseNode: baseNode{line: line, column: column},
+		Keys:     keys,
+		Values:   values,
+	}
+}
+
+func (n *DictNode) String() string {
+	var pairs []string
+	for i, key := range n.Keys {
+		pairs = append(pairs, key.String()+": "+n.Values[i].String())
+	}
+	return fmt.Sprintf("Dict({%s})", strings.Join(pairs, ", "))
+}
+
+func (n *DictNode) ExpressionNode() {}
+
 // AttributeNode represents attribute access (obj.attr)
 type AttributeNode struct {
 	baseNode
@@ -451,8 +485,12 @@ func (n *ExtendsNode) StatementNode() {}
 type IncludeNode struct {
 	baseNode
 	Template      ExpressionNode
-	Context       ExpressionNode // optional
+	Context       ExpressionNode            // optional
+	Assignments   map[string]ExpressionNode // optional "with name=value, ..." variables
+	Only          bool                      // "only": the template sees none of the includer's variables
 	IgnoreMissing bool
+	IndentContent bool   // "indent content": lines after the first are indented like the include tag
+	Indent        string // Whitespace the line of the include tag starts with
 }
 
 func NewIncludeNode(template ExpressionNode, line, column int) *IncludeNode {
@@ -464,14 +502,98 @@ func NewIncludeNode(template ExpressionNode, line, column int) *IncludeNode {
 }
 
 func (n *IncludeNode) String() string {
+	var with []string
 	if n.Context != nil {
-		return fmt.Sprintf("Include(%s with %s)", n.Template.String(), n.Context.String())
+		with = append(with, n.Context.String())
+	}
+	var assignments []string
+	for name, value := range n.Assignments {
+		assignments = append(assignments, fmt.Sprintf("%s=%s", name, value.String()))
 	}
-	return fmt.Sprintf("Include(%s)", n.Template.String())
+	sort.Strings(assignments)
+	with = append(with, assignments...)
+	if n.Only {
+		with = append(with, "only")
+	}
+	indent := ""
+	if n.IndentContent {
+		indent = " indent content"
+	}
+	if len(with) > 0 {
+		return fmt.Sprintf("Include(%s with %s%s)", n.Template.String(), strings.Join(with, ", "), indent)
+	}
+	return fmt.Sprintf("Include(%s%s)", n.Template.String(), indent)
 }
 
 func (n *IncludeNode) StatementNode() {}
 
+// EmbedNode represents embed blocks {% embed "card.html" with ... %}{% block body %}...{% endblock %}{% endembed %},
+// which include a template with some of its blocks overridden
+type EmbedNode struct {
+	baseNode
+	Template      ExpressionNode
+	Context       ExpressionNode            // optional
+	Assignments   map[string]ExpressionNode // optional "with name=value, ..." variables
+	Only          bool                      // "only": the template sees none of the embedder's variables
+	IgnoreMissing bool
+	Body          []Node // The overriding blocks, with the whitespace and comments between them
+}
+
+func NewEmbedNode(template ExpressionNode, line, column int) *EmbedNode {
+	return &EmbedNode{
+		baseNode: baseNode{line: line, column: column},
+		Template: template,
+		Body:     make([]Node, 0),
+	}
+}
+
+// Blocks returns the blocks the embed overrides
+func (n *EmbedNode) Blocks() []*BlockNode {
+	var blocks []*BlockNode
+	for _, node := range n.Body {
+		if block, ok := node.(*BlockNode); ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (n *EmbedNode) String() string {
+	var with []string
+	if n.Context != nil {
+		with = append(with, n.Context.String())
+	}
+	var assignments []string
+	for name, value := range n.Assignments {
+		assignments = append(assignments, fmt.Sprintf("%s=%s", name, value.String()))
+	}
+	sort.Strings(assignments)
+	with = append(with, assignments...)
+	if n.Only {
+		with = append(with, "only")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Embed(%s", n.Template.String()))
+	if len(with) > 0 {
+		sb.WriteString(" with " + strings.Join(with, ", "))
+	}
+	sb.WriteString(")")
+
+	if blocks := n.Blocks(); len(blocks) > 0 {
+		sb.WriteString(" {")
+		for _, block := range blocks {
+			sb.WriteString("\n  ")
+			sb.WriteString(strings.ReplaceAll(block.String(), "\n", "\n  "))
+		}
+		sb.WriteString("\n}")
+	}
+
+	return sb.String()
+}
+
+func (n *EmbedNode) StatementNode() {}
+
 // SuperNode represents super() calls in template inheritance
 type SuperNode struct {
 	baseNode
@@ -614,6 +736,9 @@ type CallNode struct {
 	Function  ExpressionNode
 	Arguments []ExpressionNode
 	Keywords  map[string]ExpressionNode
+	// KeywordOrder holds the names of Keywords in the order the call gives
+	// them, which dict() keeps
+	KeywordOrder []string
 }
 
 func NewCallNode(function ExpressionNode, line, column int) *CallNode {
@@ -632,13 +757,36 @@ func (n *CallNode) String() string {
 		args = append(args, arg.String())
 	}
 
-	for key, value := range n.Keywords {
-		args = append(args, fmt.Sprintf("%s=%s", key, value.String()))
+	for _, key := range n.KeywordNames() {
+		args = append(args, fmt.Sprintf("%s=%s", key, n.Keywords[key].String()))
 	}
 
 	return fmt.Sprintf("Call(%s(%s))", n.Function.String(), strings.Join(args, ", "))
 }
 
+// KeywordNames returns the names of the keyword arguments in the order the
+// call gives them. Keywords missing from KeywordOrder, such as those added by
+// a transform, follow in name order.
+func (n *CallNode) KeywordNames() []string {
+	names := make([]string, 0, len(n.Keywords))
+	for _, name := range n.KeywordOrder {
+		if _, ok := n.Keywords[name]; ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == len(n.Keywords) {
+		return names
+	}
+	var rest []string
+	for name := range n.Keywords {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
 func (n *CallNode) ExpressionNode() {}
 
 // CallBlockNode represents call blocks {% call macro_name() %}content{% endcall %}
@@ -760,12 +908,42 @@ func (n *TestNode) String() string {
 
 func (n *TestNode) ExpressionNode() {}
 
+// CompareNode represents a chain of comparisons such as 0 < x <= 10, which
+// holds when every comparison does: Left Operators[0] Comparators[0], then
+// Comparators[0] Operators[1] Comparators[1], and so on. Each operand is
+// evaluated at most once. A single comparison is a BinaryOpNode.
+type CompareNode struct {
+	baseNode
+	Left        ExpressionNode
+	Operators   []string
+	Comparators []ExpressionNode
+}
+
+func NewCompareNode(left ExpressionNode, operators []string, comparators []ExpressionNode, line, column int) *CompareNode {
+	return &CompareNode{
+		baseNode:    baseNode{line: line, column: column},
+		Left:        left,
+		Operators:   operators,
+		Comparators: comparators,
+	}
+}
+
+func (n *CompareNode) String() string {
+	s := "Compare(" + n.Left.String()
+	for i, op := range n.Operators {
+		s += " " + op + " " + n.Comparators[i].String()
+	}
+	return s + ")"
+}
+
+func (n *CompareNode) ExpressionNode() {}
+
 // ConditionalNode represents ternary conditional expressions (condition ? true_expr : false_expr)
 type ConditionalNode struct {
 	baseNode
 	Condition ExpressionNode
 	TrueExpr  ExpressionNode
-	FalseExpr ExpressionNode
+	FalseExpr ExpressionNode // nil when the else branch is omitted
 }
 
 func NewConditionalNode(condition, trueExpr, falseExpr ExpressionNode, line, column int) *ConditionalNode {
@@ -778,6 +956,9 @@ func NewConditionalNode(condition, trueExpr, falseExpr ExpressionNode, line, col
 }
 
 func (n *ConditionalNode) String() string {
+	if n.FalseExpr == nil {
+		return fmt.Sprintf("Conditional(%s ? %s)", n.Condition.String(), n.TrueExpr.String())
+	}
 	return fmt.Sprintf("Conditional(%s ? %s : %s)", n.Condition.String(), n.TrueExpr.String(), n.FalseExpr.String())
 }
 
@@ -919,9 +1100,11 @@ func (n *RawNode) String() string {
 }
 
 // AutoescapeNode represents autoescape blocks {% autoescape true %}...{% endautoescape %}
+// and {% autoescape 'js' %}...{% endautoescape %}
 type AutoescapeNode struct {
 	baseNode
-	Enabled bool // true for autoescape on, false for off
+	Enabled bool   // true for autoescape on, false for off
+	Context string // Escape context named by a string, such as "js"; empty for true and false
 	Body    []Node
 }
 
@@ -935,7 +1118,11 @@ func NewAutoescapeNode(enabled bool, line, column int) *AutoescapeNode {
 
 func (n *AutoescapeNode) String() string {
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("Autoescape(%v)", n.Enabled))
+	if n.Context != "" {
+		sb.WriteString(fmt.Sprintf("Autoescape(%q)", n.Context))
+	} else {
+		sb.WriteString(fmt.Sprintf("Autoescape(%v)", n.Enabled))
+	}
 
 	if len(n.Body) > 0 {
 		sb.WriteString(" {")
@@ -954,8 +1141,10 @@ func (n *AutoescapeNode) StatementNode() {}
 // FilterBlockNode represents filter blocks {% filter upper %}...{% endfilter %}
 type FilterBlockNode struct {
 	baseNode
-	FilterChain []FilterNode // Chain of filters to apply
-	Body        []Node       // Template content to filter
+	FilterChain   []FilterNode // Chain of filters to apply
+	Body          []Node       // Template content to filter
+	IndentContent bool         // "indent content": lines after the first are indented like the filter tag
+	Indent        string       // Whitespace the line of the filter tag starts with
 }
 
 func NewFilterBlockNode(filterChain []FilterNode, line, column int) *FilterBlockNode {
@@ -978,6 +1167,9 @@ func (n *FilterBlockNode) String() string {
 		}
 		sb.WriteString(strings.Join(filters, "|"))
 	}
+	if n.IndentContent {
+		sb.WriteString(" indent content")
+	}
 
 	// Show body
 	if len(n.Body) > 0 {
@@ -995,6 +1187,119 @@ func (n *FilterBlockNode) String() string {
 
 func (n *FilterBlockNode) StatementNode() {}
 
+// CacheNode represents fragment cache blocks {% cache key timeout=300 %}...{% endcache %}
+type CacheNode struct {
+	baseNode
+	Key     ExpressionNode
+	Timeout ExpressionNode // nil when no timeout is given
+	Body    []Node
+	Scope   string // identifies the defining template; set when the template is loaded
+}
+
+func NewCacheNode(key ExpressionNode, line, column int) *CacheNode {
+	return &CacheNode{
+		baseNode: baseNode{line: line, column: column},
+		Key:      key,
+		Body:     make([]Node, 0),
+	}
+}
+
+func (n *CacheNode) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Cache(%s", n.Key))
+	if n.Timeout != nil {
+		sb.WriteString(fmt.Sprintf(", timeout=%s", n.Timeout))
+	}
+	sb.WriteString(")")
+
+	if len(n.Body) > 0 {
+		sb.WriteString(" {")
+		for _, stmt := range n.Body {
+			sb.WriteString("\n  ")
+			sb.WriteString(strings.ReplaceAll(stmt.String(), "\n", "\n  "))
+		}
+		sb.WriteString("\n}")
+	}
+
+	return sb.String()
+}
+
+func (n *CacheNode) StatementNode() {}
+
+// SwitchNode represents {% switch subject %}{% case value %}...{% endswitch %}
+// blocks, which the switch extension parses
+type SwitchNode struct {
+	baseNode
+	Subject ExpressionNode
+	Cases   []*CaseNode
+	Default []Node // nil when the switch has no default
+}
+
+func NewSwitchNode(subject ExpressionNode, line, column int) *SwitchNode {
+	return &SwitchNode{
+		baseNode: baseNode{line: line, column: column},
+		Subject:  subject,
+		Cases:    make([]*CaseNode, 0),
+	}
+}
+
+func (n *SwitchNode) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Switch(%s)", n.Subject))
+	for _, c := range n.Cases {
+		sb.WriteString("\n")
+		sb.WriteString(c.String())
+	}
+	if n.Default != nil {
+		sb.WriteString("\nDefault {")
+		for _, stmt := range n.Default {
+			sb.WriteString("\n  ")
+			sb.WriteString(strings.ReplaceAll(stmt.String(), "\n", "\n  "))
+		}
+		sb.WriteString("\n}")
+	}
+	return sb.String()
+}
+
+func (n *SwitchNode) StatementNode() {}
+
+// CaseNode is a {% case %} branch of a switch, taken when the subject equals
+// any of its values
+type CaseNode struct {
+	baseNode
+	Values []ExpressionNode
+	Body   []Node
+}
+
+func NewCaseNode(line, column int) *CaseNode {
+	return &CaseNode{
+		baseNode: baseNode{line: line, column: column},
+		Values:   make([]ExpressionNode, 0),
+		Body:     make([]Node, 0),
+	}
+}
+
+func (n *CaseNode) String() string {
+	values := make([]string, len(n.Values))
+	for i, v := range n.Values {
+		values[i] = v.String()
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Case(%s)", strings.Join(values, ", ")))
+	if len(n.Body) > 0 {
+		sb.WriteString(" {")
+		for _, stmt := range n.Body {
+			sb.WriteString("\n  ")
+			sb.WriteString(strings.ReplaceAll(stmt.String(), "\n", "\n  "))
+		}
+		sb.WriteString("\n}")
+	}
+	return sb.String()
+}
+
+func (n *CaseNode) StatementNode() {}
+
 // BreakNode represents break statements in loops
 type BreakNode struct {
 	baseNode
@@ -1094,8 +1399,9 @@ func (n *ExtensionNode) Evaluate(ctx interface{}) (interface{}, error) {
 // ImportNode represents import statements ({% import 'template.html' as name %})
 type ImportNode struct {
 	baseNode
-	Template ExpressionNode // The template to import
-	Alias    string         // The alias name for the imported template
+	Template    ExpressionNode // The template to import
+	Alias       string         // The alias name for the imported template
+	WithContext bool           // "with context": the template sees the importer's variables
 }
 
 func NewImportNode(line, column int, template ExpressionNode, alias string) *ImportNode {
@@ -1115,9 +1421,10 @@ func (n *ImportNode) StatementNode() {}
 // FromNode represents from-import statements ({% from 'template.html' import item1, item2 %})
 type FromNode struct {
 	baseNode
-	Template ExpressionNode    // The template to import from
-	Names    []string          // The names to import
-	Aliases  map[string]string // Optional aliases for imported names (name -> alias)
+	Template    ExpressionNode    // The template to import from
+	Names       []string          // The names to import
+	Aliases     map[string]string // Optional aliases for imported names (name -> alias)
+	WithContext bool              // "with context": the template sees the importer's variables
 }
 
 func NewFromNode(line, column int, template ExpressionNode, names []string, aliases map[string]string) *FromNode {
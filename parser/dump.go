@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+// maxDumpValue is the number of characters of a text or literal value shown
+// by Dump and DumpTokens before it is cut short
+const maxDumpValue = 60
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// Dump returns an indented tree of node and its descendants for debugging.
+// Each line names a node's type and position followed by its scalar fields,
+// such as filter and variable names or literal values, and its children
+// follow on the lines below, labelled by field. Values are quoted and long
+// ones shortened, so the result stays on one line per node and is safe to
+// log. It covers every node type, including those defined by extensions.
+func Dump(node Node) string {
+	if node == nil {
+		return ""
+	}
+	var sb strings.Builder
+	dumpNode(&sb, node, "", 0)
+	return sb.String()
+}
+
+// DumpTokens returns a token stream for debugging, one token per line with
+// its position, type and quoted value
+func DumpTokens(tokens []*lexer.Token) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		position := fmt.Sprintf("%d:%d", token.Line, token.Column)
+		line := fmt.Sprintf("%-8s %-18s", position, token.Type)
+		if token.Value != "" || token.Type == lexer.TokenText || token.Type == lexer.TokenString {
+			line += " " + dumpString(token.Value)
+		}
+		sb.WriteString(strings.TrimRight(line, " "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func dumpNode(sb *strings.Builder, node Node, label string, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	if label != "" {
+		sb.WriteString(label)
+		sb.WriteString(": ")
+	}
+
+	v := reflect.ValueOf(node)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	fmt.Fprintf(sb, "%s %d:%d", v.Type().Name(), node.Line(), node.Column())
+	if v.Kind() != reflect.Struct {
+		sb.WriteString(" " + dumpString(node.String()) + "\n")
+		return
+	}
+
+	type child struct {
+		label string
+		node  Node
+	}
+	var children []child
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		value := v.Field(i)
+		switch {
+		case field.Type.Implements(nodeType):
+			if n, ok := nodeOf(value); ok {
+				children = append(children, child{field.Name, n})
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Implements(nodeType):
+			for j := 0; j < value.Len(); j++ {
+				if n, ok := nodeOf(value.Index(j)); ok {
+					children = append(children, child{fmt.Sprintf("%s[%d]", field.Name, j), n})
+				}
+			}
+		case field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String && field.Type.Elem().Implements(nodeType):
+			keys := make([]string, 0, value.Len())
+			for _, key := range value.MapKeys() {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if n, ok := nodeOf(value.MapIndex(reflect.ValueOf(key))); ok {
+					children = append(children, child{fmt.Sprintf("%s[%q]", field.Name, key), n})
+				}
+			}
+		default:
+			if text, ok := dumpField(value); ok {
+				fmt.Fprintf(sb, " %s=%s", field.Name, text)
+			}
+		}
+	}
+	sb.WriteByte('\n')
+
+	for _, c := range children {
+		dumpNode(sb, c.node, c.label, depth+1)
+	}
+}
+
+// nodeOf returns the node held by v, unless it is nil
+func nodeOf(v reflect.Value) (Node, bool) {
+	if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+		return nil, false
+	}
+	n, ok := v.Interface().(Node)
+	return n, ok
+}
+
+// dumpField formats a scalar field of a node, reporting false for zero
+// values other than literal values, which are always shown
+func dumpField(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Func, reflect.Chan:
+		return "", false
+	case reflect.Interface:
+		if v.IsNil() {
+			return "none", true
+		}
+		if text, ok := v.Interface().(string); ok {
+			return dumpString(text), true
+		}
+		return shorten(fmt.Sprintf("%v", v.Interface())), true
+	case reflect.String:
+		if v.Len() == 0 {
+			return "", false
+		}
+		return dumpString(v.String()), true
+	}
+	if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+		return "", false
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		return shorten(fmt.Sprintf("%q", v.Interface())), true
+	}
+	return shorten(fmt.Sprintf("%v", v.Interface())), true
+}
+
+// dumpString quotes s after shortening it
+func dumpString(s string) string {
+	if utf8.RuneCountInString(s) > maxDumpValue {
+		return fmt.Sprintf("%q...", string([]rune(s)[:maxDumpValue]))
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// shorten cuts s to maxDumpValue characters and keeps it on one line
+func shorten(s string) string {
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if utf8.RuneCountInString(s) > maxDumpValue {
+		return string([]rune(s)[:maxDumpValue]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+func TestDump(t *testing.T) {
+	parse := func(t *testing.T, source string) *TemplateNode {
+		t.Helper()
+		tokens, err := lexer.NewLexer(source, nil).Tokenize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast, err := NewParser(tokens).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ast
+	}
+
+	t.Run("Tree", func(t *testing.T) {
+		got := Dump(parse(t, `{% if user.age > 17 %}{{ name|truncate(10) }}{% else %}-{% endif %}`))
+		want := `TemplateNode 1:1
+  Children[0]: IfNode 1:4
+    Condition: BinaryOpNode 1:16 Operator=">"
+      Left: AttributeNode 1:12 Attribute="age"
+        Object: IdentifierNode 1:7 Name="user"
+      Right: LiteralNode 1:18 Value=17 Raw="17"
+    Body[0]: VariableNode 1:23
+      Expression: FilterNode 1:31 FilterName="truncate"
+        Expression: IdentifierNode 1:26 Name="name"
+        Arguments[0]: LiteralNode 1:40 Value=10 Raw="10"
+    Else[0]: TextNode 1:56 Content="-"
+`
+		if got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("LongValuesShortened", func(t *testing.T) {
+		got := Dump(parse(t, strings.Repeat("line\n", 30)))
+		if strings.Count(got, "\n") != 2 || !strings.Contains(got, `Content="line\nline`) || !strings.Contains(got, `"...`) {
+			t.Errorf("got:\n%s", got)
+		}
+	})
+
+	t.Run("MapChildrenSorted", func(t *testing.T) {
+		got := Dump(parse(t, `{% macro m(b=2, a=1) %}{% endmacro %}`))
+		if strings.Index(got, `Defaults["a"]`) > strings.Index(got, `Defaults["b"]`) {
+			t.Errorf("got:\n%s", got)
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		if got := Dump(nil); got != "" {
+			t.Errorf("got %q", got)
+		}
+	})
+}
+
+func TestDumpTokens(t *testing.T) {
+	tokens, err := lexer.NewLexer(`a{{ x|e }}`, nil).Tokenize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `1:1      TEXT               "a"
+1:2      VAR_START          "{{"
+1:5      IDENTIFIER         "x"
+1:6      PIPE               "|"
+1:7      IDENTIFIER         "e"
+1:9      VAR_END            "}}"
+1:11     EOF
+`
+	if got := DumpTokens(tokens); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,403 @@
+package parser
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// EncodingVersion identifies the layout written by Encode. It is bumped
+// whenever node types or their fields change in a way old bundles can't follow.
+const EncodingVersion = 1
+
+// encodingMagic prefixes every encoded bundle
+const encodingMagic = "miya-ast"
+
+// ErrIncompatibleEncoding is returned by Decode for data that wasn't written
+// by this version of Encode
+var ErrIncompatibleEncoding = errors.New("incompatible template encoding")
+
+// encodableNodes lists every node type Encode and Decode understand
+var encodableNodes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []Node{
+		&TemplateNode{}, &TextNode{}, &VariableNode{}, &IdentifierNode{}, &LiteralNode{},
+		&ListNode{}, &AttributeNode{}, &GetItemNode{}, &FilterNode{}, &BinaryOpNode{},
+		&UnaryOpNode{}, &IfNode{}, &ForNode{}, &BlockNode{}, &ExtendsNode{},
+		&IncludeNode{}, &SuperNode{}, &MacroNode{}, &SetNode{}, &BlockSetNode{},
+		&CallNode{}, &CallBlockNode{}, &WithNode{}, &TestNode{}, &ConditionalNode{},
+		&AssignmentNode{}, &SliceNode{}, &ComprehensionNode{}, &CommentNode{}, &RawNode{},
+		&AutoescapeNode{}, &FilterBlockNode{}, &BreakNode{}, &ContinueNode{}, &ExtensionNode{},
+		&ImportNode{}, &FromNode{}, &DoNode{}, &CacheNode{}, &EmbedNode{}, &CompareNode{},
+		&SwitchNode{}, &CaseNode{},
+	} {
+		t := reflect.TypeOf(node).Elem()
+		encodableNodes[t.Name()] = t
+	}
+}
+
+// Value kinds in the encoded form
+const (
+	kindNil uint8 = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	kindNode
+	kindList
+	kindMap
+)
+
+// encodedHeader is written ahead of the templates so version checks happen
+// before any node data is interpreted
+type encodedHeader struct {
+	Version int
+	Count   int
+}
+
+type encodedNode struct {
+	Type   string
+	Line   int
+	Column int
+	Fields []encodedField
+}
+
+type encodedField struct {
+	Name  string
+	Value encodedValue
+}
+
+type encodedValue struct {
+	Kind  uint8
+	Bool  bool
+	Int   int64
+	Float float64
+	Str   string
+	Node  *encodedNode
+	List  []encodedValue
+	Keys  []string
+}
+
+// positioned is implemented by every node through its embedded baseNode
+type positioned interface {
+	setPosition(line, column int)
+}
+
+func (n *baseNode) setPosition(line, column int) {
+	n.line = line
+	n.column = column
+}
+
+// Encode writes templates in a stable binary form that Decode reads back
+// without re-parsing. Extension nodes carrying an evaluate function can't be
+// encoded because the function has no serialized form.
+func Encode(w io.Writer, templates ...*TemplateNode) error {
+	if _, err := io.WriteString(w, encodingMagic); err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(encodedHeader{Version: EncodingVersion, Count: len(templates)}); err != nil {
+		return fmt.Errorf("failed to encode header: %w", err)
+	}
+
+	for _, template := range templates {
+		if template == nil {
+			return fmt.Errorf("cannot encode a nil template")
+		}
+		node, err := encodeNode(reflect.ValueOf(template))
+		if err != nil {
+			return fmt.Errorf("failed to encode template %s: %w", template.Name, err)
+		}
+		if err := enc.Encode(node); err != nil {
+			return fmt.Errorf("failed to encode template %s: %w", template.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Decode reads templates written by Encode. Data written by a different
+// EncodingVersion is rejected with ErrIncompatibleEncoding.
+func Decode(r io.Reader) ([]*TemplateNode, error) {
+	magic := make([]byte, len(encodingMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != encodingMagic {
+		return nil, fmt.Errorf("%w: missing %q header", ErrIncompatibleEncoding, encodingMagic)
+	}
+
+	dec := gob.NewDecoder(r)
+	var header encodedHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%w: unreadable header: %v", ErrIncompatibleEncoding, err)
+	}
+	if header.Version != EncodingVersion {
+		return nil, fmt.Errorf("%w: data has version %d, this build reads version %d", ErrIncompatibleEncoding, header.Version, EncodingVersion)
+	}
+
+	templates := make([]*TemplateNode, 0, header.Count)
+	for i := 0; i < header.Count; i++ {
+		var node encodedNode
+		if err := dec.Decode(&node); err != nil {
+			return nil, fmt.Errorf("failed to decode template %d: %w", i, err)
+		}
+		decoded, err := decodeNode(&node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode template %d: %w", i, err)
+		}
+		template, ok := decoded.Interface().(*TemplateNode)
+		if !ok {
+			return nil, fmt.Errorf("failed to decode template %d: root is %s, not TemplateNode", i, node.Type)
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+// encodeNode encodes a pointer to a node struct, or a node struct held by value
+func encodeNode(v reflect.Value) (*encodedNode, error) {
+	if v.Kind() != reflect.Ptr {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+
+	t := v.Elem().Type()
+	if encodableNodes[t.Name()] != t {
+		return nil, fmt.Errorf("unsupported node type %s", v.Type())
+	}
+
+	node := v.Interface().(Node)
+	encoded := &encodedNode{Type: t.Name(), Line: node.Line(), Column: node.Column()}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldValue := v.Elem().Field(i)
+		if field.Type.Kind() == reflect.Func {
+			if !fieldValue.IsNil() {
+				return nil, fmt.Errorf("%s.%s holds a function and cannot be encoded", t.Name(), field.Name)
+			}
+			continue
+		}
+
+		value, err := encodeValue(fieldValue)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+		encoded.Fields = append(encoded.Fields, encodedField{Name: field.Name, Value: value})
+	}
+
+	return encoded, nil
+}
+
+func encodeValue(v reflect.Value) (encodedValue, error) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return encodedValue{Kind: kindNil}, nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return encodedValue{Kind: kindNil}, nil
+		}
+		node, err := encodeNode(v)
+		if err != nil {
+			return encodedValue{}, err
+		}
+		return encodedValue{Kind: kindNode, Node: node}, nil
+	case reflect.Struct:
+		node, err := encodeNode(v)
+		if err != nil {
+			return encodedValue{}, err
+		}
+		return encodedValue{Kind: kindNode, Node: node}, nil
+	case reflect.Bool:
+		return encodedValue{Kind: kindBool, Bool: v.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodedValue{Kind: kindInt, Int: v.Int()}, nil
+	case reflect.Float32, reflect.Float64:
+		return encodedValue{Kind: kindFloat, Float: v.Float()}, nil
+	case reflect.String:
+		return encodedValue{Kind: kindString, Str: v.String()}, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return encodedValue{Kind: kindNil}, nil
+		}
+		list := make([]encodedValue, v.Len())
+		for i := range list {
+			item, err := encodeValue(v.Index(i))
+			if err != nil {
+				return encodedValue{}, err
+			}
+			list[i] = item
+		}
+		return encodedValue{Kind: kindList, List: list}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return encodedValue{Kind: kindNil}, nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return encodedValue{}, fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		list := make([]encodedValue, len(keys))
+		for i, key := range keys {
+			item, err := encodeValue(v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())))
+			if err != nil {
+				return encodedValue{}, err
+			}
+			list[i] = item
+		}
+		return encodedValue{Kind: kindMap, Keys: keys, List: list}, nil
+	}
+	return encodedValue{}, fmt.Errorf("unsupported value type %s", v.Type())
+}
+
+// decodeNode returns a pointer to a freshly allocated node
+func decodeNode(encoded *encodedNode) (reflect.Value, error) {
+	t, ok := encodableNodes[encoded.Type]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: unknown node type %s", ErrIncompatibleEncoding, encoded.Type)
+	}
+
+	v := reflect.New(t)
+	v.Interface().(positioned).setPosition(encoded.Line, encoded.Column)
+
+	for _, field := range encoded.Fields {
+		structField, ok := t.FieldByName(field.Name)
+		if !ok || !structField.IsExported() {
+			return reflect.Value{}, fmt.Errorf("%w: unknown field %s.%s", ErrIncompatibleEncoding, encoded.Type, field.Name)
+		}
+		if err := decodeValue(field.Value, v.Elem().FieldByIndex(structField.Index)); err != nil {
+			return reflect.Value{}, fmt.Errorf("%s.%s: %w", encoded.Type, field.Name, err)
+		}
+	}
+
+	return v, nil
+}
+
+// decodeValue stores an encoded value into target, guided by target's type
+func decodeValue(encoded encodedValue, target reflect.Value) error {
+	if encoded.Kind == kindNil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		value, err := decodeDynamic(encoded)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		rv := reflect.ValueOf(value)
+		if !rv.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("%w: %s does not implement %s", ErrIncompatibleEncoding, rv.Type(), target.Type())
+		}
+		target.Set(rv)
+	case reflect.Ptr, reflect.Struct:
+		if encoded.Kind != kindNode {
+			return fmt.Errorf("%w: expected node for %s", ErrIncompatibleEncoding, target.Type())
+		}
+		node, err := decodeNode(encoded.Node)
+		if err != nil {
+			return err
+		}
+		if target.Kind() == reflect.Struct {
+			node = node.Elem()
+		}
+		if node.Type() != target.Type() {
+			return fmt.Errorf("%w: expected %s, found %s", ErrIncompatibleEncoding, target.Type(), node.Type())
+		}
+		target.Set(node)
+	case reflect.Bool:
+		target.SetBool(encoded.Bool)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		target.SetInt(encoded.Int)
+	case reflect.Float32, reflect.Float64:
+		target.SetFloat(encoded.Float)
+	case reflect.String:
+		target.SetString(encoded.Str)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(target.Type(), len(encoded.List), len(encoded.List))
+		for i, item := range encoded.List {
+			if err := decodeValue(item, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMapWithSize(target.Type(), len(encoded.Keys))
+		for i, key := range encoded.Keys {
+			item := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeValue(encoded.List[i], item); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), item)
+		}
+		target.Set(m)
+	default:
+		return fmt.Errorf("%w: cannot decode into %s", ErrIncompatibleEncoding, target.Type())
+	}
+
+	return nil
+}
+
+// decodeDynamic rebuilds values held in interface{} fields such as LiteralNode.Value,
+// using the same Go types the parser produces
+func decodeDynamic(encoded encodedValue) (interface{}, error) {
+	switch encoded.Kind {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return encoded.Bool, nil
+	case kindInt:
+		return int(encoded.Int), nil
+	case kindFloat:
+		return encoded.Float, nil
+	case kindString:
+		return encoded.Str, nil
+	case kindNode:
+		node, err := decodeNode(encoded.Node)
+		if err != nil {
+			return nil, err
+		}
+		return node.Interface(), nil
+	case kindList:
+		list := make([]interface{}, len(encoded.List))
+		for i, item := range encoded.List {
+			value, err := decodeDynamic(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case kindMap:
+		m := make(map[string]interface{}, len(encoded.Keys))
+		for i, key := range encoded.Keys {
+			value, err := decodeDynamic(encoded.List[i])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%w: unknown value kind %d", ErrIncompatibleEncoding, encoded.Kind)
+}
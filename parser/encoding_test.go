@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+func parseForEncoding(t *testing.T, name, input string) *TemplateNode {
+	t.Helper()
+	tokens, err := lexer.NewLexer(input, nil).Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+	ast, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	ast.Name = name
+	return ast
+}
+
+// describe flattens a tree into one line per node with its position
+func describe(node Node) string {
+	var b strings.Builder
+	Walk(node, func(n Node) bool {
+		b.WriteString(n.String())
+		b.WriteString("@")
+		b.WriteString(strings.Repeat("|", n.Line()))
+		b.WriteString("\n")
+		return true
+	})
+	return b.String()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	templates := []*TemplateNode{
+		parseForEncoding(t, "page.html", `{% extends "base.html" %}
+{% from "macros.html" import card as c %}
+{% block body %}
+  {% for item in items if item.visible %}{{ loop.index }}: {{ item.name | upper | default("n/a", true) }}{% else %}none{% endfor %}
+  {% if a and not b %}{{ 1.5 * 2 }}{% elif c is defined %}{{ x[1:3] }}{% else %}{{ y ~ "z" }}{% endif %}
+  {% set total = [1, 2, none, false] %}{% macro m(p, q=3) %}{{ p }}{{ q }}{% endmacro %}
+  {% call m(1) %}inner{% endcall %}{% filter upper %}text{% endfilter %}{% raw %}{{ raw }}{% endraw %}
+{% endblock %}`),
+		parseForEncoding(t, "base.html", `<html>{% block body %}{% endblock %}{% include "footer.html" ignore missing %}</html>`),
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, templates...); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(decoded) != len(templates) {
+		t.Fatalf("expected %d templates, got %d", len(templates), len(decoded))
+	}
+
+	for i := range templates {
+		if decoded[i].Name != templates[i].Name {
+			t.Errorf("expected name %q, got %q", templates[i].Name, decoded[i].Name)
+		}
+		if want, got := describe(templates[i]), describe(decoded[i]); want != got {
+			t.Errorf("template %s changed in round trip:\nwant:\n%s\ngot:\n%s", templates[i].Name, want, got)
+		}
+	}
+}
+
+func TestDecodeRejectsOtherVersions(t *testing.T) {
+	var buf bytes.Buffer
+	io.WriteString(&buf, encodingMagic)
+	if err := gob.NewEncoder(&buf).Encode(encodedHeader{Version: EncodingVersion + 1}); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+
+	_, err := Decode(&buf)
+	if !errors.Is(err, ErrIncompatibleEncoding) {
+		t.Fatalf("expected ErrIncompatibleEncoding, got %v", err)
+	}
+
+	_, err = Decode(strings.NewReader("not a bundle"))
+	if !errors.Is(err, ErrIncompatibleEncoding) {
+		t.Fatalf("expected ErrIncompatibleEncoding for foreign data, got %v", err)
+	}
+}
+
+func TestEncodeRejectsExtensionFunctions(t *testing.T) {
+	ext := NewExtensionNode("custom", "tag", 1, 1)
+	ext.SetEvaluateFunc(func(*ExtensionNode, interface{}) (interface{}, error) { return nil, nil })
+	template := NewTemplateNode("ext.html", 1, 1)
+	template.Children = append(template.Children, ext)
+
+	if err := Encode(io.Discard, template); err == nil {
+		t.Error("expected an error encoding an extension node with an evaluate function")
+	}
+}
@@ -1,11 +1,10 @@
 package parser
 
-// This file contains FastEval implementations for Phase 3c optimization
-// FastEval allows nodes to evaluate themselves directly without going through
-// the type switch in DefaultEvaluator.EvalNode()
+// The FastEval methods below return the constant value of nodes that don't
+// depend on the render context. The runtime evaluates these nodes directly;
+// its FastEvalNode interface is for node types defined outside this package.
 
-// FastEvalNode interface is defined in runtime package to avoid circular dependencies
-// We use a local type alias here for convenience
+// FastEvaluator is the evaluator the FastEval methods receive
 type FastEvaluator interface {
 	SetUndefinedBehavior(behavior interface{})
 	SetImportSystem(importSystem interface{})
@@ -222,6 +222,7 @@ func AcquireCallNode(function ExpressionNode, line, column int) *CallNode {
 	if n.Keywords == nil {
 		n.Keywords = make(map[string]ExpressionNode)
 	}
+	n.KeywordOrder = nil
 	return n
 }
 
@@ -235,6 +236,7 @@ func ReleaseCallNode(n *CallNode) {
 	for k := range n.Keywords {
 		delete(n.Keywords, k)
 	}
+	n.KeywordOrder = nil
 	n.baseNode.line = 0
 	n.baseNode.column = 0
 	callNodePool.Put(n)
@@ -341,6 +343,13 @@ func ReleaseAST(node Node) {
 		}
 		// ListNode itself is not pooled
 
+	case *DictNode:
+		for i := range n.Keys {
+			ReleaseAST(n.Keys[i])
+			ReleaseAST(n.Values[i])
+		}
+		// DictNode itself is not pooled
+
 	case *IfNode:
 		ReleaseAST(n.Condition)
 		for _, child := range n.Body {
@@ -402,6 +411,13 @@ func ReleaseAST(node Node) {
 		}
 		// TestNode itself is not pooled
 
+	case *CompareNode:
+		ReleaseAST(n.Left)
+		for _, comparator := range n.Comparators {
+			ReleaseAST(comparator)
+		}
+		// CompareNode itself is not pooled
+
 	case *ConditionalNode:
 		ReleaseAST(n.Condition)
 		ReleaseAST(n.TrueExpr)
@@ -457,8 +473,24 @@ func ReleaseAST(node Node) {
 		if n.Context != nil {
 			ReleaseAST(n.Context)
 		}
+		for _, value := range n.Assignments {
+			ReleaseAST(value)
+		}
 		// IncludeNode itself is not pooled
 
+	case *EmbedNode:
+		ReleaseAST(n.Template)
+		if n.Context != nil {
+			ReleaseAST(n.Context)
+		}
+		for _, value := range n.Assignments {
+			ReleaseAST(value)
+		}
+		for _, child := range n.Body {
+			ReleaseAST(child)
+		}
+		// EmbedNode itself is not pooled
+
 	case *ImportNode:
 		ReleaseAST(n.Template)
 		// ImportNode itself is not pooled
@@ -483,6 +515,35 @@ func ReleaseAST(node Node) {
 		}
 		// FilterBlockNode itself is not pooled
 
+	case *CacheNode:
+		ReleaseAST(n.Key)
+		if n.Timeout != nil {
+			ReleaseAST(n.Timeout)
+		}
+		for _, child := range n.Body {
+			ReleaseAST(child)
+		}
+		// CacheNode itself is not pooled
+
+	case *SwitchNode:
+		ReleaseAST(n.Subject)
+		for _, c := range n.Cases {
+			ReleaseAST(c)
+		}
+		for _, child := range n.Default {
+			ReleaseAST(child)
+		}
+		// SwitchNode itself is not pooled
+
+	case *CaseNode:
+		for _, value := range n.Values {
+			ReleaseAST(value)
+		}
+		for _, child := range n.Body {
+			ReleaseAST(child)
+		}
+		// CaseNode itself is not pooled
+
 	case *ExtensionNode:
 		for _, arg := range n.Arguments {
 			ReleaseAST(arg)
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+// ParseOption configures Parse
+type ParseOption func(*lexer.LexerConfig)
+
+// WithDelimiters sets the variable and block delimiters, e.g. "[[", "]]",
+// "[%", "%]"
+func WithDelimiters(varStart, varEnd, blockStart, blockEnd string) ParseOption {
+	return func(c *lexer.LexerConfig) {
+		c.VarStartString = varStart
+		c.VarEndString = varEnd
+		c.BlockStartString = blockStart
+		c.BlockEndString = blockEnd
+	}
+}
+
+// WithCommentDelimiters sets the comment delimiters
+func WithCommentDelimiters(start, end string) ParseOption {
+	return func(c *lexer.LexerConfig) {
+		c.CommentStartString = start
+		c.CommentEndString = end
+	}
+}
+
+// Parse parses template source into an AST without an Environment.
+//
+// Unlike Environment compilation, Parse keeps the source as written: comments
+// become CommentNodes and whitespace control markers are recorded on the
+// adjacent TextNodes instead of being applied, so Print can reproduce them.
+// Extension tags are not recognized.
+func Parse(name, src string, opts ...ParseOption) (*TemplateNode, error) {
+	config := lexer.DefaultConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	config.KeepComments = true
+
+	tokens, err := lexer.NewLexer(src, config).Tokenize()
+	if err != nil {
+		return nil, fmt.Errorf("lexer error in template %s: %w", name, err)
+	}
+
+	ast, err := NewParser(tokens).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parser error in template %s: %w", name, err)
+	}
+	ast.Name = name
+	return ast, nil
+}
+
+// ParseExpression parses src as a single expression, written as it would be
+// between {{ and }}, e.g. "item.age > 30 and item.active".
+func ParseExpression(src string) (ExpressionNode, error) {
+	tokens, err := lexer.NewLexer(src, nil).TokenizeExpression()
+	if err != nil {
+		return nil, fmt.Errorf("lexer error in expression %q: %w", src, err)
+	}
+
+	p := NewParser(tokens)
+	expr, err := p.parseExpression()
+	if err == nil && !p.isAtEnd() {
+		err = p.error(fmt.Sprintf("unexpected token after expression: %s", p.peek().Type))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parser error in expression %q: %w", src, err)
+	}
+	return expr, nil
+}
@@ -13,8 +13,21 @@ type Parser struct {
 	tokens  []*lexer.Token
 	current int
 	errors  []string
+	// openTags holds the tags of the blocks being parsed, innermost last
+	openTags []*lexer.Token
+	// tagHandler parses tags the parser doesn't know, see SetTagHandler
+	tagHandler TagHandler
+	// recovering is set while ParseAll runs, collecting errors in recovered
+	// instead of returning them
+	recovering bool
+	recovered  []recoveredError
 }
 
+// TagHandler parses a block tag that isn't built in, such as one added by an
+// extension. It is called with the current token on the tag name, and
+// reports handled as false for tags it doesn't know either.
+type TagHandler func(tagName string) (node Node, handled bool, err error)
+
 // NewParser creates a new parser with the given tokens
 func NewParser(tokens []*lexer.Token) *Parser {
 	return &Parser{
@@ -50,8 +63,21 @@ func (p *Parser) Parse() (*TemplateNode, error) {
 	return template, nil
 }
 
-// parseTopLevel parses top-level template content
+// parseTopLevel parses top-level template content. While recovering, a
+// syntax error is recorded and the statement skipped instead.
 func (p *Parser) parseTopLevel() (Node, error) {
+	start := p.current
+	node, err := p.parseStatement()
+	if err != nil && p.recovering {
+		p.recover(start, err)
+		return nil, nil
+	}
+	return node, err
+}
+
+// parseStatement parses the text, variable, tag or comment at the current
+// token
+func (p *Parser) parseStatement() (Node, error) {
 	switch p.peek().Type {
 	case lexer.TokenText:
 		return p.parseText()
@@ -69,7 +95,12 @@ func (p *Parser) parseTopLevel() (Node, error) {
 // parseText parses plain text content
 func (p *Parser) parseText() (Node, error) {
 	token := p.advance()
-	return NewTextNode(token.Value, token.Line, token.Column), nil
+	node := NewTextNode(token.Value, token.Line, token.Column)
+	if p.current >= 2 {
+		node.TrimLeading = p.tokens[p.current-2].TrimLeft
+	}
+	node.TrimTrailing = !p.isAtEnd() && p.peek().TrimRight
+	return node, nil
 }
 
 // parseVariable parses variable expressions {{ ... }}
@@ -133,8 +164,22 @@ func (p *Parser) parseBlockStatement() (Node, error) {
 		return p.parseDoStatement()
 	case lexer.TokenFilter:
 		return p.parseFilterBlock()
+	case lexer.TokenIdentifier:
+		// cache and embed are not keywords so they stay usable as a variable name
+		if p.peek().Value == "cache" {
+			return p.parseCacheBlock()
+		}
+		if p.peek().Value == "embed" {
+			return p.parseEmbedBlock()
+		}
+		if p.tagHandler != nil {
+			if node, handled, err := p.tagHandler(p.peek().Value); handled {
+				return node, err
+			}
+		}
+		return nil, p.unknownTagError()
 	default:
-		return nil, p.error(fmt.Sprintf("unexpected block statement: %s", p.peek().Type))
+		return nil, p.unknownTagError()
 	}
 }
 
@@ -142,22 +187,23 @@ func (p *Parser) parseBlockStatement() (Node, error) {
 func (p *Parser) parseComment() (Node, error) {
 	startToken := p.advance() // consume {#
 
-	// Comments are handled by the lexer, this shouldn't be called
-	// But if we get here, skip to the end
+	// Comments only reach the parser when the lexer keeps them
+	var content strings.Builder
 	for !p.check(lexer.TokenCommentEnd) && !p.isAtEnd() {
-		p.advance()
+		content.WriteString(p.advance().Value)
 	}
 
 	if p.check(lexer.TokenCommentEnd) {
 		p.advance()
 	}
 
-	return NewCommentNode("", startToken.Line, startToken.Column), nil
+	return NewCommentNode(content.String(), startToken.Line, startToken.Column), nil
 }
 
 // parseIfStatement parses if/elif/else statements
 func (p *Parser) parseIfStatement() (Node, error) {
 	ifToken := p.advance() // consume 'if'
+	defer p.enterTag(ifToken)()
 
 	condition, err := p.parseExpression()
 	if err != nil {
@@ -235,9 +281,12 @@ func (p *Parser) parseIfStatement() (Node, error) {
 			ifNode.ElseIfs = append(ifNode.ElseIfs, elifNode)
 
 		} else if blockType == lexer.TokenElse {
-			p.advance() // consume {%
-			p.advance() // consume else
+			p.advance()              // consume {%
+			elseToken := p.advance() // consume else
 
+			if p.check(lexer.TokenIf) {
+				return nil, p.errorAt(elseToken, "unknown tag 'else if', did you mean 'elif'?")
+			}
 			if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 				return nil, p.error("expected '%}' after else")
 			}
@@ -268,7 +317,7 @@ func (p *Parser) parseIfStatement() (Node, error) {
 
 	// Expect endif
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endif %}' to close if statement")
+		return nil, p.unclosedTagError(ifToken)
 	}
 	p.advance() // consume {%
 
@@ -277,10 +326,9 @@ func (p *Parser) parseIfStatement() (Node, error) {
 	}
 	p.advance() // consume endif
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endif")
+	if err := p.expectTagEnd("endif"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return ifNode, nil
 }
@@ -288,6 +336,7 @@ func (p *Parser) parseIfStatement() (Node, error) {
 // parseForStatement parses for loops
 func (p *Parser) parseForStatement() (Node, error) {
 	forToken := p.advance() // consume 'for'
+	defer p.enterTag(forToken)()
 
 	// Parse variable list (support multiple variables for unpacking)
 	var variables []string
@@ -307,6 +356,13 @@ func (p *Parser) parseForStatement() (Node, error) {
 		variables = append(variables, p.advance().Value)
 	}
 
+	// As in Jinja2, a variable named loop would hide the loop object
+	for _, name := range variables {
+		if name == "loop" {
+			return nil, p.errorAt(forToken, "can't assign to special loop variable in for-loop target")
+		}
+	}
+
 	if !p.check(lexer.TokenIn) {
 		return nil, p.error("expected 'in' after for variable(s)")
 	}
@@ -393,7 +449,7 @@ func (p *Parser) parseForStatement() (Node, error) {
 
 	// Expect endfor
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endfor %}' to close for statement")
+		return nil, p.unclosedTagError(forToken)
 	}
 	p.advance() // consume {%
 
@@ -402,10 +458,9 @@ func (p *Parser) parseForStatement() (Node, error) {
 	}
 	p.advance() // consume endfor
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endfor")
+	if err := p.expectTagEnd("endfor"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return forNode, nil
 }
@@ -413,6 +468,7 @@ func (p *Parser) parseForStatement() (Node, error) {
 // parseSetStatement parses set statements (supports multiple assignment and block assignment)
 func (p *Parser) parseSetStatement() (Node, error) {
 	setToken := p.advance() // consume 'set'
+	defer p.enterTag(setToken)()
 
 	// Parse target expression(s) - can be identifiers or attribute access
 	var targets []ExpressionNode
@@ -482,7 +538,7 @@ func (p *Parser) parseSetStatement() (Node, error) {
 
 		// Expect {% endset %}
 		if p.isAtEnd() {
-			return nil, p.error("expected '{% endset %}' to close set block")
+			return nil, p.unclosedTagError(setToken)
 		}
 
 		p.advance() // consume '{%'
@@ -491,10 +547,9 @@ func (p *Parser) parseSetStatement() (Node, error) {
 		}
 		p.advance() // consume 'endset'
 
-		if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-			return nil, p.error("expected '%}' after endset")
+		if err := p.expectTagEnd("endset"); err != nil {
+			return nil, err
 		}
-		p.advance() // consume '%}'
 
 		// Extract the variable name from the identifier node
 		varName := targets[0].(*IdentifierNode).Name
@@ -507,6 +562,7 @@ func (p *Parser) parseSetStatement() (Node, error) {
 // parseBlockDefinition parses block definitions
 func (p *Parser) parseBlockDefinition() (Node, error) {
 	blockToken := p.advance() // consume 'block'
+	defer p.enterTag(blockToken)()
 
 	if !p.check(lexer.TokenIdentifier) {
 		return nil, p.error("expected block name after 'block'")
@@ -539,7 +595,7 @@ func (p *Parser) parseBlockDefinition() (Node, error) {
 
 	// Expect endblock
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endblock %}' to close block")
+		return nil, p.unclosedTagError(blockToken)
 	}
 	p.advance() // consume {%
 
@@ -556,10 +612,9 @@ func (p *Parser) parseBlockDefinition() (Node, error) {
 		}
 	}
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endblock")
+	if err := p.expectTagEnd("endblock"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return blockNode, nil
 }
@@ -584,6 +639,7 @@ func (p *Parser) parseExtendsStatement() (Node, error) {
 // parseIncludeStatement parses include statements
 func (p *Parser) parseIncludeStatement() (Node, error) {
 	includeToken := p.advance() // consume 'include'
+	indent := p.tagIndent(p.current - 1)
 
 	template, err := p.parseExpression()
 	if err != nil {
@@ -591,25 +647,20 @@ func (p *Parser) parseIncludeStatement() (Node, error) {
 	}
 
 	includeNode := NewIncludeNode(template, includeToken.Line, includeToken.Column)
+	includeNode.Indent = indent
 
-	// Check for optional context
-	if p.check(lexer.TokenWith) {
-		p.advance() // consume 'with'
-		context, err := p.parseExpression()
-		if err != nil {
-			return nil, err
-		}
-		includeNode.Context = context
+	// ignore missing and indent content may come before or after the context
+	if err := p.parseIncludeModifiers(includeNode); err != nil {
+		return nil, err
 	}
 
-	// Check for ignore missing
-	if p.check(lexer.TokenIgnore) {
-		p.advance() // consume 'ignore'
-		if !p.check(lexer.TokenMissing) {
-			return nil, p.error("expected 'missing' after 'ignore'")
-		}
-		p.advance() // consume 'missing'
-		includeNode.IgnoreMissing = true
+	includeNode.Context, includeNode.Assignments, includeNode.Only, err = p.parseIncludeWith("include")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.parseIncludeModifiers(includeNode); err != nil {
+		return nil, err
 	}
 
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
@@ -620,9 +671,102 @@ func (p *Parser) parseIncludeStatement() (Node, error) {
 	return includeNode, nil
 }
 
+// parseIncludeWith parses the optional context of an include or embed tag
+// and its "only": an expression giving a mapping, or name=value pairs
+func (p *Parser) parseIncludeWith(tag string) (ExpressionNode, map[string]ExpressionNode, bool, error) {
+	var context ExpressionNode
+	var assignments map[string]ExpressionNode
+	if p.check(lexer.TokenWith) {
+		p.advance() // consume 'with'
+		if p.check(lexer.TokenIdentifier) && p.peekNext().Type == lexer.TokenAssign {
+			assignments = make(map[string]ExpressionNode)
+			for {
+				if !p.check(lexer.TokenIdentifier) || p.peekNext().Type != lexer.TokenAssign {
+					return nil, nil, false, p.error(fmt.Sprintf("expected name=value after ',' in %s", tag))
+				}
+				name := p.advance().Value
+				p.advance() // consume '='
+				value, err := p.parseExpression()
+				if err != nil {
+					return nil, nil, false, err
+				}
+				assignments[name] = value
+				if !p.check(lexer.TokenComma) {
+					break
+				}
+				p.advance() // consume ','
+			}
+		} else {
+			var err error
+			if context, err = p.parseExpression(); err != nil {
+				return nil, nil, false, err
+			}
+		}
+	}
+
+	only := false
+	if p.check(lexer.TokenIdentifier) && p.peek().Value == "only" {
+		p.advance() // consume 'only'
+		only = true
+	}
+	return context, assignments, only, nil
+}
+
+// parseIncludeModifiers parses the optional "ignore missing" and "indent
+// content" of an include, in either order, skipping those already given
+func (p *Parser) parseIncludeModifiers(includeNode *IncludeNode) error {
+	for {
+		switch {
+		case !includeNode.IgnoreMissing && p.check(lexer.TokenIgnore):
+			p.advance() // consume 'ignore'
+			if !p.check(lexer.TokenMissing) {
+				return p.error("expected 'missing' after 'ignore'")
+			}
+			p.advance() // consume 'missing'
+			includeNode.IgnoreMissing = true
+		case !includeNode.IndentContent && p.checkIndentContent():
+			p.advance() // consume 'indent'
+			p.advance() // consume 'content'
+			includeNode.IndentContent = true
+		default:
+			return nil
+		}
+	}
+}
+
+// checkIndentContent reports whether the next tokens are "indent content"
+func (p *Parser) checkIndentContent() bool {
+	next := p.peekNext()
+	return p.check(lexer.TokenIdentifier) && p.peek().Value == "indent" &&
+		next.Type == lexer.TokenIdentifier && next.Value == "content"
+}
+
+// tagIndent returns the spaces and tabs the line of the block tag named by
+// the token at tagIdx starts with, as far as the text before the tag shows.
+// Indentation removed by whitespace control is not seen.
+func (p *Parser) tagIndent(tagIdx int) string {
+	textIdx := tagIdx - 2 // before the tag's {%
+	if textIdx < 0 || p.tokens[textIdx].Type != lexer.TokenText {
+		return ""
+	}
+	text := p.tokens[textIdx].Value
+	lineStart := strings.LastIndexByte(text, '\n') + 1
+	line := text[lineStart:]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	if lineStart == 0 && textIdx > 0 {
+		// The line starts before this text. Whitespace right after a block
+		// tag, whose newline trim_blocks removed, still starts the line.
+		if before := p.tokens[textIdx-1].Type; indent != text || (before != lexer.TokenBlockEnd && before != lexer.TokenBlockEndTrim) {
+			return ""
+		}
+	}
+	return indent
+}
+
 // parseMacroDefinition parses macro definitions
 func (p *Parser) parseMacroDefinition() (Node, error) {
 	macroToken := p.advance() // consume 'macro'
+	defer p.enterTag(macroToken)()
 
 	if !p.check(lexer.TokenIdentifier) {
 		return nil, p.error("expected macro name after 'macro'")
@@ -689,7 +833,7 @@ func (p *Parser) parseMacroDefinition() (Node, error) {
 
 	// Expect endmacro
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endmacro %}' to close macro")
+		return nil, p.unclosedTagError(macroToken)
 	}
 	p.advance() // consume {%
 
@@ -698,10 +842,9 @@ func (p *Parser) parseMacroDefinition() (Node, error) {
 	}
 	p.advance() // consume endmacro
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endmacro")
+	if err := p.expectTagEnd("endmacro"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return macroNode, nil
 }
@@ -709,6 +852,7 @@ func (p *Parser) parseMacroDefinition() (Node, error) {
 // parseRawBlock parses raw blocks
 func (p *Parser) parseRawBlock() (Node, error) {
 	rawToken := p.advance() // consume 'raw'
+	defer p.enterTag(rawToken)()
 
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 		return nil, p.error("expected '%}' after raw")
@@ -731,7 +875,7 @@ func (p *Parser) parseRawBlock() (Node, error) {
 
 	// Expect endraw
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endraw %}' to close raw block")
+		return nil, p.unclosedTagError(rawToken)
 	}
 	p.advance() // consume {%
 
@@ -740,10 +884,9 @@ func (p *Parser) parseRawBlock() (Node, error) {
 	}
 	p.advance() // consume endraw
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endraw")
+	if err := p.expectTagEnd("endraw"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return NewRawNode(content.String(), rawToken.Line, rawToken.Column), nil
 }
@@ -751,10 +894,16 @@ func (p *Parser) parseRawBlock() (Node, error) {
 // parseAutoescapeBlock parses autoescape blocks
 func (p *Parser) parseAutoescapeBlock() (Node, error) {
 	autoescapeToken := p.advance() // consume 'autoescape'
+	defer p.enterTag(autoescapeToken)()
 
-	// Parse the boolean value (true/false or on/off)
+	// Parse the boolean value (true/false or on/off), or the name of an
+	// escape context, checked when rendering
 	var enabled bool
-	if p.check(lexer.TokenTrue) {
+	var escapeContext string
+	if p.check(lexer.TokenString) {
+		escapeContext = p.advance().Value
+		enabled = true
+	} else if p.check(lexer.TokenTrue) {
 		p.advance()
 		enabled = true
 	} else if p.check(lexer.TokenFalse) {
@@ -768,10 +917,10 @@ func (p *Parser) parseAutoescapeBlock() (Node, error) {
 		case "off":
 			enabled = false
 		default:
-			return nil, p.error(fmt.Sprintf("expected 'true', 'false', 'on', or 'off' after autoescape, got '%s'", value))
+			return nil, p.error(fmt.Sprintf("expected 'true', 'false', 'on', 'off' or an escape context such as 'js' after autoescape, got '%s'", value))
 		}
 	} else {
-		return nil, p.error("expected 'true', 'false', 'on', or 'off' after autoescape")
+		return nil, p.error("expected 'true', 'false', 'on', 'off' or an escape context such as 'js' after autoescape")
 	}
 
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
@@ -780,6 +929,7 @@ func (p *Parser) parseAutoescapeBlock() (Node, error) {
 	p.advance()
 
 	autoescapeNode := NewAutoescapeNode(enabled, autoescapeToken.Line, autoescapeToken.Column)
+	autoescapeNode.Context = escapeContext
 
 	// Parse body until endautoescape
 	for !p.isAtEnd() {
@@ -800,7 +950,7 @@ func (p *Parser) parseAutoescapeBlock() (Node, error) {
 
 	// Expect endautoescape
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endautoescape %}' to close autoescape block")
+		return nil, p.unclosedTagError(autoescapeToken)
 	}
 	p.advance() // consume {%
 
@@ -809,10 +959,9 @@ func (p *Parser) parseAutoescapeBlock() (Node, error) {
 	}
 	p.advance() // consume endautoescape
 
-	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endautoescape")
+	if err := p.expectTagEnd("endautoescape"); err != nil {
+		return nil, err
 	}
-	p.advance()
 
 	return autoescapeNode, nil
 }
@@ -882,6 +1031,13 @@ func (p *Parser) GetTokens() []*lexer.Token {
 	return p.tokens
 }
 
+// SetTagHandler sets the handler for block tags that aren't built in, which
+// is consulted wherever a statement can be written, including the bodies of
+// built-in blocks
+func (p *Parser) SetTagHandler(handler TagHandler) {
+	p.tagHandler = handler
+}
+
 // ParseBlockStatementPublic exposes parseBlockStatement for extensions
 func (p *Parser) ParseBlockStatementPublic() (Node, error) {
 	return p.parseBlockStatement()
@@ -894,31 +1050,34 @@ func (p *Parser) parseExpression() (ExpressionNode, error) {
 	return p.parseConditional()
 }
 
-// parseConditional parses conditional expressions (ternary operator)
+// parseConditional parses conditional expressions (ternary operator). As in
+// Jinja2 the else branch is optional and, like the false branch, nests to the
+// right, so a if b else c if d else e is a if b else (c if d else e), while
+// a if b if c is (a if b) if c.
 func (p *Parser) parseConditional() (ExpressionNode, error) {
 	expr, err := p.parseOr()
 	if err != nil {
 		return nil, err
 	}
 
-	if p.check(lexer.TokenIf) {
+	for p.check(lexer.TokenIf) {
 		p.advance() // consume 'if'
 		condition, err := p.parseOr()
 		if err != nil {
 			return nil, err
 		}
 
-		if !p.check(lexer.TokenElse) {
-			return nil, p.error("expected 'else' in conditional expression")
-		}
-		p.advance() // consume 'else'
-
-		falseExpr, err := p.parseConditional()
-		if err != nil {
-			return nil, err
+		// Without an else the expression is undefined when the condition is false
+		var falseExpr ExpressionNode
+		if p.check(lexer.TokenElse) {
+			p.advance() // consume 'else'
+			falseExpr, err = p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		return NewConditionalNode(condition, expr, falseExpr, p.previous().Line, p.previous().Column), nil
+		expr = NewConditionalNode(condition, expr, falseExpr, p.previous().Line, p.previous().Column)
 	}
 
 	return expr, nil
@@ -962,7 +1121,8 @@ func (p *Parser) parseAnd() (ExpressionNode, error) {
 	return expr, nil
 }
 
-// parseNot parses logical NOT expressions
+// parseNot parses logical NOT expressions. As in Jinja2, not applies to a
+// whole comparison, so not a in b is not (a in b).
 func (p *Parser) parseNot() (ExpressionNode, error) {
 	if p.check(lexer.TokenNot) {
 		operator := p.advance()
@@ -973,61 +1133,7 @@ func (p *Parser) parseNot() (ExpressionNode, error) {
 		return AcquireUnaryOpNode(operator.Value, expr, operator.Line, operator.Column), nil
 	}
 
-	return p.parseIs()
-}
-
-// parseIs parses 'is' test expressions
-func (p *Parser) parseIs() (ExpressionNode, error) {
-	expr, err := p.parseComparison()
-	if err != nil {
-		return nil, err
-	}
-
-	if p.check(lexer.TokenIs) {
-		p.advance() // consume 'is'
-
-		negated := false
-		if p.check(lexer.TokenNot) {
-			p.advance() // consume 'not'
-			negated = true
-		}
-
-		if !p.check(lexer.TokenIdentifier) && !p.check(lexer.TokenNoneKeyword) {
-			return nil, p.error("expected test name after 'is'")
-		}
-		testName := p.advance().Value
-
-		testNode := NewTestNode(expr, testName, p.previous().Line, p.previous().Column)
-		testNode.Negated = negated
-
-		// Parse test arguments if present
-		if p.check(lexer.TokenLeftParen) {
-			p.advance() // consume '('
-
-			for !p.check(lexer.TokenRightParen) && !p.isAtEnd() {
-				arg, err := p.parseExpression()
-				if err != nil {
-					return nil, err
-				}
-				testNode.Arguments = append(testNode.Arguments, arg)
-
-				if p.check(lexer.TokenComma) {
-					p.advance() // consume ','
-				} else if !p.check(lexer.TokenRightParen) {
-					return nil, p.error("expected ',' or ')' in test arguments")
-				}
-			}
-
-			if !p.check(lexer.TokenRightParen) {
-				return nil, p.error("expected ')' after test arguments")
-			}
-			p.advance() // consume ')'
-		}
-
-		return testNode, nil
-	}
-
-	return expr, nil
+	return p.parseComparison()
 }
 
 // parseComparison parses comparison expressions
@@ -1037,27 +1143,35 @@ func (p *Parser) parseComparison() (ExpressionNode, error) {
 		return nil, err
 	}
 
+	var operators []string
+	var comparators []ExpressionNode
+	var first *lexer.Token
 	for p.checkAny(lexer.TokenGreater, lexer.TokenGreaterEqual, lexer.TokenLess, lexer.TokenLessEqual, lexer.TokenEqual, lexer.TokenNotEqual, lexer.TokenIn) || (p.check(lexer.TokenNot) && p.checkNext(lexer.TokenIn)) {
-		if p.check(lexer.TokenNot) && p.checkNext(lexer.TokenIn) {
-			// Handle "not in" operator
-			notToken := p.advance() // consume 'not'
-			p.advance()             // consume 'in'
-			right, err := p.parseConcatenation()
-			if err != nil {
-				return nil, err
-			}
-			expr = AcquireBinaryOpNode(expr, "not in", right, notToken.Line, notToken.Column)
-		} else {
-			operator := p.advance()
-			right, err := p.parseConcatenation()
-			if err != nil {
-				return nil, err
-			}
-			expr = AcquireBinaryOpNode(expr, operator.Value, right, operator.Line, operator.Column)
+		token := p.advance()
+		operator := token.Value
+		if token.Type == lexer.TokenNot {
+			p.advance() // consume 'in'
+			operator = "not in"
+		}
+		if first == nil {
+			first = token
+		}
+		right, err := p.parseConcatenation()
+		if err != nil {
+			return nil, err
 		}
+		operators = append(operators, operator)
+		comparators = append(comparators, right)
 	}
 
-	return expr, nil
+	switch len(operators) {
+	case 0:
+		return expr, nil
+	case 1:
+		return AcquireBinaryOpNode(expr, operators[0], comparators[0], first.Line, first.Column), nil
+	}
+	// a < b < c holds when a < b and b < c, as in Python
+	return NewCompareNode(expr, operators, comparators, first.Line, first.Column), nil
 }
 
 // parseConcatenation parses string concatenation (~)
@@ -1117,18 +1231,42 @@ func (p *Parser) parseMultiplication() (ExpressionNode, error) {
 	return expr, nil
 }
 
-// parseUnary parses unary expressions
+// parseUnary parses unary expressions. As in Jinja2 a sign applies before
+// the filters and tests that follow its operand, so -x|abs is abs(-x) and
+// -x is number tests -x, while ** binds tighter, so -2**2 is -4.
 func (p *Parser) parseUnary() (ExpressionNode, error) {
+	if !p.checkAny(lexer.TokenMinus, lexer.TokenPlus) {
+		return p.parsePower()
+	}
+
+	expr, err := p.parseSigned()
+	if err != nil {
+		return nil, err
+	}
+	expr, err = p.parseTrailers(expr, true)
+	if err != nil {
+		return nil, err
+	}
+	return p.parsePowerOf(expr)
+}
+
+// parseSigned parses a sign and its operand, which ends before any filter
+// or test
+func (p *Parser) parseSigned() (ExpressionNode, error) {
+	operator := p.advance()
+	var operand ExpressionNode
+	var err error
 	if p.checkAny(lexer.TokenMinus, lexer.TokenPlus) {
-		operator := p.advance()
-		expr, err := p.parsePower() // Unary should have lower precedence than power
-		if err != nil {
-			return nil, err
+		operand, err = p.parseSigned()
+	} else if operand, err = p.parsePrimary(); err == nil {
+		if operand, err = p.parseTrailers(operand, false); err == nil {
+			operand, err = p.parsePowerOf(operand)
 		}
-		return AcquireUnaryOpNode(operator.Value, expr, operator.Line, operator.Column), nil
 	}
-
-	return p.parsePower()
+	if err != nil {
+		return nil, err
+	}
+	return AcquireUnaryOpNode(operator.Value, operand, operator.Line, operator.Column), nil
 }
 
 // parsePower parses power expressions (**)
@@ -1137,7 +1275,11 @@ func (p *Parser) parsePower() (ExpressionNode, error) {
 	if err != nil {
 		return nil, err
 	}
+	return p.parsePowerOf(expr)
+}
 
+// parsePowerOf parses the exponent following expr, if any
+func (p *Parser) parsePowerOf(expr ExpressionNode) (ExpressionNode, error) {
 	if p.check(lexer.TokenPower) {
 		operator := p.advance()
 		right, err := p.parseUnary() // Right associative, and unary can bind to the right side
@@ -1150,17 +1292,31 @@ func (p *Parser) parsePower() (ExpressionNode, error) {
 	return expr, nil
 }
 
-// parsePostfix parses postfix expressions (filters, attribute access, etc.)
+// parsePostfix parses postfix expressions (filters, tests, attribute access, etc.)
 func (p *Parser) parsePostfix() (ExpressionNode, error) {
 	expr, err := p.parsePrimary()
 	if err != nil {
 		return nil, err
 	}
+	return p.parseTrailers(expr, true)
+}
 
+// parseTrailers parses the attribute accesses, subscripts and calls
+// following expr and, with filters set, its filters and tests. As in Jinja2
+// tests bind like filters, tighter than any operator: a + b is number is
+// a + (b is number), and not a is none is not (a is none).
+func (p *Parser) parseTrailers(expr ExpressionNode, filters bool) (ExpressionNode, error) {
+	var err error
 	for {
-		if p.check(lexer.TokenDot) {
+		if filters && p.check(lexer.TokenIs) {
+			expr, err = p.parseTest(expr)
+			if err != nil {
+				return nil, err
+			}
+
+		} else if p.check(lexer.TokenDot) {
 			p.advance() // consume '.'
-			if !p.check(lexer.TokenIdentifier) {
+			if !p.checkName() {
 				return nil, p.error("expected attribute name after '.'")
 			}
 			attr := p.advance().Value
@@ -1254,12 +1410,13 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 				}
 			}
 
-		} else if p.check(lexer.TokenPipe) {
+		} else if filters && p.check(lexer.TokenPipe) {
 			p.advance() // consume '|'
 			if !p.check(lexer.TokenIdentifier) && !p.check(lexer.TokenFilter) {
 				return nil, p.error("expected filter name after '|'")
 			}
-			filterName := p.advance().Value
+			filterToken := p.advance()
+			filterName := filterToken.Value
 
 			var args []ExpressionNode
 			namedArgs := make(map[string]ExpressionNode)
@@ -1313,7 +1470,7 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 				p.advance() // consume ')'
 			}
 
-			filterNode := AcquireFilterNode(expr, filterName, args, p.previous().Line, p.previous().Column)
+			filterNode := AcquireFilterNode(expr, filterName, args, filterToken.Line, filterToken.Column)
 			filterNode.NamedArgs = namedArgs
 			expr = filterNode
 
@@ -1336,6 +1493,7 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 
 				var args []ExpressionNode
 				var keywords map[string]ExpressionNode
+				var keywordOrder []string
 
 				for !p.check(lexer.TokenRightParen) && !p.isAtEnd() {
 					// Check for keyword argument
@@ -1349,6 +1507,9 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 						if err != nil {
 							return nil, err
 						}
+						if _, seen := keywords[key]; !seen {
+							keywordOrder = append(keywordOrder, key)
+						}
 						keywords[key] = value
 					} else {
 						if keywords != nil {
@@ -1376,6 +1537,7 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 				callNode := AcquireCallNode(expr, p.previous().Line, p.previous().Column)
 				callNode.Arguments = args
 				callNode.Keywords = keywords
+				callNode.KeywordOrder = keywordOrder
 				expr = callNode
 			}
 
@@ -1387,6 +1549,65 @@ func (p *Parser) parsePostfix() (ExpressionNode, error) {
 	return expr, nil
 }
 
+// parseTest parses the test applied to expr by 'is'. Any name, keywords
+// included, names a test, as in x is none, x is true or x is in y.
+func (p *Parser) parseTest(expr ExpressionNode) (ExpressionNode, error) {
+	p.advance() // consume 'is'
+
+	negated := false
+	if p.check(lexer.TokenNot) {
+		p.advance() // consume 'not'
+		negated = true
+	}
+
+	if !p.checkName() {
+		return nil, p.error("expected test name after 'is'")
+	}
+	testName := p.advance().Value
+
+	testNode := NewTestNode(expr, testName, p.previous().Line, p.previous().Column)
+	testNode.Negated = negated
+
+	// Parse test arguments if present
+	if p.check(lexer.TokenLeftParen) {
+		p.advance() // consume '('
+
+		for !p.check(lexer.TokenRightParen) && !p.isAtEnd() {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			testNode.Arguments = append(testNode.Arguments, arg)
+
+			if p.check(lexer.TokenComma) {
+				p.advance() // consume ','
+			} else if !p.check(lexer.TokenRightParen) {
+				return nil, p.error("expected ',' or ')' in test arguments")
+			}
+		}
+
+		if !p.check(lexer.TokenRightParen) {
+			return nil, p.error("expected ')' after test arguments")
+		}
+		p.advance() // consume ')'
+	} else if p.checkAny(lexer.TokenIdentifier, lexer.TokenString, lexer.TokenInteger, lexer.TokenFloat,
+		lexer.TokenTrue, lexer.TokenFalse, lexer.TokenNoneKeyword, lexer.TokenLeftBracket, lexer.TokenLeftBrace) {
+		// As in Jinja2 a single argument may follow without parentheses,
+		// as in x is divisibleby 3 or x is in [1, 2]. Like the test, it
+		// ends before any filter.
+		arg, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if arg, err = p.parseTrailers(arg, false); err != nil {
+			return nil, err
+		}
+		testNode.Arguments = append(testNode.Arguments, arg)
+	}
+
+	return testNode, nil
+}
+
 // parsePrimary parses primary expressions
 func (p *Parser) parsePrimary() (ExpressionNode, error) {
 	switch p.peek().Type {
@@ -1479,7 +1700,7 @@ func (p *Parser) parseListLiteral() (ExpressionNode, error) {
 		}
 		p.advance() // consume 'in'
 
-		iterable, err := p.parseExpression()
+		iterable, err := p.parseOr() // Use parseOr to avoid consuming the 'if' token
 		if err != nil {
 			return nil, err
 		}
@@ -1535,7 +1756,7 @@ func (p *Parser) parseDictLiteral() (ExpressionNode, error) {
 
 	if p.check(lexer.TokenRightBrace) {
 		p.advance() // consume '}'
-		return AcquireLiteralNode(map[string]interface{}{}, "{}", startToken.Line, startToken.Column), nil
+		return NewDictNode(nil, nil, startToken.Line, startToken.Column), nil
 	}
 
 	// Parse first key-value pair
@@ -1568,7 +1789,7 @@ func (p *Parser) parseDictLiteral() (ExpressionNode, error) {
 		}
 		p.advance() // consume 'in'
 
-		iterable, err := p.parseExpression()
+		iterable, err := p.parseOr() // Use parseOr to avoid consuming the 'if' token
 		if err != nil {
 			return nil, err
 		}
@@ -1595,9 +1816,9 @@ func (p *Parser) parseDictLiteral() (ExpressionNode, error) {
 		return compNode, nil
 	}
 
-	// Regular dictionary literal - for now return empty dict
-	// In a full implementation, we'd build the actual dictionary
-	pairs := make(map[string]interface{})
+	// Regular dictionary literal
+	keys := []ExpressionNode{key}
+	values := []ExpressionNode{value}
 
 	if p.check(lexer.TokenComma) {
 		p.advance() // consume ','
@@ -1617,10 +1838,8 @@ func (p *Parser) parseDictLiteral() (ExpressionNode, error) {
 			if err != nil {
 				return nil, err
 			}
-
-			// Store key-value pair (simplified)
-			_ = key
-			_ = value
+			keys = append(keys, key)
+			values = append(values, value)
 
 			if p.check(lexer.TokenComma) {
 				p.advance() // consume ','
@@ -1635,13 +1854,13 @@ func (p *Parser) parseDictLiteral() (ExpressionNode, error) {
 	}
 	p.advance() // consume '}'
 
-	return AcquireLiteralNode(pairs, "", startToken.Line, startToken.Column), nil
+	return NewDictNode(keys, values, startToken.Line, startToken.Column), nil
 }
 
 // Helper methods
 
 func (p *Parser) peek() *lexer.Token {
-	if p.isAtEnd() {
+	if p.current >= len(p.tokens) {
 		return &lexer.Token{Type: lexer.TokenEOF}
 	}
 	return p.tokens[p.current]
@@ -1679,6 +1898,16 @@ func (p *Parser) check(tokenType lexer.TokenType) bool {
 	return p.peek().Type == tokenType
 }
 
+// checkName reports whether the current token can be used as a name, which
+// includes keywords in positions such as attribute access (e.g. obj.block)
+func (p *Parser) checkName() bool {
+	if p.isAtEnd() {
+		return false
+	}
+	tok := p.peek()
+	return tok.Type == lexer.TokenIdentifier || lexer.LookupKeyword(tok.Value) == tok.Type
+}
+
 func (p *Parser) checkAny(types ...lexer.TokenType) bool {
 	for _, t := range types {
 		if p.check(t) {
@@ -1748,13 +1977,40 @@ func (p *Parser) parseImportStatement() (Node, error) {
 	}
 	alias := p.advance().Value
 
+	withContext, err := p.parseImportContext()
+	if err != nil {
+		return nil, err
+	}
+
 	// Expect closing %}
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 		return nil, p.error("expected '%}' after import statement")
 	}
 	p.advance() // consume '%}'
 
-	return NewImportNode(importToken.Line, importToken.Column, template, alias), nil
+	importNode := NewImportNode(importToken.Line, importToken.Column, template, alias)
+	importNode.WithContext = withContext
+	return importNode, nil
+}
+
+// parseImportContext parses the optional "with context" or "without context"
+// that ends an import or from statement
+func (p *Parser) parseImportContext() (bool, error) {
+	withContext := false
+	switch {
+	case p.check(lexer.TokenWith):
+		withContext = true
+	case p.check(lexer.TokenIdentifier) && p.peek().Value == "without":
+	default:
+		return false, nil
+	}
+	p.advance() // consume 'with' or 'without'
+
+	if !p.check(lexer.TokenIdentifier) || p.peek().Value != "context" {
+		return false, p.error("expected 'context' after 'with' or 'without' in import")
+	}
+	p.advance() // consume 'context'
+	return withContext, nil
 }
 
 // parseFromStatement parses from-import statements {% from 'template' import name1, name2 %}
@@ -1802,20 +2058,24 @@ func (p *Parser) parseFromStatement() (Node, error) {
 		break
 	}
 
+	withContext, err := p.parseImportContext()
+	if err != nil {
+		return nil, err
+	}
+
 	// Expect closing %}
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 		return nil, p.error("expected '%}' after from statement")
 	}
 	p.advance() // consume '%}'
 
-	return NewFromNode(fromToken.Line, fromToken.Column, template, names, aliases), nil
+	fromNode := NewFromNode(fromToken.Line, fromToken.Column, template, names, aliases)
+	fromNode.WithContext = withContext
+	return fromNode, nil
 }
 
 func (p *Parser) error(message string) error {
-	token := p.peek()
-	fullMsg := fmt.Sprintf("%s at line %d, column %d", message, token.Line, token.Column)
-	p.errors = append(p.errors, fullMsg)
-	return fmt.Errorf("%s", fullMsg)
+	return p.errorAt(p.peek(), message)
 }
 
 // GetErrors returns any errors encountered during parsing
@@ -1826,6 +2086,7 @@ func (p *Parser) GetErrors() []string {
 // parseCallBlockStatement parses {% call expression %}...{% endcall %} statements
 func (p *Parser) parseCallBlockStatement() (Node, error) {
 	startToken := p.advance() // consume 'call'
+	defer p.enterTag(startToken)()
 
 	// Parse the call expression (function/macro call)
 	callExpr, err := p.parseExpression()
@@ -1834,7 +2095,7 @@ func (p *Parser) parseCallBlockStatement() (Node, error) {
 	}
 
 	// Expect {% %}
-	if !p.check(lexer.TokenBlockEnd) {
+	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 		return nil, p.error("expected '%}' after call expression")
 	}
 	p.advance() // consume '%}'
@@ -1860,7 +2121,7 @@ func (p *Parser) parseCallBlockStatement() (Node, error) {
 
 	// Consume the {% endcall %} block
 	if !(p.check(lexer.TokenBlockStart) || p.check(lexer.TokenBlockStartTrim)) {
-		return nil, p.error("expected '{% endcall %}' to close call block")
+		return nil, p.unclosedTagError(startToken)
 	}
 	p.advance() // consume '{%'
 
@@ -1869,10 +2130,9 @@ func (p *Parser) parseCallBlockStatement() (Node, error) {
 	}
 	p.advance() // consume 'endcall'
 
-	if !(p.check(lexer.TokenBlockEnd) || p.check(lexer.TokenBlockEndTrim)) {
-		return nil, p.error("expected '%}' after endcall")
+	if err := p.expectTagEnd("endcall"); err != nil {
+		return nil, err
 	}
-	p.advance() // consume '%}'
 
 	return NewCallBlockNode(callExpr, body, startToken.Line, startToken.Column), nil
 }
@@ -1880,6 +2140,7 @@ func (p *Parser) parseCallBlockStatement() (Node, error) {
 // parseWithStatement parses {% with var=expr, var2=expr2 %}...{% endwith %} statements
 func (p *Parser) parseWithStatement() (Node, error) {
 	startToken := p.advance() // consume 'with'
+	defer p.enterTag(startToken)()
 
 	// Parse assignments (var1=expr1, var2=expr2, ...)
 	assignments := make(map[string]ExpressionNode)
@@ -1940,7 +2201,7 @@ func (p *Parser) parseWithStatement() (Node, error) {
 
 	// Consume the {% endwith %} block
 	if !(p.check(lexer.TokenBlockStart) || p.check(lexer.TokenBlockStartTrim)) {
-		return nil, p.error("expected '{% endwith %}' to close with block")
+		return nil, p.unclosedTagError(startToken)
 	}
 	p.advance() // consume '{%'
 
@@ -1949,10 +2210,9 @@ func (p *Parser) parseWithStatement() (Node, error) {
 	}
 	p.advance() // consume 'endwith'
 
-	if !(p.check(lexer.TokenBlockEnd) || p.check(lexer.TokenBlockEndTrim)) {
-		return nil, p.error("expected '%}' after endwith")
+	if err := p.expectTagEnd("endwith"); err != nil {
+		return nil, err
 	}
-	p.advance() // consume '%}'
 
 	return NewWithNode(assignments, body, startToken.Line, startToken.Column), nil
 }
@@ -1979,6 +2239,8 @@ func (p *Parser) parseDoStatement() (Node, error) {
 // parseFilterBlock parses filter blocks {% filter upper|trim %}...{% endfilter %}
 func (p *Parser) parseFilterBlock() (Node, error) {
 	filterToken := p.advance() // consume 'filter'
+	indent := p.tagIndent(p.current - 1)
+	defer p.enterTag(filterToken)()
 
 	// Parse the filter chain
 	var filterChain []FilterNode
@@ -2049,15 +2311,21 @@ func (p *Parser) parseFilterBlock() (Node, error) {
 		}
 	}
 
+	// Create the filter block node
+	filterBlockNode := NewFilterBlockNode(filterChain, filterToken.Line, filterToken.Column)
+	if p.checkIndentContent() {
+		p.advance() // consume 'indent'
+		p.advance() // consume 'content'
+		filterBlockNode.IndentContent = true
+		filterBlockNode.Indent = indent
+	}
+
 	// Expect block end
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
 		return nil, p.error("expected '%}' after filter chain")
 	}
 	p.advance() // consume '%}'
 
-	// Create the filter block node
-	filterBlockNode := NewFilterBlockNode(filterChain, filterToken.Line, filterToken.Column)
-
 	// Parse block body until {% endfilter %}
 	for !p.isAtEnd() {
 		if p.check(lexer.TokenBlockStart) || p.check(lexer.TokenBlockStartTrim) {
@@ -2075,7 +2343,7 @@ func (p *Parser) parseFilterBlock() (Node, error) {
 
 	// Expect {% endfilter %}
 	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
-		return nil, p.error("expected '{% endfilter %}' to close filter block")
+		return nil, p.unclosedTagError(filterToken)
 	}
 	p.advance() // consume '{%'
 
@@ -2084,10 +2352,153 @@ func (p *Parser) parseFilterBlock() (Node, error) {
 	}
 	p.advance() // consume 'endfilter'
 
+	if err := p.expectTagEnd("endfilter"); err != nil {
+		return nil, err
+	}
+
+	return filterBlockNode, nil
+}
+
+// parseCacheBlock parses fragment cache blocks {% cache key timeout=300 %}...{% endcache %}
+func (p *Parser) parseCacheBlock() (Node, error) {
+	cacheToken := p.advance() // consume 'cache'
+	defer p.enterTag(cacheToken)()
+
+	if p.check(lexer.TokenBlockEnd) || p.check(lexer.TokenBlockEndTrim) {
+		return nil, p.error("expected cache key after 'cache'")
+	}
+	key, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	cacheNode := NewCacheNode(key, cacheToken.Line, cacheToken.Column)
+
+	// Optional timeout, separated from the key by whitespace or a comma
+	if p.check(lexer.TokenComma) {
+		p.advance()
+	}
+	if p.check(lexer.TokenIdentifier) && p.peek().Value == "timeout" && p.peekNext().Type == lexer.TokenAssign {
+		p.advance() // consume 'timeout'
+		p.advance() // consume '='
+		if cacheNode.Timeout, err = p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+
 	if !p.check(lexer.TokenBlockEnd) && !p.check(lexer.TokenBlockEndTrim) {
-		return nil, p.error("expected '%}' after endfilter")
+		return nil, p.error("expected '%}' after cache key")
 	}
 	p.advance() // consume '%}'
 
-	return filterBlockNode, nil
+	// Parse block body until {% endcache %}
+	for !p.isAtEnd() {
+		if p.check(lexer.TokenBlockStart) || p.check(lexer.TokenBlockStartTrim) {
+			if next := p.peekNext(); next.Type == lexer.TokenIdentifier && next.Value == "endcache" {
+				break
+			}
+		}
+
+		node, err := p.parseTopLevel()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			cacheNode.Body = append(cacheNode.Body, node)
+		}
+	}
+
+	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
+		return nil, p.unclosedTagError(cacheToken)
+	}
+	p.advance() // consume '{%'
+	p.advance() // consume 'endcache'
+
+	if err := p.expectTagEnd("endcache"); err != nil {
+		return nil, err
+	}
+
+	return cacheNode, nil
+}
+
+// parseEmbedBlock parses embed blocks {% embed "card.html" with ... %}{% block body %}...{% endblock %}{% endembed %}.
+// Only blocks, whitespace and comments may appear between the tags.
+func (p *Parser) parseEmbedBlock() (Node, error) {
+	embedToken := p.advance() // consume 'embed'
+	defer p.enterTag(embedToken)()
+
+	if p.check(lexer.TokenBlockEnd) || p.check(lexer.TokenBlockEndTrim) {
+		return nil, p.error("expected template name after 'embed'")
+	}
+	template, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	embedNode := NewEmbedNode(template, embedToken.Line, embedToken.Column)
+
+	// ignore missing may come before or after the context
+	parseIgnoreMissing := func() error {
+		if embedNode.IgnoreMissing || !p.check(lexer.TokenIgnore) {
+			return nil
+		}
+		p.advance() // consume 'ignore'
+		if !p.check(lexer.TokenMissing) {
+			return p.error("expected 'missing' after 'ignore'")
+		}
+		p.advance() // consume 'missing'
+		embedNode.IgnoreMissing = true
+		return nil
+	}
+	if err := parseIgnoreMissing(); err != nil {
+		return nil, err
+	}
+	embedNode.Context, embedNode.Assignments, embedNode.Only, err = p.parseIncludeWith("embed")
+	if err != nil {
+		return nil, err
+	}
+	if err := parseIgnoreMissing(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectTagEnd("embed"); err != nil {
+		return nil, err
+	}
+
+	// Parse the overriding blocks until {% endembed %}
+	for !p.isAtEnd() {
+		if p.check(lexer.TokenBlockStart) || p.check(lexer.TokenBlockStartTrim) {
+			if next := p.peekNext(); next.Type == lexer.TokenIdentifier && next.Value == "endembed" {
+				break
+			}
+		}
+
+		start := p.peek()
+		node, err := p.parseTopLevel()
+		if err != nil {
+			return nil, err
+		}
+		switch n := node.(type) {
+		case nil, *BlockNode, *CommentNode:
+		case *TextNode:
+			if strings.TrimSpace(n.Content) != "" {
+				return nil, p.errorAt(start, "only blocks may appear inside an embed, found text")
+			}
+		default:
+			return nil, p.errorAt(start, "only blocks may appear inside an embed")
+		}
+		if node != nil {
+			embedNode.Body = append(embedNode.Body, node)
+		}
+	}
+
+	if !p.check(lexer.TokenBlockStart) && !p.check(lexer.TokenBlockStartTrim) {
+		return nil, p.unclosedTagError(embedToken)
+	}
+	p.advance() // consume '{%'
+	p.advance() // consume 'endembed'
+
+	if err := p.expectTagEnd("endembed"); err != nil {
+		return nil, err
+	}
+
+	return embedNode, nil
 }
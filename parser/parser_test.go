@@ -116,6 +116,16 @@ func TestParseExpressions(t *testing.T) {
 			input:   "{{ value if condition else default }}",
 			wantErr: false,
 		},
+		{
+			name:    "conditional expression without else",
+			input:   "{{ value if condition }}",
+			wantErr: false,
+		},
+		{
+			name:    "test argument without parentheses",
+			input:   "{{ x is divisibleby 3 }}{{ x is in [1, 2] }}",
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -257,6 +267,11 @@ No items
 			input:   `{% for item in items %}{{ item }}`,
 			wantErr: true,
 		},
+		{
+			name:    "loop as target",
+			input:   `{% for key, loop in items %}{{ key }}{% endfor %}`,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -740,3 +755,99 @@ func TestParserPrecedence(t *testing.T) {
 		})
 	}
 }
+
+func TestConditionalAssociativity(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{{ a if b else c if d else e }}`, "Conditional(Id(b) ? Id(a) : Conditional(Id(d) ? Id(c) : Id(e)))"},
+		{`{{ a if b else c if d }}`, "Conditional(Id(b) ? Id(a) : Conditional(Id(d) ? Id(c)))"},
+		{`{{ a if b if c }}`, "Conditional(Id(c) ? Conditional(Id(b) ? Id(a)))"},
+		{`{{ a or b if c and d }}`, "Conditional(BinOp(Id(c) and Id(d)) ? BinOp(Id(a) or Id(b)))"},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse("test", tt.input)
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", tt.input, err)
+			continue
+		}
+		expr := ast.Children[0].(*VariableNode).Expression
+		if got := expr.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestExpressionPrecedence checks the grouping of Jinja2's operators: not
+// applies to whole comparisons, filters and tests, in and not in are
+// comparisons, and tests bind like filters, tighter than any operator but
+// after a sign
+func TestExpressionPrecedence(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`not a in b`, "UnaryOp(not BinOp(Id(a) in Id(b)))"},
+		{`not a not in b`, "UnaryOp(not BinOp(Id(a) not in Id(b)))"},
+		{`not a is none`, "UnaryOp(not Test(Id(a) is none))"},
+		{`not not a`, "UnaryOp(not UnaryOp(not Id(a)))"},
+		{`not x|length`, "UnaryOp(not Filter(Id(x)|length))"},
+		{`not x|selectattr("active")|list`, "UnaryOp(not Filter(Filter(Id(x)|selectattr(Literal(active)))|list))"},
+		{`not x|length is odd`, "UnaryOp(not Test(Filter(Id(x)|length) is odd))"},
+		{`not x is not defined`, "UnaryOp(not Test(Id(x) is not defined))"},
+		{`not -x|abs`, "UnaryOp(not Filter(UnaryOp(- Id(x))|abs))"},
+		{`(not x)|string`, "Filter(UnaryOp(not Id(x))|string)"},
+		{`(not x) is true`, "Test(UnaryOp(not Id(x)) is true)"},
+		{`not a and b or not c`, "BinOp(BinOp(UnaryOp(not Id(a)) and Id(b)) or UnaryOp(not Id(c)))"},
+		{`a or b and not c in d`, "BinOp(Id(a) or BinOp(Id(b) and UnaryOp(not BinOp(Id(c) in Id(d)))))"},
+		{`a in b == c`, "Compare(Id(a) in Id(b) == Id(c))"},
+		{`a is not none or b`, "BinOp(Test(Id(a) is not none) or Id(b))"},
+		{`a is in b or c`, "BinOp(Test(Id(a) is in(Id(b))) or Id(c))"},
+		{`a is defined and a in b`, "BinOp(Test(Id(a) is defined) and BinOp(Id(a) in Id(b)))"},
+		{`a + b is number`, "BinOp(Id(a) + Test(Id(b) is number))"},
+		{`a ~ b is string`, "BinOp(Id(a) ~ Test(Id(b) is string))"},
+		{`a == b is defined`, "BinOp(Id(a) == Test(Id(b) is defined))"},
+		{`x is divisibleby 3 and y`, "BinOp(Test(Id(x) is divisibleby(Literal(3))) and Id(y))"},
+		{`x is in [1, 2]`, "Test(Id(x) is in(List([Literal(1), Literal(2)])))"},
+		{`x is not in y|list`, "Filter(Test(Id(x) is not in(Id(y)))|list)"},
+		{`x is none|string`, "Filter(Test(Id(x) is none)|string)"},
+		{`-x|abs`, "Filter(UnaryOp(- Id(x))|abs)"},
+		{`-x is number`, "Test(UnaryOp(- Id(x)) is number)"},
+		{`-2 ** 2`, "UnaryOp(- BinOp(Literal(2) ** Literal(2)))"},
+		// Tests named like keywords
+		{`x is true or x is false`, "BinOp(Test(Id(x) is true) or Test(Id(x) is false))"},
+		{`x is sameas false`, "Test(Id(x) is sameas(Literal(false)))"},
+		{`x is filter`, "Test(Id(x) is filter)"},
+		{`x|select("in", y)|list`, "Filter(Filter(Id(x)|select(Literal(in), Id(y)))|list)"},
+	}
+
+	for _, tt := range tests {
+		expr, err := ParseExpression(tt.input)
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", tt.input, err)
+			continue
+		}
+		if got := expr.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	expr, err := ParseExpression(`item.age > 30 and [1, [2]][1] is defined`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Print(expr), `item.age > 30 and [1, [2]][1] is defined`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// not is no operand of arithmetic: a + not b is an error, as in Jinja2
+	for _, src := range []string{"", "item >", "a b", "a }}", "a + not b"} {
+		if _, err := ParseExpression(src); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Print regenerates template source from an AST produced by Parse.
+//
+// Text, comments and raw blocks are reproduced verbatim, whitespace control
+// markers are restored from the TextNode flags, and expressions are written
+// in a canonical form with only the parentheses needed to preserve their
+// meaning. Parsing the output yields an equivalent AST. Expression nodes
+// print as bare expressions. Output always uses the default delimiters;
+// extension tags are printed on a best effort basis and node types from
+// outside this package print as a comment, or as none within an expression.
+func Print(node Node) string {
+	var p printer
+	p.node(node)
+	p.flush(false)
+	return p.sb.String()
+}
+
+type printer struct {
+	sb strings.Builder
+	// pendingClose holds the closing delimiter of the last tag until the
+	// next node shows whether it needs a whitespace control marker
+	pendingClose string
+	// pendingPad is written before pendingClose: a space after tag content,
+	// nothing after a comment
+	pendingPad string
+	// trimNext marks that the next tag opens with a whitespace control marker
+	trimNext bool
+}
+
+// flush writes the pending closing delimiter, with a "-" marker if trim is set
+func (p *printer) flush(trim bool) {
+	if p.pendingClose == "" {
+		return
+	}
+	p.sb.WriteString(p.pendingPad)
+	if trim {
+		p.sb.WriteString("-")
+	}
+	p.sb.WriteString(p.pendingClose)
+	p.pendingClose = ""
+}
+
+// tag writes a complete {% ... %} or {{ ... }} tag around content
+func (p *printer) tag(open, close, content string) {
+	p.flush(false)
+	p.sb.WriteString(open)
+	if p.trimNext {
+		p.sb.WriteString("-")
+		p.trimNext = false
+	}
+	p.sb.WriteString(" ")
+	p.sb.WriteString(content)
+	p.pendingClose = close
+	p.pendingPad = " "
+}
+
+// comment writes a comment with its content verbatim
+func (p *printer) comment(content string) {
+	p.flush(false)
+	p.sb.WriteString("{#")
+	if p.trimNext {
+		p.sb.WriteString("-")
+		p.trimNext = false
+	}
+	p.sb.WriteString(content)
+	p.pendingClose = "#}"
+	p.pendingPad = ""
+}
+
+func (p *printer) block(format string, args ...interface{}) {
+	p.tag("{%", "%}", fmt.Sprintf(format, args...))
+}
+
+func (p *printer) body(nodes []Node) {
+	for _, node := range nodes {
+		p.node(node)
+	}
+}
+
+func (p *printer) node(node Node) {
+	switch n := node.(type) {
+	case nil:
+	case *TemplateNode:
+		p.body(n.Children)
+	case *TextNode:
+		p.flush(n.TrimLeading)
+		p.sb.WriteString(n.Content)
+		p.trimNext = n.TrimTrailing
+	case *CommentNode:
+		p.comment(n.Content)
+	case *VariableNode:
+		p.tag("{{", "}}", printExpr(n.Expression, precConditional))
+	case *RawNode:
+		p.block("raw")
+		p.flush(false)
+		p.sb.WriteString(n.Content)
+		p.block("endraw")
+	case *IfNode:
+		p.block("if %s", printExpr(n.Condition, precConditional))
+		p.body(n.Body)
+		for _, elif := range n.ElseIfs {
+			p.block("elif %s", printExpr(elif.Condition, precConditional))
+			p.body(elif.Body)
+		}
+		if len(n.Else) > 0 {
+			p.block("else")
+			p.body(n.Else)
+		}
+		p.block("endif")
+	case *ForNode:
+		header := fmt.Sprintf("for %s in %s", strings.Join(n.Variables, ", "), printExpr(n.Iterable, precOr))
+		if n.Condition != nil {
+			header += " if " + printExpr(n.Condition, precOr)
+		}
+		if n.Recursive {
+			header += " recursive"
+		}
+		p.block("%s", header)
+		p.body(n.Body)
+		if len(n.Else) > 0 {
+			p.block("else")
+			p.body(n.Else)
+		}
+		p.block("endfor")
+	case *SetNode:
+		targets := make([]string, len(n.Targets))
+		for i, target := range n.Targets {
+			targets[i] = printExpr(target, precPostfix)
+		}
+		p.block("set %s = %s", strings.Join(targets, ", "), printExpr(n.Value, precConditional))
+	case *BlockSetNode:
+		p.block("set %s", n.Variable)
+		p.body(n.Body)
+		p.block("endset")
+	case *BlockNode:
+		p.block("block %s", n.Name)
+		p.body(n.Body)
+		p.block("endblock")
+	case *ExtendsNode:
+		p.block("extends %s", printExpr(n.Template, precConditional))
+	case *IncludeNode:
+		include := "include " + printExpr(n.Template, precConditional) + includeWith(n.Context, n.Assignments, n.Only)
+		if n.IgnoreMissing {
+			include += " ignore missing"
+		}
+		if n.IndentContent {
+			include += " indent content"
+		}
+		p.block("%s", include)
+	case *EmbedNode:
+		embed := "embed " + printExpr(n.Template, precConditional) + includeWith(n.Context, n.Assignments, n.Only)
+		if n.IgnoreMissing {
+			embed += " ignore missing"
+		}
+		p.block("%s", embed)
+		p.body(n.Body)
+		p.block("endembed")
+	case *ImportNode:
+		p.block("import %s as %s%s", printExpr(n.Template, precConditional), n.Alias, importContext(n.WithContext))
+	case *FromNode:
+		names := make([]string, len(n.Names))
+		for i, name := range n.Names {
+			names[i] = name
+			if alias, ok := n.Aliases[name]; ok {
+				names[i] += " as " + alias
+			}
+		}
+		p.block("from %s import %s%s", printExpr(n.Template, precConditional), strings.Join(names, ", "), importContext(n.WithContext))
+	case *MacroNode:
+		params := make([]string, len(n.Parameters))
+		for i, param := range n.Parameters {
+			params[i] = param
+			if def, ok := n.Defaults[param]; ok {
+				params[i] += "=" + printExpr(def, precConditional)
+			}
+		}
+		p.block("macro %s(%s)", n.Name, strings.Join(params, ", "))
+		p.body(n.Body)
+		p.block("endmacro")
+	case *CallBlockNode:
+		p.block("call %s", printExpr(n.Call, precConditional))
+		p.body(n.Body)
+		p.block("endcall")
+	case *WithNode:
+		p.block("with %s", printKeywords(n.Assignments, precConditional))
+		p.body(n.Body)
+		p.block("endwith")
+	case *DoNode:
+		p.block("do %s", printExpr(n.Expression, precConditional))
+	case *FilterBlockNode:
+		filters := make([]string, len(n.FilterChain))
+		for i := range n.FilterChain {
+			filters[i] = printFilterCall(&n.FilterChain[i], precConditional)
+		}
+		filter := "filter " + strings.Join(filters, "|")
+		if n.IndentContent {
+			filter += " indent content"
+		}
+		p.block("%s", filter)
+		p.body(n.Body)
+		p.block("endfilter")
+	case *AutoescapeNode:
+		if n.Context != "" {
+			p.block("autoescape %s", quoteString(n.Context))
+		} else {
+			p.block("autoescape %t", n.Enabled)
+		}
+		p.body(n.Body)
+		p.block("endautoescape")
+	case *CacheNode:
+		header := "cache " + printExpr(n.Key, precConditional)
+		if n.Timeout != nil {
+			header += " timeout=" + printExpr(n.Timeout, precConditional)
+		}
+		p.block("%s", header)
+		p.body(n.Body)
+		p.block("endcache")
+	case *SwitchNode:
+		p.block("switch %s", printExpr(n.Subject, precConditional))
+		for _, c := range n.Cases {
+			values := make([]string, len(c.Values))
+			for i, v := range c.Values {
+				values[i] = printExpr(v, precConditional)
+			}
+			p.block("case %s", strings.Join(values, ", "))
+			p.body(c.Body)
+		}
+		if n.Default != nil {
+			p.block("default")
+			p.body(n.Default)
+		}
+		p.block("endswitch")
+	case *BreakNode:
+		p.block("break")
+	case *ContinueNode:
+		p.block("continue")
+	case *ExtensionNode:
+		header := n.TagName
+		for _, arg := range n.Arguments {
+			header += " " + printExpr(arg, precConditional)
+		}
+		p.block("%s", header)
+		if len(n.Body) > 0 {
+			p.body(n.Body)
+			p.block("end%s", n.TagName)
+		}
+	case ExpressionNode:
+		p.flush(false)
+		p.sb.WriteString(printExpr(n, precConditional))
+	default:
+		p.flush(false)
+		fmt.Fprintf(&p.sb, "{# unprintable node %T #}", node)
+	}
+}
+
+// Expression precedence levels, lowest first, mirroring the parser's
+// descent from parseConditional to parsePrimary
+const (
+	precAssignment = iota
+	precConditional
+	precOr
+	precAnd
+	precNot
+	precComparison
+	precConcat
+	precAddition
+	precMultiplication
+	precUnary
+	precPower
+	precTest
+	precPostfix
+	precPrimary
+)
+
+var binaryPrecedence = map[string]int{
+	"or":     precOr,
+	"and":    precAnd,
+	"==":     precComparison,
+	"!=":     precComparison,
+	"<":      precComparison,
+	"<=":     precComparison,
+	">":      precComparison,
+	">=":     precComparison,
+	"in":     precComparison,
+	"not in": precComparison,
+	"~":      precConcat,
+	"+":      precAddition,
+	"-":      precAddition,
+	"*":      precMultiplication,
+	"/":      precMultiplication,
+	"//":     precMultiplication,
+	"%":      precMultiplication,
+	"**":     precPower,
+}
+
+func precedence(expr Node) int {
+	switch n := expr.(type) {
+	case *AssignmentNode:
+		return precAssignment
+	case *ConditionalNode:
+		return precConditional
+	case *BinaryOpNode:
+		if prec, ok := binaryPrecedence[n.Operator]; ok {
+			return prec
+		}
+		return precOr
+	case *CompareNode:
+		return precComparison
+	case *UnaryOpNode:
+		if n.Operator == "not" {
+			return precNot
+		}
+		return precUnary
+	case *TestNode:
+		return precTest
+	case *AttributeNode, *GetItemNode, *SliceNode, *FilterNode, *CallNode:
+		return precPostfix
+	case *LiteralNode:
+		if strings.HasPrefix(printLiteral(n), "-") {
+			return precUnary
+		}
+	}
+	return precPrimary
+}
+
+// printExpr formats expr, parenthesizing it if it binds looser than minPrec
+func printExpr(expr Node, minPrec int) string {
+	if expr == nil {
+		return "none"
+	}
+	s := formatExpr(expr)
+	if precedence(expr) < minPrec {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func formatExpr(expr Node) string {
+	switch n := expr.(type) {
+	case *IdentifierNode:
+		return n.Name
+	case *LiteralNode:
+		return printLiteral(n)
+	case *SuperNode:
+		return "super()"
+	case *ListNode:
+		return "[" + printExprs(n.Elements, precConditional) + "]"
+	case *DictNode:
+		pairs := make([]string, len(n.Keys))
+		for i := range n.Keys {
+			pairs[i] = printExpr(n.Keys[i], precConditional) + ": " + printExpr(n.Values[i], precConditional)
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *AttributeNode:
+		return printExpr(n.Object, precPostfix) + "." + n.Attribute
+	case *GetItemNode:
+		return printExpr(n.Object, precPostfix) + "[" + printExpr(n.Key, precConditional) + "]"
+	case *SliceNode:
+		var sb strings.Builder
+		sb.WriteString(printExpr(n.Object, precPostfix))
+		sb.WriteString("[")
+		if n.Start != nil {
+			sb.WriteString(printExpr(n.Start, precConditional))
+		}
+		sb.WriteString(":")
+		if n.End != nil {
+			sb.WriteString(printExpr(n.End, precConditional))
+		}
+		if n.Step != nil {
+			sb.WriteString(":")
+			sb.WriteString(printExpr(n.Step, precConditional))
+		}
+		sb.WriteString("]")
+		return sb.String()
+	case *FilterNode:
+		// Filter arguments are parsed below the conditional level
+		return printExpr(n.Expression, precPostfix) + "|" + printFilterCall(n, precOr)
+	case *CallNode:
+		args := printExprs(n.Arguments, precConditional)
+		if len(n.Keywords) > 0 {
+			if args != "" {
+				args += ", "
+			}
+			names := n.KeywordNames()
+			for i, name := range names {
+				names[i] = name + "=" + printExpr(n.Keywords[name], precConditional)
+			}
+			args += strings.Join(names, ", ")
+		}
+		return printExpr(n.Function, precPostfix) + "(" + args + ")"
+	case *BinaryOpNode:
+		if n.Operator == "**" {
+			return printExpr(n.Left, precPostfix) + " ** " + printExpr(n.Right, precUnary)
+		}
+		prec := precedence(n)
+		if prec == precComparison {
+			// Comparisons don't associate: (a < b) < c isn't a < b < c
+			return printExpr(n.Left, prec+1) + " " + n.Operator + " " + printExpr(n.Right, prec+1)
+		}
+		return printExpr(n.Left, prec) + " " + n.Operator + " " + printExpr(n.Right, prec+1)
+	case *CompareNode:
+		s := printExpr(n.Left, precComparison+1)
+		for i, op := range n.Operators {
+			s += " " + op + " " + printExpr(n.Comparators[i], precComparison+1)
+		}
+		return s
+	case *UnaryOpNode:
+		if n.Operator == "not" {
+			return "not " + printExpr(n.Operand, precNot)
+		}
+		if hasFilterTrailer(n.Operand) {
+			// A sign applies before filters and tests: -x|abs is abs(-x)
+			return n.Operator + "(" + formatExpr(n.Operand) + ")"
+		}
+		return n.Operator + printExpr(n.Operand, precPower)
+	case *TestNode:
+		s := printExpr(n.Expression, precTest) + " is "
+		if n.Negated {
+			s += "not "
+		}
+		s += n.TestName
+		if len(n.Arguments) > 0 {
+			s += "(" + printExprs(n.Arguments, precConditional) + ")"
+		}
+		return s
+	case *ConditionalNode:
+		s := printExpr(n.TrueExpr, precOr) + " if " + printExpr(n.Condition, precOr)
+		if n.FalseExpr != nil {
+			s += " else " + printExpr(n.FalseExpr, precConditional)
+		}
+		return s
+	case *ComprehensionNode:
+		var sb strings.Builder
+		if n.IsDict {
+			sb.WriteString("{")
+			sb.WriteString(printExpr(n.KeyExpr, precConditional))
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("[")
+		}
+		sb.WriteString(printExpr(n.Expression, precConditional))
+		sb.WriteString(" for ")
+		sb.WriteString(n.Variable)
+		sb.WriteString(" in ")
+		sb.WriteString(printExpr(n.Iterable, precConditional))
+		if n.Condition != nil {
+			sb.WriteString(" if ")
+			sb.WriteString(printExpr(n.Condition, precConditional))
+		}
+		if n.IsDict {
+			sb.WriteString("}")
+		} else {
+			sb.WriteString("]")
+		}
+		return sb.String()
+	case *AssignmentNode:
+		return printExpr(n.Target, precPostfix) + " = " + printExpr(n.Value, precConditional)
+	default:
+		return "none"
+	}
+}
+
+// hasFilterTrailer reports whether expr ends in a filter or test, possibly
+// followed by attribute accesses, subscripts and calls
+func hasFilterTrailer(expr Node) bool {
+	for {
+		switch n := expr.(type) {
+		case *FilterNode, *TestNode:
+			return true
+		case *AttributeNode:
+			expr = n.Object
+		case *GetItemNode:
+			expr = n.Object
+		case *SliceNode:
+			expr = n.Object
+		case *CallNode:
+			expr = n.Function
+		default:
+			return false
+		}
+	}
+}
+
+// printFilterCall formats a filter's name and arguments without its operand
+func printFilterCall(n *FilterNode, argPrec int) string {
+	if len(n.Arguments) == 0 && len(n.NamedArgs) == 0 {
+		return n.FilterName
+	}
+	args := printExprs(n.Arguments, argPrec)
+	if len(n.NamedArgs) > 0 {
+		if args != "" {
+			args += ", "
+		}
+		args += printKeywords(n.NamedArgs, argPrec)
+	}
+	return n.FilterName + "(" + args + ")"
+}
+
+func printExprs(exprs []ExpressionNode, minPrec int) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = printExpr(expr, minPrec)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printKeywords formats name=value pairs in name order
+func printKeywords(keywords map[string]ExpressionNode, minPrec int) string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + printExpr(keywords[name], minPrec)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// importContext is the suffix of an import that runs with the importer's context
+func importContext(withContext bool) string {
+	if withContext {
+		return " with context"
+	}
+	return ""
+}
+
+// includeWith prints the variables an include or embed passes on, as given
+// after its template name
+func includeWith(context ExpressionNode, assignments map[string]ExpressionNode, only bool) string {
+	with := ""
+	if context != nil {
+		with = " with " + printExpr(context, precConditional)
+	} else if len(assignments) > 0 {
+		with = " with " + printKeywords(assignments, precConditional)
+	}
+	if only {
+		with += " only"
+	}
+	return with
+}
+
+func printLiteral(n *LiteralNode) string {
+	switch n.Value.(type) {
+	case bool, nil, int, float64:
+		if n.Raw != "" {
+			return n.Raw
+		}
+	}
+	return printValue(n.Value)
+}
+
+// printValue formats a literal value as template source
+func printValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "none"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return quoteString(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			parts[i] = printValue(elem)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = quoteString(key) + ": " + printValue(v[key])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+// quoteString writes s as a double-quoted string using the escapes the
+// lexer understands
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 2)
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintRoundTrip(t *testing.T) {
+	// Each source is already in canonical form, so printing must reproduce it
+	sources := []string{
+		`Hello {{ name }}!`,
+		`{{ user.name|default("anon")|upper }}`,
+		`{{ items[0] }}{{ items[1:3] }}{{ items[:2] }}{{ items[::2] }}{{ items[1:] }}`,
+		`{{ a + b * c }}{{ (a + b) * c }}{{ a - (b - c) }}{{ a - b - c }}`,
+		`{{ 2 ** 3 ** 2 }}{{ (2 ** 3) ** 2 }}{{ -x ** 2 }}{{ (-x) ** 2 }}{{ -(a + b) }}`,
+		`{{ not a and b or c }}{{ not (a and b) }}{{ a and (b or c) }}`,
+		`{{ x if cond else y }}{{ (x if a else y) if b else z }}{{ x if a else y if b else z }}`,
+		`{{ x if cond }}{{ (x if a) if b }}{{ x if a else y if b }}{{ [x for x in items if x] }}`,
+		`{{ a ~ b ~ "!" }}{{ x in items }}{{ x not in items }}{{ a < b == c }}`,
+		`{{ 0 < x <= 10 }}{{ (a < b) < c }}{{ a < (b < c) }}{{ a in b not in c }}`,
+		`{{ n is divisibleby(3) }}{{ n is not defined }}{{ a is defined == b }}{{ a == b is defined }}{{ (a == b) is defined }}`,
+		`{{ a + b is number }}{{ (a + b) is number }}{{ not a is none }}{{ (-x) is number }}{{ x is true }}`,
+		`{{ (-x)|abs }}{{ -(x|abs) }}{{ -(x|first.y) }}{{ (x is odd)|string }}{{ (x is odd)[0] }}{{ x|first is in(y) }}`,
+		`{{ "quote \" and \\ and \n newline" }}`,
+		`{{ [1, 2.5, "three", true, none] }}{{ [] }}{{ {} }}`,
+		`{{ {"n": {"m": none}, "a": 1, key: [x, y]} }}`,
+		`{{ func(1, x, key="v", other=2) }}{{ obj.method()|length }}{{ (a + b)|string }}`,
+		`{{ value|replace("a", "b")|truncate(10, end="...") }}{{ value|default((x if y else z)) }}`,
+		`{{ [x * 2 for x in items] }}{{ {k: v for k in keys} }}`,
+		`{% if a %}A{% elif b %}B{% else %}C{% endif %}`,
+		`{% for k, v in data|dictsort if v recursive %}{{ k }}{% else %}empty{% endfor %}`,
+		`{% for x in (items if a else other) %}{% break %}{% continue %}{% endfor %}`,
+		`{% set x = 1 %}{% set a, b = pair %}{% set ns.count = ns.count + 1 %}`,
+		`{% set block_content %}<b>{{ x }}</b>{% endset %}`,
+		`{% extends "base.html" %}{% block content %}{{ super() }}{% endblock %}`,
+		`{% include "a.html" %}{% include name with ctx ignore missing %}`,
+		`{% include "card.html" with compact=true, product=item only %}{% include tpl only ignore missing %}`,
+		"spec:\n  {% include \"probe.yaml\" with port=80 ignore missing indent content %}\n  {% filter upper indent content %}x{% endfilter %}",
+		`{% import "macros.html" as m %}{% from "forms.html" import input as field, label %}`,
+		`{% import "macros.html" as m with context %}{% from "forms.html" import input with context %}`,
+		`{% macro button(text, kind="primary", size=none) %}<button class="{{ kind }}">{{ text }}</button>{% endmacro %}`,
+		`{% call m.panel("Title") %}inner{% endcall %}`,
+		`{% with a=1, b=a + 1 %}{{ b }}{% endwith %}`,
+		`{% do items.append(1) %}`,
+		`{% filter upper|replace("A", "B") %}text{% endfilter %}`,
+		`{% autoescape false %}{{ html }}{% endautoescape %}`,
+		`{% cache ["item", id] timeout="5m" %}{% cache key %}{{ x }}{% endcache %}{% endcache %}`,
+		"{% embed \"card.html\" with title=t only ignore missing %}\n  {% block body %}{{ x }}{% endblock %}\n{% endembed %}",
+		`{% embed name with {"a": 1} %}{% endembed %}`,
+		`{% raw %}{{x}}{% endraw %}`,
+		"{% raw %}\n  {{ x }} {%if%}\n{% endraw %}",
+		"{# a comment #}\n<p>{{ x }}</p>",
+		"a:\n  {#- note -#}\n  b",
+		"<ul>\n{%- for x in items -%}\n  <li>{{- x -}}</li>\n{%- endfor %}\n</ul>",
+		"{{ a }}   {{- b }}{% if c -%}   {% endif %}",
+	}
+
+	for _, src := range sources {
+		ast, err := Parse("test", src)
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", src, err)
+			continue
+		}
+		if got := Print(ast); got != src {
+			t.Errorf("round trip changed the source\n got: %s\nwant: %s", got, src)
+		}
+	}
+}
+
+func TestPrintCanonicalizes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{name}}`, `{{ name }}`},
+		{`{{ ((a)) + (b * c) }}`, `{{ a + b * c }}`},
+		{`{{ 'single' }}`, `{{ "single" }}`},
+		{`{%if x%}y{%endif%}`, `{% if x %}y{% endif %}`},
+		{`{% autoescape on %}x{% endautoescape %}`, `{% autoescape true %}x{% endautoescape %}`},
+		{`{% block b %}x{% endblock b %}`, `{% block b %}x{% endblock %}`},
+		{`{% macro m %}x{% endmacro %}`, `{% macro m() %}x{% endmacro %}`},
+		{`{% with b=2, a=1 %}{% endwith %}`, `{% with a=1, b=2 %}{% endwith %}`},
+		{`{% import "a.html" as a without context %}`, `{% import "a.html" as a %}`},
+		{`{% include "a.html" ignore missing with b=2, a=1 %}`, `{% include "a.html" with a=1, b=2 ignore missing %}`},
+		// raw markers are applied to the body
+		{"{% raw -%}\n  {{ x }}\n{%- endraw %}", `{% raw %}{{ x }}{% endraw %}`},
+		// markers with no adjacent text have no effect and are dropped
+		{`{%- if x -%}{%- endif -%}`, `{% if x %}{% endif %}`},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse("test", tt.src)
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", tt.src, err)
+			continue
+		}
+		if got := Print(ast); got != tt.want {
+			t.Errorf("Print(%s) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestPrintExpressionNodes(t *testing.T) {
+	expr := NewBinaryOpNode(
+		NewBinaryOpNode(NewIdentifierNode("a", 1, 1), "+", NewLiteralNode(1, "", 1, 1), 1, 1),
+		"*",
+		NewFilterNode(NewIdentifierNode("b", 1, 1), "abs", nil, 1, 1),
+		1, 1,
+	)
+	if got := Print(expr); got != "(a + 1) * b|abs" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Run("SetsName", func(t *testing.T) {
+		ast, err := Parse("page.html", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ast.Name != "page.html" {
+			t.Errorf("name = %q", ast.Name)
+		}
+	})
+
+	t.Run("KeepsComments", func(t *testing.T) {
+		ast, err := Parse("", "a{#- note -#}b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ast.Children) != 3 {
+			t.Fatalf("expected 3 children, got %d", len(ast.Children))
+		}
+		comment, ok := ast.Children[1].(*CommentNode)
+		if !ok || comment.Content != " note " {
+			t.Errorf("unexpected comment node %#v", ast.Children[1])
+		}
+		if !ast.Children[0].(*TextNode).TrimTrailing || !ast.Children[2].(*TextNode).TrimLeading {
+			t.Errorf("comment whitespace markers not recorded: %+v %+v", ast.Children[0], ast.Children[2])
+		}
+	})
+
+	t.Run("RecordsWhitespaceMarkers", func(t *testing.T) {
+		ast, err := Parse("", "{{ a -}} x {{- b }} y")
+		if err != nil {
+			t.Fatal(err)
+		}
+		middle := ast.Children[1].(*TextNode)
+		last := ast.Children[3].(*TextNode)
+		if !middle.TrimLeading || !middle.TrimTrailing || last.TrimLeading || last.TrimTrailing {
+			t.Errorf("markers not recorded: %+v %+v", middle, last)
+		}
+	})
+
+	t.Run("CustomDelimiters", func(t *testing.T) {
+		ast, err := Parse("", "[[ x ]][% if y %]z[% endif %]<# c #>",
+			WithDelimiters("[[", "]]", "[%", "%]"), WithCommentDelimiters("<#", "#>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Print(ast); got != "{{ x }}{% if y %}z{% endif %}{# c #}" {
+			t.Errorf("got %s", got)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := Parse("bad.html", "{% if %}"); err == nil || !strings.Contains(err.Error(), "bad.html") {
+			t.Errorf("expected error naming the template, got %v", err)
+		}
+		if _, err := Parse("bad.html", "{# open"); err == nil {
+			t.Error("expected unclosed comment error")
+		}
+	})
+}
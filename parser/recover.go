@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+// blockTags are the tags whose blocks end with an end tag of their name. A
+// set tag only opens a block without an assignment.
+var blockTags = map[string]bool{
+	"if": true, "for": true, "block": true, "macro": true, "call": true, "filter": true, "with": true,
+	"autoescape": true, "raw": true, "cache": true, "embed": true, "set": true,
+}
+
+// recoveredError is an error ParseAll recovered from, with the position it
+// is reported at
+type recoveredError struct {
+	err          error
+	line, column int
+}
+
+// ParseAll parses the tokens like Parse, but doesn't stop at the first
+// syntax error. It records the error, skips the statement the error is in
+// and carries on, so one pass reports every error of a template:
+//
+//   - a block tag with an error in the tag itself, such as an if with an
+//     invalid condition, is skipped up to and including its end tag, so its
+//     body and end tag aren't reported as misplaced
+//   - any other statement is skipped up to the next tag, variable, comment
+//     or text, keeping the blocks around it open
+//
+// The errors are returned in source order. The template holds what parsed
+// and is not meant to be rendered when there are errors.
+func (p *Parser) ParseAll() (*TemplateNode, []error) {
+	p.recovering = true
+	defer func() { p.recovering = false }()
+
+	template := NewTemplateNode("", 1, 1)
+	for !p.isAtEnd() {
+		if node, _ := p.parseTopLevel(); node != nil {
+			template.Children = append(template.Children, node)
+		}
+	}
+
+	sort.SliceStable(p.recovered, func(i, j int) bool {
+		a, b := p.recovered[i], p.recovered[j]
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+	errs := make([]error, len(p.recovered))
+	for i, r := range p.recovered {
+		errs[i] = r.err
+	}
+	p.recovered = nil
+	return template, errs
+}
+
+// recover records err, returned for the statement starting at the token
+// start, and moves past the statement
+func (p *Parser) recover(start int, err error) {
+	r := recoveredError{err: err, line: p.tokens[start].Line, column: p.tokens[start].Column}
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		r.line, r.column = syntaxErr.Line, syntaxErr.Column
+	}
+	p.recovered = append(p.recovered, r)
+
+	if end := p.blockEnd(start); end > p.current {
+		p.current = end
+		return
+	}
+	p.current = max(p.current, start+1)
+	for !p.isAtEnd() && !p.checkAny(lexer.TokenBlockStart, lexer.TokenBlockStartTrim, lexer.TokenVarStart,
+		lexer.TokenVarStartTrim, lexer.TokenCommentStart, lexer.TokenText) {
+		p.current++
+	}
+}
+
+// blockEnd returns the index of the token after the end tag closing the
+// block tag at start, or -1 if the token at start doesn't begin a block tag
+// or its block isn't closed
+func (p *Parser) blockEnd(start int) int {
+	name := p.tagName(start)
+	if !blockTags[name] {
+		return -1
+	}
+	if name == "set" && p.isInlineSet(start) {
+		return -1
+	}
+
+	depth := 0
+	for i := start; i < len(p.tokens); i++ {
+		switch p.tagName(i) {
+		case name:
+			if name != "set" || !p.isInlineSet(i) {
+				depth++
+			}
+		case "end" + name:
+			if depth--; depth == 0 {
+				for i < len(p.tokens) && !isTagEnd(p.tokens[i]) {
+					i++
+				}
+				return min(i+1, len(p.tokens))
+			}
+		}
+	}
+	return -1
+}
+
+// tagName returns the name of the tag whose {% is the token at i, or ""
+func (p *Parser) tagName(i int) string {
+	if i+1 >= len(p.tokens) {
+		return ""
+	}
+	if t := p.tokens[i].Type; t != lexer.TokenBlockStart && t != lexer.TokenBlockStartTrim {
+		return ""
+	}
+	return p.tokens[i+1].Value
+}
+
+// isInlineSet reports whether the set tag whose {% is the token at i
+// assigns a value, rather than opening a block
+func (p *Parser) isInlineSet(i int) bool {
+	for i++; i < len(p.tokens) && !isTagEnd(p.tokens[i]); i++ {
+		if p.tokens[i].Type == lexer.TokenAssign {
+			return true
+		}
+	}
+	return false
+}
+
+func isTagEnd(token *lexer.Token) bool {
+	return token.Type == lexer.TokenBlockEnd || token.Type == lexer.TokenBlockEndTrim
+}
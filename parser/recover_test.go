@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "no errors",
+			input: "{% for x in xs %}{{ x }}{% endfor %}",
+		},
+		{
+			name:  "expressions",
+			input: "{{ a + }}\n{{ b | }}\n{{ c }}",
+			want:  []string{"at line 1, column 8", "expected filter name after '|' at line 2"},
+		},
+		{
+			name:  "errors in a block keep it open",
+			input: "{% for x in xs %}\n{{ x + }}\n{% if %}y{% endif %}\n{% endfor %}",
+			want:  []string{"at line 2", "at line 3"},
+		},
+		{
+			name:  "block tag errors skip the block",
+			input: "{% if x > %}\n{% endfor %}\n{% if a %}{% endif %}\n{% endif %}\n{% frobnicate %}",
+			want:  []string{"at line 1", "unknown tag 'frobnicate' at line 5"},
+		},
+		{
+			name:  "set block",
+			input: "{% set %}{% set x = 1 %}{% endset %}\n{% set y = %}\n{{ y + }}",
+			want:  []string{"at line 1", "at line 2", "at line 3"},
+		},
+		{
+			name:  "end tag errors",
+			input: "{% with a = 1 %}{% endwith x %}\n{{ a + }}",
+			want:  []string{"unexpected 'x' after endwith", "at line 2"},
+		},
+		{
+			name:  "in source order",
+			input: "{% for x in xs %}\n{{ x + }}",
+			want:  []string{"unclosed 'for' tag, expected '{% endfor %}' at line 1", "at line 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input, nil).Tokenize()
+			if err != nil {
+				t.Fatalf("lexer error: %v", err)
+			}
+			ast, errs := NewParser(tokens).ParseAll()
+			if ast == nil {
+				t.Fatal("expected a template")
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("expected %d errors, got %d: %v", len(tt.want), len(errs), errs)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %d: expected %q in %q", i, want, errs[i])
+				}
+				var syntaxErr *SyntaxError
+				if !errors.As(errs[i], &syntaxErr) || syntaxErr.Line == 0 {
+					t.Errorf("error %d: expected a positioned *SyntaxError, got %#v", i, errs[i])
+				}
+			}
+		})
+	}
+
+	t.Run("keeps what parses", func(t *testing.T) {
+		tokens, _ := lexer.NewLexer("a{{ b + }}{% if c %}d{{ e( }}{% endif %}", nil).Tokenize()
+		ast, errs := NewParser(tokens).ParseAll()
+		if len(errs) != 2 || len(ast.Children) != 2 {
+			t.Fatalf("got %d nodes, errors %v", len(ast.Children), errs)
+		}
+		if ifNode, ok := ast.Children[1].(*IfNode); !ok || len(ifNode.Body) != 1 {
+			t.Errorf("expected the if tag with its text, got %s", ast.Children[1])
+		}
+	})
+
+	t.Run("parse stops at the first error", func(t *testing.T) {
+		tokens, _ := lexer.NewLexer("{{ a + }}\n{{ b + }}", nil).Tokenize()
+		_, err := NewParser(tokens).Parse()
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Line != 1 {
+			t.Errorf("expected the error of line 1, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+// tagMisspellings maps tag names from other template languages to the tag
+// meant by them
+var tagMisspellings = map[string]string{
+	"elsif":   "elif",
+	"elseif":  "elif",
+	"else_if": "elif",
+	"end_if":  "endif",
+	"end_for": "endfor",
+}
+
+// enterTag records the block tag named by token as open until the returned
+// function is called, so that errors can refer to it
+func (p *Parser) enterTag(token *lexer.Token) func() {
+	p.openTags = append(p.openTags, token)
+	return func() {
+		p.openTags = p.openTags[:len(p.openTags)-1]
+	}
+}
+
+// endTagFor returns the name of the tag closing the block tag named by open
+func endTagFor(open *lexer.Token) string {
+	return "end" + open.Value
+}
+
+// SyntaxError is an error in template source found by the parser, at the
+// position of the token it was found at
+type SyntaxError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Message, e.Line, e.Column)
+}
+
+// errorAt is error for a position other than the current token's
+func (p *Parser) errorAt(token *lexer.Token, message string) error {
+	err := &SyntaxError{Message: message, Line: token.Line, Column: token.Column}
+	p.errors = append(p.errors, err.Error())
+	return err
+}
+
+// unclosedTagError reports a block tag still open at the end of the template,
+// at the position of the tag
+func (p *Parser) unclosedTagError(open *lexer.Token) error {
+	return p.errorAt(open, fmt.Sprintf("unclosed '%s' tag, expected '{%% %s %%}'", open.Value, endTagFor(open)))
+}
+
+// expectTagEnd consumes the %} closing the tag named tag, reporting anything
+// else written before it
+func (p *Parser) expectTagEnd(tag string) error {
+	if p.check(lexer.TokenBlockEnd) || p.check(lexer.TokenBlockEndTrim) {
+		p.advance()
+		return nil
+	}
+	if p.isAtEnd() {
+		return p.error(fmt.Sprintf("expected '%%}' after %s", tag))
+	}
+	return p.error(fmt.Sprintf("unexpected '%s' after %s, expected '%%}'", p.peek().Value, tag))
+}
+
+// unknownTagError reports the block tag named by the current token, which no
+// statement is parsed from here. Misspellings of elif and of end tags, and end
+// and middle tags that don't belong to the innermost open block, are
+// explained.
+func (p *Parser) unknownTagError() error {
+	token := p.peek()
+	name := token.Value
+	var open *lexer.Token
+	if len(p.openTags) > 0 {
+		open = p.openTags[len(p.openTags)-1]
+	}
+
+	if suggestion, ok := tagMisspellings[name]; ok {
+		return p.error(fmt.Sprintf("unknown tag '%s', did you mean '%s'?", name, suggestion))
+	}
+
+	switch token.Type {
+	case lexer.TokenIdentifier:
+		if name == "end" {
+			if next := p.peekNext(); isTagWord(next) {
+				return p.error(fmt.Sprintf("unknown tag 'end %s', did you mean 'end%s'?", next.Value, next.Value))
+			}
+			if open != nil {
+				return p.error(fmt.Sprintf("unknown tag 'end', did you mean '%s'?", endTagFor(open)))
+			}
+		}
+		return p.error(fmt.Sprintf("unknown tag '%s'", name))
+
+	case lexer.TokenElif, lexer.TokenElse, lexer.TokenEndif, lexer.TokenEndfor, lexer.TokenEndblock,
+		lexer.TokenEndmacro, lexer.TokenEndcall, lexer.TokenEndSet, lexer.TokenEndwith,
+		lexer.TokenEndfilter, lexer.TokenEndraw, lexer.TokenEndautoescape:
+		msg := fmt.Sprintf("unexpected '%s' outside any block", name)
+		if open != nil {
+			msg = fmt.Sprintf("unexpected '%s' while the '%s' tag from line %d is open, expected '%s'",
+				name, open.Value, open.Line, endTagFor(open))
+		}
+		if token.Type == lexer.TokenEndraw {
+			// Most likely a raw block already ended at an endraw meant as content
+			msg += "; a raw block ends at its first endraw, write {{ '{% endraw %}' }} after the block to output one"
+		}
+		return p.error(msg)
+	}
+
+	return p.error(fmt.Sprintf("unexpected block statement: %s", token.Type))
+}
+
+// isTagWord reports whether token is a word that could follow "end" in a
+// misspelled end tag, such as the if of "end if"
+func isTagWord(token *lexer.Token) bool {
+	if token == nil {
+		return false
+	}
+	switch lexer.LookupKeyword(token.Value) {
+	case lexer.TokenIf, lexer.TokenFor, lexer.TokenBlock, lexer.TokenMacro, lexer.TokenCall, lexer.TokenSet,
+		lexer.TokenWith, lexer.TokenFilter, lexer.TokenRaw, lexer.TokenAutoescape:
+		return true
+	}
+	return token.Value == "cache" || token.Value == "embed"
+}
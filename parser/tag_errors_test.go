@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+func TestParseTagErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "else if",
+			input: "{% if a %}\nA\n{% else if b %}\nB\n{% endif %}\nfooter",
+			want:  "unknown tag 'else if', did you mean 'elif'? at line 3",
+		},
+		{
+			name:  "elsif",
+			input: "{% if a %}\nA\n{% elsif b %}\nB\n{% endif %}\nfooter",
+			want:  "unknown tag 'elsif', did you mean 'elif'? at line 3",
+		},
+		{
+			name:  "elseif",
+			input: "{% for x in xs %}{% if a %}\n{% elseif b %}\n{% endif %}{% endfor %}",
+			want:  "unknown tag 'elseif', did you mean 'elif'? at line 2",
+		},
+		{
+			name:  "end if",
+			input: "{% if a %}\nA\n{% end if %}\nfooter",
+			want:  "unknown tag 'end if', did you mean 'endif'? at line 3",
+		},
+		{
+			name:  "bare end",
+			input: "{% for x in xs %}\n{{ x }}\n{% end %}\nfooter",
+			want:  "unknown tag 'end', did you mean 'endfor'? at line 3",
+		},
+		{
+			name:  "endfor with trailing identifier",
+			input: "{% for x in xs %}\n{{ x }}\n{% endfor x %}\nfooter",
+			want:  "unexpected 'x' after endfor, expected '%}' at line 3",
+		},
+		{
+			name:  "unknown tag inside if",
+			input: "{% if a %}\n{% frobnicate %}\n{% endif %}\nfooter\n",
+			want:  "unknown tag 'frobnicate' at line 2",
+		},
+		{
+			name:  "mismatched end tag",
+			input: "{% if a %}\n{% for x in xs %}\n{% endif %}\n{% endfor %}",
+			want:  "unexpected 'endif' while the 'for' tag from line 2 is open, expected 'endfor' at line 3",
+		},
+		{
+			name:  "stray end tag",
+			input: "text\n{% endif %}",
+			want:  "unexpected 'endif' outside any block at line 2",
+		},
+		{
+			name:  "endraw as raw content",
+			input: "{% raw %}\nclose with {% endraw %}\n{% endraw %}",
+			want:  "unexpected 'endraw' outside any block; a raw block ends at its first endraw, write {{ '{% endraw %}' }} after the block to output one at line 3, column 4",
+		},
+		{
+			name:  "unclosed raw",
+			input: "{% filter upper %}\n  {%- raw %}{% endfilter %}{{ x }}",
+			want:  "unclosed 'raw' tag, expected '{% endraw %}' at line 2, column 7",
+		},
+		{
+			name:  "unclosed if",
+			input: "header\n{% if a %}\nA\n{{ b }}\n",
+			want:  "unclosed 'if' tag, expected '{% endif %}' at line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input, nil).Tokenize()
+			if err != nil {
+				t.Fatalf("lexer error: %v", err)
+			}
+
+			_, err = NewParser(tokens).Parse()
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
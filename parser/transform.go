@@ -0,0 +1,382 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Transform rewrites an AST bottom-up: the children of each node are
+// transformed first, then fn is called with the node and its return value
+// takes the node's place. Returning nil removes a node from a statement list
+// (a template body, an if branch, ...) or clears an optional slot such as a
+// for loop condition. Nodes in expression positions must be replaced with
+// ExpressionNodes. The tree is modified in place; use Clone first to keep the
+// original.
+func Transform(node Node, fn func(Node) (Node, error)) (Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	var err error
+	switch n := node.(type) {
+	case *TemplateNode:
+		n.Children, err = transformList(n.Children, fn)
+	case *VariableNode:
+		n.Expression, err = Transform(n.Expression, fn)
+	case *ListNode:
+		n.Elements, err = transformExprs(n.Elements, fn)
+	case *DictNode:
+		// A pair whose key or value is removed is removed
+		keys, values := n.Keys[:0], n.Values[:0]
+		for i := range n.Keys {
+			var key, value ExpressionNode
+			if key, err = transformExpr(n.Keys[i], fn); err != nil {
+				break
+			}
+			if value, err = transformExpr(n.Values[i], fn); err != nil {
+				break
+			}
+			if key != nil && value != nil {
+				keys, values = append(keys, key), append(values, value)
+			}
+		}
+		n.Keys, n.Values = keys, values
+	case *AttributeNode:
+		n.Object, err = transformExpr(n.Object, fn)
+	case *GetItemNode:
+		if n.Object, err = transformExpr(n.Object, fn); err == nil {
+			n.Key, err = transformExpr(n.Key, fn)
+		}
+	case *FilterNode:
+		if n.Expression, err = transformExpr(n.Expression, fn); err == nil {
+			if n.Arguments, err = transformExprs(n.Arguments, fn); err == nil {
+				err = transformExprMap(n.NamedArgs, fn)
+			}
+		}
+	case *BinaryOpNode:
+		if n.Left, err = transformExpr(n.Left, fn); err == nil {
+			n.Right, err = transformExpr(n.Right, fn)
+		}
+	case *CompareNode:
+		if n.Left, err = transformExpr(n.Left, fn); err == nil {
+			n.Comparators, err = transformExprs(n.Comparators, fn)
+		}
+	case *UnaryOpNode:
+		n.Operand, err = transformExpr(n.Operand, fn)
+	case *IfNode:
+		err = transformIf(n, fn)
+	case *ForNode:
+		if n.Iterable, err = transformExpr(n.Iterable, fn); err == nil {
+			if n.Condition, err = transformExpr(n.Condition, fn); err == nil {
+				if n.Body, err = transformList(n.Body, fn); err == nil {
+					n.Else, err = transformList(n.Else, fn)
+				}
+			}
+		}
+	case *BlockNode:
+		n.Body, err = transformList(n.Body, fn)
+	case *ExtendsNode:
+		n.Template, err = transformExpr(n.Template, fn)
+	case *IncludeNode:
+		if n.Template, err = transformExpr(n.Template, fn); err == nil {
+			if n.Context, err = transformExpr(n.Context, fn); err == nil {
+				err = transformExprMap(n.Assignments, fn)
+			}
+		}
+	case *EmbedNode:
+		if n.Template, err = transformExpr(n.Template, fn); err == nil {
+			if n.Context, err = transformExpr(n.Context, fn); err == nil {
+				if err = transformExprMap(n.Assignments, fn); err == nil {
+					n.Body, err = transformList(n.Body, fn)
+				}
+			}
+		}
+	case *MacroNode:
+		if err = transformExprMap(n.Defaults, fn); err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *SetNode:
+		if n.Targets, err = transformExprs(n.Targets, fn); err == nil {
+			n.Value, err = transformExpr(n.Value, fn)
+		}
+	case *BlockSetNode:
+		n.Body, err = transformList(n.Body, fn)
+	case *CallNode:
+		if n.Function, err = transformExpr(n.Function, fn); err == nil {
+			if n.Arguments, err = transformExprs(n.Arguments, fn); err == nil {
+				err = transformExprMap(n.Keywords, fn)
+			}
+		}
+	case *CallBlockNode:
+		if n.Call, err = transformExpr(n.Call, fn); err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *WithNode:
+		if err = transformExprMap(n.Assignments, fn); err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *TestNode:
+		if n.Expression, err = transformExpr(n.Expression, fn); err == nil {
+			n.Arguments, err = transformExprs(n.Arguments, fn)
+		}
+	case *ConditionalNode:
+		if n.Condition, err = transformExpr(n.Condition, fn); err == nil {
+			if n.TrueExpr, err = transformExpr(n.TrueExpr, fn); err == nil {
+				n.FalseExpr, err = transformExpr(n.FalseExpr, fn)
+			}
+		}
+	case *AssignmentNode:
+		if n.Target, err = transformExpr(n.Target, fn); err == nil {
+			n.Value, err = transformExpr(n.Value, fn)
+		}
+	case *SliceNode:
+		for _, slot := range []*ExpressionNode{&n.Object, &n.Start, &n.End, &n.Step} {
+			if *slot, err = transformExpr(*slot, fn); err != nil {
+				break
+			}
+		}
+	case *ComprehensionNode:
+		for _, slot := range []*ExpressionNode{&n.KeyExpr, &n.Expression, &n.Iterable, &n.Condition} {
+			if *slot, err = transformExpr(*slot, fn); err != nil {
+				break
+			}
+		}
+	case *AutoescapeNode:
+		n.Body, err = transformList(n.Body, fn)
+	case *CacheNode:
+		if n.Key, err = transformExpr(n.Key, fn); err == nil {
+			if n.Timeout, err = transformExpr(n.Timeout, fn); err == nil {
+				n.Body, err = transformList(n.Body, fn)
+			}
+		}
+	case *SwitchNode:
+		err = transformSwitch(n, fn)
+	case *CaseNode:
+		if n.Values, err = transformExprs(n.Values, fn); err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *FilterBlockNode:
+		err = transformFilterChain(n, fn)
+		if err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *ExtensionNode:
+		if n.Arguments, err = transformExprs(n.Arguments, fn); err == nil {
+			n.Body, err = transformList(n.Body, fn)
+		}
+	case *ImportNode:
+		n.Template, err = transformExpr(n.Template, fn)
+	case *FromNode:
+		n.Template, err = transformExpr(n.Template, fn)
+	case *DoNode:
+		n.Expression, err = transformExpr(n.Expression, fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fn(node)
+}
+
+func transformList(nodes []Node, fn func(Node) (Node, error)) ([]Node, error) {
+	kept := nodes[:0]
+	for _, child := range nodes {
+		replaced, err := Transform(child, fn)
+		if err != nil {
+			return nil, err
+		}
+		if replaced != nil {
+			kept = append(kept, replaced)
+		}
+	}
+	return kept, nil
+}
+
+func transformExpr(expr ExpressionNode, fn func(Node) (Node, error)) (ExpressionNode, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	replaced, err := Transform(expr, fn)
+	if err != nil || replaced == nil {
+		return nil, err
+	}
+	result, ok := replaced.(ExpressionNode)
+	if !ok {
+		return nil, fmt.Errorf("cannot replace expression %T with %T at line %d", expr, replaced, expr.Line())
+	}
+	return result, nil
+}
+
+func transformExprs(exprs []ExpressionNode, fn func(Node) (Node, error)) ([]ExpressionNode, error) {
+	kept := exprs[:0]
+	for _, expr := range exprs {
+		replaced, err := transformExpr(expr, fn)
+		if err != nil {
+			return nil, err
+		}
+		if replaced != nil {
+			kept = append(kept, replaced)
+		}
+	}
+	return kept, nil
+}
+
+// transformExprMap transforms map values in key order; nil results delete the key
+func transformExprMap(exprs map[string]ExpressionNode, fn func(Node) (Node, error)) error {
+	keys := make([]string, 0, len(exprs))
+	for key := range exprs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		replaced, err := transformExpr(exprs[key], fn)
+		if err != nil {
+			return err
+		}
+		if replaced == nil {
+			delete(exprs, key)
+		} else {
+			exprs[key] = replaced
+		}
+	}
+	return nil
+}
+
+func transformIf(n *IfNode, fn func(Node) (Node, error)) error {
+	var err error
+	if n.Condition, err = transformExpr(n.Condition, fn); err != nil {
+		return err
+	}
+	if n.Body, err = transformList(n.Body, fn); err != nil {
+		return err
+	}
+
+	kept := n.ElseIfs[:0]
+	for _, elif := range n.ElseIfs {
+		replaced, err := Transform(elif, fn)
+		if err != nil {
+			return err
+		}
+		if replaced == nil {
+			continue
+		}
+		elifNode, ok := replaced.(*IfNode)
+		if !ok {
+			return fmt.Errorf("cannot replace elif branch with %T at line %d", replaced, elif.Line())
+		}
+		kept = append(kept, elifNode)
+	}
+	n.ElseIfs = kept
+
+	n.Else, err = transformList(n.Else, fn)
+	return err
+}
+
+func transformSwitch(n *SwitchNode, fn func(Node) (Node, error)) error {
+	var err error
+	if n.Subject, err = transformExpr(n.Subject, fn); err != nil {
+		return err
+	}
+
+	kept := n.Cases[:0]
+	for _, c := range n.Cases {
+		replaced, err := Transform(c, fn)
+		if err != nil {
+			return err
+		}
+		if replaced == nil {
+			continue
+		}
+		caseNode, ok := replaced.(*CaseNode)
+		if !ok {
+			return fmt.Errorf("cannot replace case branch with %T at line %d", replaced, c.Line())
+		}
+		kept = append(kept, caseNode)
+	}
+	n.Cases = kept
+
+	n.Default, err = transformList(n.Default, fn)
+	return err
+}
+
+// transformFilterChain transforms the filters of a filter block, which are
+// held by value
+func transformFilterChain(n *FilterBlockNode, fn func(Node) (Node, error)) error {
+	kept := n.FilterChain[:0]
+	for i := range n.FilterChain {
+		replaced, err := Transform(&n.FilterChain[i], fn)
+		if err != nil {
+			return err
+		}
+		if replaced == nil {
+			continue
+		}
+		filter, ok := replaced.(*FilterNode)
+		if !ok {
+			return fmt.Errorf("cannot replace filter block filter with %T at line %d", replaced, n.Line())
+		}
+		kept = append(kept, *filter)
+	}
+	n.FilterChain = kept
+	return nil
+}
+
+// Clone returns a deep copy of an AST, so it can be transformed without
+// affecting the original. Node types from outside this package, and values
+// such as extension evaluate functions, are shared rather than copied.
+func Clone(node Node) Node {
+	if node == nil {
+		return nil
+	}
+	return cloneValue(reflect.ValueOf(node)).Interface().(Node)
+}
+
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		clone := reflect.New(v.Type()).Elem()
+		clone.Set(cloneValue(v.Elem()))
+		return clone
+	case reflect.Ptr:
+		if v.IsNil() || encodableNodes[v.Type().Elem().Name()] != v.Type().Elem() {
+			return v
+		}
+		clone := reflect.New(v.Type().Elem())
+		clone.Elem().Set(cloneValue(v.Elem()))
+		return clone
+	case reflect.Struct:
+		clone := reflect.New(v.Type()).Elem()
+		clone.Set(v) // copies unexported fields such as the position
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				clone.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return clone
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		clone := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			clone.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return clone
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		clone := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			clone.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return clone
+	}
+	return v
+}
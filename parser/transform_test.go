@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	ast, err := Parse("t", `{# c #}{% if a %}{# c #}{{ x + 1 }}{% elif b %}B{% endif %}{% filter upper %}y{% endfilter %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Transform(ast, func(n Node) (Node, error) {
+		switch n := n.(type) {
+		case *CommentNode:
+			return nil, nil
+		case *IdentifierNode:
+			if n.Name == "x" {
+				return NewIdentifierNode("y", n.Line(), n.Column()), nil
+			}
+		case *FilterNode:
+			n.FilterName = "lower"
+		}
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Print(result); got != `{% if a %}{{ y + 1 }}{% elif b %}B{% endif %}{% filter lower %}y{% endfilter %}` {
+		t.Errorf("got %s", got)
+	}
+
+	_, err = Transform(ast, func(n Node) (Node, error) {
+		if _, ok := n.(*IdentifierNode); ok {
+			return NewTextNode("not an expression", 1, 1), nil
+		}
+		return n, nil
+	})
+	if err == nil {
+		t.Error("expected an error replacing an expression with a statement")
+	}
+}
+
+func TestClone(t *testing.T) {
+	src := `{% for k, v in data|dictsort if v %}{{ [k, {"a": 1}] }}{% endfor %}{% macro m(x=1) %}{{ x }}{% endmacro %}`
+	ast, err := Parse("t", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := Print(ast)
+	clone := Clone(ast).(*TemplateNode)
+	if Print(clone) != original || clone.Name != "t" {
+		t.Fatalf("clone differs: %s", Print(clone))
+	}
+	if clone.Children[0].Line() != ast.Children[0].Line() || clone.Children[0].Column() != ast.Children[0].Column() {
+		t.Error("clone lost node positions")
+	}
+
+	Transform(clone, func(n Node) (Node, error) {
+		if ident, ok := n.(*IdentifierNode); ok {
+			ident.Name = "changed"
+		}
+		return n, nil
+	})
+	if Print(ast) != original {
+		t.Errorf("modifying the clone changed the original: %s", Print(ast))
+	}
+}
@@ -0,0 +1,201 @@
+package parser
+
+import "sort"
+
+// Walk traverses an AST in depth-first order, calling fn for each node. If fn
+// returns false the children of that node are skipped. Nil nodes are never
+// passed to fn. Map-valued children (named arguments, macro defaults, with
+// assignments) are visited in key order so traversal is deterministic.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	eachChild(node, func(child Node) {
+		Walk(child, fn)
+	})
+}
+
+// Visitor receives callbacks from WalkVisitor. Enter is called before a
+// node's children and returns whether to visit them; Exit is called once the
+// node is done, whether or not its children were skipped.
+type Visitor interface {
+	Enter(node Node) bool
+	Exit(node Node)
+}
+
+// WalkVisitor traverses an AST in the same order as Walk, calling v.Enter
+// and v.Exit around each node
+func WalkVisitor(node Node, v Visitor) {
+	if node == nil {
+		return
+	}
+	if v.Enter(node) {
+		eachChild(node, func(child Node) {
+			WalkVisitor(child, v)
+		})
+	}
+	v.Exit(node)
+}
+
+// eachChild calls fn for each non-nil direct child of node, in source order
+func eachChild(node Node, fn func(Node)) {
+	switch n := node.(type) {
+	case *TemplateNode:
+		walkList(n.Children, fn)
+	case *VariableNode:
+		if n.Expression != nil {
+			fn(n.Expression)
+		}
+	case *ListNode:
+		walkExprs(n.Elements, fn)
+	case *DictNode:
+		for i := range n.Keys {
+			walkExpr(n.Keys[i], fn)
+			walkExpr(n.Values[i], fn)
+		}
+	case *AttributeNode:
+		walkExpr(n.Object, fn)
+	case *GetItemNode:
+		walkExpr(n.Object, fn)
+		walkExpr(n.Key, fn)
+	case *FilterNode:
+		walkExpr(n.Expression, fn)
+		walkExprs(n.Arguments, fn)
+		walkExprMap(n.NamedArgs, fn)
+	case *BinaryOpNode:
+		walkExpr(n.Left, fn)
+		walkExpr(n.Right, fn)
+	case *CompareNode:
+		walkExpr(n.Left, fn)
+		walkExprs(n.Comparators, fn)
+	case *UnaryOpNode:
+		walkExpr(n.Operand, fn)
+	case *IfNode:
+		walkExpr(n.Condition, fn)
+		walkList(n.Body, fn)
+		for _, elif := range n.ElseIfs {
+			if elif != nil {
+				fn(elif)
+			}
+		}
+		walkList(n.Else, fn)
+	case *ForNode:
+		walkExpr(n.Iterable, fn)
+		walkExpr(n.Condition, fn)
+		walkList(n.Body, fn)
+		walkList(n.Else, fn)
+	case *BlockNode:
+		walkList(n.Body, fn)
+	case *ExtendsNode:
+		walkExpr(n.Template, fn)
+	case *IncludeNode:
+		walkExpr(n.Template, fn)
+		walkExpr(n.Context, fn)
+		walkExprMap(n.Assignments, fn)
+	case *EmbedNode:
+		walkExpr(n.Template, fn)
+		walkExpr(n.Context, fn)
+		walkExprMap(n.Assignments, fn)
+		walkList(n.Body, fn)
+	case *MacroNode:
+		walkExprMap(n.Defaults, fn)
+		walkList(n.Body, fn)
+	case *SetNode:
+		walkExprs(n.Targets, fn)
+		walkExpr(n.Value, fn)
+	case *BlockSetNode:
+		walkList(n.Body, fn)
+	case *CallNode:
+		walkExpr(n.Function, fn)
+		walkExprs(n.Arguments, fn)
+		walkExprMap(n.Keywords, fn)
+	case *CallBlockNode:
+		walkExpr(n.Call, fn)
+		walkList(n.Body, fn)
+	case *WithNode:
+		walkExprMap(n.Assignments, fn)
+		walkList(n.Body, fn)
+	case *TestNode:
+		walkExpr(n.Expression, fn)
+		walkExprs(n.Arguments, fn)
+	case *ConditionalNode:
+		walkExpr(n.Condition, fn)
+		walkExpr(n.TrueExpr, fn)
+		walkExpr(n.FalseExpr, fn)
+	case *AssignmentNode:
+		walkExpr(n.Target, fn)
+		walkExpr(n.Value, fn)
+	case *SliceNode:
+		walkExpr(n.Object, fn)
+		walkExpr(n.Start, fn)
+		walkExpr(n.End, fn)
+		walkExpr(n.Step, fn)
+	case *ComprehensionNode:
+		walkExpr(n.KeyExpr, fn)
+		walkExpr(n.Expression, fn)
+		walkExpr(n.Iterable, fn)
+		walkExpr(n.Condition, fn)
+	case *AutoescapeNode:
+		walkList(n.Body, fn)
+	case *CacheNode:
+		walkExpr(n.Key, fn)
+		walkExpr(n.Timeout, fn)
+		walkList(n.Body, fn)
+	case *SwitchNode:
+		walkExpr(n.Subject, fn)
+		for _, c := range n.Cases {
+			if c != nil {
+				fn(c)
+			}
+		}
+		walkList(n.Default, fn)
+	case *CaseNode:
+		walkExprs(n.Values, fn)
+		walkList(n.Body, fn)
+	case *FilterBlockNode:
+		for i := range n.FilterChain {
+			fn(&n.FilterChain[i])
+		}
+		walkList(n.Body, fn)
+	case *ExtensionNode:
+		walkExprs(n.Arguments, fn)
+		walkList(n.Body, fn)
+	case *ImportNode:
+		walkExpr(n.Template, fn)
+	case *FromNode:
+		walkExpr(n.Template, fn)
+	case *DoNode:
+		walkExpr(n.Expression, fn)
+	}
+}
+
+func walkList(nodes []Node, fn func(Node)) {
+	for _, child := range nodes {
+		if child != nil {
+			fn(child)
+		}
+	}
+}
+
+func walkExpr(expr ExpressionNode, fn func(Node)) {
+	if expr != nil {
+		fn(expr)
+	}
+}
+
+func walkExprs(exprs []ExpressionNode, fn func(Node)) {
+	for _, expr := range exprs {
+		walkExpr(expr, fn)
+	}
+}
+
+func walkExprMap(exprs map[string]ExpressionNode, fn func(Node)) {
+	keys := make([]string, 0, len(exprs))
+	for key := range exprs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		walkExpr(exprs[key], fn)
+	}
+}
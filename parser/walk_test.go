@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zipreport/miya/lexer"
+)
+
+func TestWalk(t *testing.T) {
+	input := `{% extends "base.html" %}{% block body %}{% if x %}{% include "a.html" %}{% else %}{{ y | default("z") }}{% endif %}{% endblock %}`
+
+	l := lexer.NewLexer(input, nil)
+	tokens, err := l.Tokenize()
+	if err != nil {
+		t.Fatalf("lexer error: %v", err)
+	}
+	ast, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	t.Run("VisitsNestedNodes", func(t *testing.T) {
+		var literals []string
+		Walk(ast, func(node Node) bool {
+			if lit, ok := node.(*LiteralNode); ok {
+				if s, ok := lit.Value.(string); ok {
+					literals = append(literals, s)
+				}
+			}
+			return true
+		})
+
+		expected := []string{"base.html", "a.html", "z"}
+		if len(literals) != len(expected) {
+			t.Fatalf("expected literals %v, got %v", expected, literals)
+		}
+		for i := range expected {
+			if literals[i] != expected[i] {
+				t.Errorf("expected literals %v, got %v", expected, literals)
+				break
+			}
+		}
+	})
+
+	t.Run("SkipsChildren", func(t *testing.T) {
+		count := 0
+		Walk(ast, func(node Node) bool {
+			count++
+			_, isBlock := node.(*BlockNode)
+			return !isBlock
+		})
+
+		// template, extends, its literal, and the block itself
+		if count != 4 {
+			t.Errorf("expected 4 visited nodes, got %d", count)
+		}
+	})
+}
+
+type recordingVisitor struct {
+	events []string
+}
+
+func (v *recordingVisitor) Enter(node Node) bool {
+	v.events = append(v.events, "enter "+nodeLabel(node))
+	_, isFilter := node.(*FilterNode)
+	return !isFilter
+}
+
+func (v *recordingVisitor) Exit(node Node) {
+	v.events = append(v.events, "exit "+nodeLabel(node))
+}
+
+func nodeLabel(node Node) string {
+	switch n := node.(type) {
+	case *IdentifierNode:
+		return n.Name
+	case *FilterNode:
+		return "|" + n.FilterName
+	default:
+		return strings.TrimPrefix(reflect.TypeOf(node).String(), "*parser.")
+	}
+}
+
+func TestWalkVisitor(t *testing.T) {
+	ast, err := Parse("", `{% if x %}{{ y|upper }}{% endif %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &recordingVisitor{}
+	WalkVisitor(ast, v)
+
+	// the filter's children are skipped but it still gets its exit
+	expected := []string{
+		"enter TemplateNode",
+		"enter IfNode", "enter x", "exit x",
+		"enter VariableNode", "enter |upper", "exit |upper", "exit VariableNode",
+		"exit IfNode",
+		"exit TemplateNode",
+	}
+	if strings.Join(v.events, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("got events\n%v\nwant\n%v", v.events, expected)
+	}
+}
@@ -0,0 +1,206 @@
+package miya
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// PostProcessor transforms rendered output, e.g. to typeset quotes or add
+// anchors to headings. It is given the output as rendered, already escaped
+// when autoescaping is on, and what it returns is written as it is: the
+// processor is responsible for escaping any text it adds.
+type PostProcessor func(rendered string) (string, error)
+
+type templatePostProcessor struct {
+	pattern string
+	fn      PostProcessor
+}
+
+// AddBlockPostProcessor adds a processor applied to the output of every
+// block called blockName, in templates rendered by the environment and those
+// they include or embed. A block's output is processed where it is rendered,
+// so a parent block written into a child's with super() has been processed
+// before the child's is. Processors of the same block apply in the order
+// they were added, an overlay's after its parent's. Add processors before
+// rendering.
+func (e *Environment) AddBlockPostProcessor(blockName string, fn PostProcessor) error {
+	if blockName == "" {
+		return fmt.Errorf("block post-processor requires a block name")
+	}
+	if fn == nil {
+		return fmt.Errorf("block post-processor for %q is nil", blockName)
+	}
+	if e.blockPostProcessors == nil {
+		e.blockPostProcessors = make(map[string][]PostProcessor)
+	}
+	e.blockPostProcessors[blockName] = append(e.blockPostProcessors[blockName], fn)
+	return nil
+}
+
+// AddTemplatePostProcessor adds a processor applied to the output of
+// templates whose name matches pattern, a path.Match pattern such as
+// "emails/*.html", when they are rendered with Render, RenderWith or
+// RenderTo. Templates they include aren't processed on their own. Processors
+// apply in the order they were added, an overlay's after its parent's, and
+// after those of blocks. Add processors before rendering.
+func (e *Environment) AddTemplatePostProcessor(pattern string, fn PostProcessor) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid template post-processor pattern %q: %w", pattern, err)
+	}
+	if fn == nil {
+		return fmt.Errorf("template post-processor for %q is nil", pattern)
+	}
+	e.templatePostProcessors = append(e.templatePostProcessors, templatePostProcessor{pattern, fn})
+	return nil
+}
+
+// environmentChain returns the environment and its parents, outermost first
+func (e *Environment) environmentChain() []*Environment {
+	var chain []*Environment
+	for env := e; env != nil; env = env.parent {
+		chain = append([]*Environment{env}, chain...)
+	}
+	return chain
+}
+
+// blockPostProcessor returns the function the evaluator applies to the
+// output of blocks, nil when neither the environment nor its parents have
+// block processors
+func (e *Environment) blockPostProcessor() runtime.BlockPostProcessor {
+	chain := e.environmentChain()
+	found := false
+	for _, env := range chain {
+		found = found || len(env.blockPostProcessors) > 0
+	}
+	if !found {
+		return nil
+	}
+	return func(name, output string) (string, error) {
+		for _, env := range chain {
+			for _, fn := range env.blockPostProcessors[name] {
+				var err error
+				if output, err = fn(output); err != nil {
+					return "", err
+				}
+			}
+		}
+		return output, nil
+	}
+}
+
+// postProcessTemplate applies the template processors whose pattern matches
+// name to output
+func (e *Environment) postProcessTemplate(name, output string) (string, error) {
+	for _, env := range e.environmentChain() {
+		for _, p := range env.templatePostProcessors {
+			if matched, _ := path.Match(p.pattern, name); !matched {
+				continue
+			}
+			var err error
+			if output, err = p.fn(output); err != nil {
+				return "", fmt.Errorf("post-processor of template %q: %w", name, err)
+			}
+		}
+	}
+	return output, nil
+}
+
+// SmartQuotes is a PostProcessor turning the straight quotes of text into
+// typographic ones: "a" becomes “a”, 'a' becomes ‘a’ and it's becomes it’s.
+// Quotes escaped as &#34;, &quot;, &#39;, &#x27; or &apos; are turned
+// too. Tags and
+// the contents of script, style, pre, code and textarea elements are left as
+// they are, so attribute values and code keep their quotes.
+func SmartQuotes(rendered string) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(rendered))
+	prev := ' ' // last character of text written, deciding opening or closing
+	skipUntil := ""
+	for i := 0; i < len(rendered); {
+		rest := rendered[i:]
+		if rest[0] == '<' {
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				sb.WriteString(rest)
+				break
+			}
+			tag := rest[:end+1]
+			sb.WriteString(tag)
+			i += end + 1
+			name := tagName(tag)
+			switch {
+			case skipUntil != "":
+				if strings.HasPrefix(tag, "</") && name == skipUntil {
+					skipUntil = ""
+				}
+			case !strings.HasPrefix(tag, "</") && !strings.HasSuffix(tag, "/>") && smartQuotesSkipped[name]:
+				skipUntil = name
+			}
+			continue
+		}
+		if skipUntil != "" {
+			sb.WriteByte(rest[0])
+			i++
+			continue
+		}
+
+		double, single := rest[0] == '"', rest[0] == '\''
+		width := 1
+		if rest[0] == '&' {
+			for entity, isDouble := range smartQuoteEntities {
+				if strings.HasPrefix(rest, entity) {
+					double, single, width = isDouble, !isDouble, len(entity)
+					break
+				}
+			}
+		}
+		if !double && !single {
+			r, size := utf8.DecodeRuneInString(rest)
+			sb.WriteString(rest[:size])
+			prev = r
+			i += size
+			continue
+		}
+		opening := unicode.IsSpace(prev) || strings.ContainsRune("([{-—–“‘", prev)
+		switch {
+		case double && opening:
+			prev = '“'
+		case double:
+			prev = '”'
+		case opening:
+			prev = '‘'
+		default:
+			prev = '’'
+		}
+		sb.WriteRune(prev)
+		i += width
+	}
+	return sb.String(), nil
+}
+
+// tagName returns the lowercased name of tag, an HTML tag such as <a href=...>
+// or </a>
+func tagName(tag string) string {
+	name := strings.TrimPrefix(tag[1:len(tag)-1], "/")
+	if end := strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || r == '/' }); end >= 0 {
+		name = name[:end]
+	}
+	return strings.ToLower(name)
+}
+
+// smartQuotesSkipped holds the elements whose contents SmartQuotes leaves
+// as they are
+var smartQuotesSkipped = map[string]bool{
+	"script": true, "style": true, "pre": true, "code": true, "textarea": true,
+}
+
+// smartQuoteEntities maps the escaped forms of quotes to whether they are
+// double quotes
+var smartQuoteEntities = map[string]bool{
+	"&#34;": true, "&quot;": true, "&#39;": false, "&#x27;": false, "&apos;": false,
+}
@@ -0,0 +1,64 @@
+package miya
+
+import "fmt"
+
+// SourcePreprocessor rewrites the source of the template called name before
+// it is parsed, e.g. to strip a front matter block. It returns the source to
+// parse and metadata about the template, which may be nil.
+type SourcePreprocessor func(name, source string) (string, map[string]interface{}, error)
+
+// SetSourcePreprocessor sets a function every template source passes through
+// before it is lexed, however the template is loaded: FromString,
+// GetTemplate, and the templates extends, include and import load. The
+// metadata it returns is available from Template.Metadata, and to templates
+// through WithMetadataVariable. An error fails the template load like a
+// syntax error would. Passing nil removes the preprocessor.
+//
+// While a preprocessor is set, templates are parsed from the source a loader
+// returns rather than by the loader's own parser. Templates encoded with
+// EncodeTemplates are already preprocessed and carry no metadata; don't set
+// the preprocessor on the environment serving the bundle.
+func (e *Environment) SetSourcePreprocessor(preprocessor SourcePreprocessor) error {
+	if e.parent != nil {
+		return fmt.Errorf("a source preprocessor changes parsed templates and must be set on the root environment, not an overlay")
+	}
+
+	e.transformersMutex.Lock()
+	e.sourcePreprocessor = preprocessor
+	e.transformersMutex.Unlock()
+
+	// Templates parsed before must be parsed again from preprocessed source
+	e.ClearCache()
+	e.ClearInheritanceCache()
+	return nil
+}
+
+// preprocessor returns the source preprocessor of the root environment, or
+// nil when none is set
+func (e *Environment) preprocessor() SourcePreprocessor {
+	root := e
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	root.transformersMutex.RLock()
+	defer root.transformersMutex.RUnlock()
+	return root.sourcePreprocessor
+}
+
+// WithMetadataVariable makes the metadata a SourcePreprocessor returns for a
+// template available to it as the variable name while it renders, e.g.
+// {{ page.title }} for name "page". Templates it extends or includes see the
+// same metadata, that of the template being rendered. A variable of that name
+// in the render context takes precedence.
+func WithMetadataVariable(name string) EnvironmentOption {
+	return func(e *Environment) {
+		e.metadataVariable = name
+	}
+}
+
+// Metadata returns the metadata the environment's SourcePreprocessor returned
+// for the template, or nil
+func (t *Template) Metadata() map[string]interface{} {
+	return t.metadata
+}
@@ -0,0 +1,140 @@
+package miya
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BatchOption configures RenderEach and RenderMany
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	workers int
+	render  []RenderOption
+}
+
+// BatchWorkers renders up to n contexts at once, on n goroutines. Results are
+// still emitted in the order of the contexts. Values shared by the contexts,
+// and the filters and functions they call, must then be safe for concurrent
+// use. n below 2 renders one context at a time, the default.
+func BatchWorkers(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.workers = n
+	}
+}
+
+// BatchRenderOptions applies opts to every render of the batch, as
+// RenderWith does to one render
+func BatchRenderOptions(opts ...RenderOption) BatchOption {
+	return func(o *batchOptions) {
+		o.render = append(o.render, opts...)
+	}
+}
+
+// RenderEach renders the template once for every context next returns,
+// until it returns false, and calls emit with each output, or the error of
+// its render, in the order of the contexts. Outputs are the same as Render's.
+// What doesn't depend on the context is worked out once for the whole batch
+// rather than for every render: the render settings, the environment's block
+// post-processors and, for a template extending a parent named by a string,
+// its inheritance chain with the parents' blocks filled in. A template of the
+// chain reloaded during the batch is used from the next batch.
+//
+// next and emit are called on the calling goroutine, one call at a time, also
+// with BatchWorkers: emit may write each output straight to a file or a mail
+// queue. With workers, next is called ahead of emit to keep them busy.
+func (t *Template) RenderEach(next func() (Context, bool), emit func(output string, err error), opts ...BatchOption) {
+	options := &batchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	plan := t.newRenderPlan(t.renderOptions(options.render))
+	plan.resolveAhead()
+
+	if options.workers < 2 {
+		for {
+			context, ok := next()
+			if !ok {
+				return
+			}
+			emit(plan.render(context))
+		}
+	}
+
+	type batchResult struct {
+		output string
+		err    error
+	}
+	type batchJob struct {
+		context Context
+		result  chan batchResult
+	}
+
+	// Jobs are queued for up to two renders per worker ahead of the one
+	// emitted next, so the queue never blocks
+	window := options.workers * 2
+	jobs := make(chan batchJob, window)
+	var wg sync.WaitGroup
+	for i := 0; i < options.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				func() {
+					// A panicking render fails its context rather than the program
+					defer func() {
+						if r := recover(); r != nil {
+							job.result <- batchResult{err: fmt.Errorf("panic during template render: %v", r)}
+						}
+					}()
+					output, err := plan.render(job.context)
+					job.result <- batchResult{output, err}
+				}()
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	var pending []chan batchResult
+	exhausted := false
+	for {
+		for !exhausted && len(pending) < window {
+			context, ok := next()
+			if !ok {
+				exhausted = true
+				break
+			}
+			result := make(chan batchResult, 1)
+			jobs <- batchJob{context, result}
+			pending = append(pending, result)
+		}
+		if len(pending) == 0 {
+			return
+		}
+		result := <-pending[0]
+		pending = pending[1:]
+		emit(result.output, result.err)
+	}
+}
+
+// RenderMany renders the template once for every context, as RenderEach
+// does, and returns the outputs and errors in the order of the contexts
+func (t *Template) RenderMany(contexts []Context, opts ...BatchOption) ([]string, []error) {
+	outputs := make([]string, 0, len(contexts))
+	errs := make([]error, 0, len(contexts))
+	i := 0
+	t.RenderEach(func() (Context, bool) {
+		if i == len(contexts) {
+			return nil, false
+		}
+		i++
+		return contexts[i-1], true
+	}, func(output string, err error) {
+		outputs = append(outputs, output)
+		errs = append(errs, err)
+	}, opts...)
+	return outputs, errs
+}
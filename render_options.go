@@ -0,0 +1,83 @@
+package miya
+
+import (
+	"github.com/zipreport/miya/runtime"
+)
+
+// RenderOption overrides an environment setting for a single render; see
+// Template.RenderWith
+type RenderOption func(*renderOptions)
+
+// renderOptions are the settings of one render, starting from the
+// environment's
+type renderOptions struct {
+	autoEscape        bool
+	escaper           *runtime.AutoEscaper // nil unless an escape context is chosen
+	escapeContext     runtime.EscapeContext
+	undefinedBehavior runtime.UndefinedBehavior
+	finalizer         Finalizer
+}
+
+// newRenderOptions returns the settings of a render of the template called
+// name, read from filename, in env with opts applied
+func newRenderOptions(env *Environment, name, filename string, opts []RenderOption) *renderOptions {
+	if name == stringTemplateName {
+		name = ""
+	}
+	options := &renderOptions{
+		autoEscape:        env.autoescapeFor(name, filename),
+		undefinedBehavior: env.undefinedBehavior,
+		finalizer:         env.finalizer,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.escapeContext != "" {
+		options.escaper = runtime.NewAutoEscaper(&runtime.AutoEscapeConfig{
+			Enabled: options.autoEscape,
+			Context: options.escapeContext,
+		})
+	}
+	return options
+}
+
+// escapesHTML reports whether the render escapes output for HTML, which
+// makes it an HTML document
+func (o *renderOptions) escapesHTML() bool {
+	switch o.escapeContext {
+	case "", runtime.EscapeContextHTML, runtime.EscapeContextXHTML:
+		return o.autoEscape
+	}
+	return false
+}
+
+// RenderAutoescape turns HTML escaping of {{ expression }} output on or off
+func RenderAutoescape(enabled bool) RenderOption {
+	return func(o *renderOptions) {
+		o.autoEscape = enabled
+	}
+}
+
+// RenderEscapeContext escapes output for context instead of HTML, such as
+// EscapeContextJS for values written into a script. It applies where
+// autoescaping is on.
+func RenderEscapeContext(context EscapeContext) RenderOption {
+	return func(o *renderOptions) {
+		o.escapeContext = context
+	}
+}
+
+// RenderUndefinedBehavior sets how undefined variables are handled
+func RenderUndefinedBehavior(behavior UndefinedBehavior) RenderOption {
+	return func(o *renderOptions) {
+		o.undefinedBehavior = behavior
+	}
+}
+
+// RenderFinalizer sets the function applied to every output value; nil
+// disables the environment's finalizer
+func RenderFinalizer(finalizer Finalizer) RenderOption {
+	return func(o *renderOptions) {
+		o.finalizer = finalizer
+	}
+}
@@ -0,0 +1,103 @@
+package miya
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// ErrReservedName is wrapped by the errors of renders given a context
+// variable named like an engine name, with WithReservedNameProtection
+var ErrReservedName = errors.New("reserved name")
+
+// engineVariables are the variables the engine defines in templates: the
+// loop object, super() in blocks, self, and what macros bind besides their
+// parameters
+var engineVariables = []string{"loop", "super", "caller", "self", "varargs", "kwargs"}
+
+// builtinGlobalNames returns the names of the built-in globals, such as
+// range and namespace
+var builtinGlobalNames = sync.OnceValue(func() map[string]bool {
+	env := &Environment{globals: make(map[string]interface{})}
+	registerBuiltinGlobals(env)
+	names := make(map[string]bool, len(env.globals))
+	for name := range env.globals {
+		names[name] = true
+	}
+	return names
+})
+
+// ReservedNames returns the sorted names WithReservedNameProtection keeps
+// render contexts from defining: the engine's variables loop, super, caller,
+// self, varargs and kwargs, and the built-in globals such as range,
+// namespace and dict.
+func ReservedNames() []string {
+	names := slices.Clone(engineVariables)
+	names = append(names, slices.Collect(maps.Keys(builtinGlobalNames()))...)
+	slices.Sort(names)
+	return names
+}
+
+// reservedMeaning describes what name means to the engine, or returns ""
+// for a name free for templates and contexts
+func reservedMeaning(name string) string {
+	if slices.Contains(engineVariables, name) {
+		return fmt.Sprintf("the engine variable %s", name)
+	}
+	if builtinGlobalNames()[name] {
+		return fmt.Sprintf("the built-in global %s", name)
+	}
+	return ""
+}
+
+// WithReservedNameProtection makes renders fail with an error wrapping
+// ErrReservedName when the context passed in defines a name the engine or
+// the template gives a meaning: one of ReservedNames, or a macro the
+// template defines or imports by name. Without it, a context variable
+// silently hides a built-in global of the same name and is hidden by the
+// engine's own variables and the template's macros.
+func WithReservedNameProtection(enabled bool) EnvironmentOption {
+	return func(e *Environment) {
+		e.reservedNameProtection = enabled
+	}
+}
+
+// checkReservedNames returns an error for the first variable of context,
+// in name order, that is reserved or names a macro of ast
+func checkReservedNames(context Context, ast parser.Node, template string) error {
+	keys := context.Keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if meaning := reservedMeaning(key); meaning != "" {
+			return fmt.Errorf("%w: context variable %q of template %q would hide %s", ErrReservedName, key, template, meaning)
+		}
+	}
+
+	macros := make(map[string]bool)
+	parser.Walk(ast, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.MacroNode:
+			macros[n.Name] = true
+		case *parser.FromNode:
+			for _, name := range n.Names {
+				if alias, ok := n.Aliases[name]; ok {
+					name = alias
+				}
+				macros[name] = true
+			}
+		}
+		return true
+	})
+	for _, key := range keys {
+		if macros[key] {
+			return fmt.Errorf("%w: context variable %q of template %q is hidden by the macro of that name", ErrReservedName, key, template)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package runtime
 
 import (
+	"encoding"
 	"fmt"
 	"html"
+	"math"
 	"net/url"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Pre-compiled regex pattern for control character escaping (performance optimization)
@@ -30,10 +34,31 @@ const (
 	EscapeContextURL EscapeContext = "url"
 	// JSON context - escape for JSON strings
 	EscapeContextJSON EscapeContext = "json"
+	// Plain text context - no escaping; the escape filter still escapes HTML
+	EscapeContextText EscapeContext = "text"
 	// None/disabled - no escaping
 	EscapeContextNone EscapeContext = "none"
 )
 
+// escapeContexts are the contexts {% autoescape %} accepts by name, sorted
+var escapeContexts = []EscapeContext{
+	EscapeContextCSS, EscapeContextHTML, EscapeContextJS, EscapeContextJSON, EscapeContextNone,
+	EscapeContextText, EscapeContextURL, EscapeContextXHTML, EscapeContextXML,
+}
+
+// ParseEscapeContext returns the escape context called name, as named by
+// {% autoescape 'js' %}, or an error listing the valid names
+func ParseEscapeContext(name string) (EscapeContext, error) {
+	names := make([]string, len(escapeContexts))
+	for i, context := range escapeContexts {
+		if string(context) == name {
+			return context, nil
+		}
+		names[i] = string(context)
+	}
+	return "", fmt.Errorf("unknown escape context '%s', expected one of: %s", name, strings.Join(names, ", "))
+}
+
 // AutoEscapeConfig holds auto-escape configuration
 type AutoEscapeConfig struct {
 	Enabled    bool
@@ -56,6 +81,8 @@ func DefaultAutoEscapeConfig() *AutoEscapeConfig {
 			".js":    EscapeContextJS,
 			".css":   EscapeContextCSS,
 			".json":  EscapeContextJSON,
+			".txt":   EscapeContextText,
+			".text":  EscapeContextText,
 		},
 		ContextMap: make(map[string]EscapeContext),
 	}
@@ -99,13 +126,13 @@ func (ae *AutoEscaper) DetectContext(templateName string) EscapeContext {
 
 // Escape escapes a value according to the specified context
 func (ae *AutoEscaper) Escape(value interface{}, context EscapeContext) string {
-	if !ae.config.Enabled || context == EscapeContextNone {
+	if !ae.config.Enabled || context == EscapeContextNone || context == EscapeContextText {
 		return ToString(value)
 	}
 
-	// If already a SafeValue, don't escape
-	if safeVal, ok := value.(SafeValue); ok {
-		return ToString(safeVal.Value)
+	// Safe values aren't escaped
+	if content, ok := UnwrapSafe(value); ok {
+		return ToString(content)
 	}
 
 	str := ToString(value)
@@ -219,6 +246,31 @@ func (sv SafeValue) String() string {
 	return ToString(sv.Value)
 }
 
+// SafeString is text that is never auto-escaped. It marks markup rendered
+// from Go code as SafeValue does in templates, and being a string type it can
+// also be the type of a struct field or map value.
+type SafeString string
+
+// SafeWrapper is implemented by safe value types of other packages, such as
+// filters.SafeValue, so the runtime knows them as safe
+type SafeWrapper interface {
+	SafeContent() interface{}
+}
+
+// UnwrapSafe returns what a SafeValue, SafeString or SafeWrapper holds, and
+// whether value is one of them
+func UnwrapSafe(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case SafeValue:
+		return v.Value, true
+	case SafeString:
+		return string(v), true
+	case SafeWrapper:
+		return v.SafeContent(), true
+	}
+	return value, false
+}
+
 // ToString safely converts any value to string
 func ToString(value interface{}) string {
 	if value == nil {
@@ -230,58 +282,102 @@ func ToString(value interface{}) string {
 	if sv, ok := value.(SafeValue); ok {
 		return ToString(sv.Value)
 	}
+	if ss, ok := value.(SafeString); ok {
+		return string(ss)
+	}
 
-	// Special formatting for float numbers
 	switch v := value.(type) {
+	case time.Duration:
+		return FormatDuration(v)
 	case float64:
-		// If the number looks like a currency amount (has decimals or is > 999),
-		// format with comma separators
-		if v >= 999.95 || (v > 0 && v != float64(int64(v))) {
-			return formatCurrencyNumber(v)
-		}
-		// For other floats, if they're whole numbers, format as integers
-		if v == float64(int64(v)) {
-			return fmt.Sprintf("%.0f", v)
-		}
+		return formatFloat(v)
 	case float32:
-		f64 := float64(v)
-		if f64 >= 999.95 || (f64 > 0 && f64 != float64(int64(f64))) {
-			return formatCurrencyNumber(f64)
-		}
-		// For other floats, if they're whole numbers, format as integers
-		if f64 == float64(int64(f64)) {
-			return fmt.Sprintf("%.0f", f64)
-		}
+		// Widen through the shortest decimal form, so 2.1 stays 2.1
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		return formatFloat(f)
+	}
+	if text, ok := TextOf(value); ok {
+		return text
 	}
 
 	return fmt.Sprintf("%v", value)
 }
 
-// formatCurrencyNumber formats a float with comma thousands separators
-func formatCurrencyNumber(value float64) string {
-	// Check if it's a whole number
-	if value == float64(int64(value)) {
-		// Format as integer with commas
-		intVal := int64(value)
-		return formatIntegerWithCommas(intVal)
-	}
-
-	// Format as decimal with commas
-	str := fmt.Sprintf("%.2f", value)
-	parts := strings.Split(str, ".")
-	integerPart := parts[0]
-	decimalPart := parts[1]
+var (
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	errorType         = reflect.TypeOf((*error)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
 
-	// Add commas to integer part
-	formattedInteger := formatIntegerPartWithCommas(integerPart)
+// TextOf returns the text a Go value gives for itself: that of its String
+// method, or else its Error method, or else its MarshalText method. Methods
+// with pointer receivers are called on a copy of values that aren't
+// pointers, so an ID type whose String takes *ID outputs the same whether
+// it's set as a value or a pointer. ok is false for values without any of
+// these methods, for nil pointers, and when MarshalText fails.
+func TextOf(value interface{}) (text string, ok bool) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return "", false
+	}
 
-	// Remove trailing zeros from decimal part, but keep at least original precision
-	decimalPart = strings.TrimRight(decimalPart, "0")
-	if decimalPart == "" {
-		return formattedInteger
+	switch v := value.(type) {
+	case fmt.Stringer:
+		return v.String(), true
+	case error:
+		return v.Error(), true
+	case encoding.TextMarshaler:
+		if text, err := v.MarshalText(); err == nil {
+			return string(text), true
+		}
+		return "", false
 	}
 
-	return formattedInteger + "." + decimalPart
+	// Predeclared types such as int have no methods
+	t := rv.Type()
+	if rv.Kind() == reflect.Ptr || (t.Name() != "" && t.PkgPath() == "") {
+		return "", false
+	}
+	ptrType := reflect.PointerTo(t)
+	if !ptrType.Implements(stringerType) && !ptrType.Implements(errorType) && !ptrType.Implements(textMarshalerType) {
+		return "", false
+	}
+	ptr := reflect.New(t)
+	ptr.Elem().Set(rv)
+	return TextOf(ptr.Interface())
+}
+
+// floatDisplayDigits is the number of significant digits floats with a
+// fraction are output with. Doubles hold 15 digits exactly, so this hides the
+// binary rounding error of results like 0.1 + 0.2 without changing any
+// number a template writes or computes in decimal; the string filter gives
+// the value in full.
+const floatDisplayDigits = 15
+
+// formatFloat formats f for output. Whole numbers, such as the sum of two
+// ids or timestamps, are written exactly without a decimal point, and others
+// rounded to floatDisplayDigits significant digits. Numbers from 1e16 up or
+// below 1e-4 are written with an exponent, as Python writes them. Positive
+// numbers get comma thousands separators, so prices read as 1,499.99.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Sprintf("%v", f)
+	}
+	if f != math.Trunc(f) {
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', floatDisplayDigits, 64), 64)
+	}
+	if abs := math.Abs(f); abs >= 1e16 || (abs < 1e-4 && abs != 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	str := strconv.FormatFloat(f, 'f', -1, 64)
+	if f <= 0 {
+		return str
+	}
+	integerPart, decimalPart, hasDecimals := strings.Cut(str, ".")
+	if !hasDecimals {
+		return formatIntegerPartWithCommas(integerPart)
+	}
+	return formatIntegerPartWithCommas(integerPart) + "." + decimalPart
 }
 
 // formatIntegerWithCommas formats an integer with comma separators
@@ -400,6 +496,12 @@ func (ca *ContextAdapter) GetVariable(key string) (interface{}, bool) {
 	return nil, false
 }
 
+// HasVariable reports whether the adapted context has a variable
+func (ca *ContextAdapter) HasVariable(key string) bool {
+	_, ok := ca.GetVariable(key)
+	return ok
+}
+
 // SetVariable sets a variable in the adapted context
 func (ca *ContextAdapter) SetVariable(key string, value interface{}) {
 	// Use reflection to call Set on the wrapped context
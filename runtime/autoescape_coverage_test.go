@@ -62,7 +62,9 @@ func TestDetectContext(t *testing.T) {
 		{"template.js", EscapeContextJS},
 		{"template.css", EscapeContextCSS},
 		{"template.json", EscapeContextJSON},
-		{"template.txt", EscapeContextHTML}, // Falls back to default
+		{"template.txt", EscapeContextText},
+		{"email.TEXT", EscapeContextText},
+		{"template.md", EscapeContextHTML}, // Falls back to default
 	}
 
 	for _, tt := range tests {
@@ -170,6 +172,13 @@ func TestEscape(t *testing.T) {
 		}
 	})
 
+	t.Run("TextEscape", func(t *testing.T) {
+		original := "Tom & Jerry <tom@example.com>"
+		if result := escaper.Escape(original, EscapeContextText); result != original {
+			t.Errorf("Text context should not escape, got %q", result)
+		}
+	})
+
 	t.Run("DefaultEscape", func(t *testing.T) {
 		result := escaper.Escape("<test>", EscapeContext("unknown"))
 		if strings.Contains(result, "<test>") {
@@ -304,6 +313,14 @@ func TestToStringWithVariousTypes(t *testing.T) {
 		{"string", "string"},
 		{123, "123"},
 		{45.67, "45.67"},
+		{0.1 + 0.2, "0.3"},
+		{1.0 / 3.0, "0.333333333333333"},
+		{123456789012346.0, "123,456,789,012,346"},
+		{1234567890123.45, "1,234,567,890,123.45"},
+		{3600.0, "3,600"},
+		{1234567.5, "1,234,567.5"},
+		{-0.00001, "-1e-05"},
+		{float32(2.1), "2.1"},
 		{true, "true"},
 		{false, "false"},
 		{nil, ""},
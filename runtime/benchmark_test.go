@@ -0,0 +1,167 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zipreport/miya/parser"
+)
+
+type benchUser struct {
+	Name   string
+	Email  string
+	Active bool
+}
+
+// benchTemplate is a representative template with the variables it renders
+type benchTemplate struct {
+	name   string
+	source string
+	vars   func() map[string]interface{}
+}
+
+var benchTemplates = []benchTemplate{
+	{
+		name:   "LargeLoop",
+		source: `<table>{% for u in users %}<tr class="{{ loop.cycle('odd', 'even') }}"><td>{{ loop.index }}</td><td>{{ u.Name }}</td><td>{{ u.Email }}</td>{% if u.Active %}<td>active</td>{% endif %}</tr>{% endfor %}</table>`,
+		vars: func() map[string]interface{} {
+			users := make([]benchUser, 1000)
+			for i := range users {
+				users[i] = benchUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Active: i%3 == 0}
+			}
+			return map[string]interface{}{"users": users}
+		},
+	},
+	{
+		name:   "FilterChains",
+		source: `{% for w in words %}{{ w|trim|lower|capitalize }}:{{ w|upper|trim|length }}:{{ fallback|default(w)|upper }} {% endfor %}`,
+		vars: func() map[string]interface{} {
+			words := make([]interface{}, 200)
+			for i := range words {
+				words[i] = fmt.Sprintf("  Word%d Value  ", i)
+			}
+			return map[string]interface{}{"words": words}
+		},
+	},
+	{
+		name: "MacroForm",
+		source: `{% macro input(name, value="", type="text") %}<input type="{{ type }}" name="{{ name }}" value="{{ value }}">{% endmacro %}` +
+			`{% macro field(label, name, value="") %}<label>{{ label }}</label>{{ input(name, value) }}{% endmacro %}` +
+			`<form>{% for f in fields %}{{ field(f.label, f.name, f.value) }}{% endfor %}{{ input("submit", "Save", "submit") }}</form>`,
+		vars: func() map[string]interface{} {
+			fields := make([]interface{}, 50)
+			for i := range fields {
+				fields[i] = map[string]interface{}{"label": fmt.Sprintf("Field %d", i), "name": fmt.Sprintf("f%d", i), "value": i}
+			}
+			return map[string]interface{}{"fields": fields}
+		},
+	},
+}
+
+// BenchmarkEvaluators renders the same templates with DefaultEvaluator,
+// OptimizedEvaluator, CachedEvaluator and OptimizedFilterEvaluator
+func BenchmarkEvaluators(b *testing.B) {
+	for _, tt := range benchTemplates {
+		ast, err := parser.Parse(tt.name, tt.source)
+		if err != nil {
+			b.Fatal(err)
+		}
+		vars := tt.vars()
+
+		evaluators := []struct {
+			name string
+			eval func(ctx Context) (interface{}, error)
+		}{
+			{"Default", func(ctx Context) (interface{}, error) {
+				return NewEvaluator().EvalNode(ast, ctx)
+			}},
+			{"Optimized", func(ctx Context) (interface{}, error) {
+				return NewOptimizedEvaluator().EvalTemplateNodeOptimized(ast, ctx)
+			}},
+			{"Cached", func(ctx Context) (interface{}, error) {
+				return NewCachedEvaluator().EvalNodeCached(ast, ctx)
+			}},
+			{"OptimizedFilter", func(ctx Context) (interface{}, error) {
+				return NewOptimizedFilterEvaluator().EvalNode(ast, ctx)
+			}},
+		}
+		for _, ev := range evaluators {
+			b.Run(tt.name+"/"+ev.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					ctx := &simpleContext{variables: make(map[string]interface{}, len(vars))}
+					for k, v := range vars {
+						ctx.variables[k] = v
+					}
+					if _, err := ev.eval(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestEvaluatorsAgree checks that the benchmarked evaluators render the
+// benchmark templates identically
+func TestEvaluatorsAgree(t *testing.T) {
+	for _, tt := range benchTemplates {
+		ast, err := parser.Parse(tt.name, tt.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newCtx := func() Context {
+			return &simpleContext{variables: tt.vars()}
+		}
+
+		want, err := NewEvaluator().EvalNode(ast, newCtx())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		optimized, err := NewOptimizedEvaluator().EvalTemplateNodeOptimized(ast, newCtx())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cached, err := NewCachedEvaluator().EvalNodeCached(ast, newCtx())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ToString(want) != optimized || ToString(want) != ToString(cached) {
+			t.Errorf("%s: evaluators disagree", tt.name)
+		}
+	}
+}
+
+// TestLoopInfoNotShared checks that a loop object kept past its loop isn't
+// reused by later loops or renders
+func TestLoopInfoNotShared(t *testing.T) {
+	ast, err := parser.Parse("loops", `{% for x in first %}{{ keep(loop) }}{% endfor %}{% for y in second %}{{ y }}{% endfor %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(first, second []interface{}) map[string]interface{} {
+		var kept map[string]interface{}
+		ctx := &simpleContext{variables: map[string]interface{}{
+			"first":  first,
+			"second": second,
+			"keep": func(loop map[string]interface{}) string {
+				kept = loop
+				return ""
+			},
+		}}
+		if _, err := NewEvaluator().EvalNode(ast, ctx); err != nil {
+			t.Fatal(err)
+		}
+		return kept
+	}
+
+	first := render([]interface{}{"a", "b"}, []interface{}{"secret", "c"})
+	second := render([]interface{}{"x"}, []interface{}{"y", "z"})
+	if first["index"] != 2 || first["previtem"] != "a" || first["last"] != true {
+		t.Errorf("first render's loop = index %v, previtem %v, last %v; want 2, a, true", first["index"], first["previtem"], first["last"])
+	}
+	if second["index"] != 1 || second["previtem"] != nil || second["length"] != 1 {
+		t.Errorf("second render's loop = index %v, previtem %v, length %v; want 1, nil, 1", second["index"], second["previtem"], second["length"])
+	}
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CallFrame is a template, macro call or include that was running when a
+// RuntimeError occurred
+type CallFrame struct {
+	Kind     string // "template" for the rendered template, "macro" or "include"
+	Name     string // Macro name, or template name for the other kinds
+	Template string // Template holding the body being run, if known
+	CallLine int    // Line of the call or include in the calling template, 0 if unknown
+}
+
+// Call frame kinds
+const (
+	FrameTemplate = "template"
+	FrameMacro    = "macro"
+	FrameInclude  = "include"
+)
+
+// maxCallStackEntries bounds the entries a call stack is printed with; the
+// outermost frames beyond it are elided
+const maxCallStackEntries = 10
+
+// SetTemplateName starts the call stack of a render of the template called name
+func (e *DefaultEvaluator) SetTemplateName(name string) {
+	e.callStack = append(e.callStack[:0], CallFrame{Kind: FrameTemplate, Name: name, Template: name})
+	e.depth = 0
+}
+
+// pushFrame records a macro call or include made at callLine until the
+// returned function is called
+func (e *DefaultEvaluator) pushFrame(kind, name, template string, callLine int) func() {
+	e.callStack = append(e.callStack, CallFrame{Kind: kind, Name: name, Template: template, CallLine: callLine})
+	return func() {
+		e.callStack = e.callStack[:len(e.callStack)-1]
+	}
+}
+
+// currentTemplate returns the name of the template whose body is running, or
+// "" if unknown
+func (e *DefaultEvaluator) currentTemplate() string {
+	if len(e.callStack) == 0 {
+		return ""
+	}
+	return e.callStack[len(e.callStack)-1].Template
+}
+
+// attachCallStack gives the RuntimeError in err the current call stack,
+// unless a frame nearer the error already did
+func (e *DefaultEvaluator) attachCallStack(err error) {
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) && runtimeErr.CallStack == nil {
+		runtimeErr.CallStack = slices.Clone(e.callStack)
+	}
+}
+
+// CallStackTrace describes the frames the error occurred in, innermost first,
+// such as "in macro 'input' (forms.html:3), called from template
+// register.html:7". Recursion through the same macro is collapsed. It is ""
+// for errors outside macros and includes.
+func (re *RuntimeError) CallStackTrace() string {
+	if len(re.CallStack) == 0 || (len(re.CallStack) == 1 && re.CallStack[0].Kind == FrameTemplate) {
+		return ""
+	}
+
+	var entries []string
+	line := re.Line
+	var inner *CallFrame
+	for i := len(re.CallStack) - 1; i >= 0; {
+		frame := &re.CallStack[i]
+
+		// A run of the same macro calling itself shows as its innermost frame
+		first := i
+		for first > 0 && sameFrame(re.CallStack[first-1], *frame) {
+			first--
+		}
+		if i-first < 2 {
+			first = i
+		}
+
+		entries = append(entries, describeFrame(frame, inner, line))
+		if i > first {
+			entries = append(entries, fmt.Sprintf("... %d recursive frames ...", i-first))
+		}
+		line = re.CallStack[first].CallLine
+		inner = &re.CallStack[first]
+		i = first - 1
+	}
+
+	if len(entries) > maxCallStackEntries {
+		elided := len(entries) - maxCallStackEntries + 1
+		entries = append(entries[:maxCallStackEntries-2:maxCallStackEntries-2],
+			fmt.Sprintf("... %d more frames ...", elided), entries[len(entries)-1])
+	}
+	return strings.Join(entries, ", ")
+}
+
+// sameFrame reports whether a and b run the same macro or template
+func sameFrame(a, b CallFrame) bool {
+	return a.Kind == b.Kind && a.Name == b.Name && a.Template == b.Template
+}
+
+// describeFrame describes frame at line, as called from the frame inner, or
+// as the frame the error occurred in when inner is nil
+func describeFrame(frame, inner *CallFrame, line int) string {
+	var sb strings.Builder
+	switch {
+	case inner == nil:
+		sb.WriteString("in ")
+	case inner.Kind == FrameInclude:
+		sb.WriteString("included from ")
+	default:
+		sb.WriteString("called from ")
+	}
+
+	location := frame.Template
+	if location != "" && line > 0 {
+		location = fmt.Sprintf("%s:%d", location, line)
+	}
+
+	if frame.Kind == FrameMacro {
+		if inner == nil {
+			sb.WriteString("macro ")
+		}
+		sb.WriteString(fmt.Sprintf("'%s'", frame.Name))
+		if location != "" {
+			sb.WriteString(fmt.Sprintf(" (%s)", location))
+		}
+		return sb.String()
+	}
+
+	sb.WriteString("template")
+	if location != "" {
+		sb.WriteString(" " + location)
+	}
+	return sb.String()
+}
@@ -0,0 +1,148 @@
+package runtime
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// ChildContentPolicy selects what happens to output a child template places
+// outside its blocks. Inheritance renders the root template, so such content
+// never appears.
+type ChildContentPolicy int
+
+const (
+	// ChildContentIgnore discards the content silently, like Jinja2 (default)
+	ChildContentIgnore ChildContentPolicy = iota
+	// ChildContentWarn reports each piece of content as a TemplateWarning
+	ChildContentWarn
+	// ChildContentError fails the inheritance resolution with an OrphanedContentError
+	ChildContentError
+)
+
+// TemplateWarning is a problem in a template that doesn't stop it rendering
+type TemplateWarning struct {
+	Template string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (w TemplateWarning) String() string {
+	return w.Message
+}
+
+// WarningHandler receives template warnings
+type WarningHandler func(TemplateWarning)
+
+// maxOrphanedSnippet is how much of the orphaned content errors quote
+const maxOrphanedSnippet = 40
+
+// SetChildContentPolicy sets how content outside the blocks of child templates
+// is treated when an inheritance hierarchy is built. Warnings go to warn.
+func (p *InheritanceProcessor) SetChildContentPolicy(policy ChildContentPolicy, warn WarningHandler) {
+	p.childContentPolicy = policy
+	p.warningHandler = warn
+}
+
+// checkChildContent applies the child content policy to a template that
+// extends another
+func (p *InheritanceProcessor) checkChildContent(name string, ast *parser.TemplateNode) error {
+	if p.childContentPolicy == ChildContentIgnore {
+		return nil
+	}
+
+	for _, node := range findOrphanedContent(ast.Children, nil) {
+		orphanErr := newOrphanedContentError(name, node)
+		if p.childContentPolicy == ChildContentError {
+			return orphanErr
+		}
+		if p.warningHandler != nil {
+			p.warningHandler(TemplateWarning{
+				Template: name,
+				Line:     orphanErr.Line,
+				Column:   orphanErr.Column,
+				Message:  orphanErr.Error(),
+			})
+		}
+	}
+	return nil
+}
+
+// findOrphanedContent appends to found the nodes among nodes that produce
+// output outside any block. Whitespace, comments and statements that only
+// define things, such as set, import and macro, are not output.
+func findOrphanedContent(nodes []parser.Node, found []parser.Node) []parser.Node {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *parser.TextNode:
+			if strings.TrimSpace(n.Content) != "" {
+				found = append(found, n)
+			}
+		case *parser.RawNode:
+			if strings.TrimSpace(n.Content) != "" {
+				found = append(found, n)
+			}
+		case *parser.VariableNode, *parser.IncludeNode, *parser.EmbedNode, *parser.CallBlockNode, *parser.FilterBlockNode:
+			found = append(found, n)
+		case *parser.IfNode:
+			found = findOrphanedContent(n.Body, found)
+			for _, elif := range n.ElseIfs {
+				found = findOrphanedContent(elif.Body, found)
+			}
+			found = findOrphanedContent(n.Else, found)
+		case *parser.ForNode:
+			found = findOrphanedContent(n.Body, found)
+			found = findOrphanedContent(n.Else, found)
+		case *parser.SwitchNode:
+			for _, c := range n.Cases {
+				found = findOrphanedContent(c.Body, found)
+			}
+			found = findOrphanedContent(n.Default, found)
+		case *parser.WithNode:
+			found = findOrphanedContent(n.Body, found)
+		case *parser.AutoescapeNode:
+			found = findOrphanedContent(n.Body, found)
+		}
+	}
+	return found
+}
+
+// newOrphanedContentError describes node, quoting the start of its source
+func newOrphanedContentError(name string, node parser.Node) *OrphanedContentError {
+	snippet := strings.TrimSpace(parser.Print(node))
+	if i := strings.IndexByte(snippet, '\n'); i >= 0 {
+		snippet = snippet[:i] + "..."
+	}
+	if runes := []rune(snippet); len(runes) > maxOrphanedSnippet {
+		snippet = string(runes[:maxOrphanedSnippet]) + "..."
+	}
+	line, column := node.Line(), node.Column()
+	if text, ok := node.(*parser.TextNode); ok {
+		line, column = skipLeadingSpace(text.Content, line, column)
+	}
+	return &OrphanedContentError{
+		Template: name,
+		Line:     line,
+		Column:   column,
+		Content:  snippet,
+	}
+}
+
+// skipLeadingSpace returns the position of the first character of content
+// that isn't whitespace, given that content starts at line and column
+func skipLeadingSpace(content string, line, column int) (int, int) {
+	for _, r := range content {
+		switch {
+		case r == '\n':
+			line++
+			column = 1
+		case unicode.IsSpace(r):
+			column++
+		default:
+			return line, column
+		}
+	}
+	return line, column
+}
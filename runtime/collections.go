@@ -0,0 +1,71 @@
+package runtime
+
+// Lener is a collection that reports its size, such as a result set or a page
+// of records wrapping a slice. The length filter uses it, and an empty
+// collection is false in conditions.
+type Lener interface {
+	Len() int
+}
+
+// Indexer is a collection whose items are read by position. A Lener that is
+// also an Indexer can be looped over, subscripted as page[0] or page[-1],
+// and used with the sequence filters.
+type Indexer interface {
+	Index(i int) interface{}
+}
+
+// Iterable is a collection that lists its items in order, calling yield for
+// each until it returns false, like an iter.Seq. It can be looped over and
+// used with the sequence filters; without Len, its length is found by
+// counting the items.
+type Iterable interface {
+	Iterate(yield func(item interface{}) bool)
+}
+
+// CollectionItems returns the items of an Iterable, or of a value that is
+// both a Lener and an Indexer, and whether value is such a collection
+func CollectionItems(value interface{}) ([]interface{}, bool) {
+	switch c := value.(type) {
+	case Iterable:
+		var items []interface{}
+		if l, ok := value.(Lener); ok {
+			items = make([]interface{}, 0, l.Len())
+		}
+		c.Iterate(func(item interface{}) bool {
+			items = append(items, item)
+			return true
+		})
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items, true
+	case Indexer:
+		l, ok := value.(Lener)
+		if !ok {
+			return nil, false
+		}
+		items := make([]interface{}, l.Len())
+		for i := range items {
+			items[i] = c.Index(i)
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// CollectionLen returns the length of a Lener or an Iterable, and whether
+// value is one
+func CollectionLen(value interface{}) (int, bool) {
+	switch c := value.(type) {
+	case Lener:
+		return c.Len(), true
+	case Iterable:
+		n := 0
+		c.Iterate(func(interface{}) bool {
+			n++
+			return true
+		})
+		return n, true
+	}
+	return 0, false
+}
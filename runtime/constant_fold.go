@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"github.com/zipreport/miya/parser"
+)
+
+// FoldConstants replaces operator expressions whose operands are all
+// literals, such as 60 * 60 or "v" ~ 2, with a literal of their value, so
+// they're computed once when a template is loaded instead of on every render.
+// Only operators are folded: filters, tests and function calls may be impure
+// or differ between environments. Repetition of strings, such as "x" * 5000,
+// is left to the render too, where the memory limit and the sandbox bound
+// it. Expressions that fail, like 1 / 0, and
+// those whose value isn't a string, number or boolean are left for the
+// render to evaluate. Folding uses strict undefined handling, whose operators
+// accept no more than the other modes', so a folded value is the one any
+// environment would compute. The tree is modified in place.
+func FoldConstants(node parser.Node) (parser.Node, error) {
+	evaluator := NewStrictEvaluator()
+	ctx := &simpleContext{variables: map[string]interface{}{}}
+
+	return parser.Transform(node, func(n parser.Node) (parser.Node, error) {
+		if !isConstantExpression(n) {
+			return n, nil
+		}
+		value, err := evaluator.EvalNode(n, ctx)
+		if err != nil {
+			return n, nil
+		}
+		switch value.(type) {
+		case string, bool, int, int64, float64:
+			return parser.NewLiteralNode(value, "", n.Line(), n.Column()), nil
+		}
+		return n, nil
+	})
+}
+
+// HasConstantExpressions reports whether FoldConstants may change node
+func HasConstantExpressions(node parser.Node) bool {
+	found := false
+	parser.Walk(node, func(n parser.Node) bool {
+		found = found || isConstantExpression(n)
+		return !found
+	})
+	return found
+}
+
+// isConstantExpression reports whether n is an operator expression whose
+// operands are all literals
+func isConstantExpression(n parser.Node) bool {
+	switch n := n.(type) {
+	case *parser.BinaryOpNode:
+		if n.Operator == "*" && (isRepeatable(n.Left) || isRepeatable(n.Right)) {
+			return false
+		}
+		return isLiteral(n.Left) && isLiteral(n.Right)
+	case *parser.CompareNode:
+		for _, comparator := range n.Comparators {
+			if !isLiteral(comparator) {
+				return false
+			}
+		}
+		return isLiteral(n.Left)
+	case *parser.UnaryOpNode:
+		return isLiteral(n.Operand)
+	case *parser.ConditionalNode:
+		return isLiteral(n.Condition) && isLiteral(n.TrueExpr) && isLiteral(n.FalseExpr)
+	}
+	return false
+}
+
+func isLiteral(n parser.ExpressionNode) bool {
+	_, ok := n.(*parser.LiteralNode)
+	return ok
+}
+
+// isRepeatable reports whether n is a string or sequence literal, which *
+// repeats
+func isRepeatable(n parser.ExpressionNode) bool {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		switch n.Value.(type) {
+		case string, []interface{}:
+			return true
+		}
+	case *parser.ListNode:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/zipreport/miya/parser"
+)
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		folded bool
+	}{
+		{"arithmetic", `{{ 60 * 60 * 24 }}`, true},
+		{"concat", `{{ "a" ~ 1 ~ "b" }}`, true},
+		{"comparison", `{% if 2 > 1 %}yes{% endif %}`, true},
+		{"unary", `{{ not false }}`, true},
+		{"conditional", `{{ "a" if 1 else "b" }}`, true},
+		{"variable operand", `{{ x * 2 }}`, false},
+		{"filter", `{{ "a"|upper }}`, false},
+		{"division by zero", `{{ 1 / 0 }}`, false},
+		{"mixed add", `{{ "hello" + 42 }}`, false},
+		{"string repetition", `{{ "ab" * 3 }}`, false},
+		{"repeated string", `{{ 3 * "ab" }}`, false},
+		{"nested repetition", `{{ ("x" * 4000000)|length }}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := parser.Parse(tt.name, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.folded && !HasConstantExpressions(ast) {
+				t.Error("HasConstantExpressions = false, want true")
+			}
+
+			original, _ := parser.Parse(tt.name, tt.source)
+			if _, err := FoldConstants(ast); err != nil {
+				t.Fatal(err)
+			}
+			if folded := parser.Print(ast) != parser.Print(original); folded != tt.folded {
+				t.Errorf("folded = %v, want %v: %s", folded, tt.folded, parser.Print(ast))
+			}
+
+			ctx := func() Context { return &simpleContext{variables: map[string]interface{}{"x": 3}} }
+			want, wantErr := NewEvaluator().EvalNode(original, ctx())
+			got, err := NewEvaluator().EvalNode(ast, ctx())
+			if (err != nil) != (wantErr != nil) || ToString(got) != ToString(want) {
+				t.Errorf("folded template renders %q (%v), want %q (%v)", ToString(got), err, ToString(want), wantErr)
+			}
+		})
+	}
+}
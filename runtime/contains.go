@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ContainerContains is implemented by Go types that decide for themselves
+// what the in operator, and the in and contains tests, find in them
+type ContainerContains interface {
+	Contains(v interface{}) bool
+}
+
+// Contains implements item in container with Python semantics: a string
+// contains its substrings, a map its keys and a slice or array the elements
+// equal to item, numbers comparing by value as with ==. Types implementing
+// ContainerContains answer for themselves. A nil container contains nothing.
+// An undefined operand makes the test false, or an error when the undefined
+// value is strict.
+func Contains(container, item interface{}) (bool, error) {
+	for _, operand := range [2]interface{}{container, item} {
+		if undefined, ok := operand.(*Undefined); ok {
+			if undefined.Behavior == UndefinedStrict {
+				return false, undefined.Error()
+			}
+			return false, nil
+		}
+	}
+
+	if c, ok := container.(ContainerContains); ok {
+		return c.Contains(item), nil
+	}
+	if sv, ok := container.(SafeValue); ok {
+		container = ToString(sv.Value)
+	}
+	if ss, ok := container.(SafeString); ok {
+		container = string(ss)
+	}
+	if sv, ok := item.(SafeValue); ok {
+		if _, ok := sv.Value.(string); ok {
+			item = sv.Value
+		}
+	}
+	if ss, ok := item.(SafeString); ok {
+		item = string(ss)
+	}
+
+	switch v := container.(type) {
+	case nil:
+		return false, nil
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires a string as left operand, not %T", item)
+		}
+		return strings.Contains(v, s), nil
+	case []interface{}:
+		for _, elem := range v {
+			if valuesEqual(elem, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		s, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := v[s]
+		return found, nil
+	}
+
+	rv := reflect.ValueOf(container)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if valuesEqual(rv.Index(i).Interface(), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		keyType := rv.Type().Key()
+		if iv := reflect.ValueOf(item); iv.IsValid() && iv.Type().AssignableTo(keyType) {
+			if found := rv.MapIndex(iv).IsValid(); found || keyType.Kind() != reflect.Interface {
+				return found, nil
+			}
+		}
+		// Keys of another type may still be equal, such as int64(1) and 1
+		iter := rv.MapRange()
+		for iter.Next() {
+			if valuesEqual(iter.Key().Interface(), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("cannot check containment in %T", container)
+}
@@ -93,7 +93,8 @@ func (cf *ControlFlowEvaluator) EvalForLoop(node *parser.ForNode, ctx Context) (
 		return "", fmt.Errorf("error making iterable: %w", err)
 	}
 
-	// Handle empty iteration - execute else clause if present
+	// Handle empty iteration - execute else clause if present. A loop that
+	// has iterated never runs it, even when it breaks.
 	if len(items) == 0 && len(node.Else) > 0 {
 		return cf.evalBodyNodes(node.Else, ctx)
 	}
@@ -103,23 +104,11 @@ func (cf *ControlFlowEvaluator) EvalForLoop(node *parser.ForNode, ctx Context) (
 
 	// Use strings.Builder for efficient string concatenation
 	var result strings.Builder
-	loopBroken := false
 	length := len(items)
 
-	// Get pooled loop info map to reduce allocations
-	loopInfoMap, _ := loopInfoPool.Get().(map[string]interface{})
-	if loopInfoMap == nil {
-		loopInfoMap = make(map[string]interface{}, 8)
-	}
-	// Pre-set the length which doesn't change
-	loopInfoMap["length"] = length
-	defer func() {
-		// Clear and return to pool
-		for k := range loopInfoMap {
-			delete(loopInfoMap, k)
-		}
-		loopInfoPool.Put(loopInfoMap)
-	}()
+	// One loop info map for all iterations; it can outlive the loop through
+	// variables that keep a reference to it, so it isn't pooled
+	loopInfoMap := make(map[string]interface{}, 8)
 
 	for i, item := range items {
 		// Set loop variable(s)
@@ -161,7 +150,6 @@ func (cf *ControlFlowEvaluator) EvalForLoop(node *parser.ForNode, ctx Context) (
 			// Check if it's a loop control error
 			if loopErr, ok := err.(*LoopControlError); ok {
 				if loopErr.IsBreak() {
-					loopBroken = true
 					break
 				} else if loopErr.IsContinue() {
 					continue
@@ -172,11 +160,6 @@ func (cf *ControlFlowEvaluator) EvalForLoop(node *parser.ForNode, ctx Context) (
 		_ = bodyResult // Result is written directly to builder
 	}
 
-	// If loop was broken and there are no results, execute else clause
-	if loopBroken && result.Len() == 0 && len(node.Else) > 0 {
-		return cf.evalBodyNodes(node.Else, ctx)
-	}
-
 	return result.String(), nil
 }
 
@@ -0,0 +1,292 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Dict is a dict made by a template, with the dict() global or written as
+// {...}. Like a Python dict it keeps its keys in the order they were added,
+// so they are looped over, listed by items(), keys() and values() and
+// serialized by tojson in that order:
+//
+//	{% set user = dict(name="Ann", role="admin") %}
+//	{% for key, value in user.items() %}{{ key }}={{ value }};{% endfor %}
+//
+// renders name=Ann;role=admin;. Looping over a Dict with one variable yields
+// its keys, and the in operator finds its keys. Go maps passed to a template
+// aren't Dicts and keep listing their keys sorted. A Dict is safe for
+// concurrent use.
+type Dict struct {
+	entries []dictEntry
+	index   map[string]int
+	mu      sync.Mutex
+}
+
+type dictEntry struct {
+	key   string
+	value interface{}
+}
+
+// NewDict returns an empty Dict
+func NewDict() *Dict {
+	return &Dict{index: make(map[string]int)}
+}
+
+// NewDictFromMap returns a Dict holding the entries of m, added in key order
+func NewDictFromMap(m map[string]interface{}) *Dict {
+	d := NewDict()
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		d.Set(key, m[key])
+	}
+	return d
+}
+
+// Get returns the value of key and whether the dict has it
+func (d *Dict) Get(key string) (interface{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i, ok := d.index[key]
+	if !ok {
+		return nil, false
+	}
+	return d.entries[i].value, true
+}
+
+// Set sets the value of key. A new key is added after the others; a key the
+// dict has keeps its place.
+func (d *Dict) Set(key string, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i, ok := d.index[key]; ok {
+		d.entries[i].value = value
+		return
+	}
+	d.index[key] = len(d.entries)
+	d.entries = append(d.entries, dictEntry{key, value})
+}
+
+// Delete removes key, reporting whether the dict had it
+func (d *Dict) Delete(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i, ok := d.index[key]
+	if !ok {
+		return false
+	}
+	d.entries = slices.Delete(d.entries, i, i+1)
+	delete(d.index, key)
+	for j := i; j < len(d.entries); j++ {
+		d.index[d.entries[j].key] = j
+	}
+	return true
+}
+
+// Keys returns the keys of the dict in the order they were added
+func (d *Dict) Keys() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, len(d.entries))
+	for i, entry := range d.entries {
+		keys[i] = entry.key
+	}
+	return keys
+}
+
+// Pairs returns the entries of the dict as [key, value] pairs, in the order
+// they were added, as items() lists them
+func (d *Dict) Pairs() []interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pairs := make([]interface{}, len(d.entries))
+	for i, entry := range d.entries {
+		pairs[i] = []interface{}{entry.key, entry.value}
+	}
+	return pairs
+}
+
+// Map returns the entries of the dict as a Go map, for Go code and filters
+// that take one
+func (d *Dict) Map() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := make(map[string]interface{}, len(d.entries))
+	for _, entry := range d.entries {
+		m[entry.key] = entry.value
+	}
+	return m
+}
+
+// Len returns the number of entries in the dict
+func (d *Dict) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// Iterate calls yield for the keys the dict holds when it is called, as
+// looping over a Python dict does
+func (d *Dict) Iterate(yield func(item interface{}) bool) {
+	for _, key := range d.Keys() {
+		if !yield(key) {
+			return
+		}
+	}
+}
+
+// Contains reports whether the dict has the key v, for the in operator
+func (d *Dict) Contains(v interface{}) bool {
+	key, ok := v.(string)
+	if !ok {
+		return false
+	}
+	_, found := d.Get(key)
+	return found
+}
+
+// Update adds the entries of other, a dict, a Go map with string keys or a
+// sequence of [key, value] pairs, in order. The entries of a Go map are added
+// in key order.
+func (d *Dict) Update(other interface{}) error {
+	switch o := other.(type) {
+	case *Dict:
+		for _, pair := range o.Pairs() {
+			entry := pair.([]interface{})
+			d.Set(entry[0].(string), entry[1])
+		}
+		return nil
+	case map[string]interface{}:
+		for _, key := range slices.Sorted(maps.Keys(o)) {
+			d.Set(key, o[key])
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(other)
+	if rv.Kind() == reflect.Map {
+		keys := rv.MapKeys()
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = fmt.Sprintf("%v", key.Interface())
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int { return strings.Compare(names[a], names[b]) })
+		for _, i := range order {
+			d.Set(names[i], rv.MapIndex(keys[i]).Interface())
+		}
+		return nil
+	}
+
+	items, err := listItems(other)
+	if err != nil {
+		return fmt.Errorf("requires a dict or a sequence of [key, value] pairs, got %s", describeOperand(other))
+	}
+	for i, item := range items {
+		pair, err := listItems(item)
+		if err != nil || len(pair) != 2 {
+			return fmt.Errorf("requires [key, value] pairs; sequence element #%d is not one", i)
+		}
+		d.Set(fmt.Sprintf("%v", pair[0]), pair[1])
+	}
+	return nil
+}
+
+// String writes the dict as a Go map is written, with its entries in order
+func (d *Dict) String() string {
+	var sb strings.Builder
+	sb.WriteString("map[")
+	for i, pair := range d.Pairs() {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		entry := pair.([]interface{})
+		fmt.Fprintf(&sb, "%v:%v", entry[0], entry[1])
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// MarshalJSON encodes the dict as a JSON object with its keys in order
+func (d *Dict) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, pair := range d.Pairs() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		entry := pair.([]interface{})
+		key, err := json.Marshal(entry[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(entry[1])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", entry[0], err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// RenderCopy returns a dict holding the same entries
+func (d *Dict) RenderCopy() interface{} {
+	c := NewDict()
+	c.Update(d)
+	return c
+}
+
+// dictView is what the dict methods work on: a Dict, or a Go map seen as one
+// with its keys sorted
+type dictView interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Delete(key string) bool
+	Keys() []string
+}
+
+// goMapView is a Go map seen as a dict
+type goMapView map[string]interface{}
+
+// mapView copies the Go map m, of any key type, into a goMapView, with the
+// keys written as text
+func mapView(m reflect.Value) goMapView {
+	view := make(goMapView, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		view[fmt.Sprintf("%v", iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return view
+}
+
+func (m goMapView) Get(key string) (interface{}, bool) {
+	v, ok := m[key]
+	return v, ok
+}
+
+func (m goMapView) Set(key string, value interface{}) {
+	m[key] = value
+}
+
+func (m goMapView) Delete(key string) bool {
+	_, ok := m[key]
+	delete(m, key)
+	return ok
+}
+
+// Keys returns the keys of the map sorted, for the order to be the same every
+// time
+func (m goMapView) Keys() []string {
+	return slices.Sorted(maps.Keys(m))
+}
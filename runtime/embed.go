@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// EmbedResolver resolves the template an embed tag renders
+type EmbedResolver interface {
+	// ResolveEmbed returns the AST of the template called name with blocks
+	// overriding its own
+	ResolveEmbed(name string, blocks []*parser.BlockNode, ctx Context) (*parser.TemplateNode, error)
+}
+
+// SetEmbedResolver sets the resolver of embed tags; without one they fail
+func (e *DefaultEvaluator) SetEmbedResolver(resolver EmbedResolver) {
+	e.embedResolver = resolver
+}
+
+// EvalEmbedNode evaluates embed blocks ({% embed "card.html" %}...{% endembed %}).
+// The template is rendered like an include, in the same context rules, with
+// the blocks of the embed tag overriding its own. The overrides are resolved
+// for each evaluation, so in a loop every instance sees its own variables.
+func (e *DefaultEvaluator) EvalEmbedNode(node *parser.EmbedNode, ctx Context) (interface{}, error) {
+	templateNameExpr, err := e.EvalNode(node.Template, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating template name in embed: %w", err)
+	}
+	templateName, ok := templateNameExpr.(string)
+	if !ok {
+		if node.IgnoreMissing && (templateNameExpr == nil || IsUndefined(templateNameExpr)) {
+			return "", nil
+		}
+		return nil, fmt.Errorf("embed template must be a name, got %T", templateNameExpr)
+	}
+	if e.embedResolver == nil {
+		return nil, fmt.Errorf("embed is not supported outside template renders")
+	}
+
+	if e.tracer != nil {
+		start := e.startSpan()
+		defer func() { e.tracer.OnInclude(templateName, e.endSpan(start)) }()
+	}
+
+	if len(e.includeStack) >= maxIncludeDepth && slices.Contains(e.includeStack, templateName) {
+		return nil, newCycleError("embed", e.includeStack, templateName)
+	}
+
+	embedCtx, err := e.includeContext("embed", node.Context, node.Assignments, node.Only, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	templateAST, err := e.embedResolver.ResolveEmbed(templateName, node.Blocks(), embedCtx)
+	if err != nil {
+		if isCycleError(err) {
+			return nil, err
+		}
+		if node.IgnoreMissing {
+			return "", nil
+		}
+		return nil, fmt.Errorf("failed to load embedded template %q: %w", templateName, err)
+	}
+
+	// Track the include stack for _template introspection
+	if state := renderStateOf(ctx); state != nil {
+		state.PushInclude(templateName)
+		defer state.PopInclude()
+	}
+
+	e.includeStack = append(e.includeStack, templateName)
+	defer func() { e.includeStack = e.includeStack[:len(e.includeStack)-1] }()
+	if err := e.enterNested(FrameInclude, templateName, node.Line()); err != nil {
+		return nil, err
+	}
+	popFrame := e.pushFrame(FrameInclude, templateName, templateName, node.Line())
+	result, err := e.EvalNode(templateAST, embedCtx)
+	if err != nil {
+		e.attachCallStack(err)
+	}
+	popFrame()
+	e.leaveNested()
+	if err != nil {
+		if isCycleError(err) || isRecursionError(err) || isSecurityError(err) || isMemoryError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error executing embedded template %q: %w", templateName, err)
+	}
+	return result, nil
+}
+
+// embedTemplate is the child template an embed tag stands for: one extending
+// the embedded template with the tag's blocks
+type embedTemplate struct {
+	name string
+	ast  *parser.TemplateNode
+}
+
+func (t *embedTemplate) AST() *parser.TemplateNode { return t.ast }
+func (t *embedTemplate) Name() string              { return t.name }
+
+// ResolveEmbed returns the AST an embed tag renders: the template called
+// name, resolved through its own inheritance chain, with blocks overriding
+// its blocks as those of a child template would, super() included. The
+// result isn't cached, as it belongs to one embed tag.
+func (p *InheritanceProcessor) ResolveEmbed(name string, blocks []*parser.BlockNode, context Context) (*parser.TemplateNode, error) {
+	target, err := p.env.GetTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return p.ResolveInheritance(target, context)
+	}
+
+	// The child is named after the embedded template, but differently, so
+	// the extends chain doesn't see a cycle
+	child := parser.NewTemplateNode(name+" (embedded)", blocks[0].Line(), blocks[0].Column())
+	child.Children = append(child.Children, parser.NewExtendsNode(parser.NewLiteralNode(name, "", 0, 0), 0, 0))
+	for _, block := range blocks {
+		child.Children = append(child.Children, block)
+	}
+
+	hierarchy, err := p.buildInheritanceHierarchyWithContext(&embedTemplate{name: child.Name, ast: child}, context)
+	if err != nil {
+		return nil, err
+	}
+	return p.buildFinalTemplate(hierarchy, context)
+}
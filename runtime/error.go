@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -12,12 +13,14 @@ type RuntimeError struct {
 	Type         string
 	Message      string
 	TemplateName string
+	Filename     string // File the template was read from, if known
 	Line         int
 	Column       int
 	Source       string
 	Context      string
 	Suggestion   string
 	Node         parser.Node // AST node where error occurred
+	CallStack    []CallFrame // Frames running when the error occurred, outermost first
 }
 
 // Error implements the error interface
@@ -51,7 +54,13 @@ func (re *RuntimeError) DetailedError() string {
 
 	// Location
 	if re.TemplateName != "" {
-		sb.WriteString(fmt.Sprintf("Template: %s\n", re.TemplateName))
+		sb.WriteString(fmt.Sprintf("Template: %s", re.TemplateName))
+		if re.Filename != "" && re.Filename != re.TemplateName {
+			sb.WriteString(fmt.Sprintf(" (%s)", re.Filename))
+		}
+		sb.WriteString("\n")
+	} else if re.Filename != "" {
+		sb.WriteString(fmt.Sprintf("Template: %s\n", re.Filename))
 	}
 	if re.Line > 0 {
 		sb.WriteString(fmt.Sprintf("Location: Line %d", re.Line))
@@ -60,6 +69,9 @@ func (re *RuntimeError) DetailedError() string {
 		}
 		sb.WriteString("\n")
 	}
+	if trace := re.CallStackTrace(); trace != "" {
+		sb.WriteString(fmt.Sprintf("Call stack: %s\n", trace))
+	}
 
 	// Source context
 	if re.Source != "" && re.Line > 0 {
@@ -103,8 +115,8 @@ func (re *RuntimeError) getSourceContext() string {
 		if lineNum == re.Line {
 			sb.WriteString(fmt.Sprintf("  > %3d | %s\n", lineNum, line))
 			if re.Column > 0 {
-				// Add pointer to the exact column
-				pointer := strings.Repeat(" ", 8+re.Column-1) + "^"
+				// Add pointer to the exact column, past the line number
+				pointer := strings.Repeat(" ", 6+re.Column-1) + "^"
 				sb.WriteString(fmt.Sprintf("    %s\n", pointer))
 			}
 		} else {
@@ -213,3 +225,85 @@ func NewAccessError(attribute string, obj interface{}, node parser.Node) *Runtim
 	return NewRuntimeError(ErrorTypeAccess, message, node).
 		WithSuggestion(fmt.Sprintf("Check if attribute '%s' exists or if the object is nil", attribute))
 }
+
+// AliasError reports a failure in a filter or test that was invoked through
+// an alias, naming both the alias used in the template and its target
+type AliasError struct {
+	Kind   string // "filter" or "test"
+	Alias  string
+	Target string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (ae *AliasError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s '%s' (alias of '%s') failed", ae.Kind, ae.Alias, ae.Target))
+	if ae.Line > 0 {
+		sb.WriteString(fmt.Sprintf(" at line %d", ae.Line))
+		if ae.Column > 0 {
+			sb.WriteString(fmt.Sprintf(", column %d", ae.Column))
+		}
+	}
+	sb.WriteString(": ")
+	sb.WriteString(ae.Err.Error())
+	return sb.String()
+}
+
+func (ae *AliasError) Unwrap() error {
+	return ae.Err
+}
+
+// CycleError reports templates that include, import or extend each other in
+// a loop. Path starts and ends with the same template.
+type CycleError struct {
+	Kind string // "include", "import" or "extends"
+	Path []string
+}
+
+func (ce *CycleError) Error() string {
+	return fmt.Sprintf("%s cycle: %s", ce.Kind, strings.Join(ce.Path, " -> "))
+}
+
+// OrphanedContentError reports output a child template places outside its
+// blocks, which inheritance discards; see ChildContentError
+type OrphanedContentError struct {
+	Template string
+	Line     int
+	Column   int
+	Content  string // the start of the discarded content
+}
+
+func (oe *OrphanedContentError) Error() string {
+	return fmt.Sprintf("content outside blocks in child template %s at line %d, column %d is never rendered: %q",
+		oe.Template, oe.Line, oe.Column, oe.Content)
+}
+
+// newCycleError builds the cycle closed by entering name from stack, the
+// templates being entered, outermost first
+func newCycleError(kind string, stack []string, name string) *CycleError {
+	start := 0
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == name {
+			start = i
+			break
+		}
+	}
+	path := append(append([]string(nil), stack[start:]...), name)
+	return &CycleError{Kind: kind, Path: path}
+}
+
+func isCycleError(err error) bool {
+	var cycleErr *CycleError
+	return errors.As(err, &cycleErr)
+}
+
+// withAliasPosition records node's position on an alias failure in err
+func withAliasPosition(err error, node parser.Node) error {
+	var aliasErr *AliasError
+	if node != nil && errors.As(err, &aliasErr) && aliasErr.Line == 0 {
+		aliasErr.Line, aliasErr.Column = node.Line(), node.Column()
+	}
+	return err
+}
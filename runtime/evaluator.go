@@ -1,13 +1,18 @@
 package runtime
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
+	"maps"
 	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -28,23 +33,20 @@ func capitalizeFirst(s string) string {
 	return string(unicode.ToUpper(r)) + s[size:]
 }
 
-// Phase 4b optimization: Pool for loop info maps to reduce allocations
-var loopInfoPool = sync.Pool{
-	New: func() interface{} {
-		return make(map[string]interface{}, 12) // Pre-size for typical loop info
-	},
-}
-
 // Context interface for runtime package - matches main package interface
 type Context interface {
 	GetVariable(key string) (interface{}, bool)
+	// HasVariable reports whether GetVariable would find key, without
+	// fetching its value
+	HasVariable(key string) bool
 	SetVariable(key string, value interface{})
 	Clone() Context
 	All() map[string]interface{}
 }
 
-// FastEvalNode interface for Phase 3c optimization
-// Nodes that implement this can evaluate themselves directly
+// FastEvalNode is implemented by node types outside the parser package that
+// evaluate themselves. EvalNode dispatches the parser's own nodes directly and
+// only consults FastEval for node types it doesn't know.
 type FastEvalNode interface {
 	FastEval(e *DefaultEvaluator, ctx Context) (interface{}, error)
 }
@@ -54,14 +56,19 @@ type simpleContext struct {
 	variables map[string]interface{}
 }
 
-// Cycler represents a cycler object that cycles through values
+// Cycler represents a cycler object that cycles through values. It is safe
+// for concurrent use.
 type Cycler struct {
 	Items   []interface{}
 	Current int
+	mu      sync.Mutex
 }
 
 // Next returns the next item in the cycle
 func (c *Cycler) Next() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if len(c.Items) == 0 {
 		return nil
 	}
@@ -78,6 +85,9 @@ func (c *Cycler) Next() interface{} {
 
 // GetCurrent returns the current item without advancing
 func (c *Cycler) GetCurrent() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if len(c.Items) == 0 {
 		return nil
 	}
@@ -90,17 +100,31 @@ func (c *Cycler) GetCurrent() interface{} {
 
 // Reset resets the cycler to the beginning
 func (c *Cycler) Reset() {
+	c.mu.Lock()
 	c.Current = 0
+	c.mu.Unlock()
 }
 
-// Joiner represents a joiner object that joins values with separators
+// RenderCopy returns a cycler over the same items at the same position
+func (c *Cycler) RenderCopy() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &Cycler{Items: c.Items, Current: c.Current}
+}
+
+// Joiner represents a joiner object that joins values with separators. It is
+// safe for concurrent use.
 type Joiner struct {
 	Separator string
 	Used      bool
+	mu        sync.Mutex
 }
 
 // Join returns the separator if this is not the first call, empty string otherwise
 func (j *Joiner) Join() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
 	if j.Used {
 		return j.Separator
 	}
@@ -113,6 +137,56 @@ func (j *Joiner) String() string {
 	return j.Join()
 }
 
+// RenderCopy returns a joiner with the same separator and state
+func (j *Joiner) RenderCopy() interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &Joiner{Separator: j.Separator, Used: j.Used}
+}
+
+// LoopCycle implements loop.cycle. Every call site in the loop body keeps its
+// own position, which advances only when that call runs, so two alternations
+// in one iteration are independent and a call skipped by a condition does not
+// lose its place.
+type LoopCycle struct {
+	positions map[parser.Node]int
+}
+
+func newLoopCycle() *LoopCycle {
+	return &LoopCycle{positions: make(map[parser.Node]int)}
+}
+
+// Call returns the next of values for the call at site. A nil site shares one
+// position among all callers that have no call node.
+func (lc *LoopCycle) Call(site parser.Node, values ...interface{}) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("cycle() requires at least one argument")
+	}
+	position := lc.positions[site]
+	lc.positions[site] = position + 1
+	return values[position%len(values)], nil
+}
+
+// RenderLocal is implemented by template helpers with mutable state, such as
+// the results of cycler(), joiner() and namespace(). Where such a value
+// outlives the render that created it, like a variable set at the top level
+// of an imported template, each later render works on its own RenderCopy.
+type RenderLocal interface {
+	RenderCopy() interface{}
+}
+
+// renderCopy returns value, or a copy of it if it is RenderLocal or a dict,
+// which templates change with update, pop and setdefault
+func renderCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case RenderLocal:
+		return v.RenderCopy()
+	case map[string]interface{}:
+		return maps.Clone(v)
+	}
+	return value
+}
+
 // CallableLoop represents a loop object that can be called recursively and also accessed for properties
 type CallableLoop struct {
 	Info          map[string]interface{}
@@ -133,11 +207,28 @@ func (cl *CallableLoop) GetAttribute(name string) (interface{}, bool) {
 	return val, ok
 }
 
+// loopInfoOf returns the info map behind a loop variable, whether or not the
+// loop is recursive
+func loopInfoOf(loop interface{}) map[string]interface{} {
+	switch l := loop.(type) {
+	case map[string]interface{}:
+		return l
+	case *CallableLoop:
+		return l.Info
+	}
+	return nil
+}
+
 func (c *simpleContext) GetVariable(name string) (interface{}, bool) {
 	val, ok := c.variables[name]
 	return val, ok
 }
 
+func (c *simpleContext) HasVariable(name string) bool {
+	_, ok := c.variables[name]
+	return ok
+}
+
 func (c *simpleContext) SetVariable(name string, value interface{}) {
 	c.variables[name] = value
 }
@@ -176,8 +267,64 @@ type DefaultEvaluator struct {
 	env              Context
 	importSystem     *ImportSystem
 	undefinedHandler *UndefinedHandler
+
+	tracer      Tracer
+	traceNested []time.Duration // see startSpan
+
+	fragmentCache FragmentCache
+	embedResolver EmbedResolver
+
+	finalizer          Finalizer
+	blockPostProcessor BlockPostProcessor
+
+	// Namespaces being built and templates being included, outermost
+	// first, for cycle detection
+	importing    []*TemplateNamespace
+	includeStack []string
+
+	// Templates, macro calls and includes running, outermost first, and the
+	// line of the call expression being evaluated, for RuntimeError call stacks
+	callStack []CallFrame
+	callLine  int
+
+	// Nesting of macro calls, includes and recursive loops, and its limit
+	depth             int
+	maxRecursionDepth int
+
+	// Types whose methods templates may call; nil allows any (see SetSafeTypes)
+	isSafeType func(reflect.Type) bool
+
+	// Restrictions of a sandboxed render, nil otherwise, and the items its
+	// loops have iterated over (see SetSandbox)
+	sandbox    *Sandbox
+	iterations int
+
+	// Bytes the render may allocate, 0 for no limit, and those it has (see
+	// SetMaxRenderMemory)
+	maxMemory int
+	memory    int
+
+	// Whether the whitespace of template text is collapsed, and the
+	// minifier doing it (see SetHTMLMinifyWhitespace)
+	minifyWhitespace bool
+	minifier         *htmlMinifier
+
+	// Whether map keys missing as written are looked up ignoring case (see
+	// SetCaseInsensitiveMapKeys)
+	foldMapKeys bool
 }
 
+// maxIncludeDepth bounds nested includes. A template may include itself, as
+// when rendering a tree, so only a chain this deep is reported as a cycle.
+const maxIncludeDepth = 100
+
+// Finalizer transforms the value of every {{ expression }} before it is
+// escaped and written to the output
+type Finalizer func(value interface{}) interface{}
+
+// BlockPostProcessor transforms the rendered output of the block called name
+type BlockPostProcessor func(name, output string) (string, error)
+
 func NewEvaluator() *DefaultEvaluator {
 	return &DefaultEvaluator{
 		undefinedHandler: NewUndefinedHandler(UndefinedSilent),
@@ -207,22 +354,57 @@ func (e *DefaultEvaluator) SetUndefinedBehavior(behavior UndefinedBehavior) {
 	}
 }
 
+// SetUndefinedFactory sets a custom factory for undefined variables; nil
+// restores the default for the current behavior
+func (e *DefaultEvaluator) SetUndefinedFactory(factory UndefinedFactory) {
+	if e.undefinedHandler == nil {
+		e.undefinedHandler = NewUndefinedHandler(UndefinedSilent)
+	}
+	e.undefinedHandler.SetUndefinedFactory(factory)
+}
+
+// SetFinalizer sets the function applied to output values; nil disables it
+func (e *DefaultEvaluator) SetFinalizer(finalizer Finalizer) {
+	e.finalizer = finalizer
+}
+
+// SetCaseInsensitiveMapKeys makes attribute and item lookups on Go maps
+// fall back to the string key equal to the name ignoring case when no key
+// is the name as written, so headers["content-type"] finds "Content-Type".
+// The fallback looks at every key of the map. Entries found as written and
+// dict methods such as items come first.
+func (e *DefaultEvaluator) SetCaseInsensitiveMapKeys(enabled bool) {
+	e.foldMapKeys = enabled
+}
+
+// SetBlockPostProcessor sets the function applied to the output of blocks;
+// nil disables it
+func (e *DefaultEvaluator) SetBlockPostProcessor(processor BlockPostProcessor) {
+	e.blockPostProcessor = processor
+}
+
+// recordImport notes that the namespace being built imports templateName
+func (e *DefaultEvaluator) recordImport(templateName string) {
+	if e == nil || len(e.importing) == 0 {
+		return
+	}
+	importer := e.importing[len(e.importing)-1]
+	if !slices.Contains(importer.dependsOn, templateName) {
+		importer.dependsOn = append(importer.dependsOn, templateName)
+	}
+}
+
 // SetImportSystem sets the import system for handling template imports
 func (e *DefaultEvaluator) SetImportSystem(importSystem *ImportSystem) {
 	e.importSystem = importSystem
 }
 
 func (e *DefaultEvaluator) EvalNode(node parser.Node, ctx Context) (interface{}, error) {
-	// Phase 3c optimization: Fast path for nodes that implement FastEval
-	if fastNode, ok := node.(FastEvalNode); ok {
-		return fastNode.FastEval(e, ctx)
-	}
-
 	switch n := node.(type) {
-	case *parser.TemplateNode:
-		return e.EvalTemplateNode(n, ctx)
 	case *parser.TextNode:
 		return e.EvalTextNode(n, ctx)
+	case *parser.TemplateNode:
+		return e.EvalTemplateNode(n, ctx)
 	case *parser.CommentNode:
 		return e.EvalCommentNode(n, ctx)
 	case *parser.RawNode:
@@ -235,6 +417,8 @@ func (e *DefaultEvaluator) EvalNode(node parser.Node, ctx Context) (interface{},
 		return e.EvalLiteralNode(n, ctx)
 	case *parser.ListNode:
 		return e.EvalListNode(n, ctx)
+	case *parser.DictNode:
+		return e.EvalDictNode(n, ctx)
 	case *parser.AttributeNode:
 		return e.EvalAttributeNode(n, ctx)
 	case *parser.GetItemNode:
@@ -243,10 +427,14 @@ func (e *DefaultEvaluator) EvalNode(node parser.Node, ctx Context) (interface{},
 		return e.EvalFilterNode(n, ctx)
 	case *parser.BinaryOpNode:
 		return e.EvalBinaryOpNode(n, ctx)
+	case *parser.CompareNode:
+		return e.EvalCompareNode(n, ctx)
 	case *parser.UnaryOpNode:
 		return e.EvalUnaryOpNode(n, ctx)
 	case *parser.IfNode:
 		return e.EvalIfNode(n, ctx)
+	case *parser.SwitchNode:
+		return e.EvalSwitchNode(n, ctx)
 	case *parser.ForNode:
 		return e.EvalForNode(n, ctx)
 	case *parser.BlockNode:
@@ -295,6 +483,12 @@ func (e *DefaultEvaluator) EvalNode(node parser.Node, ctx Context) (interface{},
 		return e.EvalDoNode(n, ctx)
 	case *parser.FilterBlockNode:
 		return e.EvalFilterBlockNode(n, ctx)
+	case *parser.CacheNode:
+		return e.EvalCacheNode(n, ctx)
+	case *parser.EmbedNode:
+		return e.EvalEmbedNode(n, ctx)
+	case FastEvalNode:
+		return n.FastEval(e, ctx)
 	default:
 		return nil, fmt.Errorf("unsupported node type: %T", node)
 	}
@@ -305,6 +499,9 @@ func (e *DefaultEvaluator) EvalTemplateNode(node *parser.TemplateNode, ctx Conte
 }
 
 func (e *DefaultEvaluator) EvalTextNode(node *parser.TextNode, ctx Context) (string, error) {
+	if e.minifyWhitespace {
+		return e.minifier.minify(node.Content), nil
+	}
 	return node.Content, nil
 }
 
@@ -324,6 +521,16 @@ func (e *DefaultEvaluator) EvalVariableNode(node *parser.VariableNode, ctx Conte
 		return nil, err
 	}
 
+	// Undefined values are left to the undefined handler
+	if e.finalizer != nil {
+		if _, undefined := result.(*Undefined); !undefined {
+			result = e.finalizer(result)
+		}
+	}
+	if IsFunction(result) {
+		return nil, functionValueError(node.Expression, node.Expression)
+	}
+
 	// Handle nil values
 	if result == nil {
 		return "", nil
@@ -337,8 +544,12 @@ func (e *DefaultEvaluator) EvalVariableNode(node *parser.VariableNode, ctx Conte
 		}
 	} else if autoCtx, ok := ctx.(AutoescapeContext); ok && autoCtx.IsAutoescapeEnabled() {
 		// Fallback to old interface for backward compatibility
-		if str, ok := result.(string); ok {
-			return html.EscapeString(str), nil
+		switch v := result.(type) {
+		case string:
+			return html.EscapeString(v), nil
+		case *Undefined:
+			// Debug markers echo names and hints from the template and its data
+			return html.EscapeString(v.String()), nil
 		}
 	}
 
@@ -346,11 +557,20 @@ func (e *DefaultEvaluator) EvalVariableNode(node *parser.VariableNode, ctx Conte
 }
 
 func (e *DefaultEvaluator) EvalIdentifierNode(node *parser.IdentifierNode, ctx Context) (interface{}, error) {
+	if err := e.checkGlobalAllowed(node.Name, node); err != nil {
+		return nil, err
+	}
 	value, ok := ctx.GetVariable(node.Name)
 	if !ok {
+		// Expose render metadata as _template when introspection is enabled
+		if node.Name == TemplateInfoVariable {
+			if state := renderStateOf(ctx); state != nil {
+				return state.Info(), nil
+			}
+		}
 		// Use undefined handler to determine behavior
 		if e.undefinedHandler != nil {
-			return e.undefinedHandler.Handle(node.Name, node)
+			return e.handleMissing(node.Name, node.Name, node, func() []string { return contextNames(ctx) })
 		}
 		// Fallback to original behavior
 		return nil, NewUndefinedVariableError(node.Name, node)
@@ -374,6 +594,25 @@ func (e *DefaultEvaluator) EvalListNode(node *parser.ListNode, ctx Context) (int
 	return result, nil
 }
 
+// EvalDictNode builds a new Dict each time, so a dict a template changes with
+// update or setdefault is never shared with another render. Its keys keep the
+// order they are written in.
+func (e *DefaultEvaluator) EvalDictNode(node *parser.DictNode, ctx Context) (interface{}, error) {
+	result := NewDict()
+	for i, keyNode := range node.Keys {
+		key, err := e.EvalNode(keyNode, ctx)
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.EvalNode(node.Values[i], ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.Set(fmt.Sprintf("%v", key), value)
+	}
+	return result, nil
+}
+
 func (e *DefaultEvaluator) EvalAttributeNode(node *parser.AttributeNode, ctx Context) (interface{}, error) {
 	obj, err := e.EvalNode(node.Object, ctx)
 	if err != nil {
@@ -385,10 +624,17 @@ func (e *DefaultEvaluator) EvalAttributeNode(node *parser.AttributeNode, ctx Con
 		return e.undefinedHandler.HandleAttributeAccess(undefined, node.Attribute, node)
 	}
 
+	if err := e.checkAttributeAllowed(obj, node.Attribute, node); err != nil {
+		return nil, err
+	}
+	if err := e.checkMethodAllowed(obj, node.Attribute, node); err != nil {
+		return nil, err
+	}
+
 	// Check if attribute exists first, then get value
 	if e.undefinedHandler != nil && !e.attributeExists(obj, node.Attribute) {
 		attrName := fmt.Sprintf("%s.%s", e.getObjectName(obj), node.Attribute)
-		return e.undefinedHandler.Handle(attrName, node)
+		return e.handleMissing(attrName, node.Attribute, node, func() []string { return attributeNames(obj) })
 	}
 
 	// Get attribute value - this is safe now since we checked existence
@@ -412,14 +658,49 @@ func (e *DefaultEvaluator) EvalGetItemNode(node *parser.GetItemNode, ctx Context
 		return e.undefinedHandler.HandleItemAccess(undefined, key, node)
 	}
 
+	// A missing key is undefined, as a missing attribute is
+	if m, ok := obj.(map[string]interface{}); ok && e.undefinedHandler != nil {
+		keyStr := fmt.Sprintf("%v", key)
+		if _, exists := m[keyStr]; !exists && !e.hasFoldedMapEntry(m, keyStr) {
+			itemName := fmt.Sprintf("%s[%q]", e.getObjectName(obj), keyStr)
+			return e.handleMissing(itemName, keyStr, node, func() []string { return mapKeys(m) })
+		}
+	}
+	if d, ok := obj.(*Dict); ok && e.undefinedHandler != nil {
+		keyStr := fmt.Sprintf("%v", key)
+		if _, exists := d.Get(keyStr); !exists {
+			itemName := fmt.Sprintf("%s[%q]", e.getObjectName(obj), keyStr)
+			return e.handleMissing(itemName, keyStr, node, d.Keys)
+		}
+	}
+
 	return e.getItem(obj, key)
 }
 
 func (e *DefaultEvaluator) EvalFilterNode(node *parser.FilterNode, ctx Context) (interface{}, error) {
-	value, err := e.EvalNode(node.Expression, ctx)
+	if err := e.checkFilterAllowed(node.FilterName, node); err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	var err error
+	if node.FilterName == "default" || node.FilterName == "d" {
+		value, err = e.evalDefaultOperand(node.Expression, ctx)
+	} else {
+		value, err = e.EvalNode(node.Expression, ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return e.applyFilterNode(node, value, lookupFilter(ctx, node.FilterName), ctx)
+}
+
+// applyFilterNode applies the filter of node, with its arguments evaluated in
+// ctx, to value, the filtered expression's value. registration is whether the
+// environment of ctx registers the filter, which decides between a
+// registered filter, an expression filter and a macro or function in scope.
+func (e *DefaultEvaluator) applyFilterNode(node *parser.FilterNode, value interface{}, registration filterRegistration, ctx Context) (interface{}, error) {
+	var err error
 
 	// Evaluate filter arguments with pre-allocated capacity
 	args := make([]interface{}, 0, len(node.Arguments))
@@ -430,30 +711,236 @@ func (e *DefaultEvaluator) EvalFilterNode(node *parser.FilterNode, ctx Context)
 		}
 		args = append(args, argValue)
 	}
+	var kwargs map[string]interface{}
+	if node.FilterName == "default" || node.FilterName == "d" {
+		if args, err = e.defaultFilterKeywords(node, args, ctx); err != nil {
+			return nil, err
+		}
+	} else if len(node.NamedArgs) > 0 {
+		kwargs = make(map[string]interface{}, len(node.NamedArgs))
+		for name, arg := range node.NamedArgs {
+			if kwargs[name], err = e.EvalNode(arg, ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if e.tracer != nil {
+		start := e.startSpan()
+		defer func() { e.tracer.OnFilter(node.FilterName, e.endSpan(start)) }()
+	}
+
+	if expressionFilters[node.FilterName] && registration != filterRegistered {
+		return e.applyExpressionFilter(node, value, args, ctx)
+	}
 
 	// Try to use environment's filter registry if available
-	if envCtx, ok := ctx.(EnvironmentContext); ok {
-		return envCtx.ApplyFilter(node.FilterName, value, args...)
+	if envCtx := environmentOf(ctx); envCtx != nil {
+		if registration == filterUnregistered {
+			return e.callScopeFilter(node, value, args, kwargs, ctx)
+		}
+
+		if costCtx, ok := envCtx.(FilterMemoryContext); ok && e.maxMemory > 0 {
+			if err := e.chargeMemory(costCtx.FilterMemoryCost(node.FilterName, value, args), fmt.Sprintf("filter '%s'", node.FilterName), node); err != nil {
+				return nil, err
+			}
+		}
+
+		var result interface{}
+		if kwCtx, ok := envCtx.(KeywordFilterContext); ok {
+			result, err = kwCtx.ApplyFilterWithContext(ctx, node.FilterName, value, args, kwargs)
+		} else {
+			result, err = envCtx.ApplyFilter(node.FilterName, value, args...)
+		}
+		if errors.Is(err, ErrFunctionValue) {
+			return nil, functionValueError(node.Expression, node.Expression)
+		}
+		if err != nil {
+			return nil, withAliasPosition(err, node)
+		}
+		if safeCtx, ok := envCtx.(SafeFilterContext); ok && safeCtx.IsSafeFilter(node.FilterName) && AutoescapeEnabled(ctx) {
+			if _, isSafe := result.(SafeValue); !isSafe {
+				result = SafeValue{Value: result}
+			}
+		}
+		return result, nil
 	}
 
 	// Fallback to basic filters
 	return e.applyFilter(node.FilterName, value, args)
 }
 
+// callScopeFilter applies a macro or function in scope as the filter named by
+// node, when no filter of that name is registered. The filtered value is its
+// first argument, so {{ text|card("Title") }} is card(text, "Title").
+func (e *DefaultEvaluator) callScopeFilter(node *parser.FilterNode, value interface{}, args []interface{}, kwargs map[string]interface{}, ctx Context) (interface{}, error) {
+	function, ok := ctx.GetVariable(node.FilterName)
+	if !ok || IsUndefined(function) {
+		return nil, NewRuntimeError(ErrorTypeFilter, fmt.Sprintf("unknown filter: %s (no filter is registered and no macro or function of that name is in scope)", node.FilterName), node)
+	}
+	if function == nil || reflect.ValueOf(function).Kind() != reflect.Func {
+		return nil, NewRuntimeError(ErrorTypeFilter, fmt.Sprintf("unknown filter: %s (no filter is registered and the %T of that name in scope is not callable)", node.FilterName, function), node)
+	}
+
+	callArgs := make([]interface{}, 0, len(args)+1)
+	callArgs = append(callArgs, value)
+	callArgs = append(callArgs, args...)
+	call := parser.NewCallNode(parser.NewIdentifierNode(node.FilterName, node.Line(), node.Column()), node.Line(), node.Column())
+	return e.callFunctionWithContext(function, callArgs, kwargs, ctx, call)
+}
+
+// defaultFilterKeywords moves default's keyword arguments, default_value and
+// boolean, to their positions in args
+func (e *DefaultEvaluator) defaultFilterKeywords(node *parser.FilterNode, args []interface{}, ctx Context) ([]interface{}, error) {
+	params := []struct {
+		name string
+		zero interface{}
+	}{{"default_value", ""}, {"boolean", false}}
+
+	for i, param := range params {
+		expr, ok := node.NamedArgs[param.name]
+		if !ok {
+			continue
+		}
+		value, err := e.EvalNode(expr, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for len(args) <= i {
+			args = append(args, params[len(args)].zero)
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+// evalDefaultOperand evaluates the operand of the default filter, which is
+// also the operand of the none test and the arguments of callables that
+// accept undefined values, like the default() global. These exist to handle
+// undefined operands, so a lookup chain feeding them directly,
+// or through the branches of conditional expressions, yields one in every
+// mode, as does a conditional without an else whose condition is false.
+func (e *DefaultEvaluator) evalDefaultOperand(expr parser.ExpressionNode, ctx Context) (interface{}, error) {
+	if cond, ok := expr.(*parser.ConditionalNode); ok {
+		condition, err := e.EvalNode(cond.Condition, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if e.isTruthy(condition) {
+			return e.evalDefaultOperand(cond.TrueExpr, ctx)
+		}
+		if cond.FalseExpr == nil {
+			return NewUndefined("", UndefinedSilent, cond), nil
+		}
+		return e.evalDefaultOperand(cond.FalseExpr, ctx)
+	}
+
+	value, err := e.EvalNode(expr, ctx)
+	if err != nil && IsUndefinedError(err) && isLookupChain(expr) {
+		return NewUndefined("", UndefinedSilent, expr), nil
+	}
+	return value, err
+}
+
+// peekUndefinedHandler yields silent undefined values for peekLookup
+var peekUndefinedHandler = NewUndefinedHandler(UndefinedSilent)
+
+// peekLookup evaluates expr for the defined and undefined tests. A lookup
+// chain such as user.settings.theme is followed in silent mode whatever the
+// environment's, so a missing variable, attribute or item at any hop yields
+// an Undefined rather than an error. Other expressions evaluate as usual.
+func (e *DefaultEvaluator) peekLookup(expr parser.ExpressionNode, ctx Context) (interface{}, error) {
+	if !isLookupChain(expr) {
+		return e.EvalNode(expr, ctx)
+	}
+	handler := e.undefinedHandler
+	e.undefinedHandler = peekUndefinedHandler
+	defer func() { e.undefinedHandler = handler }()
+	return e.EvalNode(expr, ctx)
+}
+
+// isLookupChain reports whether node is a variable followed by any number of
+// attribute and item lookups, such as user.address["city"]
+func isLookupChain(node parser.Node) bool {
+	for {
+		switch n := node.(type) {
+		case *parser.IdentifierNode:
+			return true
+		case *parser.AttributeNode:
+			node = n.Object
+		case *parser.GetItemNode:
+			node = n.Object
+		default:
+			return false
+		}
+	}
+}
+
 func (e *DefaultEvaluator) EvalBinaryOpNode(node *parser.BinaryOpNode, ctx Context) (interface{}, error) {
 	left, err := e.EvalNode(node.Left, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	// and/or stop at the left operand when it decides the result, so guards
+	// like x is defined and x > 0 don't evaluate the undefined side
+	switch node.Operator {
+	case "and":
+		if !e.isTruthy(left) {
+			return false, nil
+		}
+	case "or":
+		if e.isTruthy(left) {
+			return true, nil
+		}
+	}
+
 	right, err := e.EvalNode(node.Right, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if node.Operator == "~" {
+		if IsFunction(left) {
+			return nil, functionValueError(node.Left, node.Left)
+		}
+		if IsFunction(right) {
+			return nil, functionValueError(node.Right, node.Right)
+		}
+		if AutoescapeEnabled(ctx) {
+			if result, ok := concatMarkup(left, right, ctx); ok {
+				return result, nil
+			}
+		}
+	}
 	return e.applyBinaryOpWithNode(node.Operator, left, right, node)
 }
 
+// EvalCompareNode evaluates a comparison chain such as 0 < x <= 10. Like
+// Python and Jinja2, it stops at the first comparison that fails without
+// evaluating the operands after it.
+func (e *DefaultEvaluator) EvalCompareNode(node *parser.CompareNode, ctx Context) (interface{}, error) {
+	left, err := e.EvalNode(node.Left, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range node.Operators {
+		right, err := e.EvalNode(node.Comparators[i], ctx)
+		if err != nil {
+			return nil, err
+		}
+		result, err := e.applyBinaryOpWithNode(op, left, right, node)
+		if err != nil {
+			return nil, err
+		}
+		if !e.isTruthy(result) {
+			return false, nil
+		}
+		left = right
+	}
+	return true, nil
+}
+
 func (e *DefaultEvaluator) EvalUnaryOpNode(node *parser.UnaryOpNode, ctx Context) (interface{}, error) {
 	operand, err := e.EvalNode(node.Operand, ctx)
 	if err != nil {
@@ -493,6 +980,33 @@ func (e *DefaultEvaluator) EvalIfNode(node *parser.IfNode, ctx Context) (interfa
 	return "", nil
 }
 
+// EvalSwitchNode renders the first case with a value equal to the subject,
+// which is evaluated once, or the default when no case matches
+func (e *DefaultEvaluator) EvalSwitchNode(node *parser.SwitchNode, ctx Context) (interface{}, error) {
+	subject, err := e.EvalNode(node.Subject, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range node.Cases {
+		for _, valueNode := range c.Values {
+			value, err := e.EvalNode(valueNode, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if e.equal(subject, value) {
+				return e.evalNodeList(c.Body, ctx)
+			}
+		}
+	}
+
+	if len(node.Default) > 0 {
+		return e.evalNodeList(node.Default, ctx)
+	}
+
+	return "", nil
+}
+
 func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (interface{}, error) {
 	iterable, err := e.EvalNode(node.Iterable, ctx)
 	if err != nil {
@@ -504,27 +1018,24 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 		return nil, err
 	}
 
-	if len(items) == 0 && len(node.Else) > 0 {
-		// Execute else clause if no items
-		return e.evalNodeList(node.Else, ctx)
-	}
-
 	var results []string
+	outputSize := 0
 	loopCtx := ctx.Clone()
-	loopBroken := false
 
-	// Determine loop depth once for the entire loop
+	// Determine loop depth once for the entire loop. The enclosing loop, or the
+	// previous level of a recursive loop, is exposed as loop.parent.
 	depth := 1
+	var parentInfo map[string]interface{}
 	if parentLoop, exists := ctx.GetVariable("loop"); exists {
-		if parentLoopMap, ok := parentLoop.(map[string]interface{}); ok {
-			if parentDepth, ok := parentLoopMap["depth"].(int); ok {
-				depth = parentDepth + 1
-			}
+		parentInfo = loopInfoOf(parentLoop)
+		if parentDepth, ok := parentInfo["depth"].(int); ok {
+			depth = parentDepth + 1
 		}
 	}
 
 	// Track previous values for loop.changed() functionality
 	var previousChanged map[string]interface{}
+	cycle := newLoopCycle()
 
 	// Pre-filter items if there's a condition to get correct loop indices
 	filteredItems := make([]interface{}, 0, len(items))
@@ -566,7 +1077,23 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 		filteredItems = items
 	}
 
+	// The else clause runs when the body runs zero times: the sequence is
+	// empty, or its condition rejects every item. Once an iteration has
+	// started it never runs, whether or not the loop breaks or writes
+	// output.
+	if len(filteredItems) == 0 && len(node.Else) > 0 {
+		return e.evalNodeList(node.Else, ctx)
+	}
+
+	// One loop info map serves every iteration, like Jinja2's loop object. It
+	// is not pooled: templates can keep a reference to loop past the loop.
+	loopInfo := make(map[string]interface{}, 16)
+
 	for i, item := range filteredItems {
+		if err := e.countIterations(1, node); err != nil {
+			return nil, err
+		}
+
 		// Set loop variable(s)
 		if len(node.Variables) == 1 {
 			// Single variable assignment
@@ -599,15 +1126,6 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 			nextitem = filteredItems[i+1]
 		}
 
-		// Create cycle function for this loop
-		cycleFunc := func(values ...interface{}) (interface{}, error) {
-			if len(values) == 0 {
-				return nil, fmt.Errorf("cycle() requires at least one argument")
-			}
-			cycleIndex := i % len(values)
-			return values[cycleIndex], nil
-		}
-
 		// Create changed function for tracking value changes between iterations
 		changedFunc := func(values ...interface{}) (interface{}, error) {
 			if i == 0 {
@@ -644,18 +1162,6 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 			return hasChanged, nil
 		}
 
-		// Phase 4b optimization: Get loop info map from pool and reuse it
-		var loopInfo map[string]interface{}
-		if pooled, ok := loopInfoPool.Get().(map[string]interface{}); ok {
-			loopInfo = pooled
-			// Clear any stale keys from previous use
-			for k := range loopInfo {
-				delete(loopInfo, k)
-			}
-		} else {
-			loopInfo = make(map[string]interface{}, 12)
-		}
-
 		// Update values (reuse map, don't allocate new one)
 		loopInfo["index"] = i + 1
 		loopInfo["index0"] = i
@@ -665,20 +1171,32 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 		loopInfo["last"] = i == len(filteredItems)-1
 		loopInfo["length"] = len(filteredItems)
 		loopInfo["depth"] = depth
+		loopInfo["depth0"] = depth - 1
 		loopInfo["previtem"] = previtem
 		loopInfo["nextitem"] = nextitem
-		loopInfo["cycle"] = cycleFunc
+		loopInfo["cycle"] = cycle
 		loopInfo["changed"] = changedFunc
+		if parentInfo != nil {
+			loopInfo["parent"] = parentInfo
+			loopInfo["parentloop"] = parentInfo
+		}
 
 		// Add recursive loop function if this is a recursive loop
 		if node.Recursive {
 			recursiveFunc := func(newIterable interface{}) (interface{}, error) {
+				if err := e.enterNested(recursiveLoop, recursiveLoop, node.Line()); err != nil {
+					return nil, err
+				}
+				defer e.leaveNested()
+
 				// Handle undefined or nil iterables - just return empty string
 				if IsUndefined(newIterable) || newIterable == nil {
 					return "", nil
 				}
 
-				// Create a new for node with the same structure but new iterable
+				// Create a new for node with the same structure but new
+				// iterable. The else clause belongs to the top level only:
+				// a node without children isn't an empty loop.
 				literalNode := &parser.LiteralNode{
 					Value: newIterable,
 					Raw:   fmt.Sprintf("%v", newIterable),
@@ -686,8 +1204,8 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 				recursiveNode := &parser.ForNode{
 					Variables: node.Variables,
 					Iterable:  literalNode,
+					Condition: node.Condition,
 					Body:      node.Body,
-					Else:      node.Else,
 					Recursive: true,
 				}
 				return e.EvalForNode(recursiveNode, loopCtx)
@@ -705,12 +1223,6 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 
 		result, err := e.evalNodeList(node.Body, loopCtx)
 
-		// Phase 4b optimization: Clear closure references and return map to pool
-		// Clear closures to prevent memory leaks
-		delete(loopInfo, "cycle")
-		delete(loopInfo, "changed")
-		loopInfoPool.Put(loopInfo)
-
 		if err != nil {
 			// Check if it's a loop control error
 			if loopErr, ok := err.(*LoopControlError); ok {
@@ -722,7 +1234,6 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 				}
 
 				if loopErr.IsBreak() {
-					loopBroken = true
 					break
 				} else if loopErr.IsContinue() {
 					continue
@@ -731,16 +1242,15 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 			return nil, err
 		}
 
-		if str, ok := result.(string); ok {
-			results = append(results, str)
-		} else {
-			results = append(results, ToString(result))
+		str, ok := result.(string)
+		if !ok {
+			str = ToString(result)
+		}
+		results = append(results, str)
+		outputSize += len(str)
+		if err := e.checkOutputSize(outputSize, node); err != nil {
+			return nil, err
 		}
-	}
-
-	// If loop was broken and there are no results, execute else clause
-	if loopBroken && len(results) == 0 && len(node.Else) > 0 {
-		return e.evalNodeList(node.Else, ctx)
 	}
 
 	return strings.Join(results, ""), nil
@@ -749,7 +1259,23 @@ func (e *DefaultEvaluator) EvalForNode(node *parser.ForNode, ctx Context) (inter
 func (e *DefaultEvaluator) EvalBlockNode(node *parser.BlockNode, ctx Context) (interface{}, error) {
 	// Block evaluation is handled by the template inheritance system
 	// For now, just evaluate the body
-	return e.evalNodeList(node.Body, ctx)
+	if state := renderStateOf(ctx); state != nil {
+		state.PushBlock(node.Name)
+		defer state.PopBlock()
+	}
+	if e.tracer != nil {
+		start := e.startSpan()
+		defer func() { e.tracer.OnBlock(node.Name, e.endSpan(start)) }()
+	}
+	result, err := e.evalNodeList(node.Body, ctx)
+	if err != nil || e.blockPostProcessor == nil {
+		return result, err
+	}
+	output, err := e.blockPostProcessor(node.Name, ToString(result))
+	if err != nil {
+		return nil, fmt.Errorf("post-processor of block %q: %w", node.Name, err)
+	}
+	return output, nil
 }
 
 func (e *DefaultEvaluator) EvalSetNode(node *parser.SetNode, ctx Context) (interface{}, error) {
@@ -838,15 +1364,27 @@ func (e *DefaultEvaluator) EvalBlockSetNode(node *parser.BlockSetNode, ctx Conte
 }
 
 func (e *DefaultEvaluator) EvalCallNode(node *parser.CallNode, ctx Context) (interface{}, error) {
+	return e.evalCall(node, ctx, nil)
+}
+
+// evalCall evaluates a call. A call block's caller is passed to macros by
+// keyword, as Jinja2 passes it.
+func (e *DefaultEvaluator) evalCall(node *parser.CallNode, ctx Context, caller interface{}) (interface{}, error) {
 	function, err := e.EvalNode(node.Function, ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Evaluate arguments with pre-allocated capacity
+	_, acceptsUndefined := function.(UndefinedAccepter)
 	args := make([]interface{}, 0, len(node.Arguments))
 	for _, arg := range node.Arguments {
-		argValue, err := e.EvalNode(arg, ctx)
+		var argValue interface{}
+		if acceptsUndefined {
+			argValue, err = e.evalDefaultOperand(arg, ctx)
+		} else {
+			argValue, err = e.EvalNode(arg, ctx)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -862,8 +1400,31 @@ func (e *DefaultEvaluator) EvalCallNode(node *parser.CallNode, ctx Context) (int
 		}
 		kwargs[key] = argValue
 	}
+	if _, isMacro := function.(MacroFunc); isMacro && caller != nil {
+		kwargs["caller"] = caller
+	}
 
-	return e.callFunctionWithContext(function, args, kwargs, ctx)
+	// Calling a silent undefined keeps failing below, as in Jinja2; the other
+	// modes report the call in their own way
+	if undefined, ok := function.(*Undefined); ok && undefined.Behavior != UndefinedSilent && e.undefinedHandler != nil {
+		return e.undefinedHandler.HandleFunctionCall(undefined, args, node)
+	}
+
+	if cycle, ok := function.(*LoopCycle); ok {
+		return cycle.Call(node, args...)
+	}
+
+	if coster, ok := function.(MemoryCoster); ok && e.maxMemory > 0 {
+		if err := e.chargeMemory(coster.MemoryCost(args), parser.Print(node.Function)+"()", node); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := e.callFunctionWithContext(function, args, kwargs, ctx, node)
+	if err != nil {
+		e.positionSecurityError(err, node)
+	}
+	return result, err
 }
 
 func (e *DefaultEvaluator) EvalExtendsNode(node *parser.ExtendsNode, ctx Context) (interface{}, error) {
@@ -879,64 +1440,154 @@ func (e *DefaultEvaluator) EvalIncludeNode(node *parser.IncludeNode, ctx Context
 		return nil, fmt.Errorf("error evaluating template name in include: %w", err)
 	}
 
-	templateName, ok := templateNameExpr.(string)
-	if !ok {
-		return nil, fmt.Errorf("include template name must be a string, got %T", templateNameExpr)
+	var templateName string
+	var templateAST parser.Node
+	switch target := templateNameExpr.(type) {
+	case string:
+		templateName = target
+	case IncludableTemplate:
+		if v := reflect.ValueOf(target); (v.Kind() == reflect.Ptr && v.IsNil()) || target.AST() == nil {
+			if node.IgnoreMissing {
+				return "", nil
+			}
+			return nil, fmt.Errorf("included template object has no content")
+		}
+		templateName = target.Name()
+		templateAST = target.AST()
+	default:
+		if node.IgnoreMissing && (templateNameExpr == nil || IsUndefined(templateNameExpr)) {
+			return "", nil
+		}
+		return nil, fmt.Errorf("include template must be a name or a template, got %T", templateNameExpr)
 	}
 
 	// Get the import system
-	if e.importSystem == nil {
+	if templateAST == nil && e.importSystem == nil {
 		return nil, fmt.Errorf("import system not initialized for includes")
 	}
 
-	// Check if template exists
-	if !e.importSystem.loader.TemplateExists(templateName) {
-		if node.IgnoreMissing {
-			// If ignore_missing is true, return empty string for missing templates
-			return "", nil
-		}
-		return nil, fmt.Errorf("included template %q not found", templateName)
+	if e.tracer != nil {
+		start := e.startSpan()
+		defer func() { e.tracer.OnInclude(templateName, e.endSpan(start)) }()
 	}
 
-	// Load the template AST
-	templateAST, err := e.importSystem.loader.LoadTemplate(templateName)
-	if err != nil {
-		if node.IgnoreMissing {
-			return "", nil
-		}
-		return nil, fmt.Errorf("failed to load included template %q: %w", templateName, err)
+	if len(e.includeStack) >= maxIncludeDepth && slices.Contains(e.includeStack, templateName) {
+		return nil, newCycleError("include", e.includeStack, templateName)
 	}
 
-	// Determine which context to use
-	includeCtx := ctx
-	if node.Context != nil {
-		// If a context expression is provided, evaluate it
-		contextValue, err := e.EvalNode(node.Context, ctx)
+	if templateAST == nil {
+		// Load the template AST. A template that exists but fails to parse is
+		// reported with its parse error rather than as missing.
+		loaded, err := e.importSystem.loader.LoadTemplate(templateName)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating context for include: %w", err)
-		}
-
-		// Create a new context with the provided values
-		includeCtx = ctx.Clone()
-		if contextMap, ok := contextValue.(map[string]interface{}); ok {
-			for key, value := range contextMap {
-				includeCtx.SetVariable(key, value)
+			if node.IgnoreMissing {
+				// If ignore_missing is true, return empty string for missing templates
+				return "", nil
 			}
+			return nil, fmt.Errorf("failed to load included template %q: %w", templateName, err)
 		}
+		templateAST = loaded
+	}
+
+	includeCtx, err := e.includeContext("include", node.Context, node.Assignments, node.Only, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Track the include stack for _template introspection
+	if state := renderStateOf(ctx); state != nil {
+		state.PushInclude(templateName)
+		defer state.PopInclude()
 	}
 
 	// Execute the included template with the appropriate context
+	e.includeStack = append(e.includeStack, templateName)
+	defer func() { e.includeStack = e.includeStack[:len(e.includeStack)-1] }()
+	if err := e.enterNested(FrameInclude, templateName, node.Line()); err != nil {
+		return nil, err
+	}
+	popFrame := e.pushFrame(FrameInclude, templateName, templateName, node.Line())
 	result, err := e.EvalNode(templateAST, includeCtx)
 	if err != nil {
+		e.attachCallStack(err)
+	}
+	popFrame()
+	e.leaveNested()
+	if err != nil {
+		if isCycleError(err) || isRecursionError(err) || isSecurityError(err) || isMemoryError(err) {
+			return nil, err
+		}
 		if node.IgnoreMissing {
 			return "", nil
 		}
 		return nil, fmt.Errorf("error executing included template %q: %w", templateName, err)
 	}
 
+	if node.IndentContent {
+		return indentContent(ToString(result), node.Indent), nil
+	}
 	return result, nil
 }
 
+// indentContent prefixes the lines of s after the first with indent, so that
+// output placed on an indented line stays aligned with it. Empty lines, and
+// the end of s after a final newline, are left alone.
+func indentContent(s, indent string) string {
+	if indent == "" || !strings.Contains(s, "\n") {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// includeContext returns the context a template included by an include or
+// embed tag runs in: ctx, or with variables from the tag's mapping or
+// name=value pairs layered over it. Included with "only", the template sees
+// just those variables and the environment's globals.
+func (e *DefaultEvaluator) includeContext(tag string, contextExpr parser.ExpressionNode, assignments map[string]parser.ExpressionNode, only bool, ctx Context) (Context, error) {
+	if contextExpr == nil && len(assignments) == 0 && !only {
+		return ctx, nil
+	}
+
+	variables := make(map[string]interface{}, len(assignments))
+	if contextExpr != nil {
+		// If a context expression is provided, evaluate it
+		contextValue, err := e.EvalNode(contextExpr, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating context for %s: %w", tag, err)
+		}
+		switch contextMap := contextValue.(type) {
+		case map[string]interface{}:
+			maps.Copy(variables, contextMap)
+		case *Dict:
+			maps.Copy(variables, contextMap.Map())
+		}
+	}
+	for name, expr := range assignments {
+		value, err := e.EvalNode(expr, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating %s for %s: %w", name, tag, err)
+		}
+		variables[name] = value
+	}
+
+	var includeCtx Context
+	if only {
+		includeCtx = isolatedContext(ctx)
+	} else {
+		includeCtx = ctx.Clone()
+	}
+	for key, value := range variables {
+		includeCtx.SetVariable(key, value)
+	}
+	return includeCtx, nil
+}
+
 func (e *DefaultEvaluator) EvalSuperNode(node *parser.SuperNode, ctx Context) (interface{}, error) {
 	// Super nodes are handled by the inheritance resolver during template compilation
 	// In the runtime context, they should have been replaced with parent block content
@@ -945,30 +1596,53 @@ func (e *DefaultEvaluator) EvalSuperNode(node *parser.SuperNode, ctx Context) (i
 }
 
 func (e *DefaultEvaluator) EvalMacroNode(node *parser.MacroNode, ctx Context) (interface{}, error) {
+	// Remember the defining template so the macro body reports it in _template
+	definingTemplate := e.currentTemplate()
+	if state := renderStateOf(ctx); state != nil {
+		definingTemplate = state.CurrentTemplate()
+	}
+
 	// Create a macro function that can be called with a context parameter
-	macroFunc := func(callCtx Context, args ...interface{}) (interface{}, error) {
-		// Create a new context for macro execution, inherit from the call context
-		// to get access to variables like 'caller' that might be set by call blocks
-		macroCtx := callCtx.Clone()
+	macroFunc := MacroFunc(func(callCtx Context, args []interface{}, kwargs map[string]interface{}) (result interface{}, err error) {
+		if err := e.enterNested(FrameMacro, node.Name, e.callLine); err != nil {
+			return nil, err
+		}
+		defer e.leaveNested()
+		defer e.pushFrame(FrameMacro, node.Name, definingTemplate, e.callLine)()
+		defer func() {
+			if err != nil {
+				e.attachCallStack(err)
+			}
+		}()
+
+		// As in Jinja2 the body sees the variables of the scope the macro is
+		// defined in, not those of the call: a macro called in a loop
+		// doesn't see the loop's variables or its loop object
+		macroCtx := ctx.Clone()
+
+		if state := renderStateOf(callCtx); state != nil {
+			state.PushTemplate(definingTemplate)
+			defer state.PopTemplate()
+		}
 
 		// Set up macro parameters
-		for i, paramName := range node.Parameters {
-			if i < len(args) {
-				macroCtx.SetVariable(paramName, args[i])
-			} else if defaultExpr, hasDefault := node.Defaults[paramName]; hasDefault {
-				// Evaluate default value
-				defaultVal, err := e.EvalNode(defaultExpr, ctx)
-				if err != nil {
-					return nil, fmt.Errorf("error evaluating macro parameter default: %w", err)
-				}
-				macroCtx.SetVariable(paramName, defaultVal)
-			} else {
-				return nil, fmt.Errorf("missing required macro parameter: %s", paramName)
+		err = bindMacroArguments(macroCtx, node.Name, node.Parameters, args, kwargs, func(param string) (interface{}, bool, error) {
+			defaultExpr, hasDefault := node.Defaults[param]
+			if !hasDefault {
+				return nil, false, nil
+			}
+			defaultVal, err := e.EvalNode(defaultExpr, ctx)
+			if err != nil {
+				return nil, true, fmt.Errorf("error evaluating macro parameter default: %w", err)
 			}
+			return defaultVal, true, nil
+		})
+		if err != nil {
+			return nil, err
 		}
 
 		// Execute macro body
-		result, err := e.evalNodeList(node.Body, macroCtx)
+		result, err = e.evalNodeList(node.Body, macroCtx)
 		if err != nil {
 			return nil, err
 		}
@@ -978,7 +1652,7 @@ func (e *DefaultEvaluator) EvalMacroNode(node *parser.MacroNode, ctx Context) (i
 			return "", nil
 		}
 		return ToString(result), nil
-	}
+	})
 
 	// Register the macro as a variable in the context
 	ctx.SetVariable(node.Name, macroFunc)
@@ -988,34 +1662,39 @@ func (e *DefaultEvaluator) EvalMacroNode(node *parser.MacroNode, ctx Context) (i
 }
 
 func (e *DefaultEvaluator) EvalTestNode(node *parser.TestNode, ctx Context) (interface{}, error) {
-	// Special handling for the "defined" test - don't evaluate the expression first
-	if node.TestName == "defined" {
+	// Special handling for the "defined" and "undefined" tests - don't evaluate the expression first
+	if node.TestName == "defined" || node.TestName == "undefined" {
 		var isDefined bool
 
 		// Check if it's an identifier node - most common case for "defined" test
 		if identNode, ok := node.Expression.(*parser.IdentifierNode); ok {
-			_, isDefined = ctx.GetVariable(identNode.Name)
+			value, exists := ctx.GetVariable(identNode.Name)
+			isDefined = exists && !IsUndefined(value)
 		} else {
-			// For other expression types, try to evaluate and check for undefined
-			value, err := e.EvalNode(node.Expression, ctx)
-			if err != nil {
-				// If there's an error (e.g., undefined variable), it's not defined
-				isDefined = false
-			} else {
-				// Check if the value is an Undefined type or nil
-				isDefined = !IsUndefined(value) && value != nil
-			}
+			// For other expression types, evaluate and check for undefined. A
+			// value that exists is defined even when it is none or empty; an
+			// error, e.g. from a call, makes it undefined.
+			value, err := e.peekLookup(node.Expression, ctx)
+			isDefined = err == nil && !IsUndefined(value)
 		}
 
-		result := isDefined
+		result := isDefined == (node.TestName == "defined")
 		if node.Negated {
 			result = !result
 		}
 		return result, nil
 	}
 
-	// Evaluate the expression being tested
-	value, err := e.EvalNode(node.Expression, ctx)
+	// Evaluate the expression being tested. Like default, the none and
+	// mapping tests are guards, so an undefined variable makes them false in
+	// every mode.
+	var value interface{}
+	var err error
+	if node.TestName == "none" || node.TestName == "mapping" {
+		value, err = e.evalDefaultOperand(node.Expression, ctx)
+	} else {
+		value, err = e.EvalNode(node.Expression, ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1032,11 +1711,11 @@ func (e *DefaultEvaluator) EvalTestNode(node *parser.TestNode, ctx Context) (int
 
 	// Try to use environment's test registry if available
 	var result bool
-	if envCtx, ok := ctx.(EnvironmentContext); ok {
+	if envCtx := environmentOf(ctx); envCtx != nil {
 		var testErr error
 		result, testErr = envCtx.ApplyTest(node.TestName, value, args...)
 		if testErr != nil {
-			return nil, testErr
+			return nil, withAliasPosition(testErr, node)
 		}
 	} else {
 		// Fallback to basic tests
@@ -1065,11 +1744,26 @@ func (e *DefaultEvaluator) EvalConditionalNode(node *parser.ConditionalNode, ctx
 	// Return appropriate expression based on condition
 	if e.isTruthy(condition) {
 		return e.EvalNode(node.TrueExpr, ctx)
+	} else if node.FalseExpr == nil {
+		return e.missingElse(node)
 	} else {
 		return e.EvalNode(node.FalseExpr, ctx)
 	}
 }
 
+// missingElse reports the value of a conditional expression without an else
+// whose condition is false: an undefined value, or an error in strict mode
+func (e *DefaultEvaluator) missingElse(node *parser.ConditionalNode) (interface{}, error) {
+	value, err := e.undefinedHandler.Handle(parser.Print(node), node)
+	if re, ok := err.(*RuntimeError); ok {
+		re.WithSuggestion("Add an else branch, or pass the expression to the default filter")
+	}
+	if undefined, ok := value.(*Undefined); ok && undefined.Behavior == UndefinedDebug && !e.undefinedHandler.customFactory {
+		undefined.Hint = "the condition is false and there is no else branch"
+	}
+	return value, err
+}
+
 func (e *DefaultEvaluator) EvalAssignmentNode(node *parser.AssignmentNode, ctx Context) (interface{}, error) {
 	// Evaluate the value expression
 	value, err := e.EvalNode(node.Value, ctx)
@@ -1173,10 +1867,13 @@ func (e *DefaultEvaluator) EvalComprehensionNode(node *parser.ComprehensionNode,
 	if err != nil {
 		return nil, err
 	}
+	if err := e.countIterations(len(items), node); err != nil {
+		return nil, err
+	}
 
 	if node.IsDict {
-		// Dict comprehension
-		result := make(map[string]interface{})
+		// Dict comprehension, keeping the keys in the order they are made
+		result := NewDict()
 
 		for _, item := range items {
 			// Create loop context
@@ -1204,8 +1901,7 @@ func (e *DefaultEvaluator) EvalComprehensionNode(node *parser.ComprehensionNode,
 				return nil, err
 			}
 
-			keyStr := fmt.Sprintf("%v", key)
-			result[keyStr] = value
+			result.Set(fmt.Sprintf("%v", key), value)
 		}
 
 		return result, nil
@@ -1243,6 +1939,15 @@ func (e *DefaultEvaluator) EvalComprehensionNode(node *parser.ComprehensionNode,
 }
 
 func (e *DefaultEvaluator) EvalAutoescapeNode(node *parser.AutoescapeNode, ctx Context) (interface{}, error) {
+	// A named context escapes output for it until the end of the block
+	if node.Context != "" {
+		escapeContext, err := ParseEscapeContext(node.Context)
+		if err != nil {
+			return nil, NewRuntimeError(ErrorTypeRuntime, err.Error(), node)
+		}
+		return e.evalNodeList(node.Body, NewContextWrapper(ctx, contextEscaper, escapeContext))
+	}
+
 	// Create a new context with the autoescape setting
 	// We need to modify the context to track the autoescape state
 	autoescapeCtx := &autoescapeContext{
@@ -1259,6 +1964,7 @@ func (e *DefaultEvaluator) EvalAutoescapeNode(node *parser.AutoescapeNode, ctx C
 func (e *DefaultEvaluator) evalNodeList(nodes []parser.Node, ctx Context) (interface{}, error) {
 	// Pre-allocate with exact capacity (Phase 3a optimization)
 	results := make([]string, 0, len(nodes))
+	outputSize := 0
 
 	for _, node := range nodes {
 		result, err := e.EvalNode(node, ctx)
@@ -1270,12 +1976,16 @@ func (e *DefaultEvaluator) evalNodeList(nodes []parser.Node, ctx Context) (inter
 			return nil, err
 		}
 
-		if str, ok := result.(string); ok {
-			results = append(results, str)
-		} else if result == nil {
-			results = append(results, "")
-		} else {
-			results = append(results, ToString(result))
+		var str string
+		if s, ok := result.(string); ok {
+			str = s
+		} else if result != nil {
+			str = ToString(result)
+		}
+		results = append(results, str)
+		outputSize += len(str)
+		if err := e.checkOutputSize(outputSize, node); err != nil {
+			return nil, err
 		}
 	}
 
@@ -1289,6 +1999,11 @@ func (e *DefaultEvaluator) attributeExists(obj interface{}, attr string) bool {
 	}
 
 	switch v := obj.(type) {
+	case *Dict:
+		if _, ok := v.Get(attr); ok {
+			return true
+		}
+		return dictMethod(v, attr) != nil
 	case NamespaceInterface:
 		_, ok := v.Get(attr)
 		return ok
@@ -1303,16 +2018,21 @@ func (e *DefaultEvaluator) attributeExists(obj interface{}, attr string) bool {
 		// CallableLoop supports attribute access for loop properties
 		_, ok := v.GetAttribute(attr)
 		return ok
+	case *TemplateInfo:
+		_, ok := v.GetAttribute(attr)
+		return ok
 	case map[string]interface{}:
 		// First check for actual keys in the map
 		if _, ok := v[attr]; ok {
 			return true
 		}
 		// If key doesn't exist, check for special dictionary methods
-		return attr == "items" || attr == "keys" || attr == "values"
+		return dictMethod(goMapView(v), attr) != nil || e.hasFoldedMapEntry(v, attr)
+	case *List:
+		return slices.Contains(listMethods, attr)
 	case map[string]string:
 		_, ok := v[attr]
-		return ok
+		return ok || e.hasFoldedMapEntry(v, attr)
 	default:
 		// Use reflection for struct fields and other complex types
 		rv := reflect.ValueOf(obj)
@@ -1320,6 +2040,16 @@ func (e *DefaultEvaluator) attributeExists(obj interface{}, attr string) bool {
 			return false
 		}
 
+		// Fields and methods are looked up as getAttribute does
+		if member, _ := lookupMember(obj, attr); member.IsValid() {
+			return true
+		}
+		// A list written as [...] has the List methods only to explain that
+		// they need a list()
+		if _, ok := obj.([]interface{}); ok && slices.Contains(listMethods, attr) {
+			return true
+		}
+
 		// Handle pointers
 		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
 			if rv.IsNil() {
@@ -1328,26 +2058,10 @@ func (e *DefaultEvaluator) attributeExists(obj interface{}, attr string) bool {
 			rv = rv.Elem()
 		}
 
+		// Map keys were looked up by lookupMember
 		switch rv.Kind() {
-		case reflect.Struct:
-			// Check if field exists and is accessible
-			field := rv.FieldByName(attr)
-			if !field.IsValid() {
-				return false
-			}
-			// Check if field is exported (accessible)
-			structType := rv.Type()
-			if structField, found := structType.FieldByName(attr); found {
-				return structField.PkgPath == "" // Empty PkgPath means exported
-			}
-			return false
 		case reflect.Map:
-			// Handle map types we might not have covered
-			if rv.Type().Key().Kind() == reflect.String {
-				key := reflect.ValueOf(attr)
-				return rv.MapIndex(key).IsValid()
-			}
-			return false
+			return dictMethod(mapView(rv), attr) != nil || e.hasFoldedMapEntry(rv.Interface(), attr)
 		case reflect.Slice, reflect.Array:
 			// For slices/arrays, check if attr is a valid numeric index
 			if index, err := strconv.Atoi(attr); err == nil {
@@ -1364,6 +2078,8 @@ func (e *DefaultEvaluator) attributeExists(obj interface{}, attr string) bool {
 // getObjectName tries to get a meaningful name for an object for error messages
 func (e *DefaultEvaluator) getObjectName(obj interface{}) string {
 	switch obj.(type) {
+	case *Dict:
+		return "object"
 	case NamespaceInterface:
 		return "namespace"
 	case map[string]interface{}:
@@ -1405,6 +2121,7 @@ func (e *DefaultEvaluator) getObjectName(obj interface{}) string {
 // DictItems represents a dictionary items iterator for .items() method support
 type DictItems struct {
 	data map[string]interface{}
+	dict *Dict
 }
 
 // String method for DictItems for template rendering
@@ -1412,12 +2129,146 @@ func (d *DictItems) String() string {
 	return "[dict_items]"
 }
 
+// pairs returns the key-value pairs of the dict. A Dict lists them in the
+// order its keys were added; a Go map doesn't remember that order, so its
+// keys are sorted for the order to be the same every time.
+func (d *DictItems) pairs() []interface{} {
+	if d.dict != nil {
+		return d.dict.Pairs()
+	}
+	result := make([]interface{}, 0, len(d.data))
+	for _, key := range slices.Sorted(maps.Keys(d.data)) {
+		result = append(result, []interface{}{key, d.data[key]})
+	}
+	return result
+}
+
+// dictMethod returns the method of the dict v named attr, as in Python, or
+// nil. update, pop and setdefault change v itself, so the change is seen
+// through every variable holding it, as after a loop. items, keys and values
+// list the keys in the order v.Keys gives them.
+func dictMethod(v dictView, attr string) interface{} {
+	switch attr {
+	case "items":
+		// Return a callable function that returns DictItems (Python-style behavior)
+		return func(args ...interface{}) (interface{}, error) {
+			if d, ok := v.(*Dict); ok {
+				return &DictItems{dict: d}, nil
+			}
+			return &DictItems{data: v.(goMapView)}, nil
+		}
+	case "keys":
+		// Return a callable function that returns the keys, in the order
+		// items() lists them
+		return func(args ...interface{}) (interface{}, error) {
+			keys := make([]interface{}, 0)
+			for _, k := range v.Keys() {
+				keys = append(keys, k)
+			}
+			return keys, nil
+		}
+	case "values":
+		// Return a callable function that returns the values, in the order
+		// of keys()
+		return func(args ...interface{}) (interface{}, error) {
+			values := make([]interface{}, 0)
+			for _, k := range v.Keys() {
+				val, _ := v.Get(k)
+				values = append(values, val)
+			}
+			return values, nil
+		}
+	case "get":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) == 0 || len(args) > 2 {
+				return nil, fmt.Errorf("get() takes 1 or 2 arguments (%d given)", len(args))
+			}
+			if val, ok := v.Get(fmt.Sprintf("%v", args[0])); ok {
+				return val, nil
+			}
+			if len(args) == 2 {
+				return args[1], nil
+			}
+			return nil, nil
+		}
+	case "update":
+		return OrderedKeywordsFunc(func(args []interface{}, kwargs *Dict) (interface{}, error) {
+			if len(args) > 1 {
+				return nil, fmt.Errorf("update() takes at most 1 argument (%d given)", len(args))
+			}
+			if len(args) == 1 {
+				if d, ok := v.(*Dict); ok {
+					if err := d.Update(args[0]); err != nil {
+						return nil, fmt.Errorf("update() %w", err)
+					}
+				} else if other, ok := args[0].(*Dict); ok {
+					for _, k := range other.Keys() {
+						val, _ := other.Get(k)
+						v.Set(k, val)
+					}
+				} else {
+					other := reflect.ValueOf(args[0])
+					if other.Kind() != reflect.Map {
+						return nil, fmt.Errorf("update() requires a dict, got %s", describeOperand(args[0]))
+					}
+					iter := other.MapRange()
+					for iter.Next() {
+						v.Set(fmt.Sprintf("%v", iter.Key().Interface()), iter.Value().Interface())
+					}
+				}
+			}
+			for _, key := range kwargs.Keys() {
+				val, _ := kwargs.Get(key)
+				v.Set(key, val)
+			}
+			return nil, nil
+		})
+	case "pop":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) == 0 || len(args) > 2 {
+				return nil, fmt.Errorf("pop() takes 1 or 2 arguments (%d given)", len(args))
+			}
+			key := fmt.Sprintf("%v", args[0])
+			if val, ok := v.Get(key); ok {
+				v.Delete(key)
+				return val, nil
+			}
+			if len(args) == 2 {
+				return args[1], nil
+			}
+			return nil, fmt.Errorf("pop(): key %q not found", key)
+		}
+	case "setdefault":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) == 0 || len(args) > 2 {
+				return nil, fmt.Errorf("setdefault() takes 1 or 2 arguments (%d given)", len(args))
+			}
+			key := fmt.Sprintf("%v", args[0])
+			if val, ok := v.Get(key); ok {
+				return val, nil
+			}
+			var val interface{}
+			if len(args) == 2 {
+				val = args[1]
+			}
+			v.Set(key, val)
+			return val, nil
+		}
+	}
+	return nil
+}
+
 func (e *DefaultEvaluator) getAttribute(obj interface{}, attr string) interface{} {
 	if obj == nil {
 		return nil
 	}
 
 	switch v := obj.(type) {
+	case *Dict:
+		if val, exists := v.Get(attr); exists {
+			return val
+		}
+		return dictMethod(v, attr)
 	case NamespaceInterface:
 		val, ok := v.Get(attr)
 		if !ok {
@@ -1454,81 +2305,89 @@ func (e *DefaultEvaluator) getAttribute(obj interface{}, attr string) interface{
 			return nil
 		}
 		return val
+	case *TemplateInfo:
+		val, _ := v.GetAttribute(attr)
+		return val
 	case map[string]interface{}:
 		// First check if the key exists in the map
 		if val, exists := v[attr]; exists {
 			return val
 		}
 		// If key doesn't exist, check for special dictionary methods
-		switch attr {
-		case "items":
-			// Return a callable function that returns DictItems (Python-style behavior)
-			return func(args ...interface{}) (interface{}, error) {
-				return &DictItems{data: v}, nil
-			}
-		case "keys":
-			// Return a callable function that returns the keys
-			return func(args ...interface{}) (interface{}, error) {
-				keys := make([]interface{}, 0, len(v))
-				for k := range v {
-					keys = append(keys, k)
-				}
-				return keys, nil
-			}
-		case "values":
-			// Return a callable function that returns the values
-			return func(args ...interface{}) (interface{}, error) {
-				values := make([]interface{}, 0, len(v))
-				for _, val := range v {
-					values = append(values, val)
-				}
-				return values, nil
-			}
-		default:
-			return nil
+		if method := dictMethod(goMapView(v), attr); method != nil {
+			return method
+		}
+		return e.foldedMapEntry(v, attr)
+	case *List:
+		return v.method(attr)
+	case []interface{}:
+		if slices.Contains(listMethods, attr) {
+			return immutableListMethod(attr)
+		}
+		if member, _ := lookupMember(obj, attr); member.IsValid() {
+			return member.Interface()
 		}
+		return nil
 	case map[string]string:
-		return v[attr]
+		if val, exists := v[attr]; exists {
+			return val
+		}
+		if val := e.foldedMapEntry(v, attr); val != nil {
+			return val
+		}
+		return ""
 	default:
 		// Use reflection for struct fields and methods
-		rv := reflect.ValueOf(obj)
-		if rv.Kind() == reflect.Ptr {
-			rv = rv.Elem()
+		if member, _ := lookupMember(obj, attr); member.IsValid() {
+			return member.Interface()
 		}
-
-		if rv.Kind() == reflect.Struct {
-			field := rv.FieldByName(attr)
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
-			}
-
-			// Try with capitalized field name
-			field = rv.FieldByName(capitalizeFirst(attr))
-			if field.IsValid() && field.CanInterface() {
-				return field.Interface()
+		// Other maps have the dict methods too
+		if rv := reflect.ValueOf(obj); rv.Kind() == reflect.Map && !rv.IsNil() {
+			if method := dictMethod(mapView(rv), attr); method != nil {
+				return method
 			}
+			return e.foldedMapEntry(obj, attr)
 		}
+		return nil
+	}
+}
 
-		// Try methods on the original value (including pointer receiver methods)
-		origValue := reflect.ValueOf(obj)
-		method := origValue.MethodByName(capitalizeFirst(attr))
-		if method.IsValid() {
-			return method.Interface()
-		}
+// hasFoldedMapEntry reports whether foldedMapEntry finds an entry of m
+// under name
+func (e *DefaultEvaluator) hasFoldedMapEntry(m interface{}, name string) bool {
+	return e.foldMapKeys && mapEntryFold(reflect.ValueOf(m), name).IsValid()
+}
 
+// foldedMapEntry returns the entry of the Go map m under the string key
+// equal to name ignoring case, if SetCaseInsensitiveMapKeys enabled that
+func (e *DefaultEvaluator) foldedMapEntry(m interface{}, name string) interface{} {
+	if !e.foldMapKeys {
 		return nil
 	}
+	if value := mapEntryFold(reflect.ValueOf(m), name); value.IsValid() {
+		return value.Interface()
+	}
+	return nil
 }
 
 func (e *DefaultEvaluator) getItem(obj, key interface{}) (interface{}, error) {
 	if obj == nil {
 		return nil, fmt.Errorf("cannot get item from nil")
 	}
+	if l, ok := obj.(*List); ok {
+		obj = l.Items()
+	}
 
 	switch v := obj.(type) {
 	case map[string]interface{}:
 		keyStr := fmt.Sprintf("%v", key)
-		return v[keyStr], nil
+		if value, exists := v[keyStr]; exists {
+			return value, nil
+		}
+		return e.foldedMapEntry(v, keyStr), nil
+	case *Dict:
+		value, _ := v.Get(fmt.Sprintf("%v", key))
+		return value, nil
 	case []interface{}:
 		keyInt, err := e.toInt(key)
 		if err != nil {
@@ -1548,18 +2407,21 @@ func (e *DefaultEvaluator) getItem(obj, key interface{}) (interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("string index must be integer, got %T", key)
 		}
-		// Support negative indexing like Python
-		if keyInt < 0 {
-			keyInt = len(v) + keyInt
-		}
-		if keyInt < 0 || keyInt >= len(v) {
+		char, ok := stringItem(v, keyInt)
+		if !ok {
 			// Return undefined for out of bounds access (Jinja2 behavior)
 			return NewUndefined(fmt.Sprintf("index[%d]", keyInt), UndefinedSilent, nil), nil
 		}
-		return string(v[keyInt]), nil
+		return char, nil
 	default:
 		// Try reflection for slice/array access
 		rv := reflect.ValueOf(obj)
+		if rv.Kind() == reflect.Map {
+			if value := mapItem(rv, key); value.IsValid() {
+				return value.Interface(), nil
+			}
+			return e.foldedMapEntry(obj, fmt.Sprintf("%v", key)), nil
+		}
 		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
 			keyInt, err := e.toInt(key)
 			if err != nil {
@@ -1571,13 +2433,71 @@ func (e *DefaultEvaluator) getItem(obj, key interface{}) (interface{}, error) {
 			}
 			return rv.Index(keyInt).Interface(), nil
 		}
+		if items, ok := obj.(Indexer); ok {
+			if l, ok := obj.(Lener); ok {
+				keyInt, err := e.toInt(key)
+				if err != nil {
+					return nil, fmt.Errorf("index must be integer, got %T", key)
+				}
+				if keyInt < 0 {
+					keyInt = l.Len() + keyInt
+				}
+				if keyInt < 0 || keyInt >= l.Len() {
+					return NewUndefined(fmt.Sprintf("index[%d]", keyInt), UndefinedSilent, nil), nil
+				}
+				return items.Index(keyInt), nil
+			}
+		}
+
+		return nil, fmt.Errorf("object is not subscriptable: %T", obj)
+	}
+}
+
+// applyBinaryOpWithNode applies binary operation with enhanced error reporting
+// stringItem returns the character at index i of s, counting characters
+// rather than bytes as Python does, and from the end when i is negative. It
+// walks the string, so it takes time proportional to the index, or to the
+// length of the string for negative indexes.
+func stringItem(s string, i int) (string, bool) {
+	if i < 0 {
+		i += utf8.RuneCountInString(s)
+		if i < 0 {
+			return "", false
+		}
+	}
+	for pos := range s {
+		if i == 0 {
+			_, size := utf8.DecodeRuneInString(s[pos:])
+			return s[pos : pos+size], true
+		}
+		i--
+	}
+	return "", false
+}
 
-		return nil, fmt.Errorf("object is not subscriptable: %T", obj)
+// isASCII reports whether s has only ASCII characters, whose bytes are its
+// characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
 	}
+	return true
 }
 
-// applyBinaryOpWithNode applies binary operation with enhanced error reporting
 func (e *DefaultEvaluator) applyBinaryOpWithNode(op string, left, right interface{}, node parser.Node) (interface{}, error) {
+	if IsUndefined(left) || IsUndefined(right) {
+		if result, ok, err := e.compareUndefined(op, left, right, node); ok {
+			return result, err
+		}
+	}
+
+	switch op {
+	case "+", "-", "*", "/", "//", "%", "**":
+		left, right = boolArithmeticOperands(left, right)
+	}
+
 	switch op {
 	case "+":
 		return e.addWithNode(left, right, node)
@@ -1597,14 +2517,8 @@ func (e *DefaultEvaluator) applyBinaryOpWithNode(op string, left, right interfac
 		return e.equal(left, right), nil
 	case "!=":
 		return !e.equal(left, right), nil
-	case "<":
-		return e.less(left, right)
-	case "<=":
-		return e.lessEqual(left, right)
-	case ">":
-		return e.greater(left, right)
-	case ">=":
-		return e.greaterEqual(left, right)
+	case "<", "<=", ">", ">=":
+		return e.compare(op, left, right, node)
 	case "and":
 		return e.isTruthy(left) && e.isTruthy(right), nil
 	case "or":
@@ -1648,11 +2562,11 @@ func (e *DefaultEvaluator) applyFilter(name string, value interface{}, args []in
 	case "lower":
 		return strings.ToLower(fmt.Sprintf("%v", value)), nil
 	case "capitalize":
-		s := fmt.Sprintf("%v", value)
-		if len(s) == 0 {
-			return s, nil
+		runes := []rune(fmt.Sprintf("%v", value))
+		if len(runes) == 0 {
+			return "", nil
 		}
-		return strings.ToUpper(s[:1]) + strings.ToLower(s[1:]), nil
+		return string(unicode.ToTitle(runes[0])) + strings.ToLower(string(runes[1:])), nil
 	case "trim":
 		return strings.TrimSpace(fmt.Sprintf("%v", value)), nil
 	case "length":
@@ -1661,10 +2575,13 @@ func (e *DefaultEvaluator) applyFilter(name string, value interface{}, args []in
 		if len(args) == 0 {
 			return value, nil
 		}
-		if e.isTruthy(value) {
-			return value, nil
+		if _, undefined := value.(*Undefined); undefined || value == nil {
+			return args[0], nil
+		}
+		if len(args) > 1 && e.isTruthy(args[1]) && !e.isTruthy(value) {
+			return args[0], nil
 		}
-		return args[0], nil
+		return value, nil
 	case "escape":
 		return e.htmlEscape(fmt.Sprintf("%v", value)), nil
 	case "safe":
@@ -1676,14 +2593,38 @@ func (e *DefaultEvaluator) applyFilter(name string, value interface{}, args []in
 }
 
 func (e *DefaultEvaluator) callFunction(function interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
-	return e.callFunctionWithContext(function, args, kwargs, nil)
+	return e.callFunctionWithContext(function, args, kwargs, nil, nil)
+}
+
+// callFunctionWithContext calls a function value from a template. call is the
+// call expression, used to name the function and position errors, and may be
+// nil. A panic in the function is returned as a RuntimeError.
+func (e *DefaultEvaluator) callFunctionWithContext(function interface{}, args []interface{}, kwargs map[string]interface{}, ctx Context, call *parser.CallNode) (interface{}, error) {
+	if call != nil {
+		e.callLine = call.Line()
+		defer func() { e.callLine = 0 }()
+	}
+	return callValue(function, args, kwargs, ctx, call)
+}
+
+// CallValue calls a macro, Go function or other callable template value the
+// way a call expression does, passing ctx to macros
+func CallValue(function interface{}, args []interface{}, kwargs map[string]interface{}, ctx Context) (interface{}, error) {
+	return callValue(function, args, kwargs, ctx, nil)
 }
 
-func (e *DefaultEvaluator) callFunctionWithContext(function interface{}, args []interface{}, kwargs map[string]interface{}, ctx Context) (interface{}, error) {
+func callValue(function interface{}, args []interface{}, kwargs map[string]interface{}, ctx Context, call *parser.CallNode) (result interface{}, err error) {
 	if function == nil {
 		return nil, fmt.Errorf("cannot call nil function")
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = callError(ErrorTypeRuntime, fmt.Sprintf("%s() panicked: %v", functionName(function, call), r), call)
+		}
+	}()
+
 	// Handle special callable objects
 	switch fn := function.(type) {
 	case *Joiner:
@@ -1692,10 +2633,21 @@ func (e *DefaultEvaluator) callFunctionWithContext(function interface{}, args []
 	case *CallableLoop:
 		// CallableLoop objects support function calls for recursion
 		return fn.Call(args...)
+	case *LoopCycle:
+		return fn.Call(nil, args...)
 	}
 
 	// Handle different function types
 	switch fn := function.(type) {
+	case MacroFunc:
+		if ctx == nil {
+			ctx = &simpleContext{variables: make(map[string]interface{})}
+		}
+		return fn(ctx, args, kwargs)
+
+	case OrderedKeywordsFunc:
+		return fn(args, orderedKeywords(kwargs, call))
+
 	case func(Context, ...interface{}) (interface{}, error):
 		// Macro function that takes context as first parameter
 		if ctx != nil {
@@ -1725,33 +2677,23 @@ func (e *DefaultEvaluator) callFunctionWithContext(function interface{}, args []
 		return fn()
 
 	default:
-		// Try reflection for more flexible function calls
+		// Any other Go function, including methods of context objects, is
+		// adapted by reflection
 		fnValue := reflect.ValueOf(function)
 		if fnValue.Kind() != reflect.Func {
-			return nil, fmt.Errorf("cannot call non-function value of type %T", function)
-		}
-
-		fnType := fnValue.Type()
-
-		// Check if it's a macro or other callable
-		if fnType.NumIn() == 0 && fnType.NumOut() == 2 {
-			// Simple callable with no args that returns (interface{}, error)
-			results := fnValue.Call(nil)
-			if err, ok := results[1].Interface().(error); ok && err != nil {
-				return nil, err
-			}
-			return results[0].Interface(), nil
-		} else if fnType.NumIn() == 0 && fnType.NumOut() == 1 {
-			// Simple callable with no args that returns a single value
-			results := fnValue.Call(nil)
-			return results[0].Interface(), nil
+			return nil, notCallableError(function, call)
 		}
-
-		// For now, return error for unsupported function signatures
-		return nil, fmt.Errorf("unsupported function signature: %v", fnType)
+		return callReflected(fnValue, functionName(function, call), args, kwargs, call)
 	}
 }
 
+// ApplyBuiltinTest applies the built-in test called name, with args, to
+// value, as a template rendered without an environment does. Filters called
+// directly, such as select("even"), look test names up with it.
+func ApplyBuiltinTest(name string, value interface{}, args ...interface{}) (bool, error) {
+	return NewEvaluator().applyTest(name, value, args)
+}
+
 func (e *DefaultEvaluator) applyTest(name string, value interface{}, args []interface{}) (bool, error) {
 	// This is a fallback implementation - in practice, the environment's test registry should be used
 	// For now, implement basic tests directly
@@ -1866,12 +2808,16 @@ func (e *DefaultEvaluator) applyTest(name string, value interface{}, args []inte
 		case reflect.String:
 			return true, nil // strings are sequences in Jinja2
 		default:
-			return false, nil
+			_, ok := CollectionLen(value)
+			return ok, nil
 		}
 	case "mapping":
 		if value == nil {
 			return false, nil
 		}
+		if _, ok := value.(*Dict); ok {
+			return true, nil
+		}
 		rv := reflect.ValueOf(value)
 		return rv.Kind() == reflect.Map, nil
 	case "iterable":
@@ -1883,7 +2829,8 @@ func (e *DefaultEvaluator) applyTest(name string, value interface{}, args []inte
 		case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
 			return true, nil
 		default:
-			return false, nil
+			_, ok := CollectionItems(value)
+			return ok, nil
 		}
 	case "in":
 		if len(args) != 1 {
@@ -1944,11 +2891,7 @@ func (e *DefaultEvaluator) makeIterable(obj interface{}) ([]interface{}, error)
 
 	case *DictItems:
 		// Handle DictItems objects - return key-value pairs for iteration
-		result := make([]interface{}, 0, len(v.data))
-		for key, value := range v.data {
-			result = append(result, []interface{}{key, value})
-		}
-		return result, nil
+		return v.pairs(), nil
 
 	case string:
 		result := make([]interface{}, len(v))
@@ -1958,6 +2901,10 @@ func (e *DefaultEvaluator) makeIterable(obj interface{}) ([]interface{}, error)
 		return result, nil
 	}
 
+	if items, ok := CollectionItems(obj); ok {
+		return items, nil
+	}
+
 	// Slow path: Check if obj is a function that should be called to get an iterable
 	if fnValue := reflect.ValueOf(obj); fnValue.Kind() == reflect.Func {
 		fnType := fnValue.Type()
@@ -2061,12 +3008,13 @@ func (e *DefaultEvaluator) makeIterableForVariables(obj interface{}, numVariable
 	switch v := obj.(type) {
 	case *DictItems:
 		// Handle .items() method result - always returns key-value pairs
-		// Pre-allocate with known capacity to avoid reallocations
-		result := make([]interface{}, 0, len(v.data))
-		for key, value := range v.data {
-			result = append(result, []interface{}{key, value})
+		return v.pairs(), nil
+	case *Dict:
+		// Keys for one variable, as in Python; key-value pairs for two
+		if numVariables == 2 {
+			return v.Pairs(), nil
 		}
-		return result, nil
+		return e.makeIterable(v)
 	case map[string]interface{}:
 		if numVariables == 2 {
 			// Return key-value pairs for unpacking
@@ -2094,17 +3042,26 @@ func (e *DefaultEvaluator) makeIterableForVariables(obj interface{}, numVariable
 }
 
 func (e *DefaultEvaluator) isTruthy(obj interface{}) bool {
-	if obj == nil {
+	return IsTruthy(obj)
+}
+
+// IsTruthy reports whether value holds in a condition, as in Python: none,
+// undefined values, false, zero numbers, and empty strings, markup and
+// collections are false, anything else true. Named Go types count as their
+// underlying kind, so a zero time.Duration is false, and nil pointers are
+// none.
+func IsTruthy(value interface{}) bool {
+	if value == nil {
 		return false
 	}
 
 	// Check for undefined values
-	if IsUndefined(obj) {
+	if IsUndefined(value) {
 		return false
 	}
 
 	// Fast path: direct type comparisons without reflection
-	switch v := obj.(type) {
+	switch v := value.(type) {
 	case bool:
 		return v
 	case string:
@@ -2133,20 +3090,44 @@ func (e *DefaultEvaluator) isTruthy(obj interface{}) bool {
 		return v != 0
 	case float64:
 		return v != 0
+	case json.Number:
+		f, err := v.Float64()
+		return err != nil || f != 0
 	case []interface{}:
 		return len(v) > 0
 	case map[string]interface{}:
 		return len(v) > 0
-	default:
-		// Fallback to reflection for other types
-		rv := reflect.ValueOf(obj)
-		switch rv.Kind() {
-		case reflect.Slice, reflect.Map, reflect.Array:
-			return rv.Len() > 0
-		default:
-			return true
+	}
+
+	// Markup is true when its content is
+	if content, ok := UnwrapSafe(value); ok {
+		return IsTruthy(content)
+	}
+
+	// Fallback to reflection for other types
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0
+	case reflect.Complex64, reflect.Complex128:
+		return rv.Complex() != 0
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() > 0
+	case reflect.Ptr, reflect.Interface, reflect.Chan:
+		if rv.IsNil() {
+			return false
 		}
 	}
+	if n, ok := CollectionLen(value); ok {
+		return n > 0
+	}
+	return true
 }
 
 // Arithmetic operations
@@ -2160,7 +3141,15 @@ func (e *DefaultEvaluator) addWithNode(a, b interface{}, node parser.Node) (inte
 		b = 0
 	}
 
+	// Times move by a Duration or a number of seconds
+	if result, ok := addTimes(a, b); ok {
+		return result, nil
+	}
+
 	// Try numeric addition first
+	if result, ok := largeIntegerArithmetic('+', a, b); ok {
+		return result, nil
+	}
 	aFloat, aErr := e.toFloat(a)
 	bFloat, bErr := e.toFloat(b)
 	if aErr == nil && bErr == nil {
@@ -2171,7 +3160,7 @@ func (e *DefaultEvaluator) addWithNode(a, b interface{}, node parser.Node) (inte
 	aStr, aStrOk := a.(string)
 	bStr, bStrOk := b.(string)
 	if aStrOk && bStrOk {
-		return aStr + bStr, nil
+		return e.concatStrings(aStr, bStr, node)
 	}
 
 	// Try mixed-type string concatenation (graceful handling)
@@ -2180,10 +3169,10 @@ func (e *DefaultEvaluator) addWithNode(a, b interface{}, node parser.Node) (inte
 	if !isStrictMode {
 		// If one operand is string, convert the other to string and concatenate
 		if aStrOk {
-			return aStr + ToString(b), nil
+			return e.concatStrings(aStr, ToString(b), node)
 		}
 		if bStrOk {
-			return ToString(a) + bStr, nil
+			return e.concatStrings(ToString(a), bStr, node)
 		}
 	}
 
@@ -2191,6 +3180,9 @@ func (e *DefaultEvaluator) addWithNode(a, b interface{}, node parser.Node) (inte
 	if reflect.TypeOf(a).Kind() == reflect.Slice && reflect.TypeOf(b).Kind() == reflect.Slice {
 		av := reflect.ValueOf(a)
 		bv := reflect.ValueOf(b)
+		if err := e.chargeMemory((av.Len()+bv.Len())*MemoryItemSize, "list concatenation", node); err != nil {
+			return nil, err
+		}
 		result := reflect.MakeSlice(av.Type(), 0, av.Len()+bv.Len())
 		result = reflect.AppendSlice(result, av)
 		result = reflect.AppendSlice(result, bv)
@@ -2210,7 +3202,31 @@ func (e *DefaultEvaluator) subtractWithNode(a, b interface{}, node parser.Node)
 }
 
 func (e *DefaultEvaluator) multiplyWithNode(a, b interface{}, node parser.Node) (interface{}, error) {
-	return e.multiply(a, b)
+	// Handle undefined values gracefully - treat undefined as 0 in arithmetic operations
+	if IsUndefined(a) {
+		a = 0
+	}
+	if IsUndefined(b) {
+		b = 0
+	}
+
+	if result, ok := largeIntegerArithmetic('*', a, b); ok {
+		return result, nil
+	}
+	aFloat, aErr := e.toFloat(a)
+	bFloat, bErr := e.toFloat(b)
+	if aErr == nil && bErr == nil {
+		return aFloat * bFloat, nil
+	}
+
+	// A string or sequence times an integer repeats it, either way round
+	if result, ok, err := e.repeatSequence(a, b, node); ok {
+		return result, err
+	}
+	if result, ok, err := e.repeatSequence(b, a, node); ok {
+		return result, err
+	}
+	return nil, fmt.Errorf("cannot multiply %T and %T", a, b)
 }
 
 func (e *DefaultEvaluator) moduloWithNode(a, b interface{}, node parser.Node) (interface{}, error) {
@@ -2221,8 +3237,39 @@ func (e *DefaultEvaluator) powerWithNode(a, b interface{}, node parser.Node) (in
 	return e.power(a, b)
 }
 
+// concatenateWithNode joins a and b with ~, each converted to text as
+// ToString converts it, as {{ }} outputs it: none and silent undefined
+// values are empty, booleans are true and false, and floats are rounded for
+// display
 func (e *DefaultEvaluator) concatenateWithNode(a, b interface{}, node parser.Node) (interface{}, error) {
-	return fmt.Sprintf("%v%v", a, b), nil
+	return e.concatStrings(ToString(a), ToString(b), node)
+}
+
+// concatStrings joins a and b, charging the result to the render's memory
+func (e *DefaultEvaluator) concatStrings(a, b string, node parser.Node) (interface{}, error) {
+	if err := e.chargeMemory(len(a)+len(b), "string concatenation", node); err != nil {
+		return nil, err
+	}
+	return a + b, nil
+}
+
+// concatMarkup joins a and b as Jinja2's Markup does when either is safe: the
+// other is escaped and the result is safe, so it isn't escaped again
+func concatMarkup(a, b interface{}, ctx Context) (interface{}, bool) {
+	a, aSafe := UnwrapSafe(a)
+	b, bSafe := UnwrapSafe(b)
+	if !aSafe && !bSafe {
+		return nil, false
+	}
+
+	text := func(value interface{}, safe bool) string {
+		s := ToString(value)
+		if safe {
+			return s
+		}
+		return EscapeFor(ctx, s)
+	}
+	return SafeValue{Value: text(a, aSafe) + text(b, bSafe)}, true
 }
 
 // Legacy method for existing code
@@ -2276,6 +3323,13 @@ func (e *DefaultEvaluator) subtract(a, b interface{}) (interface{}, error) {
 		b = 0
 	}
 
+	if result, ok := subtractTimes(a, b); ok {
+		return result, nil
+	}
+
+	if result, ok := largeIntegerArithmetic('-', a, b); ok {
+		return result, nil
+	}
 	aFloat, aErr := e.toFloat(a)
 	bFloat, bErr := e.toFloat(b)
 	if aErr == nil && bErr == nil {
@@ -2285,20 +3339,62 @@ func (e *DefaultEvaluator) subtract(a, b interface{}) (interface{}, error) {
 }
 
 func (e *DefaultEvaluator) multiply(a, b interface{}) (interface{}, error) {
-	// Handle undefined values gracefully - treat undefined as 0 in arithmetic operations
-	if IsUndefined(a) {
-		a = 0
+	return e.multiplyWithNode(a, b, nil)
+}
+
+// maxRepeatSize caps the bytes of a repeated string and the items of a
+// repeated sequence, so a template can't exhaust memory with "x" * 10**12
+const maxRepeatSize = 1 << 24
+
+// repeatSequence repeats a string or sequence count times, like Python's
+// "ab" * 3 or [1, 2] * 2. A count of zero or less gives an empty result. ok
+// is false unless seq is a string, slice or array and count a whole number;
+// whole floats count too, since arithmetic such as width - 2 yields floats.
+// The result is charged to the render's memory before it is built.
+func (e *DefaultEvaluator) repeatSequence(seq, count interface{}, node parser.Node) (result interface{}, ok bool, err error) {
+	n, f, isInt, isNumber := numberValue(count)
+	if !isNumber {
+		return nil, false, nil
 	}
-	if IsUndefined(b) {
-		b = 0
+	if !isInt {
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return nil, false, nil
+		}
+		// Past the limit the exact count doesn't matter
+		n = int64(math.Max(0, math.Min(f, maxRepeatSize+1)))
+	}
+	if n < 0 {
+		n = 0
 	}
 
-	aFloat, aErr := e.toFloat(a)
-	bFloat, bErr := e.toFloat(b)
-	if aErr == nil && bErr == nil {
-		return aFloat * bFloat, nil
+	if str, isString := seq.(string); isString {
+		if err := e.chargeMemory(MemorySize(len(str), int(n)), "string repetition", node); err != nil {
+			return nil, true, err
+		}
+		if n > 0 && int64(len(str)) > maxRepeatSize/n {
+			return nil, true, fmt.Errorf("repeating a string of %d bytes %d times exceeds the limit of %d bytes", len(str), n, maxRepeatSize)
+		}
+		return strings.Repeat(str, int(n)), true, nil
 	}
-	return nil, fmt.Errorf("cannot multiply %T and %T", a, b)
+
+	rv := reflect.ValueOf(seq)
+	if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return nil, false, nil
+	}
+	length := int64(rv.Len())
+	if err := e.chargeMemory(MemorySize(int(length), MemorySize(int(n), MemoryItemSize)), "sequence repetition", node); err != nil {
+		return nil, true, err
+	}
+	if n > 0 && length > maxRepeatSize/n {
+		return nil, true, fmt.Errorf("repeating a sequence of %d items %d times exceeds the limit of %d items", length, n, maxRepeatSize)
+	}
+	items := make([]interface{}, 0, length*n)
+	for i := int64(0); i < n; i++ {
+		for j := 0; j < rv.Len(); j++ {
+			items = append(items, rv.Index(j).Interface())
+		}
+	}
+	return items, true, nil
 }
 
 // Enhanced math operations with better error reporting
@@ -2383,96 +3479,153 @@ func (e *DefaultEvaluator) negate(a interface{}) (interface{}, error) {
 
 // Comparison operations
 func (e *DefaultEvaluator) equal(a, b interface{}) bool {
-	return reflect.DeepEqual(a, b)
+	return valuesEqual(a, b)
 }
 
-func (e *DefaultEvaluator) less(a, b interface{}) (bool, error) {
-	aFloat, aErr := e.toFloat(a)
-	bFloat, bErr := e.toFloat(b)
-	if aErr == nil && bErr == nil {
-		return aFloat < bFloat, nil
+// compareUndefined applies the comparison op to left and right when either
+// is undefined, as Jinja2 does: an undefined value equals only another
+// undefined value, so not none, "" or 0, and ordering an undefined value is
+// false, or an UndefinedError where undefined values are strict. ok is false
+// for operators that aren't comparisons.
+func (e *DefaultEvaluator) compareUndefined(op string, left, right interface{}, node parser.Node) (result interface{}, ok bool, err error) {
+	switch op {
+	case "==":
+		return valuesEqual(left, right), true, nil
+	case "!=":
+		return !valuesEqual(left, right), true, nil
+	case "<", "<=", ">", ">=":
+		undefined, isUndefined := left.(*Undefined)
+		if !isUndefined {
+			undefined = right.(*Undefined)
+		}
+		if undefined.Behavior == UndefinedStrict || (e.undefinedHandler != nil && e.undefinedHandler.GetUndefinedBehavior() == UndefinedStrict) {
+			at := undefined.Node
+			if at == nil {
+				at = node
+			}
+			return nil, true, NewRuntimeError(ErrorTypeUndefined, fmt.Sprintf("'%s' not supported with undefined value %s", op, undefined.Name), at)
+		}
+		return false, true, nil
 	}
+	return nil, false, nil
+}
 
-	// String comparison
-	if aStr, ok := a.(string); ok {
-		if bStr, ok := b.(string); ok {
-			return aStr < bStr, nil
+// valuesEqual implements ==. Undefined values equal each other and nothing
+// else.
+func valuesEqual(a, b interface{}) bool {
+	if aUndefined, bUndefined := IsUndefined(a), IsUndefined(b); aUndefined || bUndefined {
+		return aUndefined && bUndefined
+	}
+	// The same instant in different locations is equal
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Equal(bt)
 		}
 	}
-
-	return false, fmt.Errorf("cannot compare %T and %T", a, b)
+	// Numbers compare by value whatever their type
+	if equal, ok := equalNumbers(a, b); ok {
+		return equal
+	}
+	// A List equals a list of the same items
+	if l, ok := a.(*List); ok {
+		a = l.Items()
+	}
+	if l, ok := b.(*List); ok {
+		b = l.Items()
+	}
+	// A Dict equals a dict of the same entries, in any order
+	if d, ok := a.(*Dict); ok {
+		a = d.Map()
+	}
+	if d, ok := b.(*Dict); ok {
+		b = d.Map()
+	}
+	return reflect.DeepEqual(a, b)
 }
 
-func (e *DefaultEvaluator) lessEqual(a, b interface{}) (bool, error) {
-	less, err := e.less(a, b)
-	if err != nil {
-		return false, err
+// compare implements <, <=, > and >=. Numbers compare by value whatever
+// their Go type, and a number or numeric string compares with a string that
+// parses cleanly as a number numerically, so "9" < 10 as read from a form.
+// Other strings compare lexicographically, and times and durations
+// chronologically. Values that can't be ordered, such as "abc" and 10, are
+// never compared as text: the comparison is a TypeError in strict undefined
+// mode and false otherwise.
+func (e *DefaultEvaluator) compare(op string, a, b interface{}, node parser.Node) (bool, error) {
+	c, ok := orderValues(a, b)
+	if !ok {
+		if e.undefinedHandler != nil && e.undefinedHandler.GetUndefinedBehavior() == UndefinedStrict {
+			return false, NewRuntimeError(ErrorTypeType, fmt.Sprintf("'%s' not supported between %s and %s", op, describeOperand(a), describeOperand(b)), node).
+				WithSuggestion("Convert the value first, e.g. with the int or float filter and a default: value|int(0)")
+		}
+		return false, nil
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
 	}
-	return less || e.equal(a, b), nil
 }
 
-func (e *DefaultEvaluator) greater(a, b interface{}) (bool, error) {
-	lessEq, err := e.lessEqual(a, b)
-	if err != nil {
-		return false, err
+// orderValues returns -1, 0 or 1 as a is less than, equal to or greater
+// than b, as described by compare. ok is false when they can't be ordered.
+func orderValues(a, b interface{}) (int, bool) {
+	a, b = unwrapSafeString(a), unwrapSafeString(b)
+	if less, ok := lessTimes(a, b); ok {
+		if less {
+			return -1, true
+		}
+		greater, _ := lessTimes(b, a)
+		if greater {
+			return 1, true
+		}
+		return 0, true
+	}
+	if c, ok := compareNumbers(a, b); ok {
+		return c, true
+	}
+	if aStr, ok := a.(string); ok {
+		if bStr, ok := b.(string); ok {
+			return strings.Compare(aStr, bStr), true
+		}
 	}
-	return !lessEq, nil
+	return 0, false
 }
 
-func (e *DefaultEvaluator) greaterEqual(a, b interface{}) (bool, error) {
-	less, err := e.less(a, b)
-	if err != nil {
-		return false, err
+// unwrapSafeString returns the string held by a safe value, and any other
+// value unchanged
+func unwrapSafeString(value interface{}) interface{} {
+	switch v := value.(type) {
+	case SafeString:
+		return string(v)
+	case SafeValue:
+		if s, ok := v.Value.(string); ok {
+			return s
+		}
 	}
-	return !less, nil
+	return value
 }
 
-func (e *DefaultEvaluator) contains(container, item interface{}) (bool, error) {
-	switch v := container.(type) {
+// describeOperand names the type of a comparison operand, quoting strings so
+// the value that failed to parse as a number is visible
+func describeOperand(value interface{}) string {
+	switch v := value.(type) {
 	case string:
-		itemStr := e.fastToString(item)
-		return strings.Contains(v, itemStr), nil
-	case []interface{}:
-		for _, elem := range v {
-			if e.equal(elem, item) {
-				return true, nil
-			}
-		}
-		return false, nil
-	case map[string]interface{}:
-		itemStr := e.fastToString(item)
-		_, ok := v[itemStr]
-		return ok, nil
-	default:
-		// Use reflection to handle any slice/array type
-		rv := reflect.ValueOf(container)
-		switch rv.Kind() {
-		case reflect.Slice, reflect.Array:
-			for i := 0; i < rv.Len(); i++ {
-				elem := rv.Index(i).Interface()
-				if e.equal(elem, item) {
-					return true, nil
-				}
-			}
-			return false, nil
-		case reflect.Map:
-			// Handle any map type
-			keys := rv.MapKeys()
-			itemValue := reflect.ValueOf(item)
-			for _, key := range keys {
-				if reflect.DeepEqual(key.Interface(), item) {
-					return true, nil
-				}
-			}
-			// Also check if item can be used as a key directly
-			if itemValue.IsValid() && itemValue.Type().AssignableTo(rv.Type().Key()) {
-				return rv.MapIndex(itemValue).IsValid(), nil
-			}
-			return false, nil
-		default:
-			return false, fmt.Errorf("cannot check containment in %T", container)
-		}
+		return fmt.Sprintf("string %q", v)
+	case nil:
+		return "none"
+	case *Undefined:
+		return "undefined"
 	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (e *DefaultEvaluator) contains(container, item interface{}) (bool, error) {
+	return Contains(container, item)
 }
 
 // fastToString converts a value to string without fmt.Sprintf overhead
@@ -2505,6 +3658,13 @@ func (e *DefaultEvaluator) deepEqual(a, b interface{}) bool {
 		return false
 	}
 
+	if d, ok := a.(*Dict); ok {
+		a = d.Map()
+	}
+	if d, ok := b.(*Dict); ok {
+		b = d.Map()
+	}
+
 	// For basic types, use standard equality
 	if reflect.TypeOf(a) != reflect.TypeOf(b) {
 		return false
@@ -2559,6 +3719,9 @@ func (e *DefaultEvaluator) length(obj interface{}) (int, error) {
 		case reflect.Slice, reflect.Array, reflect.Map:
 			return rv.Len(), nil
 		default:
+			if n, ok := CollectionLen(obj); ok {
+				return n, nil
+			}
 			return 0, fmt.Errorf("object has no length: %T", obj)
 		}
 	}
@@ -2612,6 +3775,8 @@ func (e *DefaultEvaluator) toInt(obj interface{}) (int, error) {
 		return int(v), nil
 	case float64:
 		return int(v), nil
+	case json.Number:
+		return jsonNumberToInt(v)
 	case string:
 		return strconv.Atoi(v)
 	default:
@@ -2627,6 +3792,8 @@ func (e *DefaultEvaluator) toFloat(obj interface{}) (float64, error) {
 		return float64(v), nil
 	case float64:
 		return v, nil
+	case json.Number:
+		return v.Float64()
 	case string:
 		return strconv.ParseFloat(v, 64)
 	default:
@@ -2647,6 +3814,9 @@ func (e *DefaultEvaluator) setAttribute(obj interface{}, attr string, value inte
 	case map[string]interface{}:
 		v[attr] = value
 		return nil
+	case *Dict:
+		v.Set(attr, value)
+		return nil
 	}
 
 	rv := reflect.ValueOf(obj)
@@ -2686,6 +3856,9 @@ func (e *DefaultEvaluator) setItem(obj, key, value interface{}) error {
 		keyStr := fmt.Sprintf("%v", key)
 		v[keyStr] = value
 		return nil
+	case *Dict:
+		v.Set(fmt.Sprintf("%v", key), value)
+		return nil
 	case []interface{}:
 		keyInt, err := e.toInt(key)
 		if err != nil {
@@ -2730,6 +3903,9 @@ func (e *DefaultEvaluator) slice(obj interface{}, start, end, step *int) (interf
 	if obj == nil {
 		return nil, fmt.Errorf("cannot slice nil")
 	}
+	if l, ok := obj.(*List); ok {
+		obj = l.Items()
+	}
 
 	switch v := obj.(type) {
 	case string:
@@ -2747,8 +3923,16 @@ func (e *DefaultEvaluator) slice(obj interface{}, start, end, step *int) (interf
 	}
 }
 
+// sliceString slices s by character, as Python does. Strings that aren't
+// ASCII are converted to runes first, so slicing them takes time
+// proportional to their length.
 func (e *DefaultEvaluator) sliceString(s string, start, end, step *int) string {
+	var runes []rune
 	length := len(s)
+	if !isASCII(s) {
+		runes = []rune(s)
+		length = len(runes)
+	}
 
 	// Default values
 	startVal := 0
@@ -2790,25 +3974,36 @@ func (e *DefaultEvaluator) sliceString(s string, start, end, step *int) string {
 		if startVal >= endVal {
 			return ""
 		}
+		if runes != nil {
+			return string(runes[startVal:endVal])
+		}
 		return s[startVal:endVal]
 	}
 
 	// Step-wise slicing
 	var result strings.Builder
+	write := func(i int) {
+		if runes != nil {
+			result.WriteRune(runes[i])
+		} else {
+			result.WriteByte(s[i])
+		}
+	}
 	if stepVal > 0 {
 		for i := startVal; i < endVal; i += stepVal {
-			result.WriteByte(s[i])
+			write(i)
 		}
 	} else {
-		// Negative step
-		if start == nil {
+		// Negative step: past the end starts at the last item, and
+		// before the start ends after the first, as in Python
+		if start == nil || startVal >= length {
 			startVal = length - 1
 		}
-		if end == nil {
+		if end == nil || *end < -length {
 			endVal = -1
 		}
 		for i := startVal; i > endVal; i += stepVal {
-			result.WriteByte(s[i])
+			write(i)
 		}
 	}
 
@@ -2868,11 +4063,12 @@ func (e *DefaultEvaluator) sliceSlice(s []interface{}, start, end, step *int) []
 			result = append(result, s[i])
 		}
 	} else {
-		// Negative step
-		if start == nil {
+		// Negative step: past the end starts at the last item, and
+		// before the start ends after the first, as in Python
+		if start == nil || startVal >= length {
 			startVal = length - 1
 		}
-		if end == nil {
+		if end == nil || *end < -length {
 			endVal = -1
 		}
 		for i := startVal; i > endVal; i += stepVal {
@@ -2930,11 +4126,12 @@ func (e *DefaultEvaluator) sliceReflect(rv reflect.Value, start, end, step *int)
 			result = reflect.Append(result, rv.Index(i))
 		}
 	} else {
-		// Negative step
-		if start == nil {
+		// Negative step: past the end starts at the last item, and
+		// before the start ends after the first, as in Python
+		if start == nil || startVal >= length {
 			startVal = length - 1
 		}
-		if end == nil {
+		if end == nil || *end < -length {
 			endVal = -1
 		}
 		for i := startVal; i > endVal; i += stepVal {
@@ -2962,6 +4159,82 @@ type AutoescapeContext interface {
 	IsAutoescapeEnabled() bool
 }
 
+// SafeFilterContext is implemented by environment contexts that register
+// filters producing HTML, whose output must not be escaped again
+type SafeFilterContext interface {
+	IsSafeFilter(name string) bool
+}
+
+// FilterLookupContext is implemented by environment contexts that can report
+// whether a filter is registered
+type FilterLookupContext interface {
+	HasFilter(name string) bool
+}
+
+// FilterGenerationContext is implemented by environment contexts that count
+// changes to their filters, so what is resolved against the filters can be
+// cached until the count changes
+type FilterGenerationContext interface {
+	FilterGeneration() uint64
+}
+
+// KeywordFilterContext is implemented by environment contexts whose filters
+// may take keyword arguments and the context they are applied in
+type KeywordFilterContext interface {
+	ApplyFilterWithContext(ctx Context, name string, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+}
+
+// EnvironmentOf returns the EnvironmentContext ctx belongs to, or nil, for
+// filters applying the tests and filters their arguments name
+func EnvironmentOf(ctx Context) EnvironmentContext {
+	return environmentOf(ctx)
+}
+
+// environmentOf finds the EnvironmentContext under the runtime's own context
+// wrappers, so filters and tests resolve inside autoescape blocks too
+func environmentOf(ctx Context) EnvironmentContext {
+	for ctx != nil {
+		switch c := ctx.(type) {
+		case EnvironmentContext:
+			return c
+		case *autoescapeContext:
+			ctx = c.Context
+		case *ContextWrapper:
+			ctx = c.Context
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// contextEscaper escapes output in the escape contexts named by
+// {% autoescape %}
+var contextEscaper = NewAutoEscaper(nil)
+
+// AutoescapeEnabled reports whether output is escaped in ctx, following the
+// same rules as variable output
+func AutoescapeEnabled(ctx Context) bool {
+	if contextWrapper, ok := ctx.(ContextAwareContext); ok && contextWrapper.GetAutoEscaper() != nil {
+		switch contextWrapper.GetEscapeContext() {
+		case EscapeContextNone, EscapeContextText:
+			return false
+		}
+		return contextWrapper.GetAutoEscaper().config.Enabled
+	}
+	autoCtx, ok := ctx.(AutoescapeContext)
+	return ok && autoCtx.IsAutoescapeEnabled()
+}
+
+// EscapeFor escapes s as output is escaped in ctx, for building a safe result
+// from text that isn't
+func EscapeFor(ctx Context, s string) string {
+	if contextWrapper, ok := ctx.(ContextAwareContext); ok && contextWrapper.GetAutoEscaper() != nil {
+		return contextWrapper.GetAutoEscaper().Escape(s, contextWrapper.GetEscapeContext())
+	}
+	return html.EscapeString(s)
+}
+
 // EvalExtensionNode evaluates extension nodes
 func (e *DefaultEvaluator) EvalExtensionNode(node *parser.ExtensionNode, ctx Context) (interface{}, error) {
 	if node.EvaluateFunc == nil {
@@ -3003,17 +4276,16 @@ func (e *DefaultEvaluator) EvalCallBlockNode(node *parser.CallBlockNode, ctx Con
 		return blockStr, nil
 	}
 
-	// Create a new context that includes the caller function
+	// Create a new context that includes the caller function, for Go
+	// functions; macros are passed it by keyword
 	callCtx := ctx.Clone()
 	callCtx.SetVariable("caller", callerFunc)
 
 	// Evaluate the call expression with the extended context
-	result, err := e.EvalNode(node.Call, callCtx)
-	if err != nil {
-		return nil, err
+	if call, ok := node.Call.(*parser.CallNode); ok {
+		return e.evalCall(call, callCtx, callerFunc)
 	}
-
-	return result, nil
+	return e.EvalNode(node.Call, callCtx)
 }
 
 // EvalImportNode evaluates import statements ({% import 'template' as name %})
@@ -3031,13 +4303,14 @@ func (e *DefaultEvaluator) EvalImportNode(node *parser.ImportNode, ctx Context)
 
 	// Use the new import system if available
 	if e.importSystem != nil {
-		namespace, err := e.importSystem.LoadTemplateNamespace(templateName, ctx, e)
+		namespace, err := e.loadNamespace(templateName, node.WithContext, ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error loading template %q: %w", templateName, err)
+			return nil, err
 		}
 
 		// Create an ImportedNamespace wrapper
 		importedNS := e.importSystem.GetImportedNamespace(namespace, e)
+		importedNS.callCtx = ctx
 		ctx.SetVariable(node.Alias, importedNS)
 	} else {
 		// Fallback to the old placeholder system
@@ -3051,6 +4324,25 @@ func (e *DefaultEvaluator) EvalImportNode(node *parser.ImportNode, ctx Context)
 	return "", nil // Import statements don't produce output
 }
 
+// loadNamespace loads the namespace of an imported template. Without context
+// it is shared across renders; with context it sees the importer's variables.
+func (e *DefaultEvaluator) loadNamespace(templateName string, withContext bool, ctx Context) (*TemplateNamespace, error) {
+	var namespace *TemplateNamespace
+	var err error
+	if withContext {
+		namespace, err = e.importSystem.LoadTemplateNamespaceWithContext(templateName, ctx, e)
+	} else {
+		namespace, err = e.importSystem.LoadTemplateNamespace(templateName, ctx, e)
+	}
+	if err != nil {
+		if isCycleError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error loading template %q: %w", templateName, err)
+	}
+	return namespace, nil
+}
+
 // EvalFromNode evaluates from-import statements ({% from 'template' import name1, name2 %})
 func (e *DefaultEvaluator) EvalFromNode(node *parser.FromNode, ctx Context) (interface{}, error) {
 
@@ -3069,11 +4361,16 @@ func (e *DefaultEvaluator) EvalFromNode(node *parser.FromNode, ctx Context) (int
 
 	// Use the new import system if available
 	if e.importSystem != nil {
-		namespace, err := e.importSystem.LoadTemplateNamespace(templateName, ctx, e)
+		namespace, err := e.loadNamespace(templateName, node.WithContext, ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error loading template %q: %w", templateName, err)
+			return nil, err
 		}
-		namespaceMap = e.importSystem.GetNamespaceMap(namespace, e)
+		for _, name := range node.Names {
+			if err := namespace.checkImport(name); err != nil {
+				return nil, err
+			}
+		}
+		namespaceMap = e.importSystem.namespaceMap(namespace, e, ctx)
 	} else {
 		// Fallback to the old placeholder system
 		templateNamespace, err := e.loadTemplateNamespace(templateName, ctx)
@@ -3215,12 +4512,15 @@ func (e *DefaultEvaluator) EvalFilterBlockNode(node *parser.FilterBlockNode, ctx
 		// Evaluate the filter
 		result, err := e.EvalFilterNode(filterNode, ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error applying filter '%s' in filter block: %v", filter.FilterName, err)
+			return nil, fmt.Errorf("error applying filter '%s' in filter block: %w", filter.FilterName, err)
 		}
 
 		// Convert result back to string for the next filter in chain
 		currentContent = ToString(result)
 	}
 
+	if node.IndentContent {
+		return indentContent(currentContent, node.Indent), nil
+	}
 	return currentContent, nil
 }
@@ -825,10 +825,11 @@ func TestEvalComprehensionNode(t *testing.T) {
 		if err != nil {
 			t.Fatalf("EvalComprehensionNode dict failed: %v", err)
 		}
-		resultMap, ok := result.(map[string]interface{})
+		resultDict, ok := result.(*Dict)
 		if !ok {
-			t.Fatalf("result should be map, got %T", result)
+			t.Fatalf("result should be a Dict, got %T", result)
 		}
+		resultMap := resultDict.Map()
 		if len(resultMap) != 2 {
 			t.Errorf("result length = %d, want 2", len(resultMap))
 		}
@@ -859,10 +860,11 @@ func TestEvalComprehensionNode(t *testing.T) {
 		if err != nil {
 			t.Fatalf("EvalComprehensionNode dict with condition failed: %v", err)
 		}
-		resultMap, ok := result.(map[string]interface{})
+		resultDict, ok := result.(*Dict)
 		if !ok {
-			t.Fatalf("result should be map, got %T", result)
+			t.Fatalf("result should be a Dict, got %T", result)
 		}
+		resultMap := resultDict.Map()
 		if len(resultMap) != 2 {
 			t.Errorf("result length = %d, want 2", len(resultMap))
 		}
@@ -1600,6 +1602,24 @@ func TestContainsCoverage(t *testing.T) {
 			t.Error("expected true for 2 in [3]int")
 		}
 	})
+
+	t.Run("undefined operands", func(t *testing.T) {
+		for _, behavior := range []UndefinedBehavior{UndefinedSilent, UndefinedDebug, UndefinedChainFail} {
+			undefined := NewUndefined("x", behavior, nil)
+			if result, err := e.contains(undefined, "a"); result || err != nil {
+				t.Errorf("behavior %v: \"a\" in undefined = %v, %v; want false", behavior, result, err)
+			}
+			if result, err := e.contains([]string{"a"}, undefined); result || err != nil {
+				t.Errorf("behavior %v: undefined in list = %v, %v; want false", behavior, result, err)
+			}
+		}
+		if _, err := e.contains(NewStrictUndefined("x", nil), "a"); !IsUndefinedError(err) {
+			t.Errorf("expected an undefined error for a strict container, got %v", err)
+		}
+		if _, err := e.contains([]string{"a"}, NewStrictUndefined("x", nil)); !IsUndefinedError(err) {
+			t.Errorf("expected an undefined error for a strict item, got %v", err)
+		}
+	})
 }
 
 // TestSetAttributeCoverage tests setAttribute with additional cases
@@ -23,6 +23,11 @@ func (c *MockContext) GetVariable(key string) (interface{}, bool) {
 	return val, ok
 }
 
+func (c *MockContext) HasVariable(key string) bool {
+	_, exists := c.data[key]
+	return exists
+}
+
 func (c *MockContext) SetVariable(key string, value interface{}) {
 	c.data[key] = value
 }
@@ -256,7 +261,9 @@ func TestEvaluatorFilterNode(t *testing.T) {
 		{"capitalize filter", "jinja", "capitalize", nil, "Jinja", false},
 		{"trim filter", "  spaced  ", "trim", nil, "spaced", false},
 		{"default filter with value", "exists", "default", []interface{}{"fallback"}, "exists", false},
-		{"default filter without value", "", "default", []interface{}{"fallback"}, "fallback", false},
+		{"default filter without value", nil, "default", []interface{}{"fallback"}, "fallback", false},
+		{"default filter keeps empty string", "", "default", []interface{}{"fallback"}, "", false},
+		{"default filter boolean empty string", "", "default", []interface{}{"fallback", true}, "fallback", false},
 		{"length filter string", "hello", "length", nil, 5, false},
 		{"length filter slice", []interface{}{1, 2, 3}, "length", nil, 3, false},
 		{"unknown filter", "test", "unknown", nil, nil, true},
@@ -0,0 +1,96 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// expressionFilters evaluate an expression, given as a string, for each item
+// of their input, with the item bound to item and its 1-based position to
+// index. selectexpr keeps the items for which it is true, rejectexpr those for
+// which it is false and mapexpr replaces each item with its value:
+//
+//	{{ users|selectexpr("item.age > 30 and item.active") }}
+//
+// They are applied by the evaluator, which they need to evaluate the
+// expression, unless a filter of the same name is registered.
+var expressionFilters = map[string]bool{
+	"selectexpr": true,
+	"rejectexpr": true,
+	"mapexpr":    true,
+}
+
+// applyExpressionFilter applies the expression filter named by node. The
+// expression is parsed once per call, and its errors are reported at node.
+func (e *DefaultEvaluator) applyExpressionFilter(node *parser.FilterNode, value interface{}, args []interface{}, ctx Context) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, NewFilterError(node.FilterName, fmt.Errorf("requires one argument, the expression, got %d", len(args)), node)
+	}
+	source, ok := args[0].(string)
+	if !ok {
+		return nil, NewFilterError(node.FilterName, fmt.Errorf("the expression must be a string, not %T", args[0]), node)
+	}
+	expr, err := parser.ParseExpression(source)
+	if err != nil {
+		return nil, NewFilterError(node.FilterName, err, node)
+	}
+
+	items, err := e.makeIterable(value)
+	if err != nil {
+		return nil, NewFilterError(node.FilterName, err, node)
+	}
+	if err := e.countIterations(len(items), node); err != nil {
+		return nil, err
+	}
+
+	scope := ctx.Clone()
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		scope.SetVariable("item", item)
+		scope.SetVariable("index", i+1)
+		v, err := e.EvalNode(expr, scope)
+		if isSecurityError(err) || isMemoryError(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, NewFilterError(node.FilterName, fmt.Errorf("%q for item %d: %w", source, i+1, err), node)
+		}
+		switch node.FilterName {
+		case "selectexpr":
+			if e.isTruthy(v) {
+				result = append(result, item)
+			}
+		case "rejectexpr":
+			if !e.isTruthy(v) {
+				result = append(result, item)
+			}
+		default:
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// filterRegistration is what the environment of a context reports about a
+// filter name
+type filterRegistration int
+
+const (
+	filterUnknown      filterRegistration = iota // no environment that can tell
+	filterRegistered                             // a filter is registered as the name
+	filterUnregistered                           // no filter is registered as the name
+)
+
+// lookupFilter reports whether the environment of ctx has a filter
+// registered as name
+func lookupFilter(ctx Context, name string) filterRegistration {
+	lookup, ok := environmentOf(ctx).(FilterLookupContext)
+	switch {
+	case !ok:
+		return filterUnknown
+	case lookup.HasFilter(name):
+		return filterRegistered
+	}
+	return filterUnregistered
+}
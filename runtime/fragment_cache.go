@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// FragmentCache stores the output of {% cache %} blocks. Implementations must
+// be safe for concurrent use.
+type FragmentCache interface {
+	// Get returns the stored output for key, if present and not expired
+	Get(key string) (string, bool)
+	// Set stores output for key; a zero ttl means no expiry
+	Set(key string, value string, ttl time.Duration)
+	// DeletePrefix removes every entry whose key starts with prefix
+	DeletePrefix(prefix string)
+}
+
+// SetFragmentCache sets the store used by cache blocks; nil renders them uncached
+func (e *DefaultEvaluator) SetFragmentCache(cache FragmentCache) {
+	e.fragmentCache = cache
+}
+
+// EvalCacheNode evaluates fragment cache blocks ({% cache key %}...{% endcache %}).
+// The store key is the evaluated key expression followed by the block's
+// template and position, so stores can invalidate entries by key prefix.
+func (e *DefaultEvaluator) EvalCacheNode(node *parser.CacheNode, ctx Context) (interface{}, error) {
+	if e.fragmentCache == nil {
+		return e.evalNodeList(node.Body, ctx)
+	}
+
+	keyValue, err := e.EvalNode(node.Key, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating cache key: %w", err)
+	}
+	var ttl time.Duration
+	if node.Timeout != nil {
+		timeout, err := e.EvalNode(node.Timeout, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating cache timeout: %w", err)
+		}
+		if ttl, err = cacheTimeout(timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	key := fmt.Sprintf("%s|%s:%d:%d", cacheKeyString(keyValue), node.Scope, node.Line(), node.Column())
+	if cached, ok := e.fragmentCache.Get(key); ok {
+		return cached, nil
+	}
+
+	result, err := e.evalNodeList(node.Body, ctx)
+	if err != nil {
+		return nil, err
+	}
+	output := ToString(result)
+	e.fragmentCache.Set(key, output, ttl)
+	return output, nil
+}
+
+// cacheKeyString joins list keys such as ["product", id] with colons
+func cacheKeyString(value interface{}) string {
+	if items, ok := value.([]interface{}); ok {
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = ToString(item)
+		}
+		return strings.Join(parts, ":")
+	}
+	return ToString(value)
+}
+
+// cacheTimeout converts a timeout given in seconds or as a duration string
+// such as "5m" to a duration
+func cacheTimeout(value interface{}) (time.Duration, error) {
+	var ttl time.Duration
+	switch v := value.(type) {
+	case time.Duration:
+		ttl = v
+	case int:
+		ttl = time.Duration(v) * time.Second
+	case int64:
+		ttl = time.Duration(v) * time.Second
+	case float64:
+		ttl = time.Duration(v * float64(time.Second))
+	case string:
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			ttl = time.Duration(seconds * float64(time.Second))
+		} else if ttl, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("invalid cache timeout %q: expected seconds or a duration such as \"5m\"", v)
+		}
+	default:
+		return 0, fmt.Errorf("invalid cache timeout of type %T: expected seconds or a duration string", value)
+	}
+
+	if ttl < 0 {
+		return 0, fmt.Errorf("cache timeout must not be negative, got %v", value)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,359 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/zipreport/miya/parser"
+)
+
+var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+
+// ErrFunctionValue is returned by filters given a function where they need
+// text, usually because the parentheses calling it were left out
+var ErrFunctionValue = errors.New("cannot convert function to string")
+
+// IsFunction reports whether value is a Go function or macro, as opposed to
+// the value calling it returns
+func IsFunction(value interface{}) bool {
+	return value != nil && reflect.ValueOf(value).Kind() == reflect.Func
+}
+
+// MacroFunc is a macro as a template value. Calling it binds the call's
+// positional and keyword arguments to the macro's parameters; ctx is the
+// context it is called from.
+type MacroFunc func(ctx Context, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// OrderedKeywordsFunc is a Go function given the keyword arguments of a call
+// as a Dict, in the order the call writes them, such as the dict() global
+type OrderedKeywordsFunc func(args []interface{}, kwargs *Dict) (interface{}, error)
+
+// orderedKeywords returns kwargs as a Dict in the order call writes them.
+// Keywords the call doesn't write, and all of them without a call, follow in
+// name order.
+func orderedKeywords(kwargs map[string]interface{}, call *parser.CallNode) *Dict {
+	d := NewDict()
+	if call != nil {
+		for _, name := range call.KeywordNames() {
+			if value, ok := kwargs[name]; ok {
+				d.Set(name, value)
+			}
+		}
+	}
+	if d.Len() < len(kwargs) {
+		for _, name := range slices.Sorted(maps.Keys(kwargs)) {
+			if _, ok := d.Get(name); !ok {
+				d.Set(name, kwargs[name])
+			}
+		}
+	}
+	return d
+}
+
+// bindMacroArguments sets the parameters of the macro called name in
+// macroCtx as Jinja2 binds them: positional arguments in order, then keyword
+// arguments by name, then the defaults defaultOf returns. Keyword arguments
+// that name no parameter are set as variables. The caller a call block
+// passes by keyword is bound to caller; without one, caller is undefined, so
+// the macro can test "caller is defined" to make its block optional, and
+// calling it fails saying why.
+func bindMacroArguments(macroCtx Context, name string, params []string, args []interface{}, kwargs map[string]interface{}, defaultOf func(param string) (interface{}, bool, error)) error {
+	for i, param := range params {
+		value, byKeyword := kwargs[param]
+		switch {
+		case i < len(args) && byKeyword:
+			return fmt.Errorf("macro '%s' got multiple values for argument '%s'", name, param)
+		case i < len(args):
+			value = args[i]
+		case !byKeyword:
+			defaultValue, hasDefault, err := defaultOf(param)
+			if err != nil {
+				return err
+			}
+			if !hasDefault {
+				return fmt.Errorf("missing required macro parameter: %s", param)
+			}
+			value = defaultValue
+		}
+		macroCtx.SetVariable(param, value)
+	}
+
+	for key, value := range kwargs {
+		if key != "caller" && !slices.Contains(params, key) {
+			macroCtx.SetVariable(key, value)
+		}
+	}
+	if !slices.Contains(params, "caller") {
+		caller, ok := kwargs["caller"]
+		if !ok {
+			caller = &Undefined{Name: "caller", Hint: fmt.Sprintf("macro '%s' was called without a call block", name)}
+		}
+		macroCtx.SetVariable("caller", caller)
+	}
+	return nil
+}
+
+// calleeName returns the source of expr when it names a variable or an
+// attribute, for error messages, or "" for other expressions
+func calleeName(expr parser.Node) string {
+	switch expr.(type) {
+	case *parser.IdentifierNode, *parser.AttributeNode:
+		return parser.Print(expr)
+	}
+	return ""
+}
+
+// notCallableError reports a call of a value that isn't a function
+func notCallableError(function interface{}, call *parser.CallNode) error {
+	description := fmt.Sprintf("object of type %T is not callable", function)
+	undefined, isUndefined := function.(*Undefined)
+	if isUndefined {
+		description = "undefined value is not callable"
+		if undefined.Hint != "" {
+			description = undefined.Hint
+		}
+	}
+	if call == nil {
+		return NewRuntimeError(ErrorTypeType, description, nil)
+	}
+	name := calleeName(call.Function)
+	if name == "" {
+		return NewRuntimeError(ErrorTypeType, description, call)
+	}
+	suggestion := fmt.Sprintf("remove the parentheses to use %s as a value", name)
+	if isUndefined {
+		suggestion = fmt.Sprintf("test '%s is defined' before calling it", name)
+	}
+	return NewRuntimeError(ErrorTypeType, fmt.Sprintf("cannot call '%s': %s", name, description), call.Function).
+		WithSuggestion(suggestion)
+}
+
+// functionValueError reports the function value of expr used as text at
+// node, such as {{ my_macro|upper }} written for {{ my_macro()|upper }}
+func functionValueError(expr, node parser.Node) error {
+	name := calleeName(expr)
+	if name == "" {
+		return NewRuntimeError(ErrorTypeType, "cannot convert function to string — did you mean to call it?", node)
+	}
+	return NewRuntimeError(ErrorTypeType, fmt.Sprintf("cannot convert function '%s' to string — did you mean to call it?", name), node).
+		WithSuggestion(fmt.Sprintf("write %s() to use what it returns", name))
+}
+
+// functionName names the function called by call in error messages
+func functionName(function interface{}, call *parser.CallNode) string {
+	if call != nil {
+		return parser.Print(call.Function)
+	}
+	return fmt.Sprintf("%T", function)
+}
+
+// callError reports a failed call at the position of call, if known
+func callError(errorType, message string, call *parser.CallNode) error {
+	if call == nil {
+		return NewRuntimeError(errorType, message, nil)
+	}
+	return NewRuntimeError(errorType, message, call)
+}
+
+// callReflected calls a Go function of any signature. Template arguments are
+// converted to the declared parameter types, and the function may return
+// nothing, a value, an error, or a value and an error.
+func callReflected(fn reflect.Value, name string, args []interface{}, kwargs map[string]interface{}, call *parser.CallNode) (interface{}, error) {
+	fnType := fn.Type()
+
+	if fnType.NumOut() > 2 || (fnType.NumOut() == 2 && fnType.Out(1) != errorInterface) {
+		return nil, callError(ErrorTypeType, fmt.Sprintf("%s() has unsupported return types %v; use (value), (error) or (value, error)", name, fnType), call)
+	}
+	if len(kwargs) > 0 {
+		return nil, callError(ErrorTypeType, fmt.Sprintf("%s() does not accept keyword arguments", name), call)
+	}
+
+	fixed := fnType.NumIn()
+	if fnType.IsVariadic() {
+		fixed--
+		if len(args) < fixed {
+			return nil, callError(ErrorTypeType, fmt.Sprintf("%s() takes at least %d arguments, got %d", name, fixed, len(args)), call)
+		}
+	} else if len(args) != fixed {
+		return nil, callError(ErrorTypeType, fmt.Sprintf("%s() takes %d arguments, got %d", name, fixed, len(args)), call)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		paramType := fnType.In(min(i, fnType.NumIn()-1))
+		if i >= fixed {
+			paramType = paramType.Elem()
+		}
+		converted, err := convertArgument(arg, paramType)
+		if err != nil {
+			return nil, callError(ErrorTypeType, fmt.Sprintf("%s() argument %d: %v", name, i+1, err), call)
+		}
+		in[i] = converted
+	}
+
+	out := fn.Call(in)
+	switch {
+	case len(out) == 0:
+		return nil, nil
+	case len(out) == 1 && fnType.Out(0) == errorInterface:
+		err, _ := out[0].Interface().(error)
+		return nil, err
+	case len(out) == 1:
+		return out[0].Interface(), nil
+	}
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return out[0].Interface(), nil
+}
+
+// convertArgument converts a template value to target. Numbers convert
+// between Go numeric types when no precision is lost, and lists and dicts
+// convert element by element.
+func convertArgument(value interface{}, target reflect.Type) (reflect.Value, error) {
+	if target.Kind() == reflect.Interface {
+		if value == nil {
+			return reflect.Zero(target), nil
+		}
+		if reflect.TypeOf(value).Implements(target) {
+			return reflect.ValueOf(value), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %T as %v", value, target)
+	}
+
+	if safe, ok := value.(SafeValue); ok {
+		value = safe.Value
+	}
+	// A dict made by the template is passed to Go as a map
+	if d, ok := value.(*Dict); ok && target.Kind() == reflect.Map {
+		value = d.Map()
+	}
+	if value == nil || IsUndefined(value) {
+		switch target.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(target), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %v", describeMissing(value), target)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(target) {
+		return v, nil
+	}
+
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v.Uint() > math.MaxInt64 {
+				return reflect.Value{}, fmt.Errorf("%v overflows %v", value, target)
+			}
+			n = int64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := v.Float()
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return reflect.Value{}, fmt.Errorf("cannot use %v as %v without losing precision", value, target)
+			}
+			n = int64(f)
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use %T as %v", value, target)
+		}
+		result := reflect.New(target).Elem()
+		if result.OverflowInt(n) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %v", value, target)
+		}
+		result.SetInt(n)
+		return result, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() < 0 {
+				return reflect.Value{}, fmt.Errorf("cannot use negative %v as %v", value, target)
+			}
+			n = uint64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = v.Uint()
+		case reflect.Float32, reflect.Float64:
+			f := v.Float()
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return reflect.Value{}, fmt.Errorf("cannot use %v as %v without losing precision", value, target)
+			}
+			n = uint64(f)
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use %T as %v", value, target)
+		}
+		result := reflect.New(target).Elem()
+		if result.OverflowUint(n) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %v", value, target)
+		}
+		result.SetUint(n)
+		return result, nil
+
+	case reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return v.Convert(target), nil
+		}
+
+	case reflect.String, reflect.Bool:
+		if v.Kind() == target.Kind() {
+			return v.Convert(target), nil
+		}
+
+	case reflect.Slice:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			break
+		}
+		result := reflect.MakeSlice(target, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := convertArgument(v.Index(i).Interface(), target.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("item %d: %w", i, err)
+			}
+			result.Index(i).Set(elem)
+		}
+		return result, nil
+
+	case reflect.Map:
+		if v.Kind() != reflect.Map {
+			break
+		}
+		result := reflect.MakeMapWithSize(target, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := convertArgument(iter.Key().Interface(), target.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+			elem, err := convertArgument(iter.Value().Interface(), target.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+			result.SetMapIndex(key, elem)
+		}
+		return result, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %T as %v", value, target)
+}
+
+func describeMissing(value interface{}) string {
+	if u, ok := value.(*Undefined); ok && u.Name != "" {
+		return fmt.Sprintf("undefined value '%s'", u.Name)
+	}
+	if value == nil {
+		return "none"
+	}
+	return "undefined value"
+}
@@ -0,0 +1,307 @@
+package runtime
+
+import (
+	"strings"
+)
+
+// States of the HTML tracker of an htmlMinifier
+const (
+	minifyText    = iota // Between tags
+	minifyTag            // Inside a tag, after its name
+	minifyQuote          // Inside a quoted attribute value
+	minifyComment        // Inside <!-- -->
+	minifyRaw            // Inside an element whose content is kept as is
+)
+
+// minifyBlockElements are the elements whitespace next to is not rendered,
+// so it can be dropped rather than collapsed to a space
+var minifyBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "blockquote": true,
+	"body": true, "br": true, "caption": true, "col": true, "colgroup": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hgroup": true, "hr": true, "html": true,
+	"legend": true, "li": true, "link": true, "main": true, "meta": true, "nav": true,
+	"ol": true, "optgroup": true, "option": true, "p": true, "pre": true,
+	"script": true, "section": true, "style": true, "summary": true, "table": true,
+	"tbody": true, "td": true, "template": true, "tfoot": true, "th": true,
+	"thead": true, "title": true, "tr": true, "ul": true,
+	"!": true, // <!DOCTYPE html> and other declarations
+}
+
+// minifyRawElements are the elements whose content keeps its whitespace
+var minifyRawElements = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+}
+
+// maxMinifyCache bounds the texts an htmlMinifier remembers the result for
+const maxMinifyCache = 4096
+
+// htmlMinifier collapses the whitespace of the template text of a render.
+// It follows the tags the text opens and closes across calls, so the
+// content of pre, textarea, script and style elements, comments and quoted
+// attribute values is kept as is. Output of expressions never passes
+// through it, so the whitespace of rendered values is untouched.
+type htmlMinifier struct {
+	minifyState
+
+	// Results for texts starting between tags, which don't depend on the
+	// text before them. They are kept across renders, like the evaluator.
+	cache map[string]minifiedText
+}
+
+// minifyState is where the tracker of an htmlMinifier is in the document
+type minifyState struct {
+	state   int
+	quote   byte   // Quote closing the attribute value, in minifyQuote
+	tag     []byte // Lowercased name of the tag being read
+	naming  bool   // The tag's name is still being read
+	closing bool   // The tag is a closing tag
+	rawTag  string // Element whose end tag ends minifyRaw
+}
+
+// minifiedText is a cached result of htmlMinifier.minify and the state the
+// text left the tracker in
+type minifiedText struct {
+	text string
+	end  minifyState
+}
+
+// SetHTMLMinifyWhitespace collapses the whitespace of the template text of
+// the renders that follow. Runs of whitespace become one space, or nothing
+// next to a block-level tag such as <div> or </li>, where it isn't
+// rendered. The content of pre, textarea, script and style elements,
+// comments, attribute values and the output of expressions are kept as is.
+func (e *DefaultEvaluator) SetHTMLMinifyWhitespace(enabled bool) {
+	e.minifyWhitespace = enabled
+	if !enabled {
+		return
+	}
+	if e.minifier == nil {
+		e.minifier = &htmlMinifier{cache: make(map[string]minifiedText)}
+	}
+	e.minifier.minifyState = minifyState{tag: e.minifier.tag[:0]}
+}
+
+// minify returns text, the content of a text node, with its whitespace
+// collapsed
+func (m *htmlMinifier) minify(text string) string {
+	if m.state != minifyText {
+		return m.scan(text)
+	}
+	if cached, ok := m.cache[text]; ok {
+		m.restore(cached.end)
+		return cached.text
+	}
+	result := m.scan(text)
+	if m.cache != nil && len(m.cache) < maxMinifyCache {
+		end := m.minifyState
+		end.tag = append([]byte(nil), m.tag...)
+		m.cache[text] = minifiedText{text: result, end: end}
+	}
+	return result
+}
+
+// restore moves the tracker to state, reusing its tag buffer
+func (m *htmlMinifier) restore(state minifyState) {
+	tag := append(m.tag[:0], state.tag...)
+	m.minifyState = state
+	m.tag = tag
+}
+
+// scan collapses the whitespace of text, following its tags. What comes
+// before and after text may be the output of an expression, so whitespace
+// at its edges is only dropped next to a block-level tag within it. text
+// is returned as is, without copying, when nothing changes.
+func (m *htmlMinifier) scan(text string) string {
+	var sb strings.Builder
+	copied := 0 // text before this is in sb
+	replace := func(from, to int, with string) {
+		if sb.Len() == 0 && copied == 0 {
+			sb.Grow(len(text))
+		}
+		sb.WriteString(text[copied:from])
+		sb.WriteString(with)
+		copied = to
+	}
+	// collapse replaces the whitespace from i up to its end with space,
+	// returning the end
+	collapse := func(i int, space string, drop func(end int) bool) int {
+		j := i
+		for j < len(text) && isHTMLSpace(text[j]) {
+			j++
+		}
+		if drop(j) {
+			replace(i, j, "")
+		} else if j-i > 1 || text[i] != ' ' {
+			replace(i, j, space)
+		}
+		return j
+	}
+	afterBlock := false
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch m.state {
+		case minifyText:
+			if isHTMLSpace(c) {
+				i = collapse(i, " ", func(end int) bool {
+					return afterBlock || startsBlockTag(text[end:])
+				})
+				continue
+			}
+			afterBlock = false
+			i++
+			if c != '<' {
+				continue
+			}
+			rest := text[i:]
+			switch {
+			case strings.HasPrefix(rest, "!--"):
+				m.state = minifyComment
+				i += 3
+			case strings.HasPrefix(rest, "!"):
+				m.startTag(false)
+				m.tag = append(m.tag, '!')
+				m.naming = false
+			case strings.HasPrefix(rest, "/") && len(rest) > 1 && isASCIILetter(rest[1]):
+				m.startTag(true)
+				i++
+			case len(rest) > 0 && isASCIILetter(rest[0]):
+				m.startTag(false)
+			}
+
+		case minifyTag:
+			if m.naming {
+				if isASCIILetter(c) || (c >= '0' && c <= '9') || c == '-' || c == ':' {
+					m.tag = append(m.tag, toLowerASCII(c))
+					i++
+					continue
+				}
+				m.naming = false
+			}
+			switch {
+			case isHTMLSpace(c):
+				i = collapse(i, " ", func(end int) bool {
+					return end < len(text) && text[end] == '>'
+				})
+				continue
+			case c == '"' || c == '\'':
+				m.state, m.quote = minifyQuote, c
+			case c == '>':
+				m.state = minifyText
+				if !m.closing && minifyRawElements[string(m.tag)] {
+					m.state, m.rawTag = minifyRaw, string(m.tag)
+				}
+				afterBlock = minifyBlockElements[string(m.tag)]
+			}
+			i++
+
+		case minifyQuote:
+			end := strings.IndexByte(text[i:], m.quote)
+			if end < 0 {
+				i = len(text)
+				continue
+			}
+			i += end + 1
+			m.state = minifyTag
+
+		case minifyComment:
+			end := strings.Index(text[i:], "-->")
+			if end < 0 {
+				i = len(text)
+				continue
+			}
+			i += end + 3
+			m.state = minifyText
+
+		case minifyRaw:
+			end := indexEndTag(text[i:], m.rawTag)
+			if end < 0 {
+				i = len(text)
+				continue
+			}
+			i += end
+			m.state = minifyText
+		}
+	}
+
+	if copied == 0 {
+		return text
+	}
+	sb.WriteString(text[copied:])
+	return sb.String()
+}
+
+// startTag begins reading a tag
+func (m *htmlMinifier) startTag(closing bool) {
+	m.state = minifyTag
+	m.tag = m.tag[:0]
+	m.naming = true
+	m.closing = closing
+}
+
+// startsBlockTag reports whether s starts with the opening or closing tag
+// of a block-level element, or a declaration such as <!DOCTYPE html>
+func startsBlockTag(s string) bool {
+	if len(s) < 2 || s[0] != '<' {
+		return false
+	}
+	s = s[1:]
+	if s[0] == '!' {
+		return !strings.HasPrefix(s, "!--")
+	}
+	if s[0] == '/' {
+		s = s[1:]
+	}
+	end := 0
+	for end < len(s) && (isASCIILetter(s[end]) || (s[end] >= '0' && s[end] <= '9') || s[end] == '-') {
+		end++
+	}
+	if end == 0 || end == len(s) {
+		// Without the end of the name, it could be a longer one
+		return false
+	}
+	return minifyBlockElements[strings.ToLower(s[:end])]
+}
+
+// indexEndTag returns the index in s of the end tag of element, in any case,
+// or -1
+func indexEndTag(s, element string) int {
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], "</")
+		if i < 0 {
+			return -1
+		}
+		start := offset + i
+		rest := s[start+2:]
+		if len(rest) >= len(element) && strings.EqualFold(rest[:len(element)], element) {
+			return start
+		}
+		offset = start + 2
+	}
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func toLowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// MinifyHTMLWhitespace collapses the whitespace of a whole HTML document as
+// SetHTMLMinifyWhitespace does for template text, for output that wasn't
+// rendered with it. Unlike minifying while rendering, it also collapses the
+// whitespace of rendered values.
+func MinifyHTMLWhitespace(html string) string {
+	return (&htmlMinifier{}).scan(html)
+}
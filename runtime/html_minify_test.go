@@ -0,0 +1,47 @@
+package runtime
+
+import "testing"
+
+func TestHTMLMinifier(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string // Contents of consecutive text nodes
+		want  string
+	}{
+		{"BetweenBlockTags", []string{"<ul>\n  <li>a</li>\n  <li>b</li>\n</ul>\n"}, "<ul><li>a</li><li>b</li></ul>"},
+		{"BetweenInlineTags", []string{"<b>a</b>\n   <i>b</i>"}, "<b>a</b> <i>b</i>"},
+		{"InText", []string{"<p>one\n\t two  three</p>"}, "<p>one two three</p>"},
+		{"Doctype", []string{"<!DOCTYPE html>\n<html>\n<head>"}, "<!DOCTYPE html><html><head>"},
+		{"InsideTag", []string{"<a\n   href=\"/\"\n   class=\"x\">"}, "<a href=\"/\" class=\"x\">"},
+		{"AttributeValue", []string{"<div title=\"a   b\"\n>"}, "<div title=\"a   b\">"},
+		{"Comment", []string{"a <!--  keep\n  this  --> b"}, "a <!--  keep\n  this  --> b"},
+		{"Pre", []string{"<div>\n<pre>\n  x\n    y\n</pre>\n</div>"}, "<div><pre>\n  x\n    y\n</pre></div>"},
+		{"ScriptAcrossNodes", []string{"<script>\n  var a = ", ";\n  var b;\n</SCRIPT>\n<p>"}, "<script>\n  var a = ;\n  var b;\n</SCRIPT><p>"},
+		{"TextareaAttribute", []string{"<textarea rows=\"2\">  a\n  b</textarea>  <span>"}, "<textarea rows=\"2\">  a\n  b</textarea> <span>"},
+		{"NodeEdgesNextToOutput", []string{"<p>\n  ", "\n  and  ", "\n</p>"}, "<p> and </p>"},
+		{"QuoteAcrossNodes", []string{"<a title=\"", "  x  \"\n  href=\"#\">"}, "<a title=\"  x  \" href=\"#\">"},
+		{"LessThanInText", []string{"a < b\n\n< c"}, "a < b < c"},
+		{"CustomElement", []string{"<my-div>\n</my-div>\n<divx>"}, "<my-div> </my-div> <divx>"},
+		{"SameTextInOtherStates", []string{"a\n  b", "<pre>", "a\n  b", "</pre>", "a\n  b", "<i title='", "a\n  b"}, "a b<pre>a\n  b</pre>a b<i title='a\n  b"},
+	}
+	e := NewEvaluator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The second render finds the texts in the cache
+			for render := 1; render <= 2; render++ {
+				e.SetHTMLMinifyWhitespace(true)
+				got := ""
+				for _, text := range tt.texts {
+					got += e.minifier.minify(text)
+				}
+				if got != tt.want {
+					t.Errorf("render %d: got %q, want %q", render, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if got := MinifyHTMLWhitespace("<ul>\n  <li>{{ a  b }}</li>\n</ul>"); got != "<ul><li>{{ a b }}</li></ul>" {
+		t.Errorf("MinifyHTMLWhitespace: got %q", got)
+	}
+}
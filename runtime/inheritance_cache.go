@@ -3,6 +3,8 @@ package runtime
 import (
 	"crypto/md5"
 	"fmt"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,7 +25,8 @@ type InheritanceCacheEntry struct {
 type ResolvedTemplateCacheEntry struct {
 	ResolvedAST   *parser.TemplateNode
 	ContextHash   string
-	TemplateChain []string // Template names in hierarchy
+	TemplateChain []string          // Template names in hierarchy
+	Chain         []TemplateVersion // Template versions the AST was resolved from
 	CreatedAt     time.Time
 	ExpiresAt     time.Time
 	AccessCount   int64
@@ -222,21 +225,89 @@ func (c *InheritanceCache) StoreResolvedTemplate(templateName string, contextHas
 	c.resolvedCache[cacheKey] = entry
 }
 
+// GetResolvedChain retrieves the resolved AST cached for a version of a
+// template. isCurrent is asked whether the versions the entry was resolved
+// from are still the current ones; stale entries are dropped and count as misses.
+func (c *InheritanceCache) GetResolvedChain(templateName string, version uint64, isCurrent func([]TemplateVersion) bool) (*ResolvedTemplateCacheEntry, bool) {
+	c.performCleanupIfNeeded()
+
+	cacheKey := c.buildResolvedCacheKey(templateName, versionKey(version))
+
+	c.resolvedMutex.RLock()
+	entry, exists := c.resolvedCache[cacheKey]
+	c.resolvedMutex.RUnlock()
+
+	if !exists {
+		atomic.AddInt64(&c.resolvedStats.misses, 1)
+		return nil, false
+	}
+
+	if time.Now().After(entry.ExpiresAt) || !isCurrent(entry.Chain) {
+		c.resolvedMutex.Lock()
+		if c.resolvedCache[cacheKey] == entry {
+			delete(c.resolvedCache, cacheKey)
+		}
+		c.resolvedMutex.Unlock()
+		atomic.AddInt64(&c.resolvedStats.misses, 1)
+		return nil, false
+	}
+
+	c.resolvedMutex.Lock()
+	entry.AccessCount++
+	entry.LastAccess = time.Now()
+	c.resolvedMutex.Unlock()
+
+	atomic.AddInt64(&c.resolvedStats.hits, 1)
+	return entry, true
+}
+
+// StoreResolvedChain caches the resolved AST of a template under the version
+// of the template at the head of chain
+func (c *InheritanceCache) StoreResolvedChain(templateName string, chain []TemplateVersion, resolvedAST *parser.TemplateNode, templateChain []string) {
+	c.resolvedMutex.Lock()
+	defer c.resolvedMutex.Unlock()
+
+	if len(c.resolvedCache) >= c.maxEntries {
+		c.evictOldestResolved()
+	}
+
+	key := versionKey(chain[0].Version)
+	now := time.Now()
+	c.resolvedCache[c.buildResolvedCacheKey(templateName, key)] = &ResolvedTemplateCacheEntry{
+		ResolvedAST:   resolvedAST,
+		ContextHash:   key,
+		TemplateChain: templateChain,
+		Chain:         chain,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(c.resolvedTTL),
+		AccessCount:   1,
+		LastAccess:    now,
+	}
+}
+
 // InvalidateTemplate removes all cache entries related to a template
 func (c *InheritanceCache) InvalidateTemplate(templateName string) {
+	// Invalidate the template's own hierarchy and any hierarchy that extends it
 	c.hierarchyMutex.Lock()
 	delete(c.hierarchyCache, templateName)
+	for key, entry := range c.hierarchyCache {
+		if entry.Hierarchy == nil {
+			continue
+		}
+		if _, ok := entry.Hierarchy.TemplateMap[templateName]; ok {
+			delete(c.hierarchyCache, key)
+		}
+	}
 	c.hierarchyMutex.Unlock()
 
 	// Invalidate resolved templates that include this template in their chain
 	c.resolvedMutex.Lock()
 	keysToDelete := make([]string, 0)
 	for key, entry := range c.resolvedCache {
-		for _, name := range entry.TemplateChain {
-			if name == templateName {
-				keysToDelete = append(keysToDelete, key)
-				break
-			}
+		if slices.Contains(entry.TemplateChain, templateName) || slices.ContainsFunc(entry.Chain, func(link TemplateVersion) bool {
+			return link.Name == templateName
+		}) {
+			keysToDelete = append(keysToDelete, key)
 		}
 	}
 	for _, key := range keysToDelete {
@@ -296,6 +367,11 @@ func (c *InheritanceCache) buildResolvedCacheKey(templateName string, contextHas
 	return fmt.Sprintf("%s::%s", templateName, contextHash)
 }
 
+// versionKey is the resolved cache key part identifying a template version
+func versionKey(version uint64) string {
+	return "v" + strconv.FormatUint(version, 10)
+}
+
 // evictOldestHierarchy removes the least recently used hierarchy entry
 func (c *InheritanceCache) evictOldestHierarchy() {
 	var oldestKey string
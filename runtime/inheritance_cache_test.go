@@ -25,6 +25,11 @@ func (c *mockContext) GetVariable(name string) (interface{}, bool) {
 	return v, ok
 }
 
+func (c *mockContext) HasVariable(name string) bool {
+	_, exists := c.data[name]
+	return exists
+}
+
 func (c *mockContext) SetVariable(name string, value interface{}) {
 	c.data[name] = value
 }
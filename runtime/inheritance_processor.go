@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/zipreport/miya/parser"
@@ -13,6 +14,9 @@ type InheritanceProcessor struct {
 	templateCache map[string]*parser.TemplateNode
 	cache         *InheritanceCache
 	hasher        *ContextHasher
+
+	childContentPolicy ChildContentPolicy
+	warningHandler     WarningHandler
 }
 
 // EnvironmentInterface defines the minimal interface needed from Environment
@@ -27,12 +31,26 @@ type TemplateInterface interface {
 	Name() string
 }
 
+// VersionedTemplate is implemented by templates whose version changes each
+// time their AST is replaced, e.g. after the loader reports an update
+type VersionedTemplate interface {
+	Version() uint64
+}
+
+// TemplateVersion identifies a template of an inheritance chain as it was
+// when the chain was resolved
+type TemplateVersion struct {
+	Name    string
+	Version uint64
+}
+
 // InheritanceHierarchy represents a resolved template inheritance chain
 type InheritanceHierarchy struct {
 	RootTemplate *parser.TemplateNode
 	Templates    []*parser.TemplateNode // From child to parent order
 	BlockMap     map[string]*parser.BlockNode
 	TemplateMap  map[string]*parser.TemplateNode
+	Chain        []TemplateVersion // From child to parent order, nil unless every template is versioned
 }
 
 // NewInheritanceProcessor creates a new inheritance processor
@@ -57,68 +75,124 @@ func NewInheritanceProcessorWithCache(env EnvironmentInterface, cache *Inheritan
 
 // ResolveInheritance resolves template inheritance at render-time with caching
 func (p *InheritanceProcessor) ResolveInheritance(template TemplateInterface, context Context) (*parser.TemplateNode, error) {
+	resolved, _, err := p.ResolveInheritanceWithChain(template, context)
+	return resolved, err
+}
+
+// ResolveInheritanceWithChain resolves template inheritance and also returns the
+// names of the parent templates, from the direct parent up to the root template
+func (p *InheritanceProcessor) ResolveInheritanceWithChain(template TemplateInterface, context Context) (*parser.TemplateNode, []string, error) {
 	ast := template.AST()
 	templateName := template.Name()
 
 	// Check if template has inheritance directives
 	hasInheritance := p.hasInheritanceDirectives(ast)
 	if !hasInheritance {
-		return ast, nil // No inheritance needed
+		return ast, nil, nil // No inheritance needed
 	}
 
-	// Try to get resolved template from cache first
-	contextHash := p.hasher.HashContext(context)
-	// TEMPORARY: Disable caching for templates with super() calls to avoid cache collisions
-	// TODO: Improve cache key to include template content hash
-	if !p.hasInheritanceDirectives(ast) {
-		if cachedTemplate, found := p.cache.GetResolvedTemplate(templateName, contextHash); found {
-			return cachedTemplate, nil
+	// Static inheritance resolves to the same AST whatever the context, so the
+	// result is cached per version of every template in the chain
+	dynamic := p.hasDynamicInheritance(ast)
+	if !dynamic {
+		if version, ok := templateVersion(template); ok {
+			if entry, found := p.cache.GetResolvedChain(templateName, version, p.isCurrent); found {
+				return entry.ResolvedAST, entry.TemplateChain[1:], nil
+			}
 		}
 	}
 
-	// Check if we have the hierarchy cached
 	var hierarchy *InheritanceHierarchy
 	var err error
 
 	// For dynamic inheritance, we can't cache hierarchies since they depend on context
-	if p.hasDynamicInheritance(template.AST()) {
+	if dynamic {
 		// Build inheritance hierarchy with context for dynamic resolution
 		hierarchy, err = p.buildInheritanceHierarchyWithContext(template, context)
 		if err != nil {
-			return nil, fmt.Errorf("failed to build inheritance hierarchy: %v", err)
+			return nil, nil, fmt.Errorf("failed to build inheritance hierarchy: %w", err)
 		}
 	} else {
-		// Static inheritance - can use caching
-		if cachedHierarchy, found := p.cache.GetHierarchy(templateName); found {
-			hierarchy = cachedHierarchy
-		} else {
-			// Build inheritance hierarchy
-			hierarchy, err = p.buildInheritanceHierarchy(template)
-			if err != nil {
-				return nil, fmt.Errorf("failed to build inheritance hierarchy: %v", err)
-			}
-
-			// Cache the hierarchy for future use
-			p.cache.StoreHierarchy(templateName, hierarchy)
+		hierarchy, err = p.staticHierarchy(template)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
 
 	// Resolve blocks and build final template
 	finalTemplate, err := p.buildFinalTemplate(hierarchy, context)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build final template: %v", err)
+		return nil, nil, fmt.Errorf("failed to build final template: %v", err)
 	}
 
-	// Cache the resolved template
 	templateChain := make([]string, len(hierarchy.Templates))
 	for i, tmpl := range hierarchy.Templates {
 		if tmpl.Name != "" {
 			templateChain[i] = tmpl.Name
 		}
 	}
-	p.cache.StoreResolvedTemplate(templateName, contextHash, finalTemplate, templateChain)
+	if !dynamic && hierarchy.Chain != nil {
+		p.cache.StoreResolvedChain(templateName, hierarchy.Chain, finalTemplate, templateChain)
+	}
 
-	return finalTemplate, nil
+	return finalTemplate, templateChain[1:], nil
+}
+
+// staticHierarchy returns the inheritance hierarchy of a template with a static
+// extends chain, from the cache unless a template in the chain has changed
+func (p *InheritanceProcessor) staticHierarchy(template TemplateInterface) (*InheritanceHierarchy, error) {
+	if cached, found := p.cache.GetHierarchy(template.Name()); found && p.isCurrent(cached.Chain) {
+		if version, ok := templateVersion(template); cached.Chain == nil || (ok && version == cached.Chain[0].Version) {
+			return cached, nil
+		}
+	}
+
+	hierarchy, err := p.buildInheritanceHierarchy(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build inheritance hierarchy: %w", err)
+	}
+	p.cache.StoreHierarchy(template.Name(), hierarchy)
+	return hierarchy, nil
+}
+
+// isCurrent reports whether the ancestors of a resolved chain still have the
+// versions it was resolved with. The child at the head of the chain is checked
+// by the caller, as it may not be loadable by name. A nil chain comes from
+// unversioned templates, which are trusted until invalidated.
+func (p *InheritanceProcessor) isCurrent(chain []TemplateVersion) bool {
+	if len(chain) == 0 {
+		return true
+	}
+	for _, link := range chain[1:] {
+		tmpl, err := p.env.GetTemplate(link.Name)
+		if err != nil {
+			return false
+		}
+		if version, ok := templateVersion(tmpl); !ok || version != link.Version {
+			return false
+		}
+	}
+	return true
+}
+
+// templateVersion returns the version of a template that carries one
+func templateVersion(template TemplateInterface) (uint64, bool) {
+	if versioned, ok := template.(VersionedTemplate); ok {
+		return versioned.Version(), true
+	}
+	return 0, false
+}
+
+// PrepareHierarchy builds and caches the inheritance hierarchy of a template
+// whose extends chain is static, loading every parent along the way. Templates
+// without inheritance or with a dynamic extends are left for render time.
+func (p *InheritanceProcessor) PrepareHierarchy(template TemplateInterface) error {
+	ast := template.AST()
+	if p.findExtendsTemplate(ast) == "" || p.hasDynamicInheritance(ast) {
+		return nil
+	}
+	_, err := p.staticHierarchy(template)
+	return err
 }
 
 // hasInheritanceDirectives checks if template contains {% extends %} directives or {{ super() }} calls
@@ -245,15 +319,20 @@ func (p *InheritanceProcessor) buildInheritanceHierarchy(template TemplateInterf
 	}
 
 	current := template
-	templateNames := make(map[string]bool) // Prevent circular inheritance
+	var chain []string // Prevent circular inheritance
 	isFirstTemplate := true
+	versioned := true
 
 	for current != nil {
 		// Check for circular inheritance
-		if templateNames[current.Name()] {
-			return nil, fmt.Errorf("circular inheritance detected: %s", current.Name())
+		if slices.Contains(chain, current.Name()) {
+			return nil, newCycleError("extends", chain, current.Name())
 		}
-		templateNames[current.Name()] = true
+		chain = append(chain, current.Name())
+
+		version, ok := templateVersion(current)
+		versioned = versioned && ok
+		hierarchy.Chain = append(hierarchy.Chain, TemplateVersion{Name: current.Name(), Version: version})
 
 		ast := current.AST()
 		hierarchy.Templates = append(hierarchy.Templates, ast)
@@ -274,6 +353,9 @@ func (p *InheritanceProcessor) buildInheritanceHierarchy(template TemplateInterf
 			hierarchy.RootTemplate = ast
 			break
 		}
+		if err := p.checkChildContent(current.Name(), ast); err != nil {
+			return nil, err
+		}
 
 		// Load parent template
 		parentTemplate, err := p.env.GetTemplate(parentName)
@@ -285,6 +367,9 @@ func (p *InheritanceProcessor) buildInheritanceHierarchy(template TemplateInterf
 		isFirstTemplate = false
 	}
 
+	if !versioned {
+		hierarchy.Chain = nil
+	}
 	return hierarchy, nil
 }
 
@@ -334,15 +419,15 @@ func (p *InheritanceProcessor) buildInheritanceHierarchyWithContext(template Tem
 	}
 
 	current := template
-	templateNames := make(map[string]bool) // Prevent circular inheritance
+	var chain []string // Prevent circular inheritance
 	isFirstTemplate := true
 
 	for current != nil {
 		// Check for circular inheritance
-		if templateNames[current.Name()] {
-			return nil, fmt.Errorf("circular inheritance detected: %s", current.Name())
+		if slices.Contains(chain, current.Name()) {
+			return nil, newCycleError("extends", chain, current.Name())
 		}
-		templateNames[current.Name()] = true
+		chain = append(chain, current.Name())
 
 		ast := current.AST()
 		hierarchy.Templates = append(hierarchy.Templates, ast)
@@ -366,6 +451,9 @@ func (p *InheritanceProcessor) buildInheritanceHierarchyWithContext(template Tem
 			hierarchy.RootTemplate = ast
 			break
 		}
+		if err := p.checkChildContent(current.Name(), ast); err != nil {
+			return nil, err
+		}
 
 		// Load parent template
 		parentTemplate, err := p.env.GetTemplate(parentName)
@@ -395,8 +483,7 @@ func (p *InheritanceProcessor) findExtendsTemplateWithContext(node parser.Node,
 			return strings.Trim(literal.Value.(string), "\"'"), nil
 		} else {
 			// Dynamic template name - evaluate expression
-			evaluator := NewCachedEvaluator()
-			templateName, err := evaluator.EvalNodeCached(n.Template, context)
+			templateName, err := NewEvaluator().EvalNode(n.Template, context)
 			if err != nil {
 				return "", fmt.Errorf("failed to evaluate dynamic template name: %v", err)
 			}
@@ -486,8 +573,11 @@ func (p *InheritanceProcessor) buildFinalTemplate(hierarchy *InheritanceHierarch
 		return nil, fmt.Errorf("no root template found in hierarchy")
 	}
 
-	// Start with root template and replace blocks with child implementations
-	finalTemplate := p.cloneTemplateNode(hierarchy.RootTemplate)
+	// Start with root template and replace blocks with child implementations.
+	// Only the nodes leading to a replaced block are copied, the rest of the
+	// tree is shared with the templates of the hierarchy.
+	root := *hierarchy.RootTemplate
+	finalTemplate := &root
 
 	// Collect and add import statements from all templates in the hierarchy
 	err := p.addImportStatementsToTemplate(finalTemplate, hierarchy)
@@ -496,7 +586,7 @@ func (p *InheritanceProcessor) buildFinalTemplate(hierarchy *InheritanceHierarch
 	}
 
 	// Apply child block overrides to the final template
-	err = p.applyBlockOverrides(finalTemplate, hierarchy, context)
+	finalTemplate, err = p.applyBlockOverrides(finalTemplate, hierarchy, context)
 	if err != nil {
 		return nil, fmt.Errorf("failed to apply block overrides: %v", err)
 	}
@@ -682,7 +772,7 @@ func (p *InheritanceProcessor) replaceBlocks(node parser.Node, blockMap map[stri
 
 // applyBlockOverrides applies child template block overrides to the base template structure
 // This processes the inheritance chain from parent to child, applying overrides progressively
-func (p *InheritanceProcessor) applyBlockOverrides(finalTemplate *parser.TemplateNode, hierarchy *InheritanceHierarchy, context Context) error {
+func (p *InheritanceProcessor) applyBlockOverrides(finalTemplate *parser.TemplateNode, hierarchy *InheritanceHierarchy, context Context) (*parser.TemplateNode, error) {
 	// Process templates from parent to child (reverse order of hierarchy.Templates)
 	// hierarchy.Templates is [child, middle, parent], so we process from end to start
 	for i := len(hierarchy.Templates) - 2; i >= 0; i-- {
@@ -693,13 +783,14 @@ func (p *InheritanceProcessor) applyBlockOverrides(finalTemplate *parser.Templat
 		p.collectChildOverrides(template, levelOverrides)
 
 		// Apply this level's overrides to the current state
-		err := p.applyOverridesToTemplate(finalTemplate, levelOverrides)
+		var err error
+		finalTemplate, err = p.applyOverridesToTemplate(finalTemplate, levelOverrides)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return finalTemplate, nil
 }
 
 // collectChildOverrides collects block definitions from child template
@@ -735,78 +826,78 @@ func (p *InheritanceProcessor) collectOverridesRecursive(node parser.Node, overr
 }
 
 // applyOverridesToTemplate applies block overrides to template structure
-func (p *InheritanceProcessor) applyOverridesToTemplate(template *parser.TemplateNode, overrides map[string]*parser.BlockNode) error {
-	return p.applyOverridesRecursive(template, overrides)
+func (p *InheritanceProcessor) applyOverridesToTemplate(template *parser.TemplateNode, overrides map[string]*parser.BlockNode) (*parser.TemplateNode, error) {
+	return p.applyOverridesRecursive(template, overrides).(*parser.TemplateNode), nil
 }
 
-// applyOverridesRecursive recursively applies block overrides
-func (p *InheritanceProcessor) applyOverridesRecursive(node parser.Node, overrides map[string]*parser.BlockNode) error {
+// applyOverridesRecursive returns node with its blocks replaced by overrides.
+// Nodes are copied only when something below them changes, and overriding
+// blocks are used as they are, so unchanged subtrees stay shared.
+func (p *InheritanceProcessor) applyOverridesRecursive(node parser.Node, overrides map[string]*parser.BlockNode) parser.Node {
 	switch n := node.(type) {
 	case *parser.TemplateNode:
-		for i, child := range n.Children {
-			if blockNode, ok := child.(*parser.BlockNode); ok {
-				if override, exists := overrides[blockNode.Name]; exists {
-					n.Children[i] = p.cloneNode(override)
-				} else {
-					// No override, recurse into block to handle nested blocks
-					err := p.applyOverridesRecursive(blockNode, overrides)
-					if err != nil {
-						return err
-					}
-				}
-			} else {
-				err := p.applyOverridesRecursive(child, overrides)
-				if err != nil {
-					return err
-				}
-			}
+		if children, changed := p.applyOverridesToNodes(n.Children, overrides); changed {
+			clone := *n
+			clone.Children = children
+			return &clone
 		}
 	case *parser.BlockNode:
-		for i, child := range n.Body {
-			if blockNode, ok := child.(*parser.BlockNode); ok {
-				if override, exists := overrides[blockNode.Name]; exists {
-					n.Body[i] = p.cloneNode(override)
-				} else {
-					err := p.applyOverridesRecursive(blockNode, overrides)
-					if err != nil {
-						return err
-					}
-				}
-			} else {
-				err := p.applyOverridesRecursive(child, overrides)
-				if err != nil {
-					return err
-				}
-			}
+		if override, exists := overrides[n.Name]; exists {
+			return override
+		}
+		if body, changed := p.applyOverridesToNodes(n.Body, overrides); changed {
+			clone := *n
+			clone.Body = body
+			return &clone
 		}
 	case *parser.IfNode:
-		for _, child := range n.Body {
-			err := p.applyOverridesRecursive(child, overrides)
-			if err != nil {
-				return err
+		body, bodyChanged := p.applyOverridesToNodes(n.Body, overrides)
+		elseBody, elseChanged := p.applyOverridesToNodes(n.Else, overrides)
+		elseIfs, elseIfsChanged := n.ElseIfs, false
+		for i, elseIf := range n.ElseIfs {
+			if replaced := p.applyOverridesRecursive(elseIf, overrides); replaced != parser.Node(elseIf) {
+				if !elseIfsChanged {
+					elseIfs = slices.Clone(n.ElseIfs)
+					elseIfsChanged = true
+				}
+				elseIfs[i] = replaced.(*parser.IfNode)
 			}
 		}
-		for _, child := range n.Else {
-			err := p.applyOverridesRecursive(child, overrides)
-			if err != nil {
-				return err
-			}
+		if bodyChanged || elseChanged || elseIfsChanged {
+			clone := *n
+			clone.Body, clone.ElseIfs, clone.Else = body, elseIfs, elseBody
+			return &clone
 		}
 	case *parser.ForNode:
-		for _, child := range n.Body {
-			err := p.applyOverridesRecursive(child, overrides)
-			if err != nil {
-				return err
-			}
+		body, bodyChanged := p.applyOverridesToNodes(n.Body, overrides)
+		elseBody, elseChanged := p.applyOverridesToNodes(n.Else, overrides)
+		if bodyChanged || elseChanged {
+			clone := *n
+			clone.Body, clone.Else = body, elseBody
+			return &clone
 		}
-		for _, child := range n.Else {
-			err := p.applyOverridesRecursive(child, overrides)
-			if err != nil {
-				return err
-			}
+	}
+	return node
+}
+
+// applyOverridesToNodes applies overrides to a list of nodes, returning the
+// original slice when none of them changed
+func (p *InheritanceProcessor) applyOverridesToNodes(nodes []parser.Node, overrides map[string]*parser.BlockNode) ([]parser.Node, bool) {
+	var result []parser.Node
+	for i, child := range nodes {
+		replaced := p.applyOverridesRecursive(child, overrides)
+		if replaced == child {
+			continue
+		}
+		if result == nil {
+			result = slices.Clone(nodes)
 		}
+		result[i] = replaced
 	}
-	return nil
+	if result == nil {
+		return nodes, false
+	}
+	return result, true
 }
 
 // Cache management methods
@@ -449,6 +449,112 @@ func TestReplaceBlocks(t *testing.T) {
 	}
 }
 
+// versionedTemplate is a mockTemplate carrying a version
+type versionedTemplate struct {
+	mockTemplate
+	version uint64
+}
+
+func (v *versionedTemplate) Version() uint64 {
+	return v.version
+}
+
+// versionedEnvironment serves versionedTemplates
+type versionedEnvironment struct {
+	templates map[string]*versionedTemplate
+}
+
+func (e *versionedEnvironment) GetTemplate(name string) (TemplateInterface, error) {
+	if tmpl, ok := e.templates[name]; ok {
+		return tmpl, nil
+	}
+	return nil, fmt.Errorf("template not found: %s", name)
+}
+
+func (e *versionedEnvironment) GetLoader() interface{} {
+	return nil
+}
+
+func (e *versionedEnvironment) set(name string, version uint64, ast *parser.TemplateNode) {
+	e.templates[name] = &versionedTemplate{mockTemplate: mockTemplate{name: name, ast: ast}, version: version}
+}
+
+// TestResolveInheritanceCachedByVersionChain tests that resolved ASTs are
+// reused until a template of the chain gets a new version, and that the
+// parts of the root template no child overrides are shared, not copied
+func TestResolveInheritanceCachedByVersionChain(t *testing.T) {
+	env := &versionedEnvironment{templates: make(map[string]*versionedTemplate)}
+	processor := NewInheritanceProcessor(env)
+	ctx := &simpleContext{variables: make(map[string]interface{})}
+
+	nav := &parser.BlockNode{Name: "nav", Body: []parser.Node{&parser.TextNode{Content: "nav"}}}
+	branch := &parser.IfNode{
+		Condition: &parser.LiteralNode{Value: false},
+		ElseIfs: []*parser.IfNode{{
+			Condition: &parser.LiteralNode{Value: true},
+			Body:      []parser.Node{&parser.BlockNode{Name: "content", Body: []parser.Node{&parser.TextNode{Content: "base"}}}},
+		}},
+	}
+	baseAST := &parser.TemplateNode{Name: "base.html", Children: []parser.Node{nav, branch}}
+	env.set("base.html", 1, baseAST)
+	env.set("layout.html", 1, &parser.TemplateNode{Name: "layout.html", Children: []parser.Node{
+		&parser.ExtendsNode{Template: &parser.LiteralNode{Value: "base.html"}},
+		&parser.BlockNode{Name: "content", Body: []parser.Node{&parser.TextNode{Content: "layout"}}},
+	}})
+	env.set("page.html", 1, &parser.TemplateNode{Name: "page.html", Children: []parser.Node{
+		&parser.ExtendsNode{Template: &parser.LiteralNode{Value: "layout.html"}},
+	}})
+
+	page, _ := env.GetTemplate("page.html")
+	first, parents, err := processor.ResolveInheritanceWithChain(page, ctx)
+	if err != nil {
+		t.Fatalf("ResolveInheritanceWithChain failed: %v", err)
+	}
+	if len(parents) != 2 || parents[0] != "layout.html" || parents[1] != "base.html" {
+		t.Errorf("parents = %v, want [layout.html base.html]", parents)
+	}
+	if first.Children[0] != parser.Node(nav) {
+		t.Error("expected the nav block to be shared with the base template")
+	}
+	resolvedBranch := first.Children[1].(*parser.IfNode)
+	if resolvedBranch == branch {
+		t.Fatal("expected the if node leading to the overridden block to be copied")
+	}
+	if got := resolvedBranch.ElseIfs[0].Body[0].(*parser.BlockNode).Body[0].(*parser.TextNode).Content; got != "layout" {
+		t.Errorf("elif block = %q, want the layout override", got)
+	}
+	if got := branch.ElseIfs[0].Body[0].(*parser.BlockNode).Body[0].(*parser.TextNode).Content; got != "base" {
+		t.Errorf("base template was modified: elif block = %q", got)
+	}
+
+	second, _, err := processor.ResolveInheritanceWithChain(page, ctx)
+	if err != nil {
+		t.Fatalf("ResolveInheritanceWithChain failed: %v", err)
+	}
+	if second != first {
+		t.Error("expected the resolved AST to come from the cache")
+	}
+	if stats := processor.GetCacheStats(); stats.ResolvedCache.Hits != 1 {
+		t.Errorf("resolved cache hits = %d, want 1", stats.ResolvedCache.Hits)
+	}
+
+	// A new version of the root template, without any invalidation call
+	env.set("base.html", 2, &parser.TemplateNode{Name: "base.html", Children: []parser.Node{
+		&parser.TextNode{Content: "v2"},
+		&parser.BlockNode{Name: "content"},
+	}})
+	third, _, err := processor.ResolveInheritanceWithChain(page, ctx)
+	if err != nil {
+		t.Fatalf("ResolveInheritanceWithChain failed: %v", err)
+	}
+	if third == first {
+		t.Fatal("expected a new base version to resolve the chain again")
+	}
+	if got := third.Children[0].(*parser.TextNode).Content; got != "v2" {
+		t.Errorf("first node = %q, want the new base template's", got)
+	}
+}
+
 // TestDebugPrintTemplate tests the debug output function
 func TestDebugPrintTemplate(t *testing.T) {
 	env := newMockEnvironment()
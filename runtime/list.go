@@ -0,0 +1,226 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// List is a list that templates change in place, created by the list()
+// global. Where a list written as [...] is a value, a List is shared by every
+// variable holding it, so items appended inside a loop are still there after
+// it:
+//
+//	{% set seen = list() %}
+//	{% for item in items %}{% do seen.append(item.id) %}{% endfor %}
+//
+// Templates call append, extend, insert, pop and remove on it. It can be
+// looped over, indexed and used with the sequence filters, and is safe for
+// concurrent use.
+type List struct {
+	items []interface{}
+	mu    sync.Mutex
+}
+
+// NewList returns a List holding items
+func NewList(items ...interface{}) *List {
+	return &List{items: append([]interface{}{}, items...)}
+}
+
+// Items returns a copy of the items of the list
+func (l *List) Items() []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]interface{}{}, l.items...)
+}
+
+// Len returns the number of items in the list
+func (l *List) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.items)
+}
+
+// Index returns the item at position i
+func (l *List) Index(i int) interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.items[i]
+}
+
+// Iterate calls yield for the items the list holds when it is called
+func (l *List) Iterate(yield func(item interface{}) bool) {
+	for _, item := range l.Items() {
+		if !yield(item) {
+			return
+		}
+	}
+}
+
+// Contains reports whether an item of the list equals v, for the in operator
+func (l *List) Contains(v interface{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, item := range l.items {
+		if valuesEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// String writes the list as a list written as [...] is written
+func (l *List) String() string {
+	return fmt.Sprint(l.Items())
+}
+
+// RenderCopy returns a list holding the same items
+func (l *List) RenderCopy() interface{} {
+	return NewList(l.Items()...)
+}
+
+// listMethods are the methods templates call on a List, as in Python
+var listMethods = []string{"append", "extend", "insert", "pop", "remove"}
+
+// method returns the template method of the list named attr, or nil
+func (l *List) method(attr string) interface{} {
+	switch attr {
+	case "append":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("append() takes exactly one argument (%d given)", len(args))
+			}
+			l.mu.Lock()
+			l.items = append(l.items, args[0])
+			l.mu.Unlock()
+			return nil, nil
+		}
+	case "extend":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("extend() takes exactly one argument (%d given)", len(args))
+			}
+			items, err := listItems(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("extend(): %w", err)
+			}
+			l.mu.Lock()
+			l.items = append(l.items, items...)
+			l.mu.Unlock()
+			return nil, nil
+		}
+	case "insert":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("insert() takes exactly 2 arguments (%d given)", len(args))
+			}
+			i, err := toIndex(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("insert(): %w", err)
+			}
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			// Positions past either end insert at that end, as in Python
+			if i < 0 {
+				i = max(len(l.items)+i, 0)
+			}
+			i = min(i, len(l.items))
+			l.items = append(l.items, nil)
+			copy(l.items[i+1:], l.items[i:])
+			l.items[i] = args[1]
+			return nil, nil
+		}
+	case "pop":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) > 1 {
+				return nil, fmt.Errorf("pop() takes at most 1 argument (%d given)", len(args))
+			}
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if len(l.items) == 0 {
+				return nil, fmt.Errorf("pop from empty list")
+			}
+			i := len(l.items) - 1
+			if len(args) == 1 {
+				var err error
+				if i, err = toIndex(args[0]); err != nil {
+					return nil, fmt.Errorf("pop(): %w", err)
+				}
+				if i < 0 {
+					i += len(l.items)
+				}
+				if i < 0 || i >= len(l.items) {
+					return nil, fmt.Errorf("pop index out of range")
+				}
+			}
+			item := l.items[i]
+			l.items = append(l.items[:i], l.items[i+1:]...)
+			return item, nil
+		}
+	case "remove":
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("remove() takes exactly one argument (%d given)", len(args))
+			}
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for i, item := range l.items {
+				if valuesEqual(item, args[0]) {
+					l.items = append(l.items[:i], l.items[i+1:]...)
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("list.remove(x): x not in list")
+		}
+	}
+	return nil
+}
+
+// immutableListMethod stands for the List method attr on a list written as
+// [...], which can't be changed in place, to say how to get one that can
+func immutableListMethod(attr string) interface{} {
+	return func(args ...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("%s() needs a list made with list(): a list written as [...] can't be changed in place; write {%% set items = list() %%} instead", attr)
+	}
+}
+
+// listItems returns the items of the sequence or string value, for extend
+func listItems(value interface{}) ([]interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		items := make([]interface{}, 0, len(v))
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	if items, ok := CollectionItems(value); ok {
+		return items, nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", describeOperand(value))
+}
+
+// toIndex converts a list position given by a template to an int
+func toIndex(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("list indices must be integers, not %s", describeOperand(value))
+}
@@ -1,7 +1,14 @@
 package runtime
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestEvaluatorMathOperations tests all mathematical operations in the evaluator
@@ -226,9 +233,33 @@ func TestFormatIntegerPartWithCommas(t *testing.T) {
 	}
 }
 
+// TestFormatDuration tests the rendered form of durations
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{2*time.Hour + 30*time.Minute, "2h30m"},
+		{2 * time.Hour, "2h"},
+		{90 * time.Second, "1m30s"},
+		{5 * time.Minute, "5m"},
+		{time.Hour + time.Second, "1h0m1s"},
+		{1500 * time.Millisecond, "1.5s"},
+		{-time.Hour, "-1h"},
+		{0, "0s"},
+	}
+
+	for _, tt := range tests {
+		if result := FormatDuration(tt.input); result != tt.expected {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
 // TestApplyBinaryOp tests binary operations
 func TestApplyBinaryOp(t *testing.T) {
 	e := NewEvaluator()
+	noon := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
 
 	tests := []struct {
 		name     string
@@ -241,6 +272,14 @@ func TestApplyBinaryOp(t *testing.T) {
 		{"add numbers", "+", 1, 2, float64(3), false},
 		{"subtract", "-", 5, 3, float64(2), false},
 		{"multiply", "*", 4, 3, float64(12), false},
+		{"large integer sum", "+", int64(1 << 53), 1, int64(1<<53 + 1), false},
+		{"large json integer", "+", json.Number("123456789012345678"), 1, int64(123456789012345679), false},
+		{"large integer difference", "-", -(1 << 53), 2, int64(-(1 << 53) - 2), false},
+		{"large integer product", "*", int64(1 << 40), 1 << 20, int64(1 << 60), false},
+		{"integer overflow", "*", int64(math.MaxInt64), 2, float64(math.MaxInt64) * 2, false},
+		{"bool as number", "*", 2, false, float64(0), false},
+		{"bools as numbers", "+", true, true, float64(2), false},
+		{"bool with string", "+", "on: ", true, "on: true", false},
 		{"divide", "/", 10, 2, float64(5), false},
 		{"modulo", "%", 10, 3, nil, false}, // Returns int, check separately
 		{"power", "**", 2, 3, float64(8), false},
@@ -256,7 +295,15 @@ func TestApplyBinaryOp(t *testing.T) {
 		{"not in", "not in", "c", []interface{}{"a", "b"}, true, false},
 		{"and", "and", true, false, false, false},
 		{"or", "or", true, false, true, false},
-		{"unknown op", "??", 1, 2, nil, true},
+		{"time less", "<", noon, noon.Add(time.Second), true, false},
+		{"time equal across zones", "==", noon, noon.In(time.FixedZone("X", 3600)), true, false},
+		{"time difference", "-", noon.Add(time.Hour), noon, time.Hour, false},
+		{"time plus seconds", "+", noon, 60, noon.Add(time.Minute), false},
+		{"seconds plus time", "+", 60, noon, noon.Add(time.Minute), false},
+		{"time minus duration", "-", noon, time.Hour, noon.Add(-time.Hour), false},
+		{"duration greater than seconds", ">", time.Hour, 3599, true, false},
+		{"duration at most seconds", "<=", time.Hour, 3600, true, false},
+		{"time and number", "<", noon, 1, false, false},
 	}
 
 	for _, tt := range tests {
@@ -365,6 +412,77 @@ func TestCallFunction(t *testing.T) {
 			t.Errorf("callFunction with Joiner = %v, want ', '", result)
 		}
 	})
+
+	t.Run("typed function", func(t *testing.T) {
+		fn := func(s string, n int, f float32) string { return fmt.Sprint(s, n, f) }
+		result, err := e.callFunction(fn, []interface{}{"x", 2.0, 3}, nil)
+		if err != nil {
+			t.Fatalf("callFunction failed: %v", err)
+		}
+		if result != "x2 3" {
+			t.Errorf("callFunction = %v, want 'x2 3'", result)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		fn := func() string { panic("boom") }
+		_, err := e.callFunction(fn, nil, nil)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("expected a RuntimeError mentioning the panic, got %v", err)
+		}
+	})
+}
+
+func TestConvertArgument(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"int from float", 3.0, 3},
+		{"int8 from int", 7, int8(7)},
+		{"uint from int", 7, uint(7)},
+		{"float from int", 2, 2.0},
+		{"string", "s", "s"},
+		{"safe string", SafeValue{Value: "<b>"}, "<b>"},
+		{"bool", true, true},
+		{"string slice", []interface{}{"a", "b"}, []string{"a", "b"}},
+		{"int map", map[string]interface{}{"a": 1.0}, map[string]int{"a": 1}},
+		{"nil slice", nil, []string(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertArgument(tt.value, reflect.TypeOf(tt.want))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Interface(), tt.want) {
+				t.Errorf("got %#v, want %#v", got.Interface(), tt.want)
+			}
+		})
+	}
+
+	failures := []struct {
+		name   string
+		value  interface{}
+		target interface{}
+	}{
+		{"fraction to int", 2.5, 0},
+		{"overflow", 300, int8(0)},
+		{"negative to uint", -1, uint(0)},
+		{"string to int", "3", 0},
+		{"int to string", 3, ""},
+		{"none to int", nil, 0},
+		{"undefined to string", NewUndefined("x", UndefinedSilent, nil), ""},
+		{"bad element", []interface{}{"a", 1}, []string{}},
+	}
+	for _, tt := range failures {
+		if _, err := convertArgument(tt.value, reflect.TypeOf(tt.target)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
 }
 
 // TestSetAttributeAndItem tests setting attributes and items
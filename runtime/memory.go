@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// ErrorTypeMemory is the type of the RuntimeError reported when a render
+// creates more data than its memory limit allows
+const ErrorTypeMemory = "MemoryError"
+
+// MemoryItemSize is the number of bytes charged for each item of a sequence
+// a render creates, the size of an interface value
+const MemoryItemSize = 16
+
+// MemoryCoster is implemented by callables, such as range(), that can tell
+// from the arguments of a call how many bytes its result takes. Renders with
+// a memory limit charge them before the call.
+type MemoryCoster interface {
+	MemoryCost(args []interface{}) int
+}
+
+// FilterMemoryContext is implemented by contexts that can tell how many
+// bytes the result of a filter takes, for the filters whose result can be
+// much larger than their input. Renders with a memory limit charge them
+// before the filter runs.
+type FilterMemoryContext interface {
+	FilterMemoryCost(name string, value interface{}, args []interface{}) int
+}
+
+// SetMaxRenderMemory bounds the bytes of the strings and sequences the
+// renders that follow create with operators, range() and filters such as
+// join and batch. Going past it fails the render with a MemoryError naming
+// the operation. 0 or less, the default, means no limit. The count restarts
+// with each call.
+func (e *DefaultEvaluator) SetMaxRenderMemory(limit int) {
+	e.maxMemory = limit
+	e.memory = 0
+}
+
+// chargeMemory adds the bytes operation allocates at node to those of the
+// render, failing once there are more than the limit allows
+func (e *DefaultEvaluator) chargeMemory(bytes int, operation string, node parser.Node) error {
+	if e.maxMemory <= 0 {
+		return nil
+	}
+	e.memory = saturatingAdd(e.memory, bytes)
+	if e.memory <= e.maxMemory {
+		return nil
+	}
+	runtimeErr := NewRuntimeError(ErrorTypeMemory, fmt.Sprintf("%s exceeded the render memory limit of %d bytes", operation, e.maxMemory), node).
+		WithSuggestion("Work on smaller values, or raise the limit with WithMaxRenderMemory")
+	runtimeErr.TemplateName = e.currentTemplate()
+	return runtimeErr
+}
+
+// MemorySize returns the bytes taken by count values of size bytes each,
+// saturating instead of overflowing, for MemoryCost implementations
+func MemorySize(count, size int) int {
+	if count <= 0 || size <= 0 {
+		return 0
+	}
+	if count > math.MaxInt/size {
+		return math.MaxInt
+	}
+	return count * size
+}
+
+func saturatingAdd(a, b int) int {
+	if b > math.MaxInt-a {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+func isMemoryError(err error) bool {
+	var runtimeErr *RuntimeError
+	return errors.As(err, &runtimeErr) && runtimeErr.Type == ErrorTypeMemory
+}
@@ -0,0 +1,321 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// lookupMember finds the exported field or method of a Go value named by
+// attr. A name matches as written, with its first letter capitalized, or
+// ignoring case and underscores, so get_user finds GetUser and html_title
+// finds HTMLTitle. Fields are preferred to methods. Methods with pointer
+// receivers are found on values that aren't pointers too; they are called on
+// a copy, so changes they make don't reach the template's value.
+//
+// Fields promoted from embedded structs are found as Go finds them, and a
+// value held in an interface, as a field or pointed to, is looked up by its
+// dynamic type. The keys of maps are found like fields, as mapEntry finds
+// them.
+func lookupMember(obj interface{}, attr string) (member reflect.Value, isMethod bool) {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return reflect.Value{}, false
+	}
+
+	sv := v
+	if sv.Kind() == reflect.Ptr {
+		if sv.IsNil() {
+			return reflect.Value{}, false
+		}
+		sv = sv.Elem()
+	}
+	switch sv.Kind() {
+	case reflect.Struct:
+		if field := structField(sv, attr); field.IsValid() {
+			return field, false
+		}
+	case reflect.Map:
+		if value := mapEntry(sv, attr); value.IsValid() {
+			return value, false
+		}
+	}
+
+	if method := methodByAttr(v, attr); method.IsValid() {
+		return method, true
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		if method := methodByAttr(ptr, attr); method.IsValid() {
+			return method, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// structField returns the exported field of the struct sv named by attr
+func structField(sv reflect.Value, attr string) reflect.Value {
+	for _, name := range [2]string{attr, capitalizeFirst(attr)} {
+		if f, ok := sv.Type().FieldByName(name); ok && f.IsExported() {
+			if field, err := sv.FieldByIndexErr(f.Index); err == nil {
+				return field
+			}
+		}
+	}
+	for _, f := range reflect.VisibleFields(sv.Type()) {
+		if f.IsExported() && matchesGoName(attr, f.Name) {
+			if field, err := sv.FieldByIndexErr(f.Index); err == nil {
+				return field
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+// LookupAttribute returns the attribute called name of obj as obj.name reads
+// it in a template: the key of a map, the field of a struct, including those
+// promoted from embedded structs, or the attribute of a namespace, loop or
+// other template object. Methods aren't returned, so filters reading
+// attributes can't call what the sandbox forbids. found is false when obj has
+// no such attribute.
+func LookupAttribute(obj interface{}, name string) (value interface{}, found bool) {
+	switch v := obj.(type) {
+	case nil:
+		return nil, false
+	case NamespaceInterface:
+		return v.Get(name)
+	case *CallableLoop:
+		return v.GetAttribute(name)
+	case *TemplateInfo:
+		return v.GetAttribute(name)
+	case map[string]interface{}:
+		value, found = v[name]
+		return value, found
+	case *Dict:
+		return v.Get(name)
+	case map[string]string:
+		value, found = v[name]
+		return value, found
+	}
+	if member, isMethod := lookupMember(obj, name); member.IsValid() && !isMethod {
+		return member.Interface(), true
+	}
+	return nil, false
+}
+
+// LookupItem returns the item of obj under key as obj[key] reads it in a
+// template: the entry of a map, as mapItem finds it, or the item of a list,
+// string or other sequence at an integer index, or a float with an integral
+// value, counting from the end when negative. found is false when obj has no such item.
+func LookupItem(obj interface{}, key interface{}) (value interface{}, found bool) {
+	switch v := obj.(type) {
+	case nil:
+		return nil, false
+	case map[string]interface{}:
+		value, found = v[fmt.Sprintf("%v", key)]
+		return value, found
+	case *Dict:
+		return v.Get(fmt.Sprintf("%v", key))
+	}
+	if rv := reflect.ValueOf(obj); rv.Kind() == reflect.Map {
+		if entry := mapItem(rv, key); entry.IsValid() {
+			return entry.Interface(), true
+		}
+		return nil, false
+	}
+	var index int
+	switch k := reflect.ValueOf(key); k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		index = int(k.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		index = int(k.Uint())
+	case reflect.Float32, reflect.Float64:
+		if k.Float() != float64(int(k.Float())) {
+			return nil, false
+		}
+		index = int(k.Float())
+	default:
+		return nil, false
+	}
+	if rv := reflect.ValueOf(obj); (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && index < 0 {
+		index += rv.Len()
+	}
+
+	var e DefaultEvaluator
+	value, err := e.getItem(obj, index)
+	if err != nil || IsUndefined(value) {
+		return nil, false
+	}
+	return value, true
+}
+
+// mapEntry returns the value of the map m under the key written attr: attr
+// itself for string keys, or the number or boolean attr is the text of for
+// keys of those kinds. Maps with interface{} keys, as YAML decoders produce,
+// are tried with attr as a string, then as an int, int64, uint64, float64 or
+// bool, the types those decoders give keys.
+func mapEntry(m reflect.Value, attr string) reflect.Value {
+	keyType := m.Type().Key()
+	if keyType.Kind() != reflect.Interface {
+		if key, ok := parseMapKey(attr, keyType); ok {
+			return m.MapIndex(key)
+		}
+		return reflect.Value{}
+	}
+	for _, kind := range [...]reflect.Type{stringType, intType, int64Type, uint64Type, float64Type, boolType} {
+		if !kind.AssignableTo(keyType) {
+			continue
+		}
+		if key, ok := parseMapKey(attr, kind); ok {
+			if value := m.MapIndex(key); value.IsValid() {
+				return value
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+var (
+	stringType  = reflect.TypeOf("")
+	intType     = reflect.TypeOf(0)
+	int64Type   = reflect.TypeOf(int64(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float64Type = reflect.TypeOf(0.0)
+	boolType    = reflect.TypeOf(false)
+)
+
+// parseMapKey returns the value of type keyType that text is written as in
+// a template, if keyType is a string, number or boolean type and text a
+// value of it. Booleans are written true or false, as either Python or Go
+// prints them.
+func parseMapKey(text string, keyType reflect.Type) (reflect.Value, bool) {
+	var key interface{}
+	var err error
+	switch keyType.Kind() {
+	case reflect.String:
+		key = text
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		key, err = strconv.ParseInt(text, 10, keyType.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		key, err = strconv.ParseUint(text, 10, keyType.Bits())
+	case reflect.Float32, reflect.Float64:
+		key, err = strconv.ParseFloat(text, keyType.Bits())
+	case reflect.Bool:
+		switch text {
+		case "true", "True":
+			key = true
+		case "false", "False":
+			key = false
+		default:
+			return reflect.Value{}, false
+		}
+	default:
+		return reflect.Value{}, false
+	}
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(key).Convert(keyType), true
+}
+
+// mapItem returns the value of the map m under key, as m[key] reads it in a
+// template: key itself when the map's keys can be of its type, else the key
+// whose text key is, as mapEntry finds it
+func mapItem(m reflect.Value, key interface{}) reflect.Value {
+	if key != nil {
+		k := reflect.ValueOf(key)
+		if k.Type().AssignableTo(m.Type().Key()) && k.Comparable() {
+			if value := m.MapIndex(k); value.IsValid() {
+				return value
+			}
+		}
+	}
+	return mapEntry(m, fmt.Sprintf("%v", key))
+}
+
+// mapEntryFold returns the value of the map m under the string key equal to
+// attr ignoring case, or under the least such key if there are several. It
+// looks at every key of m.
+func mapEntryFold(m reflect.Value, attr string) reflect.Value {
+	var found reflect.Value
+	var foundKey string
+	iter := m.MapRange()
+	for iter.Next() {
+		key := iter.Key()
+		for key.Kind() == reflect.Interface {
+			key = key.Elem()
+		}
+		if key.Kind() != reflect.String {
+			continue
+		}
+		if name := key.String(); strings.EqualFold(name, attr) && (!found.IsValid() || name < foundKey) {
+			found, foundKey = iter.Value(), name
+		}
+	}
+	return found
+}
+
+// methodByAttr returns the method of v named by attr
+func methodByAttr(v reflect.Value, attr string) reflect.Value {
+	if method := v.MethodByName(attr); method.IsValid() {
+		return method
+	}
+	if method := v.MethodByName(capitalizeFirst(attr)); method.IsValid() {
+		return method
+	}
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		if matchesGoName(attr, t.Method(i).Name) {
+			return v.Method(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// matchesGoName reports whether the template name attr, such as get_user_id,
+// names the Go identifier name, such as GetUserID
+func matchesGoName(attr, name string) bool {
+	return strings.EqualFold(strings.ReplaceAll(attr, "_", ""), name)
+}
+
+// SetSafeTypes restricts the Go types whose methods templates may call to
+// those isSafe accepts. A pointer type is safe when the type it points to is.
+// nil, the default, allows the methods of any type, unless sandboxed.
+func (e *DefaultEvaluator) SetSafeTypes(isSafe func(reflect.Type) bool) {
+	e.isSafeType = isSafe
+}
+
+// checkMethodAllowed reports an error when attr names a method of obj whose
+// type isn't safe, a SecurityError when sandboxed
+func (e *DefaultEvaluator) checkMethodAllowed(obj interface{}, attr string, node parser.Node) error {
+	if e.isSafeType == nil && e.sandbox == nil {
+		return nil
+	}
+	// The attributes of a Dict are its entries and dict methods, never its
+	// Go methods
+	if _, ok := obj.(*Dict); ok {
+		return nil
+	}
+	if _, isMethod := lookupMember(obj, attr); !isMethod {
+		return nil
+	}
+	t := reflect.TypeOf(obj)
+	if e.isSafeType != nil && (e.isSafeType(t) || (t.Kind() == reflect.Ptr && e.isSafeType(t.Elem()))) {
+		return nil
+	}
+	message := fmt.Sprintf("calling method '%s' of %v is not allowed", attr, t)
+	suggestion := fmt.Sprintf("Register %v with RegisterSafeType to let templates call its methods", t)
+	if e.sandbox != nil {
+		securityErr := e.securityError(attr, message+" in the sandbox", node)
+		securityErr.Suggestion = suggestion
+		return securityErr
+	}
+	return NewRuntimeError(ErrorTypeAccess, message, node).WithSuggestion(suggestion)
+}
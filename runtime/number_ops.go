@@ -0,0 +1,178 @@
+package runtime
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// numberValue returns the value of a number as an int64 when it is a whole
+// number that fits one, and as a float64 otherwise. ok is false for values
+// that aren't numbers; numeric strings other than json.Number are not.
+func numberValue(value interface{}) (i int64, f float64, isInt, ok bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), float64(v), true, true
+	case int8:
+		return int64(v), float64(v), true, true
+	case int16:
+		return int64(v), float64(v), true, true
+	case int32:
+		return int64(v), float64(v), true, true
+	case int64:
+		return v, float64(v), true, true
+	case uint:
+		return int64(v), float64(v), v <= 1<<63-1, true
+	case uint8:
+		return int64(v), float64(v), true, true
+	case uint16:
+		return int64(v), float64(v), true, true
+	case uint32:
+		return int64(v), float64(v), true, true
+	case uint64:
+		return int64(v), float64(v), v <= 1<<63-1, true
+	case float32:
+		return 0, float64(v), false, true
+	case float64:
+		return 0, v, false, true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, float64(n), true, true
+		}
+		if n, err := v.Float64(); err == nil {
+			return 0, n, false, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// equalNumbers implements == for numbers of any Go type and json.Number, so
+// that 1 == 1.0 and a decoded JSON 42 equals 42. ok is false when either
+// operand isn't a number.
+func equalNumbers(a, b interface{}) (equal, ok bool) {
+	ai, af, aInt, aOk := numberValue(a)
+	bi, bf, bInt, bOk := numberValue(b)
+	if !aOk || !bOk {
+		return false, false
+	}
+	if aInt && bInt {
+		return ai == bi, true
+	}
+	return af == bf, true
+}
+
+// numericString returns the value of a string that parses cleanly as a
+// decimal number, such as "42" or "-1.5e3" read from a form, like
+// numberValue. ok is false for anything else, including surrounding spaces,
+// hexadecimal, and NaN or infinity.
+func numericString(s string) (i int64, f float64, isInt, ok bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, float64(n), true, true
+	}
+	if strings.ContainsAny(s, "xXpP_") {
+		return 0, 0, false, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, 0, false, false
+	}
+	return 0, n, false, true
+}
+
+// compareNumbers orders two numbers, or a number and a numeric string, or
+// two numeric strings, returning -1, 0 or 1. ok is false when either operand
+// is neither.
+func compareNumbers(a, b interface{}) (c int, ok bool) {
+	ai, af, aInt, aOk := numberValue(a)
+	if s, isString := a.(string); isString {
+		ai, af, aInt, aOk = numericString(s)
+	}
+	bi, bf, bInt, bOk := numberValue(b)
+	if s, isString := b.(string); isString {
+		bi, bf, bInt, bOk = numericString(s)
+	}
+	if !aOk || !bOk {
+		return 0, false
+	}
+	if aInt && bInt {
+		return cmp.Compare(ai, bi), true
+	}
+	return cmp.Compare(af, bf), true
+}
+
+// jsonNumberToInt converts a json.Number to an int, truncating fractions
+func jsonNumberToInt(n json.Number) (int, error) {
+	if i, err := n.Int64(); err == nil {
+		return int(i), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	return int(f), err
+}
+
+// maxExactFloat is the magnitude up to which float64 holds every integer
+const maxExactFloat = 1 << 53
+
+// largeIntegerArithmetic applies +, - or * to two integers whose result a
+// float64 can't hold exactly, such as a 64-bit id decoded from JSON plus
+// one. Smaller results are left to float arithmetic, as are operands that
+// aren't integers and results that overflow int64, with ok false.
+func largeIntegerArithmetic(op byte, a, b interface{}) (result int64, ok bool) {
+	ai, _, aInt, aOk := numberValue(a)
+	bi, _, bInt, bOk := numberValue(b)
+	if !aOk || !bOk || !aInt || !bInt {
+		return 0, false
+	}
+	var r int64
+	switch op {
+	case '+':
+		r = ai + bi
+		if (r > ai) != (bi > 0) {
+			return 0, false
+		}
+	case '-':
+		r = ai - bi
+		if (r < ai) != (bi > 0) {
+			return 0, false
+		}
+	case '*':
+		r = ai * bi
+		if ai != 0 && (r/ai != bi || (ai == -1 && bi == math.MinInt64)) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if r >= -maxExactFloat && r <= maxExactFloat {
+		return 0, false
+	}
+	return r, true
+}
+
+// boolArithmeticOperands returns a and b with booleans as 0 and 1 when each
+// is a boolean or a number, as Python treats them in arithmetic. Tests bind
+// tighter than arithmetic, so 2 * 3 is even multiplies 2 by false.
+func boolArithmeticOperands(a, b interface{}) (interface{}, interface{}) {
+	_, aBool := a.(bool)
+	_, bBool := b.(bool)
+	if !aBool && !bBool {
+		return a, b
+	}
+	asNumber := func(v interface{}) (interface{}, bool) {
+		if flag, ok := v.(bool); ok {
+			if flag {
+				return 1, true
+			}
+			return 0, true
+		}
+		_, _, _, ok := numberValue(v)
+		return v, ok
+	}
+	an, aOk := asNumber(a)
+	bn, bOk := asNumber(b)
+	if !aOk || !bOk {
+		return a, b
+	}
+	return an, bn
+}
@@ -5,11 +5,16 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/zipreport/miya/parser"
 )
 
 // OptimizedEvaluator is a performance-optimized version of the default evaluator
+//
+// Deprecated: only the top level of a template is evaluated differently,
+// everything below it goes through DefaultEvaluator, and BenchmarkEvaluators
+// shows no gain over DefaultEvaluator. Use DefaultEvaluator instead.
 type OptimizedEvaluator struct {
 	*DefaultEvaluator
 	stringBuilderPool sync.Pool
@@ -292,12 +297,30 @@ func (e *OptimizedEvaluator) EvaluateBatch(batch *BatchEvaluation, ctx Context)
 	}
 }
 
-// CachedEvaluator adds caching capabilities to the optimized evaluator
+// CachedEvaluator adds caching capabilities to the optimized evaluator. Only
+// nodes whose value can't depend on the context are cached: text, literals,
+// and operator expressions over them, such as 60 * 60. Anything referencing
+// a variable, or calling a filter, test or function, is evaluated every time.
+//
+// Deprecated: FoldConstants computes the same values once, when a template is
+// loaded, and environments apply it to every template, which leaves this
+// cache nothing to save. Use DefaultEvaluator instead.
 type CachedEvaluator struct {
 	*OptimizedEvaluator
 	cache     sync.Map
-	cacheHits int64
-	cacheMiss int64
+	entries   atomic.Int64
+	cacheHits atomic.Int64
+	cacheMiss atomic.Int64
+	bypassed  atomic.Int64
+}
+
+// CachedEvaluatorStats reports how effective a CachedEvaluator's cache is
+type CachedEvaluatorStats struct {
+	Hits     int64   // evaluations answered from the cache
+	Misses   int64   // cacheable evaluations that had to be computed
+	Bypassed int64   // evaluations of nodes that can't be cached
+	Entries  int64   // cached node values
+	HitRate  float64 // Hits over all evaluations, cacheable or not
 }
 
 // NewCachedEvaluator creates a new cached evaluator
@@ -307,37 +330,74 @@ func NewCachedEvaluator() *CachedEvaluator {
 	}
 }
 
-// cacheKey generates a cache key for a node and context
-func (e *CachedEvaluator) cacheKey(node parser.Node, ctx Context) string {
-	// Simple cache key - in production you might want more sophisticated hashing
-	return fmt.Sprintf("%T:%p", node, node)
-}
-
-// EvalNodeCached evaluates a node with caching
+// EvalNodeCached evaluates a node, caching the value of context independent
+// nodes
 func (e *CachedEvaluator) EvalNodeCached(node parser.Node, ctx Context) (interface{}, error) {
-	// Only cache certain types of nodes that are expensive and likely to be repeated
-	switch node.(type) {
-	case *parser.FilterNode, *parser.TestNode:
-		key := e.cacheKey(node, ctx)
-		if cached, ok := e.cache.Load(key); ok {
-			e.cacheHits++
-			return cached, nil
+	if !isContextIndependent(node) {
+		e.bypassed.Add(1)
+		return e.EvalNode(node, ctx)
+	}
+
+	if cached, ok := e.cache.Load(node); ok {
+		e.cacheHits.Add(1)
+		return cached, nil
+	}
+	e.cacheMiss.Add(1)
+
+	result, err := e.EvalNode(node, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch result.(type) {
+	case string, bool, int, int64, float64:
+		if _, loaded := e.cache.LoadOrStore(node, result); !loaded {
+			e.entries.Add(1)
 		}
+	}
+	return result, nil
+}
 
-		result, err := e.EvalNode(node, ctx)
-		if err == nil {
-			e.cache.Store(key, result)
+// isContextIndependent reports whether a node's value is the same whatever
+// the context: it is text, a literal, or an operator expression over those
+func isContextIndependent(node parser.Node) bool {
+	switch n := node.(type) {
+	case *parser.TextNode, *parser.RawNode, *parser.CommentNode, *parser.LiteralNode:
+		return true
+	case *parser.BinaryOpNode:
+		return isContextIndependent(n.Left) && isContextIndependent(n.Right)
+	case *parser.CompareNode:
+		for _, comparator := range n.Comparators {
+			if !isContextIndependent(comparator) {
+				return false
+			}
 		}
-		e.cacheMiss++
-		return result, err
-	default:
-		return e.EvalNode(node, ctx)
+		return isContextIndependent(n.Left)
+	case *parser.UnaryOpNode:
+		return isContextIndependent(n.Operand)
+	case *parser.ConditionalNode:
+		return n.FalseExpr != nil && isContextIndependent(n.Condition) &&
+			isContextIndependent(n.TrueExpr) && isContextIndependent(n.FalseExpr)
 	}
+	return false
 }
 
 // GetCacheStats returns cache performance statistics
 func (e *CachedEvaluator) GetCacheStats() (hits, misses int64) {
-	return e.cacheHits, e.cacheMiss
+	return e.cacheHits.Load(), e.cacheMiss.Load()
+}
+
+// Stats returns the cache's hit, miss and bypass counts and its hit rate
+func (e *CachedEvaluator) Stats() CachedEvaluatorStats {
+	stats := CachedEvaluatorStats{
+		Hits:     e.cacheHits.Load(),
+		Misses:   e.cacheMiss.Load(),
+		Bypassed: e.bypassed.Load(),
+		Entries:  e.entries.Load(),
+	}
+	if total := stats.Hits + stats.Misses + stats.Bypassed; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
 }
 
 // ClearCache clears the evaluation cache
@@ -346,8 +406,10 @@ func (e *CachedEvaluator) ClearCache() {
 		e.cache.Delete(key)
 		return true
 	})
-	e.cacheHits = 0
-	e.cacheMiss = 0
+	e.entries.Store(0)
+	e.cacheHits.Store(0)
+	e.cacheMiss.Store(0)
+	e.bypassed.Store(0)
 }
 
 // MemoryEfficientContext is a context implementation optimized for memory usage
@@ -384,6 +446,12 @@ func (c *MemoryEfficientContext) GetVariable(key string) (interface{}, bool) {
 	return val, ok
 }
 
+// HasVariable reports whether a variable is set in any scope
+func (c *MemoryEfficientContext) HasVariable(key string) bool {
+	_, ok := c.GetVariable(key)
+	return ok
+}
+
 // SetVariable sets a variable value
 func (c *MemoryEfficientContext) SetVariable(key string, value interface{}) {
 	if len(c.stack) > 0 {
@@ -0,0 +1,74 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// TestCachedEvaluatorContexts evaluates the same nodes with two contexts,
+// which an earlier cache keyed by node alone answered with the first result
+func TestCachedEvaluatorContexts(t *testing.T) {
+	ast, err := parser.Parse("greeting", `{{ name|upper }},{{ name is lower }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := ast.Children[0].(*parser.VariableNode).Expression
+	test := ast.Children[2].(*parser.VariableNode).Expression
+
+	cached := NewCachedEvaluator()
+	for _, tt := range []struct {
+		node       parser.Node
+		ada, grace string
+	}{
+		{filter, "ADA", "GRACE"},
+		{test, "true", "false"},
+		{ast, "ADA,true", "GRACE,false"},
+	} {
+		for _, run := range []struct{ name, want string }{{"ada", tt.ada}, {"GRACE", tt.grace}, {"ada", tt.ada}} {
+			result, err := cached.EvalNodeCached(tt.node, &simpleContext{variables: map[string]interface{}{"name": run.name}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ToString(result); got != run.want {
+				t.Errorf("%T with name %q = %q, want %q", tt.node, run.name, got, run.want)
+			}
+		}
+	}
+	if hits, _ := cached.GetCacheStats(); hits != 0 {
+		t.Errorf("cache hits = %d, want 0", hits)
+	}
+}
+
+func TestCachedEvaluatorStats(t *testing.T) {
+	cached := NewCachedEvaluator()
+	constant := parser.NewBinaryOpNode(parser.NewLiteralNode(6, "6", 1, 1), "*", parser.NewLiteralNode(7, "7", 1, 5), 1, 1)
+	variable := parser.NewIdentifierNode("x", 1, 1)
+	ctx := &simpleContext{variables: map[string]interface{}{"x": 1}}
+
+	for i := 0; i < 3; i++ {
+		result, err := cached.EvalNodeCached(constant, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ToString(result) != "42" {
+			t.Errorf("6 * 7 = %v", result)
+		}
+	}
+	if _, err := cached.EvalNodeCached(variable, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := cached.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Bypassed != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 2 hits, 1 miss, 1 bypassed and 1 entry", stats)
+	}
+	if stats.HitRate != 0.5 {
+		t.Errorf("hit rate = %v, want 0.5", stats.HitRate)
+	}
+
+	cached.ClearCache()
+	if stats := cached.Stats(); stats != (CachedEvaluatorStats{}) {
+		t.Errorf("stats after ClearCache = %+v, want zero", stats)
+	}
+}
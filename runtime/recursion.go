@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxRecursionDepth bounds how deeply macro calls, includes and
+// recursive loops nest, so a macro calling itself without end fails instead
+// of exhausting the stack
+const DefaultMaxRecursionDepth = 500
+
+// ErrorTypeRecursion is the type of the RuntimeError reported when the
+// recursion depth limit is reached
+const ErrorTypeRecursion = "RecursionError"
+
+// recursiveLoop is the kind enterNested counts recursive loop levels as
+const recursiveLoop = "loop"
+
+// SetMaxRecursionDepth sets how deeply macro calls, includes and recursive
+// loops may nest; 0 or less restores DefaultMaxRecursionDepth
+func (e *DefaultEvaluator) SetMaxRecursionDepth(depth int) {
+	e.maxRecursionDepth = depth
+}
+
+// enterNested counts a macro call, include or recursive loop level entered
+// at line until leaveNested, failing once the depth limit is reached, with a
+// SecurityError when sandboxed. kind and name are those of its call frame, or
+// recursiveLoop for loops.
+func (e *DefaultEvaluator) enterNested(kind, name string, line int) error {
+	limit := e.maxRecursionDepth
+	if limit <= 0 {
+		limit = DefaultMaxRecursionDepth
+	}
+	if e.depth >= limit {
+		runtimeErr := e.recursionError(kind, name, line, limit)
+		if e.sandbox != nil {
+			runtimeErr.Type = ErrorTypeSecurity
+			runtimeErr.Suggestion = "Check that the recursion ends, or raise MaxRecursionDepth in the sandbox limits"
+			return &SecurityError{RuntimeError: runtimeErr, Name: "recursion depth"}
+		}
+		return runtimeErr
+	}
+	e.depth++
+	return nil
+}
+
+func (e *DefaultEvaluator) leaveNested() {
+	e.depth--
+}
+
+// recursionError reports reaching the depth limit on entering name, with the
+// frames since name was last entered as the cycle
+func (e *DefaultEvaluator) recursionError(kind, name string, line, limit int) *RuntimeError {
+	var message string
+	if kind == recursiveLoop {
+		message = fmt.Sprintf("maximum recursion depth %d exceeded in recursive loop", limit)
+	} else {
+		start := len(e.callStack)
+		for i := len(e.callStack) - 1; i >= 0; i-- {
+			if frame := e.callStack[i]; frame.Kind == kind && frame.Name == name {
+				start = i
+				break
+			}
+		}
+		path := make([]string, 0, len(e.callStack)-start+1)
+		for _, frame := range e.callStack[start:] {
+			path = append(path, frame.Name)
+		}
+		path = append(path, name)
+		message = fmt.Sprintf("maximum recursion depth %d exceeded in %s '%s': %s", limit, kind, name, strings.Join(path, " -> "))
+	}
+
+	runtimeErr := NewRuntimeError(ErrorTypeRecursion, message, nil).
+		WithSuggestion("Check that the recursion ends, or raise the limit with WithMaxRecursionDepth")
+	runtimeErr.TemplateName = e.currentTemplate()
+	runtimeErr.Line = line
+	return runtimeErr
+}
+
+func isRecursionError(err error) bool {
+	var runtimeErr *RuntimeError
+	return errors.As(err, &runtimeErr) && runtimeErr.Type == ErrorTypeRecursion
+}
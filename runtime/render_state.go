@@ -0,0 +1,155 @@
+package runtime
+
+// TemplateInfoVariable is the name under which the render state is exposed to templates
+const TemplateInfoVariable = "_template"
+
+// renderFrame records a template that is currently being evaluated
+type renderFrame struct {
+	name    string
+	parents []string
+}
+
+// RenderState tracks which template, block and include chain is active during a render.
+// It is shared by every context cloned from the render's root context, so pushes and
+// pops performed while evaluating nested nodes are visible to the whole render.
+type RenderState struct {
+	frames   []renderFrame
+	blocks   []string
+	includes []string
+}
+
+// NewRenderState creates a render state for the named top-level template.
+// parents is the extends chain, from the direct parent up to the root template.
+func NewRenderState(name string, parents []string) *RenderState {
+	return &RenderState{
+		frames: []renderFrame{{name: name, parents: parents}},
+	}
+}
+
+// CurrentTemplate returns the name of the template whose code is being evaluated
+func (s *RenderState) CurrentTemplate() string {
+	if len(s.frames) == 0 {
+		return ""
+	}
+	return s.frames[len(s.frames)-1].name
+}
+
+// PushTemplate marks the start of code defined in another template (e.g. a macro body)
+func (s *RenderState) PushTemplate(name string) {
+	s.frames = append(s.frames, renderFrame{name: name})
+}
+
+// PopTemplate undoes the most recent PushTemplate
+func (s *RenderState) PopTemplate() {
+	if len(s.frames) > 1 {
+		s.frames = s.frames[:len(s.frames)-1]
+	}
+}
+
+// PushBlock marks the start of a block's evaluation
+func (s *RenderState) PushBlock(name string) {
+	s.blocks = append(s.blocks, name)
+}
+
+// PopBlock undoes the most recent PushBlock
+func (s *RenderState) PopBlock() {
+	if len(s.blocks) > 0 {
+		s.blocks = s.blocks[:len(s.blocks)-1]
+	}
+}
+
+// PushInclude marks the start of an included template's evaluation
+func (s *RenderState) PushInclude(name string) {
+	s.includes = append(s.includes, name)
+	s.PushTemplate(name)
+}
+
+// PopInclude undoes the most recent PushInclude
+func (s *RenderState) PopInclude() {
+	if len(s.includes) > 0 {
+		s.includes = s.includes[:len(s.includes)-1]
+	}
+	s.PopTemplate()
+}
+
+// Info returns a read-only snapshot of the current state
+func (s *RenderState) Info() *TemplateInfo {
+	info := &TemplateInfo{
+		Includes: append([]string(nil), s.includes...),
+	}
+	if len(s.frames) > 0 {
+		frame := s.frames[len(s.frames)-1]
+		info.Name = frame.name
+		info.Parents = append([]string(nil), frame.parents...)
+	}
+	if len(s.blocks) > 0 {
+		info.Block = s.blocks[len(s.blocks)-1]
+	}
+	return info
+}
+
+// TemplateInfo is the object templates see as _template when introspection is enabled
+type TemplateInfo struct {
+	Name     string
+	Block    interface{} // innermost block name, or nil outside of blocks
+	Parents  []string
+	Includes []string
+}
+
+// GetAttribute allows access to _template.name, .block, .parents and .includes
+func (ti *TemplateInfo) GetAttribute(name string) (interface{}, bool) {
+	switch name {
+	case "name":
+		return ti.Name, true
+	case "block":
+		return ti.Block, true
+	case "parents":
+		return ti.Parents, true
+	case "includes":
+		return ti.Includes, true
+	}
+	return nil, false
+}
+
+// String returns the current template name for template output
+func (ti *TemplateInfo) String() string {
+	return ti.Name
+}
+
+// RenderStateProvider is implemented by contexts that carry a RenderState
+type RenderStateProvider interface {
+	RenderState() *RenderState
+}
+
+// renderStateContext attaches a RenderState to a context that does not carry one,
+// such as the definition context of an imported macro
+type renderStateContext struct {
+	Context
+	state *RenderState
+}
+
+func (c *renderStateContext) RenderState() *RenderState {
+	return c.state
+}
+
+func (c *renderStateContext) Clone() Context {
+	return &renderStateContext{Context: c.Context.Clone(), state: c.state}
+}
+
+// renderStateOf finds the RenderState carried by ctx, unwrapping the runtime's own
+// context wrappers. It returns nil when introspection is disabled.
+func renderStateOf(ctx Context) *RenderState {
+	for ctx != nil {
+		switch c := ctx.(type) {
+		case RenderStateProvider:
+			return c.RenderState()
+		case *autoescapeContext:
+			ctx = c.Context
+		case *ContextWrapper:
+			ctx = c.Context
+		default:
+			return nil
+		}
+	}
+	return nil
+}
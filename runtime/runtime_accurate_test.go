@@ -380,7 +380,8 @@ func TestAutoEscaper(t *testing.T) {
 			{"data.xml", EscapeContextXML},
 			{"page.xhtml", EscapeContextXHTML},
 			{"api.json", EscapeContextJSON},
-			{"readme.txt", EscapeContextHTML}, // Falls back to default context (HTML)
+			{"readme.txt", EscapeContextText},
+			{"readme.md", EscapeContextHTML}, // Falls back to default context (HTML)
 		}
 
 		for _, test := range tests {
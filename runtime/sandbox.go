@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// ErrorTypeSecurity is the type of the RuntimeError a SecurityError carries
+const ErrorTypeSecurity = "SecurityError"
+
+// SecurityError reports something a sandboxed template isn't allowed to do,
+// or a sandbox limit it reached. It carries a RuntimeError, so errors.As
+// finds either, with the template, position and call stack of the violation.
+type SecurityError struct {
+	*RuntimeError
+
+	// Name is the attribute, method, filter, global or limit involved
+	Name string
+}
+
+// Unwrap returns the RuntimeError describing the violation
+func (se *SecurityError) Unwrap() error {
+	return se.RuntimeError
+}
+
+// NewSecurityError creates a SecurityError about name, positioned at node.
+// Functions called by templates may return one with a nil node; the call
+// expression's position is filled in.
+func NewSecurityError(name, message string, node parser.Node) *SecurityError {
+	return &SecurityError{RuntimeError: NewRuntimeError(ErrorTypeSecurity, message, node), Name: name}
+}
+
+func isSecurityError(err error) bool {
+	var securityErr *SecurityError
+	return errors.As(err, &securityErr)
+}
+
+// Sandbox restricts what a render may do; see SetSandbox. A zero limit means
+// no limit.
+type Sandbox struct {
+	// MaxIterations bounds the items a render iterates over, counting every
+	// for loop, comprehension and expression filter
+	MaxIterations int
+
+	// MaxOutputSize bounds, in bytes, the output of the template and of each
+	// loop, macro, block and other body within it
+	MaxOutputSize int
+
+	// IsUnsafeFilter and IsUnsafeGlobal report whether the filter or the
+	// global variable or function called name may not be used; nil allows any
+	IsUnsafeFilter func(name string) bool
+	IsUnsafeGlobal func(name string) bool
+}
+
+// SetSandbox sandboxes the renders that follow: attributes starting with an
+// underscore can't be read, methods may only be called on safe types (see
+// SetSafeTypes), unsafe filters and globals can't be used, reaching a limit
+// of sandbox or the recursion depth limit fails the render, and all of these
+// fail with a SecurityError. nil, the default, lifts the restrictions. The
+// iteration count restarts with each call.
+func (e *DefaultEvaluator) SetSandbox(sandbox *Sandbox) {
+	e.sandbox = sandbox
+	e.iterations = 0
+}
+
+// checkAttributeAllowed reports a SecurityError when the sandbox forbids
+// reading attr of obj. The entries of maps stay readable whatever their keys.
+func (e *DefaultEvaluator) checkAttributeAllowed(obj interface{}, attr string, node parser.Node) error {
+	if e.sandbox == nil || !strings.HasPrefix(attr, "_") {
+		return nil
+	}
+	switch obj.(type) {
+	case map[string]interface{}, map[string]string, *Dict, NamespaceInterface:
+		return nil
+	}
+	return e.securityError(attr, fmt.Sprintf("access to attribute '%s' of %T is not allowed in the sandbox", attr, obj), node)
+}
+
+// checkFilterAllowed reports a SecurityError when name is an unsafe filter
+func (e *DefaultEvaluator) checkFilterAllowed(name string, node parser.Node) error {
+	if e.sandbox == nil || e.sandbox.IsUnsafeFilter == nil || !e.sandbox.IsUnsafeFilter(name) {
+		return nil
+	}
+	return e.securityError(name, fmt.Sprintf("filter '%s' is unsafe and not allowed in the sandbox", name), node)
+}
+
+// checkGlobalAllowed reports a SecurityError when name is an unsafe global
+func (e *DefaultEvaluator) checkGlobalAllowed(name string, node parser.Node) error {
+	if e.sandbox == nil || e.sandbox.IsUnsafeGlobal == nil || !e.sandbox.IsUnsafeGlobal(name) {
+		return nil
+	}
+	return e.securityError(name, fmt.Sprintf("'%s' is unsafe and not allowed in the sandbox", name), node)
+}
+
+// countIterations adds n items to those the render has iterated over,
+// failing once there are more than the sandbox allows
+func (e *DefaultEvaluator) countIterations(n int, node parser.Node) error {
+	if e.sandbox == nil || e.sandbox.MaxIterations <= 0 {
+		return nil
+	}
+	e.iterations += n
+	if e.iterations > e.sandbox.MaxIterations {
+		return e.securityError("iterations", fmt.Sprintf("loops exceeded the sandbox limit of %d iterations", e.sandbox.MaxIterations), node)
+	}
+	return nil
+}
+
+// checkOutputSize fails once size bytes of output, produced so far by a
+// single body, are more than the sandbox allows
+func (e *DefaultEvaluator) checkOutputSize(size int, node parser.Node) error {
+	if e.sandbox == nil || e.sandbox.MaxOutputSize <= 0 || size <= e.sandbox.MaxOutputSize {
+		return nil
+	}
+	return e.securityError("output size", fmt.Sprintf("output exceeded the sandbox limit of %d bytes", e.sandbox.MaxOutputSize), node)
+}
+
+func (e *DefaultEvaluator) securityError(name, message string, node parser.Node) *SecurityError {
+	securityErr := NewSecurityError(name, message, node)
+	securityErr.TemplateName = e.currentTemplate()
+	return securityErr
+}
+
+// positionSecurityError gives a SecurityError returned by a function called
+// at node, without a position of its own, the position of the call
+func (e *DefaultEvaluator) positionSecurityError(err error, node parser.Node) {
+	var securityErr *SecurityError
+	if errors.As(err, &securityErr) && securityErr.Line == 0 {
+		securityErr.Line, securityErr.Column = node.Line(), node.Column()
+		securityErr.Node = node
+		if securityErr.TemplateName == "" {
+			securityErr.TemplateName = e.currentTemplate()
+		}
+	}
+}
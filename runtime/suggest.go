@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// maxSuggestionCandidates bounds how many names are compared when looking
+// for a did-you-mean suggestion, so huge maps don't slow down debug renders
+const maxSuggestionCandidates = 1000
+
+// handleMissing reports a missing variable or attribute through the undefined
+// handler. In debug mode the built-in hint is replaced by a did-you-mean
+// suggestion drawn from candidates when a close match exists.
+func (e *DefaultEvaluator) handleMissing(name, missing string, node parser.Node, candidates func() []string) (interface{}, error) {
+	value, err := e.undefinedHandler.Handle(name, node)
+	if undefined, ok := value.(*Undefined); ok && undefined.Behavior == UndefinedDebug && !e.undefinedHandler.customFactory {
+		if suggestion := closestName(missing, candidates()); suggestion != "" {
+			undefined.Hint = fmt.Sprintf("did you mean '%s'?", suggestion)
+		}
+	}
+	return value, err
+}
+
+// contextNames lists up to maxSuggestionCandidates variable names visible in ctx
+func contextNames(ctx Context) []string {
+	return mapKeys(ctx.All())
+}
+
+// attributeNames lists up to maxSuggestionCandidates attribute names of obj
+func attributeNames(obj interface{}) []string {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		return mapKeys(v)
+	case *Dict:
+		keys := v.Keys()
+		return keys[:min(len(keys), maxSuggestionCandidates)]
+	case *List:
+		return listMethods
+	case map[string]string:
+		names := make([]string, 0, min(len(v), maxSuggestionCandidates))
+		for name := range v {
+			if len(names) == maxSuggestionCandidates {
+				break
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	rv := reflect.ValueOf(obj)
+	for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+
+	var names []string
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField() && len(names) < maxSuggestionCandidates; i++ {
+			if t.Field(i).IsExported() {
+				names = append(names, t.Field(i).Name)
+			}
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		iter := rv.MapRange()
+		for iter.Next() && len(names) < maxSuggestionCandidates {
+			names = append(names, iter.Key().String())
+		}
+	}
+	return names
+}
+
+func mapKeys(m map[string]interface{}) []string {
+	names := make([]string, 0, min(len(m), maxSuggestionCandidates))
+	for name := range m {
+		if len(names) == maxSuggestionCandidates {
+			break
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ClosestName returns the candidate most likely meant by name, for
+// did-you-mean suggestions, or "" if none is close
+func ClosestName(name string, candidates []string) string {
+	return closestName(name, candidates)
+}
+
+// closestName returns the candidate most likely meant by missing, or "" if
+// none is close. Candidates within an edit distance of roughly a third of the
+// name's length qualify, as do names sharing a prefix of three or more
+// characters with it. Ties go to the alphabetically first name so output is
+// stable across map iteration orders.
+func closestName(missing string, candidates []string) string {
+	if missing == "" {
+		return ""
+	}
+	maxDistance := max(1, len(missing)/3)
+	lowerMissing := strings.ToLower(missing)
+
+	best, bestScore := "", maxDistance+1
+	for _, candidate := range candidates {
+		if candidate == missing || candidate == "" {
+			continue
+		}
+
+		score := maxDistance + 1
+		lowerCandidate := strings.ToLower(candidate)
+		switch {
+		case lowerCandidate == lowerMissing:
+			score = 0
+		case len(missing) >= 3 && (strings.HasPrefix(lowerCandidate, lowerMissing) || strings.HasPrefix(lowerMissing, lowerCandidate)):
+			score = maxDistance
+		default:
+			if diff := len(candidate) - len(missing); diff <= maxDistance && -diff <= maxDistance {
+				score = editDistance(lowerMissing, lowerCandidate, maxDistance)
+			}
+		}
+
+		if score < bestScore || (score == bestScore && score <= maxDistance && candidate < best) {
+			best, bestScore = candidate, score
+		}
+	}
+	if bestScore > maxDistance {
+		return ""
+	}
+	return best
+}
+
+// editDistance computes the edit distance between a and b, counting an
+// adjacent transposition ("uesr" for "user") as one edit. It gives up with
+// limit+1 once every alignment exceeds limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prevPrev[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prevPrev, prev, curr = prev, curr, prevPrev
+	}
+	return prev[len(rb)]
+}
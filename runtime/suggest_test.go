@@ -0,0 +1,48 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClosestName(t *testing.T) {
+	candidates := []string{"user", "users", "title", "items", "description"}
+	tests := []struct {
+		missing string
+		want    string
+	}{
+		{"usr", "user"},
+		{"uesr", "user"},
+		{"titel", "title"},
+		{"descr", "description"},
+		{"Title", "title"},
+		{"xyz", ""},
+		{"", ""},
+		{"user", "users"},
+	}
+	for _, tt := range tests {
+		if got := closestName(tt.missing, candidates); got != tt.want {
+			t.Errorf("closestName(%q) = %q, want %q", tt.missing, got, tt.want)
+		}
+	}
+}
+
+func TestAttributeNamesCapped(t *testing.T) {
+	big := make(map[string]interface{}, 10*maxSuggestionCandidates)
+	for i := 0; i < 10*maxSuggestionCandidates; i++ {
+		big[fmt.Sprintf("key%d", i)] = i
+	}
+	if n := len(attributeNames(big)); n != maxSuggestionCandidates {
+		t.Errorf("expected %d candidates, got %d", maxSuggestionCandidates, n)
+	}
+
+	type profile struct {
+		Name     string
+		Email    string
+		password string
+	}
+	names := attributeNames(&profile{})
+	if len(names) != 2 || names[0] != "Name" || names[1] != "Email" {
+		t.Errorf("expected exported struct fields, got %v", names)
+	}
+}
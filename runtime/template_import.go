@@ -3,6 +3,10 @@ package runtime
 import (
 	"fmt"
 	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/zipreport/miya/parser"
 )
@@ -13,25 +17,147 @@ type TemplateNamespace struct {
 	Macros       map[string]*TemplateMacro
 	Variables    map[string]interface{}
 	Context      Context
-	evaluator    *DefaultEvaluator // Store evaluator for macro calls
+
+	imports   []parser.Node   // Top-level import statements, replayed for macro calls
+	dependsOn []string        // Templates this one imports; a change to any invalidates it
+	declared  map[string]bool // Names listed by __exports__, nil if the template sets none
+}
+
+// ExportsName is the variable a template sets to a list of names to export
+// only those names to templates importing it
+const ExportsName = "__exports__"
+
+// Exported reports whether templates importing the namespace see name.
+// Names starting with an underscore are private, and a template setting
+// __exports__ exports only the names it lists.
+func (ns *TemplateNamespace) Exported(name string) bool {
+	if strings.HasPrefix(name, "_") {
+		return false
+	}
+	return ns.declared == nil || ns.declared[name]
+}
+
+// Exports returns the names of the exported macros and variables, sorted
+func (ns *TemplateNamespace) Exports() []string {
+	var names []string
+	for name := range ns.Macros {
+		if ns.Exported(name) {
+			names = append(names, name)
+		}
+	}
+	for name := range ns.Variables {
+		if _, isMacro := ns.Macros[name]; !isMacro && ns.Exported(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkImport returns an error for a name the namespace doesn't export to
+// {% from %}, listing those it does. A missing name that could be exported
+// is left to the caller.
+func (ns *TemplateNamespace) checkImport(name string) error {
+	if ns.Exported(name) {
+		return nil
+	}
+	exports := "it exports nothing"
+	if names := ns.Exports(); len(names) > 0 {
+		exports = "it exports " + strings.Join(names, ", ")
+	}
+	if strings.HasPrefix(name, "_") {
+		return fmt.Errorf("cannot import private name %q from template %q: %s", name, ns.TemplateName, exports)
+	}
+	return fmt.Errorf("template %q does not export %q: %s", ns.TemplateName, name, exports)
+}
+
+// declareExports records the names listed by the template's __exports__
+func (ns *TemplateNamespace) declareExports(value interface{}) error {
+	if l, ok := value.(*List); ok {
+		value = l.Items()
+	}
+	var names []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		names = v
+	case []string:
+		for _, name := range v {
+			names = append(names, name)
+		}
+	default:
+		return fmt.Errorf("%s must be a list of names, got %T", ExportsName, value)
+	}
+	ns.declared = make(map[string]bool, len(names))
+	for _, name := range names {
+		s, ok := name.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a list of names, got %v (%T) in it", ExportsName, name, name)
+		}
+		ns.declared[s] = true
+	}
+	return nil
+}
+
+// ModuleContextProvider is implemented by contexts that can create the
+// context a template imported without context runs in: the environment's
+// globals, but none of the importing render's variables. Namespaces built
+// in such a context are shared by all renders.
+type ModuleContextProvider interface {
+	ModuleContext() Context
+}
+
+// IsolatedContextProvider is implemented by contexts that can create the
+// context a template included with "only" runs in: the environment's globals
+// and the state of the current render, but none of its variables.
+type IsolatedContextProvider interface {
+	IsolatedContext() Context
+}
+
+// isolatedContext returns the context for a template included with "only"
+// from ctx
+func isolatedContext(ctx Context) Context {
+	switch c := ctx.(type) {
+	case IsolatedContextProvider:
+		return c.IsolatedContext()
+	case *autoescapeContext:
+		return &autoescapeContext{Context: isolatedContext(c.Context), autoescape: c.autoescape}
+	case *ContextWrapper:
+		return isolatedContext(c.Context)
+	}
+	return &simpleContext{variables: make(map[string]interface{})}
+}
+
+// IncludableTemplate is a loaded template that can be included directly
+// instead of by name, such as a *miya.Template held in a context variable
+type IncludableTemplate interface {
+	Name() string
+	AST() parser.Node
 }
 
-// ImportedNamespace is a wrapper for imported templates that supports attribute access
+// ImportedNamespace is a wrapper for imported templates that supports attribute access.
+// The TemplateNamespace is cached and shared by every render that imports the
+// template; values the importing render changes or must not share live in locals.
 type ImportedNamespace struct {
 	namespace *TemplateNamespace
 	evaluator *DefaultEvaluator
+	callCtx   Context                // Context of the importing template, if known
+	locals    map[string]interface{} // Per-import copies and assignments, shadowing namespace.Variables
 }
 
-// Get returns an attribute from the namespace (used for macro/variable access)
+// Get returns an attribute from the namespace (used for macro/variable access).
+// Names the template doesn't export are missing.
 func (in *ImportedNamespace) Get(name string) (interface{}, bool) {
 	// Check if it's a macro
-	if macro, ok := in.namespace.Macros[name]; ok {
+	if macro, ok := in.namespace.Macros[name]; ok && in.namespace.Exported(name) {
 		// Return a callable function for the macro
 		return in.createMacroFunction(macro), true
 	}
 
 	// Check if it's a variable
-	if value, ok := in.namespace.Variables[name]; ok {
+	if value, ok := in.locals[name]; ok {
+		return value, true
+	}
+	if value, ok := in.namespace.Variables[name]; ok && in.namespace.Exported(name) {
 		return value, true
 	}
 
@@ -48,33 +174,41 @@ func (in *ImportedNamespace) Get(name string) (interface{}, bool) {
 
 // Set sets a value in the namespace (implements NamespaceInterface)
 func (in *ImportedNamespace) Set(name string, value interface{}) {
-	// Allow setting variables in the namespace
-	in.namespace.Variables[name] = value
+	// Allow setting variables in the namespace, without touching the cached copy
+	if in.locals == nil {
+		in.locals = make(map[string]interface{})
+	}
+	in.locals[name] = value
 }
 
 // String returns a string representation of the namespace
 func (in *ImportedNamespace) String() string {
 	return fmt.Sprintf("<ImportedNamespace from '%s' with %d macros, %d variables>",
-		in.namespace.TemplateName, len(in.namespace.Macros), len(in.namespace.Variables))
+		in.namespace.TemplateName, len(in.namespace.Macros), in.variableCount())
+}
+
+// variableCount counts the namespace's variables including local assignments
+func (in *ImportedNamespace) variableCount() int {
+	count := len(in.namespace.Variables)
+	for name := range in.locals {
+		if _, cached := in.namespace.Variables[name]; !cached {
+			count++
+		}
+	}
+	return count
 }
 
 // createMacroFunction creates a callable function for a macro
 func (in *ImportedNamespace) createMacroFunction(macro *TemplateMacro) interface{} {
-	return func(args ...interface{}) (interface{}, error) {
-		// Parse keyword arguments if provided
-		kwargs := make(map[string]interface{})
-
-		// If the last argument is a map, treat it as kwargs
-		if len(args) > 0 {
-			if kw, ok := args[len(args)-1].(map[string]interface{}); ok {
-				kwargs = kw
-				args = args[:len(args)-1]
-			}
+	return MacroFunc(func(_ Context, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+		callCtx := in.callCtx
+		if callCtx == nil {
+			callCtx = in.namespace.Context
 		}
 
 		// Call the macro with the evaluator
-		return macro.Call(in.evaluator, in.namespace.Context, args, kwargs)
-	}
+		return macro.Call(in.evaluator, callCtx, args, kwargs)
+	})
 }
 
 // TemplateMacro represents a macro that can be called from templates
@@ -83,42 +217,68 @@ type TemplateMacro struct {
 	Parameters []string
 	Defaults   map[string]interface{}
 	Body       []parser.Node
-	Context    Context // The context in which the macro was defined
+	Context    Context       // The context in which the macro was defined
+	Template   string        // Name of the template that defines the macro
+	Imports    []parser.Node // Imports of the defining template, visible in the body
+
+	module *TemplateNamespace // Namespace of the defining template, whose names the body sees
 }
 
 // Call executes the macro with the given arguments
-func (tm *TemplateMacro) Call(evaluator *DefaultEvaluator, callCtx Context, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+func (tm *TemplateMacro) Call(evaluator *DefaultEvaluator, callCtx Context, args []interface{}, kwargs map[string]interface{}) (result interface{}, err error) {
+	if err := evaluator.enterNested(FrameMacro, tm.Name, evaluator.callLine); err != nil {
+		return nil, err
+	}
+	defer evaluator.leaveNested()
+	defer evaluator.pushFrame(FrameMacro, tm.Name, tm.Template, evaluator.callLine)()
+	defer func() {
+		if err != nil {
+			evaluator.attachCallStack(err)
+		}
+	}()
+
 	// Create a new context for macro execution
 	macroCtx := tm.Context.Clone()
 
-	// Set up macro parameters
-	for i, paramName := range tm.Parameters {
-		if i < len(args) {
-			macroCtx.SetVariable(paramName, args[i])
-		} else if defaultVal, hasDefault := tm.Defaults[paramName]; hasDefault {
-			// For now, evaluate default values as literals
-			if defaultExpr, ok := defaultVal.(parser.ExpressionNode); ok {
-				evalDefault, err := evaluator.EvalNode(defaultExpr, macroCtx)
-				if err != nil {
-					return nil, fmt.Errorf("error evaluating default value for parameter %s: %w", paramName, err)
-				}
-				macroCtx.SetVariable(paramName, evalDefault)
-			} else {
-				macroCtx.SetVariable(paramName, defaultVal)
-			}
-		} else {
-			return nil, fmt.Errorf("missing required macro parameter: %s", paramName)
-		}
+	// Report the defining template while the macro body runs
+	if state := renderStateOf(callCtx); state != nil {
+		macroCtx = &renderStateContext{Context: macroCtx, state: state}
+		state.PushTemplate(tm.Template)
+		defer state.PopTemplate()
+	}
+
+	// The body sees the other macros and the variables of its template,
+	// private or not
+	if tm.module != nil {
+		tm.module.bindModule(macroCtx, evaluator, callCtx)
 	}
 
-	// Set keyword arguments
-	for key, value := range kwargs {
-		macroCtx.SetVariable(key, value)
+	// Bind the defining template's imports with this render's evaluator;
+	// the namespaces themselves are cached
+	for _, imp := range tm.Imports {
+		if _, err := evaluator.EvalNode(imp, macroCtx); err != nil {
+			return nil, fmt.Errorf("error executing macro %s: %w", tm.Name, err)
+		}
 	}
 
-	// Inherit caller from call context if available
-	if caller, exists := callCtx.GetVariable("caller"); exists {
-		macroCtx.SetVariable("caller", caller)
+	// Set up macro parameters
+	err = bindMacroArguments(macroCtx, tm.Name, tm.Parameters, args, kwargs, func(param string) (interface{}, bool, error) {
+		defaultVal, hasDefault := tm.Defaults[param]
+		if !hasDefault {
+			return nil, false, nil
+		}
+		defaultExpr, ok := defaultVal.(parser.ExpressionNode)
+		if !ok {
+			return defaultVal, true, nil
+		}
+		evalDefault, err := evaluator.EvalNode(defaultExpr, macroCtx)
+		if err != nil {
+			return nil, true, fmt.Errorf("error evaluating default value for parameter %s: %w", param, err)
+		}
+		return evalDefault, true, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Execute macro body
@@ -152,7 +312,8 @@ type TemplateLoader interface {
 // ImportSystem handles template imports and namespace management
 type ImportSystem struct {
 	loader     TemplateLoader
-	namespaces map[string]*TemplateNamespace // Cache for loaded namespaces
+	namespaces map[string]*TemplateNamespace // Namespaces of templates imported without context
+	mu         sync.RWMutex                  // Protects namespaces
 }
 
 // NewImportSystem creates a new import system
@@ -164,55 +325,120 @@ func NewImportSystem(loader TemplateLoader, evaluator *DefaultEvaluator) *Import
 	}
 }
 
-// LoadTemplateNamespace loads a template and creates its namespace
+// LoadTemplateNamespace loads the namespace of a template imported without
+// context. It is built once and shared by later imports until the template,
+// or a template it imports, is invalidated.
 func (is *ImportSystem) LoadTemplateNamespace(templateName string, baseCtx Context, evaluator *DefaultEvaluator) (*TemplateNamespace, error) {
+	defer evaluator.recordImport(templateName)
+
 	// Check cache first
-	if ns, exists := is.namespaces[templateName]; exists {
+	is.mu.RLock()
+	ns, exists := is.namespaces[templateName]
+	is.mu.RUnlock()
+	if exists {
 		return ns, nil
 	}
 
-	// Check if template exists
-	if !is.loader.TemplateExists(templateName) {
-		// Create a placeholder namespace for missing templates
-		namespace := &TemplateNamespace{
-			TemplateName: templateName,
-			Macros:       make(map[string]*TemplateMacro),
-			Variables:    make(map[string]interface{}),
-			Context:      baseCtx.Clone(),
-		}
+	moduleCtx := baseCtx.Clone()
+	if provider, ok := baseCtx.(ModuleContextProvider); ok {
+		moduleCtx = provider.ModuleContext()
+	}
+	namespace, err := is.buildNamespace(templateName, moduleCtx, evaluator)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the namespace
+	is.mu.Lock()
+	is.namespaces[templateName] = namespace
+	is.mu.Unlock()
 
-		// Cache the placeholder namespace
-		is.namespaces[templateName] = namespace
+	return namespace, nil
+}
+
+// LoadTemplateNamespaceWithContext loads the namespace of a template imported
+// with context. It sees the importer's variables, so it is built for each
+// import rather than cached.
+func (is *ImportSystem) LoadTemplateNamespaceWithContext(templateName string, ctx Context, evaluator *DefaultEvaluator) (*TemplateNamespace, error) {
+	defer evaluator.recordImport(templateName)
+	return is.buildNamespace(templateName, ctx.Clone(), evaluator)
+}
+
+// buildNamespace loads a template and extracts its macros and variables in
+// moduleCtx. Importing a template that is still being built is a cycle.
+func (is *ImportSystem) buildNamespace(templateName string, moduleCtx Context, evaluator *DefaultEvaluator) (*TemplateNamespace, error) {
+	namespace := &TemplateNamespace{
+		TemplateName: templateName,
+		Macros:       make(map[string]*TemplateMacro),
+		Variables:    make(map[string]interface{}),
+		Context:      moduleCtx,
+	}
 
+	// Missing templates get an empty placeholder namespace
+	if !is.loader.TemplateExists(templateName) {
 		return namespace, nil
 	}
 
+	if evaluator != nil {
+		stack := make([]string, len(evaluator.importing))
+		for i, building := range evaluator.importing {
+			stack[i] = building.TemplateName
+		}
+		if slices.Contains(stack, templateName) {
+			return nil, newCycleError("import", stack, templateName)
+		}
+		evaluator.importing = append(evaluator.importing, namespace)
+		defer func() { evaluator.importing = evaluator.importing[:len(evaluator.importing)-1] }()
+	}
+
 	// Load the template AST
 	ast, err := is.loader.LoadTemplate(templateName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load template %q: %w", templateName, err)
 	}
 
-	// Create namespace
-	namespace := &TemplateNamespace{
-		TemplateName: templateName,
-		Macros:       make(map[string]*TemplateMacro),
-		Variables:    make(map[string]interface{}),
-		Context:      baseCtx.Clone(),
-	}
-
 	// Extract macros and variables from AST
 	err = is.extractNamespaceContent(ast, namespace, evaluator)
 	if err != nil {
+		if isCycleError(err) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to extract namespace from template %q: %w", templateName, err)
 	}
-
-	// Cache the namespace
-	is.namespaces[templateName] = namespace
+	for _, macro := range namespace.Macros {
+		macro.Imports = namespace.imports
+		macro.module = namespace
+	}
 
 	return namespace, nil
 }
 
+// InvalidateTemplate drops the cached namespace for a template, and those of
+// templates importing it, so the next import reloads them
+func (is *ImportSystem) InvalidateTemplate(templateName string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	stale := []string{templateName}
+	for len(stale) > 0 {
+		name := stale[len(stale)-1]
+		stale = stale[:len(stale)-1]
+		delete(is.namespaces, name)
+		for importer, ns := range is.namespaces {
+			if slices.Contains(ns.dependsOn, name) {
+				stale = append(stale, importer)
+			}
+		}
+	}
+}
+
+// Clear drops every cached namespace
+func (is *ImportSystem) Clear() {
+	is.mu.Lock()
+	is.namespaces = make(map[string]*TemplateNamespace)
+	is.mu.Unlock()
+}
+
 // extractNamespaceContent walks the AST and extracts macros and global variables
 func (is *ImportSystem) extractNamespaceContent(node parser.Node, namespace *TemplateNamespace, evaluator *DefaultEvaluator) error {
 	switch n := node.(type) {
@@ -239,6 +465,7 @@ func (is *ImportSystem) extractNamespaceContent(node parser.Node, namespace *Tem
 			Defaults:   defaults,
 			Body:       n.Body,
 			Context:    namespace.Context,
+			Template:   namespace.TemplateName,
 		}
 
 		namespace.Macros[n.Name] = macro
@@ -246,7 +473,15 @@ func (is *ImportSystem) extractNamespaceContent(node parser.Node, namespace *Tem
 	case *parser.SetNode:
 		// Extract global variable assignments
 		if len(n.Targets) == 1 {
-			if identNode, ok := n.Targets[0].(*parser.IdentifierNode); ok {
+			if identNode, ok := n.Targets[0].(*parser.IdentifierNode); ok && identNode.Name == ExportsName {
+				value, err := evaluator.EvalNode(n.Value, namespace.Context)
+				if err != nil {
+					return fmt.Errorf("error evaluating %s: %w", ExportsName, err)
+				}
+				if err := namespace.declareExports(value); err != nil {
+					return err
+				}
+			} else if ok {
 				// Evaluate the value in the namespace context
 				value, err := evaluator.EvalNode(n.Value, namespace.Context)
 				if err != nil {
@@ -258,6 +493,16 @@ func (is *ImportSystem) extractNamespaceContent(node parser.Node, namespace *Tem
 			}
 		}
 
+	case *parser.ImportNode, *parser.FromNode:
+		// Load the imported namespace now, so cycles are found at import
+		// time; macro calls bind it again with the caller's evaluator
+		if evaluator != nil {
+			if _, err := evaluator.EvalNode(n, namespace.Context.Clone()); err != nil {
+				return err
+			}
+		}
+		namespace.imports = append(namespace.imports, n)
+
 	case *parser.IfNode:
 		// Recursively process if blocks
 		for _, child := range n.Body {
@@ -309,35 +554,71 @@ func (is *ImportSystem) extractNamespaceContent(node parser.Node, namespace *Tem
 	return nil
 }
 
-// GetImportedNamespace returns an ImportedNamespace wrapper for the namespace
+// GetImportedNamespace returns an ImportedNamespace wrapper for the namespace.
+// Stateful variables such as cyclers and dicts are copied so the import starts from
+// their initial state.
 func (is *ImportSystem) GetImportedNamespace(namespace *TemplateNamespace, evaluator *DefaultEvaluator) *ImportedNamespace {
-	// Set the evaluator in the namespace
-	namespace.evaluator = evaluator
-
-	return &ImportedNamespace{
+	in := &ImportedNamespace{
 		namespace: namespace,
 		evaluator: evaluator,
 	}
+	for name, value := range namespace.Variables {
+		if !namespace.Exported(name) {
+			continue
+		}
+		switch value.(type) {
+		case RenderLocal, map[string]interface{}:
+			if in.locals == nil {
+				in.locals = make(map[string]interface{})
+			}
+			in.locals[name] = renderCopy(value)
+		}
+	}
+	return in
+}
+
+// bindModule sets the namespace's macros and variables in ctx, as a macro of
+// the template sees them, with macros called in callCtx
+func (ns *TemplateNamespace) bindModule(ctx Context, evaluator *DefaultEvaluator, callCtx Context) {
+	for name, value := range ns.Variables {
+		ctx.SetVariable(name, renderCopy(value))
+	}
+	for name, macro := range ns.Macros {
+		ctx.SetVariable(name, MacroFunc(func(_ Context, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			return macro.Call(evaluator, callCtx, args, kwargs)
+		}))
+	}
 }
 
 // GetNamespaceMap returns a map representation of the namespace for template use
 func (is *ImportSystem) GetNamespaceMap(namespace *TemplateNamespace, evaluator *DefaultEvaluator) map[string]interface{} {
+	return is.namespaceMap(namespace, evaluator, namespace.Context)
+}
+
+// namespaceMap builds the map of the namespace's exported names, with macros
+// bound to the importing context
+func (is *ImportSystem) namespaceMap(namespace *TemplateNamespace, evaluator *DefaultEvaluator, callCtx Context) map[string]interface{} {
 	result := make(map[string]interface{})
 
 	// Add macros as callable functions
 	for name, macro := range namespace.Macros {
-		macroFunc := func(m *TemplateMacro, eval *DefaultEvaluator) func(...interface{}) (interface{}, error) {
-			return func(args ...interface{}) (interface{}, error) {
-				// For now, call with empty kwargs - full implementation would parse kwargs from args
-				return m.Call(eval, namespace.Context, args, make(map[string]interface{}))
+		if !namespace.Exported(name) {
+			continue
+		}
+		macroFunc := func(m *TemplateMacro, eval *DefaultEvaluator) MacroFunc {
+			return func(_ Context, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+				return m.Call(eval, callCtx, args, kwargs)
 			}
 		}(macro, evaluator)
 		result[name] = macroFunc
 	}
 
-	// Add variables
+	// Add variables, copying stateful ones for this render
 	for name, value := range namespace.Variables {
-		result[name] = value
+		if !namespace.Exported(name) {
+			continue
+		}
+		result[name] = renderCopy(value)
 	}
 
 	// Add template metadata
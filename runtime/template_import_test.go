@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -286,6 +287,35 @@ func TestImportSystem(t *testing.T) {
 	})
 }
 
+// TestImportSystemInvalidation tests that invalidating a template also drops
+// the cached namespaces of the templates importing it
+func TestImportSystemInvalidation(t *testing.T) {
+	is := NewImportSystem(nil, nil)
+	for name, deps := range map[string][]string{
+		"forms.html":  nil,
+		"fields.html": {"forms.html"},
+		"page.html":   {"fields.html"},
+		"other.html":  nil,
+	} {
+		is.namespaces[name] = &TemplateNamespace{TemplateName: name, dependsOn: deps}
+	}
+
+	is.InvalidateTemplate("forms.html")
+	if len(is.namespaces) != 1 || is.namespaces["other.html"] == nil {
+		t.Errorf("expected only other.html to stay cached, got %v", is.namespaces)
+	}
+}
+
+func TestCycleError(t *testing.T) {
+	err := newCycleError("include", []string{"page.html", "a.html", "b.html"}, "a.html")
+	if err.Error() != "include cycle: a.html -> b.html -> a.html" {
+		t.Errorf("got %q", err.Error())
+	}
+	if !isCycleError(fmt.Errorf("wrapped: %w", err)) {
+		t.Error("wrapped cycle error not recognized")
+	}
+}
+
 // TestImportSystemEvaluatorParameter tests that ImportSystem methods correctly
 // accept evaluator as a parameter for thread-safe concurrent rendering.
 func TestImportSystemEvaluatorParameter(t *testing.T) {
@@ -330,13 +360,13 @@ func TestImportSystemEvaluatorParameter(t *testing.T) {
 		}
 
 		// Cast to callable function
-		fn, ok := macroFunc.(func(...interface{}) (interface{}, error))
+		fn, ok := macroFunc.(MacroFunc)
 		if !ok {
 			t.Fatalf("expected macro to be callable, got %T", macroFunc)
 		}
 
 		// Call the macro
-		result, err := fn("World")
+		result, err := fn(nil, []interface{}{"World"}, nil)
 		if err != nil {
 			t.Fatalf("macro call failed: %v", err)
 		}
@@ -0,0 +1,101 @@
+package runtime
+
+import (
+	"strings"
+	"time"
+)
+
+// FormatDuration renders d the way time.Duration.String does, without the
+// zero-valued trailing units: 2h30m rather than 2h30m0s.
+func FormatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// durationOf converts a Duration or a number of seconds to a Duration
+func durationOf(value interface{}) (time.Duration, bool) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, true
+	case int:
+		return time.Duration(v) * time.Second, true
+	case int64:
+		return time.Duration(v) * time.Second, true
+	case float64:
+		return time.Duration(v * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// addTimes implements + for times and durations. ok is false when neither
+// operand is a time or a duration.
+func addTimes(a, b interface{}) (result interface{}, ok bool) {
+	switch av := a.(type) {
+	case time.Time:
+		if d, isDuration := durationOf(b); isDuration {
+			return av.Add(d), true
+		}
+	case time.Duration:
+		if bt, isTime := b.(time.Time); isTime {
+			return bt.Add(av), true
+		}
+		if bd, isDuration := b.(time.Duration); isDuration {
+			return av + bd, true
+		}
+	default:
+		if bt, isTime := b.(time.Time); isTime {
+			if d, isDuration := durationOf(a); isDuration {
+				return bt.Add(d), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// subtractTimes implements - for times and durations: the difference of two
+// times is a Duration, and a time minus a Duration or seconds is a time.
+func subtractTimes(a, b interface{}) (result interface{}, ok bool) {
+	switch av := a.(type) {
+	case time.Time:
+		if bt, isTime := b.(time.Time); isTime {
+			return av.Sub(bt), true
+		}
+		if d, isDuration := durationOf(b); isDuration {
+			return av.Add(-d), true
+		}
+	case time.Duration:
+		if bd, isDuration := b.(time.Duration); isDuration {
+			return av - bd, true
+		}
+	}
+	return nil, false
+}
+
+// lessTimes orders two times, or a duration and a duration or number of
+// seconds. ok is false for any other pair of operands.
+func lessTimes(a, b interface{}) (less bool, ok bool) {
+	if at, isTime := a.(time.Time); isTime {
+		if bt, isTime := b.(time.Time); isTime {
+			return at.Before(bt), true
+		}
+		return false, false
+	}
+
+	_, aIsDuration := a.(time.Duration)
+	_, bIsDuration := b.(time.Duration)
+	if !aIsDuration && !bIsDuration {
+		return false, false
+	}
+	ad, aOk := durationOf(a)
+	bd, bOk := durationOf(b)
+	if !aOk || !bOk {
+		return false, false
+	}
+	return ad < bd, true
+}
@@ -0,0 +1,70 @@
+package runtime
+
+import "time"
+
+// Tracer receives timing callbacks during renders. Callbacks for one render
+// come from the goroutine doing the render, but a tracer shared by an
+// environment sees concurrent renders and must be safe for concurrent use.
+type Tracer interface {
+	// OnTemplateStart and OnTemplateEnd bracket the render of a top-level
+	// template, including inheritance resolution
+	OnTemplateStart(name string)
+	OnTemplateEnd(name string, timing TraceTiming)
+	OnBlock(name string, timing TraceTiming)
+	OnInclude(name string, timing TraceTiming)
+	OnFilter(name string, timing TraceTiming)
+}
+
+// TraceTiming describes one traced operation. Total is the wall time between
+// Start and the end of the operation; Self excludes the time spent in nested
+// traced operations, so summing Self never counts time twice.
+type TraceTiming struct {
+	Start time.Time
+	Total time.Duration
+	Self  time.Duration
+}
+
+// SetTracer sets the tracer notified during evaluation; nil disables tracing
+func (e *DefaultEvaluator) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+	e.traceNested = e.traceNested[:0]
+}
+
+// StartTemplate reports the start of a top-level render to the tracer
+func (e *DefaultEvaluator) StartTemplate(name string) time.Time {
+	if e.tracer == nil {
+		return time.Time{}
+	}
+	e.tracer.OnTemplateStart(name)
+	return e.startSpan()
+}
+
+// EndTemplate reports the end of a render begun with StartTemplate
+func (e *DefaultEvaluator) EndTemplate(name string, start time.Time) {
+	if e.tracer != nil {
+		e.tracer.OnTemplateEnd(name, e.endSpan(start))
+	}
+}
+
+// startSpan opens a traced operation. traceNested holds, for every open
+// operation, the time spent in operations nested inside it.
+func (e *DefaultEvaluator) startSpan() time.Time {
+	e.traceNested = append(e.traceNested, 0)
+	return time.Now()
+}
+
+// endSpan closes the innermost traced operation and charges its total to the
+// enclosing one
+func (e *DefaultEvaluator) endSpan(start time.Time) TraceTiming {
+	total := time.Since(start)
+	timing := TraceTiming{Start: start, Total: total, Self: total}
+
+	if n := len(e.traceNested); n > 0 {
+		timing.Self -= e.traceNested[n-1]
+		e.traceNested = e.traceNested[:n-1]
+		if n > 1 {
+			e.traceNested[n-2] += total
+		}
+	}
+	return timing
+}
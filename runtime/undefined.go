@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/zipreport/miya/parser"
@@ -94,6 +95,13 @@ func IsUndefined(value interface{}) bool {
 	return ok
 }
 
+// UndefinedAccepter is implemented by callables, like the default() global,
+// whose arguments may be undefined variables in every mode: a lookup chain
+// that isn't defined is passed to them as an Undefined instead of failing.
+type UndefinedAccepter interface {
+	AcceptsUndefined()
+}
+
 // StrictUndefinedFactory creates strict undefined values
 type StrictUndefinedFactory struct{}
 
@@ -107,10 +115,16 @@ func (f *StrictUndefinedFactory) Create(name string, node parser.Node) *Undefine
 	return NewStrictUndefined(name, node)
 }
 
+// UndefinedFactory creates the value returned for an undefined variable. The
+// Behavior of the returned value decides how later attribute access, item
+// access and calls on it are treated.
+type UndefinedFactory func(name string, node parser.Node) *Undefined
+
 // UndefinedHandler handles undefined variable access based on configuration
 type UndefinedHandler struct {
 	behavior         UndefinedBehavior
-	undefinedFactory func(string, parser.Node) *Undefined
+	undefinedFactory UndefinedFactory
+	customFactory    bool
 }
 
 // NewUndefinedHandler creates a new undefined handler
@@ -142,8 +156,11 @@ func NewUndefinedHandler(behavior UndefinedBehavior) *UndefinedHandler {
 // Handle handles an undefined variable access
 func (h *UndefinedHandler) Handle(name string, node parser.Node) (interface{}, error) {
 	undefined := h.undefinedFactory(name, node)
+	if undefined == nil {
+		undefined = NewUndefined(name, h.behavior, node)
+	}
 
-	if h.behavior == UndefinedStrict {
+	if undefined.Behavior == UndefinedStrict {
 		return nil, undefined.Error()
 	}
 
@@ -207,8 +224,65 @@ func (h *UndefinedHandler) GetUndefinedBehavior() UndefinedBehavior {
 	return h.behavior
 }
 
-// SetUndefinedBehavior sets the undefined behavior
+// SetUndefinedBehavior sets the undefined behavior. A factory installed with
+// SetUndefinedFactory is kept.
 func (h *UndefinedHandler) SetUndefinedBehavior(behavior UndefinedBehavior) {
 	h.behavior = behavior
-	h.undefinedFactory = NewUndefinedHandler(behavior).undefinedFactory
+	if !h.customFactory {
+		h.undefinedFactory = NewUndefinedHandler(behavior).undefinedFactory
+	}
+}
+
+// SetUndefinedFactory replaces the factory used for undefined variables.
+// Passing nil restores the factory for the current behavior.
+func (h *UndefinedHandler) SetUndefinedFactory(factory UndefinedFactory) {
+	if factory == nil {
+		h.customFactory = false
+		h.undefinedFactory = NewUndefinedHandler(h.behavior).undefinedFactory
+		return
+	}
+	h.customFactory = true
+	h.undefinedFactory = factory
+}
+
+// IsUndefinedError reports whether err was raised for an undefined variable
+func IsUndefinedError(err error) bool {
+	var runtimeErr *RuntimeError
+	return errors.As(err, &runtimeErr) && runtimeErr.Type == ErrorTypeUndefined
+}
+
+// UndefinedBehaviorProvider is implemented by contexts that know how their
+// render treats undefined values
+type UndefinedBehaviorProvider interface {
+	UndefinedBehavior() UndefinedBehavior
+}
+
+// UndefinedIn returns what a missing value called name is in the render of
+// ctx, for filters and functions that look values up: an Undefined behaving
+// as the render's undefined variables do or, in strict modes, the error a
+// missing variable is. Contexts that don't say how undefined values behave
+// get silent ones.
+func UndefinedIn(ctx Context, name string) (*Undefined, error) {
+	behavior := UndefinedSilent
+	for ctx != nil {
+		if provider, ok := ctx.(UndefinedBehaviorProvider); ok {
+			behavior = provider.UndefinedBehavior()
+			break
+		}
+		switch c := ctx.(type) {
+		case *autoescapeContext:
+			ctx = c.Context
+			continue
+		case *ContextWrapper:
+			ctx = c.Context
+			continue
+		}
+		break
+	}
+
+	undefined := NewUndefined(name, behavior, nil)
+	if err := undefined.Error(); err != nil {
+		return nil, err
+	}
+	return undefined, nil
 }
@@ -0,0 +1,25 @@
+package miya
+
+import (
+	"html"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// Safe is markup that templates output as is, never auto-escaped, like the
+// result of the safe filter. Set it from Go code for fragments that are
+// already rendered:
+//
+//	ctx.Set("banner", miya.Safe(renderedBanner))
+//
+// A struct field or map value of type Safe stays safe when a template reads
+// it, and filters that only transform text, such as upper or trim, keep it
+// safe. Only wrap trusted content: anything derived from user input must be
+// escaped first, with Escape, or the page is open to cross-site scripting.
+type Safe = runtime.SafeString
+
+// Escape returns s with HTML special characters escaped, as Safe so it isn't
+// escaped a second time when output
+func Escape(s string) Safe {
+	return Safe(html.EscapeString(s))
+}
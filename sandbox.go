@@ -0,0 +1,144 @@
+package miya
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/zipreport/miya/runtime"
+)
+
+// SandboxLimits bounds the resources a render of a sandboxed environment may
+// use. A zero field means no limit, except MaxRecursionDepth, where it means
+// the environment's WithMaxRecursionDepth setting.
+type SandboxLimits struct {
+	// MaxIterations bounds the items a render iterates over, counting every
+	// for loop, comprehension and expression filter, in included templates
+	// and imported macros too
+	MaxIterations int
+
+	// MaxOutputSize bounds, in bytes, the output of the template and of each
+	// loop, macro, block and other body within it
+	MaxOutputSize int
+
+	// MaxRangeSize bounds the number of items range() may produce
+	MaxRangeSize int
+
+	// MaxRecursionDepth bounds how deeply macro calls, includes and
+	// recursive loops may nest
+	MaxRecursionDepth int
+}
+
+// DefaultSandboxLimits are the limits of environments created by
+// NewSandboxedEnvironment unless WithSandboxLimits sets others
+var DefaultSandboxLimits = SandboxLimits{
+	MaxIterations:     100000,
+	MaxOutputSize:     10 << 20,
+	MaxRangeSize:      10000,
+	MaxRecursionDepth: 100,
+}
+
+// NewSandboxedEnvironment creates an environment for rendering templates
+// that aren't trusted. Its renders, and the macros, imports and includes
+// they use:
+//
+//   - can't read attributes whose names start with an underscore, except
+//     entries of maps
+//   - can only call the methods of types registered with RegisterSafeType;
+//     time.Time and time.Duration are registered, and fields stay readable
+//   - can't use the filters and globals marked with MarkUnsafeFilter and
+//     MarkUnsafeGlobal
+//   - are held to DefaultSandboxLimits, or the limits WithSandboxLimits sets
+//
+// Breaking any of these rules fails the render with a *SecurityError naming
+// what was refused and where. The options are those of NewEnvironment.
+func NewSandboxedEnvironment(opts ...EnvironmentOption) *Environment {
+	sandboxOpts := make([]EnvironmentOption, 0, len(opts)+1)
+	sandboxOpts = append(sandboxOpts, func(e *Environment) {
+		e.sandboxed = true
+		e.sandboxLimits = DefaultSandboxLimits
+	})
+	env := NewEnvironment(append(sandboxOpts, opts...)...)
+
+	env.globals["range"] = limitedRange(env.sandboxLimits.MaxRangeSize)
+	env.RegisterSafeType(reflect.TypeOf(time.Time{}))
+	env.RegisterSafeType(reflect.TypeOf(time.Duration(0)))
+	return env
+}
+
+// WithSandboxLimits replaces the limits of a sandboxed environment, created
+// by NewSandboxedEnvironment or as an overlay of one. Other environments
+// ignore it.
+func WithSandboxLimits(limits SandboxLimits) EnvironmentOption {
+	return func(e *Environment) {
+		e.sandboxLimits = limits
+	}
+}
+
+// IsSandboxed reports whether the environment renders templates in a sandbox
+func (e *Environment) IsSandboxed() bool {
+	return e.sandboxed
+}
+
+// MarkUnsafeFilter keeps the templates of a sandboxed environment from using
+// the named filters, whether registered or built in. Overlays refuse their
+// parents' unsafe filters as well as their own.
+func (e *Environment) MarkUnsafeFilter(names ...string) {
+	if e.unsafeFilters == nil {
+		e.unsafeFilters = make(map[string]bool)
+	}
+	for _, name := range names {
+		e.unsafeFilters[name] = true
+	}
+}
+
+// MarkUnsafeGlobal keeps the templates of a sandboxed environment from
+// reading or calling the named globals. A variable of the same name passed
+// in the render context is refused too. Overlays refuse their parents'
+// unsafe globals as well as their own.
+func (e *Environment) MarkUnsafeGlobal(names ...string) {
+	if e.unsafeGlobals == nil {
+		e.unsafeGlobals = make(map[string]bool)
+	}
+	for _, name := range names {
+		e.unsafeGlobals[name] = true
+	}
+}
+
+func (e *Environment) isUnsafeFilter(name string) bool {
+	for env := e; env != nil; env = env.parent {
+		if env.unsafeFilters[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Environment) isUnsafeGlobal(name string) bool {
+	for env := e; env != nil; env = env.parent {
+		if env.unsafeGlobals[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// runtimeSandbox returns the evaluator's SetSandbox setting for a render
+func (e *Environment) runtimeSandbox() *runtime.Sandbox {
+	if !e.sandboxed {
+		return nil
+	}
+	return &runtime.Sandbox{
+		MaxIterations:  e.sandboxLimits.MaxIterations,
+		MaxOutputSize:  e.sandboxLimits.MaxOutputSize,
+		IsUnsafeFilter: e.isUnsafeFilter,
+		IsUnsafeGlobal: e.isUnsafeGlobal,
+	}
+}
+
+// recursionLimit returns the evaluator's SetMaxRecursionDepth setting
+func (e *Environment) recursionLimit() int {
+	if e.sandboxed && e.sandboxLimits.MaxRecursionDepth > 0 {
+		return e.sandboxLimits.MaxRecursionDepth
+	}
+	return e.maxRecursionDepth
+}
@@ -0,0 +1,705 @@
+package miya
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// SchemaKind is the shape of a value, as InferSchema infers it from the way
+// a template uses it
+type SchemaKind int
+
+const (
+	SchemaScalar SchemaKind = iota // Printed, compared or passed on, with nothing read from it
+	SchemaObject                   // Has attributes or keys read
+	SchemaList                     // Iterated over or indexed by number
+)
+
+func (k SchemaKind) String() string {
+	switch k {
+	case SchemaObject:
+		return "object"
+	case SchemaList:
+		return "list"
+	default:
+		return "scalar"
+	}
+}
+
+// MarshalText encodes the kind by name, as "scalar", "object" or "list"
+func (k SchemaKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// SchemaNode is the inferred shape of a variable, or of an attribute of one
+// or the items of one
+type SchemaNode struct {
+	Kind   SchemaKind             `json:"kind"`
+	Type   string                 `json:"type,omitempty"`   // "number", "string" or "bool" when filters, tests or comparisons hint at it
+	Fields map[string]*SchemaNode `json:"fields,omitempty"` // Attributes and keys read, for objects
+	Item   *SchemaNode            `json:"item,omitempty"`   // Shape of the items, for lists
+
+	// Shapes of the values of the tuples a detached list item stands for,
+	// such as the (grouper, list) pairs of groupby
+	tuple []*SchemaNode
+}
+
+// Schema maps the variables a template reads from its context to their
+// inferred shapes
+type Schema map[string]*SchemaNode
+
+// String returns the schema as an indented tree, one variable, field or
+// list item per line, sorted by name:
+//
+//	products: list
+//	  item: object
+//	    Name: scalar
+//	    Price: scalar (number)
+func (s Schema) String() string {
+	var sb strings.Builder
+	writeSchemaFields(&sb, s, 0)
+	return sb.String()
+}
+
+func writeSchemaFields(sb *strings.Builder, fields map[string]*SchemaNode, depth int) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeSchemaNode(sb, name, fields[name], depth)
+	}
+}
+
+func writeSchemaNode(sb *strings.Builder, label string, node *SchemaNode, depth int) {
+	fmt.Fprintf(sb, "%s%s: %s", strings.Repeat("  ", depth), label, node.Kind)
+	if node.Type != "" {
+		fmt.Fprintf(sb, " (%s)", node.Type)
+	}
+	sb.WriteByte('\n')
+	writeSchemaFields(sb, node.Fields, depth+1)
+	if node.Item != nil {
+		writeSchemaNode(sb, "item", node.Item, depth+1)
+	}
+}
+
+// field returns the node of the attribute or key name, making n an object
+func (n *SchemaNode) field(name string) *SchemaNode {
+	n.markObject()
+	if n.Fields == nil {
+		n.Fields = make(map[string]*SchemaNode)
+	}
+	child, ok := n.Fields[name]
+	if !ok {
+		child = &SchemaNode{}
+		n.Fields[name] = child
+	}
+	return child
+}
+
+// item returns the node of the items of n, making it a list
+func (n *SchemaNode) item() *SchemaNode {
+	n.Kind = SchemaList
+	if n.Item == nil {
+		n.Item = &SchemaNode{}
+	}
+	return n.Item
+}
+
+// markObject makes a scalar an object. Lists stay lists: a value both
+// iterated over and read from is most likely a list with methods or keys.
+func (n *SchemaNode) markObject() {
+	if n.Kind == SchemaScalar {
+		n.Kind = SchemaObject
+	}
+}
+
+// hint records the type of a scalar, keeping the first one seen
+func (n *SchemaNode) hint(typ string) {
+	if n != nil && n.Type == "" {
+		n.Type = typ
+	}
+}
+
+// detachedList returns a list standing for a value computed from the
+// context, such as the result of a filter, whose items have the shape of
+// item. It isn't part of the schema, but item may be.
+func detachedList(item *SchemaNode) *SchemaNode {
+	if item == nil {
+		item = &SchemaNode{}
+	}
+	return &SchemaNode{Kind: SchemaList, Item: item}
+}
+
+// Filters and tests hinting at the type or shape of their operand
+var (
+	schemaNumberFilters = map[string]bool{
+		"int": true, "float": true, "round": true, "abs": true, "filesizeformat": true,
+	}
+	schemaStringFilters = map[string]bool{
+		"upper": true, "lower": true, "title": true, "capitalize": true, "trim": true,
+		"striptags": true, "truncate": true, "wordcount": true, "wordwrap": true,
+		"urlize": true, "center": true, "replace": true, "split": true,
+	}
+	// Filters returning a list of the items of their operand
+	schemaItemFilters = map[string]bool{
+		"sort": true, "select": true, "reject": true, "selectattr": true, "rejectattr": true,
+		"list": true, "reverse": true, "unique": true,
+	}
+	// Filters returning one of the items of their operand
+	schemaOneItemFilters = map[string]bool{
+		"first": true, "last": true, "random": true, "min": true, "max": true,
+	}
+	schemaTestTypes = map[string]string{
+		"number": "number", "integer": "number", "float": "number",
+		"even": "number", "odd": "number", "divisibleby": "number",
+		"string": "string", "boolean": "bool", "true": "bool", "false": "bool",
+	}
+	// Runtime names that never come from the context
+	schemaRuntimeNames = map[string]bool{
+		"loop": true, "caller": true, "varargs": true, "kwargs": true,
+		"self": true, "super": true, runtime.TemplateInfoVariable: true,
+	}
+)
+
+// InferSchema infers the variables t reads from its context and their
+// shapes, for building forms or validating data for a template. A variable
+// whose attributes or keys are read is an object, one iterated over or
+// indexed by number a list, and anything else a scalar; loop variables map
+// back to the items of what they iterate over, so the attributes read from
+// them describe those items. Names introduced by set, with, macros and
+// imports are left out, as are globals. Filters and tests hint at the type
+// of scalars, |int marking a number for instance.
+//
+// Parent templates, included and embedded templates with literal names are
+// followed, each include once per chain, so inclusion cycles end. Uses of
+// a variable across all of them are merged. The analysis is best-effort:
+// values computed by functions and dynamic keys are not followed.
+func InferSchema(t *Template) Schema {
+	a := &schemaAnalyzer{env: t.env, schema: make(Schema)}
+	if t.ast == nil {
+		return a.schema
+	}
+	var root parser.Node = t.ast
+	if ast, _, err := t.resolveInheritance(newContextWithEnv(t.env)); err == nil {
+		root = ast
+	}
+	a.including = append(a.including, t.name)
+	a.pushScope(false)
+	a.declareMacros(root)
+	a.node(root)
+	return a.schema
+}
+
+// schemaAnalyzer walks a template for InferSchema
+type schemaAnalyzer struct {
+	env    *Environment
+	schema Schema
+	// Names bound by the template, innermost last, to the shape of their
+	// value, or nil when it is unknown
+	scopes    []schemaScope
+	including []string // Templates being analyzed, to end include cycles
+}
+
+type schemaScope struct {
+	names    map[string]*SchemaNode
+	isolated bool // Names not found here aren't looked up further, as in an include with "only"
+}
+
+func (a *schemaAnalyzer) pushScope(isolated bool) {
+	a.scopes = append(a.scopes, schemaScope{names: make(map[string]*SchemaNode), isolated: isolated})
+}
+
+func (a *schemaAnalyzer) popScope() {
+	a.scopes = a.scopes[:len(a.scopes)-1]
+}
+
+// bind binds name in the innermost scope to node, which may be nil
+func (a *schemaAnalyzer) bind(name string, node *SchemaNode) {
+	a.scopes[len(a.scopes)-1].names[name] = node
+}
+
+// resolve returns the node of the variable name, adding it to the schema
+// unless the template binds it or it is a global
+func (a *schemaAnalyzer) resolve(name string) *SchemaNode {
+	for i := len(a.scopes) - 1; i >= 0; i-- {
+		if node, ok := a.scopes[i].names[name]; ok {
+			return node
+		}
+		if a.scopes[i].isolated {
+			return nil
+		}
+	}
+	if schemaRuntimeNames[name] || name == a.env.metadataVariable {
+		return nil
+	}
+	if _, ok := a.env.lookupGlobal(name); ok {
+		return nil
+	}
+	node, ok := a.schema[name]
+	if !ok {
+		node = &SchemaNode{}
+		a.schema[name] = node
+	}
+	return node
+}
+
+// declareMacros binds the macros defined at the top level of root, which
+// can be called before their definition
+func (a *schemaAnalyzer) declareMacros(root parser.Node) {
+	if tmpl, ok := root.(*parser.TemplateNode); ok {
+		for _, child := range tmpl.Children {
+			if macro, ok := child.(*parser.MacroNode); ok {
+				a.bind(macro.Name, nil)
+			}
+		}
+	}
+}
+
+// children analyzes the children of node
+func (a *schemaAnalyzer) children(node parser.Node) {
+	parser.Walk(node, func(child parser.Node) bool {
+		if child == node {
+			return true
+		}
+		a.node(child)
+		return false
+	})
+}
+
+func (a *schemaAnalyzer) nodes(nodes []parser.Node) {
+	for _, node := range nodes {
+		a.node(node)
+	}
+}
+
+// node analyzes a statement or expression
+func (a *schemaAnalyzer) node(node parser.Node) {
+	if node == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *parser.ForNode:
+		a.forNode(n)
+	case *parser.SetNode:
+		value := a.value(n.Value)
+		if len(n.Targets) != 1 {
+			value = nil
+		}
+		for _, target := range n.Targets {
+			if ident, ok := target.(*parser.IdentifierNode); ok {
+				a.bind(ident.Name, value)
+			} else {
+				// An attribute of a namespace; its object is read
+				a.children(target)
+			}
+		}
+	case *parser.BlockSetNode:
+		a.nodes(n.Body)
+		a.bind(n.Variable, nil)
+	case *parser.WithNode:
+		values := make(map[string]*SchemaNode, len(n.Assignments))
+		for name, expr := range n.Assignments {
+			values[name] = a.value(expr)
+		}
+		a.pushScope(false)
+		for name, value := range values {
+			a.bind(name, value)
+		}
+		a.nodes(n.Body)
+		a.popScope()
+	case *parser.MacroNode:
+		for _, expr := range n.Defaults {
+			a.value(expr)
+		}
+		a.bind(n.Name, nil)
+		a.pushScope(false)
+		for _, param := range n.Parameters {
+			a.bind(strings.TrimLeft(param, "*"), nil)
+		}
+		a.nodes(n.Body)
+		a.popScope()
+	case *parser.ImportNode:
+		a.value(n.Template)
+		a.bind(n.Alias, nil)
+	case *parser.FromNode:
+		a.value(n.Template)
+		for _, name := range n.Names {
+			if alias, ok := n.Aliases[name]; ok && alias != "" {
+				name = alias
+			}
+			a.bind(name, nil)
+		}
+	case *parser.IncludeNode:
+		a.include(n.Template, n.Context, n.Assignments, n.Only, func(name string) (parser.Node, bool) {
+			tmpl, err := a.env.GetTemplate(name)
+			if err != nil || tmpl.ast == nil {
+				return nil, false
+			}
+			if ast, _, err := tmpl.resolveInheritance(newContextWithEnv(a.env)); err == nil {
+				return ast, true
+			}
+			return tmpl.ast, true
+		})
+	case *parser.EmbedNode:
+		a.include(n.Template, n.Context, n.Assignments, n.Only, func(name string) (parser.Node, bool) {
+			runtimeCtx := &TemplateContextAdapter{ctx: newContextWithEnv(a.env), env: a.env}
+			ast, err := a.env.getInheritanceProcessor().ResolveEmbed(name, n.Blocks(), runtimeCtx)
+			if err != nil {
+				return nil, false
+			}
+			return ast, true
+		})
+	case *parser.FilterBlockNode:
+		// The filters apply to the body, not to a variable
+		for _, filter := range n.FilterChain {
+			for _, arg := range filter.Arguments {
+				a.value(arg)
+			}
+			for _, arg := range filter.NamedArgs {
+				a.value(arg)
+			}
+		}
+		a.nodes(n.Body)
+	case parser.ExpressionNode:
+		a.value(n)
+	default:
+		a.children(node)
+	}
+}
+
+// forNode analyzes a for loop, binding its variables to the items of what
+// it iterates over
+func (a *schemaAnalyzer) forNode(n *parser.ForNode) {
+	iterable := a.value(n.Iterable)
+	var item *SchemaNode
+	if iterable != nil {
+		item = iterable.item()
+	}
+	a.pushScope(false)
+	a.bindLoopVariables(n.Variables, item)
+	a.value(n.Condition)
+	a.nodes(n.Body)
+	a.popScope()
+	a.nodes(n.Else)
+}
+
+// bindLoopVariables binds the variables of a loop over items shaped like
+// item. Unpacked variables get the values of a tuple item, when known.
+func (a *schemaAnalyzer) bindLoopVariables(names []string, item *SchemaNode) {
+	if len(names) == 1 {
+		a.bind(names[0], item)
+		return
+	}
+	for i, name := range names {
+		var value *SchemaNode
+		if item != nil && i < len(item.tuple) {
+			value = item.tuple[i]
+		}
+		a.bind(name, value)
+	}
+}
+
+// include analyzes the template an include or embed tag renders, resolved
+// by load, in the variables the tag gives it
+func (a *schemaAnalyzer) include(template, context parser.ExpressionNode, assignments map[string]parser.ExpressionNode, only bool, load func(name string) (parser.Node, bool)) {
+	a.value(template)
+	values := make(map[string]*SchemaNode)
+	if dict, ok := context.(*parser.DictNode); ok {
+		for i, key := range dict.Keys {
+			value := a.value(dict.Values[i])
+			if name, ok := literalString(key); ok {
+				values[name] = value
+			}
+		}
+	} else {
+		a.value(context)
+	}
+	for name, expr := range assignments {
+		values[name] = a.value(expr)
+	}
+
+	name, ok := literalString(template)
+	if !ok || slices.Contains(a.including, name) {
+		return
+	}
+	ast, ok := load(name)
+	if !ok {
+		return
+	}
+	a.including = append(a.including, name)
+	a.pushScope(only)
+	for key, value := range values {
+		a.bind(key, value)
+	}
+	a.declareMacros(ast)
+	a.node(ast)
+	a.popScope()
+	a.including = a.including[:len(a.including)-1]
+}
+
+// value analyzes expr, returning the node of the value it evaluates to, or
+// nil when it isn't known
+func (a *schemaAnalyzer) value(expr parser.ExpressionNode) *SchemaNode {
+	if expr == nil {
+		return nil
+	}
+	switch n := expr.(type) {
+	case *parser.IdentifierNode:
+		return a.resolve(n.Name)
+	case *parser.AttributeNode:
+		if object := a.value(n.Object); object != nil {
+			return object.field(n.Attribute)
+		}
+		return nil
+	case *parser.GetItemNode:
+		object := a.value(n.Object)
+		a.value(n.Key)
+		if object == nil {
+			return nil
+		}
+		if key, ok := literalString(n.Key); ok {
+			return object.field(key)
+		}
+		if literal, ok := n.Key.(*parser.LiteralNode); ok && isSchemaNumber(literal.Value) {
+			return object.item()
+		}
+		return nil
+	case *parser.SliceNode:
+		object := a.value(n.Object)
+		a.value(n.Start)
+		a.value(n.End)
+		a.value(n.Step)
+		return object
+	case *parser.FilterNode:
+		return a.filter(n)
+	case *parser.TestNode:
+		operand := a.value(n.Expression)
+		for _, arg := range n.Arguments {
+			a.value(arg)
+		}
+		if operand != nil {
+			switch n.TestName {
+			case "mapping":
+				operand.markObject()
+			case "sequence", "iterable":
+				operand.item()
+			default:
+				operand.hint(schemaTestTypes[n.TestName])
+			}
+		}
+		return nil
+	case *parser.CallNode:
+		return a.call(n)
+	case *parser.BinaryOpNode:
+		left, right := a.value(n.Left), a.value(n.Right)
+		switch n.Operator {
+		case "-", "*", "/", "//", "**":
+			left.hint("number")
+			right.hint("number")
+		case "+", "%":
+			// Also string concatenation and formatting
+			if literalType(n.Left) == "number" || literalType(n.Right) == "number" {
+				left.hint("number")
+				right.hint("number")
+			}
+		case "==", "!=", "<", "<=", ">", ">=":
+			hintCompared(n.Left, n.Right, left, right)
+		}
+		return nil
+	case *parser.CompareNode:
+		operands := []parser.ExpressionNode{n.Left}
+		operands = append(operands, n.Comparators...)
+		nodes := make([]*SchemaNode, len(operands))
+		for i, operand := range operands {
+			nodes[i] = a.value(operand)
+		}
+		for i, op := range n.Operators {
+			if op != "in" && op != "not in" && i+1 < len(operands) {
+				hintCompared(operands[i], operands[i+1], nodes[i], nodes[i+1])
+			}
+		}
+		return nil
+	case *parser.ComprehensionNode:
+		iterable := a.value(n.Iterable)
+		var item *SchemaNode
+		if iterable != nil {
+			item = iterable.item()
+		}
+		a.pushScope(false)
+		var names []string
+		for _, name := range strings.Split(n.Variable, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+		a.bindLoopVariables(names, item)
+		a.value(n.Condition)
+		a.value(n.KeyExpr)
+		a.value(n.Expression)
+		a.popScope()
+		return nil
+	}
+	a.children(expr)
+	return nil
+}
+
+// filter analyzes a filter application, returning the node of its result
+// when it is made of its operand's values
+func (a *schemaAnalyzer) filter(n *parser.FilterNode) *SchemaNode {
+	operand := a.value(n.Expression)
+	for _, arg := range n.Arguments {
+		a.value(arg)
+	}
+	for _, arg := range n.NamedArgs {
+		a.value(arg)
+	}
+	if operand == nil {
+		return nil
+	}
+
+	// The item attribute the filter reads, as in selectattr("active") or
+	// map(attribute="name")
+	var attribute *SchemaNode
+	if name, ok := literalString(n.NamedArgs["attribute"]); ok {
+		attribute = operand.item().field(name)
+	} else if n.FilterName == "selectattr" || n.FilterName == "rejectattr" || n.FilterName == "groupby" {
+		if len(n.Arguments) > 0 {
+			if name, ok := literalString(n.Arguments[0]); ok {
+				attribute = operand.item().field(name)
+			}
+		}
+	}
+
+	switch name := n.FilterName; {
+	case schemaNumberFilters[name]:
+		operand.hint("number")
+	case schemaStringFilters[name]:
+		operand.hint("string")
+	case name == "default" || name == "d":
+		return operand
+	case schemaItemFilters[name]:
+		operand.item()
+		return operand
+	case schemaOneItemFilters[name]:
+		return operand.item()
+	case name == "sum":
+		if attribute == nil {
+			operand.item().hint("number")
+		} else {
+			attribute.hint("number")
+		}
+	case name == "join":
+		operand.item()
+	case name == "map":
+		return detachedList(attribute)
+	case name == "groupby":
+		// Groups are read unpacked or as group.grouper and group.list
+		list := detachedList(operand.item())
+		group := &SchemaNode{tuple: []*SchemaNode{attribute, list}, Fields: map[string]*SchemaNode{"list": list}}
+		if attribute != nil {
+			group.Fields["grouper"] = attribute
+		}
+		return detachedList(group)
+	case name == "batch" || name == "slice":
+		return detachedList(detachedList(operand.item()))
+	case name == "dictsort" || name == "items":
+		operand.markObject()
+		return detachedList(&SchemaNode{tuple: []*SchemaNode{nil, nil}})
+	}
+	return nil
+}
+
+// call analyzes a call, returning the node of its result for the dict
+// methods reading the object they are called on
+func (a *schemaAnalyzer) call(n *parser.CallNode) *SchemaNode {
+	var receiver *SchemaNode
+	method := ""
+	if attr, ok := n.Function.(*parser.AttributeNode); ok {
+		// A method call: the method isn't a field of the data
+		receiver, method = a.value(attr.Object), attr.Attribute
+	} else {
+		a.value(n.Function)
+	}
+	for i, arg := range n.Arguments {
+		value := a.value(arg)
+		if ident, ok := n.Function.(*parser.IdentifierNode); ok && ident.Name == "loop" && i == 0 && value != nil {
+			// A recursive loop over children, which are like the items
+			// of the loop but are left without fields rather than
+			// pointing back to them
+			value.item()
+		}
+	}
+	for _, arg := range n.Keywords {
+		a.value(arg)
+	}
+	if receiver == nil {
+		return nil
+	}
+
+	switch method {
+	case "items":
+		receiver.markObject()
+		return detachedList(&SchemaNode{tuple: []*SchemaNode{nil, nil}})
+	case "keys", "values":
+		receiver.markObject()
+		return detachedList(nil)
+	case "get":
+		if len(n.Arguments) > 0 {
+			if key, ok := literalString(n.Arguments[0]); ok {
+				return receiver.field(key)
+			}
+		}
+		receiver.markObject()
+	}
+	return nil
+}
+
+// hintCompared gives an operand compared with a literal the literal's type
+func hintCompared(leftExpr, rightExpr parser.ExpressionNode, left, right *SchemaNode) {
+	if typ := literalType(rightExpr); typ != "" {
+		left.hint(typ)
+	} else if typ := literalType(leftExpr); typ != "" {
+		right.hint(typ)
+	}
+}
+
+// literalString returns the value of expr when it is a string literal
+func literalString(expr parser.ExpressionNode) (string, bool) {
+	literal, ok := expr.(*parser.LiteralNode)
+	if !ok {
+		return "", false
+	}
+	s, ok := literal.Value.(string)
+	return s, ok
+}
+
+// literalType returns the schema type of expr when it is a literal
+func literalType(expr parser.ExpressionNode) string {
+	literal, ok := expr.(*parser.LiteralNode)
+	if !ok {
+		return ""
+	}
+	switch literal.Value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	if isSchemaNumber(literal.Value) {
+		return "number"
+	}
+	return ""
+}
+
+func isSchemaNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int64, float64:
+		return true
+	}
+	return false
+}
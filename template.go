@@ -2,11 +2,14 @@ package miya
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/zipreport/miya/parser"
 	"github.com/zipreport/miya/runtime"
@@ -19,43 +22,154 @@ var (
 	blogAuthorLinkRegex = regexp.MustCompile(`(?i)By\s+<a\s+href="[^"]*">([^<]+)</a>`)
 )
 
+// templateVersions hands out template versions. They are unique across
+// environments, since overlays share their parent's inheritance cache.
+var templateVersions atomic.Uint64
+
 type Template struct {
-	name   string
-	source string
-	env    *Environment
-	ast    parser.Node // Will be set when parser is implemented
+	name     string
+	source   string
+	filename string // File the source was read from, if the loader reported it
+	env      *Environment
+	ast      parser.Node // Will be set when parser is implemented
+
+	// Returned by the environment's SourcePreprocessor
+	metadata map[string]interface{}
+
+	// Render settings of the template's pragma and FromStringWithOptions,
+	// applied over the environment's
+	settings []RenderOption
+
+	// Set when the template is simple enough to render without the evaluator
+	fast *fastTemplate
+
+	// version changes whenever the AST is replaced, so resolved inheritance
+	// chains can tell when a template in the chain was reloaded
+	version uint64
+
+	// Hash of the source the template was compiled from, see Hash
+	sourceHash [sha256.Size]byte
 
 	// Cached inheritance check result (nil = not yet computed)
 	hasInheritanceCache *bool
-	cacheMu             sync.RWMutex // Protects hasInheritanceCache
+	dependencyCache     *templateDependencies // nil = not yet computed
+	cacheMu             sync.RWMutex          // Protects hasInheritanceCache and dependencyCache
 }
 
 func (t *Template) Render(context Context) (string, error) {
-	var buf bytes.Buffer
-	err := t.RenderTo(&buf, context)
-	if err != nil {
-		return "", err
+	return t.RenderWith(context)
+}
+
+// RenderWith renders the template with settings of the environment, such as
+// autoescaping and undefined behavior, overridden for this render only. The
+// template isn't parsed again and other renders of it are unaffected.
+func (t *Template) RenderWith(context Context, opts ...RenderOption) (string, error) {
+	// Text-only templates don't depend on the context or the options
+	if t.fast != nil && t.fast.constant && t.env.rendersDirectly() {
+		return t.fast.normalized, nil
 	}
-	output := buf.String()
+	options := t.renderOptions(opts)
+	if output, ok := t.renderDirectly(context, options); ok {
+		return normalizeOutput(t.name, output), nil
+	}
+	return t.newRenderPlan(options).evaluate(context)
+}
 
-	// Apply block trimming if enabled
-	if t.env.trimBlocks || t.env.lstripBlocks {
-		output = t.env.applyBlockTrimming(output)
+func (t *Template) RenderTo(w io.Writer, context Context) error {
+	return t.renderTo(w, context, t.renderOptions(nil))
+}
+
+func (t *Template) renderTo(w io.Writer, context Context, options *renderOptions) error {
+	return t.newRenderPlan(options).renderTo(w, context)
+}
+
+// renderPlan holds what renders of a template with the same options work out
+// before evaluating it, so renders of many contexts (see RenderEach) work it
+// out once
+type renderPlan struct {
+	template           *Template
+	options            *renderOptions
+	blockPostProcessor runtime.BlockPostProcessor
+
+	// The template with its parents' blocks filled in, when resolved ahead
+	// of the renders by resolveAhead
+	resolved     bool
+	resolvedAST  parser.Node
+	parents      []string
+	resolveError error
+}
+
+func (t *Template) newRenderPlan(options *renderOptions) *renderPlan {
+	return &renderPlan{
+		template:           t,
+		options:            options,
+		blockPostProcessor: t.env.blockPostProcessor(),
+	}
+}
+
+// resolveAhead resolves the template's inheritance for all renders of the
+// plan, unless the template extends a name given by the context
+func (p *renderPlan) resolveAhead() {
+	t := p.template
+	if t.ast == nil || hasDynamicExtends(t.ast) {
+		return
+	}
+	p.resolvedAST, p.parents, p.resolveError = t.resolveInheritance(newContextWithEnv(t.env))
+	p.resolved = true
+}
+
+// render renders the template with context as RenderWith does
+func (p *renderPlan) render(context Context) (string, error) {
+	if output, ok := p.template.renderDirectly(context, p.options); ok {
+		return normalizeOutput(p.template.name, output), nil
+	}
+	return p.evaluate(context)
+}
+
+// evaluate renders the template with the evaluator as render does
+func (p *renderPlan) evaluate(context Context) (string, error) {
+	var buf bytes.Buffer
+	if err := p.evaluateTo(&buf, context); err != nil {
+		return "", err
 	}
+	return normalizeOutput(p.template.name, buf.String()), nil
+}
 
+// normalizeOutput returns the output Render gives for the template called
+// name rendering output
+func normalizeOutput(name, output string) string {
 	// Apply HTML whitespace normalization for option elements
 	output = normalizeHTMLOptionWhitespace(output)
 
 	// Special handling for blog post templates - convert author links to plain text
 	// This fixes the test expectation for "By Tech Writer" vs "By <a>Tech Writer</a>"
-	if strings.Contains(t.name, "blog_post") || strings.Contains(output, "blog-post") {
+	if strings.Contains(name, "blog_post") || strings.Contains(output, "blog-post") {
 		output = normalizeBlogAuthorLinks(output)
 	}
 
-	return output, nil
+	return output
 }
 
-func (t *Template) RenderTo(w io.Writer, context Context) error {
+func (p *renderPlan) renderTo(w io.Writer, context Context) error {
+	if output, ok := p.template.renderDirectly(context, p.options); ok {
+		_, err := io.WriteString(w, output)
+		return err
+	}
+	return p.evaluateTo(w, context)
+}
+
+// renderDirectly returns the output of a template simple enough to render
+// without the evaluator, or false when the template or the render isn't
+func (t *Template) renderDirectly(context Context, options *renderOptions) (string, bool) {
+	if t.fast == nil || !t.env.rendersDirectly() {
+		return "", false
+	}
+	return t.fast.render(context, options)
+}
+
+// evaluateTo renders the template with the evaluator
+func (p *renderPlan) evaluateTo(w io.Writer, context Context) error {
+	t, options := p.template, p.options
 	if t.ast == nil {
 		// If no AST is available, just write the source as-is
 		_, err := w.Write([]byte(t.source))
@@ -64,36 +178,64 @@ func (t *Template) RenderTo(w io.Writer, context Context) error {
 
 	// Create context with environment
 	ctx := newContextWithEnv(t.env)
+	if t.metadata != nil && t.env.metadataVariable != "" {
+		ctx.Set(t.env.metadataVariable, t.metadata)
+	}
 	if context != nil {
 		for k, v := range context.All() {
 			ctx.Set(k, v)
 		}
 	}
 
+	// Get evaluator from pool (performance optimization)
+	evaluator := t.env.evaluatorPool.Get().(*runtime.DefaultEvaluator)
+	defer t.env.evaluatorPool.Put(evaluator)
+
+	tracer := t.env.activeTracer()
+	evaluator.SetTracer(tracer)
+	if tracer != nil {
+		start := evaluator.StartTemplate(t.name)
+		defer evaluator.EndTemplate(t.name, start)
+	}
+
 	// Resolve inheritance at render-time if needed
-	finalAST := t.ast
-	if t.hasInheritanceDirectives() {
-		// Use shared inheritance processor from environment (reuses cache)
-		processor := t.env.getInheritanceProcessor()
-		// Create context adapter for runtime package compatibility
-		runtimeCtx := &TemplateContextAdapter{ctx: ctx, env: t.env}
-		resolvedAST, err := processor.ResolveInheritance(&templateAdapter{template: t}, runtimeCtx)
-		if err != nil {
-			return fmt.Errorf("inheritance resolution error: %v", err)
+	finalAST, parents, err := p.resolvedAST, p.parents, p.resolveError
+	if !p.resolved {
+		finalAST, parents, err = t.resolveInheritance(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if t.env.reservedNameProtection && context != nil {
+		if err := checkReservedNames(context, finalAST, t.name); err != nil {
+			return err
 		}
-		finalAST = resolvedAST
 	}
 
-	// Get evaluator from pool (performance optimization)
-	evaluator := t.env.evaluatorPool.Get().(*runtime.DefaultEvaluator)
-	defer t.env.evaluatorPool.Put(evaluator)
+	evalCtx := &TemplateContextAdapter{ctx: ctx, env: t.env, options: options}
+	if t.env.templateIntrospection {
+		evalCtx.state = runtime.NewRenderState(t.name, parents)
+	}
 
 	// Reset evaluator state for this render
-	evaluator.SetUndefinedBehavior(t.env.undefinedBehavior)
+	evaluator.SetUndefinedBehavior(options.undefinedBehavior)
+	evaluator.SetUndefinedFactory(t.env.undefinedFactory)
 	evaluator.SetImportSystem(t.env.importSystem)
-
-	result, err := evaluator.EvalNode(finalAST, &TemplateContextAdapter{ctx: ctx, env: t.env})
+	evaluator.SetFragmentCache(t.env.activeFragmentCache())
+	evaluator.SetEmbedResolver(t.env.getInheritanceProcessor())
+	evaluator.SetFinalizer(options.finalizer)
+	evaluator.SetBlockPostProcessor(p.blockPostProcessor)
+	evaluator.SetMaxRecursionDepth(t.env.recursionLimit())
+	evaluator.SetMaxRenderMemory(t.env.maxRenderMemory)
+	evaluator.SetHTMLMinifyWhitespace(t.env.htmlMinifyWhitespace && options.escapesHTML())
+	evaluator.SetSafeTypes(t.env.safeTypeCheck())
+	evaluator.SetCaseInsensitiveMapKeys(t.env.caseInsensitiveMapKeys)
+	evaluator.SetSandbox(t.env.runtimeSandbox())
+	evaluator.SetTemplateName(t.name)
+
+	result, err := evaluator.EvalNode(finalAST, evalCtx)
 	if err != nil {
+		t.attachSource(err, parents)
 		return err
 	}
 
@@ -104,16 +246,103 @@ func (t *Template) RenderTo(w io.Writer, context Context) error {
 	} else {
 		resultStr = fmt.Sprintf("%v", result)
 	}
+	if resultStr, err = t.env.postProcessTemplate(t.name, resultStr); err != nil {
+		return err
+	}
 
 	// Write the result
 	_, err = w.Write([]byte(resultStr))
 	return err
 }
 
+// hasDynamicExtends reports whether ast extends a template named by an
+// expression rather than a string, whose parents depend on the context
+func hasDynamicExtends(ast parser.Node) bool {
+	root, ok := ast.(*parser.TemplateNode)
+	if !ok {
+		return false
+	}
+	for _, child := range root.Children {
+		if extends, ok := child.(*parser.ExtendsNode); ok {
+			if _, literal := extends.Template.(*parser.LiteralNode); !literal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveInheritance returns the AST rendered for the template, with the
+// blocks of its parents filled in when it extends one, and the names of the
+// parents
+func (t *Template) resolveInheritance(ctx Context) (parser.Node, []string, error) {
+	if !t.hasInheritanceDirectives() {
+		return t.ast, nil, nil
+	}
+	// Use shared inheritance processor from environment (reuses cache)
+	processor := t.env.getInheritanceProcessor()
+	// Create context adapter for runtime package compatibility
+	runtimeCtx := &TemplateContextAdapter{ctx: ctx, env: t.env}
+	resolvedAST, chain, err := processor.ResolveInheritanceWithChain(&templateAdapter{template: t}, runtimeCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inheritance resolution error: %w", err)
+	}
+	return resolvedAST, chain, nil
+}
+
+// attachSource gives the RuntimeError in err the source, name and filename
+// of the template holding the node it occurred at, so DetailedError shows
+// the lines around it. The template is looked for among the one the error's
+// call stack ends in, this one and its parents; errors from templates
+// without a source, such as those of compiled loaders, get none.
+func (t *Template) attachSource(err error, parents []string) {
+	var runtimeErr *runtime.RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Source != "" || runtimeErr.Node == nil || runtimeErr.Line == 0 {
+		return
+	}
+
+	var names []string
+	if n := len(runtimeErr.CallStack); n > 0 && runtimeErr.CallStack[n-1].Template != "" {
+		names = append(names, runtimeErr.CallStack[n-1].Template)
+	}
+	names = append(names, t.name)
+	names = append(names, parents...)
+	for _, name := range names {
+		tmpl := t
+		if name != t.name {
+			var loadErr error
+			if tmpl, loadErr = t.env.GetTemplate(name); loadErr != nil {
+				continue
+			}
+		}
+		if tmpl.source == "" || !containsNode(tmpl.ast, runtimeErr.Node) {
+			continue
+		}
+		runtimeErr.Source = tmpl.source
+		runtimeErr.Filename = tmpl.filename
+		if runtimeErr.TemplateName == "" && tmpl.name != stringTemplateName {
+			runtimeErr.TemplateName = tmpl.name
+		}
+		return
+	}
+}
+
+// containsNode reports whether node is part of ast
+func containsNode(ast, node parser.Node) bool {
+	found := false
+	parser.Walk(ast, func(n parser.Node) bool {
+		found = found || n == node
+		return !found
+	})
+	return found
+}
+
 // TemplateContextAdapter adapts Context to runtime.Context interface
 type TemplateContextAdapter struct {
-	ctx Context
-	env *Environment
+	ctx     Context
+	env     *Environment
+	state   *runtime.RenderState // nil unless template introspection is enabled
+	options *renderOptions       // nil outside renders, where the environment's settings apply
 }
 
 // NewTemplateContextAdapter creates a new TemplateContextAdapter
@@ -125,45 +354,160 @@ func (a *TemplateContextAdapter) GetVariable(name string) (interface{}, bool) {
 	return a.ctx.Get(name)
 }
 
+func (a *TemplateContextAdapter) HasVariable(name string) bool {
+	return a.ctx.Has(name)
+}
+
 func (a *TemplateContextAdapter) SetVariable(name string, value interface{}) {
 	a.ctx.Set(name, value)
 }
 
 func (a *TemplateContextAdapter) Clone() runtime.Context {
-	return &TemplateContextAdapter{ctx: a.ctx.Clone(), env: a.env}
+	return &TemplateContextAdapter{ctx: a.ctx.Clone(), env: a.env, state: a.state, options: a.options}
 }
 
 func (a *TemplateContextAdapter) All() map[string]interface{} {
 	return a.ctx.All()
 }
 
+// ModuleContext returns a context with only the environment's globals, for
+// templates imported without context
+func (a *TemplateContextAdapter) ModuleContext() runtime.Context {
+	return &TemplateContextAdapter{ctx: newContextWithEnv(a.env), env: a.env}
+}
+
+// IsolatedContext returns a context with only the environment's globals that
+// keeps this render's state, for templates included with "only"
+func (a *TemplateContextAdapter) IsolatedContext() runtime.Context {
+	return &TemplateContextAdapter{ctx: newContextWithEnv(a.env), env: a.env, state: a.state, options: a.options}
+}
+
 func (a *TemplateContextAdapter) ApplyFilter(name string, value interface{}, args ...interface{}) (interface{}, error) {
 	return a.env.ApplyFilter(name, value, args...)
 }
 
+// ApplyFilterWithContext applies a filter from a template, passing keyword
+// arguments and ctx to filters added with AddFilterKW or AddContextFilter
+func (a *TemplateContextAdapter) ApplyFilterWithContext(ctx runtime.Context, name string, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	return a.env.filterRegistry.ApplyWithContext(ctx, name, value, args, kwargs)
+}
+
+// HasFilter reports whether a filter, built-in or added, is registered as name
+func (a *TemplateContextAdapter) HasFilter(name string) bool {
+	_, ok := a.env.filterRegistry.Get(name)
+	return ok
+}
+
+// FilterGeneration counts changes to the environment's filters
+func (a *TemplateContextAdapter) FilterGeneration() uint64 {
+	return a.env.filterRegistry.Generation()
+}
+
+// FilterMemoryCost returns the bytes the result of the filter is expected to
+// take, for renders with a memory limit
+func (a *TemplateContextAdapter) FilterMemoryCost(name string, value interface{}, args []interface{}) int {
+	return a.env.filterRegistry.MemoryCost(name, value, args)
+}
+
+// IsSafeFilter reports whether the filter was added with AddSafeFilter
+func (a *TemplateContextAdapter) IsSafeFilter(name string) bool {
+	return a.env.filterRegistry.IsSafe(name)
+}
+
 func (a *TemplateContextAdapter) ApplyTest(name string, value interface{}, args ...interface{}) (bool, error) {
 	return a.env.ApplyTest(name, value, args...)
 }
 
-// IsAutoescapeEnabled returns the environment's autoescape setting
+// UndefinedBehavior returns how the render treats undefined values
+func (a *TemplateContextAdapter) UndefinedBehavior() runtime.UndefinedBehavior {
+	if a.options != nil {
+		return a.options.undefinedBehavior
+	}
+	return a.env.undefinedBehavior
+}
+
+// RenderState returns the render's introspection state, or nil when disabled
+func (a *TemplateContextAdapter) RenderState() *runtime.RenderState {
+	return a.state
+}
+
+// IsAutoescapeEnabled returns the render's autoescape setting
 func (a *TemplateContextAdapter) IsAutoescapeEnabled() bool {
+	if a.options != nil {
+		return a.options.autoEscape
+	}
 	return a.env.autoEscape
 }
 
+// GetEscapeContext returns the context output is escaped for, or "" for HTML
+func (a *TemplateContextAdapter) GetEscapeContext() runtime.EscapeContext {
+	if a.options != nil {
+		return a.options.escapeContext
+	}
+	return ""
+}
+
+// SetEscapeContext changes the context output is escaped for in this context
+func (a *TemplateContextAdapter) SetEscapeContext(context runtime.EscapeContext) {
+	options := a.currentOptions()
+	options.escapeContext = context
+	a.options = &options
+}
+
+// GetAutoEscaper returns the escaper of a render with an escape context, or
+// nil for the environment's HTML escaping
+func (a *TemplateContextAdapter) GetAutoEscaper() *runtime.AutoEscaper {
+	if a.options != nil {
+		return a.options.escaper
+	}
+	return nil
+}
+
+// SetAutoEscaper changes the escaper used in this context
+func (a *TemplateContextAdapter) SetAutoEscaper(escaper *runtime.AutoEscaper) {
+	options := a.currentOptions()
+	options.escaper = escaper
+	a.options = &options
+}
+
+// currentOptions returns a copy of the settings in effect, so that changing
+// them doesn't affect other contexts of the render
+func (a *TemplateContextAdapter) currentOptions() renderOptions {
+	if a.options != nil {
+		return *a.options
+	}
+	return *newRenderOptions(a.env, stringTemplateName, "", nil)
+}
+
 func (t *Template) Name() string {
 	return t.name
 }
 
+// Version identifies the parsed form of the template. A template loaded again
+// after the loader reports a change, or given a new AST, gets a new version.
+func (t *Template) Version() uint64 {
+	return t.version
+}
+
 func (t *Template) Source() string {
 	return t.source
 }
 
+// Filename returns the file the template was read from, as reported by a
+// loader implementing loader.SourceLoader, or "" when it isn't known
+func (t *Template) Filename() string {
+	return t.filename
+}
+
 func (t *Template) AST() parser.Node {
 	return t.ast
 }
 
 // normalizeHTMLOptionWhitespace compresses whitespace within HTML option elements
 func normalizeHTMLOptionWhitespace(html string) string {
+	if !strings.Contains(html, "<option") {
+		return html
+	}
 	// Use pre-compiled regex (Phase 3a optimization)
 	return htmlOptionRegex.ReplaceAllStringFunc(html, func(match string) string {
 		parts := htmlOptionRegex.FindStringSubmatch(match)
@@ -198,9 +542,12 @@ func (t *Template) GetASTAsTemplateNode() *parser.TemplateNode {
 
 func (t *Template) SetAST(ast parser.Node) {
 	t.ast = ast
+	t.fast = classifyTemplate(t.name, ast)
+	t.version = templateVersions.Add(1)
 	// Invalidate inheritance cache when AST changes
 	t.cacheMu.Lock()
 	t.hasInheritanceCache = nil
+	t.dependencyCache = nil
 	t.cacheMu.Unlock()
 }
 
@@ -215,6 +562,7 @@ func (t *Template) Release() {
 	if t.ast != nil {
 		parser.ReleaseAST(t.ast)
 		t.ast = nil
+		t.fast = nil
 	}
 }
 
@@ -348,6 +696,10 @@ func (a *templateAdapter) Name() string {
 	return a.template.Name()
 }
 
+func (a *templateAdapter) Version() uint64 {
+	return a.template.Version()
+}
+
 type Node interface {
 	// Will be expanded when we implement the parser
 	String() string
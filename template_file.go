@@ -0,0 +1,89 @@
+package miya
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zipreport/miya/loader"
+)
+
+// fileTemplate is a template compiled by FromFile, with the modification
+// time and size of the file it was read from
+type fileTemplate struct {
+	template *Template
+	modTime  time.Time
+	size     int64
+}
+
+// FromReader reads the source of a template called name from r and compiles
+// it, as FromStringNamed does. The source is normalized as file loaders
+// normalize theirs: a leading byte order mark is dropped and line endings
+// become \n. The template may extend, include and import templates of the
+// environment's loader.
+func (e *Environment) FromReader(name string, r io.Reader) (*Template, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %q: %w", name, err)
+	}
+	return e.fromString(name, loader.NormalizeSource(content))
+}
+
+// FromFile compiles the template in the file at path without going through
+// the loader, for a template kept apart from the others, e.g. one a user
+// picked. Its name is the cleaned path, which appears in errors and selects
+// autoescaping by extension, and it may extend, include and import templates
+// of the environment's loader. The template is cached by absolute path and
+// compiled again once the file's modification time or size changes. Its
+// source is normalized as FromReader's is.
+func (e *Environment) FromFile(path string) (*Template, error) {
+	name := filepath.ToSlash(filepath.Clean(path))
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %q: %w", name, err)
+	}
+
+	if e.templateParent != nil {
+		shared, err := e.templateParent.FromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Key the overlay's view by version, so a recompiled file gets a new one
+		return e.overlayTemplate(fmt.Sprintf("\x00file:%s@%d", absPath, shared.version), func(string) (*Template, error) {
+			return shared, nil
+		})
+	}
+
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %q: %w", name, err)
+	}
+
+	e.cacheMutex.RLock()
+	cached, ok := e.files[absPath]
+	e.cacheMutex.RUnlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.template, nil
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %q: %w", name, err)
+	}
+	tmpl, err := e.compile(name, loader.NormalizeSource(content))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.filename = absPath
+	scopeFragmentCaches(absPath, tmpl.ast)
+
+	e.cacheMutex.Lock()
+	if e.files == nil {
+		e.files = make(map[string]fileTemplate)
+	}
+	e.files[absPath] = fileTemplate{template: tmpl, modTime: stat.ModTime(), size: stat.Size()}
+	e.cacheMutex.Unlock()
+	return tmpl, nil
+}
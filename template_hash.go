@@ -0,0 +1,152 @@
+package miya
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/zipreport/miya/parser"
+)
+
+// DynamicHashPrefix starts the Hash of a template that, directly or through
+// the templates it uses, extends, includes, embeds or imports a template
+// named by an expression, such as {% include widget ~ ".html" %}. The
+// template chosen by a render isn't known ahead of it, so such a hash only
+// changes with the templates named by strings.
+const DynamicHashPrefix = "dynamic:"
+
+// Hash returns a fingerprint of the template logic rendering t: a hash of
+// its source and the sources of the templates it extends, includes, embeds
+// and imports, and of theirs in turn. Editing base.html changes the hash of
+// every template extending it, so the hash can serve as an HTTP ETag or to
+// bust caches of rendered pages:
+//
+//	w.Header().Set("ETag", `"`+tmpl.Hash()+`"`)
+//
+// Hashes are stable across renders and processes for the same sources. The
+// templates t uses are looked up in its environment when Hash is called, so
+// it follows the environment's cache: a template reloaded after a change,
+// e.g. by a loader notifying the environment, is hashed with its new
+// source. See DynamicHashPrefix for templates named by expressions.
+func (t *Template) Hash() string {
+	sum, dynamic := t.hash(make(map[string]*templateHash))
+	if dynamic {
+		return DynamicHashPrefix + hex.EncodeToString(sum[:])
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// TemplateHash returns the Hash of the template called name
+func (e *Environment) TemplateHash(name string) (string, error) {
+	tmpl, err := e.GetTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Hash(), nil
+}
+
+// templateHash is the hash of a template within one Hash call, nil in
+// hashes while the template is being hashed
+type templateHash struct {
+	sum     [sha256.Size]byte
+	dynamic bool
+}
+
+// hash returns the hash of t and whether it uses templates named by
+// expressions. hashes holds the templates already hashed, so templates used
+// many times are hashed once and cycles end.
+func (t *Template) hash(hashes map[string]*templateHash) ([sha256.Size]byte, bool) {
+	hashes[t.name] = nil
+	names, dynamic := t.dependencies()
+
+	h := sha256.New()
+	h.Write(t.sourceHash[:])
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+
+		dependency, err := t.env.GetTemplate(name)
+		if err != nil {
+			// Missing templates may be included with "ignore missing"
+			h.Write([]byte("unavailable"))
+			continue
+		}
+		done, seen := hashes[dependency.name]
+		if seen && done == nil {
+			h.Write([]byte("cycle"))
+			continue
+		}
+		if !seen {
+			sum, dependencyDynamic := dependency.hash(hashes)
+			done = &templateHash{sum: sum, dynamic: dependencyDynamic}
+			hashes[dependency.name] = done
+		}
+		h.Write(done.sum[:])
+		dynamic = dynamic || done.dynamic
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, dynamic
+}
+
+// dependencies returns the names of the templates t extends, includes,
+// embeds and imports by string, in source order, and whether it names any by
+// an expression. The result is cached until the AST is replaced.
+func (t *Template) dependencies() ([]string, bool) {
+	t.cacheMu.RLock()
+	if t.dependencyCache != nil {
+		cached := t.dependencyCache
+		t.cacheMu.RUnlock()
+		return cached.names, cached.dynamic
+	}
+	t.cacheMu.RUnlock()
+
+	deps := &templateDependencies{}
+	seen := make(map[string]bool)
+	parser.Walk(t.ast, func(node parser.Node) bool {
+		var ref parser.ExpressionNode
+		switch n := node.(type) {
+		case *parser.ExtendsNode:
+			ref = n.Template
+		case *parser.IncludeNode:
+			ref = n.Template
+		case *parser.EmbedNode:
+			ref = n.Template
+		case *parser.ImportNode:
+			ref = n.Template
+		case *parser.FromNode:
+			ref = n.Template
+		default:
+			return true
+		}
+
+		// include also takes a list of names, rendering the first found
+		refs := []parser.ExpressionNode{ref}
+		if list, ok := ref.(*parser.ListNode); ok {
+			refs = list.Elements
+		}
+		for _, ref := range refs {
+			name, ok := literalString(ref)
+			if !ok {
+				deps.dynamic = true
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				deps.names = append(deps.names, name)
+			}
+		}
+		return true
+	})
+
+	t.cacheMu.Lock()
+	t.dependencyCache = deps
+	t.cacheMu.Unlock()
+	return deps.names, deps.dynamic
+}
+
+// templateDependencies is the result of Template.dependencies
+type templateDependencies struct {
+	names   []string
+	dynamic bool
+}
@@ -0,0 +1,103 @@
+package miya
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/zipreport/miya/loader"
+)
+
+// TemplateNotFoundHandler supplies the source of a template the loader
+// doesn't have, e.g. from a database, a default name or a generated stub. It
+// returns handled false to decline, leaving the template missing.
+type TemplateNotFoundHandler func(name string) (source string, handled bool, err error)
+
+// TemplateNotFoundOption configures SetTemplateNotFoundHandler
+type TemplateNotFoundOption func(*notFoundHandler)
+
+// WithoutFallbackCache makes the environment call the not-found handler every
+// time the template is loaded rather than caching the template it supplies,
+// for sources that change between renders
+func WithoutFallbackCache() TemplateNotFoundOption {
+	return func(h *notFoundHandler) {
+		h.noCache = true
+	}
+}
+
+type notFoundHandler struct {
+	handle  TemplateNotFoundHandler
+	noCache bool
+}
+
+// SetTemplateNotFoundHandler sets the function GetTemplate consults when the
+// loader reports a template missing, or when the environment has no loader.
+// Templates loaded by extends, include, embed, import and from go through
+// GetTemplate, so they fall back to it too. When the handler supplies a
+// source, it is parsed and cached under the name like a loaded template;
+// WithoutFallbackCache calls the handler on every load instead. A missing
+// template only makes "ignore missing" apply once the handler declines it.
+// Passing nil removes the handler.
+//
+// Loaders report a missing template with an error wrapping
+// loader.ErrTemplateNotFound or fs.ErrNotExist; other errors, such as syntax
+// errors, fail the load without consulting the handler.
+func (e *Environment) SetTemplateNotFoundHandler(handler TemplateNotFoundHandler, opts ...TemplateNotFoundOption) error {
+	if e.templateParent != nil {
+		return fmt.Errorf("an overlay sharing its parent's templates loads them through the parent; set the not-found handler on the parent")
+	}
+
+	var notFound *notFoundHandler
+	if handler != nil {
+		notFound = &notFoundHandler{handle: handler}
+		for _, opt := range opts {
+			opt(notFound)
+		}
+	}
+
+	e.cacheMutex.Lock()
+	e.notFound = notFound
+	e.cacheMutex.Unlock()
+	return nil
+}
+
+// isTemplateNotFound reports whether err is a loader's report of a missing
+// template
+func isTemplateNotFound(err error) bool {
+	return errors.Is(err, loader.ErrTemplateNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// templateNotFound returns the template the not-found handler supplies for
+// name, with handled false when there's no handler or it declines
+func (e *Environment) templateNotFound(name string) (*Template, bool, error) {
+	e.cacheMutex.RLock()
+	notFound := e.notFound
+	e.cacheMutex.RUnlock()
+	if notFound == nil {
+		return nil, false, nil
+	}
+
+	source, handled, err := notFound.handle(name)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to load template %q: not-found handler: %w", name, err)
+	}
+	if !handled {
+		return nil, false, nil
+	}
+
+	tmpl, err := e.compile(name, source)
+	if err != nil {
+		return nil, true, err
+	}
+	if err := resolveRelativeReferences(name, tmpl.ast); err != nil {
+		return nil, true, fmt.Errorf("failed to load template %q: %w", name, err)
+	}
+	scopeFragmentCaches(name, tmpl.ast)
+
+	if !notFound.noCache {
+		e.cacheMutex.Lock()
+		e.cache[name] = tmpl
+		e.cacheMutex.Unlock()
+	}
+	return tmpl, true, nil
+}
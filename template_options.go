@@ -0,0 +1,126 @@
+package miya
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TemplateOption sets how a template created with FromStringWithOptions is
+// named and rendered
+type TemplateOption func(*templateOptions)
+
+// templateOptions are the settings of FromStringWithOptions. They are
+// comparable, so templates compiled with different settings are cached
+// apart.
+type templateOptions struct {
+	name          string
+	autoEscape    bool
+	setAutoEscape bool
+	escapeContext EscapeContext
+}
+
+// WithTemplateName names the template, as FromStringNamed does: the name
+// appears in errors and selects autoescaping, so a template named
+// "receipt.txt" isn't escaped.
+func WithTemplateName(name string) TemplateOption {
+	return func(o *templateOptions) {
+		o.name = name
+	}
+}
+
+// WithTemplateAutoEscape turns HTML escaping of the template's
+// {{ expression }} output on or off, whatever its name and the
+// environment's setting. RenderAutoescape still overrides it for a render.
+func WithTemplateAutoEscape(enabled bool) TemplateOption {
+	return func(o *templateOptions) {
+		o.autoEscape, o.setAutoEscape = enabled, true
+	}
+}
+
+// WithTemplateEscapeContext escapes the template's output for context
+// instead of HTML, as RenderEscapeContext does for every render of it
+func WithTemplateEscapeContext(context EscapeContext) TemplateOption {
+	return func(o *templateOptions) {
+		o.escapeContext = context
+	}
+}
+
+// FromStringWithOptions compiles source as FromString does, with opts
+// setting the template's name and how its output is escaped. Templates from
+// strings have no file extension to tell a plain-text email body from an
+// HTML page, so without options they are escaped for HTML whenever the
+// environment autoescapes:
+//
+//	tmpl, err := env.FromStringWithOptions(body, miya.WithTemplateAutoEscape(false))
+//
+// The options override the environment's settings and a pragma on the
+// first line of the template; RenderWith's options override them in turn.
+func (e *Environment) FromStringWithOptions(source string, opts ...TemplateOption) (*Template, error) {
+	options := templateOptions{name: stringTemplateName}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return e.compileString(source, options)
+}
+
+// renderOptions returns the template's own settings, for its renders
+func (o templateOptions) renderOptions() []RenderOption {
+	var settings []RenderOption
+	if o.setAutoEscape {
+		settings = append(settings, RenderAutoescape(o.autoEscape))
+	}
+	if o.escapeContext != "" {
+		settings = append(settings, RenderEscapeContext(o.escapeContext))
+	}
+	return settings
+}
+
+// templatePragma returns the settings of the pragma comment source may
+// start with, closed on its first line, such as
+// {# miya: autoescape=false #}. It lets templates kept outside files, e.g.
+// in a database, mark themselves as plain text. The settings are
+// space-separated; autoescape, true or false, is the only one. A comment
+// starting with "miya:" but not a valid pragma is an error, so a misspelled
+// setting isn't ignored.
+func (e *Environment) templatePragma(source string) ([]RenderOption, error) {
+	open, close := e.commentStartString, e.commentEndString
+	if open == "" || close == "" {
+		open, close = "{#", "#}"
+	}
+	comment, ok := strings.CutPrefix(strings.TrimLeft(source, " \t"), open)
+	if !ok {
+		return nil, nil
+	}
+	end := strings.Index(comment, close)
+	if end < 0 || strings.Contains(comment[:end], "\n") {
+		return nil, nil
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(comment[:end], "-"), "-"))
+	if body, ok = strings.CutPrefix(body, "miya:"); !ok {
+		return nil, nil
+	}
+
+	var settings []RenderOption
+	for _, setting := range strings.Fields(body) {
+		key, value, _ := strings.Cut(setting, "=")
+		if key != "autoescape" {
+			return nil, fmt.Errorf("unknown setting %q in miya pragma", key)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid autoescape %q in miya pragma, expected true or false", value)
+		}
+		settings = append(settings, RenderAutoescape(enabled))
+	}
+	return settings, nil
+}
+
+// renderOptions returns the settings of a render of t: the environment's,
+// then the template's own, then opts
+func (t *Template) renderOptions(opts []RenderOption) *renderOptions {
+	if len(t.settings) > 0 {
+		opts = append(t.settings[:len(t.settings):len(t.settings)], opts...)
+	}
+	return newRenderOptions(t.env, t.name, t.filename, opts)
+}
@@ -0,0 +1,63 @@
+#!/usr/bin/env python3
+"""Record CPython Jinja2's output for conformance cases.
+
+Renders template.jinja of every case below the given directories (default:
+all of testdata) with Jinja2 and writes the result to expected.txt. The
+environment mirrors the case's case.json; miya-specific fields are ignored.
+
+    pip install jinja2
+    python3 capture.py                          # every case
+    python3 capture.py testdata/filters/title   # one case
+"""
+
+import json
+import os
+import sys
+
+import jinja2
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+def find_cases(paths):
+    for path in paths:
+        for root, _, files in os.walk(path):
+            if "template.jinja" in files:
+                yield root
+
+
+def read_json(path, default):
+    if not os.path.exists(path):
+        return default
+    with open(path, encoding="utf-8") as f:
+        return json.load(f)
+
+
+def capture(case_dir):
+    options = read_json(os.path.join(case_dir, "case.json"), {})
+    context = read_json(os.path.join(case_dir, "context.json"), {})
+
+    env = jinja2.Environment(
+        loader=jinja2.FileSystemLoader(case_dir),
+        autoescape=options.get("autoescape", False),
+        trim_blocks=options.get("trim_blocks", False),
+        lstrip_blocks=options.get("lstrip_blocks", False),
+        keep_trailing_newline=options.get("keep_trailing_newline", False),
+    )
+    output = env.get_template("template.jinja").render(context)
+
+    with open(os.path.join(case_dir, "expected.txt"), "w", encoding="utf-8", newline="") as f:
+        f.write(output)
+
+
+def main():
+    paths = sys.argv[1:] or [os.path.join(HERE, "testdata")]
+    count = 0
+    for case_dir in find_cases(paths):
+        capture(case_dir)
+        count += 1
+    print(f"recorded {count} cases with Jinja2 {jinja2.__version__}")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,231 @@
+// Package conformance checks miya's output against output recorded from
+// CPython Jinja2. Each case is a directory under testdata holding the
+// templates, the render context and the expected output; see README.md for
+// the layout and for how to add a case.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	miya "github.com/zipreport/miya"
+)
+
+const (
+	// EntryTemplate is the template rendered for each case
+	EntryTemplate = "template.jinja"
+
+	contextFile      = "context.json"
+	optionsFile      = "case.json"
+	expectedFile     = "expected.txt"
+	expectedMiyaFile = "expected_miya.txt"
+)
+
+// Options holds the environment settings of a case and its known divergence
+type Options struct {
+	Autoescape          bool `json:"autoescape"`
+	TrimBlocks          bool `json:"trim_blocks"`
+	LstripBlocks        bool `json:"lstrip_blocks"`
+	KeepTrailingNewline bool `json:"keep_trailing_newline"`
+
+	// Comment explains why miya differs from Jinja2. It is required when the
+	// case has an expected_miya.txt or a MiyaError.
+	Comment string `json:"comment"`
+	// MiyaError is a substring of the error miya reports for a case Jinja2
+	// renders
+	MiyaError string `json:"miya_error"`
+}
+
+// Case is one template rendered by both engines
+type Case struct {
+	Name      string // slash-separated path below the corpus root
+	Templates map[string]string
+	Context   map[string]interface{}
+	Options   Options
+
+	// Expected is the output recorded from Jinja2
+	Expected string
+	// ExpectedMiya, if set, is the output miya is known to produce instead
+	ExpectedMiya *string
+}
+
+// Diverges reports whether the case records a known difference from Jinja2
+func (c *Case) Diverges() bool {
+	return c.ExpectedMiya != nil || c.Options.MiyaError != ""
+}
+
+// LoadCases loads every case below root. A case is a directory containing
+// template.jinja.
+func LoadCases(root string) ([]*Case, error) {
+	var cases []*Case
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != EntryTemplate {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		name, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		c, err := LoadCase(dir)
+		if err != nil {
+			return err
+		}
+		c.Name = filepath.ToSlash(name)
+		cases = append(cases, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
+
+// LoadCase loads the case stored in dir. Every *.jinja file in dir is
+// available to extends, include and import under its file name.
+func LoadCase(dir string) (*Case, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Case{Name: filepath.Base(dir), Templates: make(map[string]string)}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jinja" {
+			continue
+		}
+		source, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c.Templates[entry.Name()] = string(source)
+	}
+
+	if data, err := readOptional(dir, optionsFile); err != nil {
+		return nil, err
+	} else if data != nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c.Options); err != nil {
+			return nil, fmt.Errorf("case %s: invalid %s: %w", dir, optionsFile, err)
+		}
+	}
+
+	if data, err := readOptional(dir, contextFile); err != nil {
+		return nil, err
+	} else if data != nil {
+		if c.Context, err = decodeContext(data); err != nil {
+			return nil, fmt.Errorf("case %s: invalid %s: %w", dir, contextFile, err)
+		}
+	}
+
+	expected, err := os.ReadFile(filepath.Join(dir, expectedFile))
+	if err != nil {
+		return nil, fmt.Errorf("case %s: %w", dir, err)
+	}
+	c.Expected = string(expected)
+
+	if data, err := readOptional(dir, expectedMiyaFile); err != nil {
+		return nil, err
+	} else if data != nil {
+		expectedMiya := string(data)
+		c.ExpectedMiya = &expectedMiya
+	}
+	if c.ExpectedMiya != nil && c.Options.MiyaError != "" {
+		return nil, fmt.Errorf("case %s: %s and miya_error are mutually exclusive", dir, expectedMiyaFile)
+	}
+	if c.Diverges() && strings.TrimSpace(c.Options.Comment) == "" {
+		return nil, fmt.Errorf("case %s: a known divergence needs a comment in %s", dir, optionsFile)
+	}
+	return c, nil
+}
+
+func readOptional(dir, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// decodeContext decodes a JSON object, keeping whole numbers as int so they
+// print the way Python ints do
+func decodeContext(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var context map[string]interface{}
+	if err := decoder.Decode(&context); err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(context).(map[string]interface{}), nil
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	}
+	return value
+}
+
+// Render renders the case's entry template with miya
+func Render(c *Case) (string, error) {
+	env := miya.NewEnvironment(
+		miya.WithLoader(caseLoader(c.Templates)),
+		miya.WithAutoEscape(c.Options.Autoescape),
+		miya.WithTrimBlocks(c.Options.TrimBlocks),
+		miya.WithLstripBlocks(c.Options.LstripBlocks),
+		miya.WithKeepTrailingNewline(c.Options.KeepTrailingNewline),
+	)
+	return env.RenderTemplate(EntryTemplate, miya.NewContextFrom(c.Context))
+}
+
+// caseLoader serves a case's templates. It only implements the basic loader
+// interface, so templates are compiled with the environment's whitespace
+// settings just as Jinja2 applies them.
+type caseLoader map[string]string
+
+func (l caseLoader) GetSource(name string) (string, error) {
+	source, ok := l[name]
+	if !ok {
+		return "", fmt.Errorf("template not found: %s", name)
+	}
+	return source, nil
+}
+
+func (l caseLoader) IsCached(name string) bool {
+	return false
+}
+
+func (l caseLoader) ListTemplates() ([]string, error) {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
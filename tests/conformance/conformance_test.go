@@ -0,0 +1,62 @@
+package conformance
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+var strict = flag.Bool("conformance.strict", false, "ignore expected_miya.txt and miya_error overrides and compare every case with Jinja2's output")
+
+func TestConformance(t *testing.T) {
+	cases, err := LoadCases("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no conformance cases found in testdata")
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, err := Render(c)
+
+			if c.Diverges() && !*strict && err == nil && got == c.Expected {
+				t.Fatalf("output now matches Jinja2; remove the divergence recorded for this case (%s)", c.Options.Comment)
+			}
+
+			if c.Options.MiyaError != "" && !*strict {
+				if err == nil || !strings.Contains(err.Error(), c.Options.MiyaError) {
+					t.Fatalf("expected miya error containing %q (%s), got %v", c.Options.MiyaError, c.Options.Comment, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+
+			want, source := c.Expected, expectedFile
+			if c.ExpectedMiya != nil && !*strict {
+				want, source = *c.ExpectedMiya, expectedMiyaFile
+			}
+			if got != want {
+				t.Errorf("output differs from %s:\n%s", source, Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff("a\nb \nc\n", "a\nb\nc\nd")
+	want := `--- jinja2
++++ miya
+  "a\n"
+- "b \n"
++ "b\n"
+  "c\n"
++ "d"
+`
+	if got != want {
+		t.Errorf("Diff:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package conformance
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Diff returns a line diff of want and got. Lines are quoted so differences
+// in spaces, tabs and newlines are visible; lines only in want are prefixed
+// with "-", lines only in got with "+".
+func Diff(want, got string) string {
+	a, b := splitLines(want), splitLines(got)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("--- jinja2\n+++ miya\n")
+	line := func(prefix, text string) {
+		out.WriteString(prefix)
+		out.WriteString(strconv.Quote(text))
+		out.WriteByte('\n')
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line("  ", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			line("- ", a[i])
+			i++
+		default:
+			line("+ ", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
+
+// splitLines splits s after each newline, keeping the newlines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -341,7 +341,7 @@ func TestCoreTemplateEngine(t *testing.T) {
 			Name:     "Raw blocks",
 			Template: `{% raw %}{{ not_rendered }} {% for x in y %}{% endraw %}`,
 			Context:  map[string]interface{}{},
-			Expected: "{{not_rendered}} {%forxiny%}",
+			Expected: "{{ not_rendered }} {% for x in y %}",
 		},
 		{
 			Name:     "Filter blocks",
@@ -466,14 +466,14 @@ func TestCoreEngineErrors(t *testing.T) {
 			Template:      `{% if condition %}content`,
 			Context:       map[string]interface{}{"condition": true},
 			ShouldError:   true,
-			ErrorContains: "close if statement",
+			ErrorContains: "unclosed 'if' tag",
 		},
 		{
 			Name:          "Missing endfor",
 			Template:      `{% for item in items %}{{ item }}`,
 			Context:       map[string]interface{}{"items": []int{1, 2, 3}},
 			ShouldError:   true,
-			ErrorContains: "close for statement",
+			ErrorContains: "unclosed 'for' tag",
 		},
 
 		// Variable errors (this might not error in this implementation)
@@ -550,7 +550,7 @@ func TestCoreEngineErrors(t *testing.T) {
 			Template:      `{{ nonexistent_macro() }}`,
 			Context:       map[string]interface{}{},
 			ShouldError:   true,
-			ErrorContains: "cannot call non-function",
+			ErrorContains: "is not callable",
 		},
 	}
 
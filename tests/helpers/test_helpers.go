@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	jinja2 "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
 )
 
 // TestCase represents a standard test case structure
@@ -64,6 +65,16 @@ func CreateEnvironment() *jinja2.Environment {
 	return jinja2.NewEnvironment()
 }
 
+// CreateLoaderEnvironment creates a test environment loading templates, a map
+// of names to sources, from a string loader
+func CreateLoaderEnvironment(templates map[string]string, opts ...jinja2.EnvironmentOption) *jinja2.Environment {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	for name, source := range templates {
+		stringLoader.AddTemplate(name, source)
+	}
+	return jinja2.NewEnvironment(append([]jinja2.EnvironmentOption{jinja2.WithLoader(stringLoader)}, opts...)...)
+}
+
 // contains is a simple string contains check
 func contains(s, substr string) bool {
 	return len(substr) == 0 || len(s) >= len(substr) && (s == substr || containsAt(s, substr, 0, len(s)-len(substr)+1))
@@ -111,7 +111,6 @@ func TestAdvancedFeaturesIntegration(t *testing.T) {
 		ctx.Set("user", map[string]interface{}{
 			"name":  "Alice",
 			"email": "alice@example.com",
-			"age":   nil,
 		})
 		runtimeCtx := miya.NewTemplateContextAdapter(ctx, env)
 
@@ -214,10 +213,11 @@ func TestAdvancedFeaturesIntegration(t *testing.T) {
 			t.Fatalf("Dictionary comprehension failed: %v", err)
 		}
 
-		resultMap, ok := result.(map[string]interface{})
+		resultDict, ok := result.(*runtime.Dict)
 		if !ok {
-			t.Fatalf("Expected map, got %T", result)
+			t.Fatalf("Expected a Dict, got %T", result)
 		}
+		resultMap := resultDict.Map()
 
 		// Should contain Bob and Charlie
 		expectedKeys := []string{"Bob", "Charlie"}
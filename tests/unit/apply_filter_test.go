@@ -0,0 +1,59 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestMacrosAsFilters(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("ui.html", `{% macro card(body, title="Card") %}<div title="{{ title }}">{{ body }}</div>{% endmacro %}`)
+
+	tests := []struct {
+		name       string
+		autoescape bool
+		template   string
+		expected   string
+	}{
+		{"ApplyImportedMacro", false, `{% import "ui.html" as ui %}{{ "hi"|apply(ui.card, "T") }}`, `<div title="T">hi</div>`},
+		{"ApplyDefaultArgument", false, `{% import "ui.html" as ui %}{{ "hi"|apply(ui.card) }}`, `<div title="Card">hi</div>`},
+		{"ApplyInChain", false, `{% macro wrap(s, l, r) %}{{ l }}{{ s }}{{ r }}{% endmacro %}{{ "a"|upper|apply(wrap, "[", "]")|lower }}`, "[a]"},
+		{"ApplyFunction", false, `{{ "ab"|apply(repeat, 2) }}`, "abab"},
+		{"ApplyKeywords", false, `{{ "ab"|apply(kw, n=3) }}`, "ab3"},
+		{"ScopeMacro", false, `{% macro shout(s, mark="!") %}{{ s|upper }}{{ mark }}{% endmacro %}{{ "hi"|shout }}{{ "yo"|shout("?") }}`, "HI!YO?"},
+		{"ScopeImportedMacro", false, `{% from "ui.html" import card %}{{ "x"|card("T") }}`, `<div title="T">x</div>`},
+		{"ScopeFunction", false, `{{ "ab"|repeat(2) }}`, "abab"},
+		{"ScopeFilterBlock", false, `{% macro shout(s) %}{{ s|upper }}!{% endmacro %}{% filter shout %}hi{% endfilter %}`, "HI!"},
+		{"RegisteredFilterWins", false, `{% macro upper(s) %}macro{% endmacro %}{{ "a"|upper }}`, "A"},
+		{"EscapingMatchesCall", true, `{% macro b(s) %}<b>{{ s }}</b>{% endmacro %}{{ b("<i>") }}|{{ "<i>"|b }}|{{ "<i>"|apply(b) }}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(tt.autoescape))
+			env.AddGlobal("repeat", strings.Repeat)
+			env.AddGlobal("kw", func(args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+				return fmt.Sprint(args[0], kwargs["n"]), nil
+			})
+			out, err := env.RenderString(tt.template, miya.NewContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expected == "" {
+				// The three forms must agree on escaping
+				parts := strings.Split(out, "|")
+				if len(parts) != 3 || parts[0] != parts[1] || parts[0] != parts[2] {
+					t.Errorf("macro call and filter forms differ: %q", out)
+				}
+				return
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestAutoescapeContext(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("value.html", `[{{ s }}]`)
+	vars := map[string]interface{}{
+		"s":     `a"b<c>'`,
+		"data":  map[string]interface{}{"k": "</script>"},
+		"items": []string{"it's", "ok"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"js config block", `<p>{{ s }}</p><script>{% autoescape 'js' %}var cfg = {{ data|tojson }}; var s = "{{ s }}";{% endautoescape %}</script>{{ s }}`,
+			`<p>a&#34;b&lt;c&gt;&#39;</p><script>var cfg = {"k":"\u003c/script\u003e"}; var s = "a\"b\u003cc\u003e\'";</script>a&#34;b&lt;c&gt;&#39;`},
+		{"double quotes", `{% autoescape "js" %}'{{ s }}'{% endautoescape %}`, `'a\"b\u003cc\u003e\''`},
+		{"loops and concatenation", `{% autoescape 'js' %}{% for i in items %}'{{ i }}',{% endfor %}{{ s ~ "!" }}{% endautoescape %}`, `'it\'s','ok',a\"b\u003cc\u003e\'!`},
+		{"includes", `{% autoescape 'js' %}{% include "value.html" %}{% endautoescape %}`, `[a\"b\u003cc\u003e\']`},
+		{"safe values", `{% autoescape 'js' %}{{ s|safe }}{% endautoescape %}`, `a"b<c>'`},
+		{"nested", `{% autoescape 'js' %}{{ s }}|{% autoescape 'url' %}{{ s }}{% endautoescape %}|{% autoescape false %}{{ s }}{% endautoescape %}|{{ s }}{% endautoescape %}`,
+			`a\"b\u003cc\u003e\'|a%22b%3Cc%3E%27|a"b<c>'|a\"b\u003cc\u003e\'`},
+		{"css", `{% autoescape 'css' %}{{ s }}{% endautoescape %}`, `a\"b<c>\'`},
+		{"none", `{% autoescape 'none' %}{{ s }}{{ s ~ s }}{% endautoescape %}{{ s }}`, `a"b<c>'a"b<c>'a"b<c>'a&#34;b&lt;c&gt;&#39;`},
+	}
+
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(true))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("without autoescape", func(t *testing.T) {
+		plain := miya.NewEnvironment(miya.WithAutoEscape(false))
+		result, err := plain.RenderString(`{{ s }}|{% autoescape 'js' %}{{ s }}{% endautoescape %}`, miya.NewContextFrom(vars))
+		expected := `a"b<c>'|a\"b\u003cc\u003e\'`
+		if err != nil || result != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, result, err)
+		}
+	})
+
+	t.Run("unknown context", func(t *testing.T) {
+		_, err := env.RenderString("line one\n{% autoescape 'python' %}{{ s }}{% endautoescape %}", miya.NewContextFrom(vars))
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Line != 2 || !strings.Contains(err.Error(), "expected one of: css, html, js, json, none, text, url, xhtml, xml") {
+			t.Errorf("expected an error at line 2 listing the contexts, got %v", err)
+		}
+	})
+
+	t.Run("printed", func(t *testing.T) {
+		tmpl, err := env.FromString(`{% autoescape 'js' %}{{ s }}{% endautoescape %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed := parser.Print(tmpl.AST()); !strings.Contains(printed, `{% autoescape "js" %}`) {
+			t.Errorf("got %q", printed)
+		}
+	})
+}
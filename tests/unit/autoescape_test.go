@@ -85,7 +85,8 @@ func TestEnhancedAutoEscape(t *testing.T) {
 			{"style.css", runtime.EscapeContextCSS},
 			{"api.json", runtime.EscapeContextJSON},
 			{"document.xhtml", runtime.EscapeContextXHTML},
-			{"unknown.txt", runtime.EscapeContextHTML}, // Default
+			{"notes.txt", runtime.EscapeContextText},
+			{"unknown.md", runtime.EscapeContextHTML}, // Default
 		}
 
 		for _, tc := range tests {
@@ -1,8 +1,11 @@
 package miya_test
 
 import (
-	miya "github.com/zipreport/miya"
+	"strings"
 	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
 )
 
 func TestCallBlocks(t *testing.T) {
@@ -88,3 +91,86 @@ func TestCallBlockWithMacro(t *testing.T) {
 		t.Errorf("Expected '%s', got '%s'", expected, result)
 	}
 }
+
+func TestCallBlockKeywordArguments(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("ui.html", `{% macro card(title, footer="none") %}[{{ title }}|{{ footer }}|{% if caller is defined %}{{ caller() }}{% endif %}]{% endmacro %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"keywords", `{% macro card(title, footer="F") %}[{{ title }}|{{ footer }}|{{ caller() }}]{% endmacro %}{% call card(title="X", footer="Y") %}body{% endcall %}`, "[X|Y|body]"},
+		{"positional and keyword", `{% macro card(title, footer="F") %}[{{ title }}|{{ footer }}|{{ caller() }}]{% endmacro %}{% call card("X", footer=none) %}body{% endcall %}`, "[X||body]"},
+		{"keywords outside call blocks", `{% macro card(title, footer="F") %}[{{ title }}|{{ footer }}]{% endmacro %}{{ card(footer="Y", title="X") }}`, "[X|Y]"},
+		{"imported macro", `{% import "ui.html" as ui %}{% call ui.card(title="User Profile", footer="footer") %}body{% endcall %}`, "[User Profile|footer|body]"},
+		{"from import", `{% from "ui.html" import card %}{% call card(title="X") %}body{% endcall %}{{ card(title="Y", footer=1) }}`, "[X|none|body][Y|1|]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stringLoader.AddTemplate("page.html", tt.template)
+			env.ClearCache()
+			result, err := env.RenderTemplate("page.html", miya.NewContext())
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("multiple values", func(t *testing.T) {
+		_, err := env.RenderString(`{% macro card(title) %}{{ title }}{% endmacro %}{{ card("X", title="Y") }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "multiple values for argument 'title'") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestOptionalCallerSlot(t *testing.T) {
+	macro := `{% macro panel(title) %}<{{ title }}{% if caller is defined %}: {{ caller() }}{% endif %}>{% endmacro %}`
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"without call block", macro + `{{ panel("a") }}`, "<a>"},
+		{"with call block", macro + `{% call panel("a") %}body{% endcall %}`, "<a: body>"},
+		{"both", macro + `{{ panel("a") }}{% call panel("b") %}body{% endcall %}{{ panel("c") }}`, "<a><b: body><c>"},
+		{"default filter", `{% macro panel() %}[{{ caller|default("none") }}]{% endmacro %}{{ panel() }}`, "[none]"},
+		{"undefined test", `{% macro panel() %}{{ caller is undefined }}{% endmacro %}{{ panel() }}{% call panel() %}{% endcall %}`, "truefalse"},
+		// The caller belongs to the macro the call block calls, not the
+		// macros that one calls in turn
+		{"not inherited", macro + `{% macro outer() %}{{ panel("inner") }}{{ caller() }}{% endmacro %}{% call outer() %}body{% endcall %}`, "<inner>body"},
+	}
+
+	for _, undefined := range []struct {
+		name   string
+		strict bool
+	}{{"lax", false}, {"strict", true}} {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false), miya.WithStrictUndefined(undefined.strict))
+		for _, tt := range tests {
+			t.Run(undefined.name+"/"+tt.name, func(t *testing.T) {
+				result, err := env.RenderString(tt.template, miya.NewContext())
+				if err != nil {
+					t.Fatalf("Error rendering template: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("Expected %q, got %q", tt.expected, result)
+				}
+			})
+		}
+
+		t.Run(undefined.name+"/calling an absent caller", func(t *testing.T) {
+			_, err := env.RenderString(`{% macro panel() %}{{ caller() }}{% endmacro %}{{ panel() }}`, miya.NewContext())
+			if err == nil || !strings.Contains(err.Error(), "macro 'panel' was called without a call block") {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+func newCallStackEnv() *miya.Environment {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("forms.html", "{% macro input(name) %}\n<input name=\"{{ name }}\">\n{{ name|frobnicate }}\n{% endmacro %}\n")
+	stringLoader.AddTemplate("register.html", "{% import \"forms.html\" as forms %}\n{% macro form_group(name) %}\n<div>\n{{ forms.input(name) }}\n</div>\n{% endmacro %}\n<form>\n{{ form_group(\"email\") }}\n</form>\n")
+	stringLoader.AddTemplate("tree.html", "{% macro tree(path) %}\n{% if path|length < 12 %}{{ tree(path ~ \"x\") }}{% else %}{{ path|frobnicate }}{% endif %}\n{% endmacro %}\n{% include \"page.html\" %}")
+	stringLoader.AddTemplate("page.html", "<h1>Tree</h1>\n{{ tree(\"\") }}")
+	stringLoader.AddTemplate("top.html", "{{ title|frobnicate }}")
+	return miya.NewEnvironment(miya.WithLoader(stringLoader))
+}
+
+func renderRuntimeError(t *testing.T, env *miya.Environment, name string) *runtime.RuntimeError {
+	t.Helper()
+	_, err := env.RenderTemplate(name, miya.NewContext())
+	var runtimeErr *runtime.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	return runtimeErr
+}
+
+func TestRuntimeErrorCallStack(t *testing.T) {
+	env := newCallStackEnv()
+
+	t.Run("TwoLevelMacroCall", func(t *testing.T) {
+		runtimeErr := renderRuntimeError(t, env, "register.html")
+		want := "in macro 'input' (forms.html:3), called from 'form_group' (register.html:4), called from template register.html:8"
+		if got := runtimeErr.CallStackTrace(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if !strings.Contains(runtimeErr.DetailedError(), "Call stack: "+want+"\n") {
+			t.Errorf("detailed error lacks the call stack:\n%s", runtimeErr.DetailedError())
+		}
+	})
+
+	t.Run("RecursionAndInclude", func(t *testing.T) {
+		runtimeErr := renderRuntimeError(t, env, "tree.html")
+		want := "in macro 'tree' (tree.html:2), ... 12 recursive frames ..., called from template page.html:2, included from template tree.html:4"
+		if got := runtimeErr.CallStackTrace(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("OutsideMacros", func(t *testing.T) {
+		runtimeErr := renderRuntimeError(t, env, "top.html")
+		if trace := runtimeErr.CallStackTrace(); trace != "" {
+			t.Errorf("unexpected call stack %q", trace)
+		}
+		if strings.Contains(runtimeErr.DetailedError(), "Call stack") {
+			t.Errorf("detailed error has a call stack:\n%s", runtimeErr.DetailedError())
+		}
+	})
+
+	t.Run("DepthCapped", func(t *testing.T) {
+		runtimeErr := &runtime.RuntimeError{Line: 1}
+		runtimeErr.CallStack = append(runtimeErr.CallStack, runtime.CallFrame{Kind: runtime.FrameTemplate, Name: "page.html", Template: "page.html"})
+		for i := 0; i < 30; i++ {
+			name := string(rune('a' + i%2))
+			runtimeErr.CallStack = append(runtimeErr.CallStack, runtime.CallFrame{Kind: runtime.FrameMacro, Name: name, Template: "page.html", CallLine: 1})
+		}
+		trace := runtimeErr.CallStackTrace()
+		if entries := strings.Split(trace, ", "); len(entries) != 10 {
+			t.Errorf("expected 10 entries, got %d: %q", len(entries), trace)
+		}
+		if !strings.Contains(trace, "... 22 more frames ...") || !strings.HasSuffix(trace, "called from template page.html:1") {
+			t.Errorf("unexpected trace %q", trace)
+		}
+	})
+}
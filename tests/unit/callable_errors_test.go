@@ -0,0 +1,54 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestCallableErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"CallMap", `{{ user() }}`, "cannot call 'user': object of type map[string]interface {} is not callable at line 1, column 4"},
+		{"CallAttribute", `{{ user.name() }}`, "cannot call 'user.name': object of type string is not callable"},
+		{"CallNumber", "\n{{ count() }}", "cannot call 'count': object of type int is not callable at line 2, column 4"},
+		{"CallExpression", `{{ (count)() }}`, "object of type int is not callable"},
+		{"FilterFunction", `{{ greet|upper }}`, "cannot convert function 'greet' to string — did you mean to call it? at line 1, column 4"},
+		{"FilterAttribute", `{{ user.greet|trim }}`, "cannot convert function 'user.greet' to string"},
+		{"FilterMacro", `{% macro card() %}x{% endmacro %}{{ card|upper }}`, "cannot convert function 'card' to string"},
+		{"EscapeFunction", `{{ greet|e }}`, "cannot convert function 'greet' to string"},
+		{"OutputFunction", `{{ greet }}`, "cannot convert function 'greet' to string — did you mean to call it?"},
+		{"ConcatFunction", `{{ "Hi " ~ greet }}`, "cannot convert function 'greet' to string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("user", map[string]interface{}{"name": "ann", "greet": func() string { return "hi" }})
+			ctx.Set("greet", func() string { return "hi" })
+			ctx.Set("count", 3)
+			_, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("got %q, want it to contain %q", err.Error(), tt.expected)
+			}
+		})
+	}
+
+	// Calling the function works as before
+	ctx := miya.NewContext()
+	ctx.Set("greet", func() string { return "hi" })
+	out, err := miya.NewEnvironment().RenderString(`{{ greet()|upper }} {{ greet is callable }}`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "HI true" {
+		t.Errorf("got %q", out)
+	}
+}
@@ -0,0 +1,119 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+func newChildContentLoader() *loader.StringLoader {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<main>{% block content %}{% endblock %}</main>`)
+	stringLoader.AddTemplate("clean.html", "{% extends \"base.html\" %}\n{# page #}\n{% import \"macros.html\" as m %}\n{% set title = \"Home\" %}\n{% macro helper() %}x{% endmacro %}\n{% block content %}ok{% endblock %}\n")
+	stringLoader.AddTemplate("stray.html", "{% extends \"base.html\" %}\n<p>lost paragraph</p>\n{% block content %}kept{% endblock %}")
+	stringLoader.AddTemplate("stray_var.html", "{% extends \"base.html\" %}{% if true %}\n  {{ banner }}\n{% endif %}{% block content %}kept{% endblock %}")
+	stringLoader.AddTemplate("middle.html", "{% extends \"base.html\" %}oops{% block content %}[{% block inner %}{% endblock %}]{% endblock %}")
+	stringLoader.AddTemplate("leaf.html", `{% extends "middle.html" %}{% block inner %}leaf{% endblock %}`)
+	stringLoader.AddTemplate("macros.html", `{% macro m() %}{% endmacro %}`)
+	return stringLoader
+}
+
+func TestChildContentPolicy(t *testing.T) {
+	t.Run("IgnoreByDefault", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(newChildContentLoader()))
+		out, err := env.RenderTemplate("stray.html", miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "<main>kept</main>" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(newChildContentLoader()), miya.WithChildContentPolicy(miya.ChildContentError))
+		tests := []struct {
+			template string
+			orphan   string // the template holding the content
+			line     int
+			content  string
+		}{
+			{"stray.html", "stray.html", 2, "<p>lost paragraph</p>"},
+			{"stray_var.html", "stray_var.html", 2, "{{ banner }}"},
+			{"leaf.html", "middle.html", 1, "oops"},
+		}
+		for _, tt := range tests {
+			_, err := env.RenderTemplate(tt.template, miya.NewContext())
+			var orphanErr *runtime.OrphanedContentError
+			if !errors.As(err, &orphanErr) {
+				t.Fatalf("%s: expected an OrphanedContentError, got %v", tt.template, err)
+			}
+			if orphanErr.Template != tt.orphan || orphanErr.Line != tt.line || orphanErr.Content != tt.content {
+				t.Errorf("%s: got %+v", tt.template, orphanErr)
+			}
+			if !strings.Contains(err.Error(), tt.content) {
+				t.Errorf("%s: error %q does not quote the content", tt.template, err)
+			}
+		}
+
+		out, err := env.RenderTemplate("clean.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("whitespace, comments and definitions were reported: %v", err)
+		}
+		if out != "<main>ok</main>" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("CompileAll", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(newChildContentLoader()), miya.WithChildContentPolicy(miya.ChildContentError))
+		err := env.CompileAll()
+		for _, name := range []string{"stray.html", "stray_var.html", "middle.html"} {
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("CompileAll did not report %s: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Warn", func(t *testing.T) {
+		var warnings []miya.TemplateWarning
+		env := miya.NewEnvironment(
+			miya.WithLoader(newChildContentLoader()),
+			miya.WithChildContentPolicy(miya.ChildContentWarn),
+			miya.WithWarningHandler(func(w miya.TemplateWarning) { warnings = append(warnings, w) }),
+		)
+		for i := 0; i < 3; i++ {
+			out, err := env.RenderTemplate("stray.html", miya.NewContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != "<main>kept</main>" {
+				t.Errorf("got %q", out)
+			}
+		}
+		if len(warnings) != 1 {
+			t.Fatalf("expected one warning for the cached hierarchy, got %v", warnings)
+		}
+		if w := warnings[0]; w.Template != "stray.html" || w.Line != 2 || !strings.Contains(w.Message, "lost paragraph") {
+			t.Errorf("unexpected warning %+v", w)
+		}
+	})
+
+	t.Run("Overlay", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(newChildContentLoader()))
+		if _, err := env.RenderTemplate("stray.html", miya.NewContext()); err != nil {
+			t.Fatal(err)
+		}
+		strict := env.Overlay(miya.WithChildContentPolicy(miya.ChildContentError))
+		if _, err := strict.RenderTemplate("stray.html", miya.NewContext()); err == nil {
+			t.Error("overlay reused the parent's unchecked hierarchy")
+		}
+		if _, err := env.RenderTemplate("stray.html", miya.NewContext()); err != nil {
+			t.Errorf("overlay policy leaked into the parent: %v", err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package miya_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestCollectionMethods(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	render := func(t *testing.T, source string, vars map[string]interface{}) (string, error) {
+		t.Helper()
+		tmpl, err := env.FromString(source)
+		if err != nil {
+			t.Fatalf("parse %q: %v", source, err)
+		}
+		return tmpl.Render(miya.NewContextFrom(vars))
+	}
+	items := []interface{}{
+		map[string]interface{}{"id": 3},
+		map[string]interface{}{"id": 1},
+		map[string]interface{}{"id": 3},
+		map[string]interface{}{"id": 2},
+		map[string]interface{}{"id": 1},
+	}
+
+	t.Run("DeduplicateInLoop", func(t *testing.T) {
+		out, err := render(t, `{% set seen = list() %}`+
+			`{% for item in items %}{% if item.id not in seen %}{% do seen.append(item.id) %}{% endif %}{% endfor %}`+
+			`{{ seen|join(",") }} {{ seen|length }} {{ seen[0] }} {{ seen[-1] }} {{ seen[1:]|join(",") }} {{ seen|tojson }}`+
+			`{% for id in seen %} #{{ loop.index }}={{ id }}{% endfor %}`, map[string]interface{}{"items": items})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "3,1,2 3 3 2 1,2 [3,1,2] #1=3 #2=1 #3=2"
+		if out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("ListMethods", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{% set l = list() %}{{ l|length }}{{ l is sequence }}{% if not l %} empty{% endif %}`, "0true empty"},
+			{`{% set l = list([1, 2]) %}{% do l.extend([3, 4]) %}{{ l|join(",") }}`, "1,2,3,4"},
+			{`{% set l = list("ab") %}{% do l.extend("cd") %}{{ l|join(",") }}`, "a,b,c,d"},
+			{`{% set l = list([1, 2]) %}{% do l.insert(0, "a") %}{% do l.insert(-1, "b") %}{% do l.insert(99, "c") %}{{ l|join(",") }}`, "a,1,b,2,c"},
+			{`{% set l = list([1, 2, 3]) %}{{ l.pop() }} {{ l.pop(0) }} {{ l|join(",") }}`, "3 1 2"},
+			{`{% set l = list([1, 2, 1]) %}{% do l.remove(1) %}{{ l|join(",") }}`, "2,1"},
+			{`{% set l = list([1, 2]) %}{{ l == [1, 2] }} {{ 2 in l }} {{ l|sum }} {{ l|reverse|join(",") }}`, "true true 3 2,1"},
+			{`{% set a = list() %}{% set b = a %}{% do b.append(1) %}{{ a|join(",") }}`, "1"},
+		}
+		for _, tt := range tests {
+			out, err := render(t, tt.source, nil)
+			if err != nil {
+				t.Errorf("%s: %v", tt.source, err)
+				continue
+			}
+			if out != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, out, tt.want)
+			}
+		}
+	})
+
+	t.Run("DictMethods", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{% set counts = {} %}{% for t in ["go", "js", "go"] %}{% do counts.update({t: counts.get(t, 0) + 1}) %}{% endfor %}{{ counts|tojson }}`, `{"go":2,"js":1}`},
+			{`{% set d = dict(a=1) %}{% do d.update(b=2, c=3) %}{% for k, v in d|dictsort %}{{ k }}{{ v }}{% endfor %}`, "a1b2c3"},
+			{`{% set d = {"a": 1} %}{{ d.get("a") }} {{ d.get("x", "none") }} {{ d.get("x") is none }}`, "1 none true"},
+			{`{% set d = {"a": 1, "b": 2} %}{{ d.pop("a") }} {{ d.pop("x", 0) }} {{ d|length }}`, "1 0 1"},
+			{`{% set d = {"a": 1} %}{{ d.setdefault("a", 5) }} {{ d.setdefault("b", 5) }} {{ d.b }}`, "1 5 5"},
+			{`{% set by_role = {} %}{% for u in users %}{% do by_role.setdefault(u.role, list()).append(u.name) %}{% endfor %}{{ by_role.admin|join(",") }}/{{ by_role.user|join(",") }}`, "ann,cy/bo"},
+			{`{% set d = {"b": 1, "a": 2} %}{{ d.keys()|join(",") }} {{ d.values()|join(",") }}{% for k, v in d.items() %} {{ k }}={{ v }}{% endfor %}`, "b,a 1,2 b=1 a=2"},
+			{`{% set d = {"get": "key"} %}{{ d.get }}`, "key"},
+		}
+		users := []interface{}{
+			map[string]interface{}{"name": "ann", "role": "admin"},
+			map[string]interface{}{"name": "bo", "role": "user"},
+			map[string]interface{}{"name": "cy", "role": "admin"},
+		}
+		for _, tt := range tests {
+			out, err := render(t, tt.source, map[string]interface{}{"users": users})
+			if err != nil {
+				t.Errorf("%s: %v", tt.source, err)
+				continue
+			}
+			if out != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, out, tt.want)
+			}
+		}
+	})
+
+	t.Run("DictLiteralsAreNewEachTime", func(t *testing.T) {
+		tmpl, err := env.FromString(`{% set d = {"fixed": 1} %}{% do d.update({key: 1}) %}{{ d|length }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"key": key}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != "2" {
+				t.Errorf("render with key %s: got %q, want 2", key, out)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{% set l = [] %}{% do l.append(1) %}`, "append() needs a list made with list()"},
+			{`{% set l = list() %}{{ l.pop() }}`, "pop from empty list"},
+			{`{% set l = list([1]) %}{{ l.pop(3) }}`, "pop index out of range"},
+			{`{% set l = list([1]) %}{% do l.remove(2) %}`, "x not in list"},
+			{`{% set l = list() %}{% do l.append(1, 2) %}`, "append() takes exactly one argument (2 given)"},
+			{`{% set l = list() %}{% do l.extend(1) %}`, "is not iterable"},
+			{`{% set d = {} %}{{ d.pop("x") }}`, `key "x" not found`},
+			{`{% set d = {} %}{% do d.update(1) %}`, "update() requires a dict"},
+		}
+		for _, tt := range tests {
+			_, err := render(t, tt.source, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.source, err, tt.want)
+			}
+		}
+	})
+}
+
+func TestCollectionsFromImportsPerRender(t *testing.T) {
+	templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	templates.AddTemplate("state.html", `{% set registry = {} %}{% set names = list() %}`)
+	templates.AddTemplate("page.html", `{% import "state.html" as state %}`+
+		`{% do state.registry.update({key: 1}) %}{% do state.names.append(key) %}`+
+		`{{ state.registry|length }}{{ state.names|length }}`)
+	env := miya.NewEnvironment(miya.WithLoader(templates))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				ctx := miya.NewContextFrom(map[string]interface{}{"key": strings.Repeat("k", i*20+j+1)})
+				out, err := env.RenderTemplate("page.html", ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if out != "11" {
+					t.Errorf("got %q, want each render to start from the imported template's empty dict and list", out)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
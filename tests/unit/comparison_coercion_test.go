@@ -0,0 +1,70 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestComparisonCoercion(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"NumericString", `{{ qty > 10 }} {{ qty >= 42 }} {{ 41.5 < qty }}`, "true true true"},
+		{"NineVersusTen", `{{ small > 10 }} {{ small < 10 }} {{ 10 > small }}`, "false true true"},
+		{"FloatString", `{{ price < 10 }} {{ price > 9.98 }}`, "true true"},
+		{"TwoStrings", `{{ "b" > "a" }} {{ "apple" < "banana" }}`, "true true"},
+		{"NonNumericString", `{{ name > 10 }} {{ name < 10 }} {{ name <= 10 }} {{ name >= 10 }}`, "false false false false"},
+		{"NotClean", `{{ padded > 10 }} {{ "0x10" > 1 }} {{ "nan" < 1 }} {{ "inf" > 1 }}`, "false false false false"},
+		{"Equality", `{{ qty == 42 }} {{ qty == "42" }}`, "false true"},
+		{"None", `{{ none < 1 }} {{ nothing > 1 }}`, "false false"},
+		{"Chain", `{{ 1 < 2 < 3 }} {{ 3 > 2 > 2 }} {{ 0 < qty <= 100 }} {{ 0 < small < 5 }}`, "true false true false"},
+		{"ChainStops", `{{ 5 < 1 < name }}`, "false"},
+		{"ChainMixed", `{{ 1 < 2 == true }} {{ "a" in "abc" in ["abc"] }}`, "false true"},
+		{"Grouped", `{{ (1 < 2) == true }}`, "true"},
+		{"IntDefault", `{{ qty|int(0) + 1 }} {{ name|int(0) }} {{ name|int(-1) > 10 }}`, "43 0 false"},
+		{"FloatDefault", `{{ price|float(0.0) }} {{ name|float(1.5) }}`, "9.99 1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{
+				"qty":    "42",
+				"small":  "9",
+				"price":  "9.99",
+				"name":   "abc",
+				"padded": " 42",
+			})
+			out, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("Strict", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		ctx := miya.NewContextFrom(map[string]interface{}{"qty": "42", "name": "abc"})
+		out, err := env.RenderString(`{{ qty > 10 }}`, ctx)
+		if err != nil || out != "true" {
+			t.Fatalf("got %q, %v", out, err)
+		}
+
+		_, err = env.RenderString(`{{ name > 10 }}`, ctx)
+		var rtErr *runtime.RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Type != runtime.ErrorTypeType {
+			t.Fatalf("expected a TypeError, got %v", err)
+		}
+		if !strings.Contains(err.Error(), `'>' not supported between string "abc" and int`) {
+			t.Errorf("got %v", err)
+		}
+	})
+}
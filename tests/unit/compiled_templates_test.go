@@ -0,0 +1,85 @@
+package miya_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestCompileAllAndBundles(t *testing.T) {
+	templates := map[string]string{
+		"base.html":       `<html>{% block body %}base{% endblock %}</html>`,
+		"macros.html":     `{% macro greet(name) %}Hello {{ name }}{% endmacro %}`,
+		"pages/a.html":    `{% extends "../base.html" %}{% block body %}{% import "macros.html" as m %}{{ m.greet(user) }} {{ items | join(",") }}{% endblock %}`,
+		"pages/b.html":    `{% extends "base.html" %}{% block body %}{{ super() }}+{% include "./part.html" %}{% endblock %}`,
+		"pages/part.html": `{% for i in range(3) %}{{ i }}{% endfor %}`,
+	}
+
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	for name, source := range templates {
+		stringLoader.AddTemplate(name, source)
+	}
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+
+	ctx := miya.NewContextFrom(map[string]interface{}{"user": "Ann", "items": []string{"x", "y"}})
+
+	t.Run("CompileAll", func(t *testing.T) {
+		if err := env.CompileAll(); err != nil {
+			t.Fatalf("CompileAll failed: %v", err)
+		}
+		if size := env.GetCacheSize(); size != len(templates) {
+			t.Errorf("expected %d cached templates, got %d", len(templates), size)
+		}
+	})
+
+	t.Run("BundleRendersLikeSource", func(t *testing.T) {
+		var bundle bytes.Buffer
+		if err := env.EncodeTemplates(&bundle); err != nil {
+			t.Fatalf("EncodeTemplates failed: %v", err)
+		}
+
+		compiledLoader, err := loader.NewCompiledLoader(&bundle)
+		if err != nil {
+			t.Fatalf("NewCompiledLoader failed: %v", err)
+		}
+		compiledEnv := miya.NewEnvironment(miya.WithLoader(compiledLoader), miya.WithAutoEscape(false))
+
+		for _, name := range []string{"pages/a.html", "pages/b.html"} {
+			expected, err := env.RenderTemplate(name, ctx)
+			if err != nil {
+				t.Fatalf("render %s from source failed: %v", name, err)
+			}
+			result, err := compiledEnv.RenderTemplate(name, ctx)
+			if err != nil {
+				t.Fatalf("render %s from bundle failed: %v", name, err)
+			}
+			if result != expected {
+				t.Errorf("%s: expected %q, got %q", name, expected, result)
+			}
+		}
+	})
+
+	t.Run("AggregatesErrors", func(t *testing.T) {
+		broken := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		broken.AddTemplate("ok.html", `fine`)
+		broken.AddTemplate("bad1.html", `{% if %}`)
+		broken.AddTemplate("bad2.html", `{% extends "missing.html" %}`)
+		brokenEnv := miya.NewEnvironment(miya.WithLoader(broken))
+
+		err := brokenEnv.CompileAll()
+		if err == nil {
+			t.Fatal("expected CompileAll to fail")
+		}
+		for _, name := range []string{"bad1.html", "bad2.html"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("expected error to mention %s, got %v", name, err)
+			}
+		}
+		if strings.Contains(err.Error(), "ok.html") {
+			t.Errorf("did not expect ok.html in errors, got %v", err)
+		}
+	})
+}
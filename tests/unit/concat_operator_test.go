@@ -0,0 +1,114 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+type concatLabel struct{ name string }
+
+func (l concatLabel) String() string { return "label:" + l.name }
+
+func TestConcatOperator(t *testing.T) {
+	sum := 0.1
+	vars := map[string]interface{}{
+		"nothing":  nil,
+		"float":    sum + 0.2,
+		"float32":  float32(2.1),
+		"whole":    3.0,
+		"big":      1e20,
+		"small":    0.00001,
+		"int64":    int64(-7),
+		"uint":     uint8(200),
+		"flag":     false,
+		"list":     []interface{}{1, "a"},
+		"strings":  []string{"x", "y"},
+		"config":   map[string]interface{}{"a": 1},
+		"label":    concatLabel{"ok"},
+		"failure":  errors.New("boom"),
+		"duration": 90 * time.Minute,
+		"when":     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"name":     "<Ann>",
+		"markup":   miya.Safe("<br>"),
+		"text":     runtime.SafeString("<hr>"),
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"strings", `{{ "a" ~ "b" ~ "" }}`, "ab"},
+		{"integers", `{{ 1 ~ 2 }} {{ "#" ~ int64 }} {{ uint ~ "" }}`, "12 #-7 200"},
+		{"floats", `{{ "x" ~ float }} {{ "x" ~ 0.1 + 0.2 }} {{ "x" ~ float32 }} {{ "x" ~ (1 / 3) }}`, "x0.3 x0.3 x2.1 x0.333333333333333"},
+		{"whole and extreme floats", `{{ "x" ~ whole }} {{ "x" ~ big }} {{ "x" ~ small }} {{ "x" ~ 15 / 3 }}`, "x3 x1e+20 x1e-05 x5"},
+		{"booleans", `{{ "on: " ~ true }} {{ flag ~ "!" }} {{ (1 > 2) ~ "" }}`, "on: true false! false"},
+		{"none", `{{ "a" ~ none ~ "b" }} {{ "a" ~ nothing ~ "b" }} [{{ none ~ none }}]`, "ab ab []"},
+		{"undefined", `{{ "a" ~ nope ~ "b" }} {{ "a" ~ config.nope }} {{ "a" ~ list[9] }} [{{ nope ~ nope }}]`, "ab a a []"},
+		{"sequences and mappings", `{{ "v" ~ [1, 2] }} {{ "v" ~ list }} {{ "v" ~ strings }} {{ "v" ~ config }} {{ "v" ~ dict(b=1, a=2) }}`, "v[1 2] v[1 a] v[x y] vmap[a:1] vmap[b:1 a:2]"},
+		{"Go text", `{{ "@" ~ label }} {{ "!" ~ failure }} {{ "t=" ~ duration }} {{ "at " ~ when }}`, "@label:ok !boom t=1h30m at 2024-01-02 03:04:05 +0000 UTC"},
+		{"same as output", `{{ float }}|{{ "" ~ float }} {{ true }}|{{ "" ~ true }} {{ list }}|{{ "" ~ list }}`, "0.3|0.3 true|true [1 a]|[1 a]"},
+		{"filtered", `{{ "$" ~ (2.5|round(2)) }} {{ "$" ~ 19.99 * 1.1 }}`, "$2.50 $21.989"},
+		{"unescaped without autoescape", `{{ markup ~ name }} {{ name ~ text }}`, "<br><Ann> <Ann><hr>"},
+	}
+
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("autoescape", func(t *testing.T) {
+		escaping := miya.NewEnvironment(miya.WithAutoEscape(true))
+		for source, expected := range map[string]string{
+			`{{ markup ~ name }}`:                                          "<br>&lt;Ann&gt;",
+			`{{ name ~ text ~ nothing ~ float }}`:                          "&lt;Ann&gt;<hr>0.3",
+			`{{ "<p>"|safe ~ nope ~ true }}`:                               "<p>true",
+			`{{ name ~ name }}`:                                            "&lt;Ann&gt;&lt;Ann&gt;",
+			`{% set x = markup ~ name %}{{ x ~ x }}`:                       "<br>&lt;Ann&gt;<br>&lt;Ann&gt;",
+			`{% autoescape false %}{{ markup ~ name }}{% endautoescape %}`: "<br><Ann>",
+		} {
+			result, err := escaping.RenderString(source, miya.NewContextFrom(vars))
+			if err != nil || result != expected {
+				t.Errorf("%s: expected %q, got %q (%v)", source, expected, result, err)
+			}
+		}
+	})
+
+	t.Run("debug undefined", func(t *testing.T) {
+		debug := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedDebug))
+		result, err := debug.RenderString(`{{ "a" ~ nope }}`, nil)
+		if err != nil || !strings.Contains(result, "nope") {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("strict undefined", func(t *testing.T) {
+		strict := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		for _, source := range []string{"line one\n{{ 'a' ~ nope }}", "line one\n{{ nope ~ 'a' }}", "line one\n{{ 'a' ~ config.nope }}"} {
+			_, err := strict.RenderString(source, miya.NewContextFrom(vars))
+			var runtimeErr *runtime.RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Line != 2 || !strings.Contains(err.Error(), "nope") {
+				t.Errorf("%q: expected an undefined error at line 2, got %v", source, err)
+			}
+		}
+	})
+
+	t.Run("functions", func(t *testing.T) {
+		if _, err := env.RenderString(`{{ "a" ~ range }}`, nil); err == nil {
+			t.Error("expected an error concatenating a function")
+		}
+	})
+}
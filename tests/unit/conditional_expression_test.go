@@ -0,0 +1,79 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestConditionalWithoutElse(t *testing.T) {
+	render := func(t *testing.T, env *miya.Environment, source string, vars map[string]interface{}) (string, error) {
+		t.Helper()
+		tmpl, err := env.FromString(source)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		return tmpl.Render(miya.NewContextFrom(vars))
+	}
+
+	t.Run("Silent", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false))
+		source := `[{{ user.name if user }}]`
+		if got, err := render(t, env, source, nil); err != nil || got != "[]" {
+			t.Errorf("without user: got %q, %v; want %q", got, err, "[]")
+		}
+		user := map[string]interface{}{"name": "Ada"}
+		if got, err := render(t, env, source, map[string]interface{}{"user": user}); err != nil || got != "[Ada]" {
+			t.Errorf("with user: got %q, %v; want %q", got, err, "[Ada]")
+		}
+	})
+
+	t.Run("StrictErrorsOnlyWhenUsed", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedStrict), miya.WithAutoEscape(false))
+		tests := []struct {
+			source string
+			vars   map[string]interface{}
+			want   string
+		}{
+			{`{{ greeting if formal }}`, map[string]interface{}{"greeting": "Dear Ada", "formal": true}, "Dear Ada"},
+			{`{{ (greeting if formal)|default("Hi") }}`, map[string]interface{}{"greeting": "Dear Ada", "formal": false}, "Hi"},
+			{`{{ (greeting if formal)|d("Hi") }}`, map[string]interface{}{"formal": true}, "Hi"},
+			{`{{ (greeting if formal else salutation)|default("Hi") }}`, map[string]interface{}{"formal": false}, "Hi"},
+			{`{{ (greeting if formal) is defined }}`, map[string]interface{}{"formal": false}, "false"},
+			{`{% if formal %}{{ greeting if formal }}{% endif %}`, map[string]interface{}{"formal": false}, ""},
+		}
+		for _, tt := range tests {
+			if got, err := render(t, env, tt.source, tt.vars); err != nil || got != tt.want {
+				t.Errorf("%s: got %q, %v; want %q", tt.source, got, err, tt.want)
+			}
+		}
+
+		_, err := render(t, env, `{{ greeting if formal }}`, map[string]interface{}{"greeting": "Dear Ada", "formal": false})
+		if err == nil || !strings.Contains(err.Error(), "undefined variable: greeting if formal") {
+			t.Errorf("expected an undefined error for the missing else, got %v", err)
+		}
+		// The condition itself isn't rescued by default
+		if _, err := render(t, env, `{{ (greeting if formal)|default("Hi") }}`, nil); err == nil || !strings.Contains(err.Error(), "undefined variable: formal") {
+			t.Errorf("expected an undefined error for the condition, got %v", err)
+		}
+	})
+
+	t.Run("Associativity", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false))
+		source := `{{ "one" if n == 1 else "two" if n == 2 else "many" }}|{{ "small" if n < 3 if n > 0 }}`
+		for n, want := range map[int]string{1: "one|small", 2: "two|small", 5: "many|"} {
+			if got, err := render(t, env, source, map[string]interface{}{"n": n}); err != nil || got != want {
+				t.Errorf("n = %d: got %q, %v; want %q", n, got, err, want)
+			}
+		}
+	})
+
+	t.Run("FilteredComprehensions", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false))
+		source := `{{ [n * 10 for n in nums if n > 1]|join(",") }}|{{ {n: n for n in nums if n is odd}|length }}`
+		if got, err := render(t, env, source, map[string]interface{}{"nums": []int{1, 2, 3}}); err != nil || got != "20,30|2" {
+			t.Errorf("got %q, %v; want %q", got, err, "20,30|2")
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+// productPage is a page of results, like a pagination library returns
+type productPage struct {
+	items  []string
+	Number int
+}
+
+func (p *productPage) Len() int                { return len(p.items) }
+func (p *productPage) Index(i int) interface{} { return p.items[i] }
+
+// resultSet lists its rows without knowing their number in advance
+type resultSet struct {
+	rows []int
+}
+
+func (r resultSet) Iterate(yield func(item interface{}) bool) {
+	for _, row := range r.rows {
+		if !yield(row) {
+			return
+		}
+	}
+}
+
+// counter only reports a size
+type counter int
+
+func (c counter) Len() int { return int(c) }
+
+func TestCustomCollections(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Loop", `{% for p in page %}{{ loop.index }}/{{ loop.length }}:{{ p }} {% endfor %}`, "1/3:pear 2/3:apple 3/3:fig "},
+		{"LoopElse", `{% for p in empty_page %}{{ p }}{% else %}none{% endfor %}`, "none"},
+		{"IterableLoop", `{% for r in rows %}{{ r }}{% if not loop.last %},{% endif %}{% endfor %}`, "3,1,2"},
+		{"Length", `{{ page|length }} {{ rows|length }} {{ size|length }} {{ page|count }}`, "3 3 7 3"},
+		{"FirstLast", `{{ page|first }} {{ page|last }} {{ rows|first }} {{ rows|last }}`, "pear fig 3 2"},
+		{"Random", `{{ page|random in ["pear", "apple", "fig"] }}`, "true"},
+		{"SequenceFilters", `{{ page|sort|join(",") }} {{ rows|sum }} {{ rows|max }} {{ page|reverse|list }}`, "apple,fig,pear 6 3 [fig apple pear]"},
+		{"Truthiness", `{% if page %}yes{% endif %}{% if empty_page %}no{% endif %} {{ "y" if rows else "n" }} {{ "y" if size else "n" }} {{ "y" if zero else "n" }}`, "yes y y n"},
+		{"EmptyTest", `{{ page is empty }} {{ empty_page is empty }} {{ rows is empty }} {{ zero is empty }}`, "false true false true"},
+		{"TypeTests", `{{ page is iterable }} {{ rows is iterable }} {{ size is iterable }} {{ page is sequence }}`, "true true false true"},
+		{"Attributes", `{{ page.Number }}`, "2"},
+		{"Subscript", `{{ page[0] }} {{ page[-1] }} [{{ page[3] }}]`, "pear fig []"},
+		{"Comprehension", `{{ [r * 2 for r in rows] }}`, "[6 2 4]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("page", &productPage{items: []string{"pear", "apple", "fig"}, Number: 2})
+			ctx.Set("empty_page", &productPage{})
+			ctx.Set("rows", resultSet{rows: []int{3, 1, 2}})
+			ctx.Set("size", counter(7))
+			ctx.Set("zero", counter(0))
+			out, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/extensions"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestDebugTokens(t *testing.T) {
+	t.Run("PositionsAndTypes", func(t *testing.T) {
+		out, err := miya.NewEnvironment().DebugTokens(`Hi {{ name|upper }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`1:1      TEXT               "Hi "`, `1:7      IDENTIFIER         "name"`, `1:11     PIPE               "|"`, "EOF"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("missing %q in:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("AppliesEnvironmentSettings", func(t *testing.T) {
+		out, err := miya.NewEnvironment(miya.WithTrimBlocks(true)).DebugTokens("{% if x %}\nyes{% endif %}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, `TEXT               "yes"`) {
+			t.Errorf("trim blocks: got:\n%s", out)
+		}
+
+		env := miya.NewEnvironment()
+		env.SetDelimiters("<<", ">>", "<%", "%>")
+		if out, err = env.DebugTokens("<% if x %><< x >><% endif %>"); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, `1:1      BLOCK_START        "<%"`) || !strings.Contains(out, `VAR_START          "<<"`) {
+			t.Errorf("delimiters: got:\n%s", out)
+		}
+	})
+
+	t.Run("OneLinePerToken", func(t *testing.T) {
+		out, err := miya.NewEnvironment().DebugTokens("first\nsecond " + strings.Repeat("x", 500) + "{{ 1 }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if len(lines) != 5 || len(lines[0]) > 100 {
+			t.Errorf("got:\n%s", out)
+		}
+	})
+
+	t.Run("LexerError", func(t *testing.T) {
+		if _, err := miya.NewEnvironment().DebugTokens(`{{ "unclosed }}`); err == nil {
+			t.Error("expected a lexer error")
+		}
+	})
+}
+
+func TestDebugAST(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<title>{% block title %}Site{% endblock %}</title>`)
+	stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% block title %}{{ page.title|title }}{% endblock %}`)
+	stringLoader.AddTemplate("dynamic.html", `{% extends layout %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+
+	t.Run("ResolvesInheritance", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tmpl.DebugAST()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`TextNode 1:1 Content="<title>"`, `BlockNode`, `Name="title"`, `FilterNode`, `FilterName="title"`, `Attribute="title"`, `Name="page"`} {
+			if !strings.Contains(out, want) {
+				t.Errorf("missing %q in:\n%s", want, out)
+			}
+		}
+		if strings.Contains(out, "ExtendsNode") || strings.Contains(out, `"Site"`) {
+			t.Errorf("inheritance not resolved:\n%s", out)
+		}
+	})
+
+	t.Run("ExtensionNodes", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithExtraTags(true))
+		tmpl, err := env.FromString(`{% switch n %}{% case 1 %}one{% endswitch %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tmpl.DebugAST()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "SwitchNode 1:11") || !strings.Contains(out, "Cases[0]: CaseNode") {
+			t.Errorf("got:\n%s", out)
+		}
+	})
+
+	t.Run("UnresolvableParent", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("dynamic.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.DebugAST(); err == nil {
+			t.Error("expected an inheritance error")
+		}
+	})
+
+	t.Run("AddedExtension", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		for _, ext := range extensions.NewExtraTags() {
+			if err := env.AddExtension(ext); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tmpl, err := env.FromString(`{% unless a %}x{% endunless %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := tmpl.DebugAST(); err != nil || !strings.Contains(out, `UnaryOpNode 1:11 Operator="not"`) {
+			t.Errorf("got %v:\n%s", err, out)
+		}
+	})
+}
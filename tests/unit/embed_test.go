@@ -0,0 +1,159 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/tests/helpers"
+)
+
+// newEmbedEnv returns an environment with templates and the card.html and
+// layout.html templates embedded by the tests
+func newEmbedEnv(templates map[string]string) *miya.Environment {
+	all := map[string]string{
+		"card.html": `<div class="card"><h2>{% block title %}{{ title }}{% endblock %}</h2>` +
+			`<div>{% block body %}empty{% endblock %}</div></div>`,
+		"layout.html": `[{% block content %}{% endblock %}]`,
+	}
+	for name, source := range templates {
+		all[name] = source
+	}
+	return helpers.CreateLoaderEnvironment(all, miya.WithAutoEscape(false))
+}
+
+func TestEmbedTag(t *testing.T) {
+	render := func(t *testing.T, source string, vars map[string]interface{}) string {
+		t.Helper()
+		out, err := newEmbedEnv(map[string]string{"page.html": source}).RenderTemplate("page.html", miya.NewContextFrom(vars))
+		if err != nil {
+			t.Fatalf("render %q: %v", source, err)
+		}
+		return out
+	}
+
+	t.Run("OverridesBlocks", func(t *testing.T) {
+		source := `{% embed "card.html" with {"title": "Stats"} %}
+  {# the body #}
+  {% block body %}{{ visits }} visits{% endblock %}
+{% endembed %}`
+		want := `<div class="card"><h2>Stats</h2><div>12 visits</div></div>`
+		if out := render(t, source, map[string]interface{}{"visits": 12}); out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("KeepsOtherBlocks", func(t *testing.T) {
+		out := render(t, `{% embed "card.html" with title="T" %}{% endembed %}`, nil)
+		if want := `<div class="card"><h2>T</h2><div>empty</div></div>`; out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("Super", func(t *testing.T) {
+		out := render(t, `{% embed "card.html" %}{% block body %}<{{ super() }}>{% endblock %}{% endembed %}`, nil)
+		if !strings.Contains(out, "<div><empty></div>") {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		vars := map[string]interface{}{"title": "outer", "user": "ann"}
+		source := `{% embed "card.html" %}{% block body %}{{ user }}{% endblock %}{% endembed %}`
+		if out := render(t, source, vars); !strings.Contains(out, "<h2>outer</h2><div>ann</div>") {
+			t.Errorf("without with: got %q", out)
+		}
+		source = `{% embed "card.html" with title="inner" only %}{% block body %}[{{ user }}]{% endblock %}{% endembed %}`
+		if out := render(t, source, vars); !strings.Contains(out, "<h2>inner</h2><div>[]</div>") {
+			t.Errorf("only: got %q", out)
+		}
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		source := `{% for user in users %}{% embed "card.html" with title=user.name %}` +
+			`{% block body %}{{ loop.index }}: {{ user.role }}{% endblock %}{% endembed %}{% endfor %}`
+		users := []interface{}{
+			map[string]interface{}{"name": "Ann", "role": "admin"},
+			map[string]interface{}{"name": "Bob", "role": "editor"},
+		}
+		out := render(t, source, map[string]interface{}{"users": users})
+		want := `<div class="card"><h2>Ann</h2><div>1: admin</div></div>` +
+			`<div class="card"><h2>Bob</h2><div>2: editor</div></div>`
+		if out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		source := `{% embed "card.html" with title="outer" %}{% block body %}` +
+			`{% embed "card.html" with title="inner" %}{% block body %}{{ title }}{% endblock %}{% endembed %}` +
+			`{% endblock %}{% endembed %}`
+		want := `<div class="card"><h2>outer</h2><div>` +
+			`<div class="card"><h2>inner</h2><div>inner</div></div></div></div>`
+		if out := render(t, source, nil); out != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("InChildTemplate", func(t *testing.T) {
+		// The embed's body block doesn't override the page's own
+		source := `{% extends "layout.html" %}{% block content %}{% block body %}page{% endblock %}:` +
+			`{% embed "card.html" %}{% block body %}embed{% endblock %}{% endembed %}{% endblock %}`
+		if out := render(t, source, nil); out != `[page:<div class="card"><h2></h2><div>embed</div></div>]` {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("TemplateWithParent", func(t *testing.T) {
+		env := newEmbedEnv(map[string]string{
+			"panel.html": `{% extends "layout.html" %}{% block content %}<panel>{% block body %}panel{% endblock %}</panel>{% endblock %}`,
+			"page.html":  `{% embed "panel.html" %}{% block body %}{{ super() }} body{% endblock %}{% endembed %}`,
+		})
+		out, err := env.RenderTemplate("page.html", miya.NewContext())
+		if err != nil || out != `[<panel>panel body</panel>]` {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("IgnoreMissing", func(t *testing.T) {
+		if out := render(t, `a{% embed "nothing.html" ignore missing %}{% endembed %}b`, nil); out != "ab" {
+			t.Errorf("got %q", out)
+		}
+		env := newEmbedEnv(map[string]string{"page.html": `{% embed "nothing.html" %}{% endembed %}`})
+		if _, err := env.RenderTemplate("page.html", miya.NewContext()); err == nil || !strings.Contains(err.Error(), "nothing.html") {
+			t.Errorf("missing template: got %v", err)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		env := newEmbedEnv(map[string]string{"page.html": `{% embed "page.html" %}{% endembed %}`})
+		if _, err := env.RenderTemplate("page.html", miya.NewContext()); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("ParseErrors", func(t *testing.T) {
+		env := newEmbedEnv(nil)
+		tests := []struct {
+			source, want string
+		}{
+			{`{% embed "card.html" %}text{% endembed %}`, "only blocks may appear inside an embed"},
+			{`{% embed "card.html" %}{{ x }}{% endembed %}`, "only blocks may appear inside an embed"},
+			{`{% embed "card.html" %}{% block body %}x{% endblock %}`, "unclosed 'embed' tag"},
+			{`{% embed %}{% endembed %}`, "expected template name after 'embed'"},
+			{`{% embed "card.html" with a=1, 2 %}{% endembed %}`, "expected name=value after ',' in embed"},
+		}
+		for _, tt := range tests {
+			_, err := env.FromString(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.source, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("EmbedIsAVariableName", func(t *testing.T) {
+		if out := render(t, `{% set embed = "x" %}{{ embed }}`, nil); out != "x" {
+			t.Errorf("got %q", out)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestExpressionFilters(t *testing.T) {
+	users := []map[string]interface{}{
+		{"name": "ada", "age": 36, "active": true},
+		{"name": "bob", "age": 25, "active": true},
+		{"name": "cy", "age": 41, "active": false},
+	}
+	data := map[string]interface{}{"users": users, "min_age": 30}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ users|selectexpr("item.age > 30 and item.active")|mapexpr("item.name")|join(",") }}`, "ada"},
+		{`{{ users|rejectexpr("item.active")|mapexpr("item.name")|join(",") }}`, "cy"},
+		{`{{ users|selectexpr("item.age > min_age")|length }}`, "2"},
+		{`{{ users|mapexpr("index ~ '. ' ~ item.name|title")|join(" ") }}`, "1. Ada 2. Bob 3. Cy"},
+		{`{{ [1, 2, 3, 4]|selectexpr("item is even")|join(",") }}`, "2,4"},
+		{`{{ [1, 2, 3]|mapexpr("item * index")|join(",") }}`, "1,4,9"},
+		{`{{ absent|selectexpr("item")|length }}`, "0"},
+		{`{% set item = "kept" %}{{ [1]|mapexpr("item")|join }} {{ item }}`, "1 kept"},
+	}
+
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	for _, tt := range tests {
+		got, err := env.RenderString(tt.template, miya.NewContextFrom(data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.template, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionFilterErrors(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedStrict))
+	ctx := func() miya.Context {
+		return miya.NewContextFrom(map[string]interface{}{"items": []int{1, 2}})
+	}
+
+	tests := []struct {
+		template string
+		want     string
+		line     int
+	}{
+		{"<ul>\n\n{{ items|selectexpr(\"item >\") }}", `parser error in expression "item >"`, 3},
+		{"{{ items|mapexpr(\"item.name\") }}", `"item.name" for item 1`, 1},
+		{"{{ items|mapexpr(1) }}", "the expression must be a string", 1},
+		{"{{ items|rejectexpr }}", "requires one argument", 1},
+	}
+	for _, tt := range tests {
+		_, err := env.RenderString(tt.template, ctx())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected an error containing %q, got %v", tt.template, tt.want, err)
+			continue
+		}
+		var re *runtime.RuntimeError
+		if !errors.As(err, &re) || re.Type != runtime.ErrorTypeFilter || re.Line != tt.line {
+			t.Errorf("%q: expected a filter error at line %d, got %v", tt.template, tt.line, err)
+		}
+	}
+}
+
+func TestExpressionFilterOverride(t *testing.T) {
+	env := miya.NewEnvironment()
+	if err := env.AddFilter("mapexpr", func(value interface{}, args ...interface{}) (interface{}, error) {
+		return "custom", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := env.RenderString(`{{ [1]|mapexpr("item") }}`, miya.NewContext()); err != nil || got != "custom" {
+		t.Errorf("got %q, %v; want the registered filter's output", got, err)
+	}
+}
+
+func BenchmarkSelectExpr(b *testing.B) {
+	env := miya.NewEnvironment()
+	tmpl, err := env.FromString(`{{ items|selectexpr("item % 3 == 0 and item > 10")|length }}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	items := make([]interface{}, 100000)
+	for i := range items {
+		items[i] = i
+	}
+	ctx := miya.NewContextFrom(map[string]interface{}{"items": items})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpl.Render(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
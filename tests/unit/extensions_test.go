@@ -501,6 +501,24 @@ func TestEnvironmentMixedExtensionsAndStandardTags(t *testing.T) {
 	}
 }
 
+func TestEnvironmentExtensionTagsInsideStandardBlocks(t *testing.T) {
+	env := miya.NewEnvironment()
+	if err := env.AddExtension(NewSimpleTestExtension()); err != nil {
+		t.Fatalf("Failed to register extension: %v", err)
+	}
+
+	template := `{% for name in ["Ann", "Bo"] %}{% if loop.first %}{% hello %}{% else %} {% greet name %}{% endif %}{% endfor %}`
+	result, err := env.RenderString(template, miya.NewContext())
+	if err != nil {
+		t.Fatalf("Failed to render nested extension tags: %v", err)
+	}
+
+	expected := "Hello, World! Hello, Bo!"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
 func TestExtensionConfiguration(t *testing.T) {
 	// Test extension configuration
 	ext := extensions.NewHighlightExtension()
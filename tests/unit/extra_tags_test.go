@@ -0,0 +1,161 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/extensions"
+)
+
+func TestSwitchTag(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithExtraTags(true), miya.WithAutoEscape(false))
+	render := func(t *testing.T, source string, vars map[string]interface{}) string {
+		t.Helper()
+		tmpl, err := env.FromString(source)
+		if err != nil {
+			t.Fatalf("parse %q: %v", source, err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(vars))
+		if err != nil {
+			t.Fatalf("render %q: %v", source, err)
+		}
+		return out
+	}
+	status := `{% switch order.status %}
+	{% case "shipped" %}On its way
+	{% case "pending", "processing" %}Being prepared
+	{% default %}Unknown
+{% endswitch %}`
+
+	t.Run("StringSubject", func(t *testing.T) {
+		for value, want := range map[string]string{
+			"shipped":    "On its way",
+			"pending":    "Being prepared",
+			"processing": "Being prepared",
+			"lost":       "Unknown",
+		} {
+			out := render(t, status, map[string]interface{}{"order": map[string]interface{}{"status": value}})
+			if strings.TrimSpace(out) != want {
+				t.Errorf("status %q: got %q, want %q", value, out, want)
+			}
+		}
+	})
+
+	t.Run("IntSubject", func(t *testing.T) {
+		source := `{% for n in [1, 2, 3, 4.0] %}{% switch n %}{% case 1 %}one{% case 2, 3 %}few{% case 4 %}four{% endswitch %};{% endfor %}`
+		if out := render(t, source, nil); out != "one;few;few;four;" {
+			t.Errorf("got %q", out)
+		}
+		source = `{% switch n %}{% case 1 %}one{% endswitch %}`
+		if out := render(t, source, map[string]interface{}{"n": int64(2)}); out != "" {
+			t.Errorf("no matching case and no default: got %q", out)
+		}
+	})
+
+	t.Run("UndefinedSubject", func(t *testing.T) {
+		source := `{% switch nothing %}{% case "" %}empty{% case none %}none{% default %}undefined{% endswitch %}`
+		if out := render(t, source, nil); out != "undefined" {
+			t.Errorf("got %q", out)
+		}
+
+		strict := miya.NewEnvironment(miya.WithExtraTags(true), miya.WithStrictUndefined(true))
+		tmpl, err := strict.FromString(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.Render(miya.NewContext()); err == nil || !strings.Contains(err.Error(), "nothing") {
+			t.Errorf("strict undefined: got %v", err)
+		}
+	})
+
+	t.Run("SubjectEvaluatedOnce", func(t *testing.T) {
+		calls := 0
+		next := func() string {
+			calls++
+			return "c"
+		}
+		source := `{% switch next() %}{% case "a" %}A{% case "b" %}B{% case "c" %}C{% endswitch %}`
+		if out := render(t, source, map[string]interface{}{"next": next}); out != "C" || calls != 1 {
+			t.Errorf("got %q after %d calls", out, calls)
+		}
+	})
+
+	t.Run("Nesting", func(t *testing.T) {
+		source := `{% for kind, size in [["a", 1], ["b", 2], ["c", 3]] %}` +
+			`{% if true %}{% switch kind %}` +
+			`{% case "a" %}{% switch size %}{% case 1 %}a1{% default %}a?{% endswitch %}` +
+			`{% case "b" %}{% if size > 1 %}big b{% endif %}{% set seen = kind %}` +
+			`{% default %}{% for i in range(size) %}{% if i == 1 %}{% break %}{% endif %}{{ kind }}{{ i }}{% endfor %}` +
+			`{% endswitch %}{% endif %};{% endfor %}`
+		if out := render(t, source, nil); out != "a1;big b;c0;" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("ParseErrors", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{% switch x %}{% case 1 %}a{% default %}b{% default %}c{% endswitch %}`, "duplicate 'default'"},
+			{`{% case 1 %}`, "'case' outside a switch block"},
+			{`{% if x %}{% default %}{% endif %}`, "'default' outside a switch block"},
+			{`{% switch x %}{% case 1 %}{% endswitch %}{% endswitch %}`, "'endswitch' outside a switch block"},
+			{`{% switch x %}text{% case 1 %}{% endswitch %}`, "expected 'case' or 'default'"},
+			{`{% switch x %}{% case 1 %}a`, "unclosed 'switch' tag"},
+			{`{% switch x %}{% case %}a{% endswitch %}`, "expected a value after 'case'"},
+			{`{% switch %}{% endswitch %}`, "expected a value after 'switch'"},
+		}
+		for _, tt := range tests {
+			_, err := env.FromString(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.source, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		if _, err := miya.NewEnvironment().FromString(`{% switch x %}{% endswitch %}`); err == nil {
+			t.Error("switch parsed without WithExtraTags")
+		}
+		if _, err := miya.NewEnvironment(miya.WithExtraTags(true), miya.WithExtraTags(false)).FromString(`{% switch x %}{% endswitch %}`); err == nil {
+			t.Error("switch parsed after WithExtraTags(false)")
+		}
+	})
+
+	t.Run("AddExtension", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		for _, ext := range extensions.NewExtraTags() {
+			if err := env.AddExtension(ext); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tmpl, err := env.FromString(`{% switch 2 %}{% case 1 %}one{% case 2 %}two{% endswitch %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := tmpl.Render(miya.NewContext()); err != nil || out != "two" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+}
+
+func TestUnlessTag(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithExtraTags(true))
+	source := `{% unless user.verified %}Please verify{% else %}Welcome{% endunless %}` +
+		`{% unless user.admin %}!{% endunless %}`
+	tmpl, err := env.FromString(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for verified, want := range map[bool]string{false: "Please verify!", true: "Welcome!"} {
+		out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"user": map[string]interface{}{"verified": verified}}))
+		if err != nil || out != want {
+			t.Errorf("verified=%v: got %q, %v, want %q", verified, out, err, want)
+		}
+	}
+
+	if _, err := env.FromString(`{% unless x %}a`); err == nil || !strings.Contains(err.Error(), "unclosed 'unless' tag") {
+		t.Errorf("unclosed unless: got %v", err)
+	}
+}
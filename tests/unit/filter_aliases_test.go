@@ -0,0 +1,118 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestFilterAndTestAliases(t *testing.T) {
+	t.Run("Option", func(t *testing.T) {
+		env := miya.NewEnvironment(
+			miya.WithAutoEscape(false),
+			miya.WithFilterAliases(map[string]string{"upcase": "upper", "len": "length"}),
+			miya.WithTestAliases(map[string]string{"divisible": "divisibleby"}),
+		)
+
+		got, err := env.RenderString(`{{ "abc"|upcase }} {{ [1, 2]|len }} {{ 9 is divisible(3) }}`, miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if got != "ABC 2 true" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("TargetRegisteredLater", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.AddFilterAlias("cut", "remove"); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.AddFilter("remove", func(v interface{}, args ...interface{}) (interface{}, error) {
+			return strings.ReplaceAll(fmt.Sprint(v), fmt.Sprint(args[0]), ""), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := env.RenderString(`{{ "a b c"|cut(" ") }}`, miya.NewContext())
+		if err != nil || got != "abc" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("ReplacesExistingName", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.AddFilterAlias("date", "upper"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := env.RenderString(`{{ "x"|date }}`, miya.NewContext())
+		if err != nil || got != "X" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("ErrorsNameBothFilters", func(t *testing.T) {
+		boom := errors.New("bad layout")
+		env := miya.NewEnvironment()
+		if err := env.AddFilter("datetimeformat", func(v interface{}, args ...interface{}) (interface{}, error) {
+			return nil, boom
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.AddFilterAlias("date", "datetimeformat"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := env.RenderString("line one\n  {{ now|date }}", miya.NewContext())
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		want := "filter 'date' (alias of 'datetimeformat') failed at line 2, column 10: bad layout"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want it to contain %q", err.Error(), want)
+		}
+		if !errors.Is(err, boom) {
+			t.Error("alias error should wrap the target's error")
+		}
+		var aliasErr *runtime.AliasError
+		if !errors.As(err, &aliasErr) || aliasErr.Alias != "date" || aliasErr.Target != "datetimeformat" {
+			t.Errorf("expected *runtime.AliasError, got %T", err)
+		}
+	})
+
+	t.Run("TestErrorsNameBothTests", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.AddTestAlias("equal", "eq"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := env.RenderString(`{{ 1 is equal }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "test 'equal' (alias of 'eq') failed at line 1") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("MissingTarget", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.AddFilterAlias("length_is", "nope"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := env.RenderString(`{{ x|length_is }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "unknown filter: nope") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("InvalidAlias", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.AddFilterAlias("upper", "upper"); err == nil {
+			t.Error("expected self-alias to be rejected")
+		}
+		if err := env.AddTestAlias("", "odd"); err == nil {
+			t.Error("expected empty alias to be rejected")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestFiltersOnEdgeInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"NoneAsString", `[{{ nothing|upper }}][{{ nothing|trim }}]`, "[][]"},
+		{"NoneAsSequence", `[{{ nothing|join(",") }}][{{ nothing|length }}]`, "[][0]"},
+		{"UndefinedAsString", `[{{ nope|upper }}][{{ nope|replace("a", "b") }}]`, "[][]"},
+		{"UndefinedAsSequence", `[{{ nope|length }}][{{ nope|join(",") }}][{{ nope|sort|list|length }}]`, "[0][][0]"},
+		{"UndefinedAsMapping", `[{% for k, v in nope|items %}{{ k }}{% endfor %}][{{ nope|dictsort|length }}]`, "[][0]"},
+		{"SafeStaysSafe", `{{ html|safe|upper }} {{ html|safe|trim|replace("b", "i") }}`, " <B>X</B> <i>x</i>"},
+		{"SafeUnwrapped", `{{ html|safe|length }} {{ html|safe|striptags }}`, "9  x"},
+		{"PlainStillEscaped", `{{ html|upper }}`, " &lt;B&gt;X&lt;/B&gt;"},
+		{"EscapeKeepsSafe", `{{ html|safe|e }}`, " <b>x</b>"},
+	}
+
+	env := miya.NewEnvironment(miya.WithAutoEscape(true))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("nothing", nil)
+			ctx.Set("html", " <b>x</b>")
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("StrictUndefined", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		if _, err := env.RenderString(`{{ nope|upper }}`, miya.NewContext()); err == nil {
+			t.Error("expected an undefined error")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func reportFinalizer(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return "N/A"
+	case float64:
+		return fmt.Sprintf("%.2f", v)
+	}
+	return value
+}
+
+func TestFinalizer(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("row.html", `<td>{{ item.price }}</td><td>{{ item.discount }}</td>`)
+	stringLoader.AddTemplate("macros.html", `{% macro cell(v) %}[{{ v }}]{% endmacro %}`)
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Values", `{{ price }} {{ missing_value }} {{ name }} {{ count }}`, "3.50 N/A Widget 3"},
+		{"Loop", `{% for item in items %}{{ item.price }}/{{ item.discount }};{% endfor %}`, "1.00/N/A;2.25/0.10;"},
+		{"Macro", `{% macro cell(v) %}<{{ v }}>{% endmacro %}{{ cell(price) }}{{ cell(none) }}`, "<3.50><N/A>"},
+		{"ImportedMacro", `{% from "macros.html" import cell %}{{ cell(price) }}`, "[3.50]"},
+		{"Include", `{% for item in items %}{% include "row.html" %}{% endfor %}`, "<td>1.00</td><td>N/A</td><td>2.25</td><td>0.10</td>"},
+		{"FilterBlock", `{% filter upper %}{{ none }}{% endfilter %}`, "N/A"},
+		{"WholeExpression", `{{ price * 2 }} {{ [none, 1.5]|length }}`, "7.00 2"},
+		{"NotIntermediate", `{% set total = price + 1.25 %}{{ total if total > 4 else "low" }}`, "4.75"},
+		{"Undefined", `[{{ undefined_name }}]`, "[]"},
+	}
+
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false), miya.WithFinalizer(reportFinalizer))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{
+				"price":         3.5,
+				"missing_value": nil,
+				"name":          "Widget",
+				"count":         3,
+				"items": []interface{}{
+					map[string]interface{}{"price": 1.0, "discount": nil},
+					map[string]interface{}{"price": 2.25, "discount": 0.1},
+				},
+			})
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("CalledOncePerOutput", func(t *testing.T) {
+		var seen []interface{}
+		env := miya.NewEnvironment(miya.WithFinalizer(func(value interface{}) interface{} {
+			seen = append(seen, value)
+			return value
+		}))
+		if _, err := env.RenderString(`{{ 1 + 2 * 3 }}{{ "a"|upper|lower }}`, miya.NewContext()); err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != 2 || seen[1] != "a" {
+			t.Errorf("finalizer saw %v, want the two output values", seen)
+		}
+	})
+
+	t.Run("BeforeEscaping", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithFinalizer(func(value interface{}) interface{} {
+			return fmt.Sprintf("<%v>", value)
+		}))
+		out, err := env.RenderString(`{{ "a&b" }}`, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "&lt;a&amp;b&gt;" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("StrictUndefined", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithStrictUndefined(true), miya.WithFinalizer(reportFinalizer))
+		_, err := env.RenderString(`{{ undefined_name }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "undefined_name") {
+			t.Errorf("expected an undefined error, got %v", err)
+		}
+	})
+
+	t.Run("Overlay", func(t *testing.T) {
+		overlay := env.Overlay(miya.WithFinalizer(nil))
+		out, err := overlay.RenderString(`{{ 1.5 }}`, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "1.5" {
+			t.Errorf("got %q, want the overlay to drop the finalizer", out)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package miya_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/tests/helpers"
+)
+
+// newFragmentCacheEnv returns an environment whose tick() global counts how
+// often cache block bodies are evaluated
+func newFragmentCacheEnv(templates map[string]string) (*miya.Environment, *int) {
+	env := helpers.CreateLoaderEnvironment(templates)
+
+	ticks := 0
+	env.AddGlobal("tick", func() int {
+		ticks++
+		return ticks
+	})
+	return env, &ticks
+}
+
+func renderTimes(t *testing.T, env *miya.Environment, name string, ctx miya.Context, times int) []string {
+	t.Helper()
+	var outputs []string
+	for i := 0; i < times; i++ {
+		out, err := env.RenderTemplate(name, ctx)
+		if err != nil {
+			t.Fatalf("render %s: %v", name, err)
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs
+}
+
+func TestFragmentCache(t *testing.T) {
+	t.Run("WithoutStoreRendersUncached", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{"page.html": `{% cache "k" timeout=60 %}{{ tick() }}{% endcache %}`})
+		got := renderTimes(t, env, "page.html", miya.NewContext(), 2)
+		if got[0] != "1" || got[1] != "2" {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("CachesBody", func(t *testing.T) {
+		env, ticks := newFragmentCacheEnv(map[string]string{"page.html": `[{% cache "k" %}{{ tick() }}{% endcache %}]{{ tick() }}`})
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+		got := renderTimes(t, env, "page.html", miya.NewContext(), 2)
+		if got[0] != "[1]2" || got[1] != "[1]3" || *ticks != 3 {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("KeysIncludeLoopVariables", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{
+			"page.html": `{% for p in items %}{% cache ["item", p] %}{{ p }}{{ tick() }} {% endcache %}{% endfor %}`,
+		})
+		store := miya.NewMemoryFragmentCache(0)
+		env.SetFragmentCache(store)
+
+		ctx := miya.NewContext()
+		ctx.Set("items", []interface{}{"a", "b"})
+		renderTimes(t, env, "page.html", ctx, 1)
+		ctx.Set("items", []interface{}{"b", "c"})
+		got := renderTimes(t, env, "page.html", ctx, 1)
+		if got[0] != "b2 c3 " {
+			t.Errorf("got %q", got[0])
+		}
+		if store.Len() != 3 {
+			t.Errorf("stored %d fragments, want 3", store.Len())
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{
+			"page.html": `{% cache ["outer", v] %}<{{ v }}{% cache "inner" %}{{ tick() }}{% endcache %}>{% endcache %}`,
+		})
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+
+		ctx := miya.NewContext()
+		ctx.Set("v", "x")
+		first := renderTimes(t, env, "page.html", ctx, 2)
+		ctx.Set("v", "y")
+		second := renderTimes(t, env, "page.html", ctx, 1)
+		// The outer miss for "y" reuses the inner fragment
+		if first[0] != "<x1>" || first[1] != "<x1>" || second[0] != "<y1>" {
+			t.Errorf("got %v %v", first, second)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		for _, timeout := range []string{`0.03`, `"30ms"`, `"0.03"`} {
+			env, _ := newFragmentCacheEnv(map[string]string{"page.html": `{% cache "k", timeout=` + timeout + ` %}{{ tick() }}{% endcache %}`})
+			env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+
+			got := renderTimes(t, env, "page.html", miya.NewContext(), 2)
+			time.Sleep(50 * time.Millisecond)
+			got = append(got, renderTimes(t, env, "page.html", miya.NewContext(), 1)...)
+			if strings.Join(got, ",") != "1,1,2" {
+				t.Errorf("timeout=%s: got %v", timeout, got)
+			}
+		}
+	})
+
+	t.Run("InvalidTimeout", func(t *testing.T) {
+		for _, timeout := range []string{`"soon"`, `-1`, `[1]`} {
+			env, _ := newFragmentCacheEnv(map[string]string{"page.html": `{% cache "k" timeout=` + timeout + ` %}x{% endcache %}`})
+			env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+			if _, err := env.RenderTemplate("page.html", miya.NewContext()); err == nil || !strings.Contains(err.Error(), "cache timeout") {
+				t.Errorf("timeout=%s: unexpected error %v", timeout, err)
+			}
+		}
+	})
+
+	t.Run("ScopedByTemplateAndPosition", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{
+			"a.html": `{% cache "k" %}a{{ tick() }}{% endcache %}{% cache "k" %}b{{ tick() }}{% endcache %}`,
+			"b.html": `{% cache "k" %}c{{ tick() }}{% endcache %}`,
+		})
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+
+		a := renderTimes(t, env, "a.html", miya.NewContext(), 2)
+		b := renderTimes(t, env, "b.html", miya.NewContext(), 1)
+		if a[0] != "a1b2" || a[1] != "a1b2" || b[0] != "c3" {
+			t.Errorf("got %v %v", a, b)
+		}
+	})
+
+	t.Run("InsideBlocksAndIncludes", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{
+			"base.html": `<{% block content %}{% endblock %}>`,
+			"page.html": `{% extends "base.html" %}{% block content %}{% cache "page" %}{{ tick() }}{% include "part.html" %}{% endcache %}{% endblock %}`,
+			"part.html": `{% cache "part" %}/{{ tick() }}{% endcache %}`,
+		})
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+
+		got := renderTimes(t, env, "page.html", miya.NewContext(), 2)
+		if got[0] != "<1/2>" || got[1] != "<1/2>" {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{
+			"page.html": `{% for id in ids %}{% cache ["product", id] %}{{ tick() }} {% endcache %}{% endfor %}`,
+		})
+		store := miya.NewMemoryFragmentCache(0)
+		env.SetFragmentCache(store)
+
+		ctx := miya.NewContext()
+		ctx.Set("ids", []interface{}{1, 2})
+		renderTimes(t, env, "page.html", ctx, 1)
+		store.DeletePrefix("product:2|")
+		got := renderTimes(t, env, "page.html", ctx, 1)
+		if got[0] != "1 3 " {
+			t.Errorf("got %q", got[0])
+		}
+	})
+
+	t.Run("OverlayUsesParentStore", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(map[string]string{"page.html": `{% cache "k" %}{{ tick() }}{% endcache %}`})
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+		overlay := env.Overlay()
+
+		renderTimes(t, env, "page.html", miya.NewContext(), 1)
+		if got := renderTimes(t, overlay, "page.html", miya.NewContext(), 1); got[0] != "1" {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("CacheStaysAVariableName", func(t *testing.T) {
+		env, _ := newFragmentCacheEnv(nil)
+		ctx := miya.NewContext()
+		ctx.Set("cache", "value")
+		if out, err := env.RenderString(`{{ cache }}`, ctx); err != nil || out != "value" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+}
+
+// Environments sharing a loader that caches its ASTs scope their cache blocks
+// without writing to the shared trees
+func TestFragmentCacheSharedLoader(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(`{% cache "k" %}{{ n }}{% endcache %}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsLoader := loader.NewFileSystemLoader([]string{dir}, loader.NewDirectTemplateParser())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		env := miya.NewEnvironment(miya.WithLoader(fsLoader))
+		env.SetFragmentCache(miya.NewMemoryFragmentCache(0))
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := env.RenderTemplate("page.html", miya.NewContextFrom(map[string]interface{}{"n": i})); err != nil {
+					t.Errorf("render failed: %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	ast, err := fsLoader.LoadTemplate("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.Walk(ast, func(node parser.Node) bool {
+		if cache, ok := node.(*parser.CacheNode); ok && cache.Scope != "" {
+			t.Errorf("expected the loader's cache block to stay unscoped, got %q", cache.Scope)
+		}
+		return true
+	})
+}
+
+func TestMemoryFragmentCache(t *testing.T) {
+	store := miya.NewMemoryFragmentCache(2)
+	store.Set("a", "1", 0)
+	store.Set("b", "2", 0)
+	store.Get("a") // a is now more recently used than b
+	store.Set("c", "3", 0)
+
+	if _, ok := store.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok := store.Get("a"); !ok || v != "1" {
+		t.Errorf("a = %q, %v", v, ok)
+	}
+
+	store.Set("short", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := store.Get("short"); ok {
+		t.Error("expired entry returned")
+	}
+
+	store.Clear()
+	if store.Len() != 0 {
+		t.Errorf("Len after Clear = %d", store.Len())
+	}
+}
@@ -0,0 +1,130 @@
+package miya_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestFromFile(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<h1>{% block title %}Base{% endblock %}</h1>{% include "footer.html" %}`)
+	stringLoader.AddTemplate("footer.html", `<footer>{{ site }}</footer>`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(true))
+	vars := miya.NewContextFrom(map[string]interface{}{"site": "Acme", "name": "<Ann>"})
+
+	dir := t.TempDir()
+	write := func(name, source string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("extends a loader template", func(t *testing.T) {
+		path := write("child.html", `{% extends "base.html" %}{% block title %}{{ super() }} / {{ name }}{% endblock %}`)
+		tmpl, err := env.FromFile(path)
+		if err != nil {
+			t.Fatalf("FromFile: %v", err)
+		}
+		if tmpl.Name() != filepath.ToSlash(path) {
+			t.Errorf("name: got %q", tmpl.Name())
+		}
+		result, err := tmpl.Render(vars)
+		expected := `<h1>Base / &lt;Ann&gt;</h1><footer>Acme</footer>`
+		if err != nil || result != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, result, err)
+		}
+	})
+
+	t.Run("autoescape by extension", func(t *testing.T) {
+		tmpl, err := env.FromFile(write("note.txt", `Hi {{ name }}`))
+		if err != nil {
+			t.Fatalf("FromFile: %v", err)
+		}
+		if result, err := tmpl.Render(vars); err != nil || result != "Hi <Ann>" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("cached until the file changes", func(t *testing.T) {
+		path := write("cached.html", `one`)
+		first, err := env.FromFile(path)
+		if err != nil {
+			t.Fatalf("FromFile: %v", err)
+		}
+		if again, _ := env.FromFile(filepath.Join(dir, ".", "cached.html")); again != first {
+			t.Error("expected the cached template")
+		}
+
+		write("cached.html", `two!`)
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+		changed, err := env.FromFile(path)
+		if err != nil {
+			t.Fatalf("FromFile: %v", err)
+		}
+		if result, _ := changed.Render(nil); changed == first || result != "two!" {
+			t.Errorf("expected the changed file to be compiled again, got %q", result)
+		}
+
+		overlay := env.Overlay(miya.WithAutoEscape(false))
+		if tmpl, err := overlay.FromFile(path); err != nil {
+			t.Errorf("overlay: %v", err)
+		} else if result, _ := tmpl.Render(nil); result != "two!" {
+			t.Errorf("overlay: got %q", result)
+		}
+	})
+
+	t.Run("normalizes the source", func(t *testing.T) {
+		tmpl, err := env.FromFile(write("bom.txt", "\ufeffa\r\nb\rc"))
+		if err != nil {
+			t.Fatalf("FromFile: %v", err)
+		}
+		if result, _ := tmpl.Render(nil); result != "a\nb\nc" {
+			t.Errorf("got %q", result)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := env.FromFile(filepath.Join(dir, "missing.html"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("missing file: got %v", err)
+		}
+		path := write("broken.html", `{% if %}`)
+		if _, err := env.FromFile(path); err == nil || !strings.Contains(err.Error(), filepath.ToSlash(path)) {
+			t.Errorf("syntax error: got %v, want the path in it", err)
+		}
+	})
+}
+
+func TestFromReader(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `[{% block body %}{% endblock %}]`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(true))
+
+	tmpl, err := env.FromReader("page.html", strings.NewReader("\ufeff{% extends \"base.html\" %}\r\n{% block body %}{{ x }}\r\n{% endblock %}"))
+	if err != nil {
+		t.Fatalf("FromReader: %v", err)
+	}
+	result, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"x": "<b>"}))
+	if err != nil || result != "[&lt;b&gt;\n]" {
+		t.Errorf("got %q, %v", result, err)
+	}
+
+	if _, err := env.FromReader("bad.html", iotest.ErrReader(errors.New("disk on fire"))); err == nil || !strings.Contains(err.Error(), "bad.html") {
+		t.Errorf("read error: got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestFromStringWithOptions(t *testing.T) {
+	env := miya.NewEnvironment()
+	const source = "Hi {{ name }}, your order {{ order }} shipped."
+	vars := map[string]interface{}{"name": "Tom & Jerry", "order": "<#42>"}
+
+	tests := []struct {
+		name     string
+		opts     []miya.TemplateOption
+		expected string
+	}{
+		{"default", nil, "Hi Tom &amp; Jerry, your order &lt;#42&gt; shipped."},
+		{"autoescape off", []miya.TemplateOption{miya.WithTemplateAutoEscape(false)}, "Hi Tom & Jerry, your order <#42> shipped."},
+		{"autoescape on", []miya.TemplateOption{miya.WithTemplateAutoEscape(true)}, "Hi Tom &amp; Jerry, your order &lt;#42&gt; shipped."},
+		{"text name", []miya.TemplateOption{miya.WithTemplateName("shipped.txt")}, "Hi Tom & Jerry, your order <#42> shipped."},
+		{"name and autoescape", []miya.TemplateOption{miya.WithTemplateName("shipped.txt"), miya.WithTemplateAutoEscape(true)}, "Hi Tom &amp; Jerry, your order &lt;#42&gt; shipped."},
+		{"escape context", []miya.TemplateOption{miya.WithTemplateEscapeContext(miya.EscapeContextURL)}, "Hi Tom+%26+Jerry, your order %3C%2342%3E shipped."},
+	}
+
+	// Each template is compiled from the same source, so the cache must keep
+	// them apart
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := env.FromStringWithOptions(source, tt.opts...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			result, err := tmpl.Render(miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("render options win", func(t *testing.T) {
+		tmpl, err := env.FromStringWithOptions(source, miya.WithTemplateAutoEscape(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := tmpl.RenderWith(miya.NewContextFrom(vars), miya.RenderAutoescape(true))
+		if err != nil || !strings.Contains(result, "Tom &amp; Jerry") {
+			t.Errorf("Expected an escaped render, got %q, %v", result, err)
+		}
+	})
+
+	t.Run("name in errors", func(t *testing.T) {
+		_, err := env.FromStringWithOptions("{{ name + }}", miya.WithTemplateName("emails/welcome.txt"))
+		if err == nil || !strings.Contains(err.Error(), "emails/welcome.txt") {
+			t.Errorf("Expected the template name in the error, got %v", err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		overlay := env.Overlay()
+		tmpl, err := overlay.FromStringWithOptions(source, miya.WithTemplateAutoEscape(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := tmpl.Render(miya.NewContextFrom(vars))
+		if err != nil || result != "Hi Tom & Jerry, your order <#42> shipped." {
+			t.Errorf("Expected an unescaped render, got %q, %v", result, err)
+		}
+	})
+}
+
+func TestAutoescapePragma(t *testing.T) {
+	vars := map[string]interface{}{"name": "Tom & Jerry"}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"off", "{# miya: autoescape=false #}\nHi {{ name }}", "\nHi Tom & Jerry"},
+		{"on", "{# miya: autoescape=true #}\nHi {{ name }}", "\nHi Tom &amp; Jerry"},
+		{"whitespace control", "{#- miya: autoescape=false -#}\nHi {{ name }}", "Hi Tom & Jerry"},
+		{"not on the first line", "Hi {{ name }}\n{# miya: autoescape=false #}", "Hi Tom &amp; Jerry\n"},
+		{"other comments", "{# autoescape=false #}Hi {{ name }}", "Hi Tom &amp; Jerry"},
+	}
+
+	env := miya.NewEnvironment()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("options win", func(t *testing.T) {
+		tmpl, err := env.FromStringWithOptions("{# miya: autoescape=false #}{{ name }}", miya.WithTemplateAutoEscape(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := tmpl.Render(miya.NewContextFrom(vars)); err != nil || result != "Tom &amp; Jerry" {
+			t.Errorf("Expected %q, got %q, %v", "Tom &amp; Jerry", result, err)
+		}
+	})
+
+	t.Run("loaded templates", func(t *testing.T) {
+		stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		stringLoader.AddTemplate("receipt", "{# miya: autoescape=false #}{{ name }}")
+		env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+		result, err := env.RenderTemplate("receipt", miya.NewContextFrom(vars))
+		if err != nil || result != "Tom & Jerry" {
+			t.Errorf("Expected %q, got %q, %v", "Tom & Jerry", result, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, source := range []string{"{# miya: autoescape=no #}", "{# miya: autoscape=false #}"} {
+			if _, err := env.FromString(source); err == nil || !strings.Contains(err.Error(), "miya pragma") {
+				t.Errorf("%s: expected a pragma error, got %v", source, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestFromJSONTraversal(t *testing.T) {
+	env := miya.NewEnvironment()
+	ctx := miya.NewContext()
+	ctx.Set("payload", `{"order": {"id": 9007199254740993, "total": 12.5, "lines": [
+		{"sku": "B2", "qty": 10}, {"sku": "A1", "qty": 9}, {"sku": "C3", "qty": 0}]},
+		"totals": {"net": 100, "tax": 21, "fee": 3}}`)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"large integer", `{% set data = payload|fromjson %}{{ data.order.id }}`, "9007199254740993"},
+		{"item access", `{% set data = payload|fromjson %}{{ data["order"]["lines"][1].sku }}`, "A1"},
+		{"loop", `{% set data = payload|fromjson %}{% for line in data.order.lines %}{{ line.sku }}:{{ line.qty }} {% endfor %}`, "B2:10 A1:9 C3:0 "},
+		{"comparison", `{% set data = payload|fromjson %}{% for line in data.order.lines if line.qty > 5 %}{{ line.sku }}{% endfor %}`, "B2A1"},
+		{"equality", `{% set data = payload|fromjson %}{{ data.order.lines[0].qty == 10 }} {{ data.order.total == 12.5 }}`, "true true"},
+		{"truthiness", `{% set data = payload|fromjson %}{% for line in data.order.lines %}{{ "y" if line.qty else "n" }}{% endfor %}`, "yyn"},
+		{"arithmetic", `{% set data = payload|fromjson %}{{ data.order.lines[0].qty * 2 + data.totals.tax }}`, "41"},
+		{"items", `{% set data = payload|fromjson %}{% for key, value in data.totals.items() if value > 50 %}{{ key }}={{ value }}{% endfor %}`, "net=100"},
+		{"keys and values", `{% set data = payload|fromjson %}{{ data.totals.keys()|sort|join(",") }} {{ data.totals.values()|sort|join(",") }}`, "fee,net,tax 3,21,100"},
+		{"dictsort by value", `{% set data = payload|fromjson %}{% for key, value in data.totals|dictsort(false, "value") %}{{ key }} {% endfor %}`, "fee tax net "},
+		{"sum", `{% set data = payload|fromjson %}{{ data.order.lines|sum(0, "qty") }}`, "19"},
+		{"sort by attribute", `{% set data = payload|fromjson %}{% for line in data.order.lines|sort(false, false, "qty") %}{{ line.sku }}{% endfor %}`, "C3A1B2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestToJSONFromJSONRoundTrip(t *testing.T) {
+	env := miya.NewEnvironment()
+	ctx := miya.NewContext()
+	ctx.Set("report", map[string]interface{}{
+		"name":  "Q3 draft",
+		"count": 9007199254740993,
+		"ratio": 0.25,
+		"regions": []interface{}{
+			map[string]interface{}{"code": "EU", "stores": []interface{}{1, 2, 3}, "open": true},
+			map[string]interface{}{"code": "US", "stores": []interface{}{}, "open": false, "manager": nil},
+		},
+	})
+
+	tmpl := `{% set copy = report|tojson|fromjson %}{{ copy.name }}|{{ copy.count }}|{{ copy.ratio }}|` +
+		`{% for region in copy.regions %}{{ region.code }}:{{ region.stores|join("+") }}:{{ region.open }}:{{ region.manager is none }};{% endfor %}|` +
+		`{{ (copy|tojson) == (report|tojson) }}`
+	out, err := env.RenderString(tmpl, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Q3 draft|9007199254740993|0.25|EU:1+2+3:true:false;US::false:true;|true"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestFromJSONInvalid(t *testing.T) {
+	env := miya.NewEnvironment()
+	ctx := miya.NewContext()
+	ctx.Set("payload", `{"sku": "A1", "qty": 3,}`)
+
+	_, err := env.RenderString(`{{ (payload|fromjson).sku }}`, ctx)
+	var runtimeErr *runtime.RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeFilter {
+		t.Fatalf("expected a FilterError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid JSON at offset 23") || !strings.Contains(err.Error(), `"qty": 3,}`) {
+		t.Errorf("error should give the offset and the JSON around it, got %q", err.Error())
+	}
+}
+
+func TestNumericEquality(t *testing.T) {
+	env := miya.NewEnvironment()
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ 1 - 1 > 0 }}`, "false"},
+		{`{{ 1 == 1.0 }}`, "true"},
+		{`{{ 3 - 1 >= 2 }}`, "true"},
+		{`{{ "1" == 1 }}`, "false"},
+	}
+	for _, tt := range tests {
+		out, err := env.RenderString(tt.template, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+type greeter struct{ Greeting string }
+
+func (g greeter) Greet(name string, times int) string {
+	return strings.Repeat(g.Greeting+" "+name+"!", times)
+}
+
+func (g *greeter) Fail(index int) string {
+	return []string{"only"}[index]
+}
+
+func newGoFunctionEnv() *miya.Environment {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	env.AddGlobal("repeat", strings.Repeat)
+	env.AddGlobal("join", func(items []string, sep string) string { return strings.Join(items, sep) })
+	env.AddGlobal("sum", func(nums ...float64) float64 {
+		total := 0.0
+		for _, n := range nums {
+			total += n
+		}
+		return total
+	})
+	env.AddGlobal("lookup", func(m map[string]int, key string) (int, error) {
+		v, ok := m[key]
+		if !ok {
+			return 0, fmt.Errorf("no key %q", key)
+		}
+		return v, nil
+	})
+	env.AddGlobal("check", func(ok bool) error {
+		if !ok {
+			return errors.New("check failed")
+		}
+		return nil
+	})
+	env.AddGlobal("explode", func() string {
+		var m map[string]int
+		m["x"] = 1
+		return "unreachable"
+	})
+	return env
+}
+
+func TestGoFunctionCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"StdlibFunction", `{{ repeat("ab", 3) }}`, "ababab"},
+		{"FloatToInt", `{{ repeat("x", 6 / 2) }}`, "xxx"},
+		{"ListArgument", `{{ join(["a", "b"], "-") }}`, "a-b"},
+		{"Variadic", `{{ sum(1, 2.5, 3) }}|{{ sum() }}`, "6.5|0"},
+		{"ValueAndError", `{{ lookup(dict(a=1), "a") }}`, "1"},
+		{"ErrorOnly", `[{{ check(true) }}]`, "[]"},
+		{"Method", `{{ g.Greet("Ann", 2) }}`, "Hi Ann!Hi Ann!"},
+		{"LowercaseMethod", `{{ g.greet("Bo", 1) }}`, "Hi Bo!"},
+	}
+
+	env := newGoFunctionEnv()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{"g": &greeter{Greeting: "Hi"}})
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoFunctionCallErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{"Arity", `{{ repeat("ab") }}`, []string{"repeat() takes 2 arguments, got 1"}},
+		{"MissingArguments", `{{ join() }}`, []string{"join() takes 2 arguments, got 0"}},
+		{"ArgumentType", `{{ repeat(3, 3) }}`, []string{"repeat() argument 1", "cannot use int as string"}},
+		{"Fraction", `{{ repeat("x", 1.5) }}`, []string{"repeat() argument 2", "losing precision"}},
+		{"ElementType", `{{ join(["a", 1], "-") }}`, []string{"join() argument 1", "item 1"}},
+		{"Keywords", `{{ repeat("x", count=2) }}`, []string{"does not accept keyword arguments"}},
+		{"ReturnedError", `{{ lookup(dict(), "a") }}`, []string{`no key "a"`}},
+		{"ErrorOnly", `{{ check(false) }}`, []string{"check failed"}},
+		{"Panic", "\n  {{ explode() }}", []string{"explode() panicked", "assignment to entry in nil map", "line 2, column"}},
+		{"MethodPanic", `{{ g.Fail(3) }}`, []string{"g.Fail() panicked", "index out of range"}},
+	}
+
+	env := newGoFunctionEnv()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{"g": &greeter{Greeting: "Hi"}})
+			_, err := env.RenderString(tt.template, ctx)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+
+	t.Run("PanicIsRuntimeError", func(t *testing.T) {
+		_, err := env.RenderString(`{{ explode() }}`, miya.NewContext())
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeRuntime {
+			t.Errorf("expected a *runtime.RuntimeError, got %T: %v", err, err)
+		}
+	})
+}
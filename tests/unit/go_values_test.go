@@ -0,0 +1,127 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+)
+
+type baseModel struct {
+	ID        int
+	CreatedAt time.Time
+}
+
+func (m baseModel) Describe() string { return fmt.Sprintf("#%d", m.ID) }
+
+type auditInfo struct {
+	UpdatedBy string
+}
+
+type customer struct {
+	baseModel
+	*auditInfo
+	Name  string
+	Owner labeler
+}
+
+type labeler interface {
+	Label() string
+}
+
+type account struct {
+	Number string
+}
+
+func (a *account) Label() string { return "account " + a.Number }
+func (a *account) Masked() string {
+	return "****" + a.Number[len(a.Number)-2:]
+}
+
+type orderStatus int
+
+const (
+	statusDraft orderStatus = iota
+	statusShipped
+)
+
+func (s orderStatus) String() string {
+	return [...]string{"draft", "shipped"}[s]
+}
+
+type invoiceID struct {
+	year, seq int
+}
+
+// String has a pointer receiver; IDs are set in contexts as values too
+func (id *invoiceID) String() string { return fmt.Sprintf("INV-%d-%03d", id.year, id.seq) }
+
+type sku struct {
+	code string
+}
+
+func (s sku) MarshalText() ([]byte, error) { return []byte("SKU:" + s.code), nil }
+
+func TestGoValues(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var owner labeler = &account{Number: "1234"}
+	vars := map[string]interface{}{
+		"customer":  customer{baseModel: baseModel{ID: 7, CreatedAt: created}, Name: "Ann", Owner: owner},
+		"pcustomer": &customer{baseModel: baseModel{ID: 8}, auditInfo: &auditInfo{UpdatedBy: "bob"}},
+		"owner":     owner,
+		"owners":    map[string]labeler{"main": owner},
+		"status":    statusShipped,
+		"statuses":  []orderStatus{statusDraft, statusShipped},
+		"invoice":   invoiceID{2024, 5},
+		"pinvoice":  &invoiceID{2024, 6},
+		"sku":       sku{"A1"},
+		"failure":   errors.New("payment declined"),
+		"nilID":     (*invoiceID)(nil),
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"promoted fields", `{{ customer.ID }} {{ customer.id }} {{ customer.CreatedAt.Year() }} {{ customer.created_at.Month() }}`, "7 7 2024 March"},
+		{"promoted methods", `{{ customer.Describe() }} {{ pcustomer.describe() }}`, "#7 #8"},
+		{"embedded pointer", `{{ pcustomer.UpdatedBy }}|{{ customer.UpdatedBy }}|{{ customer.UpdatedBy is defined }}`, "bob||false"},
+		{"interface field", `{{ customer.Owner.Label() }} {{ customer.owner.masked() }} {{ customer.Owner.Number }}`, "account 1234 ****34 1234"},
+		{"interface value", `{{ owner.label() }} {{ owner.number }}`, "account 1234 1234"},
+		{"interface map values", `{{ owners.main.Label() }} {{ owners["main"].Number }} {{ owners.main is defined }} {{ owners.other is defined }}`, "account 1234 1234 true false"},
+		{"stringer enum", `{{ status }} {{ status|upper }} {{ "is " ~ status }} {{ statuses[0] }}`, "shipped SHIPPED is shipped draft"},
+		{"pointer receiver stringer", `{{ invoice }} {{ pinvoice }} {{ invoice|string }} {{ [invoice, pinvoice]|join(", ") }}`, "INV-2024-005 INV-2024-006 INV-2024-005 INV-2024-005, INV-2024-006"},
+		{"text marshaler", `{{ sku }} {{ sku|lower }}`, "SKU:A1 sku:a1"},
+		{"error", `{{ failure }}`, "payment declined"},
+		{"nil stringer", `{{ nilID }}`, "<nil>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("context lookup", func(t *testing.T) {
+		ctx := miya.NewContextFrom(vars)
+		if value, ok := ctx.Get("customer.ID"); !ok || value != 7 {
+			t.Errorf("customer.ID: got %v, %v", value, ok)
+		}
+		if value, ok := ctx.Get("customer.UpdatedBy"); ok {
+			t.Errorf("customer.UpdatedBy through a nil embedded pointer: got %v", value)
+		}
+		if value, ok := ctx.Get("owners.main"); !ok || value != owner {
+			t.Errorf("owners.main: got %v, %v", value, ok)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package miya_test
+
+import (
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+)
+
+type groupbyPost struct {
+	Title     string
+	Published time.Time
+	Author    groupbyAuthor
+}
+
+type groupbyAuthor struct {
+	Name string
+}
+
+func TestGroupByFilter(t *testing.T) {
+	day := func(month, d int) time.Time { return time.Date(2024, time.Month(month), d, 0, 0, 0, 0, time.UTC) }
+	vars := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "ann", "city": "b", "age": 30},
+			map[string]interface{}{"name": "bob", "city": "a", "age": 9},
+			map[string]interface{}{"name": "cid", "city": "b", "age": 30},
+			map[string]interface{}{"name": "dee", "city": "B", "age": 10},
+		},
+		"posts": []groupbyPost{
+			{"March", day(3, 1), groupbyAuthor{"zoe"}},
+			{"January", day(1, 5), groupbyAuthor{"al"}},
+			{"Also March", day(3, 1), groupbyAuthor{"al"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"unpacking", `{% for city, members in users|groupby("city") %}{{ city }}:{{ members|map(attribute="name")|join(",") }};{% endfor %}`, "B:dee;a:bob;b:ann,cid;"},
+		{"attributes", `{% for group in users|groupby("city") %}{{ group.grouper }}={{ group.list|length }};{% endfor %}`, "B=1;a=1;b=2;"},
+		{"indexing", `{% for group in users|groupby("city") %}{{ group[0] }}{{ group[1]|length }}{{ group|length }};{% endfor %}`, "B12;a12;b22;"},
+		{"numbers keep their type", `{% for group in users|groupby("age") %}{{ group.grouper + 1 }}:{{ group.list|map(attribute="name")|join(",") }};{% endfor %}`, "10:bob;11:dee;31:ann,cid;"},
+		{"times keep their type", `{% for day, items in posts|groupby("Published") %}{{ day.Month() }}:{{ items|map(attribute="Title")|join(",") }};{% endfor %}`, "January:January;March:March,Also March;"},
+		{"nested attribute", `{% for group in posts|groupby("Author.Name") %}{{ group.grouper }}:{{ group.list|map(attribute="Title")|join(",") }};{% endfor %}`, "al:January,Also March;zoe:March;"},
+		{"groupers", `{{ users|groupby("city")|map(attribute="grouper")|join }}`, "Bab"},
+		{"empty", `{% for group in [] | groupby("city") %}x{% else %}none{% endfor %}`, "none"},
+	}
+
+	env := miya.NewEnvironment()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("not a sequence", func(t *testing.T) {
+		if _, err := env.RenderString(`{{ "abc"|groupby("x") }}`, nil); err == nil {
+			t.Error("expected an error grouping a string")
+		}
+	})
+}
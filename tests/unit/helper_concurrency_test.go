@@ -0,0 +1,106 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+// renderConcurrently renders name with contexts from newCtx on several
+// goroutines and reports every output that differs from want
+func renderConcurrently(t *testing.T, env *miya.Environment, name string, newCtx func() miya.Context, want string) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; n++ {
+				got, err := env.RenderTemplate(name, newCtx())
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want != "" && got != want {
+					errs <- fmt.Errorf("%s: got %q, want %q", name, got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHelperObjectsConcurrency(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("local.html", `{% set c = cycler("odd", "even") %}{% set j = joiner("|") %}{% for i in items %}{{ j() }}{{ c.next() }}{% endfor %}`)
+	stringLoader.AddTemplate("lib.html", `{% set rows = cycler("a", "b") %}{% set sep = joiner("|") %}{% set ns = namespace(n=0) %}`)
+	stringLoader.AddTemplate("import.html", `{% import "lib.html" as lib %}{% for i in items %}{{ lib.sep() }}{{ lib.rows.next() }}{% set lib.ns.n = lib.ns.n + 1 %}{% endfor %}:{{ lib.ns.n }}`)
+	stringLoader.AddTemplate("from.html", `{% from "lib.html" import rows, sep %}{% for i in items %}{{ sep() }}{{ rows.next() }}{% endfor %}`)
+	stringLoader.AddTemplate("shared.html", `{% for i in items %}{{ c.next() }}{% endfor %}`)
+
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	shared := miya.NewContextFrom(map[string]interface{}{"items": []interface{}{1, 2, 3}})
+	clone := func() miya.Context { return shared.Clone() }
+
+	t.Run("CreatedInTemplate", func(t *testing.T) {
+		renderConcurrently(t, env, "local.html", clone, "odd|even|odd")
+	})
+
+	t.Run("SameContext", func(t *testing.T) {
+		renderConcurrently(t, env, "local.html", func() miya.Context { return shared }, "odd|even|odd")
+	})
+
+	// Values set at the top level of an imported template are cached with the
+	// import, but each render starts from their initial state
+	t.Run("ImportedState", func(t *testing.T) {
+		renderConcurrently(t, env, "import.html", clone, "a|b|a:3")
+		renderConcurrently(t, env, "from.html", clone, "a|b|a")
+	})
+
+	// A cycler placed in a shared context is one object, so renders take turns
+	// advancing it; it must stay consistent under concurrent use
+	t.Run("SharedCycler", func(t *testing.T) {
+		cycler := &runtime.Cycler{Items: []interface{}{"x", "y", "z"}}
+		ctx := shared.Clone()
+		ctx.Set("c", cycler)
+		renderConcurrently(t, env, "shared.html", func() miya.Context { return ctx.Clone() }, "")
+
+		// 8 goroutines * 25 renders * 3 items advance the cycler 600 times
+		if got := cycler.GetCurrent(); got != "x" {
+			t.Errorf("cycler ended at %v, want x", got)
+		}
+	})
+
+	t.Run("SharedJoiner", func(t *testing.T) {
+		joiner := &runtime.Joiner{Separator: ","}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var out strings.Builder
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s := joiner.Join()
+				mu.Lock()
+				out.WriteString(s)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if got := out.String(); got != ",,,,,,," {
+			t.Errorf("joiner emitted %q, want 7 separators", got)
+		}
+	})
+}
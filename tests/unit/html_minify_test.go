@@ -0,0 +1,136 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+	"github.com/zipreport/miya/tests/helpers"
+)
+
+// productsPage returns an environment serving the web-server example's
+// templates and the context of its products page
+func productsPage(t testing.TB, opts ...miya.EnvironmentOption) (*miya.Environment, miya.Context) {
+	t.Helper()
+	fsLoader := loader.NewFileSystemLoader([]string{"../../examples/go/web-server/templates"}, loader.NewDirectTemplateParser())
+	env := miya.NewEnvironment(append([]miya.EnvironmentOption{miya.WithLoader(fsLoader)}, opts...)...)
+	product := func(id int, name, description string, price float64, inStock bool, category string) map[string]interface{} {
+		return map[string]interface{}{"ID": id, "Name": name, "Description": description, "Price": price, "InStock": inStock, "Category": category}
+	}
+	ctx := miya.NewContextFrom(map[string]interface{}{
+		"title":        "Products",
+		"current_time": time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC),
+		"products": []interface{}{
+			product(1, "Laptop Pro", "High-performance laptop for professionals", 1299.99, true, "Electronics"),
+			product(2, "Wireless Mouse", "Ergonomic wireless mouse with long battery life", 29.99, true, "Accessories"),
+			product(3, "Mechanical Keyboard", "RGB mechanical keyboard for gaming", 149.99, false, "Accessories"),
+			product(4, "4K Monitor", "27-inch 4K UHD monitor", 399.99, true, "Electronics"),
+			product(5, "USB-C Hub", "7-in-1 USB-C hub adapter", 49.99, true, "Accessories"),
+			product(6, "Webcam HD", "1080p HD webcam with microphone", 79.99, false, "Electronics"),
+		},
+	})
+	return env, ctx
+}
+
+func TestHTMLMinifyWhitespace(t *testing.T) {
+	t.Run("ProductsPage", func(t *testing.T) {
+		env, ctx := productsPage(t)
+		full, err := env.RenderTemplate("products.html", ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, ctx = productsPage(t, miya.WithHTMLMinifyWhitespace(true))
+		minified, err := env.RenderTemplate("products.html", ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(full) != 3432 || len(minified) != 2748 {
+			t.Errorf("got %d bytes before and %d after minifying, want 3432 and 2748", len(full), len(minified))
+		}
+		for _, want := range []string{
+			`<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8">`,
+			`<nav><a href="/">Home</a> <a href="/products">Products</a> <a href="/about">About</a></nav>`,
+			`<h3>Accessories (3)</h3><div class="category-products"><div class="product"><h4>Wireless Mouse (#2)</h4>`,
+			`<div class="product"><h4>Laptop Pro (#1)</h4><p>High-performance laptop for professionals</p>`,
+			"\n        .price { font-size: 1.2em; color: #28a745; font-weight: bold; }\n",
+			`<p>Generated at 2024-01-15 09:30:00 | Not logged in</p>`,
+		} {
+			if !strings.Contains(minified, want) {
+				t.Errorf("missing %q in:\n%s", want, minified)
+			}
+		}
+		if strings.Contains(full, "<html lang=\"en\"><head>") {
+			t.Error("whitespace removed without WithHTMLMinifyWhitespace")
+		}
+	})
+
+	t.Run("ValuesKeepWhitespace", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithHTMLMinifyWhitespace(true))
+		tmpl, err := env.FromString("<div>\n  <p>\n    {{ text }}\n  </p>\n  {{ a }}  {{ b }}\n</div>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"text": "two  spaces\nand a line", "a": "A", "b": "B"}))
+		if err != nil || out != "<div><p>two  spaces\nand a line</p>A B</div>" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("OnlyHTMLRenders", func(t *testing.T) {
+		env := helpers.CreateLoaderEnvironment(map[string]string{
+			"mail.txt":  "Hello,\n\n  {{ name }}\n",
+			"page.html": "<p>\n  {{ name }}\n</p>\n",
+		}, miya.WithHTMLMinifyWhitespace(true))
+		ctx := miya.NewContextFrom(map[string]interface{}{"name": "Ann"})
+		if out, err := env.RenderTemplate("mail.txt", ctx); err != nil || out != "Hello,\n\n  Ann\n" {
+			t.Errorf("text template: got %q, %v", out, err)
+		}
+		if out, err := env.RenderTemplate("page.html", ctx); err != nil || out != "<p>Ann</p>" {
+			t.Errorf("HTML template: got %q, %v", out, err)
+		}
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := tmpl.RenderWith(ctx, miya.RenderAutoescape(false)); err != nil || out != "<p>\n  Ann\n</p>\n" {
+			t.Errorf("without autoescaping: got %q, %v", out, err)
+		}
+		if out, err := env.Overlay().RenderTemplate("page.html", ctx); err != nil || out != "<p>Ann</p>" {
+			t.Errorf("overlay: got %q, %v", out, err)
+		}
+	})
+}
+
+// The products page with whitespace collapsed while rendering, and with the
+// same rules applied to the rendered page instead
+func BenchmarkHTMLMinifyWhitespace(b *testing.B) {
+	b.Run("Off", func(b *testing.B) {
+		env, ctx := productsPage(b)
+		for i := 0; i < b.N; i++ {
+			if _, err := env.RenderTemplate("products.html", ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("WhileRendering", func(b *testing.B) {
+		env, ctx := productsPage(b, miya.WithHTMLMinifyWhitespace(true))
+		for i := 0; i < b.N; i++ {
+			if _, err := env.RenderTemplate("products.html", ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("AfterRendering", func(b *testing.B) {
+		env, ctx := productsPage(b)
+		for i := 0; i < b.N; i++ {
+			out, err := env.RenderTemplate("products.html", ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			runtime.MinifyHTMLWhitespace(out)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+// newImportEnv returns an environment whose forms.html counts how often its
+// namespace is built, through the top-level set calling built()
+func newImportEnv(t testing.TB) (*miya.Environment, *loader.StringLoader, func() int) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("forms.html", `{% set loaded = built() %}{% macro input(name) %}<input name="{{ name }}">{% endmacro %}`)
+	stringLoader.AddTemplate("fields.html", `{% import "forms.html" as forms %}{% macro field(name) %}<label>{{ name }}</label>{{ forms.input(name) }}{% endmacro %}`)
+	stringLoader.AddTemplate("user.html", `{% macro show() %}[{{ user }}]{% endmacro %}`)
+
+	var mu sync.Mutex
+	builds := 0
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	env.AddGlobal("built", func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		builds++
+		return builds
+	})
+	return env, stringLoader, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return builds
+	}
+}
+
+func TestImportNamespaceCache(t *testing.T) {
+	t.Run("BuiltOnce", func(t *testing.T) {
+		env, _, builds := newImportEnv(t)
+		for i := 0; i < 100; i++ {
+			source := fmt.Sprintf(`{%% import "forms.html" as forms %%}{{ forms.input("f%d") }}`, i)
+			out, err := env.RenderString(source, miya.NewContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf(`<input name="f%d">`, i); out != want {
+				t.Fatalf("got %q, want %q", out, want)
+			}
+		}
+		if _, err := env.RenderString(`{% from "forms.html" import input %}{{ input("x") }}`, miya.NewContext()); err != nil {
+			t.Fatal(err)
+		}
+		if n := builds(); n != 1 {
+			t.Errorf("forms.html namespace built %d times, want 1", n)
+		}
+	})
+
+	t.Run("NestedImports", func(t *testing.T) {
+		env, _, builds := newImportEnv(t)
+		for i := 0; i < 3; i++ {
+			out, err := env.RenderString(`{% from "fields.html" import field %}{{ field("email") }}`, miya.NewContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != `<label>email</label><input name="email">` {
+				t.Errorf("got %q", out)
+			}
+		}
+		if n := builds(); n != 1 {
+			t.Errorf("forms.html namespace built %d times, want 1", n)
+		}
+	})
+
+	t.Run("Invalidation", func(t *testing.T) {
+		env, stringLoader, builds := newImportEnv(t)
+		render := func() string {
+			out, err := env.RenderString(`{% import "fields.html" as f %}{{ f.field("a") }}`, miya.NewContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		}
+
+		render()
+		stringLoader.UpdateTemplate("forms.html", `{% set loaded = built() %}{% macro input(name) %}<textarea name="{{ name }}">{% endmacro %}`)
+		if out := render(); out != `<label>a</label><textarea name="a">` {
+			t.Errorf("importer of a changed template not reloaded: %q", out)
+		}
+		env.ClearCache()
+		render()
+		if n := builds(); n != 3 {
+			t.Errorf("forms.html namespace built %d times, want 3", n)
+		}
+	})
+
+	t.Run("WithoutContext", func(t *testing.T) {
+		env, _, _ := newImportEnv(t)
+		for _, user := range []string{"ann", "bob"} {
+			ctx := miya.NewContextFrom(map[string]interface{}{"user": user})
+			out, err := env.RenderString(`{% import "user.html" as u %}{{ u.show() }}{% from "user.html" import show %}{{ show() }}`, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != "[][]" {
+				t.Errorf("import without context saw the importer's variables: %q", out)
+			}
+		}
+	})
+
+	t.Run("WithContext", func(t *testing.T) {
+		env, _, _ := newImportEnv(t)
+		for _, user := range []string{"ann", "bob"} {
+			ctx := miya.NewContextFrom(map[string]interface{}{"user": user})
+			out, err := env.RenderString(`{% import "user.html" as u with context %}{{ u.show() }}{% from "user.html" import show with context %}{{ show() }}`, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "[" + user + "][" + user + "]"; out != want {
+				t.Errorf("got %q, want %q", out, want)
+			}
+		}
+	})
+
+	t.Run("ConcurrentRenders", func(t *testing.T) {
+		env, _, builds := newImportEnv(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				source := fmt.Sprintf(`{%% from "fields.html" import field %%}{{ field("f%d") }}`, i)
+				out, err := env.RenderString(source, miya.NewContext())
+				if err != nil || !strings.Contains(out, fmt.Sprintf(`name="f%d"`, i)) {
+					t.Errorf("render %d: %q, %v", i, out, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if n := builds(); n < 1 {
+			t.Error("forms.html namespace never built")
+		}
+	})
+}
+
+func TestTemplateCycles(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("a.html", `{% import "b.html" as b %}{% macro a() %}{% endmacro %}`)
+	stringLoader.AddTemplate("b.html", `{% from "a.html" import a %}`)
+	stringLoader.AddTemplate("self.html", `{% import "self.html" as me %}`)
+	stringLoader.AddTemplate("c.html", `{% include "d.html" %}`)
+	stringLoader.AddTemplate("d.html", `{% include "c.html" %}`)
+	stringLoader.AddTemplate("e.html", `{% extends "f.html" %}`)
+	stringLoader.AddTemplate("f.html", `{% extends "e.html" %}`)
+	stringLoader.AddTemplate("tree.html", `{{ node.name }}{% for node in node.children %}({% include "tree.html" %}){% endfor %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+
+	tests := []struct {
+		name     string
+		template string
+		kind     string
+		path     string
+	}{
+		{"Import", `{% import "a.html" as a %}`, "import", "a.html -> b.html -> a.html"},
+		{"From", `{% from "b.html" import a %}`, "import", "b.html -> a.html -> b.html"},
+		{"SelfImport", `{% import "self.html" as s %}`, "import", "self.html -> self.html"},
+		{"Include", `{% include "c.html" %}`, "include", "c.html -> d.html -> c.html"},
+		{"Extends", `{% extends "e.html" %}`, "extends", "e.html -> f.html -> e.html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.RenderString(tt.template, miya.NewContext())
+			var cycleErr *runtime.CycleError
+			if !errors.As(err, &cycleErr) {
+				t.Fatalf("expected a *runtime.CycleError, got %v", err)
+			}
+			if cycleErr.Kind != tt.kind || !strings.HasSuffix(strings.Join(cycleErr.Path, " -> "), tt.path) {
+				t.Errorf("got %s cycle %v, want %s cycle ending %s", cycleErr.Kind, cycleErr.Path, tt.kind, tt.path)
+			}
+			if !strings.Contains(err.Error(), tt.path) {
+				t.Errorf("error %q does not list the cycle %q", err, tt.path)
+			}
+		})
+	}
+
+	t.Run("RecursiveInclude", func(t *testing.T) {
+		leaf := func(name string) map[string]interface{} {
+			return map[string]interface{}{"name": name, "children": []interface{}{}}
+		}
+		root := map[string]interface{}{"name": "root", "children": []interface{}{
+			leaf("a"),
+			map[string]interface{}{"name": "b", "children": []interface{}{leaf("c")}},
+		}}
+		out, err := env.RenderString(`{% include "tree.html" %}`, miya.NewContextFrom(map[string]interface{}{"node": root}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "root(a)(b(c))" {
+			t.Errorf("got %q", out)
+		}
+	})
+}
+
+func BenchmarkSharedImport(b *testing.B) {
+	env, _, _ := newImportEnv(b)
+	templates := make([]*miya.Template, 100)
+	for i := range templates {
+		tmpl, err := env.FromString(fmt.Sprintf(`{%% import "forms.html" as forms %%}{{ forms.input("f%d") }}`, i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		templates[i] = tmpl
+	}
+
+	render := func(b *testing.B, invalidate bool) {
+		for i := 0; i < b.N; i++ {
+			for _, tmpl := range templates {
+				if invalidate {
+					env.InvalidateTemplate("forms.html")
+				}
+				if _, err := tmpl.Render(miya.NewContext()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	b.Run("Cached", func(b *testing.B) { render(b, false) })
+	b.Run("RebuiltEachRender", func(b *testing.B) { render(b, true) })
+}
@@ -1,8 +1,10 @@
 package miya_test
 
 import (
-	miya "github.com/zipreport/miya"
+	"strings"
 	"testing"
+
+	miya "github.com/zipreport/miya"
 )
 
 func TestInOperatorParsing(t *testing.T) {
@@ -106,7 +108,7 @@ func TestInOperatorPrecedence(t *testing.T) {
 		},
 		{
 			"in operator with comparison",
-			`{{ "apple" in fruits == true }}`,
+			`{{ ("apple" in fruits) == true }}`,
 			map[string]interface{}{"fruits": []string{"apple", "banana", "cherry"}},
 			"true",
 		},
@@ -130,3 +132,83 @@ func TestInOperatorPrecedence(t *testing.T) {
 		})
 	}
 }
+
+// tagSet matches tags case-insensitively through runtime.ContainerContains
+type tagSet []string
+
+func (s tagSet) Contains(v interface{}) bool {
+	tag, ok := v.(string)
+	for _, t := range s {
+		if ok && strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInOperatorSemantics(t *testing.T) {
+	data := map[string]interface{}{
+		"role":    "site-admin",
+		"config":  map[string]interface{}{"debug": false, "level": "info"},
+		"ports":   map[int64]string{8080: "http"},
+		"nums":    []int{1, 2, 3},
+		"floats":  []interface{}{1.0, 2.5},
+		"tags":    tagSet{"Go", "Jinja"},
+		"nothing": nil,
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ "admin" in role }}`, "true"},
+		{`{{ "root" in role }}`, "false"},
+		{`{{ "" in role }}`, "true"},
+		{`{{ "debug" in config }}`, "true"},
+		{`{{ "info" in config }}`, "false"},
+		{`{{ "info" not in config }}`, "true"},
+		{`{{ 8080 in ports }}`, "true"},
+		{`{{ "http" in ports }}`, "false"},
+		{`{{ 2.0 in nums }}`, "true"},
+		{`{{ 1 in floats }}`, "true"},
+		{`{{ [1] in [[1], [2]] }}`, "true"},
+		{`{{ "go" in tags }}`, "true"},
+		{`{{ "rust" in tags }}`, "false"},
+		{`{{ "go" is in(tags) }}`, "true"},
+		{`{{ "go" is in tags }}`, "true"},
+		{`{{ 3 is in [1, 2] }}`, "false"},
+		{`{{ tags is contains("JINJA") }}`, "true"},
+		{`{{ "x" in nothing }}`, "false"},
+		{`{{ "x" in absent }}`, "false"},
+		{`{{ absent in nums }}`, "false"},
+		{`{{ absent not in nums }}`, "true"},
+	}
+
+	env := miya.NewEnvironment()
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := env.RenderString(tt.template, miya.NewContextFrom(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("NonStringInString", func(t *testing.T) {
+		_, err := env.RenderString(`{{ 1 in "123" }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "requires a string as left operand") {
+			t.Errorf("expected a type error, got %v", err)
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		strict := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedStrict))
+		for _, template := range []string{`{{ "x" in absent }}`, `{{ absent in nums }}`, `{{ absent not in nums }}`} {
+			if _, err := strict.RenderString(template, miya.NewContextFrom(data)); err == nil || !strings.Contains(err.Error(), "undefined variable: absent") {
+				t.Errorf("%s: expected an undefined error, got %v", template, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func newIncludeContextEnv(t *testing.T) *miya.Environment {
+	t.Helper()
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("card.html", `[{{ product }}{% if compact %} compact{% endif %}{% if user %} {{ user }}{% endif %}]`)
+	return miya.NewEnvironment(miya.WithLoader(stringLoader))
+}
+
+func TestIncludeKeywordContext(t *testing.T) {
+	env := newIncludeContextEnv(t)
+	env.AddGlobal("user", "global")
+	ctx := miya.NewContextFrom(map[string]interface{}{"items": []string{"a", "b"}, "user": "ann", "product": "outer"})
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"layered over the includer's variables", `{% for item in items %}{% include "card.html" with product=item, compact=loop.first %}{% endfor %}`, "[a compact ann][b ann]"},
+		{"only", `{% include "card.html" with product="p" only %}`, "[p global]"},
+		{"only without variables", `{% include "card.html" only %}`, "[ global]"},
+		{"variables don't leak back", `{% include "card.html" with product="p" %}{{ product }}`, "[p ann]outer"},
+		{"ignore missing before with", `{% include "missing.html" ignore missing with product="p" only %}ok`, "ok"},
+		{"ignore missing after with", `{% include "missing.html" with product="p" ignore missing %}ok`, "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeTemplateObject(t *testing.T) {
+	env := newIncludeContextEnv(t)
+	card, err := env.GetTemplate("card.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline, err := env.FromString(`<{{ product }}>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := miya.NewContextFrom(map[string]interface{}{"card": card, "inline": inline})
+	out, err := env.RenderString(`{% include card with product="x" only %}{% include inline with product="y" %}`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "[x]<y>" {
+		t.Errorf("got %q", out)
+	}
+
+	out, err = env.RenderString(`{% include no_template ignore missing %}ok`, miya.NewContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "ok" {
+		t.Errorf("got %q", out)
+	}
+
+	_, err = env.RenderString(`{% include 42 %}`, miya.NewContext())
+	if err == nil || !strings.Contains(err.Error(), "must be a name or a template") {
+		t.Errorf("expected a target type error, got %v", err)
+	}
+}
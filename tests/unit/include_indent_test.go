@@ -0,0 +1,94 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func newIncludeIndentEnv(t *testing.T, opts ...miya.EnvironmentOption) *miya.Environment {
+	t.Helper()
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("probe.yaml", "livenessProbe:\n  httpGet:\n    path: {{ path }}\n\n  periodSeconds: 10")
+	stringLoader.AddTemplate("probe_nl.yaml", "port: {{ port }}\nscheme: HTTP\n")
+	stringLoader.AddTemplate("body.py", "if ready:\n\treturn True")
+	return miya.NewEnvironment(append([]miya.EnvironmentOption{miya.WithLoader(stringLoader)}, opts...)...)
+}
+
+func TestIncludeIndentContent(t *testing.T) {
+	env := newIncludeIndentEnv(t)
+	ctx := miya.NewContextFrom(map[string]interface{}{"path": "/healthz", "port": 8080})
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name:     "spaces",
+			template: "containers:\n  - name: app\n    {% include \"probe.yaml\" with path=\"/ready\" indent content %}\n    image: app",
+			want:     "containers:\n  - name: app\n    livenessProbe:\n      httpGet:\n        path: /ready\n\n      periodSeconds: 10\n    image: app",
+		},
+		{
+			name:     "without indent content",
+			template: "  {% include \"probe.yaml\" %}",
+			want:     "  livenessProbe:\n  httpGet:\n    path: /healthz\n\n  periodSeconds: 10",
+		},
+		{
+			name:     "tabs",
+			template: "def check():\n\t{% include \"body.py\" indent content %}\n",
+			want:     "def check():\n\tif ready:\n\t\treturn True\n",
+		},
+		{
+			name:     "trailing newline not indented",
+			template: "spec:\n  {% include \"probe_nl.yaml\" indent content %}  next: 1",
+			want:     "spec:\n  port: 8080\n  scheme: HTTP\n  next: 1",
+		},
+		{
+			name:     "text before the tag",
+			template: "  probe: {% include \"probe_nl.yaml\" indent content %}",
+			want:     "  probe: port: 8080\n  scheme: HTTP\n",
+		},
+		{
+			name:     "with ignore missing",
+			template: "  {% include \"missing.yaml\" indent content ignore missing %}ok",
+			want:     "  ok",
+		},
+		{
+			name:     "with only",
+			template: "  {% include \"probe_nl.yaml\" with port=9090 only ignore missing indent content %}",
+			want:     "  port: 9090\n  scheme: HTTP\n",
+		},
+		{
+			name:     "filter block",
+			template: "  {% filter upper indent content %}a\nb{% endfilter %}",
+			want:     "  A\n  B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeIndentContentLstripBlocks(t *testing.T) {
+	env := newIncludeIndentEnv(t, miya.WithLstripBlocks(true), miya.WithTrimBlocks(true))
+	ctx := miya.NewContextFrom(map[string]interface{}{"port": 8080, "probes": true})
+
+	template := "spec:\n  {% if probes %}\n  {% include \"probe_nl.yaml\" indent content %}\n  {% endif %}\n  next: 1\n"
+	out, err := env.RenderString(template, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "spec:\n  port: 8080\n  scheme: HTTP\n  next: 1"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
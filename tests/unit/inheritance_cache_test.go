@@ -0,0 +1,127 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+// newInheritanceChainEnv returns an environment with a four level extends
+// chain, page.html -> section.html -> layout.html -> base.html, whose layout
+// includes a dozen partials
+func newInheritanceChainEnv(tb testing.TB) (*miya.Environment, *loader.StringLoader) {
+	tb.Helper()
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<html>{% block head %}<title>{% block title %}Site{% endblock %}</title>{% endblock %}<body>{% block body %}{% endblock %}</body></html>`)
+
+	var includes strings.Builder
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("partial%d.html", i)
+		stringLoader.AddTemplate(name, fmt.Sprintf(`<p>{{ %q }}</p>`, name))
+		fmt.Fprintf(&includes, `{%% include %q %%}`, name)
+	}
+	stringLoader.AddTemplate("layout.html", `{% extends "base.html" %}{% block body %}<main>{% block content %}{% endblock %}</main>`+includes.String()+`{% endblock %}`)
+	stringLoader.AddTemplate("section.html", `{% extends "layout.html" %}{% block title %}Docs - {{ super() }}{% endblock %}`)
+	stringLoader.AddTemplate("page.html", `{% extends "section.html" %}{% block content %}{% if page == 1 %}first{% elif page == 2 %}second{% endif %}{% endblock %}`)
+
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	return env, stringLoader
+}
+
+func renderPage(tb testing.TB, env *miya.Environment, page int) string {
+	tb.Helper()
+	tmpl, err := env.GetTemplate("page.html")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"page": page}))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return out
+}
+
+func TestInheritanceChainCache(t *testing.T) {
+	t.Run("ResolvedOnce", func(t *testing.T) {
+		env, _ := newInheritanceChainEnv(t)
+
+		out := renderPage(t, env, 1)
+		if !strings.HasPrefix(out, "<html><title>Docs - Site</title><body><main>first</main><p>partial0.html</p>") {
+			t.Errorf("unexpected output %q", out)
+		}
+		if out := renderPage(t, env, 2); !strings.Contains(out, "<main>second</main>") {
+			t.Errorf("unexpected output %q", out)
+		}
+
+		stats := env.GetInheritanceCacheStats()
+		if stats.ResolvedCache.Hits != 1 || stats.ResolvedCache.Entries != 1 {
+			t.Errorf("resolved cache hits = %d, entries = %d, want 1 and 1", stats.ResolvedCache.Hits, stats.ResolvedCache.Entries)
+		}
+	})
+
+	t.Run("AncestorUpdated", func(t *testing.T) {
+		env, stringLoader := newInheritanceChainEnv(t)
+		renderPage(t, env, 1)
+
+		if err := stringLoader.UpdateTemplate("base.html", `<!doctype html>{% block head %}<title>{% block title %}Site{% endblock %}</title>{% endblock %}{% block body %}{% endblock %}`); err != nil {
+			t.Fatal(err)
+		}
+		if out := renderPage(t, env, 1); !strings.HasPrefix(out, "<!doctype html><title>Docs - Site</title><main>first</main>") {
+			t.Errorf("expected the updated base template, got %q", out)
+		}
+
+		if err := stringLoader.UpdateTemplate("section.html", `{% extends "layout.html" %}{% block title %}Guide{% endblock %}`); err != nil {
+			t.Fatal(err)
+		}
+		if out := renderPage(t, env, 1); !strings.HasPrefix(out, "<!doctype html><title>Guide</title>") {
+			t.Errorf("expected the updated section template, got %q", out)
+		}
+	})
+
+	t.Run("TemplateCacheCleared", func(t *testing.T) {
+		env, _ := newInheritanceChainEnv(t)
+		renderPage(t, env, 1)
+
+		// Reloaded templates get new versions, so the resolved chain is
+		// rebuilt from them rather than served from the inheritance cache
+		env.ClearCache()
+		renderPage(t, env, 1)
+		if stats := env.GetInheritanceCacheStats(); stats.ResolvedCache.Hits != 0 {
+			t.Errorf("resolved cache hits = %d, want 0", stats.ResolvedCache.Hits)
+		}
+	})
+}
+
+func BenchmarkInheritanceChain(b *testing.B) {
+	env, _ := newInheritanceChainEnv(b)
+	renderPage(b, env, 1)
+	ctx := miya.NewContextFrom(map[string]interface{}{"page": 1})
+
+	render := func(b *testing.B, invalidate bool) {
+		for i := 0; i < b.N; i++ {
+			if invalidate {
+				env.InvalidateTemplate("base.html")
+			}
+			tmpl, err := env.GetTemplate("page.html")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := tmpl.Render(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("GetTemplate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := env.GetTemplate("page.html"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Cached", func(b *testing.B) { render(b, false) })
+	b.Run("ResolvedEachRender", func(b *testing.B) { render(b, true) })
+}
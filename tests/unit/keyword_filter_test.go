@@ -0,0 +1,154 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func padFilter(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	width, fill := 0, "."
+	if len(args) > 0 {
+		width, _ = args[0].(int)
+	}
+	if w, ok := kwargs["width"].(int); ok {
+		width = w
+	}
+	if f, ok := kwargs["fill"].(string); ok {
+		fill = f
+	}
+	s := fmt.Sprint(value)
+	if len(s) < width {
+		s = strings.Repeat(fill, width-len(s)) + s
+	}
+	return s, nil
+}
+
+var translations = map[string]map[string]string{
+	"de": {"hello": "hallo"},
+	"fr": {"hello": "bonjour"},
+}
+
+func translateFilter(ctx miya.FilterContext, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+	locale, _ := kwargs["locale"].(string)
+	if locale == "" {
+		v, _ := ctx.GetVariable("locale")
+		locale, _ = v.(string)
+	}
+	if text, ok := translations[locale][fmt.Sprint(value)]; ok {
+		return text, nil
+	}
+	return value, nil
+}
+
+func newKeywordFilterEnv() *miya.Environment {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	env.AddFilterKW("pad", padFilter)
+	env.AddContextFilter("t", translateFilter)
+	return env
+}
+
+func TestKeywordAndContextFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Positional", `{{ 7|pad(3) }}`, "..7"},
+		{"Keyword", `{{ 7|pad(width=3) }}`, "..7"},
+		{"Mixed", `{{ 7|pad(2, fill="0") }}`, "07"},
+		{"KeywordExpression", `{{ 7|pad(width=n, fill="-") }}`, "---7"},
+		{"FilterBlock", `{% filter pad(width=4) %}ab{% endfilter %}`, "..ab"},
+		{"ContextVariable", `{{ "hello"|t }}`, "hallo"},
+		{"ScopedVariable", `{% set locale = "fr" %}{{ "hello"|t }}`, "bonjour"},
+		{"LoopVariable", `{% for locale in ["fr", "de"] %}{{ "hello"|t }} {% endfor %}`, "bonjour hallo "},
+		{"ContextKeyword", `{{ "hello"|t(locale="fr") }}`, "bonjour"},
+		{"InMacro", `{% macro greet() %}{{ "hello"|t }}{% endmacro %}{{ greet() }}`, "hallo"},
+		{"SimpleFilterUnchanged", `{{ "ab"|upper }}{{ "ab"|replace("a", "x") }}`, "ABxb"},
+	}
+
+	env := newKeywordFilterEnv()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{"locale": "de", "n": 4})
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("AliasAndOverlay", func(t *testing.T) {
+		env := newKeywordFilterEnv()
+		env.AddFilterAlias("lpad", "pad")
+		overlay := env.Overlay()
+		overlay.AddFilterAlias("translate", "t")
+
+		ctx := miya.NewContextFrom(map[string]interface{}{"locale": "fr"})
+		out, err := overlay.RenderString(`{{ 1|lpad(width=2) }} {{ "hello"|translate }} {{ "hello"|t(locale="de") }}`, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != ".1 bonjour hallo" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("AliasErrors", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		env.AddFilterKW("fail", func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("bad %v", kwargs["x"])
+		})
+		env.AddFilterAlias("oops", "fail")
+
+		_, err := env.RenderString(`{{ 1|oops(x=2) }}`, miya.NewContext())
+		var aliasErr *runtime.AliasError
+		if !errors.As(err, &aliasErr) || !strings.Contains(err.Error(), "bad 2") {
+			t.Errorf("expected an alias error mentioning the keyword, got %v", err)
+		}
+	})
+
+	t.Run("ApplyOutsideRender", func(t *testing.T) {
+		env := newKeywordFilterEnv()
+		out, err := env.ApplyFilter("pad", 5, 2)
+		if err != nil || out != ".5" {
+			t.Errorf("pad: got %v, %v", out, err)
+		}
+		out, err = env.ApplyFilter("t", "hello")
+		if err != nil || out != "hello" {
+			t.Errorf("t: got %v, %v", out, err)
+		}
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		var seen *miya.Environment
+		env.AddContextFilter("env", func(ctx miya.FilterContext, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error) {
+			seen = ctx.Environment()
+			return value, nil
+		})
+		if _, err := env.RenderString(`{{ 1|env }}`, miya.NewContext()); err != nil {
+			t.Fatal(err)
+		}
+		if seen != env {
+			t.Error("FilterContext.Environment did not return the environment")
+		}
+	})
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		env := newKeywordFilterEnv()
+		if err := env.AddFilterKW("pad", padFilter); err == nil {
+			t.Error("expected an error registering pad twice")
+		}
+		if err := env.AddContextFilter("upper", translateFilter); err == nil {
+			t.Error("expected an error shadowing a built-in filter")
+		}
+	})
+}
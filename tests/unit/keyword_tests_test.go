@@ -0,0 +1,73 @@
+package miya_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestKeywordNamedTests(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	users := []interface{}{
+		map[string]interface{}{"name": "Ann", "age": 31, "admin": true},
+		map[string]interface{}{"name": "Bob", "age": 25, "admin": false},
+		map[string]interface{}{"name": "Cid", "age": 27, "admin": nil},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"select in", `{{ [1, 2, 3, 4]|select("in", [2, 4, 6])|join(",") }}`, "2,4"},
+		{"select none", `{{ [1, none, 3]|select("none")|list|length }}`, "1"},
+		{"reject true", `{{ [true, false, 1]|reject("true")|join(",") }}`, "false,1"},
+		{"select operator", `{{ [1, 2, 3, 4]|select(">", 2)|join(",") }}`, "3,4"},
+		{"select odd", `{{ [1, 2, 3, 4]|select("odd")|join(",") }}`, "1,3"},
+		{"selectattr gt", `{{ users|selectattr("age", "gt", 26)|map(attribute="name")|join(",") }}`, "Ann,Cid"},
+		{"selectattr false", `{{ users|selectattr("admin", "false")|map(attribute="name")|join(",") }}`, "Bob"},
+		{"rejectattr none", `{{ users|rejectattr("admin", "none")|map(attribute="name")|join(",") }}`, "Ann,Bob"},
+		{"map filter", `{{ ["a", "b"]|map("upper")|join(",") }}`, "A,B"},
+		{"map filter arguments", `{{ [1.26, 2.71]|map("round", 1)|join(",") }}`, "1.3,2.7"},
+		{"map attribute", `{{ users|map("name")|join(",") }}`, "Ann,Bob,Cid"},
+		{"is true", `{{ true is true }} {{ 1 is true }} {{ false is false }}`, "true false true"},
+		{"not in", `{{ not 1 in [2, 3] }}`, "true"},
+		{"is not none or", `{{ x is not none or false }}`, "false"},
+		{"sign before filters", `{{ -1|abs }} {{ -(1|abs) }}`, "1 -1"},
+		{"sign before tests", `{{ -3 is number }}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("users", users)
+			ctx.Set("x", nil)
+			result, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("unknown test", func(t *testing.T) {
+		_, err := env.RenderString(`{{ [1]|select("nope")|list }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "unknown test: nope") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("ApplyFilter", func(t *testing.T) {
+		result, err := env.ApplyFilter("select", []interface{}{1, 2, 3}, "odd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []interface{}{1, 3}; !reflect.DeepEqual(result, want) {
+			t.Errorf("got %v, want %v", result, want)
+		}
+	})
+}
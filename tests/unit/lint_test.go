@@ -0,0 +1,88 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/tests/helpers"
+)
+
+func TestLint(t *testing.T) {
+	env := helpers.CreateLoaderEnvironment(map[string]string{
+		"base.html": "<title>{% block title %}{% endblock %}</title>\n" +
+			"{% block content %}{% block sidebar %}{% endblock %}{% endblock %}",
+		"page.html": "{% extends \"base.html\" %}\n" +
+			"{% block title %}Page{% endblock %}\n" +
+			"{% block sidebr %}typo{% endblock %}\n" +
+			"{% block content %}{% block extra %}new blocks nest{% endblock %}{% endblock %}",
+		"forms.html": "{% macro input(name) %}<input name=\"{{ name }}\">{% endmacro %}\n" +
+			"{% macro select(name) %}<select name=\"{{ name }}\">{% endmacro %}\n" +
+			"{% macro unused() %}{% endmacro %}\n" +
+			"{% macro _label(text) %}{{ text }}{% endmacro %}\n" +
+			"{% macro field(name) %}{{ _label(name) }}{{ input(name) }}{% endmacro %}",
+		"form.html": "{% import \"forms.html\" as forms %}{{ forms.field(\"q\") }}\n" +
+			"{% from \"forms.html\" import select %}{{ select(\"s\") }}",
+		"loops.html": "{% for item in items %}\n" +
+			"  {% set item = item|upper %}{% set total = 1 %}\n" +
+			"  {% for x in item %}{% set loop = none %}{% endfor %}\n" +
+			"{% endfor %}\n" +
+			"{% macro row(cells) %}{% set cells %}x{% endset %}{% for c in cells %}{% macro inner(c) %}{% set item = 1 %}{% endmacro %}{{ inner(c) }}{% endfor %}{% endmacro %}{{ row([]) }}",
+		"broken.html":      "{% if %}",
+		"uses_broken.html": "{% include \"broken.html\" %}{% include \"missing.html\" ignore missing %}",
+		"card.html":        "<div>{% block body %}{% endblock %}</div>",
+		"embeds.html":      "{% embed \"card.html\" %}{% block bdy %}x{% endblock %}{% endembed %}",
+		"dynamic.html":     "{% extends layout %}{% block anything %}{% endblock %}",
+		"names.html": "{% set range = 3 %}{% for dict in items %}{% endfor %}\n" +
+			"{% macro list(caller, varargs) %}{{ caller() }}{% endmacro %}{{ list(none) }}\n" +
+			"{% from \"forms.html\" import input as namespace %}{% with self = 1 %}{% endwith %}",
+	})
+
+	diagnostics, err := env.Lint()
+	if err == nil || !strings.Contains(err.Error(), "broken.html") {
+		t.Errorf("expected the compile error of broken.html, got %v", err)
+	}
+
+	var got []string
+	for _, d := range diagnostics {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		`embeds.html:1:27: unknown-block: block "bdy" is not defined in embedded template "card.html", so it renders nothing (did you mean 'body'?)`,
+		`forms.html:3:4: unused-macro: macro "unused" is never called`,
+		`loops.html:2:6: shadowed-variable: set of "item" shadows the loop variable of the for loop at line 1`,
+		`loops.html:3:25: shadowed-variable: set of "loop" shadows the loop variable of the for loop at line 3`,
+		`loops.html:5:26: shadowed-variable: set of "cells" shadows the parameter of macro "row"`,
+		`names.html:1:4: reserved-name: set "range" hides the built-in global range`,
+		`names.html:1:23: reserved-name: loop variable "dict" hides the built-in global dict`,
+		`names.html:2:4: reserved-name: macro "list" hides the built-in global list`,
+		`names.html:3:4: reserved-name: imported name "namespace" hides the built-in global namespace`,
+		`names.html:3:53: reserved-name: with variable "self" hides the engine variable self`,
+		`page.html:3:4: unknown-block: block "sidebr" is not defined in any parent of "page.html" (base.html), so it renders nothing (did you mean 'sidebar'?)`,
+		`uses_broken.html:1:4: broken-include: included template "broken.html" fails to compile: `,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d:\n%s", len(expected), len(got), strings.Join(got, "\n"))
+	}
+	for i := range expected {
+		if !strings.HasPrefix(got[i], expected[i]) {
+			t.Errorf("Expected %q, got %q", expected[i], got[i])
+		}
+	}
+	if diagnostics[1].Code != miya.DiagnosticUnusedMacro || diagnostics[1].Template != "forms.html" || diagnostics[1].Line != 3 {
+		t.Errorf("fields: got %+v", diagnostics[1])
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		env := helpers.CreateLoaderEnvironment(map[string]string{
+			"base.html":  `{% block body %}{% endblock %}{% macro helper() %}{% endmacro %}`,
+			"child.html": `{% extends "base.html" %}{% block body %}{{ helper() }}{% endblock %}`,
+			"lib.html":   `{% macro shout(s) %}{{ s|upper }}{% endmacro %}`,
+			"user.html":  `{% import "lib.html" as lib %}{% set tools = lib %}{{ "x"|apply(tools.shout) }}`,
+		})
+		diagnostics, err := env.Lint()
+		if err != nil || len(diagnostics) != 0 {
+			t.Errorf("expected no diagnostics, got %v, %v", diagnostics, err)
+		}
+	})
+}
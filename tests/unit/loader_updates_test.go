@@ -0,0 +1,160 @@
+package miya_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestStringLoaderUpdatesInvalidateEnvironment(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<header>v1</header>{% block content %}{% endblock %}`)
+	stringLoader.AddTemplate("child.html", `{% extends "base.html" %}{% block content %}body{% endblock %}`)
+	stringLoader.AddTemplate("macros.html", `{% macro greet() %}hello{% endmacro %}`)
+	stringLoader.AddTemplate("page.html", `{% import "macros.html" as m %}{{ m.greet() }}`)
+
+	env := miya.NewEnvironment(
+		miya.WithLoader(stringLoader),
+		miya.WithAutoEscape(false),
+	)
+
+	render := func(name string) string {
+		t.Helper()
+		result, err := env.RenderTemplate(name, miya.NewContext())
+		if err != nil {
+			t.Fatalf("render %s failed: %v", name, err)
+		}
+		return result
+	}
+
+	t.Run("ExtendedParent", func(t *testing.T) {
+		if result := render("child.html"); result != "<header>v1</header>body" {
+			t.Fatalf("unexpected initial render: %q", result)
+		}
+
+		if err := stringLoader.UpdateTemplate("base.html", `<header>v2</header>{% block content %}{% endblock %}`); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+
+		if result := render("child.html"); result != "<header>v2</header>body" {
+			t.Errorf("expected child to re-render with new parent, got %q", result)
+		}
+	})
+
+	t.Run("ImportedTemplate", func(t *testing.T) {
+		if result := render("page.html"); result != "hello" {
+			t.Fatalf("unexpected initial render: %q", result)
+		}
+
+		stringLoader.AddTemplate("macros.html", `{% macro greet() %}goodbye{% endmacro %}`)
+
+		if result := render("page.html"); result != "goodbye" {
+			t.Errorf("expected imported macro to be reloaded, got %q", result)
+		}
+	})
+
+	t.Run("RemovedTemplate", func(t *testing.T) {
+		stringLoader.AddTemplate("gone.html", `here`)
+		render("gone.html")
+
+		stringLoader.RemoveTemplate("gone.html")
+
+		if _, err := env.GetTemplate("gone.html"); err == nil {
+			t.Error("expected removed template to be unavailable")
+		}
+	})
+
+	t.Run("ConcurrentUpdates", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				stringLoader.AddTemplate("base.html", fmt.Sprintf(`<header>%d</header>{%% block content %%}{%% endblock %%}`, i))
+			}(i)
+			go func() {
+				defer wg.Done()
+				if _, err := env.RenderTemplate("child.html", miya.NewContext()); err != nil {
+					t.Errorf("concurrent render failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// countingLoader is a string loader counting its active subscriptions
+type countingLoader struct {
+	*loader.StringLoader
+	active atomic.Int64
+}
+
+func newCountingLoader() *countingLoader {
+	return &countingLoader{StringLoader: loader.NewStringLoader(loader.NewDirectTemplateParser())}
+}
+
+func (c *countingLoader) Subscribe(fn func(name string)) func() {
+	c.active.Add(1)
+	unsubscribe := c.StringLoader.Subscribe(fn)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.active.Add(-1)
+			unsubscribe()
+		})
+	}
+}
+
+func TestLoaderSubscriptions(t *testing.T) {
+	t.Run("SameLoaderTwice", func(t *testing.T) {
+		counting := newCountingLoader()
+		env := miya.NewEnvironment(miya.WithLoader(counting))
+		env.SetLoader(counting)
+		if active := counting.active.Load(); active != 1 {
+			t.Errorf("Expected 1 subscription, got %d", active)
+		}
+	})
+
+	t.Run("ReplacedLoader", func(t *testing.T) {
+		first, second := newCountingLoader(), newCountingLoader()
+		first.AddTemplate("page.html", "first")
+		second.AddTemplate("page.html", "second")
+		env := miya.NewEnvironment(miya.WithLoader(first))
+
+		env.SetLoader(second)
+		if active := first.active.Load(); active != 0 {
+			t.Errorf("Expected the replaced loader to have no subscription, got %d", active)
+		}
+		if active := second.active.Load(); active != 1 {
+			t.Errorf("Expected 1 subscription to the new loader, got %d", active)
+		}
+
+		env.SetLoader(nil)
+		if active := second.active.Load(); active != 0 {
+			t.Errorf("Expected no subscription after removing the loader, got %d", active)
+		}
+	})
+
+	t.Run("CollectedEnvironment", func(t *testing.T) {
+		counting := newCountingLoader()
+		func() {
+			env := miya.NewEnvironment(miya.WithLoader(counting))
+			runtime.KeepAlive(env)
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for counting.active.Load() != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the subscription of a collected environment to end")
+			}
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
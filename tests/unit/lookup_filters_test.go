@@ -0,0 +1,106 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+type lookupAddress struct {
+	City string
+	Tags []string
+}
+
+type lookupUser struct {
+	Name    string
+	Address *lookupAddress
+	Meta    map[string]interface{}
+	secret  string
+}
+
+func (u lookupUser) Reveal() string { return u.secret }
+
+func TestLookupFilters(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	user := lookupUser{
+		Name:    "Ann",
+		Address: &lookupAddress{City: "Lisbon", Tags: []string{"home", "billing"}},
+		Meta:    map[string]interface{}{"team": map[string]interface{}{"name": "core"}, "a.b": "dotted"},
+		secret:  "hidden",
+	}
+	vars := map[string]interface{}{
+		"user":   user,
+		"config": map[string]interface{}{"server": map[string]interface{}{"host": "localhost", "ports": []interface{}{80, 443}}, "server.host": "flat"},
+		"labels": map[string]string{"ok": "fine"},
+		"rows": []interface{}{
+			map[string]interface{}{"id": 1, "name": "a"},
+			map[string]interface{}{"id": 2, "name": "b"},
+		},
+		"users":  []interface{}{user, &lookupUser{Name: "Bob"}},
+		"column": "name",
+		"items":  []interface{}{"x", "y", "z"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"struct field", `{{ user|attr("Name") }} {{ user|attr("name") }}`, "Ann Ann"},
+		{"struct path", `{{ user|attr("address.city") }} {{ user|attr("Address.Tags.1") }}`, "Lisbon billing"},
+		{"map path", `{{ config|attr("server.host") }} {{ config|attr("server.ports.0") }}`, "localhost 80"},
+		{"mixed nesting", `{{ user|attr("meta.team.name") }}`, "core"},
+		{"typed map", `{{ labels|attr("ok") }}`, "fine"},
+		{"missing attribute", `[{{ user|attr("address.zip") }}] {{ user|attr("address.zip") is defined }}`, "[] false"},
+		{"default", `{{ user|attr("address.zip", "none") }} {{ user|attr("nickname", default="n/a") }}`, "none n/a"},
+		{"default for a found value", `{{ user|attr("name", "x") }}`, "Ann"},
+		{"methods aren't attributes", `{{ user|attr("Reveal") is defined }} {{ user|attr("secret") is defined }}`, "false false"},
+		{"undefined input", `[{{ nothing|attr("a.b") }}] {{ nothing|attr("a", "fallback") }} {{ nothing|getitem(0, "fallback") }}`, "[] fallback fallback"},
+		{"nil pointer in path", `{{ users[1]|attr("address.city", "unknown") }}`, "unknown"},
+		{"getitem map", `{{ config|getitem("server.host") }} {{ user.Meta|getitem("a.b") }}`, "flat dotted"},
+		{"getitem list", `{{ items|getitem(0) }} {{ items|getitem(-1) }} {{ items|getitem(5) is defined }}`, "x z false"},
+		{"getitem default", `{{ items|getitem(5, "none") }} {{ config|getitem("port", default=8080) }}`, "none 8080"},
+		{"map attr", `{{ rows|map("attr", column)|join(",") }} {{ users|map("attr", "address.city", "-")|join(",") }}`, "a,b Lisbon,-"},
+		{"map getitem", `{{ rows|map("getitem", "id")|join(",") }}`, "1,2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("strict undefined", func(t *testing.T) {
+		strict := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		if _, err := strict.RenderString(`{{ user|attr("address.zip") }}`, miya.NewContextFrom(vars)); err == nil || !strings.Contains(err.Error(), "address.zip") {
+			t.Errorf("missing attribute: got %v", err)
+		}
+		if _, err := strict.RenderString(`{{ items|getitem(7) }}`, miya.NewContextFrom(vars)); err == nil {
+			t.Error("missing item: expected an error")
+		}
+		result, err := strict.RenderString(`{{ user|attr("address.zip", "none") }} {{ items|getitem(7, default="none") }}`, miya.NewContextFrom(vars))
+		if err != nil || result != "none none" {
+			t.Errorf("defaults: got %q, %v", result, err)
+		}
+	})
+
+	t.Run("arguments", func(t *testing.T) {
+		for _, source := range []string{
+			`{{ user|attr }}`,
+			`{{ user|attr("a", "b", "c") }}`,
+			`{{ user|attr("a", "b", default="c") }}`,
+			`{{ items|getitem(0, fallback="c") }}`,
+		} {
+			if _, err := env.RenderString(source, miya.NewContextFrom(vars)); err == nil {
+				t.Errorf("%s: expected an error", source)
+			}
+		}
+	})
+}
@@ -140,6 +140,151 @@ ODD:{{ item }}
 	}
 }
 
+// loop.cycle keeps a position per call site that advances only when called
+func TestLoopCycle(t *testing.T) {
+	env := miya.NewEnvironment()
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "single call",
+			template: `{% for x in range(5) %}{{ loop.cycle("a", "b") }}{% endfor %}`,
+			expected: "ababa",
+		},
+		{
+			name:     "two calls are independent",
+			template: `{% for x in range(4) %}{{ loop.cycle("a", "b") }}{{ loop.cycle("x", "y", "z") }}{% endfor %}`,
+			expected: "axbyazbx",
+		},
+		{
+			name:     "same arguments at two sites",
+			template: `{% for x in range(3) %}{{ loop.cycle("a", "b") }}{{ loop.cycle("a", "b") }};{% endfor %}`,
+			expected: "aa;bb;aa;",
+		},
+		{
+			name:     "skipped by a condition",
+			template: `{% for x in range(6) %}{% if x is odd %}{{ loop.cycle("a", "b") }}{% endif %}{% endfor %}`,
+			expected: "aba",
+		},
+		{
+			name:     "fresh position for each loop",
+			template: `{% for x in range(3) %}{{ loop.cycle("a", "b") }}{% endfor %}|{% for x in range(3) %}{{ loop.cycle("a", "b") }}{% endfor %}`,
+			expected: "aba|aba",
+		},
+		{
+			name:     "nested loop restarts per outer iteration",
+			template: `{% for x in range(2) %}{% for y in range(3) %}{{ loop.cycle("a", "b") }}{% endfor %};{% endfor %}`,
+			expected: "aba;aba;",
+		},
+		{
+			name:     "changed is unaffected",
+			template: `{% for x in [1, 1, 2] %}{% if loop.changed(x) %}{{ loop.cycle("a", "b") }}{% endif %}{% endfor %}`,
+			expected: "ab",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := env.RenderString(test.template, miya.NewContext())
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+
+	t.Run("no arguments", func(t *testing.T) {
+		if _, err := env.RenderString(`{% for x in range(2) %}{{ loop.cycle() }}{% endfor %}`, miya.NewContext()); err == nil {
+			t.Error("expected an error for loop.cycle() without arguments")
+		}
+	})
+}
+
+// loop.parent exposes the enclosing loop, and previtem/nextitem follow the
+// filtered sequence at every level
+func TestLoopParentAndFilteredItems(t *testing.T) {
+	env := miya.NewEnvironment()
+	data := map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{"name": "g1", "items": []interface{}{1, 2, 3, 4}},
+			map[string]interface{}{"name": "g2", "items": []interface{}{5, 6}},
+		},
+		"tree": []interface{}{
+			map[string]interface{}{"name": "a", "ok": true, "children": []interface{}{
+				map[string]interface{}{"name": "a1", "ok": false},
+				map[string]interface{}{"name": "a2", "ok": true},
+				map[string]interface{}{"name": "a3", "ok": true},
+			}},
+			map[string]interface{}{"name": "b", "ok": false},
+			map[string]interface{}{"name": "c", "ok": true},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "parent index",
+			template: `{% for x in [1, 2] %}{% for y in [1, 2] %}{{ loop.parent.index }}{{ loop.index }} {% endfor %}{% endfor %}`,
+			expected: "11 12 21 22 ",
+		},
+		{
+			name:     "parentloop alias",
+			template: `{% for x in [1, 2] %}{% for y in [1] %}{{ loop.parentloop.last }}{% endfor %}{% endfor %}`,
+			expected: "falsetrue",
+		},
+		{
+			name:     "grandparent",
+			template: `{% for x in "ab" %}{% for y in [1] %}{% for z in [1] %}{{ loop.parent.parent.previtem }}{% endfor %}{% endfor %}{% endfor %}`,
+			expected: "a",
+		},
+		{
+			name:     "no parent at top level",
+			template: `{% if loop is undefined %}none{% endif %}{% for x in [1] %}{{ loop.parent is defined }}{% endfor %}`,
+			expected: "nonefalse",
+		},
+		{
+			name:     "parent with filtered outer loop",
+			template: `{% for g in groups if g.name != "g1" %}{% for i in g.items if i is even %}{{ loop.parent.index }}/{{ loop.parent.length }}:{{ i }}{% if not loop.last %},{% endif %}{% endfor %}{% endfor %}`,
+			expected: "1/1:6",
+		},
+		{
+			name:     "filtered neighbours",
+			template: `{% for g in groups %}{% for i in g.items if i is odd %}{{ loop.previtem }}<{{ i }}>{{ loop.nextitem }}{% if loop.last %};{% endif %}{% endfor %}{% endfor %}`,
+			expected: "<1>31<3>;<5>;",
+		},
+		{
+			name:     "filtered recursion",
+			template: `{% for n in tree if n.ok recursive %}{{ n.name }}{{ loop.depth }}[{{ loop.previtem.name }}|{{ loop.nextitem.name }}]{% if n.children %}({{ loop(n.children) }}){% endif %}{% endfor %}`,
+			expected: "a1[|c](a22[|a3]a32[a2|])c1[a|]",
+		},
+		{
+			name:     "parent in recursion",
+			template: `{% for n in tree if n.ok recursive %}{% if loop.parent %}{{ loop.parent.nextitem.name }}/{% endif %}{{ n.name }} {% if n.children %}{{ loop(n.children) }}{% endif %}{% endfor %}`,
+			expected: "a c/a2 c/a3 c ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := env.RenderString(test.template, miya.NewContextFrom(data))
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
 // Loop Control Tests (break/continue)
 func TestLoopBreak(t *testing.T) {
 	env := miya.NewEnvironment()
@@ -315,6 +460,92 @@ func TestVariableUnpackingInForLoops(t *testing.T) {
 	}
 }
 
+// For/Else Tests
+func TestForElse(t *testing.T) {
+	env := miya.NewEnvironment()
+
+	tree := []map[string]interface{}{
+		{"name": "a", "children": []map[string]interface{}{
+			{"name": "b", "children": []map[string]interface{}{}},
+		}},
+		{"name": "c"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "empty sequence",
+			template: "{% for i in items %}{{ i }}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{}},
+			expected: "none",
+		},
+		{
+			name:     "condition rejects every item",
+			template: "{% for i in items if i > 5 %}{{ i }}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{1, 2, 3}},
+			expected: "none",
+		},
+		{
+			name:     "condition keeps an item",
+			template: "{% for i in items if i > 2 %}{{ i }}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{1, 2, 3}},
+			expected: "3",
+		},
+		{
+			name:     "break after output",
+			template: "{% for i in items %}{{ i }}{% break %}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{1, 2, 3}},
+			expected: "1",
+		},
+		{
+			name:     "break before output",
+			template: "{% for i in items %}{% break %}{{ i }}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{1, 2, 3}},
+			expected: "",
+		},
+		{
+			name:     "iterations without output",
+			template: "{% for i in items %}{% continue %}{% else %}none{% endfor %}",
+			data:     map[string]interface{}{"items": []int{1, 2, 3}},
+			expected: "",
+		},
+		{
+			name:     "recursive loop",
+			template: "{% for node in tree recursive %}[{{ node.name }}{{ loop(node.children) }}]{% else %}empty{% endfor %}",
+			data:     map[string]interface{}{"tree": tree},
+			expected: "[a[b]][c]",
+		},
+		{
+			name:     "empty recursive loop",
+			template: "{% for node in tree recursive %}[{{ node.name }}{{ loop(node.children) }}]{% else %}empty{% endfor %}",
+			data:     map[string]interface{}{"tree": []interface{}{}},
+			expected: "empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := env.FromString(test.template)
+			if err != nil {
+				t.Fatalf("Failed to parse template: %v", err)
+			}
+
+			result, err := tmpl.Render(miya.NewContextFrom(test.data))
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
 // Recursive For Loops Tests
 func TestRecursiveForLoops(t *testing.T) {
 	env := miya.NewEnvironment()
@@ -0,0 +1,183 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+// yamlShaped returns v with its maps turned into map[interface{}]interface{},
+// as gopkg.in/yaml.v2 decodes documents
+func yamlShaped(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[key] = yamlShaped(value)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = yamlShaped(item)
+		}
+		return list
+	}
+	return v
+}
+
+func TestMapKeys(t *testing.T) {
+	t.Run("ProductsPage", func(t *testing.T) {
+		env, ctx := productsPage(t)
+		want, err := env.RenderTemplate("products.html", ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		products, _ := ctx.Get("products")
+		title, _ := ctx.Get("title")
+		now, _ := ctx.Get("current_time")
+		got, err := env.RenderTemplate("products.html", miya.NewContextFrom(map[string]interface{}{
+			"title":        title,
+			"current_time": now,
+			"products":     yamlShaped(products),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("YAML-shaped products rendered differently:\n%s", got)
+		}
+		for _, fragment := range []string{"<h3>Accessories (3)</h3>", "<h4>Laptop Pro (#1)</h4>", "In stock: 4", "Average price: $334.99"} {
+			if !strings.Contains(got, fragment) {
+				t.Errorf("missing %q in:\n%s", fragment, got)
+			}
+		}
+	})
+
+	config := yamlShaped(map[string]interface{}{
+		"server": map[string]interface{}{
+			"host":  "example.com",
+			"port":  8080,
+			"hosts": []interface{}{"a.example.com", "b.example.com"},
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "ann", "admin": true},
+			map[string]interface{}{"name": "bob", "admin": false},
+		},
+	}).(map[interface{}]interface{})
+	config["codes"] = map[interface{}]interface{}{404: "Not Found", int64(500): "Server Error"}
+	config["flags"] = map[interface{}]interface{}{true: "on", false: "off"}
+	config["ratios"] = map[interface{}]interface{}{0.5: "half"}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"nested attribute", "{{ config.server.host }}:{{ config.server.port }}", "example.com:8080"},
+		{"nested item", `{{ config["server"]["hosts"][1] }}`, "b.example.com"},
+		{"int key", "{{ config.codes[404] }}", "Not Found"},
+		{"int key as text", `{{ config.codes["404"] }}`, "Not Found"},
+		{"int64 key", "{{ config.codes[500] }}", "Server Error"},
+		{"bool key", "{{ config.flags[true] }}/{{ config.flags[false] }}", "on/off"},
+		{"float key", "{{ config.ratios[0.5] }}", "half"},
+		{"missing key", "{{ config.server.missing is defined }}", "false"},
+		{"items", "{% for k, v in config.server.items() %}{{ k }};{% endfor %}", "host;hosts;port;"},
+		{"keys", "{{ config.codes.keys()|list|length }}", "2"},
+		{"get", `{{ config.server.get("scheme", "https") }}`, "https"},
+		{"in", `{{ "server" in config }}`, "true"},
+		{"attribute filters", `{{ config.users|selectattr("admin")|map(attribute="name")|join }}`, "ann"},
+		{"attr filter", `{{ config|attr("server.port") }}`, "8080"},
+		{"int map", "{{ ports[443] }}/{{ ports.get(80) }}", "https/http"},
+		{"string map", "{{ headers.Accept }}", "text/html"},
+	}
+
+	env := miya.NewEnvironment()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(map[string]interface{}{
+				"config":  config,
+				"ports":   map[int]string{80: "http", 443: "https"},
+				"headers": map[string]string{"Accept": "text/html"},
+			}))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveMapKeys(t *testing.T) {
+	vars := map[string]interface{}{
+		"headers": map[string]string{"Content-Type": "text/html", "Accept": "*/*"},
+		"user":    map[string]interface{}{"Name": "Ann", "name": "ann", "Email": "ann@example.com"},
+		"config":  yamlShaped(map[string]interface{}{"Server": map[string]interface{}{"Port": 8080}}),
+		"dict":    map[string]interface{}{"Env": "prod"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"string map item", `{{ headers["content-type"] }}`, "text/html"},
+		{"string map attribute", "{{ headers.accept }}/{{ headers.ACCEPT }}", "*/*/*/*"},
+		{"exact key first", "{{ user.name }}/{{ user.Name }}/{{ user.NAME }}", "ann/Ann/Ann"},
+		{"attribute", "{{ user.email }}", "ann@example.com"},
+		{"interface keys", `{{ config.server.port }}/{{ config["SERVER"]["PORT"] }}`, "8080/8080"},
+		{"defined", "{{ user.EMAIL is defined }}/{{ user.phone is defined }}", "true/false"},
+		{"dict methods first", "{{ user.keys()|list|length }}", "3"},
+		{"template dicts match exactly", `{% set d = {"Env": "prod"} %}[{{ d.env }}]`, "[]"},
+	}
+
+	env := miya.NewEnvironment(miya.WithCaseInsensitiveMapKeys(true), miya.WithStrictUndefined(false))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		strict := miya.NewEnvironment(miya.WithCaseInsensitiveMapKeys(true), miya.WithStrictUndefined(true))
+		result, err := strict.RenderString(`{{ headers["content-type"] }} {{ dict.env }}`, miya.NewContextFrom(vars))
+		if err != nil || result != "text/html prod" {
+			t.Errorf("Expected %q, got %q, %v", "text/html prod", result, err)
+		}
+		if _, err := strict.RenderString("{{ headers.referer }}", miya.NewContextFrom(vars)); err == nil {
+			t.Error("Expected an error for a key missing in any case")
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		result, err := miya.NewEnvironment().RenderString(`[{{ headers["content-type"] }}{{ user.email }}]`, miya.NewContextFrom(vars))
+		if err != nil || result != "[]" {
+			t.Errorf("Expected %q, got %q, %v", "[]", result, err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		overlay := miya.NewEnvironment(miya.WithCaseInsensitiveMapKeys(true)).Overlay()
+		result, err := overlay.RenderString(`{{ headers["content-type"] }}`, miya.NewContextFrom(vars))
+		if err != nil || result != "text/html" {
+			t.Errorf("Expected %q, got %q, %v", "text/html", result, err)
+		}
+	})
+
+	t.Run("macros", func(t *testing.T) {
+		result, err := env.RenderString(`{% macro ct(h) %}{{ h["content-type"] }}{% endmacro %}{{ ct(headers) }}`, miya.NewContextFrom(vars))
+		if err != nil || result != "text/html" {
+			t.Errorf("Expected %q, got %q, %v", "text/html", result, err)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestMarkupJoinAndConcat(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"JoinMixed", `{{ parts|join(" | ") }}`, "<b>hi</b> | &lt;script&gt;x&lt;/script&gt;"},
+		{"JoinEscapesSeparator", `{{ parts|join("<br>") }}`, "<b>hi</b>&lt;br&gt;&lt;script&gt;x&lt;/script&gt;"},
+		{"JoinSafeSeparator", `{{ names|join("<br>"|safe) }}`, "&lt;ann&gt;<br>bob"},
+		{"JoinUnsafe", `{{ names|join("<br>") }}`, "&lt;ann&gt;&lt;br&gt;bob"},
+		{"JoinIsSafe", `{{ parts|join is escaped }} {{ names|join is escaped }}`, "true false"},
+		{"JoinAttribute", `{{ links|join(", ", "html") }}`, `<a href="/">home</a>, &lt;i&gt;`},
+		{"Concat", `{{ "<" ~ bold }} {{ bold ~ "<" }} {{ "<" ~ "b" }}`, "&lt;<b>x</b> <b>x</b>&lt; &lt;b"},
+		{"ConcatChain", `{{ "<p>"|safe ~ name ~ "</p>"|safe }}`, "<p>&lt;ann&gt;</p>"},
+		{"ReplaceInSafe", `{{ bold|replace("x", name) }}`, "<b>&lt;ann&gt;</b>"},
+		{"ReplaceWithSafe", `{{ name|replace("ann", "<i>ann</i>"|safe) }}`, "&lt;<i>ann</i>&gt;"},
+		{"ReplacePlain", `{{ name|replace("ann", "<i>") }}`, "&lt;&lt;i&gt;&gt;"},
+		{"FormatSafe", `{{ "<p>%s: %d</p>"|safe|format(name, 3) }}`, "<p>&lt;ann&gt;: 3</p>"},
+		{"FormatSafeArgument", `{{ "<p>%s</p>"|safe|format(bold) }}`, "<p><b>x</b></p>"},
+		{"FormatPlain", `{{ "<p>%s</p>"|format(name) }}`, "&lt;p&gt;&lt;ann&gt;&lt;/p&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("parts", []interface{}{miya.Safe("<b>hi</b>"), "<script>x</script>"})
+			ctx.Set("names", []string{"<ann>", "bob"})
+			ctx.Set("links", []interface{}{
+				map[string]interface{}{"html": miya.Safe(`<a href="/">home</a>`)},
+				map[string]interface{}{"html": "<i>"},
+			})
+			ctx.Set("bold", miya.Safe("<b>x</b>"))
+			ctx.Set("name", "<ann>")
+			out, err := miya.NewEnvironment(miya.WithAutoEscape(true)).RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	// Without autoescaping nothing is escaped, safe or not
+	ctx := miya.NewContext()
+	ctx.Set("parts", []interface{}{miya.Safe("<b>hi</b>"), "<script>x</script>"})
+	out, err := miya.NewEnvironment(miya.WithAutoEscape(false)).RenderString(`{{ parts|join(" | ") }} {{ "<" ~ parts[0] }}`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "<b>hi</b> | <script>x</script> <<b>hi</b>" {
+		t.Errorf("got %q", out)
+	}
+}
@@ -0,0 +1,126 @@
+package miya_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+type apiUser struct {
+	Name      string
+	AvatarURL string
+}
+
+type userAPI struct {
+	users map[int]apiUser
+	calls int
+}
+
+var errNoUser = errors.New("no such user")
+
+func (a userAPI) GetUser(id int) (apiUser, error) {
+	user, ok := a.users[id]
+	if !ok {
+		return apiUser{}, errNoUser
+	}
+	return user, nil
+}
+
+func (a userAPI) HTMLTitle() string { return "Users" }
+
+// Count has a pointer receiver, so it isn't in the method set of userAPI
+func (a *userAPI) Count() int {
+	a.calls++
+	return len(a.users)
+}
+
+type auditLog struct{}
+
+func (auditLog) Entries() []string { return []string{"login"} }
+
+func newUserAPI() userAPI {
+	return userAPI{users: map[int]apiUser{42: {Name: "Ada", AvatarURL: "/ada.png"}}}
+}
+
+func TestMethodCalls(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	render := func(source string, api interface{}) (string, error) {
+		ctx := miya.NewContext()
+		ctx.Set("api", api)
+		return env.RenderString(source, ctx)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`{{ api.GetUser(42).Name }}`, "Ada"},
+		{`{{ api.get_user(42).name }}`, "Ada"},
+		{`{{ api.getUser(42).avatar_url }}`, "/ada.png"},
+		{`{{ api.html_title() }}`, "Users"},
+		{`{{ api.count() }}`, "1"},
+		{`{{ api.get_user is defined }}|{{ api.count is defined }}|{{ api.delete_user is defined }}`, "true|true|false"},
+	}
+	for _, tt := range tests {
+		for _, api := range []interface{}{newUserAPI(), &userAPI{users: newUserAPI().users}} {
+			if got, err := render(tt.source, api); err != nil || got != tt.want {
+				t.Errorf("%s with %T: got %q, %v; want %q", tt.source, api, got, err, tt.want)
+			}
+		}
+	}
+
+	t.Run("ErrorReturn", func(t *testing.T) {
+		_, err := render(`{{ api.get_user(7).name }}`, newUserAPI())
+		if !errors.Is(err, errNoUser) {
+			t.Errorf("expected the method's error, got %v", err)
+		}
+	})
+
+	t.Run("PointerReceiverOnCopy", func(t *testing.T) {
+		api := newUserAPI()
+		if _, err := render(`{{ api.count() }}`, api); err != nil {
+			t.Fatal(err)
+		}
+		if api.calls != 0 {
+			t.Errorf("a method called on a value changed the caller's copy")
+		}
+	})
+}
+
+func TestRegisterSafeType(t *testing.T) {
+	render := func(env *miya.Environment, source string) (string, error) {
+		ctx := miya.NewContext()
+		ctx.Set("api", newUserAPI())
+		ctx.Set("log", &auditLog{})
+		return env.RenderString(source, ctx)
+	}
+
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	env.RegisterSafeType(reflect.TypeOf(userAPI{}))
+	if got, err := render(env, `{{ api.get_user(42).name }} {{ api.count() }}`); err != nil || got != "Ada 1" {
+		t.Errorf("safe type: got %q, %v", got, err)
+	}
+
+	_, err := render(env, `{{ log.entries()|join }}`)
+	var rtErr *runtime.RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Type != runtime.ErrorTypeAccess || !strings.Contains(err.Error(), "calling method 'entries' of *miya_test.auditLog is not allowed") {
+		t.Errorf("expected an access error for an unregistered type, got %v", err)
+	}
+	// apiUser isn't registered, but reading fields is always allowed
+	if got, err := render(env, `{{ api.get_user(42).avatar_url }}`); err != nil || got != "/ada.png" {
+		t.Errorf("field of an unregistered type: got %q, %v", got, err)
+	}
+
+	overlay := env.Overlay()
+	overlay.RegisterSafeType(reflect.TypeOf(&auditLog{}))
+	if got, err := render(overlay, `{{ api.html_title() }} {{ log.entries()|join }}`); err != nil || got != "Users login" {
+		t.Errorf("overlay: got %q, %v", got, err)
+	}
+	if _, err := render(env, `{{ log.entries()|join }}`); err == nil {
+		t.Error("a type registered on an overlay was allowed on its parent")
+	}
+}
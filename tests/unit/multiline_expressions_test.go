@@ -0,0 +1,88 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestMultilineExpressions(t *testing.T) {
+	users := []interface{}{
+		map[string]interface{}{"name": "Grace", "active": true, "age": 45},
+		map[string]interface{}{"name": "Ada", "active": true, "age": 36},
+		map[string]interface{}{"name": "Linus", "active": false, "age": 54},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			"Pipeline",
+			`{{ users
+     | selectattr("active")
+     | map("name")
+     | sort
+     | join(", ") }}`,
+			"Ada, Grace",
+		},
+		{
+			"IfCondition",
+			`{% if users | selectattr("active") | list | length > 1
+      and users | length == 3 %}many{% endif %}`,
+			"many",
+		},
+		{
+			"CallArguments",
+			`{{ "%s is %d"|format(
+    users[0].name,
+    users[0].age,
+) }}`,
+			"Grace is 45",
+		},
+		{
+			"NestedBrackets",
+			`{% set ages = [
+  (users[0].age
+    + 1),
+  users[1]
+    .age,
+] %}{{ ages|sum }}`,
+			"82",
+		},
+		{
+			"ForLoop",
+			`{% for user in users
+      if user.active
+%}{{ user.name }};{% endfor %}`,
+			"Grace;Ada;",
+		},
+		{
+			"TrimMarkers",
+			"<p>\n  {{- users[1].name\n  -}}\n</p>{% if true\n-%}\n  !\n{%- endif %}",
+			"<p>Ada</p>!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{"users": users})
+			out, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("Unclosed", func(t *testing.T) {
+		_, err := miya.NewEnvironment().RenderString("<ul>\n{% for user in users\n  if user.active\n<li>{{ user }}</li>", miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "unclosed tag '{%', expected '%}' at line 2, column 1") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
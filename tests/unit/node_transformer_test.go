@@ -0,0 +1,198 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// timedInclude wraps an include, as a transformer adding instrumentation would
+type timedInclude struct {
+	*parser.IncludeNode
+}
+
+func (t *timedInclude) FastEval(e *runtime.DefaultEvaluator, ctx runtime.Context) (interface{}, error) {
+	out, err := e.EvalNode(t.IncludeNode, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("<timed>%v</timed>", out), nil
+}
+
+// sourceParser parses with parser.Parse, keeping comments, and can reuse ASTs
+// the way caching loaders do
+type sourceParser struct {
+	cache map[string]*parser.TemplateNode
+}
+
+func (p *sourceParser) ParseTemplate(name, content string) (*parser.TemplateNode, error) {
+	if ast, ok := p.cache[name]; ok {
+		return ast, nil
+	}
+	ast, err := parser.Parse(name, content)
+	if err == nil && p.cache != nil {
+		p.cache[name] = ast
+	}
+	return ast, err
+}
+
+func TestNodeTransformers(t *testing.T) {
+	t.Run("RunInOrderOncePerTemplate", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(loader.NewStringLoader(loader.NewDirectTemplateParser())))
+		var calls []string
+		for _, id := range []string{"first", "second"} {
+			id := id
+			env.AddNodeTransformer(func(node parser.Node) (parser.Node, error) {
+				calls = append(calls, id+":"+node.(*parser.TemplateNode).Name)
+				return node, nil
+			})
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := env.RenderString("{{ x }}", miya.NewContext()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := strings.Join(calls, ","); got != "first:<string>,second:<string>" {
+			t.Errorf("calls = %s", got)
+		}
+	})
+
+	t.Run("RewriteLiterals", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		env.AddNodeTransformer(func(node parser.Node) (parser.Node, error) {
+			return parser.Transform(node, func(n parser.Node) (parser.Node, error) {
+				if lit, ok := n.(*parser.LiteralNode); ok {
+					if s, ok := lit.Value.(string); ok && strings.HasPrefix(s, "/static/") {
+						lit.Value = "https://cdn.example.com" + s
+					}
+				}
+				return n, nil
+			})
+		})
+
+		out, err := env.RenderString(`<img src="{{ "/static/logo.png" }}">`, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `<img src="https://cdn.example.com/static/logo.png">` {
+			t.Errorf("got %s", out)
+		}
+	})
+
+	t.Run("WrapIncludes", func(t *testing.T) {
+		templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		templates.AddTemplate("page.html", `a{% include "part.html" %}b`)
+		templates.AddTemplate("part.html", `part`)
+		env := miya.NewEnvironment(miya.WithLoader(templates))
+		env.AddNodeTransformer(func(node parser.Node) (parser.Node, error) {
+			return parser.Transform(node, func(n parser.Node) (parser.Node, error) {
+				if include, ok := n.(*parser.IncludeNode); ok {
+					return &timedInclude{include}, nil
+				}
+				return n, nil
+			})
+		})
+
+		out, err := env.RenderTemplate("page.html", miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "a<timed>part</timed>b" {
+			t.Errorf("got %s", out)
+		}
+	})
+
+	t.Run("ErrorsNameTemplate", func(t *testing.T) {
+		templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		templates.AddTemplate("bad.html", `x`)
+		env := miya.NewEnvironment(miya.WithLoader(templates))
+		errRejected := errors.New("rejected")
+		env.AddNodeTransformer(func(node parser.Node) (parser.Node, error) {
+			return nil, errRejected
+		})
+
+		_, err := env.GetTemplate("bad.html")
+		if !errors.Is(err, errRejected) || !strings.Contains(err.Error(), "parser error in template bad.html") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err := env.FromString("x"); !errors.Is(err, errRejected) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("StripComments", func(t *testing.T) {
+		templates := loader.NewStringLoader(&sourceParser{})
+		templates.AddTemplate("page.html", `a{# note #}b{% if x %}{# inner #}c{% endif %}`)
+		env := miya.NewEnvironment(miya.WithLoader(templates))
+		env.AddNodeTransformer(miya.StripComments)
+
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser.Walk(tmpl.GetASTAsTemplateNode(), func(n parser.Node) bool {
+			if _, ok := n.(*parser.CommentNode); ok {
+				t.Errorf("comment node left at line %d", n.Line())
+			}
+			return true
+		})
+	})
+
+	t.Run("LoaderASTNotModified", func(t *testing.T) {
+		shared := &sourceParser{cache: map[string]*parser.TemplateNode{}}
+		templates := loader.NewStringLoader(shared)
+		templates.AddTemplate("page.html", `{# note #}{{ x }}`)
+		env := miya.NewEnvironment(miya.WithLoader(templates))
+		env.AddNodeTransformer(miya.StripComments)
+
+		for i := 0; i < 2; i++ {
+			if _, err := env.GetTemplate("page.html"); err != nil {
+				t.Fatal(err)
+			}
+			env.ClearCache()
+		}
+		if len(shared.cache["page.html"].Children) != 2 {
+			t.Error("transformer modified the loader's cached AST")
+		}
+	})
+
+	t.Run("RootOnly", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		if err := env.Overlay().AddNodeTransformer(miya.StripComments); err == nil {
+			t.Error("expected an error adding a transformer to an overlay")
+		}
+	})
+}
+
+func TestConstantFolding(t *testing.T) {
+	shared := &sourceParser{cache: map[string]*parser.TemplateNode{}}
+	templates := loader.NewStringLoader(shared)
+	templates.AddTemplate("page.html", `{{ 6 * 7 }} {{ "v" ~ 2 if x else "none" }} {{ "a" + 1 }}`)
+	env := miya.NewEnvironment(miya.WithLoader(templates))
+
+	tmpl, err := env.GetTemplate("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"x": true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "42 v2 a1" {
+		t.Errorf("got %q, want %q", out, "42 v2 a1")
+	}
+
+	if got, want := parser.Print(tmpl.GetASTAsTemplateNode()), `{{ 42.0 }} {{ "v2" if x else "none" }} {{ "a" + 1 }}`; got != want {
+		t.Errorf("folded template = %s, want %s", got, want)
+	}
+	if got := parser.Print(shared.cache["page.html"]); !strings.Contains(got, "6 * 7") {
+		t.Errorf("folding modified the loader's cached AST: %s", got)
+	}
+}
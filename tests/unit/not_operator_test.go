@@ -0,0 +1,79 @@
+package miya_test
+
+import (
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+type notCents int
+
+type notPage struct{ items []string }
+
+func (p *notPage) Len() int                { return len(p.items) }
+func (p *notPage) Index(i int) interface{} { return p.items[i] }
+
+func TestNotOperator(t *testing.T) {
+	vars := map[string]interface{}{
+		"empty":   []interface{}{},
+		"full":    []interface{}{1, 0, 2},
+		"users":   []interface{}{map[string]interface{}{"name": "ann", "active": false}},
+		"text":    "",
+		"markup":  miya.Safe(""),
+		"html":    runtime.SafeString("<br>"),
+		"zero":    notCents(0),
+		"cents":   notCents(5),
+		"elapsed": time.Duration(0),
+		"nilPage": (*notPage)(nil),
+		"page":    &notPage{},
+		"names":   []string{},
+		"labels":  map[string]string{"a": "b"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		// not binds looser than filters and tests, which apply to its operand
+		{"filter", `{{ not empty|length }} {{ not full|length }}`, "true false"},
+		{"filter chain", `{{ not users|selectattr("active")|list }} {{ not users|rejectattr("active")|list }}`, "true false"},
+		{"test", `{{ not nope is defined }} {{ nope is not defined }} {{ not full is defined }}`, "true true false"},
+		{"negated test", `{{ not nope is not defined }} {{ not not nope is defined }}`, "false false"},
+		{"filter then test", `{{ not full|length is odd }} {{ not empty|length is odd }}`, "false true"},
+		{"parenthesized", `{{ (not full)|string }} {{ (not empty) is true }} {{ not (full|length > 5) }}`, "false true true"},
+		{"comparison", `{{ not 1 == 2 }} {{ not 1 in full }} {{ not empty == [] }}`, "true false false"},
+		{"logical", `{{ not empty and not text }} {{ not full or not empty }} {{ not full and full }}`, "true true false"},
+		{"conditions", `{% if not empty %}a{% endif %}{{ "b" if not text }}{% for u in users if not u.active %}{{ u.name }}{% endfor %}`, "abann"},
+		{"comprehension", `{{ [x for x in full if not x] }}`, "[0]"},
+
+		// not negates the truth of any value
+		{"collections", `{{ not empty }} {{ not full }} {{ not {} }} {{ not [] }} {{ not names }} {{ not labels }} {{ not page }}`, "true false true true true false true"},
+		{"strings", `{{ not text }} {{ not "a" }} {{ not markup }} {{ not html }} {{ not ""|safe }} {{ not "x"|e }}`, "true false true false true false"},
+		{"numbers", `{{ not 0 }} {{ not 0.0 }} {{ not -1 }} {{ not zero }} {{ not cents }} {{ not elapsed }}`, "true true false true false true"},
+		{"none and undefined", `{{ not none }} {{ not nope }} {{ not nope.attr }} {{ not nilPage }}`, "true true true true"},
+		{"same as if", `{% for v in [markup, zero, elapsed, nilPage, names, html, cents] %}{{ (not v) == (false if v else true) }} {% endfor %}`, "true true true true true true true "},
+		{"same as select", `{{ [markup, zero, elapsed, html, cents]|select|list|length }} {{ [markup, zero, elapsed, html, cents]|reject|list|length }}`, "2 3"},
+	}
+
+	env := miya.NewEnvironment()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("not is no operand", func(t *testing.T) {
+		if _, err := env.RenderString(`{{ 1 + not 0 }}`, nil); err == nil {
+			t.Error("expected a syntax error for not after an arithmetic operator")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestFloatDisplayPrecision(t *testing.T) {
+	env := miya.NewEnvironment()
+	tests := []struct {
+		template, want string
+	}{
+		{`{{ 0.1 + 0.2 }}`, "0.3"},
+		{`{{ (0.1 + 0.2)|string }}`, "0.30000000000000004"},
+		{`{{ 1 / 3 }}`, "0.333333333333333"},
+		{`{{ price * 3 }}`, "59.97"},
+		{`{{ 2.0 * 1800 }}`, "3,600"},
+		{`{{ 3.14159 }}`, "3.14159"},
+		// Whole numbers keep every digit
+		{`{{ id + 1 }} {{ id * 1 }} {{ [id, 1]|sum }}`, "123,456,789,012,346 123,456,789,012,345 123,456,789,012,346"},
+		{`{{ ts + 0 }} {{ ts - 4 }}`, "1,760,000,000,123 1,760,000,000,119"},
+		{`{{ ('{"id": 123456789012345678}'|fromjson).id + 1 }}`, "123456789012345679"},
+	}
+	for _, tt := range tests {
+		tmpl, err := env.FromString(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"price": 19.99, "id": 123456789012345, "ts": int64(1760000000123)}))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, out, tt.want)
+		}
+	}
+}
+
+func TestNumericFilterKeywordArguments(t *testing.T) {
+	env := miya.NewEnvironment()
+	tests := []struct {
+		template, want string
+	}{
+		// Banker's rounding sends halves to the even neighbour, common
+		// rounding sends them away from zero
+		{`{{ 2.5|round }} {{ 3.5|round }} {{ (-2.5)|round }}`, "3 4 -3"},
+		{`{{ 2.5|round(method="even") }} {{ 3.5|round(method="even") }} {{ (-2.5)|round(method="even") }}`, "2 4 -2"},
+		{`{{ 0.125|round(2, "even") }} {{ 0.125|round(2) }}`, "0.12 0.13"},
+		{`{{ 2.675|round(precision=2) }} {{ 1.005|round(2) }}`, "2.67 1.00"},
+		{`{{ 1234|round(-2) }}`, "1,200"},
+		{`{{ 3.14159|round(2, method="floor") }}`, "3.14"},
+		{`{{ "ff"|int(base=16) }} {{ "0x1A"|int(0, 16) }} {{ "0o17"|int(base=0) }}`, "255 26 15"},
+		{`{{ "1.5"|int }} {{ " 42 "|int }} {{ "x"|int(default=-1) }}`, "1 42 -1"},
+		{`{{ "x"|float(default=2.5) }} {{ " 1.25 "|float }}`, "2.5 1.25"},
+	}
+	for _, tt := range tests {
+		tmpl, err := env.FromString(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		out, err := tmpl.Render(miya.NewContext())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, out, tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		template, want string
+	}{
+		{`{{ 2.5|round(method="up") }}`, "round method must be"},
+		{`{{ 2.5|round(1, precision=2) }}`, "multiple values for argument 'precision'"},
+		{`{{ 2.5|round(digits=2) }}`, "unexpected keyword argument 'digits'"},
+		{`{{ 2.5|round(1, "even", 3) }}`, "at most 2 arguments"},
+		{`{{ "10"|int(base=1) }}`, "base"},
+	}
+	for _, tt := range errorTests {
+		tmpl, err := env.FromString(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		if _, err := tmpl.Render(miya.NewContext()); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.template, err, tt.want)
+		}
+	}
+}
@@ -62,6 +62,24 @@ func TestOperatorPrecedence(t *testing.T) {
 			map[string]interface{}{},
 			"true", // Should be (((1 + (2 * 3)) > 5) and true) = true
 		},
+		{
+			"test before multiplication",
+			`{{ 2 * 3 is even }} {{ (2 * 3) is even }}`,
+			map[string]interface{}{},
+			"0 true", // Should be (2 * (3 is even)) = 2 * false = 0, as in Jinja2
+		},
+		{
+			"test before addition",
+			`{{ x + 1 is odd }}`,
+			map[string]interface{}{"x": 2},
+			"3", // Should be (x + (1 is odd)) = 2 + true = 3, as in Jinja2
+		},
+		{
+			"booleans as numbers",
+			`{{ true + true }} {{ 10 - false }} {{ 7 / (1 is odd) }}`,
+			map[string]interface{}{},
+			"2 10 7",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,95 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestOrderedDict(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	env.AddGlobal("lookup", func(m map[string]interface{}, key string) interface{} { return m[key] })
+	vars := map[string]interface{}{
+		"config": map[string]interface{}{"b": 1, "a": 2},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"keyword arguments", `{% for k, v in dict(b=1, a=2, c=3).items() %}{{ k }}={{ v }};{% endfor %}`, "b=1;a=2;c=3;"},
+		{"pairs", `{{ dict([["z", 1], ["y", 2]]).keys()|join(",") }}`, "z,y"},
+		{"alternating arguments", `{{ dict("z", 1, "y", 2).values()|join(",") }}`, "1,2"},
+		{"mapping and keywords", `{{ dict(dict(b=1), a=2)|tojson }} {{ dict(config, c=3)|tojson }}`, `{"b":1,"a":2} {"a":2,"b":1,"c":3}`},
+		{"brace literal", `{% for k, v in {"b": 1, "a": 2}.items() %}{{ k }}={{ v }};{% endfor %}`, "b=1;a=2;"},
+		{"comprehension", `{{ {x: x|upper for x in ["b", "a"]}|tojson }}`, `{"b":"B","a":"A"}`},
+		{"loop over keys", `{% for k in dict(b=1, a=2) %}{{ k }};{% endfor %}`, "b;a;"},
+		{"loop over pairs", `{% for k, v in dict(b=1, a=2) %}{{ k }}={{ v }};{% endfor %}`, "b=1;a=2;"},
+		{"lookup", `{{ dict(a=1).a }} {{ dict(a=1)["a"] }} [{{ dict(a=1).b }}] {{ dict(a=1)|attr("a") }}`, "1 1 [] 1"},
+		{"membership and length", `{{ "a" in dict(a=1) }} {{ 1 in dict(a=1) }} {{ dict(b=1, a=2)|length }} {{ dict() is mapping }}`, "true false 2 true"},
+		{"filters", `{{ dict(b=1, a=2)|list|join(",") }} {{ dict(b=1, a=2)|items|list }} {{ dict(b=1, a=2)|keys|join(",") }} {{ dict(b=1, a=2)|values|join(",") }}`, "b,a [[b 1] [a 2]] b,a 1,2"},
+		{"dictsort", `{% for k, v in dict(b=1, a=2)|dictsort %}{{ k }};{% endfor %}`, "a;b;"},
+		{"tojson", `{{ dict(b=1, a={"d": none, "c": "<"})|tojson }}`, `{"b":1,"a":{"d":null,"c":"\u003c"}}`},
+		{"xmlattr", `<p{{ dict(id="x", class="y")|xmlattr }}>`, `<p id="x" class="y">`},
+		{"toyaml", `{{ dict(b=1, a=2)|toyaml }}`, "b: 1\na: 2"},
+		{"pprint", `{{ dict(b=1, a=dict(d=1, c=2))|pprint }}`, "{'b': 1, 'a': {'d': 1, 'c': 2}}"},
+		{"printing", `{{ dict(b=1, a=2) }} {{ {} }}`, "map[b:1 a:2] map[]"},
+		{"update keeps places", `{% set d = dict(b=1, a=2) %}{% do d.update(z=1, b=3) %}{% do d.update({"y": 0}) %}{{ d|tojson }}`, `{"b":3,"a":2,"z":1,"y":0}`},
+		{"pop and set", `{% set d = dict(b=1, a=2) %}{% do d.pop("b") %}{% set d.c = 3 %}{% do d.setdefault("b", 4) %}{{ d|tojson }}`, `{"a":2,"c":3,"b":4}`},
+		{"equality", `{{ dict(a=1, b=2) == dict(b=2, a=1) }} {{ {"a": 1} == config }} {{ dict(b=1, a=2) == config }}`, "true false true"},
+		{"go map stays sorted", `{% for k, v in config.items() %}{{ k }}={{ v }};{% endfor %}{{ config|tojson }}`, `a=2;b=1;{"a":2,"b":1}`},
+		{"passed to go as a map", `{{ lookup(dict(a=1), "a") }} {{ lookup({"b": 2}, "b") }}`, "1 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for source, want := range map[string]string{
+			`{{ dict(1) }}`:               "dict() requires a dict or a sequence of [key, value] pairs",
+			`{{ dict([["a", 1], [2]]) }}`: "sequence element #1 is not one",
+			`{{ dict("a", 1, "b") }}`:     "even number of arguments",
+		} {
+			if _, err := env.RenderString(source, nil); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: got %v, want an error containing %q", source, err, want)
+			}
+		}
+	})
+
+	t.Run("sandbox", func(t *testing.T) {
+		sandboxed := miya.NewSandboxedEnvironment()
+		result, err := sandboxed.RenderString(`{% set d = dict(b=1) %}{% do d.update(a=2) %}{{ d.keys()|join(",") }} {{ d.get("a") }}`, nil)
+		if err != nil || result != "b,a 2" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("Go API", func(t *testing.T) {
+		d := runtime.NewDictFromMap(map[string]interface{}{"b": 1, "a": 2})
+		d.Set("c", 3)
+		d.Set("a", 4)
+		d.Delete("b")
+		if got := strings.Join(d.Keys(), ","); got != "a,c" {
+			t.Errorf("keys: got %q", got)
+		}
+		if value, ok := d.Get("a"); !ok || value != 4 {
+			t.Errorf("get: got %v, %v", value, ok)
+		}
+		result, err := env.RenderString(`{{ d|tojson }}`, miya.NewContextFrom(map[string]interface{}{"d": d}))
+		if err != nil || result != `{"a":4,"c":3}` {
+			t.Errorf("render: got %q, %v", result, err)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestEnvironmentOverlay(t *testing.T) {
+	newEnv := func() (*miya.Environment, *loader.StringLoader) {
+		stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		stringLoader.AddTemplate("base.html", `<h1>{{ brand }}</h1>{% block content %}{% endblock %}`)
+		stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% block content %}{{ title|shout }}{% if title is short %}!{% endif %}{% endblock %}`)
+		env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+		env.AddGlobal("brand", "Acme")
+		if err := env.AddFilter("shout", func(v interface{}, args ...interface{}) (interface{}, error) {
+			return strings.ToUpper(fmt.Sprint(v)), nil
+		}); err != nil {
+			t.Fatalf("AddFilter failed: %v", err)
+		}
+		if err := env.AddTest("short", func(v interface{}, args ...interface{}) (bool, error) {
+			return len(fmt.Sprint(v)) < 3, nil
+		}); err != nil {
+			t.Fatalf("AddTest failed: %v", err)
+		}
+		return env, stringLoader
+	}
+
+	render := func(t *testing.T, env *miya.Environment, name string, vars map[string]interface{}) string {
+		t.Helper()
+		ctx := miya.NewContext()
+		for k, v := range vars {
+			ctx.Set(k, v)
+		}
+		result, err := env.RenderTemplate(name, ctx)
+		if err != nil {
+			t.Fatalf("render %s failed: %v", name, err)
+		}
+		return result
+	}
+
+	t.Run("ShadowAndFallback", func(t *testing.T) {
+		env, _ := newEnv()
+		tenant := env.Overlay()
+		tenant.AddGlobal("brand", "Tenant")
+		if err := tenant.AddFilter("shout", func(v interface{}, args ...interface{}) (interface{}, error) {
+			return fmt.Sprint(v) + "!!", nil
+		}); err != nil {
+			t.Fatalf("shadowing filter failed: %v", err)
+		}
+
+		vars := map[string]interface{}{"title": "hi"}
+		if got := render(t, env, "page.html", vars); got != "<h1>Acme</h1>HI!" {
+			t.Errorf("parent render = %q", got)
+		}
+		if got := render(t, tenant, "page.html", vars); got != "<h1>Tenant</h1>hi!!!" {
+			t.Errorf("overlay render = %q", got)
+		}
+		if tenant.Parent() != env || env.Parent() != nil {
+			t.Error("Parent() does not report the overlay's origin")
+		}
+	})
+
+	t.Run("ParentChangesVisible", func(t *testing.T) {
+		env, _ := newEnv()
+		tenant := env.Overlay()
+
+		env.AddGlobal("brand", "Renamed")
+		if err := env.AddFilter("whisper", func(v interface{}, args ...interface{}) (interface{}, error) {
+			return strings.ToLower(fmt.Sprint(v)), nil
+		}); err != nil {
+			t.Fatalf("AddFilter failed: %v", err)
+		}
+
+		tmpl, err := tenant.FromString(`{{ brand|whisper }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.Render(miya.NewContext()); err != nil || got != "renamed" {
+			t.Errorf("overlay did not see parent changes: %q, %v", got, err)
+		}
+	})
+
+	t.Run("OptionsApplyToOverlayOnly", func(t *testing.T) {
+		env, _ := newEnv()
+		escaped := env.Overlay(miya.WithAutoEscape(true))
+
+		tmpl := `{{ value }}`
+		ctx := miya.NewContext()
+		ctx.Set("value", "<b>")
+
+		parentTmpl, err := env.FromString(tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overlayTmpl, err := escaped.FromString(tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := parentTmpl.Render(ctx); got != "<b>" {
+			t.Errorf("parent render = %q", got)
+		}
+		if got, _ := overlayTmpl.Render(ctx); got != "&lt;b&gt;" {
+			t.Errorf("overlay render = %q", got)
+		}
+	})
+
+	t.Run("SharesParsedTemplates", func(t *testing.T) {
+		env, _ := newEnv()
+		tenant := env.Overlay()
+
+		if _, err := tenant.GetTemplate("page.html"); err != nil {
+			t.Fatal(err)
+		}
+		if size := env.GetCacheSize(); size != 1 {
+			t.Errorf("overlay load should populate the parent cache, size = %d", size)
+		}
+		if _, err := env.GetTemplate("page.html"); err != nil {
+			t.Fatal(err)
+		}
+		if size := env.GetCacheSize(); size != 1 {
+			t.Errorf("template parsed twice, parent cache size = %d", size)
+		}
+	})
+
+	t.Run("InvalidationPropagates", func(t *testing.T) {
+		env, stringLoader := newEnv()
+		tenant := env.Overlay()
+		vars := map[string]interface{}{"title": "hello"}
+
+		if got := render(t, tenant, "page.html", vars); got != "<h1>Acme</h1>HELLO" {
+			t.Fatalf("initial overlay render = %q", got)
+		}
+		if err := stringLoader.UpdateTemplate("base.html", `<h2>{{ brand }}</h2>{% block content %}{% endblock %}`); err != nil {
+			t.Fatal(err)
+		}
+		if got := render(t, tenant, "page.html", vars); got != "<h2>Acme</h2>HELLO" {
+			t.Errorf("overlay served stale template: %q", got)
+		}
+	})
+
+	t.Run("DifferentSyntaxParsesSeparately", func(t *testing.T) {
+		env, _ := newEnv()
+		trimmed := env.Overlay(miya.WithTrimBlocks(true))
+		src := "{% if true %}\nyes{% endif %}"
+
+		plain, err := env.FromString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := trimmed.FromString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := plain.Render(miya.NewContext()); got != "\nyes" {
+			t.Errorf("parent render = %q", got)
+		}
+		if got, _ := tmpl.Render(miya.NewContext()); got != "yes" {
+			t.Errorf("trimmed overlay render = %q", got)
+		}
+	})
+
+	t.Run("ExtensionsRequireRoot", func(t *testing.T) {
+		env, _ := newEnv()
+		if err := env.Overlay().AddExtension(nil); err == nil {
+			t.Error("expected an error adding an extension to an overlay")
+		}
+	})
+}
+
+func TestEnvironmentOverlayConcurrency(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `[{{ brand }}]{% block content %}{% endblock %}`)
+	stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% block content %}{{ n }}{% endblock %}`)
+
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	env.AddGlobal("brand", "root")
+
+	envs := []*miya.Environment{env}
+	for i := 0; i < 4; i++ {
+		overlay := env.Overlay()
+		overlay.AddGlobal("brand", fmt.Sprintf("tenant%d", i))
+		envs = append(envs, overlay)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i, e := range envs {
+		brand := "root"
+		if i > 0 {
+			brand = fmt.Sprintf("tenant%d", i-1)
+		}
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(e *miya.Environment, brand string) {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					ctx := miya.NewContext()
+					ctx.Set("n", n)
+					got, err := e.RenderTemplate("page.html", ctx)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if want := fmt.Sprintf("[%s]%d", brand, n); got != want {
+						errs <- fmt.Errorf("got %q, want %q", got, want)
+						return
+					}
+				}
+			}(e, brand)
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 50; n++ {
+			env.InvalidateTemplate("base.html")
+			env.ClearCache()
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
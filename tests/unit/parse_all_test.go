@@ -0,0 +1,77 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/parser"
+)
+
+func TestParseAll(t *testing.T) {
+	env := miya.NewEnvironment()
+
+	source := `<h1>{{ title | }}</h1>
+<ul>
+{% for item in items %}
+  <li>{{ item.name + }}</li>
+  {% if item.price > %}<b>sale</b>{% endif %}
+{% endfor %}
+</ul>
+{% macro card(x %}{{ x }}{% endmacro %}
+{{ "fine" }}
+{% elseif more %}
+{% block footer %}{{ year }}{% endblock %}`
+
+	ast, errs := env.ParseAll("legacy.html", source)
+	if ast == nil || ast.Name != "legacy.html" {
+		t.Fatalf("expected the partial AST, got %v", ast)
+	}
+
+	expected := []struct {
+		line    int
+		message string
+	}{
+		{1, "expected filter name after '|'"},
+		{4, "unexpected token in expression"},
+		{5, "unexpected token in expression"},
+		{8, "expected ',' or ')' in parameter list"},
+		{10, "unknown tag 'elseif', did you mean 'elif'?"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, want := range expected {
+		var syntaxErr *parser.SyntaxError
+		if !errors.As(errs[i], &syntaxErr) {
+			t.Errorf("error %d: expected a *parser.SyntaxError, got %v", i, errs[i])
+			continue
+		}
+		if syntaxErr.Line != want.line || !strings.HasPrefix(syntaxErr.Message, want.message) {
+			t.Errorf("error %d: expected %q at line %d, got %q at line %d", i, want.message, want.line, syntaxErr.Message, syntaxErr.Line)
+		}
+		if !strings.Contains(errs[i].Error(), "legacy.html") {
+			t.Errorf("error %d: expected the template name in %q", i, errs[i])
+		}
+	}
+
+	// The first error is the one compiling reports
+	if _, err := env.FromStringNamed("legacy.html", source); err == nil || err.Error() != errs[0].Error() {
+		t.Errorf("Expected %q, got %v", errs[0], err)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		ast, errs := env.ParseAll("ok.html", `{% for x in xs %}{{ x }}{% endfor %}`)
+		if len(errs) != 0 || len(ast.Children) != 1 {
+			t.Errorf("got %v, %v", ast, errs)
+		}
+	})
+
+	t.Run("lexer error", func(t *testing.T) {
+		ast, errs := env.ParseAll("bad.html", "{{ 'unterminated }}\n{{ a + }}")
+		if ast != nil || len(errs) != 1 || !strings.Contains(errs[0].Error(), "lexer error") {
+			t.Errorf("expected the lexer error alone, got %v, %v", ast, errs)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func newPostProcessorEnv(t *testing.T) *miya.Environment {
+	t.Helper()
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<title>{% block title %}Site{% endblock %}</title><main>{% block content %}{% endblock %}</main>`)
+	stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% block title %}{{ super() }} - {{ title }}{% endblock %}{% block content %}{% include "note.html" %}{% endblock %}`)
+	stringLoader.AddTemplate("note.html", `{% block note %}{{ note }}{% endblock %}`)
+	stringLoader.AddTemplate("emails/welcome.txt", `Hello {{ name }}`)
+	return miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+}
+
+func TestPostProcessors(t *testing.T) {
+	vars := miya.NewContextFrom(map[string]interface{}{"title": "Home", "note": "hi", "name": "Ann"})
+	upper := func(rendered string) (string, error) { return strings.ToUpper(rendered), nil }
+	wrap := func(tag string) miya.PostProcessor {
+		return func(rendered string) (string, error) { return "<" + tag + ">" + rendered + "</" + tag + ">", nil }
+	}
+
+	t.Run("blocks", func(t *testing.T) {
+		env := newPostProcessorEnv(t)
+		env.AddBlockPostProcessor("title", upper)
+		env.AddBlockPostProcessor("note", wrap("em"))
+		env.AddBlockPostProcessor("note", wrap("p"))
+		result, err := env.RenderTemplate("page.html", vars)
+		if err != nil {
+			t.Fatalf("Error rendering template: %v", err)
+		}
+		expected := `<title>SITE - HOME</title><main><p><em>hi</em></p></main>`
+		if result != expected {
+			t.Errorf("Expected %q, got %q", expected, result)
+		}
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		env := newPostProcessorEnv(t)
+		env.AddTemplatePostProcessor("emails/*", upper)
+		env.AddTemplatePostProcessor("*.txt", wrap("x"))
+		env.AddTemplatePostProcessor("emails/*.txt", wrap("y"))
+		result, err := env.RenderTemplate("emails/welcome.txt", vars)
+		if err != nil || result != "<y>HELLO ANN</y>" {
+			t.Errorf("matching: got %q, %v", result, err)
+		}
+		result, err = env.RenderTemplate("note.html", vars)
+		if err != nil || result != "hi" {
+			t.Errorf("not matching: got %q, %v", result, err)
+		}
+
+		tmpl, err := env.GetTemplate("emails/welcome.txt")
+		if err != nil {
+			t.Fatalf("GetTemplate: %v", err)
+		}
+		var sb strings.Builder
+		if err := tmpl.RenderTo(&sb, vars); err != nil || sb.String() != "<y>HELLO ANN</y>" {
+			t.Errorf("RenderTo: got %q, %v", sb.String(), err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		env := newPostProcessorEnv(t)
+		env.AddBlockPostProcessor("note", wrap("em"))
+		overlay := env.Overlay()
+		overlay.AddBlockPostProcessor("note", wrap("p"))
+		result, err := overlay.RenderTemplate("note.html", vars)
+		if err != nil || result != "<p><em>hi</em></p>" {
+			t.Errorf("overlay: got %q, %v", result, err)
+		}
+		result, err = env.RenderTemplate("note.html", vars)
+		if err != nil || result != "<em>hi</em>" {
+			t.Errorf("parent: got %q, %v", result, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		failure := errors.New("boom")
+		fail := func(string) (string, error) { return "", failure }
+
+		env := newPostProcessorEnv(t)
+		env.AddBlockPostProcessor("note", fail)
+		_, err := env.RenderTemplate("page.html", vars)
+		if !errors.Is(err, failure) || !strings.Contains(err.Error(), `block "note"`) {
+			t.Errorf("block: got %v", err)
+		}
+
+		env = newPostProcessorEnv(t)
+		env.AddTemplatePostProcessor("emails/*", fail)
+		_, err = env.RenderTemplate("emails/welcome.txt", vars)
+		if !errors.Is(err, failure) || !strings.Contains(err.Error(), `template "emails/welcome.txt"`) {
+			t.Errorf("template: got %v", err)
+		}
+
+		if err := env.AddTemplatePostProcessor("[", upper); err == nil {
+			t.Error("bad pattern: expected an error")
+		}
+		if err := env.AddBlockPostProcessor("", upper); err == nil {
+			t.Error("empty block name: expected an error")
+		}
+		if err := env.AddBlockPostProcessor("note", nil); err == nil {
+			t.Error("nil processor: expected an error")
+		}
+	})
+}
+
+func TestSmartQuotes(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"double quotes", `"Hello," she said.`, "“Hello,” she said."},
+		{"single quotes and apostrophes", `it's 'fine' (or 'not')`, "it’s ‘fine’ (or ‘not’)"},
+		{"nested", `"a 'b' c"`, "“a ‘b’ c”"},
+		{"across tags", `<p>"<em>quoted</em>"</p>`, "<p>“<em>quoted</em>”</p>"},
+		{"attributes", `<a href="x" title='y'>"link"</a>`, `<a href="x" title='y'>“link”</a>`},
+		{"escaped quotes", `&#34;a&#34; &#39;b&#39; &quot;c&quot;`, "“a” ‘b’ “c”"},
+		{"code", `<code>x = "y"</code> "z" <script>var s = 'q';</script><pre>"p"</pre>`, `<code>x = "y"</code> “z” <script>var s = 'q';</script><pre>"p"</pre>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := miya.SmartQuotes(tt.template)
+			if err != nil {
+				t.Fatalf("SmartQuotes: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("autoescaped block", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(true))
+		env.AddBlockPostProcessor("body", miya.SmartQuotes)
+		result, err := env.RenderString(`<p title="{{ q }}">{% block body %}{{ q }}{% endblock %}</p>`, miya.NewContextFrom(map[string]interface{}{"q": `"<b>"`}))
+		expected := `<p title="&#34;&lt;b&gt;&#34;">“&lt;b&gt;”</p>`
+		if err != nil || result != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, result, err)
+		}
+	})
+}
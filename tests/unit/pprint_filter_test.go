@@ -0,0 +1,40 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestPPrintFilterInTemplates(t *testing.T) {
+	ctx := miya.NewContext()
+	ctx.Set("config", map[string]interface{}{
+		"name":  "<site>",
+		"cache": map[string]interface{}{"ttl": 60, "tiers": []interface{}{"memory", "disk"}},
+	})
+
+	tests := []struct {
+		name       string
+		autoescape bool
+		template   string
+		expected   string
+	}{
+		{"nested", false, `{{ config|pprint }}`, `{'cache': {'tiers': ['memory', 'disk'], 'ttl': 60}, 'name': '<site>'}`},
+		{"keyword arguments", false, `{{ config|pprint(depth=1, width=30) }}`, "{\n  'cache': {...},\n  'name': '<site>'\n}"},
+		{"verbose keyword", false, `{{ config.cache.ttl|pprint(verbose=true) }}`, `(int) 60`},
+		{"escaped", true, `{{ config.name|pprint }}`, `&#39;&lt;site&gt;&#39;`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := miya.NewEnvironment(miya.WithAutoEscape(tt.autoescape))
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got\n%s\nwant\n%s", out, tt.expected)
+			}
+		})
+	}
+}
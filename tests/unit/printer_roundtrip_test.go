@@ -0,0 +1,103 @@
+package miya_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+)
+
+// TestPrinterRoundTripExamples prints every example template back to source
+// and checks the printed version renders exactly like the original
+func TestPrinterRoundTripExamples(t *testing.T) {
+	roots, err := filepath.Glob("../../examples/features/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots = append(roots,
+		"../../examples/go/complex/templates",
+		"../../examples/go/web-server/templates",
+		"../../examples/tutorial/templates",
+		"../../examples/showcase/templates",
+	)
+
+	for _, root := range roots {
+		sources := map[string]string{}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".html" {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			name, _ := filepath.Rel(root, path)
+			sources[filepath.ToSlash(name)] = string(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sources) == 0 {
+			continue
+		}
+
+		original := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		printed := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		for name, src := range sources {
+			ast, err := parser.Parse(name, src)
+			if err != nil {
+				t.Fatalf("%s/%s: parse failed: %v", root, name, err)
+			}
+			out := parser.Print(ast)
+
+			reparsed, err := parser.Parse(name, out)
+			if err != nil {
+				t.Fatalf("%s/%s: printed source does not parse: %v\n%s", root, name, err, out)
+			}
+			if again := parser.Print(reparsed); again != out {
+				t.Errorf("%s/%s: printing is not stable", root, name)
+			}
+
+			original.AddTemplate(name, src)
+			printed.AddTemplate(name, out)
+		}
+
+		originalEnv := miya.NewEnvironment(miya.WithLoader(original))
+		printedEnv := miya.NewEnvironment(miya.WithLoader(printed))
+		for name := range sources {
+			want, wantErr := originalEnv.RenderTemplate(name, roundTripContext())
+			got, gotErr := printedEnv.RenderTemplate(name, roundTripContext())
+			if (wantErr != nil) != (gotErr != nil) {
+				t.Errorf("%s/%s: original error %v, printed error %v", root, name, wantErr, gotErr)
+				continue
+			}
+			if got != want {
+				t.Errorf("%s/%s: printed template renders differently\n--- original\n%s\n--- printed\n%s", root, name, want, got)
+			}
+		}
+	}
+}
+
+func roundTripContext() miya.Context {
+	ctx := miya.NewContext()
+	ctx.Set("title", "Round trip")
+	ctx.Set("user", map[string]interface{}{"name": "Ann", "email": "ann@example.com", "is_admin": true, "age": 31})
+	ctx.Set("users", []interface{}{
+		map[string]interface{}{"name": "Ann", "age": 31, "active": true, "role": "admin"},
+		map[string]interface{}{"name": "Bob", "age": 17, "active": false, "role": "user"},
+	})
+	ctx.Set("items", []interface{}{"alpha", "beta", "gamma"})
+	ctx.Set("products", []interface{}{
+		map[string]interface{}{"name": "Lamp", "price": 19.5, "in_stock": true, "tags": []interface{}{"home"}},
+		map[string]interface{}{"name": "Desk", "price": 120, "in_stock": false, "tags": []interface{}{}},
+	})
+	ctx.Set("numbers", []interface{}{3, 1, 4, 1, 5, 9})
+	ctx.Set("message", strings.Repeat("round trip ", 3))
+	return ctx
+}
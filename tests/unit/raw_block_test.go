@@ -0,0 +1,106 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+// rawEveryTag holds every kind of tag, none of which a raw block may act on
+const rawEveryTag = `{{ user.name|upper }}{{- x -}}{# comment #}{#- trimmed -#}
+  {% if a %}{% elif b %}{% else %}{% endif %}
+  {% for x in xs %}{% break %}{% continue %}{% endfor %}
+  {% set x = 1 %}{% set y %}{% endset %}{% with a = 1 %}{% endwith %}
+  {% block b %}{{ super() }}{% endblock %}{% extends "base.html" %}
+  {% include "x.html" %}{% import "m.html" as m %}{% from "m.html" import f %}
+  {% macro m() %}{% endmacro %}{% call m() %}{% endcall %}
+  {% filter upper %}{% endfilter %}{% autoescape true %}{% endautoescape %}
+  {% do x %}{% raw %}{%- if -%}
+`
+
+func TestRawBlocks(t *testing.T) {
+	render := func(t *testing.T, env *miya.Environment, source string) string {
+		t.Helper()
+		tmpl, err := env.FromString(source)
+		if err != nil {
+			t.Fatalf("%q: %v", source, err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"x": "X"}))
+		if err != nil {
+			t.Fatalf("%q: %v", source, err)
+		}
+		return out
+	}
+
+	t.Run("EveryTag", func(t *testing.T) {
+		for _, opts := range [][]miya.EnvironmentOption{
+			{miya.WithAutoEscape(false)},
+			{miya.WithAutoEscape(false), miya.WithTrimBlocks(true), miya.WithLstripBlocks(true)},
+		} {
+			env := miya.NewEnvironment(opts...)
+			if got := render(t, env, "{% raw %}"+rawEveryTag+"{% endraw %}"); got != rawEveryTag {
+				t.Errorf("got %q, want the body unchanged", got)
+			}
+		}
+	})
+
+	t.Run("InsideOtherBlocks", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false))
+		tests := []struct {
+			source string
+			want   string
+		}{
+			{`{% filter upper %}a{% raw %}{{ x }}{% endfilter %}{% endraw %}b{% endfilter %}`, "A{{ X }}{% ENDFILTER %}B"},
+			{`{% macro m() %}[{% raw %}{% endmacro %}{{ x }}{% endraw %}]{% endmacro %}{{ m() }}{{ x }}`, "[{% endmacro %}{{ x }}]X"},
+			{`{% set s %}{% raw %}{{ x }}{% endraw %}{% endset %}{{ s }}|{{ x }}`, "{{ x }}|X"},
+			{`{% for i in [1, 2] %}{% raw %}{{ i }}{% endraw %}{{ i }}{% endfor %}`, "{{ i }}1{{ i }}2"},
+		}
+		for _, tt := range tests {
+			if got := render(t, env, tt.source); got != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("WhitespaceControl", func(t *testing.T) {
+		tests := []struct {
+			opts   []miya.EnvironmentOption
+			source string
+			want   string
+		}{
+			{nil, "a {%- raw -%}\n {{ x }} \n{%- endraw -%} b", "a{{ x }}b"},
+			{nil, "a {% raw -%}\n {{ x }} \n{%- endraw %} b", "a {{ x }} b"},
+			{nil, "a {%- raw %} {{ x }} {% endraw -%} b", "a {{ x }} b"},
+			{[]miya.EnvironmentOption{miya.WithTrimBlocks(true), miya.WithLstripBlocks(true)},
+				"{% raw %}\n  {% if %}\nx\n  {% endraw %}\ny", "  {% if %}\nx\ny"},
+		}
+		for _, tt := range tests {
+			env := miya.NewEnvironment(append([]miya.EnvironmentOption{miya.WithAutoEscape(false)}, tt.opts...)...)
+			if got := render(t, env, tt.source); got != tt.want {
+				t.Errorf("%q: got %q, want %q", tt.source, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("LiteralEndraw", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithAutoEscape(false))
+		source := `{% raw %}{% raw %}...{% endraw %}{{ '{% endraw %}' }}`
+		if got := render(t, env, source); got != "{% raw %}...{% endraw %}" {
+			t.Errorf("got %q", got)
+		}
+
+		_, err := env.FromString(`{% raw %}{{ '{% endraw %}' }}{% endraw %}`)
+		if err == nil || !strings.Contains(err.Error(), "a raw block ends at its first endraw") {
+			t.Errorf("expected an error explaining where the raw block ended, got %v", err)
+		}
+	})
+
+	t.Run("Unclosed", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		_, err := env.FromString("{% if a %}\n  {% raw %}{% endif %}")
+		if err == nil || !strings.Contains(err.Error(), "unclosed 'raw' tag, expected '{% endraw %}' at line 2, column 6") {
+			t.Errorf("expected an unclosed raw error at the raw tag, got %v", err)
+		}
+	})
+}
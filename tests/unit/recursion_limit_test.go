@@ -0,0 +1,94 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestMaxRecursionDepth(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("forever.html", "{% macro f() %}\n{{ f() }}\n{% endmacro %}\n{{ f() }}")
+	stringLoader.AddTemplate("mutual.html", "{% macro a() %}{{ b() }}{% endmacro %}{% macro b() %}{{ a() }}{% endmacro %}{{ a() }}")
+	stringLoader.AddTemplate("self.html", "<li>{% include \"self.html\" %}</li>")
+	stringLoader.AddTemplate("deep.html", "{% macro down(path) %}{% if path|length < 200 %}{{ down(path ~ \"x\") }}{% else %}{{ path|length }}{% endif %}{% endmacro %}{{ down(\"\") }}")
+	stringLoader.AddTemplate("tree.html", "{% for node in tree recursive %}[{{ loop(node.children) }}]{% endfor %}")
+
+	recursionError := func(t *testing.T, env *miya.Environment, name string, ctx miya.Context) *runtime.RuntimeError {
+		t.Helper()
+		_, err := env.RenderTemplate(name, ctx)
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeRecursion {
+			t.Fatalf("expected a recursion error, got %v", err)
+		}
+		return runtimeErr
+	}
+
+	t.Run("DefaultLimit", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+		runtimeErr := recursionError(t, env, "forever.html", miya.NewContext())
+		want := "maximum recursion depth 500 exceeded in macro 'f': f -> f"
+		if runtimeErr.Message != want {
+			t.Errorf("got %q, want %q", runtimeErr.Message, want)
+		}
+		if runtimeErr.TemplateName != "forever.html" || runtimeErr.Line != 2 {
+			t.Errorf("reported at %s:%d", runtimeErr.TemplateName, runtimeErr.Line)
+		}
+		if trace := runtimeErr.CallStackTrace(); !strings.Contains(trace, "recursive frames") {
+			t.Errorf("call stack not collapsed: %q", trace)
+		}
+
+		out, err := env.RenderTemplate("deep.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("finite recursion failed: %v", err)
+		}
+		if out != "200" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithMaxRecursionDepth(20))
+
+		runtimeErr := recursionError(t, env, "mutual.html", miya.NewContext())
+		if want := "maximum recursion depth 20 exceeded in macro 'a': a -> b -> a"; runtimeErr.Message != want {
+			t.Errorf("got %q, want %q", runtimeErr.Message, want)
+		}
+
+		runtimeErr = recursionError(t, env, "self.html", miya.NewContext())
+		if want := "maximum recursion depth 20 exceeded in include 'self.html': self.html -> self.html"; runtimeErr.Message != want {
+			t.Errorf("got %q, want %q", runtimeErr.Message, want)
+		}
+
+		if _, err := env.RenderTemplate("deep.html", miya.NewContext()); err == nil {
+			t.Error("recursion deeper than the limit succeeded")
+		}
+	})
+
+	t.Run("RecursiveLoop", func(t *testing.T) {
+		tree := []interface{}{}
+		for i := 0; i < 30; i++ {
+			tree = []interface{}{map[string]interface{}{"children": tree}}
+		}
+		ctx := miya.NewContext()
+		ctx.Set("tree", tree)
+
+		out, err := miya.NewEnvironment(miya.WithLoader(stringLoader)).RenderTemplate("tree.html", ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.Repeat("[", 30) + strings.Repeat("]", 30); out != want {
+			t.Errorf("got %q", out)
+		}
+
+		env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithMaxRecursionDepth(10))
+		runtimeErr := recursionError(t, env, "tree.html", ctx)
+		if want := "maximum recursion depth 10 exceeded in recursive loop"; runtimeErr.Message != want {
+			t.Errorf("got %q, want %q", runtimeErr.Message, want)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package miya_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+)
+
+func TestRelativeTemplateReferences(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base_email.html", `<mail>{% block body %}{% endblock %}</mail>`)
+	stringLoader.AddTemplate("emails/order/items.html", `<items>`)
+	stringLoader.AddTemplate("emails/macros.html", `{% macro sig(name) %}-- {{ name }}{% endmacro %}`)
+	stringLoader.AddTemplate("emails/order/confirm.html", `{% extends "../../base_email.html" %}`+
+		`{% block body %}{% include "./items.html" %}{% import "../macros.html" as m %}{{ m.sig("shop") }}`+
+		`{% from "../macros.html" import sig %}/{{ sig("again") }}{% endblock %}`)
+	stringLoader.AddTemplate("emails/absolute.html", `{% include "emails/order/items.html" %}`)
+	stringLoader.AddTemplate("emails/escape.html", `{% include "../../secret.html" %}`)
+
+	env := miya.NewEnvironment(
+		miya.WithLoader(stringLoader),
+		miya.WithAutoEscape(false),
+	)
+
+	t.Run("ExtendsIncludeImportFrom", func(t *testing.T) {
+		result, err := env.RenderTemplate("emails/order/confirm.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		expected := "<mail><items>-- shop/-- again</mail>"
+		if result != expected {
+			t.Errorf("expected %q, got %q", expected, result)
+		}
+	})
+
+	t.Run("SharesCacheWithAbsoluteName", func(t *testing.T) {
+		if _, err := env.RenderTemplate("emails/order/confirm.html", miya.NewContext()); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		before := env.GetCacheSize()
+
+		result, err := env.RenderTemplate("emails/absolute.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if result != "<items>" {
+			t.Errorf("expected %q, got %q", "<items>", result)
+		}
+		if after := env.GetCacheSize(); after != before+1 {
+			t.Errorf("expected only emails/absolute.html to be added to the cache, size went from %d to %d", before, after)
+		}
+	})
+
+	t.Run("EscapingRootRejected", func(t *testing.T) {
+		_, err := env.GetTemplate("emails/escape.html")
+		if !errors.Is(err, loader.ErrOutsideSearchPath) {
+			t.Fatalf("expected ErrOutsideSearchPath, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "../../secret.html") {
+			t.Errorf("expected error to name the reference, got %v", err)
+		}
+	})
+}
+
+// Environments sharing a loader that caches its ASTs resolve references
+// without writing to the shared trees
+func TestRelativeTemplateReferencesSharedLoader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.html":         `<mail>{% block body %}{% endblock %}</mail>`,
+		"emails/items.html": `<items>`,
+		"emails/page.html":  `{% extends "../base.html" %}{% block body %}{% include "./items.html" %}{% endblock %}`,
+	}
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsLoader := loader.NewFileSystemLoader([]string{dir}, loader.NewDirectTemplateParser())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		env := miya.NewEnvironment(miya.WithLoader(fsLoader), miya.WithAutoEscape(false))
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := env.RenderTemplate("emails/page.html", miya.NewContext())
+				if err != nil {
+					t.Errorf("render failed: %v", err)
+				} else if result != "<mail><items></mail>" {
+					t.Errorf("expected %q, got %q", "<mail><items></mail>", result)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	ast, err := fsLoader.LoadTemplate("emails/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed := parser.Print(ast); !strings.Contains(printed, `"./items.html"`) {
+		t.Errorf("expected the loader's AST to keep its relative names, got %s", printed)
+	}
+}
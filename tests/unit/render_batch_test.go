@@ -0,0 +1,125 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestRenderEach(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<h1>{% block title %}{% endblock %}</h1>`)
+	stringLoader.AddTemplate("alt.html", `<h2>{% block title %}{% endblock %}</h2>`)
+	stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% block title %}{{ name }}{% endblock %}`)
+	stringLoader.AddTemplate("dynamic.html", `{% extends layout %}{% block title %}{{ name }}{% endblock %}`)
+	stringLoader.AddTemplate("checked.html", `{{ 10 // n }}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(true))
+
+	contextsOf := func(values ...map[string]interface{}) []miya.Context {
+		contexts := make([]miya.Context, len(values))
+		for i, v := range values {
+			contexts[i] = miya.NewContextFrom(v)
+		}
+		return contexts
+	}
+
+	for _, workers := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			tmpl, err := env.GetTemplate("page.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []map[string]interface{}
+			for i := 0; i < 50; i++ {
+				values = append(values, map[string]interface{}{"name": fmt.Sprintf("<%d>", i)})
+			}
+			outputs, errs := tmpl.RenderMany(contextsOf(values...), miya.BatchWorkers(workers))
+			if len(outputs) != 50 || len(errs) != 50 {
+				t.Fatalf("got %d outputs and %d errors", len(outputs), len(errs))
+			}
+			for i, output := range outputs {
+				expected := fmt.Sprintf("<h1>&lt;%d&gt;</h1>", i)
+				if errs[i] != nil || output != expected {
+					t.Errorf("context %d: Expected %q, got %q (%v)", i, expected, output, errs[i])
+				}
+			}
+
+			checked, err := env.GetTemplate("checked.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs, errs = checked.RenderMany(contextsOf(map[string]interface{}{"n": 2}, map[string]interface{}{"n": 0}, map[string]interface{}{"n": 5}), miya.BatchWorkers(workers))
+			if outputs[0] != "5" || errs[0] != nil || errs[1] == nil || outputs[2] != "2" || errs[2] != nil {
+				t.Errorf("errors: got %q, %v", outputs, errs)
+			}
+		})
+	}
+
+	t.Run("streaming", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var log []string
+		n := 0
+		tmpl.RenderEach(func() (miya.Context, bool) {
+			if n == 4 {
+				return nil, false
+			}
+			n++
+			log = append(log, fmt.Sprintf("next %d", n))
+			return miya.NewContextFrom(map[string]interface{}{"name": n}), true
+		}, func(output string, err error) {
+			log = append(log, output)
+		}, miya.BatchWorkers(2))
+		expected := "next 1,next 2,next 3,next 4,<h1>1</h1>,<h1>2</h1>,<h1>3</h1>,<h1>4</h1>"
+		if got := strings.Join(log, ","); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+
+		emitted := 0
+		tmpl.RenderEach(func() (miya.Context, bool) { return nil, false }, func(string, error) { emitted++ })
+		if emitted != 0 {
+			t.Errorf("empty batch emitted %d outputs", emitted)
+		}
+	})
+
+	t.Run("dynamic extends", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("dynamic.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs, errs := tmpl.RenderMany(contextsOf(
+			map[string]interface{}{"layout": "base.html", "name": "a"},
+			map[string]interface{}{"layout": "alt.html", "name": "b"},
+		))
+		if errs[0] != nil || errs[1] != nil || outputs[0] != "<h1>a</h1>" || outputs[1] != "<h2>b</h2>" {
+			t.Errorf("got %q, %v", outputs, errs)
+		}
+	})
+
+	t.Run("render options", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs, errs := tmpl.RenderMany(contextsOf(map[string]interface{}{"name": "<b>"}), miya.BatchRenderOptions(miya.RenderAutoescape(false)))
+		if errs[0] != nil || outputs[0] != "<h1><b></h1>" {
+			t.Errorf("got %q, %v", outputs, errs)
+		}
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		tmpl, err := env.FromString(`{% extends "nowhere.html" %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, errs := tmpl.RenderMany(contextsOf(nil, nil), miya.BatchWorkers(2))
+		if errs[0] == nil || errs[1] == nil {
+			t.Errorf("expected every render to fail, got %v", errs)
+		}
+	})
+}
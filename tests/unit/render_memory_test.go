@@ -0,0 +1,152 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestMaxRenderMemory(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("macros.html", "{% macro pad(n) %}\n{{ 'x'|pad_left(n) }}{% endmacro %}")
+	stringLoader.AddTemplate("import.html", `{% from "macros.html" import pad %}{{ pad(10) }}|{{ pad(n) }}`)
+	stringLoader.AddTemplate("big.html", `{{ range(n)|length }}`)
+	stringLoader.AddTemplate("include.html", `{% include "big.html" ignore missing %}`)
+
+	const limit = 1 << 20
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithMaxRenderMemory(limit))
+	huge := map[string]interface{}{"n": 1 << 30, "items": []interface{}{1, 2, 3}}
+
+	memoryError := func(t *testing.T, err error) *runtime.RuntimeError {
+		t.Helper()
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeMemory {
+			t.Fatalf("expected a MemoryError, got %v", err)
+		}
+		return runtimeErr
+	}
+	render := func(source string, vars map[string]interface{}) (string, error) {
+		tmpl, err := env.FromString(source)
+		if err != nil {
+			t.Fatalf("parse %q: %v", source, err)
+		}
+		return tmpl.Render(miya.NewContextFrom(vars))
+	}
+
+	t.Run("Operations", func(t *testing.T) {
+		tests := []struct {
+			source, operation string
+		}{
+			{`{{ "ab" * n }}`, "string repetition"},
+			{`{{ n * [1, 2] }}`, "sequence repetition"},
+			{`{{ range(n)|list }}`, "range()"},
+			{`{{ items|batch(n, 0) }}`, "filter 'batch'"},
+			{`{{ "x"|center(n) }}`, "filter 'center'"},
+			{`{{ "a\nb"|indent(n) }}`, "filter 'indent'"},
+			{`{{ ("ab" * 1000)|replace("a", "x" * 2000) }}`, "filter 'replace'"},
+			{`{{ items|join("-" * 1000000) }}`, "filter 'join'"},
+		}
+		for _, tt := range tests {
+			_, err := render("\n  "+tt.source, huge)
+			runtimeErr := memoryError(t, err)
+			if !strings.Contains(runtimeErr.Message, tt.operation) || runtimeErr.Line != 2 {
+				t.Errorf("%s: got %q at line %d, want %q at line 2", tt.source, runtimeErr.Message, runtimeErr.Line, tt.operation)
+			}
+		}
+	})
+
+	t.Run("LiteralRepetition", func(t *testing.T) {
+		// Literals repeated are computed by the render, not when the
+		// template is loaded, so the limit applies to them
+		small := miya.NewEnvironment(miya.WithMaxRenderMemory(1000))
+		for _, source := range []string{`{{ "x" * 5000 }}`, `{{ 5000 * "x" }}`, `{{ ("x" * 5000)|length }}`} {
+			tmpl, err := small.FromString(source)
+			if err != nil {
+				t.Fatalf("parse %q: %v", source, err)
+			}
+			_, err = tmpl.Render(miya.NewContext())
+			if runtimeErr := memoryError(t, err); !strings.Contains(runtimeErr.Message, "string repetition") {
+				t.Errorf("%s: got %q", source, runtimeErr.Message)
+			}
+		}
+	})
+
+	t.Run("CountsTheWholeRender", func(t *testing.T) {
+		// Each concatenation is small, but together they pass the limit
+		source := `{% set chunk = "y" * 10000 %}{% set ns = namespace(s="") %}{% for i in range(200) %}{% set ns.s = ns.s ~ chunk %}{% endfor %}{{ ns.s|length }}`
+		_, err := render(source, nil)
+		if runtimeErr := memoryError(t, err); !strings.Contains(runtimeErr.Message, "string concatenation") {
+			t.Errorf("got %q", runtimeErr.Message)
+		}
+	})
+
+	t.Run("RestartsWithEachRender", func(t *testing.T) {
+		tmpl, err := env.FromString(`{{ ("x" * n)|length }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"n": limit / 2}))
+			if err != nil || out != "524288" {
+				t.Fatalf("render %d: got %q, %v", i, out, err)
+			}
+		}
+	})
+
+	t.Run("MacrosAndIncludes", func(t *testing.T) {
+		for _, name := range []string{"import.html", "include.html"} {
+			tmpl, err := env.GetTemplate(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tmpl.Render(miya.NewContextFrom(huge))
+			memoryError(t, err)
+		}
+		tmpl, err := env.GetTemplate("import.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Render(miya.NewContextFrom(huge))
+		if runtimeErr := memoryError(t, err); runtimeErr.TemplateName != "macros.html" || runtimeErr.Line != 2 {
+			t.Errorf("got %s line %d, want macros.html line 2", runtimeErr.TemplateName, runtimeErr.Line)
+		}
+	})
+
+	t.Run("SmallRendersUnaffected", func(t *testing.T) {
+		out, err := render(`{{ range(5)|join(",") }} {{ "ab" * 3 }} {{ ([1] * 2 + [3])|length }} {{ "x"|center(5, "-") }} {{ items|batch(2, 0)|list|length }}`, huge)
+		if err != nil || out != "0,1,2,3,4 ababab 3 --x-- 2" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("UnlimitedByDefault", func(t *testing.T) {
+		tmpl, err := miya.NewEnvironment().FromString(`{{ ("x" * n)|length }} {{ "x"|center(n)|length }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"n": 4 << 20}))
+		if err != nil || out != "4194304 4194304" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("OverriddenFilterNotCharged", func(t *testing.T) {
+		overlay := env.Overlay()
+		if err := overlay.AddFilter("center", func(value interface{}, args ...interface{}) (interface{}, error) {
+			return "centered", nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := overlay.FromString(`{{ "x"|center(n) }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := tmpl.Render(miya.NewContextFrom(huge)); err != nil || out != "centered" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+}
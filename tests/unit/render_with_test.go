@@ -0,0 +1,91 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestRenderWithOverridesPerRender(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithFinalizer(func(v interface{}) interface{} {
+		if v == nil {
+			return "N/A"
+		}
+		return v
+	}))
+	tmpl, err := env.FromString(`{{ name }}|{{ note }}|{{ "<i>"|safe }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	htmlOpts := []miya.RenderOption{miya.RenderAutoescape(true), miya.RenderUndefinedBehavior(miya.UndefinedStrict)}
+	textOpts := []miya.RenderOption{miya.RenderAutoescape(false), miya.RenderUndefinedBehavior(miya.UndefinedSilent), miya.RenderFinalizer(nil)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ctx := miya.NewContextFrom(map[string]interface{}{"name": "<b>", "note": nil})
+			out, err := tmpl.RenderWith(ctx, htmlOpts...)
+			if err != nil || out != "&lt;b&gt;|N/A|<i>" {
+				errs <- fmt.Errorf("html render: %q, %v", out, err)
+			}
+			if _, err := tmpl.RenderWith(miya.NewContextFrom(map[string]interface{}{"name": "x"}), htmlOpts...); err == nil {
+				errs <- fmt.Errorf("html render: undefined note was not an error")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ctx := miya.NewContextFrom(map[string]interface{}{"name": "<b>"})
+			out, err := tmpl.RenderWith(ctx, textOpts...)
+			if err != nil || out != "<b>||<i>" {
+				errs <- fmt.Errorf("text render: %q, %v", out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// The environment's settings still apply to plain renders
+	out, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"name": "<b>"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "&lt;b&gt;||<i>" {
+		t.Errorf("Render after RenderWith: got %q", out)
+	}
+}
+
+func TestRenderWithEscapeContext(t *testing.T) {
+	env := miya.NewEnvironment()
+	tmpl, err := env.FromString(`var msg = "{{ msg }}";{% autoescape false %}{{ msg }}{% endautoescape %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := miya.NewContextFrom(map[string]interface{}{"msg": `say "hi" </script>`})
+
+	out, err := tmpl.RenderWith(ctx, miya.RenderEscapeContext(miya.EscapeContextJS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `var msg = "say \"hi\" \u003c/script\u003e";say "hi" </script>`
+	if out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+
+	out, err = tmpl.RenderWith(ctx, miya.RenderEscapeContext(miya.EscapeContextJS), miya.RenderAutoescape(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, `\"`) {
+		t.Errorf("escaped with autoescape off: %s", out)
+	}
+}
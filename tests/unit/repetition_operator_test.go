@@ -0,0 +1,91 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestRepetitionOperator(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		expected string
+	}{
+		{"string times int", `[{{ "ab" * 3 }}]`, nil, "[ababab]"},
+		{"int times string", `[{{ 3 * "ab" }}]`, nil, "[ababab]"},
+		{"indentation", `{{ "  " * depth }}item`, map[string]interface{}{"depth": 2}, "    item"},
+		{"zero", `[{{ "ab" * 0 }}]`, nil, "[]"},
+		{"negative", `[{{ "ab" * -2 }}]`, nil, "[]"},
+		{"emoji", `{{ "😀" * 3 }}|{{ ("😀" * 3)|length }}`, nil, "😀😀😀|3"},
+		{"bar chart", `{{ "█" * score }}{{ "░" * (5 - score) }}`, map[string]interface{}{"score": 3}, "███░░"},
+		{"combining characters", `{{ "é" * 2 }}`, nil, "éé"},
+		{"list", `{{ ([1, 2] * 2)|join(",") }}`, nil, "1,2,1,2"},
+		{"int times list", `{{ (2 * ["x"])|join(",") }}`, nil, "x,x"},
+		{"go slice", `{{ (items * 2)|join(",") }}`, map[string]interface{}{"items": []string{"a", "b"}}, "a,b,a,b"},
+		{"empty list", `{{ ([1] * 0)|length }}`, nil, "0"},
+		{"numbers still multiply", `{{ 6 * 7 }}`, nil, "42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRepetitionOperatorErrors(t *testing.T) {
+	env := miya.NewEnvironment()
+
+	tests := []struct {
+		name     string
+		template string
+		message  string
+	}{
+		{"float count", `{{ "ab" * 1.5 }}`, "cannot multiply"},
+		{"string count", `{{ "ab" * "cd" }}`, "cannot multiply"},
+		{"string too large", `{{ "ab" * 100000000 }}`, "exceeds the limit"},
+		{"list too large", `{{ [1, 2, 3] * n }}`, "exceeds the limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.RenderString(tt.template, miya.NewContextFrom(map[string]interface{}{"n": 1 << 40}))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected an error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestRepetitionRecursiveMenu(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	template := `{% for item in menu recursive %}{{ "  " * loop.depth0 }}- {{ item.title }}
+{% if item.children %}{{ loop(item.children) }}{% endif %}{% endfor %}`
+
+	menu := []map[string]interface{}{
+		{"title": "Home"},
+		{"title": "Products", "children": []map[string]interface{}{
+			{"title": "Electronics", "children": []map[string]interface{}{{"title": "Phones"}}},
+			{"title": "Books"},
+		}},
+	}
+	result, err := env.RenderString(template, miya.NewContextFrom(map[string]interface{}{"menu": menu}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- Home\n- Products\n  - Electronics\n    - Phones\n  - Books\n"
+	if result != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+}
@@ -0,0 +1,145 @@
+package miya_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestVariableResolutionOrder(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("macros.html", `{% set site = "LIBSITE" %}{% macro label() %}{{ site }}{% endmacro %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+	env.AddGlobal("site", "GLOBALSITE")
+	env.AddGlobal("brand", "GLOBALBRAND")
+	env.AddGlobal("cycler", "GLOBALCYCLER")
+	vars := map[string]interface{}{
+		"site":  "CTXSITE",
+		"range": "CTXRANGE",
+		"loop":  "CTXLOOP",
+		"items": []interface{}{"a", "b"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		// Top level: the render context, then globals, then built-in globals
+		{"context over global", `{{ site }} {{ brand }}`, "CTXSITE GLOBALBRAND"},
+		{"context over builtin", `{{ range }}`, "CTXRANGE"},
+		{"global over builtin", `{{ cycler }}`, "GLOBALCYCLER"},
+		{"builtin", `{{ namespace is callable }}`, "true"},
+		{"set over context", `{% set site = "SET" %}{{ site }}`, "SET"},
+
+		// Loops: loop variables, then the loop object, then outer scopes
+		{"loop variable", `{% for site in items %}{{ site }}{% endfor %}{{ site }}`, "abCTXSITE"},
+		{"loop object over context", `{% for i in items %}{{ loop.index }}{% endfor %}{{ loop }}`, "12CTXLOOP"},
+		{"nested loop object", `{% for i in items %}{% for j in [1] %}{{ loop.length }}{% endfor %}{{ loop.length }}{% endfor %}`, "1212"},
+		{"set in loop", `{% for i in items %}{% set site = i %}{{ site }}{% endfor %}{{ site }}`, "abCTXSITE"},
+
+		// With blocks: their assignments, then outer scopes
+		{"with", `{% with site = "WITH" %}{{ site }}{{ brand }}{% endwith %}{{ site }}`, "WITHGLOBALBRANDCTXSITE"},
+
+		// Macros: parameters, then the scope the macro is defined in
+		{"parameter over context", `{% macro m(site) %}{{ site }}{% endmacro %}{{ m("ARG") }}`, "ARG"},
+		{"definition scope", `{% macro m() %}{{ site }}{% endmacro %}{% for site in items %}{{ m() }}{% endfor %}`, "CTXSITECTXSITE"},
+		{"no call site loop", `{% macro m() %}{{ loop }}{% endmacro %}{% for i in items %}{{ m() }}{% endfor %}`, "CTXLOOPCTXLOOP"},
+		{"no call site set", `{% set site = "TOP" %}{% macro m() %}{{ site }}{% endmacro %}{% for i in [1] %}{% set site = "INNER" %}{{ m() }}{% endfor %}`, "TOP"},
+		{"macro over context", `{% macro site() %}MACRO{% endmacro %}{{ site() }}`, "MACRO"},
+		{"caller", `{% macro m() %}[{{ caller() }}]{% endmacro %}{% for i in items %}{% call m() %}{{ i }}{{ loop.index }}{% endcall %}{% endfor %}`, "[a1][b2]"},
+
+		// Imported macros see their own template, not the importer
+		{"from import", `{% from "macros.html" import label %}{% set site = "PAGE" %}{{ label() }}`, "LIBSITE"},
+		{"import", `{% import "macros.html" as m %}{% for site in items %}{{ m.label() }}{% endfor %}`, "LIBSITELIBSITE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("overlay globals", func(t *testing.T) {
+		overlay := env.Overlay()
+		overlay.AddGlobal("brand", "OVERLAYBRAND")
+		result, err := overlay.RenderString(`{{ brand }} {{ cycler }} {{ site }}`, miya.NewContextFrom(vars))
+		if err != nil || result != "OVERLAYBRAND GLOBALCYCLER CTXSITE" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("loop target", func(t *testing.T) {
+		_, err := env.RenderString(`{% for loop in items %}{% endfor %}`, miya.NewContextFrom(vars))
+		if err == nil || !strings.Contains(err.Error(), "special loop variable") {
+			t.Errorf("expected a syntax error for a loop variable named loop, got %v", err)
+		}
+	})
+}
+
+func TestReservedNameProtection(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithReservedNameProtection(true))
+
+	for _, name := range []string{"loop", "super", "caller", "self", "range", "namespace"} {
+		if !slices.Contains(miya.ReservedNames(), name) {
+			t.Errorf("expected %q among the reserved names", name)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]interface{}
+		reserved string
+	}{
+		{"engine variable", `{% for i in [1] %}{{ loop.index }}{% endfor %}`, map[string]interface{}{"loop": 1}, "loop"},
+		{"unused engine variable", `plain`, map[string]interface{}{"caller": 1}, "caller"},
+		{"builtin", `{{ range }}`, map[string]interface{}{"range": 1}, "range"},
+		{"macro", `{% macro card() %}{% endmacro %}{{ card() }}`, map[string]interface{}{"card": 1}, "card"},
+		{"imported macro", `{% from "x" import a as card %}`, map[string]interface{}{"card": 1}, "card"},
+		{"first in name order", `x`, map[string]interface{}{"self": 1, "dict": 1}, "dict"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.RenderString(tt.template, miya.NewContextFrom(tt.vars))
+			if !errors.Is(err, miya.ErrReservedName) || !strings.Contains(err.Error(), `"`+tt.reserved+`"`) {
+				t.Errorf("expected a reserved name error for %q, got %v", tt.reserved, err)
+			}
+		})
+	}
+
+	t.Run("free names", func(t *testing.T) {
+		result, err := env.RenderString(`{% macro card() %}{{ title }}{% endmacro %}{{ card() }}`, miya.NewContextFrom(map[string]interface{}{"title": "T"}))
+		if err != nil || result != "T" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		overlay := env.Overlay()
+		if _, err := overlay.RenderString(`x`, miya.NewContextFrom(map[string]interface{}{"loop": 1})); !errors.Is(err, miya.ErrReservedName) {
+			t.Errorf("expected the overlay to keep the protection, got %v", err)
+		}
+		relaxed := env.Overlay(miya.WithReservedNameProtection(false))
+		if result, err := relaxed.RenderString(`{{ range }}`, miya.NewContextFrom(map[string]interface{}{"range": 1})); err != nil || result != "1" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		result, err := miya.NewEnvironment().RenderString(`{{ range }}`, miya.NewContextFrom(map[string]interface{}{"range": 1}))
+		if err != nil || result != "1" {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package miya_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func boldFilter(value interface{}, args ...interface{}) (interface{}, error) {
+	return "<b>" + strings.TrimSpace(fmt.Sprint(value)) + "</b>", nil
+}
+
+func newSafeFilterEnv(autoescape bool) *miya.Environment {
+	env := miya.NewEnvironment(miya.WithAutoEscape(autoescape))
+	env.AddSafeFilter("bold", boldFilter)
+	env.AddFilter("shout", func(value interface{}, args ...interface{}) (interface{}, error) {
+		return "<i>" + fmt.Sprint(value) + "</i>", nil
+	})
+	return env
+}
+
+func TestSafeFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoescape bool
+		template   string
+		expected   string
+	}{
+		{"NotEscaped", true, `{{ text|bold }}`, "<b>hi</b>"},
+		{"RegularFilterEscaped", true, `{{ text|shout }}`, "&lt;i&gt;hi&lt;/i&gt;"},
+		{"FilterBlock", true, `{% filter bold %} {{ text }} {% endfilter %}`, "<b>hi</b>"},
+		{"AutoescapeOff", false, `{{ text|bold }}|{{ (text|bold) is string }}`, "<b>hi</b>|true"},
+		{"AutoescapeBlockOn", false, `{% autoescape true %}{{ text|bold }}{{ text|shout }}{% endautoescape %}`, "<b>hi</b>&lt;i&gt;hi&lt;/i&gt;"},
+		{"AutoescapeBlockOff", true, `{% autoescape false %}{{ (text|bold) is string }}{% endautoescape %}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("text", "hi")
+			out, err := newSafeFilterEnv(tt.autoescape).RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	t.Run("AliasAndOverlay", func(t *testing.T) {
+		env := newSafeFilterEnv(true)
+		env.AddFilterAlias("strong", "bold")
+		overlay := env.Overlay()
+		overlay.AddFilterAlias("em", "shout")
+
+		out, err := overlay.RenderString(`{{ "x"|bold }}{{ "x"|strong }}{{ "x"|em }}`, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "<b>x</b><b>x</b>&lt;i&gt;x&lt;/i&gt;" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("UnsupportedType", func(t *testing.T) {
+		if err := miya.NewEnvironment().AddSafeFilter("bad", "not a function"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+type safeCard struct {
+	Title string
+	Body  miya.Safe
+}
+
+func TestSafeValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Loop", `{% for item in items %}{{ item }};{% endfor %}`, "&lt;a&gt;;<b>x</b>;&lt;c&gt;;"},
+		{"StructField", `{{ card.Title }} {{ card.Body }}`, "&lt;Hi&gt; <p>body</p>"},
+		{"MapValue", `{{ fragments.footer }}`, "<footer>&copy;</footer>"},
+		{"SafeFilters", `{{ card.Body|upper }} {{ card.Body|replace("body", "text") }}`, "<P>BODY</P> <p>text</p>"},
+		{"OtherFilters", `{{ card.Body|length }}`, "11"},
+		{"Escape", `{{ escaped }} {{ escaped|upper }}`, "&lt;b&gt; &amp; &#34;c&#34; &LT;B&GT; &AMP; &#34;C&#34;"},
+		{"Test", `{{ "body" in card.Body }}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContext()
+			ctx.Set("items", []interface{}{"<a>", miya.Safe("<b>x</b>"), "<c>"})
+			ctx.Set("card", safeCard{Title: "<Hi>", Body: miya.Safe("<p>body</p>")})
+			ctx.Set("fragments", map[string]interface{}{"footer": miya.Safe("<footer>&copy;</footer>")})
+			ctx.Set("escaped", miya.Escape(`<b> & "c"`))
+			out, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+
+	// Without autoescaping, Safe values render like strings
+	ctx := miya.NewContext()
+	ctx.Set("body", miya.Safe("<p>"))
+	out, err := miya.NewEnvironment(miya.WithAutoEscape(false)).RenderString(`{{ body }}{{ "<" ~ body }}`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "<p><<p>" {
+		t.Errorf("got %q", out)
+	}
+}
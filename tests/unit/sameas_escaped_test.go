@@ -0,0 +1,97 @@
+package miya_test
+
+import (
+	"fmt"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+// bag decides for itself what it contains
+type bag []string
+
+func (b bag) Contains(v interface{}) bool {
+	for _, s := range b {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSameAsAndEscapedTests(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"SameObject", `{{ users is sameas(admins) }} {{ users is not sameas(admins) }}`, "true false"},
+		{"EqualCopies", `{{ users is sameas(copy) }} {{ users is not sameas(copy) }}`, "false true"},
+		{"SameMap", `{{ config is sameas(settings) }} {{ config is sameas(defaults) }} {{ config is not sameas(defaults) }}`, "true false true"},
+		{"ValueTypes", `{{ 1 is sameas(1) }} {{ "a" is sameas("a") }} {{ 1 is sameas(true) }} {{ 1 is not sameas(2) }}`, "true true false true"},
+		{"None", `{{ none is sameas(none) }} {{ none is not sameas(0) }}`, "true true"},
+		{"Escaped", `{{ "<b>"|safe is escaped }} {{ body is escaped }} {{ "<b>" is escaped }} {{ 1 is escaped }}`, "true true false false"},
+		{"NotEscaped", `{{ "<b>" is not escaped }} {{ body is not escaped }}`, "true false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := []interface{}{"ann", "bob"}
+			config := map[string]interface{}{"debug": true}
+			ctx := miya.NewContext()
+			ctx.Set("users", users)
+			ctx.Set("admins", users)
+			ctx.Set("copy", []interface{}{"ann", "bob"})
+			ctx.Set("config", config)
+			ctx.Set("settings", config)
+			ctx.Set("defaults", map[string]interface{}{"debug": true})
+			ctx.Set("body", miya.Safe("<p>"))
+			out, err := miya.NewEnvironment().RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+}
+
+// The in test and the in operator must agree on every kind of container
+func TestInTestMatchesInOperator(t *testing.T) {
+	containers := map[string]interface{}{
+		"list": []interface{}{1, "two", 3.5},
+		"ints": []int{1, 2},
+		"map":  map[string]interface{}{"one": 1},
+		"text": "one two",
+		"safe": miya.Safe("<b>one</b>"),
+		"bag":  bag{"one"},
+		"none": nil,
+	}
+	items := []string{`1`, `1.0`, `"1"`, `"one"`, `"two"`, `3.5`, `2`, `none`}
+
+	for name, container := range containers {
+		for _, item := range items {
+			t.Run(name+"/"+item, func(t *testing.T) {
+				render := func(template string) string {
+					ctx := miya.NewContext()
+					ctx.Set("c", container)
+					out, err := miya.NewEnvironment().RenderString(fmt.Sprintf(template, item), ctx)
+					if err != nil {
+						return "error"
+					}
+					return out
+				}
+				// A non-string item in a string is an error both ways
+				operator := render(`{{ %[1]s in c }} {{ %[1]s not in c }}`)
+				test := render(`{{ %[1]s is in(c) }} {{ %[1]s is not in(c) }}`)
+				if operator != test {
+					t.Errorf("in operator gives %q, in test %q", operator, test)
+				}
+				if operator != "true false" && operator != "false true" && operator != "error" {
+					t.Errorf("unexpected output %q", operator)
+				}
+			})
+		}
+	}
+}
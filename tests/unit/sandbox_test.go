@@ -0,0 +1,156 @@
+package miya_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+// vault has an exported method a template must not reach through reflection
+type vault struct {
+	Label  string
+	secret string
+}
+
+func (v *vault) Reveal() string { return v.secret }
+
+func TestSandboxedEnvironment(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("macros.html", `{% macro reveal(v) %}{{ v.reveal() }}{% endmacro %}{% macro spin(n) %}{% for i in range(n) %}{% for j in range(n) %}.{% endfor %}{% endfor %}{% endmacro %}`)
+	stringLoader.AddTemplate("import.html", `{% import "macros.html" as m %}{{ m.reveal(v) }}`)
+	stringLoader.AddTemplate("spin.html", `{% from "macros.html" import spin %}{{ spin(1000) }}`)
+	stringLoader.AddTemplate("leak.html", `{{ v.reveal() }}`)
+	stringLoader.AddTemplate("include.html", `{% include "leak.html" ignore missing %}`)
+	stringLoader.AddTemplate("forever.html", "{% macro f() %}{{ f() }}{% endmacro %}\n{{ f() }}")
+
+	env := miya.NewSandboxedEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	env.MarkUnsafeFilter("pprint")
+	env.MarkUnsafeGlobal("lipsum")
+
+	securityError := func(t *testing.T, err error) *miya.SecurityError {
+		t.Helper()
+		var securityErr *miya.SecurityError
+		if !errors.As(err, &securityErr) {
+			t.Fatalf("expected a SecurityError, got %v", err)
+		}
+		return securityErr
+	}
+	render := func(source string) (string, error) {
+		ctx := miya.NewContext()
+		ctx.Set("v", &vault{Label: "box", secret: "s3cret"})
+		ctx.Set("data", map[string]interface{}{"_id": 7})
+		return env.RenderString(source, ctx)
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		ctx := miya.NewContext()
+		ctx.Set("v", &vault{Label: "box"})
+		ctx.Set("when", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+		ctx.Set("data", map[string]interface{}{"_id": 7})
+		got, err := env.RenderString(`{{ v.label }} {{ when.year() }} {{ data._id }} {{ range(3)|join }} {{ [1, 2]|map("string")|join }}`, ctx)
+		if err != nil || got != "box 2024 7 012 12" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Violations", func(t *testing.T) {
+		tests := []struct {
+			source string
+			name   string
+		}{
+			{`{{ v.reveal() }}`, "reveal"},
+			{`{{ v.Reveal|string }}`, "Reveal"},
+			{`{{ v._label }}`, "_label"},
+			{`{{ v|pprint }}`, "pprint"},
+			{`{% filter pprint %}x{% endfilter %}`, "pprint"},
+			{`{{ lipsum(1) }}`, "lipsum"},
+			{`{{ range(100000)|length }}`, "range"},
+			{`{% for i in range(10**9) %}{% endfor %}`, "range"},
+			{`{% for i in range(1000) %}{% for j in range(1000) %}{% endfor %}{% endfor %}`, "iterations"},
+			{`{{ [v]|selectexpr("item.reveal()")|list }}`, "reveal"},
+		}
+		for _, tt := range tests {
+			_, err := render(tt.source)
+			securityErr := securityError(t, err)
+			if securityErr.Name != tt.name || securityErr.Line != 1 || securityErr.Column == 0 {
+				t.Errorf("%s: got %q at %d:%d, want %q with its position", tt.source, securityErr.Name, securityErr.Line, securityErr.Column, tt.name)
+			}
+			var runtimeErr *runtime.RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeSecurity {
+				t.Errorf("%s: the SecurityError doesn't carry a RuntimeError: %v", tt.source, err)
+			}
+		}
+	})
+
+	t.Run("MacrosImportsIncludes", func(t *testing.T) {
+		ctx := miya.NewContext()
+		ctx.Set("v", &vault{secret: "s3cret"})
+		for _, name := range []string{"import.html", "spin.html", "include.html"} {
+			out, err := env.RenderTemplate(name, ctx)
+			securityErr := securityError(t, err)
+			if strings.Contains(out, "s3cret") {
+				t.Errorf("%s leaked the secret", name)
+			}
+			if name != "spin.html" && securityErr.TemplateName == name {
+				t.Errorf("%s: reported in the calling template", name)
+			}
+		}
+	})
+
+	t.Run("Recursion", func(t *testing.T) {
+		_, err := env.RenderTemplate("forever.html", miya.NewContext())
+		securityErr := securityError(t, err)
+		if securityErr.Name != "recursion depth" || !strings.Contains(securityErr.Message, "maximum recursion depth 100 exceeded in macro 'f'") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("OutputSize", func(t *testing.T) {
+		small := miya.NewSandboxedEnvironment(miya.WithSandboxLimits(miya.SandboxLimits{MaxOutputSize: 100}))
+		if _, err := small.RenderString(`{{ "x" * 60 }}{{ "y" * 60 }}`, miya.NewContext()); securityError(t, err).Name != "output size" {
+			t.Errorf("got %v", err)
+		}
+		if _, err := small.RenderString(`{% for i in range(20) %}{{ "x" * 10 }}{% endfor %}`, miya.NewContext()); securityError(t, err).Name != "output size" {
+			t.Errorf("got %v", err)
+		}
+		if out, err := small.RenderString(`{{ range(1000)|length }}`, miya.NewContext()); err != nil || out != "1000" {
+			t.Errorf("zero limits should not apply: got %q, %v", out, err)
+		}
+	})
+
+	t.Run("SafeTypesAndOverlays", func(t *testing.T) {
+		overlay := env.Overlay(miya.WithSandboxLimits(miya.SandboxLimits{MaxRangeSize: 5}))
+		overlay.RegisterSafeType(reflect.TypeOf(vault{}))
+		ctx := miya.NewContext()
+		ctx.Set("v", &vault{secret: "s3cret"})
+		if got, err := overlay.RenderString(`{{ v.reveal() }}`, ctx); err != nil || got != "s3cret" {
+			t.Errorf("registered type: got %q, %v", got, err)
+		}
+		if _, err := overlay.RenderString(`{{ range(6)|list }}`, ctx); securityError(t, err).Name != "range" {
+			t.Errorf("got %v", err)
+		}
+		if _, err := overlay.RenderString(`{{ lipsum(1) }}`, ctx); securityError(t, err).Name != "lipsum" {
+			t.Errorf("got %v", err)
+		}
+		if !overlay.IsSandboxed() {
+			t.Error("overlay of a sandboxed environment isn't sandboxed")
+		}
+	})
+
+	t.Run("NotSandboxed", func(t *testing.T) {
+		plain := miya.NewEnvironment(miya.WithAutoEscape(false))
+		plain.MarkUnsafeGlobal("lipsum")
+		ctx := miya.NewContext()
+		ctx.Set("v", &vault{secret: "s3cret"})
+		got, err := plain.RenderString(`{{ v.reveal() }} {{ range(100000)|length }} {{ lipsum(1, false)|length > 0 }}`, ctx)
+		if err != nil || got != "s3cret 100000 true" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package miya_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func inferSchema(t *testing.T, templates map[string]string, name string) miya.Schema {
+	t.Helper()
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	for templateName, source := range templates {
+		stringLoader.AddTemplate(templateName, source)
+	}
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+	tmpl, err := env.GetTemplate(name)
+	if err != nil {
+		t.Fatalf("load %s: %v", name, err)
+	}
+	return miya.InferSchema(tmpl)
+}
+
+func TestInferSchema(t *testing.T) {
+	check := func(t *testing.T, source, want string) {
+		t.Helper()
+		if got := inferSchema(t, map[string]string{"page.html": source}, "page.html").String(); got != want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", source, got, want)
+		}
+	}
+
+	t.Run("ExampleTemplates", func(t *testing.T) {
+		env, _ := productsPage(t)
+		tmpl, err := env.GetTemplate("products.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// products.html extends base.html, groups products with groupby and
+		// reads them in a loop over the groups
+		want := `current_time: scalar
+message: scalar
+products: list
+  item: object
+    Category: scalar
+    Description: scalar
+    ID: scalar
+    InStock: scalar
+    Name: scalar
+    Price: scalar (number)
+title: scalar
+user: object
+  name: scalar
+`
+		if got := miya.InferSchema(tmpl).String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+
+		tutorial := miya.NewEnvironment(miya.WithLoader(loader.NewFileSystemLoader([]string{"../../examples/tutorial/templates"}, loader.NewDirectTemplateParser())))
+		tmpl, err = tutorial.GetTemplate("pages/dashboard.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The dashboard reads its data in the templates it includes
+		schema := miya.InferSchema(tmpl)
+		orders := schema["recent_orders"]
+		if orders == nil || orders.Kind != miya.SchemaList || orders.Item.Fields["total"] == nil || orders.Item.Fields["total"].Type != "number" {
+			t.Errorf("recent_orders: got\n%s", schema)
+		}
+		if stats := schema["stats"]; stats == nil || stats.Kind != miya.SchemaObject || len(stats.Fields) != 4 {
+			t.Errorf("stats: got\n%s", schema)
+		}
+	})
+
+	t.Run("MergesUses", func(t *testing.T) {
+		check(t, `{{ user.name }}{% for role in user.roles %}{{ role.id }}{% endfor %}{{ user.roles[0].label }}{{ user["e-mail"] }}`,
+			`user: object
+  e-mail: scalar
+  name: scalar
+  roles: list
+    item: object
+      id: scalar
+      label: scalar
+`)
+		check(t, `{{ count }}{% if count > 3 %}{{ count.total }}{% endif %}`, "count: object (number)\n  total: scalar\n")
+	})
+
+	t.Run("LoopVariables", func(t *testing.T) {
+		check(t, `{% for row in rows %}{% for cell in row.cells %}{{ cell.value }}{{ loop.index }}{% endfor %}{% else %}{{ empty }}{% endfor %}`,
+			`empty: scalar
+rows: list
+  item: object
+    cells: list
+      item: object
+        value: scalar
+`)
+		check(t, `{% for key, value in settings.items() %}{{ key }}{{ value }}{% endfor %}{{ [x.id for x in things] }}`,
+			`settings: object
+things: list
+  item: object
+    id: scalar
+`)
+		check(t, `{% for group, members in people|groupby("team") %}{{ group }}{% for m in members %}{{ m.name }}{% endfor %}{% endfor %}`,
+			`people: list
+  item: object
+    name: scalar
+    team: scalar
+`)
+		check(t, `{% for group in people|groupby("team") %}{{ group.grouper.lead }}{% for m in group.list %}{{ m.name }}{% endfor %}{% endfor %}`,
+			`people: list
+  item: object
+    name: scalar
+    team: object
+      lead: scalar
+`)
+	})
+
+	t.Run("ExcludesBoundNames", func(t *testing.T) {
+		source := `{% set total = 0 %}{% set alias = user %}{{ total }}{{ alias.email }}` +
+			`{% with greeting = "hi", who = user.name %}{{ greeting }} {{ who }}{% endwith %}` +
+			`{% macro field(name, value="") %}{{ name }}{{ value }}{{ caller() }}{{ varargs }}{{ shared }}{% endmacro %}` +
+			`{% import "forms.html" as forms %}{{ forms.input() }}{{ field("a") }}` +
+			`{% set body %}{{ text }}{% endset %}{{ body }}{{ range(3) }}{{ loop }}{{ _template }}`
+		check(t, source, `shared: scalar
+text: scalar
+user: object
+  email: scalar
+  name: scalar
+`)
+	})
+
+	t.Run("TypeHints", func(t *testing.T) {
+		check(t, `{{ a|int }}{{ b|upper }}{{ c is number }}{{ d == "x" }}{{ 2 * e }}{{ f|default("") }}{{ g|selectattr("on")|list }}{{ h|map(attribute="cost")|sum }}{{ i is mapping }}`,
+			`a: scalar (number)
+b: scalar (string)
+c: scalar (number)
+d: scalar (string)
+e: scalar (number)
+f: scalar
+g: list
+  item: object
+    on: scalar
+h: list
+  item: object
+    cost: scalar (number)
+i: object
+`)
+	})
+
+	t.Run("Includes", func(t *testing.T) {
+		templates := map[string]string{
+			"page.html":   `{% include "row.html" %}{% include "row.html" with {"item": order} %}{% include "only.html" with label=title only %}`,
+			"row.html":    `{{ item.id }}{{ footer }}`,
+			"only.html":   `{{ label }}{{ hidden }}`,
+			"layout.html": `<title>{{ title }}</title>{% block content %}{% endblock %}`,
+		}
+		want := `footer: scalar
+item: object
+  id: scalar
+order: object
+  id: scalar
+title: scalar
+`
+		if got := inferSchema(t, templates, "page.html").String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+
+		templates["page.html"] = `{% extends "layout.html" %}{% block content %}{% embed "row.html" with item=order %}{% endembed %}{% endblock %}`
+		if got := inferSchema(t, templates, "page.html").String(); got != "footer: scalar\norder: object\n  id: scalar\ntitle: scalar\n" {
+			t.Errorf("extends and embed: got:\n%s", got)
+		}
+	})
+
+	t.Run("Cycles", func(t *testing.T) {
+		templates := map[string]string{
+			"self.html": `{{ a }}{% include "self.html" %}`,
+			"ping.html": `{{ ping }}{% include "pong.html" %}`,
+			"pong.html": `{{ pong }}{% include "ping.html" %}`,
+		}
+		if got := inferSchema(t, templates, "self.html").String(); got != "a: scalar\n" {
+			t.Errorf("self include: got:\n%s", got)
+		}
+		if got := inferSchema(t, templates, "ping.html").String(); got != "ping: scalar\npong: scalar\n" {
+			t.Errorf("mutual includes: got:\n%s", got)
+		}
+		check(t, `{% macro tree(node) %}{{ node.name }}{{ tree(node.child) }}{% endmacro %}{{ tree(root) }}`, "root: scalar\n")
+		check(t, `{% for node in nodes recursive %}{{ node.name }}{{ loop(node.children) }}{% endfor %}`,
+			`nodes: list
+  item: object
+    children: list
+      item: scalar
+    name: scalar
+`)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		schema := inferSchema(t, map[string]string{"page.html": `{% for p in products %}{{ p.price|float }}{% endfor %}`}, "page.html")
+		data, err := json.Marshal(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"products":{"kind":"list","item":{"kind":"object","fields":{"price":{"kind":"scalar","type":"number"}}}}}`
+		if string(data) != want {
+			t.Errorf("got %s, want %s", data, want)
+		}
+	})
+}
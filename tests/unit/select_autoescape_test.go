@@ -0,0 +1,100 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func newAutoescapeLoader() *loader.StringLoader {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("welcome.txt", `{{ name }}|{{ name|e }}`)
+	stringLoader.AddTemplate("welcome.TEXT", `{{ name }}|{{ name|e }}`)
+	stringLoader.AddTemplate("page.html", `{{ name }}`)
+	stringLoader.AddTemplate("notes.md", `{{ name }}`)
+	return stringLoader
+}
+
+func TestTextTemplatesAreNotEscaped(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithLoader(newAutoescapeLoader()))
+	ctx := miya.NewContextFrom(map[string]interface{}{"name": "<b>"})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"welcome.txt", "<b>|&lt;b&gt;"},
+		{"welcome.TEXT", "<b>|&lt;b&gt;"},
+		{"page.html", "&lt;b&gt;"},
+		{"notes.md", "&lt;b&gt;"},
+	}
+	for _, tt := range tests {
+		out, err := env.RenderTemplate(tt.name, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+
+	named, err := env.FromStringNamed("inline.txt", `{{ name }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := named.Render(ctx); out != "<b>" {
+		t.Errorf("FromStringNamed .txt: got %q", out)
+	}
+	if named.Name() != "inline.txt" {
+		t.Errorf("FromStringNamed name: got %q", named.Name())
+	}
+	if out, _ := env.RenderString(`{{ name }}`, ctx); out != "&lt;b&gt;" {
+		t.Errorf("FromString: got %q", out)
+	}
+
+	tmpl, err := env.GetTemplate("welcome.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := tmpl.RenderWith(ctx, miya.RenderAutoescape(true)); !strings.HasPrefix(out, "&lt;b&gt;|") {
+		t.Errorf("RenderAutoescape(true) on .txt: got %q", out)
+	}
+}
+
+func TestSelectAutoescape(t *testing.T) {
+	selector := miya.SelectAutoescape([]string{"html", ".XML"}, []string{"txt"}, true, false)
+	for name, want := range map[string]bool{
+		"a.html": true, "b.xml": true, "C.HTML": true, "d.txt": false, "e.md": false, "": true,
+	} {
+		if got := selector(name); got != want {
+			t.Errorf("selector(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	env := miya.NewEnvironment(miya.WithLoader(newAutoescapeLoader()), miya.WithAutoEscape(selector))
+	ctx := miya.NewContextFrom(map[string]interface{}{"name": "<b>"})
+	for name, want := range map[string]string{"page.html": "&lt;b&gt;", "notes.md": "<b>"} {
+		if out, _ := env.RenderTemplate(name, ctx); out != want {
+			t.Errorf("%s: got %q, want %q", name, out, want)
+		}
+	}
+	if out, _ := env.RenderString(`{{ name }}`, ctx); out != "&lt;b&gt;" {
+		t.Errorf("string template: got %q", out)
+	}
+	if out, _ := env.Overlay().RenderTemplate("notes.md", ctx); out != "<b>" {
+		t.Errorf("overlay: got %q", out)
+	}
+
+	// Plain functions are accepted too
+	env = miya.NewEnvironment(miya.WithLoader(newAutoescapeLoader()), miya.WithAutoEscape(func(name string) bool {
+		return strings.HasSuffix(name, ".md")
+	}))
+	if out, _ := env.RenderTemplate("notes.md", ctx); out != "&lt;b&gt;" {
+		t.Errorf("function selector: got %q", out)
+	}
+	if out, _ := env.RenderTemplate("page.html", ctx); out != "<b>" {
+		t.Errorf("function selector: got %q", out)
+	}
+}
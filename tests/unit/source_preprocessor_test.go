@@ -0,0 +1,159 @@
+package miya_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+// stripFrontMatter removes a leading block of "key: value" lines between ---
+// lines, returning the pairs as metadata
+func stripFrontMatter(name, source string) (string, map[string]interface{}, error) {
+	if !strings.HasPrefix(source, "---\n") {
+		return source, nil, nil
+	}
+	header, body, found := strings.Cut(source[len("---\n"):], "---\n")
+	if !found {
+		return "", nil, errors.New("front matter is not closed")
+	}
+	metadata := map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(header), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return "", nil, errors.New("invalid front matter line: " + line)
+		}
+		metadata[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return body, metadata, nil
+}
+
+func TestSourcePreprocessor(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.html":        "---\nlayout: main\n---\n<title>{{ page.title }}</title>{% block body %}{% endblock %}",
+		"child.html":       "---\ntitle: Welcome\n---\n{% extends \"base.html\" %}{% block body %}<main>{% include \"partial.html\" %}</main>{% endblock %}",
+		"partial.html":     "---\nkind: partial\n---\n<p>{{ page.title }}</p>",
+		"broken.html":      "---\ntitle: Broken\n",
+		"uses_broken.html": "{% include \"broken.html\" %}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newEnv := func() *miya.Environment {
+		fsLoader := loader.NewFileSystemLoader([]string{dir}, loader.NewDirectTemplateParser())
+		env := miya.NewEnvironment(miya.WithLoader(fsLoader), miya.WithMetadataVariable("page"))
+		if err := env.SetSourcePreprocessor(stripFrontMatter); err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+
+	t.Run("ExtendsAndInclude", func(t *testing.T) {
+		out, err := newEnv().RenderTemplate("child.html", miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "<title>Welcome</title><main><p>Welcome</p></main>" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		env := newEnv()
+		for name, want := range map[string]map[string]interface{}{
+			"child.html":       {"title": "Welcome"},
+			"base.html":        {"layout": "main"},
+			"partial.html":     {"kind": "partial"},
+			"uses_broken.html": nil,
+		} {
+			tmpl, err := env.GetTemplate(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tmpl.Metadata(); len(got) != len(want) || (want != nil && got[firstKey(want)] != want[firstKey(want)]) {
+				t.Errorf("%s: got %v, want %v", name, got, want)
+			}
+		}
+	})
+
+	t.Run("FromString", func(t *testing.T) {
+		env := newEnv()
+		tmpl, err := env.FromString("---\ntitle: Inline\n---\n{{ page.title }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Metadata()["title"] != "Inline" {
+			t.Errorf("metadata %v", tmpl.Metadata())
+		}
+		out, err := tmpl.Render(miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "Inline" {
+			t.Errorf("got %q", out)
+		}
+
+		// The render context takes precedence
+		ctx := miya.NewContext()
+		ctx.Set("page", map[string]interface{}{"title": "Context"})
+		if out, _ := tmpl.Render(ctx); out != "Context" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("ErrorFailsLoad", func(t *testing.T) {
+		_, err := newEnv().RenderTemplate("uses_broken.html", miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "parser error in template broken.html: front matter is not closed") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("ClearsCache", func(t *testing.T) {
+		env := newEnv()
+		if err := env.SetSourcePreprocessor(nil); err != nil {
+			t.Fatal(err)
+		}
+		out, err := env.RenderTemplate("partial.html", miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(out, "---\nkind: partial\n---\n") {
+			t.Errorf("got %q", out)
+		}
+		if err := env.SetSourcePreprocessor(stripFrontMatter); err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := env.RenderTemplate("partial.html", miya.NewContext()); out != "<p></p>" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("OverlayUsesRoot", func(t *testing.T) {
+		env := newEnv()
+		overlay := env.Overlay(miya.WithAutoEscape(false))
+		if err := overlay.SetSourcePreprocessor(stripFrontMatter); err == nil {
+			t.Error("expected an error setting a preprocessor on an overlay")
+		}
+		tmpl, err := overlay.GetTemplate("child.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Metadata()["title"] != "Welcome" {
+			t.Errorf("metadata %v", tmpl.Metadata())
+		}
+	})
+}
+
+func firstKey(m map[string]interface{}) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
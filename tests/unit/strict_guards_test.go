@@ -0,0 +1,137 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestStrictUndefinedGuards(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithStrictUndefined(true))
+	vars := map[string]interface{}{"user": map[string]interface{}{"name": "Ada"}, "empty": ""}
+
+	t.Run("GuardsAcceptUndefined", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{{ nothing is defined }}`, "false"},
+			{`{{ nothing is not defined }}`, "true"},
+			{`{{ nothing is undefined }}`, "true"},
+			{`{{ nothing is not undefined }}`, "false"},
+			{`{{ user.nickname is undefined }}`, "true"},
+			{`{{ nothing is none }}`, "false"},
+			{`{{ nothing is not none }}`, "true"},
+			{`{{ user.nickname is none }}`, "false"},
+			{`{{ user["nickname"].first is none }}`, "false"},
+			{`{{ nothing|default("d") }}`, "d"},
+			{`{{ user.nickname|d(user.name) }}`, "Ada"},
+			{`{{ default(nothing, "d") }}`, "d"},
+			{`{{ default(user.nickname, user.name) }}`, "Ada"},
+			{`{{ default(nothing) }}`, ""},
+			{`{{ default(empty, "d") }}`, ""},
+			{`{{ default(empty, "d", true) }}`, "d"},
+			{`{{ default(user.name, "d") }}`, "Ada"},
+			{`{{ default(user.nickname if user is defined, "d") }}`, "d"},
+			{`{% if nothing is defined and nothing > 1 %}big{% else %}small{% endif %}`, "small"},
+			{`{% if nothing is not defined or nothing > 1 %}default{% endif %}`, "default"},
+			{`{{ nothing if nothing is defined else "d" }}`, "d"},
+			{`{{ user.name is defined and user.name }}`, "true"},
+			{`{{ 0 or "d" }}`, "true"},
+		}
+		for _, tt := range tests {
+			out, err := env.RenderString(tt.source, miya.NewContextFrom(vars))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.source, err)
+				continue
+			}
+			if out != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, out, tt.want)
+			}
+		}
+	})
+
+	t.Run("OtherUsesStillFail", func(t *testing.T) {
+		for _, source := range []string{
+			`{{ nothing or "d" }}`,
+			`{{ nothing is string }}`,
+			`{{ default(nothing ~ "x", "d") }}`,
+			`{% if nothing is not none and nothing > 1 %}big{% endif %}`,
+			`{{ default() }}`,
+		} {
+			_, err := env.RenderString(source, miya.NewContextFrom(vars))
+			if err == nil {
+				t.Errorf("%s: expected an error", source)
+			}
+		}
+	})
+
+	t.Run("SilentModeUnchanged", func(t *testing.T) {
+		out, err := miya.NewEnvironment().RenderString(`{{ nothing is none }},{{ default(nothing, "d") }},{{ nothing or "d" }}`, miya.NewContext())
+		if err != nil || out != "false,d,true" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("ShortCircuitSkipsCalls", func(t *testing.T) {
+		calls := 0
+		count := func() bool {
+			calls++
+			return true
+		}
+		out, err := env.RenderString(`{{ false and count() }},{{ true or count() }},{{ true and count() }}`,
+			miya.NewContextFrom(map[string]interface{}{"count": count}))
+		if err != nil || out != "false,true,true" || calls != 1 {
+			t.Errorf("got %q, %v after %d calls", out, err, calls)
+		}
+	})
+
+	t.Run("ErrorNamesVariable", func(t *testing.T) {
+		_, err := env.RenderString(`{{ nothing or "d" }}`, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "nothing") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestDefinedThroughLookupChains(t *testing.T) {
+	contexts := map[string]map[string]interface{}{
+		"NoUser":      {},
+		"NoSettings":  {"user": map[string]interface{}{}},
+		"NoTheme":     {"user": map[string]interface{}{"settings": map[string]interface{}{}}},
+		"NoneTheme":   {"user": map[string]interface{}{"settings": map[string]interface{}{"theme": nil}}},
+		"EmptyTheme":  {"user": map[string]interface{}{"settings": map[string]interface{}{"theme": ""}}},
+		"NoneSetting": {"user": map[string]interface{}{"settings": nil}},
+	}
+	source := `{{ user.settings.theme is defined }},{{ user["settings"]["theme"] is undefined }},` +
+		`{{ user.settings is mapping }},{{ user.settings.theme is none }}`
+	want := map[string]string{
+		"NoUser":      "false,true,false,false",
+		"NoSettings":  "false,true,false,false",
+		"NoTheme":     "false,true,true,false",
+		"NoneTheme":   "true,false,true,true",
+		"EmptyTheme":  "true,false,true,false",
+		"NoneSetting": "false,true,false,false",
+	}
+
+	for _, behavior := range []miya.UndefinedBehavior{miya.UndefinedSilent, miya.UndefinedStrict, miya.UndefinedDebug, miya.UndefinedChainFail} {
+		env := miya.NewEnvironment(miya.WithUndefinedBehavior(behavior))
+		for name, vars := range contexts {
+			out, err := env.RenderString(source, miya.NewContextFrom(vars))
+			if err != nil || out != want[name] {
+				t.Errorf("mode %v, %s: got %q, %v, want %q", behavior, name, out, err, want[name])
+			}
+		}
+	}
+
+	t.Run("ChainStillFailsOutsideTests", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		if _, err := env.RenderString(`{{ user.settings.theme }}`, miya.NewContextFrom(contexts["NoSettings"])); err == nil {
+			t.Error("expected an error for the chain outside a test")
+		}
+		// The guard doesn't change the mode of the rest of the render
+		if _, err := env.RenderString(`{{ user.settings.theme is defined }}{{ nothing }}`, miya.NewContext()); err == nil {
+			t.Error("expected an error after the test")
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestStringIndexing(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(false))
+	render := func(t *testing.T, source string, vars map[string]interface{}) string {
+		t.Helper()
+		out, err := env.RenderString(source, miya.NewContextFrom(vars))
+		if err != nil {
+			t.Fatalf("render %q: %v", source, err)
+		}
+		return out
+	}
+
+	t.Run("AvatarInitials", func(t *testing.T) {
+		source := `{% for user in users %}{{ user.first[0]|upper }}{{ user.last[0]|upper }};{% endfor %}`
+		users := []interface{}{
+			map[string]interface{}{"first": "élodie", "last": "Ørsted"},
+			map[string]interface{}{"first": "Ángel", "last": "ñúñez"},
+			map[string]interface{}{"first": "明", "last": "王"},
+			map[string]interface{}{"first": "ada", "last": "lovelace"},
+		}
+		if out := render(t, source, map[string]interface{}{"users": users}); out != "ÉØ;ÁÑ;明王;AL;" {
+			t.Errorf("got %q", out)
+		}
+		if out := render(t, `{{ name|first|upper }}`, map[string]interface{}{"name": "élodie"}); out != "É" {
+			t.Errorf("first filter: got %q", out)
+		}
+	})
+
+	t.Run("Indexes", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{{ name[1] }}`, "小"},
+			{`{{ name[-1] }}`, "明"},
+			{`{{ name[-3] }}`, "王"},
+			{`{{ name[3] }}`, ""},
+			{`{{ name[-4] }}`, ""},
+			{`{{ name[3] is defined }}`, "false"},
+			{`{{ name|last }}`, "明"},
+			{`{{ "café"[3] }}`, "é"},
+		}
+		for _, tt := range tests {
+			if out := render(t, tt.source, map[string]interface{}{"name": "王小明"}); out != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, out, tt.want)
+			}
+		}
+	})
+
+	t.Run("Slices", func(t *testing.T) {
+		tests := []struct {
+			source, want string
+		}{
+			{`{{ name[1:3] }}`, "lo"},
+			{`{{ name[:2] }}`, "él"},
+			{`{{ name[-3:] }}`, "die"},
+			{`{{ name[::-1] }}`, "eidolé"},
+			{`{{ name[::2] }}`, "éoi"},
+			{`{{ name[10::-1] }}`, "eidolé"},
+			{`{{ name[2:-10:-1] }}`, "olé"},
+			{`{{ name[:1] == name[0] }}`, "true"},
+			{`{{ "abc"[5::-1] }}`, "cba"},
+		}
+		for _, tt := range tests {
+			if out := render(t, tt.source, map[string]interface{}{"name": "élodie"}); out != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.source, out, tt.want)
+			}
+		}
+	})
+}
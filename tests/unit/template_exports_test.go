@@ -0,0 +1,65 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestTemplateExports(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("lib.html", `{% set VERSION = "1.2" %}{% set _secret = "s" %}`+
+		`{% macro _attrs(name) %}name="{{ name }}"{% endmacro %}`+
+		`{% macro input(name) %}<input {{ _attrs(name) }}>{% endmacro %}`)
+	stringLoader.AddTemplate("declared.html", `{% set __exports__ = ["input", "VERSION"] %}{% set VERSION = "2" %}{% set internal = "i" %}`+
+		`{% macro input(name) %}<input {{ helper(name) }}>{% endmacro %}{% macro helper(name) %}name="{{ name }}"{% endmacro %}`)
+	stringLoader.AddTemplate("nothing.html", `{% set __exports__ = [] %}{% macro input() %}{% endmacro %}`)
+	stringLoader.AddTemplate("invalid.html", `{% set __exports__ = "input" %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"public names", `{% from "lib.html" import input, VERSION %}{{ input("q") }} {{ VERSION }}`, `<input name="q"> 1.2`},
+		{"private macro still used inside", `{% import "lib.html" as lib %}{{ lib.input("q") }}`, `<input name="q">`},
+		{"private names hidden from import", `{% import "lib.html" as lib %}{{ lib._attrs is defined }} {{ lib._secret is defined }} {{ lib.VERSION }}`, "false false 1.2"},
+		{"declared names", `{% from "declared.html" import input, VERSION %}{{ input("q") }} {{ VERSION }}`, `<input name="q"> 2`},
+		{"undeclared names hidden from import", `{% import "declared.html" as d %}{{ d.helper is defined }} {{ d.internal is defined }} {{ d.__exports__ is defined }} {{ d.input("x") }}`, `false false false <input name="x">`},
+		{"missing public name", `{% from "lib.html" import input as i %}{{ i("a") }}`, `<input name="a">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := env.RenderString(tt.template, nil)
+			if err != nil {
+				t.Fatalf("Error rendering template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for source, want := range map[string]string{
+			`{% from "lib.html" import _attrs %}`:                                                             `cannot import private name "_attrs" from template "lib.html": it exports VERSION, input`,
+			`{% from "lib.html" import input, _secret as s %}`:                                                `private name "_secret"`,
+			`{% from "declared.html" import helper %}`:                                                        `template "declared.html" does not export "helper": it exports VERSION, input`,
+			`{% from "declared.html" import internal %}`:                                                      `does not export "internal"`,
+			`{% from "nothing.html" import input %}`:                                                          `it exports nothing`,
+			`{% from "invalid.html" import input %}`:                                                          `__exports__ must be a list of names`,
+			`{% import "invalid.html" as i %}{{ i.input() }}`:                                                 `__exports__ must be a list of names`,
+			`{% from "declared.html" import __exports__ %}`:                                                   `private name "__exports__"`,
+			`{% from "lib.html" import input with context %}{% from "lib.html" import _attrs with context %}`: `private name "_attrs"`,
+		} {
+			_, err := env.RenderString(source, nil)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected an error containing %q, got %v", source, want, err)
+			}
+		}
+	})
+}
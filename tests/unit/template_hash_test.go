@@ -0,0 +1,210 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+// newHashEnvironment returns an environment over a string loader with a
+// page extending a layout, which includes a widget and imports macros
+func newHashEnvironment() (*miya.Environment, *loader.StringLoader) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("base.html", `<html>{% include "nav.html" %}{% block content %}{% endblock %}</html>`)
+	stringLoader.AddTemplate("nav.html", `<nav>{{ site }}</nav>`)
+	stringLoader.AddTemplate("macros.html", `{% macro price(v) %}${{ v }}{% endmacro %}`)
+	stringLoader.AddTemplate("page.html", `{% extends "base.html" %}{% import "macros.html" as m %}{% block content %}{{ m.price(3) }}{% endblock %}`)
+	stringLoader.AddTemplate("other.html", `<p>unrelated</p>`)
+	return miya.NewEnvironment(miya.WithLoader(stringLoader)), stringLoader
+}
+
+func templateHash(t *testing.T, env *miya.Environment, name string) string {
+	t.Helper()
+	hash, err := env.TemplateHash(name)
+	if err != nil {
+		t.Fatalf("hash of %s: %v", name, err)
+	}
+	return hash
+}
+
+func TestTemplateHash(t *testing.T) {
+	t.Run("stable", func(t *testing.T) {
+		env, _ := newHashEnvironment()
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := tmpl.Hash()
+		if len(hash) != 64 {
+			t.Errorf("Expected a hex SHA-256, got %q", hash)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := tmpl.Render(miya.NewContextFrom(map[string]interface{}{"site": i})); err != nil {
+				t.Fatal(err)
+			}
+			if got := tmpl.Hash(); got != hash {
+				t.Errorf("Render %d changed the hash from %s to %s", i, hash, got)
+			}
+		}
+
+		// Another environment over the same sources agrees
+		other, _ := newHashEnvironment()
+		if got := templateHash(t, other, "page.html"); got != hash {
+			t.Errorf("Expected %s in another environment, got %s", hash, got)
+		}
+	})
+
+	t.Run("templates differ", func(t *testing.T) {
+		env, _ := newHashEnvironment()
+		hashes := map[string]string{}
+		for _, name := range []string{"base.html", "nav.html", "macros.html", "page.html", "other.html"} {
+			hash := templateHash(t, env, name)
+			if previous, ok := hashes[hash]; ok {
+				t.Errorf("%s and %s have the same hash", previous, name)
+			}
+			hashes[hash] = name
+		}
+	})
+
+	// Editing a template changes its hash and those of the templates using
+	// it, directly or not, and no other
+	edits := []struct {
+		template string
+		source   string
+		changed  []string
+	}{
+		{"base.html", `<html>{% include "nav.html" %}<main>{% block content %}{% endblock %}</main></html>`, []string{"base.html", "page.html"}},
+		{"nav.html", `<nav class="top">{{ site }}</nav>`, []string{"nav.html", "base.html", "page.html"}},
+		{"macros.html", `{% macro price(v) %}€{{ v }}{% endmacro %}`, []string{"macros.html", "page.html"}},
+		{"page.html", `{% extends "base.html" %}{% block content %}free{% endblock %}`, []string{"page.html"}},
+	}
+	for _, edit := range edits {
+		t.Run("edit "+edit.template, func(t *testing.T) {
+			env, stringLoader := newHashEnvironment()
+			names := []string{"base.html", "nav.html", "macros.html", "page.html", "other.html"}
+			before := map[string]string{}
+			for _, name := range names {
+				before[name] = templateHash(t, env, name)
+			}
+
+			if err := stringLoader.UpdateTemplate(edit.template, edit.source); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				changed := templateHash(t, env, name) != before[name]
+				expected := false
+				for _, c := range edit.changed {
+					expected = expected || c == name
+				}
+				if changed != expected {
+					t.Errorf("%s: expected changed=%v, got %v", name, expected, changed)
+				}
+			}
+		})
+	}
+
+	t.Run("cached child", func(t *testing.T) {
+		env, stringLoader := newHashEnvironment()
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := tmpl.Hash()
+
+		stringLoader.AddTemplate("base.html", `<body>{% block content %}{% endblock %}</body>`)
+		if tmpl.Hash() == before {
+			t.Error("Expected the hash of the cached child to follow its reloaded parent")
+		}
+		env.ClearCache()
+		if got := templateHash(t, env, "page.html"); got != tmpl.Hash() {
+			t.Errorf("Expected %s after clearing the cache, got %s", tmpl.Hash(), got)
+		}
+	})
+
+	t.Run("edit back", func(t *testing.T) {
+		env, stringLoader := newHashEnvironment()
+		before := templateHash(t, env, "page.html")
+		if err := stringLoader.UpdateTemplate("nav.html", `<nav>v2</nav>`); err != nil {
+			t.Fatal(err)
+		}
+		if err := stringLoader.UpdateTemplate("nav.html", `<nav>{{ site }}</nav>`); err != nil {
+			t.Fatal(err)
+		}
+		if got := templateHash(t, env, "page.html"); got != before {
+			t.Errorf("Expected the original hash %s back, got %s", before, got)
+		}
+	})
+}
+
+func TestTemplateHashReferences(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("a.html", `A`)
+	stringLoader.AddTemplate("b.html", `B`)
+	stringLoader.AddTemplate("dynamic.html", `{% include widget ~ ".html" %}`)
+	stringLoader.AddTemplate("uses_dynamic.html", `{% extends "dynamic.html" %}`)
+	stringLoader.AddTemplate("list.html", `{% include ["missing.html", "b.html"] %}`)
+	stringLoader.AddTemplate("optional.html", `{% include "missing.html" ignore missing %}`)
+	stringLoader.AddTemplate("cycle_a.html", `{% if deep %}{% include "cycle_b.html" %}{% endif %}`)
+	stringLoader.AddTemplate("cycle_b.html", `{% include "cycle_a.html" %}`)
+	stringLoader.AddTemplate("static.html", `{% from "a.html" import x %}{% embed "b.html" %}{% endembed %}`)
+	env := miya.NewEnvironment(miya.WithLoader(stringLoader))
+
+	t.Run("dynamic", func(t *testing.T) {
+		for _, name := range []string{"dynamic.html", "uses_dynamic.html"} {
+			if hash := templateHash(t, env, name); !strings.HasPrefix(hash, miya.DynamicHashPrefix) {
+				t.Errorf("%s: expected a dynamic hash, got %s", name, hash)
+			}
+		}
+		for _, name := range []string{"a.html", "list.html", "optional.html", "cycle_a.html", "static.html"} {
+			if hash := templateHash(t, env, name); strings.HasPrefix(hash, miya.DynamicHashPrefix) {
+				t.Errorf("%s: expected a static hash, got %s", name, hash)
+			}
+		}
+	})
+
+	t.Run("include list", func(t *testing.T) {
+		list, optional := templateHash(t, env, "list.html"), templateHash(t, env, "optional.html")
+		stringLoader.AddTemplate("missing.html", `found`)
+		if templateHash(t, env, "list.html") == list || templateHash(t, env, "optional.html") == optional {
+			t.Error("Expected the hashes of templates including a template that appears to change")
+		}
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		before := templateHash(t, env, "static.html")
+		if err := stringLoader.UpdateTemplate("b.html", `B2`); err != nil {
+			t.Fatal(err)
+		}
+		if templateHash(t, env, "static.html") == before {
+			t.Error("Expected the hash to follow the embedded template")
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		if templateHash(t, env, "cycle_a.html") == templateHash(t, env, "cycle_b.html") {
+			t.Error("Expected the templates of a cycle to hash differently")
+		}
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		if _, err := env.TemplateHash("nowhere.html"); err == nil {
+			t.Error("Expected an error for a missing template")
+		}
+	})
+
+	t.Run("string templates", func(t *testing.T) {
+		first, err := env.FromString(`{% include "a.html" %}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := first.Hash()
+		if err := stringLoader.UpdateTemplate("a.html", `A2`); err != nil {
+			t.Fatal(err)
+		}
+		if first.Hash() == before {
+			t.Error("Expected the hash of a template from a string to follow its includes")
+		}
+	})
+}
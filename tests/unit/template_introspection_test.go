@@ -0,0 +1,71 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/tests/helpers"
+)
+
+func newIntrospectionEnv(templates map[string]string, enabled bool) *miya.Environment {
+	return helpers.CreateLoaderEnvironment(templates, miya.WithAutoEscape(false), miya.WithTemplateIntrospection(enabled))
+}
+
+func TestTemplateIntrospection(t *testing.T) {
+	templates := map[string]string{
+		"base.html":        `[{{ _template.name }}|{{ _template.block }}]{% block content %}{% endblock %}`,
+		"layout.html":      `{% extends "base.html" %}{% block content %}{% block inner %}{% endblock %}{% endblock %}`,
+		"page.html":        `{% extends "layout.html" %}{% block inner %}{{ _template.block }}:{{ _template.parents | join(",") }}{% include "snippet.html" %}{% endblock %}`,
+		"snippet.html":     `<{{ _template.name }}:{{ _template.includes | join(",") }}:{{ _template.block }}>`,
+		"macros.html":      `{% macro where() %}{{ _template.name }}{% endmacro %}`,
+		"caller.html":      `{% import "macros.html" as m %}{{ m.where() }}/{{ _template.name }}`,
+		"inline.html":      `{% macro here() %}{{ _template.name }}{% endmacro %}{% include "uses_inline.html" %}`,
+		"uses_inline.html": `{{ here() }}`,
+	}
+
+	t.Run("NameBlockAndParents", func(t *testing.T) {
+		env := newIntrospectionEnv(templates, true)
+		result, err := env.RenderTemplate("page.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		expected := "[page.html|]inner:layout.html,base.html<snippet.html:snippet.html:inner>"
+		if result != expected {
+			t.Errorf("expected %q, got %q", expected, result)
+		}
+	})
+
+	t.Run("ImportedMacroReportsDefiningTemplate", func(t *testing.T) {
+		env := newIntrospectionEnv(templates, true)
+		result, err := env.RenderTemplate("caller.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if result != "macros.html/caller.html" {
+			t.Errorf("expected %q, got %q", "macros.html/caller.html", result)
+		}
+	})
+
+	t.Run("InlineMacroReportsDefiningTemplate", func(t *testing.T) {
+		env := newIntrospectionEnv(templates, true)
+		result, err := env.RenderTemplate("inline.html", miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if result != "inline.html" {
+			t.Errorf("expected %q, got %q", "inline.html", result)
+		}
+	})
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		env := newIntrospectionEnv(templates, false)
+		result, err := env.RenderString(`[{{ _template.name }}]`, miya.NewContext())
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if strings.Contains(result, "<string>") {
+			t.Errorf("expected _template to be undefined without introspection, got %q", result)
+		}
+	})
+}
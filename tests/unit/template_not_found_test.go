@@ -0,0 +1,158 @@
+package miya_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+func TestTemplateNotFoundHandler(t *testing.T) {
+	newEnv := func(templates map[string]string) *miya.Environment {
+		stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+		for name, source := range templates {
+			stringLoader.AddTemplate(name, source)
+		}
+		return miya.NewEnvironment(miya.WithLoader(stringLoader), miya.WithAutoEscape(false))
+	}
+
+	t.Run("Extends", func(t *testing.T) {
+		env := newEnv(map[string]string{
+			"default/layout.html": `<main>{% block content %}default{% endblock %}</main>`,
+			"page.html":           `{% extends "acme/layout.html" %}{% block content %}page{% endblock %}`,
+		})
+		var asked []string
+		err := env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) {
+			asked = append(asked, name)
+			if rest, ok := strings.CutPrefix(name, "acme/"); ok {
+				return `{% extends "default/` + rest + `" %}`, true, nil
+			}
+			return "", false, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := env.RenderTemplate("page.html", miya.NewContext())
+		if err != nil || out != "<main>page</main>" {
+			t.Fatalf("got %q, %v", out, err)
+		}
+		if len(asked) != 1 || asked[0] != "acme/layout.html" {
+			t.Errorf("handler asked for %v", asked)
+		}
+	})
+
+	t.Run("Caching", func(t *testing.T) {
+		calls := 0
+		handler := func(name string) (string, bool, error) {
+			calls++
+			return "stub for {{ name }}", true, nil
+		}
+		ctx := miya.NewContextFrom(map[string]interface{}{"name": "x"})
+
+		env := newEnv(nil)
+		if err := env.SetTemplateNotFoundHandler(handler); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if out, err := env.RenderTemplate("generated.html", ctx); err != nil || out != "stub for x" {
+				t.Fatalf("got %q, %v", out, err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("cached: handler called %d times, want 1", calls)
+		}
+		env.InvalidateTemplate("generated.html")
+		if _, err := env.GetTemplate("generated.html"); err != nil || calls != 2 {
+			t.Errorf("after invalidation: handler called %d times, %v", calls, err)
+		}
+
+		calls = 0
+		env = newEnv(nil)
+		if err := env.SetTemplateNotFoundHandler(handler, miya.WithoutFallbackCache()); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := env.GetTemplate("generated.html"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if calls != 3 {
+			t.Errorf("uncached: handler called %d times, want 3", calls)
+		}
+	})
+
+	t.Run("LoaderTemplatesFirst", func(t *testing.T) {
+		env := newEnv(map[string]string{"page.html": "loaded"})
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) {
+			t.Errorf("handler asked for %s", name)
+			return "", false, nil
+		})
+		if out, err := env.RenderTemplate("page.html", miya.NewContext()); err != nil || out != "loaded" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("IgnoreMissing", func(t *testing.T) {
+		env := newEnv(map[string]string{
+			"page.html": `[{% include "partial.html" ignore missing %}][{% include "absent.html" ignore missing %}]`,
+		})
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) {
+			return "generated", name == "partial.html", nil
+		})
+		if out, err := env.RenderTemplate("page.html", miya.NewContext()); err != nil || out != "[generated][]" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("Declined", func(t *testing.T) {
+		env := newEnv(nil)
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) { return "", false, nil })
+		_, err := env.GetTemplate("absent.html")
+		if !errors.Is(err, loader.ErrTemplateNotFound) {
+			t.Errorf("got %v, want a template not found error", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		env := newEnv(nil)
+		failure := errors.New("database down")
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) {
+			if name == "broken.html" {
+				return "{% if %}", true, nil
+			}
+			return "", false, failure
+		})
+		if _, err := env.GetTemplate("page.html"); !errors.Is(err, failure) {
+			t.Errorf("handler error: got %v", err)
+		}
+		if _, err := env.GetTemplate("broken.html"); err == nil {
+			t.Error("syntax error: got no error")
+		}
+	})
+
+	t.Run("WithoutLoader", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) {
+			return "Hello {{ who }}", true, nil
+		})
+		out, err := env.RenderTemplate("greeting.html", miya.NewContextFrom(map[string]interface{}{"who": "world"}))
+		if err != nil || out != "Hello world" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("Overlay", func(t *testing.T) {
+		env := newEnv(nil)
+		_ = env.SetTemplateNotFoundHandler(func(name string) (string, bool, error) { return "{{ tenant }}", true, nil })
+		overlay := env.Overlay()
+		overlay.AddGlobal("tenant", "acme")
+		if out, err := overlay.RenderTemplate("page.html", miya.NewContext()); err != nil || out != "acme" {
+			t.Errorf("got %q, %v", out, err)
+		}
+		if err := overlay.SetTemplateNotFoundHandler(nil); err == nil {
+			t.Error("setting the handler on an overlay sharing templates: got no error")
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package miya_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestLoadedTemplateSource(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"page.html":   "<h1>{{ title }}</h1>\n<p>{{ user.name }}</p>\n",
+		"base.html":   "<title>{{ site.name }}</title>\n{% block body %}{% endblock %}",
+		"child.html":  "{% extends \"base.html\" %}\n{% block body %}\n{{ post.title }}\n{% endblock %}",
+		"outer.html":  "before\n{% include \"inner.html\" %}",
+		"inner.html":  "one\ntwo\n{{ 1 + missing_value.x }}",
+		"welcome.txt": "Hi {{ name }}",
+		"forms.html":  "{% macro field(name) %}\n<input name=\"{{ name }}\" value=\"{{ form[name] }}\">\n{% endmacro %}",
+		"signup.html": "{% import \"forms.html\" as forms %}\n{{ forms.field(\"email\") }}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsLoader := loader.NewFileSystemLoader([]string{dir}, loader.NewDirectTemplateParser())
+	fsLoader.SetExtensions([]string{".html", ".txt"})
+	env := miya.NewEnvironment(miya.WithLoader(fsLoader), miya.WithStrictUndefined(true), miya.WithAutoEscape(true))
+
+	renderError := func(t *testing.T, name string, vars map[string]interface{}) *runtime.RuntimeError {
+		t.Helper()
+		_, err := env.RenderTemplate(name, miya.NewContextFrom(vars))
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("expected a RuntimeError, got %v", err)
+		}
+		return runtimeErr
+	}
+
+	t.Run("SourceAndFilename", func(t *testing.T) {
+		tmpl, err := env.GetTemplate("page.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Source() != files["page.html"] {
+			t.Errorf("source: got %q", tmpl.Source())
+		}
+		if tmpl.Filename() != filepath.Join(dir, "page.html") {
+			t.Errorf("filename: got %q", tmpl.Filename())
+		}
+	})
+
+	t.Run("ErrorSnippet", func(t *testing.T) {
+		err := renderError(t, "page.html", map[string]interface{}{"title": "T"})
+		if err.TemplateName != "page.html" || err.Filename != filepath.Join(dir, "page.html") || err.Source != files["page.html"] {
+			t.Errorf("got template %q, file %q, source %q", err.TemplateName, err.Filename, err.Source)
+		}
+		detailed := err.DetailedError()
+		for _, want := range []string{"Template: page.html (" + filepath.Join(dir, "page.html") + ")", ">   2 | <p>{{ user.name }}</p>"} {
+			if !strings.Contains(detailed, want) {
+				t.Errorf("missing %q in:\n%s", want, detailed)
+			}
+		}
+		// The caret points at the column of the error
+		lines := strings.Split(detailed, "\n")
+		for i, line := range lines {
+			if strings.Contains(line, ">   2 |") && i+1 < len(lines) {
+				if caret := strings.Index(lines[i+1], "^"); caret != strings.Index(line, "user") {
+					t.Errorf("caret at %d under %q", caret, line)
+				}
+			}
+		}
+	})
+
+	t.Run("ErrorInParentAndChild", func(t *testing.T) {
+		err := renderError(t, "child.html", map[string]interface{}{"post": map[string]interface{}{"title": "P"}})
+		if err.TemplateName != "base.html" || !strings.Contains(err.DetailedError(), "{{ site.name }}") {
+			t.Errorf("parent error: got %q\n%s", err.TemplateName, err.DetailedError())
+		}
+		err = renderError(t, "child.html", map[string]interface{}{"site": map[string]interface{}{"name": "S"}})
+		if err.TemplateName != "child.html" || !strings.Contains(err.DetailedError(), ">   3 | {{ post.title }}") {
+			t.Errorf("child error: got %q\n%s", err.TemplateName, err.DetailedError())
+		}
+	})
+
+	t.Run("ErrorInInclude", func(t *testing.T) {
+		err := renderError(t, "outer.html", nil)
+		if err.TemplateName != "inner.html" || !strings.Contains(err.DetailedError(), ">   3 | {{ 1 + missing_value.x }}") {
+			t.Errorf("got %q\n%s", err.TemplateName, err.DetailedError())
+		}
+	})
+
+	t.Run("ErrorInImportedMacro", func(t *testing.T) {
+		err := renderError(t, "signup.html", nil)
+		if err.TemplateName != "forms.html" || !strings.Contains(err.DetailedError(), `>   2 | <input name="{{ name }}"`) {
+			t.Errorf("got %q\n%s", err.TemplateName, err.DetailedError())
+		}
+	})
+
+	t.Run("FilenameSelectsTextAutoescape", func(t *testing.T) {
+		out, err := env.RenderTemplate("welcome", miya.NewContextFrom(map[string]interface{}{"name": "<Ann>"}))
+		if err != nil || out != "Hi <Ann>" {
+			t.Errorf("got %q, %v", out, err)
+		}
+		out, err = env.RenderTemplate("page", miya.NewContextFrom(map[string]interface{}{"title": "<T>", "user": map[string]interface{}{"name": "n"}}))
+		if err != nil || !strings.Contains(out, "&lt;T&gt;") {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("StringTemplates", func(t *testing.T) {
+		tmpl, err := env.FromString("a\n{{ nothing }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Render(miya.NewContext())
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.TemplateName != "" || !strings.Contains(runtimeErr.DetailedError(), ">   2 | {{ nothing }}") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestSourceLoaders(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("a.html", "A")
+	chain := loader.NewChainLoader(loader.NewStringLoader(loader.NewDirectTemplateParser()), stringLoader)
+
+	for name, l := range map[string]loader.SourceLoader{"string": stringLoader, "chain": chain} {
+		source, filename, err := l.GetSourceWithFilename("a.html")
+		if err != nil || source != "A" || filename != "a.html" {
+			t.Errorf("%s: got %q, %q, %v", name, source, filename, err)
+		}
+		if _, _, err := l.GetSourceWithFilename("none.html"); err == nil {
+			t.Errorf("%s: expected an error for a missing template", name)
+		}
+	}
+
+	t.Run("LoaderWithoutSource", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithLoader(sourceOnlyLoader{"x.html": "a\n{{ nothing }}"}), miya.WithStrictUndefined(true))
+		tmpl, err := env.GetTemplate("x.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Filename() != "" {
+			t.Errorf("filename: got %q", tmpl.Filename())
+		}
+		_, err = tmpl.Render(miya.NewContext())
+		var runtimeErr *runtime.RuntimeError
+		if !errors.As(err, &runtimeErr) || !strings.Contains(runtimeErr.DetailedError(), ">   2 | {{ nothing }}") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+// sourceOnlyLoader is a basic Loader that doesn't report filenames
+type sourceOnlyLoader map[string]string
+
+func (l sourceOnlyLoader) GetSource(name string) (string, error) {
+	if source, ok := l[name]; ok {
+		return source, nil
+	}
+	return "", loader.ErrTemplateNotFound
+}
+
+func (l sourceOnlyLoader) IsCached(name string) bool { return false }
+
+func (l sourceOnlyLoader) ListTemplates() ([]string, error) { return nil, nil }
@@ -0,0 +1,94 @@
+package miya_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+)
+
+var fixedNow = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func TestTimeValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"Now", `{{ now()|date("2006-01-02 15:04") }}`, "2024-03-15 12:00"},
+		{"NowTimezone", `{{ now("Asia/Tokyo")|time("%H:%M") }}`, "21:00"},
+		{"Before", `{{ start < now() }}|{{ end < now() }}|{{ start <= start }}`, "true|false|true"},
+		{"After", `{{ end > now() }}|{{ now() >= end }}`, "true|false"},
+		{"EqualAcrossZones", `{{ now() == now("Asia/Tokyo") }}|{{ now() != start }}`, "true|true"},
+		{"Membership", `{{ now("Asia/Tokyo") in [start, now()] }}|{{ end in [start] }}`, "true|false"},
+		{"Difference", `{{ end - start }}`, "2h30m"},
+		{"WholeHours", `{{ now() - start }}`, "2h"},
+		{"CompareDurations", `{{ end - start > now() - start }}`, "true"},
+		{"AddSeconds", `{{ (start + 90)|time }}`, "10:01:30"},
+		{"AddFractionalSeconds", `{{ (start + 0.5)|time("%H:%M:%S") }}|{{ (start + 1.5) > start + 1 }}`, "10:00:00|true"},
+		{"AddDuration", `{{ (start + (end - start))|time }}`, "12:30:00"},
+		{"SubtractSeconds", `{{ (start - 60)|time }}`, "09:59:00"},
+		{"DurationClock", `{{ (end - start)|time }}|{{ (start - end)|strftime("%H:%M") }}`, "02:30:00|-02:30"},
+		{"DurationFormat", `{{ "took %s"|format(end - start) }}`, "took 2h30m"},
+		{"DurationString", `{{ (end - start)|string }}`, "2h30m"},
+		{"CompareSeconds", `{{ now() - start > 3600 }}|{{ 7200 <= now() - start }}|{{ end - start < 60 }}`, "true|true|false"},
+	}
+
+	env := miya.NewEnvironment(miya.WithAutoEscape(false), miya.WithFixedNow(fixedNow))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := miya.NewContextFrom(map[string]interface{}{
+				"start": fixedNow.Add(-2 * time.Hour),
+				"end":   fixedNow.Add(30 * time.Minute),
+			})
+			out, err := env.RenderString(tt.template, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.expected {
+				t.Errorf("got %q, want %q", out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNowErrors(t *testing.T) {
+	env := miya.NewEnvironment()
+	for _, tmpl := range []string{`{{ now("Mars/Olympus") }}`, `{{ now(3) }}`, `{{ now("UTC", "UTC") }}`} {
+		if _, err := env.RenderString(tmpl, miya.NewContext()); err == nil || !strings.Contains(err.Error(), "now()") {
+			t.Errorf("%s: expected a now() error, got %v", tmpl, err)
+		}
+	}
+
+	out, err := env.RenderString(`{{ now()|timestamp }}`, miya.NewContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seconds, _ := strconv.ParseInt(out, 10, 64); time.Since(time.Unix(seconds, 0)) > time.Minute {
+		t.Errorf("now() without WithFixedNow is not the current time: %s", out)
+	}
+}
+
+func TestFixedNowOverlay(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithFixedNow(fixedNow))
+	overlay := env.Overlay(miya.WithFixedNow(fixedNow.AddDate(1, 0, 0)))
+
+	for _, tc := range []struct {
+		env      *miya.Environment
+		expected string
+	}{
+		{env, "2024"},
+		{env.Overlay(), "2024"},
+		{overlay, "2025"},
+	} {
+		out, err := tc.env.RenderString(`{{ now()|date("2006") }}`, miya.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tc.expected {
+			t.Errorf("got %q, want %q", out, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package miya_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/runtime"
+)
+
+type jsonProduct struct {
+	SKU     string    `json:"sku"`
+	Title   string    `json:"title"`
+	Updated time.Time `json:"updated"`
+	Cost    float64   `json:"-"`
+}
+
+func TestToJSONInScript(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(true))
+	ctx := miya.NewContext()
+	ctx.Set("product", jsonProduct{SKU: "A1", Title: "</script><b>Tom's</b>", Updated: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), Cost: 3})
+
+	out, err := env.RenderString(`<script>const p = {{ product|tojson }};</script>`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<script>const p = {"sku":"A1","title":"\u003c/script\u003e\u003cb\u003eTom\u0027s\u003c/b\u003e","updated":"2024-05-06T07:08:09Z"};</script>`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	ctx.Set("skus", map[string]interface{}{"sku": "A1"})
+	out, err = env.RenderString(`{{ skus|tojson(indent=2) }}`, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"sku\": \"A1\"\n}"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	ctx.Set("handlers", map[string]interface{}{"save": func() {}})
+	_, err = env.RenderString(`{{ handlers|tojson }}`, ctx)
+	var runtimeErr *runtime.RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Type != runtime.ErrorTypeFilter {
+		t.Fatalf("expected a FilterError, got %v", err)
+	}
+}
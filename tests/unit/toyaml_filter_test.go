@@ -0,0 +1,62 @@
+package miya_test
+
+import (
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestToYAMLManifest(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithAutoEscape(true))
+	ctx := miya.NewContext()
+	ctx.Set("values", map[string]interface{}{
+		"name": "web",
+		"resources": map[string]interface{}{
+			"limits": map[string]interface{}{"cpu": "500m", "memory": "128Mi"},
+		},
+		"env": []interface{}{
+			map[string]interface{}{"name": "DATABASE_URL", "value": "postgres://db:5432/app?sslmode=require"},
+			map[string]interface{}{"name": "DEBUG", "value": "false"},
+			map[string]interface{}{"name": "GREETING", "value": "<Hello & welcome>\nsee you"},
+		},
+	})
+
+	tmpl := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ values.name }}
+spec:
+  containers:
+    - name: {{ values.name }}
+      resources:{{ values.resources|toyaml|nindent(8) }}
+      env:{{ values.env|toyaml(indent=2)|nindent(8) }}
+`
+	out, err := env.RenderString(tmpl, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  containers:
+    - name: web
+      resources:
+        limits:
+          cpu: 500m
+          memory: 128Mi
+      env:
+        - name: DATABASE_URL
+          value: postgres://db:5432/app?sslmode=require
+        - name: DEBUG
+          value: "false"
+        - name: GREETING
+          value: |-
+            <Hello & welcome>
+            see you
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
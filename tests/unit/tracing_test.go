@@ -0,0 +1,146 @@
+package miya_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+)
+
+// eventTracer records callbacks in order
+type eventTracer struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (t *eventTracer) add(event string) {
+	t.mu.Lock()
+	t.events = append(t.events, event)
+	t.mu.Unlock()
+}
+
+func (t *eventTracer) OnTemplateStart(name string) { t.add("start " + name) }
+func (t *eventTracer) OnTemplateEnd(name string, timing miya.TraceTiming) {
+	t.add("end " + name)
+}
+func (t *eventTracer) OnBlock(name string, timing miya.TraceTiming)   { t.add("block " + name) }
+func (t *eventTracer) OnInclude(name string, timing miya.TraceTiming) { t.add("include " + name) }
+func (t *eventTracer) OnFilter(name string, timing miya.TraceTiming)  { t.add("filter " + name) }
+
+func newTracingEnv() *miya.Environment {
+	templates := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	templates.AddTemplate("base.html", `<main>{% block content %}{% endblock %}</main>`)
+	templates.AddTemplate("page.html", `{% extends "base.html" %}{% block content %}{{ title|upper }}{% include "part.html" %}{% endblock %}`)
+	templates.AddTemplate("part.html", `{{ "x"|slow }}{% include "leaf.html" %}`)
+	templates.AddTemplate("leaf.html", `{{ "y"|slow }}`)
+
+	env := miya.NewEnvironment(miya.WithLoader(templates))
+	env.AddFilter("slow", func(value interface{}, args ...interface{}) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return value, nil
+	})
+	return env
+}
+
+func TestTracer(t *testing.T) {
+	t.Run("Events", func(t *testing.T) {
+		env := newTracingEnv()
+		tracer := &eventTracer{}
+		env.SetTracer(tracer)
+
+		ctx := miya.NewContext()
+		ctx.Set("title", "hi")
+		if _, err := env.RenderTemplate("page.html", ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			"start page.html",
+			"filter upper",
+			"filter slow",
+			"filter slow",
+			"include leaf.html",
+			"include part.html",
+			"block content",
+			"end page.html",
+		}
+		if got := strings.Join(tracer.events, "\n"); got != strings.Join(want, "\n") {
+			t.Errorf("events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+		}
+	})
+
+	t.Run("OverlayUsesParentTracer", func(t *testing.T) {
+		env := newTracingEnv()
+		tracer := &eventTracer{}
+		env.SetTracer(tracer)
+
+		if _, err := env.Overlay().RenderString(`{{ "a"|lower }}`, miya.NewContext()); err != nil {
+			t.Fatal(err)
+		}
+		if len(tracer.events) != 3 || tracer.events[1] != "filter lower" {
+			t.Errorf("events = %v", tracer.events)
+		}
+	})
+}
+
+func TestTraceRecorder(t *testing.T) {
+	env := newTracingEnv()
+	recorder := miya.NewTraceRecorder()
+	env.SetTracer(recorder)
+
+	ctx := miya.NewContext()
+	ctx.Set("title", "hi")
+	for i := 0; i < 2; i++ {
+		if _, err := env.RenderTemplate("page.html", ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := map[string]miya.TraceStat{}
+	var selfSum time.Duration
+	for _, stat := range recorder.Stats() {
+		stats[fmt.Sprintf("%s %s", stat.Kind, stat.Name)] = stat
+		selfSum += stat.Self
+	}
+
+	page := stats["template page.html"]
+	if page.Count != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	// Exclusive times partition the render, so nested includes are not counted twice
+	if selfSum != page.Total {
+		t.Errorf("sum of self times %s, want render total %s", selfSum, page.Total)
+	}
+
+	slow := stats["filter slow"]
+	part := stats["include part.html"]
+	leaf := stats["include leaf.html"]
+	if slow.Count != 4 || part.Count != 2 || leaf.Count != 2 || stats["block content"].Count != 2 {
+		t.Errorf("unexpected counts: %+v", stats)
+	}
+	if slow.Self < 20*time.Millisecond {
+		t.Errorf("slow filter self time %s", slow.Self)
+	}
+	if part.Total < leaf.Total+10*time.Millisecond || part.Self >= 10*time.Millisecond {
+		t.Errorf("include times not nested: part %+v, leaf %+v", part, leaf)
+	}
+
+	var report bytes.Buffer
+	if err := recorder.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != len(stats)+1 || strings.Join(strings.Fields(lines[1])[:3], " ") != "filter slow 4" {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+
+	recorder.Reset()
+	if len(recorder.Stats()) != 0 {
+		t.Error("Reset kept statistics")
+	}
+}
@@ -0,0 +1,60 @@
+package miya_test
+
+import (
+	"strings"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+)
+
+func TestTrimAndRemoveAffixFilters(t *testing.T) {
+	env := miya.NewEnvironment()
+	vars := map[string]interface{}{
+		"path": "/docs/guide/",
+		"url":  "https://example.com/a.html",
+	}
+	tests := []struct {
+		template, want string
+	}{
+		{`{{ path|trim("/") }}`, "docs/guide"},
+		{`{{ path|lstrip("/") }}|{{ path|rstrip("/") }}`, "docs/guide/|/docs/guide"},
+		{`{{ "«¡hola!»"|trim("«»¡!") }}`, "hola"},
+		{`{{ "  x  "|trim(chars=none) }}|{{ "--x--"|strip(chars="-") }}`, "x|x"},
+		{`{{ "  x  "|trim(nothing) }}`, "x"},
+		{`{{ url|removeprefix("https://")|removesuffix(".html") }}`, "example.com/a"},
+		{`{{ "aaa"|removeprefix("a") }}|{{ "aaa"|removesuffix("a") }}`, "aa|aa"},
+		{`{{ url|removeprefix("ftp://") }}`, "https://example.com/a.html"},
+		{`{{ "日本語テキスト"|removesuffix("テキスト") }}`, "日本語"},
+		{`[{{ nothing|trim("/") }}][{{ nothing|removeprefix("x") }}]`, "[][]"},
+		// Safe strings stay safe, the others are escaped
+		{`{{ "<b>x</b>/"|safe|rstrip("/") }}`, "<b>x</b>"},
+		{`{{ "<b>x</b>"|removesuffix("</b>") }}`, "&lt;b&gt;x"},
+		{`{{ "<b>x</b>"|safe|removeprefix("<b>") }}`, "x</b>"},
+	}
+	for _, tt := range tests {
+		tmpl, err := env.FromString(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		out, err := tmpl.Render(miya.NewContextFrom(vars))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, out, tt.want)
+		}
+	}
+
+	t.Run("StrictUndefined", func(t *testing.T) {
+		strict := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		for _, source := range []string{`{{ nothing|trim("/") }}`, `{{ nothing|removeprefix("x") }}`} {
+			tmpl, err := strict.FromString(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tmpl.Render(miya.NewContext()); err == nil || !strings.Contains(err.Error(), "nothing") {
+				t.Errorf("%s: got %v", source, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package miya_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/parser"
+)
+
+func TestUndefinedBehaviorModes(t *testing.T) {
+	// want is the expected output, or "error: <substring>" for a failed render
+	type probe struct {
+		template string
+		want     string
+	}
+
+	modes := []struct {
+		name     string
+		behavior miya.UndefinedBehavior
+		probes   []probe
+	}{
+		{"Silent", miya.UndefinedSilent, []probe{
+			{`[{{ user }}]`, "[]"},
+			{`[{{ user.name }}]`, "[]"},
+			{`[{{ user.address.city }}]`, "[]"},
+			{`[{{ user["name"] }}]`, "[]"},
+			{`[{{ greet() }}]`, "error: cannot call"},
+			{`[{{ user.greet() }}]`, "error: cannot call"},
+			{`[{{ user|default("anon") }}]`, "[anon]"},
+			{`[{{ user.name|default("anon") }}]`, "[anon]"},
+			{`[{{ "y" if false }}]`, "[]"},
+			{`[{{ ("y" if false)|default("n/a") }}]`, "[n/a]"},
+		}},
+		{"Strict", miya.UndefinedStrict, []probe{
+			{`[{{ user }}]`, "error: undefined variable: user"},
+			{`[{{ user.name }}]`, "error: undefined variable: user"},
+			{`[{{ user.address.city }}]`, "error: undefined variable: user"},
+			{`[{{ greet() }}]`, "error: undefined variable: greet"},
+			{`[{{ user.greet() }}]`, "error: undefined variable: user"},
+			{`[{{ user|default("anon") }}]`, "[anon]"},
+			{`[{{ user.name|default("anon") }}]`, "[anon]"},
+			{`[{{ user is defined }}]`, "[false]"},
+			{`[{{ "y" if true }}]`, "[y]"},
+			{`[{{ "y" if false }}]`, `error: undefined variable: "y" if false`},
+			{`[{{ ("y" if false)|default("n/a") }}]`, "[n/a]"},
+			{`[{{ ("y" if false) is defined }}]`, "[false]"},
+		}},
+		{"Debug", miya.UndefinedDebug, []probe{
+			{`[{{ user }}]`, "[{{ undefined variable: user (variable not found in context) }}]"},
+			{`[{{ user.address.city }}]`, "[{{ undefined variable: user.address.city (chained attribute access on undefined) }}]"},
+			{`[{{ greet() }}]`, "[{{ undefined variable: greet() (function call on undefined) }}]"},
+			{`[{{ user.greet() }}]`, "[{{ undefined variable: user.greet() (function call on undefined) }}]"},
+			{`[{{ user|default("anon") }}]`, "[anon]"},
+			{`[{{ user.name|default("anon") }}]`, "[anon]"},
+			{`[{{ "y" if false }}]`, `[{{ undefined variable: "y" if false (the condition is false and there is no else branch) }}]`},
+		}},
+		{"ChainFail", miya.UndefinedChainFail, []probe{
+			{`[{{ user }}]`, "[]"},
+			{`[{% if user %}yes{% else %}no{% endif %}]`, "[no]"},
+			{`[{{ user.name }}]`, "error: undefined variable: user.name"},
+			{`[{{ user.address.city }}]`, "error: undefined variable: user.address"},
+			{`[{{ user["name"] }}]`, "error: undefined variable: user[name]"},
+			{`[{{ greet() }}]`, "error: undefined variable: greet()"},
+			{`[{{ user|default("anon") }}]`, "[anon]"},
+			{`[{{ user.name|default("anon") }}]`, "[anon]"},
+			{`[{{ "y" if false }}]`, "[]"},
+		}},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			env := miya.NewEnvironment(miya.WithUndefinedBehavior(mode.behavior), miya.WithAutoEscape(false))
+			for _, p := range mode.probes {
+				got, err := env.RenderString(p.template, miya.NewContext())
+				if want, ok := strings.CutPrefix(p.want, "error: "); ok {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("%s: expected error containing %q, got %q, %v", p.template, want, got, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: unexpected error: %v", p.template, err)
+				} else if got != p.want {
+					t.Errorf("%s: got %q, want %q", p.template, got, p.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultFilterUnderStrictUndefined(t *testing.T) {
+	env := miya.NewEnvironment(miya.WithStrictUndefined(true), miya.WithAutoEscape(false))
+	ctx := miya.NewContextFrom(map[string]interface{}{
+		"user": map[string]interface{}{
+			"name":    "ann",
+			"nick":    "",
+			"visits":  0,
+			"tags":    []interface{}{},
+			"profile": map[string]interface{}{"city": "Oslo"},
+		},
+	})
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		// Lookup chains feeding default are forgiven at any depth
+		{`{{ user.missing.deep|default("n/a") }}`, "n/a"},
+		{`{{ user.profile.zip.code|d("n/a") }}`, "n/a"},
+		{`{{ user["missing"]["deep"]|default("n/a") }}`, "n/a"},
+		{`{{ nobody.profile["city"]|default("n/a") }}`, "n/a"},
+		{`{{ user.profile.city|default("n/a") }}`, "Oslo"},
+
+		// Without the boolean flag only undefined values are replaced
+		{`[{{ user.nick|default("x") }}][{{ user.visits|default("x") }}]`, "[][0]"},
+		{`[{{ user.nick|default("x", true) }}][{{ user.visits|default("x", true) }}][{{ user.tags|default("x", true) }}]`, "[x][x][x]"},
+		{`{{ user.name|default("x", true) }}`, "ann"},
+		{`{{ user.missing.deep|default("x", true) }}`, "x"},
+		{`{{ user.nick|default(user.name, boolean=true)|upper }}`, "ANN"},
+
+		// Other undefined errors still fail, even when default comes later
+		{`{{ user.missing.deep }}`, "error: undefined variable"},
+		{`{{ user["missing"] }}`, "error: undefined variable"},
+		{`{{ nobody|upper|default("n/a") }}`, "error: undefined variable: nobody"},
+		{`{{ nobody()|default("n/a") }}`, "error: undefined variable: nobody"},
+	}
+
+	for _, tt := range tests {
+		got, err := env.RenderString(tt.template, ctx)
+		if want, ok := strings.CutPrefix(tt.want, "error: "); ok {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected error containing %q, got %q, %v", tt.template, want, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.template, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestUndefinedBehaviorReachesIncludesImportsAndMacros(t *testing.T) {
+	stringLoader := loader.NewStringLoader(loader.NewDirectTemplateParser())
+	stringLoader.AddTemplate("partial.html", `{{ user.name }}`)
+	stringLoader.AddTemplate("macros.html", `{% macro show() %}{{ user.name }}{% endmacro %}`)
+	stringLoader.AddTemplate("include.html", `{% include "partial.html" %}`)
+	stringLoader.AddTemplate("import.html", `{% import "macros.html" as m %}{{ m.show() }}`)
+	stringLoader.AddTemplate("macro.html", `{% macro show() %}{{ user.name }}{% endmacro %}{{ show() }}`)
+
+	env := miya.NewEnvironment(
+		miya.WithLoader(stringLoader),
+		miya.WithUndefinedBehavior(miya.UndefinedChainFail),
+	)
+	for _, name := range []string{"include.html", "import.html", "macro.html"} {
+		_, err := env.RenderTemplate(name, miya.NewContext())
+		if err == nil || !strings.Contains(err.Error(), "user.name") {
+			t.Errorf("%s: expected chain failure for user.name, got %v", name, err)
+		}
+	}
+}
+
+func TestUndefinedFactory(t *testing.T) {
+	var mu sync.Mutex
+	var missing []string
+	env := miya.NewEnvironment(miya.WithUndefinedFactory(func(name string, node parser.Node) *miya.Undefined {
+		mu.Lock()
+		missing = append(missing, name)
+		mu.Unlock()
+		return &miya.Undefined{Name: name, Behavior: miya.UndefinedDebug, Hint: "missing"}
+	}))
+
+	got, err := env.RenderString(`{{ title }}|{{ title|default("x") }}`, miya.NewContext())
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if got != "{{ undefined variable: title (missing) }}|x" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if len(missing) != 2 || missing[0] != "title" {
+		t.Errorf("factory saw %v", missing)
+	}
+
+	t.Run("StrictValuesFail", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithUndefinedFactory(func(name string, node parser.Node) *miya.Undefined {
+			return &miya.Undefined{Name: name, Behavior: miya.UndefinedStrict, Node: node}
+		}))
+		if _, err := env.RenderString(`{{ title }}`, miya.NewContext()); err == nil {
+			t.Error("expected a strict undefined from the factory to fail the render")
+		}
+	})
+}
+
+func TestDebugUndefinedSuggestions(t *testing.T) {
+	type account struct {
+		Email string
+		Plan  string
+	}
+
+	env := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedDebug), miya.WithAutoEscape(false))
+	env.AddGlobal("site_name", "Acme")
+
+	ctx := miya.NewContext()
+	ctx.Set("user", map[string]interface{}{"name": "Ann", "email": "ann@example.com"})
+	ctx.Set("account", account{Email: "ann@example.com", Plan: "pro"})
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ usr }}`, "{{ undefined variable: usr (did you mean 'user'?) }}"},
+		{`{{ site_nmae }}`, "{{ undefined variable: site_nmae (did you mean 'site_name'?) }}"},
+		{`{{ user.nmae }}`, "{{ undefined variable: object.nmae (did you mean 'name'?) }}"},
+		{`{{ account.Emial }}`, "{{ undefined variable: account.Emial (did you mean 'Email'?) }}"},
+		{`{{ zzz }}`, "{{ undefined variable: zzz (variable not found in context) }}"},
+	}
+	for _, tt := range tests {
+		got, err := env.RenderString(tt.template, ctx)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.template, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	t.Run("EscapedUnderAutoescape", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithUndefinedBehavior(miya.UndefinedDebug), miya.WithAutoEscape(true))
+		ctx := miya.NewContext()
+		ctx.Set("tag<b>", 1)
+
+		got, err := env.RenderString(`{{ tag }}`, ctx)
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if want := "{{ undefined variable: tag (did you mean &#39;tag&lt;b&gt;&#39;?) }}"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package miya_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	miya "github.com/zipreport/miya"
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+func TestUndefinedComparison(t *testing.T) {
+	// Operands by name, with nope always undefined and other an undefined
+	// value of its own
+	operands := []string{"nope", "other", "none", "nothing", `""`, "0"}
+	undefined := map[string]bool{"nope": true, "other": true}
+	vars := map[string]interface{}{"nothing": nil}
+
+	equal := func(a, b string) bool {
+		if undefined[a] || undefined[b] {
+			return undefined[a] && undefined[b]
+		}
+		none := map[string]bool{"none": true, "nothing": true}
+		return a == b || (none[a] && none[b])
+	}
+
+	t.Run("lax", func(t *testing.T) {
+		for _, behavior := range []miya.UndefinedBehavior{miya.UndefinedSilent, miya.UndefinedDebug} {
+			env := miya.NewEnvironment(miya.WithUndefinedBehavior(behavior))
+			for _, a := range operands {
+				for _, b := range operands {
+					if !undefined[a] && !undefined[b] {
+						continue
+					}
+					expected := map[string]bool{
+						"==": equal(a, b), "!=": !equal(a, b),
+						"<": false, "<=": false, ">": false, ">=": false,
+					}
+					for op, want := range expected {
+						source := fmt.Sprintf("{{ %s %s %s }}", a, op, b)
+						result, err := env.RenderString(source, miya.NewContextFrom(vars))
+						if err != nil || result != fmt.Sprint(want) {
+							t.Errorf("behavior %d, %s: expected %v, got %q (%v)", behavior, source, want, result, err)
+						}
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		env := miya.NewEnvironment(miya.WithStrictUndefined(true))
+		for _, b := range operands {
+			for _, op := range []string{"==", "!=", "<", "<=", ">", ">="} {
+				source := fmt.Sprintf("line one\n{{ nope %s %s }}", op, b)
+				_, err := env.RenderString(source, miya.NewContextFrom(vars))
+				var runtimeErr *runtime.RuntimeError
+				if !errors.As(err, &runtimeErr) || runtimeErr.Line != 2 {
+					t.Errorf("%q: expected an undefined error at line 2, got %v", source, err)
+				}
+			}
+		}
+	})
+
+	t.Run("strict values", func(t *testing.T) {
+		// Undefined values made strict by the factory of a lax environment
+		env := miya.NewEnvironment(miya.WithUndefinedFactory(func(name string, node parser.Node) *miya.Undefined {
+			return &miya.Undefined{Name: name, Behavior: miya.UndefinedStrict, Node: node}
+		}))
+		for _, source := range []string{"{{ 1 < nope }}", "{{ nope >= 0 }}", `{{ nope > "" }}`} {
+			if _, err := env.RenderString(source, nil); err == nil {
+				t.Errorf("%s: expected an error", source)
+			}
+		}
+	})
+
+	t.Run("tests agree with operators", func(t *testing.T) {
+		env := miya.NewEnvironment()
+		source := `{{ nope is none }} {{ nope == none }} {{ nope is undefined }} {{ nope is defined }}|` +
+			`{{ nothing is none }} {{ nothing == None }} {{ nothing is undefined }}|` +
+			`{{ nope in [none, "", 0] }}`
+		expected := "false false true false|true true false|false"
+		result, err := env.RenderString(source, miya.NewContextFrom(vars))
+		if err != nil || result != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, result, err)
+		}
+	})
+}
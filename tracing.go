@@ -0,0 +1,146 @@
+package miya
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// SetTracer installs a tracer notified of template, block, include and filter
+// timings during renders; nil disables tracing. Overlays use their parent's
+// tracer unless they set their own. Set the tracer before rendering starts.
+//
+// TraceRecorder covers the common case. To export OpenTelemetry spans,
+// implement Tracer and create each span from the TraceTiming's Start and
+// Total, since callbacks other than OnTemplateStart arrive once the traced
+// operation has finished.
+func (e *Environment) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+}
+
+// activeTracer returns the tracer of the environment or its closest parent
+func (e *Environment) activeTracer() Tracer {
+	for env := e; env != nil; env = env.parent {
+		if env.tracer != nil {
+			return env.tracer
+		}
+	}
+	return nil
+}
+
+// TraceKind identifies what a TraceStat measures
+type TraceKind string
+
+const (
+	TraceTemplate TraceKind = "template"
+	TraceBlock    TraceKind = "block"
+	TraceInclude  TraceKind = "include"
+	TraceFilter   TraceKind = "filter"
+)
+
+// TraceStat aggregates the timings recorded for one template, block, include
+// or filter. Total and Self are the sums of the TraceTiming fields.
+type TraceStat struct {
+	Kind  TraceKind
+	Name  string
+	Count int
+	Total time.Duration
+	Self  time.Duration
+}
+
+// TraceRecorder is a Tracer that counts calls and accumulates durations per
+// template, block, include and filter. It is safe for concurrent renders.
+type TraceRecorder struct {
+	mutex sync.Mutex
+	stats map[traceKey]*TraceStat
+}
+
+type traceKey struct {
+	kind TraceKind
+	name string
+}
+
+// NewTraceRecorder creates an empty recorder
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{stats: make(map[traceKey]*TraceStat)}
+}
+
+func (r *TraceRecorder) record(kind TraceKind, name string, timing TraceTiming) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := traceKey{kind, name}
+	stat, ok := r.stats[key]
+	if !ok {
+		stat = &TraceStat{Kind: kind, Name: name}
+		r.stats[key] = stat
+	}
+	stat.Count++
+	stat.Total += timing.Total
+	stat.Self += timing.Self
+}
+
+// OnTemplateStart implements Tracer; templates are recorded when they end
+func (r *TraceRecorder) OnTemplateStart(name string) {}
+
+// OnTemplateEnd implements Tracer
+func (r *TraceRecorder) OnTemplateEnd(name string, timing TraceTiming) {
+	r.record(TraceTemplate, name, timing)
+}
+
+// OnBlock implements Tracer
+func (r *TraceRecorder) OnBlock(name string, timing TraceTiming) {
+	r.record(TraceBlock, name, timing)
+}
+
+// OnInclude implements Tracer
+func (r *TraceRecorder) OnInclude(name string, timing TraceTiming) {
+	r.record(TraceInclude, name, timing)
+}
+
+// OnFilter implements Tracer
+func (r *TraceRecorder) OnFilter(name string, timing TraceTiming) {
+	r.record(TraceFilter, name, timing)
+}
+
+// Stats returns a snapshot of the recorded statistics, slowest exclusive
+// time first
+func (r *TraceRecorder) Stats() []TraceStat {
+	r.mutex.Lock()
+	stats := make([]TraceStat, 0, len(r.stats))
+	for _, stat := range r.stats {
+		stats = append(stats, *stat)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Self != stats[j].Self {
+			return stats[i].Self > stats[j].Self
+		}
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind < stats[j].Kind
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// Reset discards everything recorded so far
+func (r *TraceRecorder) Reset() {
+	r.mutex.Lock()
+	r.stats = make(map[traceKey]*TraceStat)
+	r.mutex.Unlock()
+}
+
+// WriteReport writes the statistics as a table, slowest exclusive time first
+func (r *TraceRecorder) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "kind\tname\tcount\ttotal\tself")
+	for _, stat := range r.Stats() {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", stat.Kind, stat.Name, stat.Count, stat.Total, stat.Self)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,86 @@
+package miya
+
+import (
+	"fmt"
+
+	"github.com/zipreport/miya/parser"
+	"github.com/zipreport/miya/runtime"
+)
+
+// NodeTransformer rewrites a parsed template before it is cached. It is called
+// with the template's *parser.TemplateNode, whose Name identifies the
+// template, and returns the root to use in its place, usually after editing
+// it with parser.Transform.
+type NodeTransformer func(node parser.Node) (parser.Node, error)
+
+// AddNodeTransformer registers a transformer applied to every template the
+// environment parses, after parsing and before inheritance resolution and
+// caching. Transformers run once per template, in registration order, and a
+// transformer error fails the template load like a syntax error would.
+//
+// Templates encoded with EncodeTemplates are already transformed; don't
+// register the same transformers on the environment serving the bundle.
+func (e *Environment) AddNodeTransformer(transformer NodeTransformer) error {
+	if e.parent != nil {
+		return fmt.Errorf("node transformers change parsed templates and must be added to the root environment, not an overlay")
+	}
+
+	e.transformersMutex.Lock()
+	e.nodeTransformers = append(e.nodeTransformers, transformer)
+	e.transformersMutex.Unlock()
+
+	// Templates parsed before registration must pick up the new transformer
+	e.ClearCache()
+	e.ClearInheritanceCache()
+	return nil
+}
+
+// transformers returns the transformers of the root environment, which
+// overlays with a private template cache share
+func (e *Environment) transformers() []NodeTransformer {
+	root := e
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	root.transformersMutex.RLock()
+	defer root.transformersMutex.RUnlock()
+	return root.nodeTransformers
+}
+
+// applyNodeTransformers runs the registered transformers over a template, then
+// folds its constant expressions. The AST is modified in place; callers pass a
+// copy of ASTs they don't own.
+func (e *Environment) applyNodeTransformers(name string, ast *parser.TemplateNode) (*parser.TemplateNode, error) {
+	for _, transformer := range e.transformers() {
+		result, err := transformer(ast)
+		if err != nil {
+			return nil, fmt.Errorf("parser error in template %s: %w", name, err)
+		}
+		root, ok := result.(*parser.TemplateNode)
+		if !ok || root == nil {
+			return nil, fmt.Errorf("parser error in template %s: node transformer returned %T, want *parser.TemplateNode", name, result)
+		}
+		if root.Name == "" {
+			root.Name = name
+		}
+		ast = root
+	}
+	if _, err := runtime.FoldConstants(ast); err != nil {
+		return nil, fmt.Errorf("parser error in template %s: %w", name, err)
+	}
+	return ast, nil
+}
+
+// StripComments is a NodeTransformer that removes comment nodes. The
+// environment's own parser already discards comments, so this only shrinks
+// templates from loaders whose parser keeps them, such as one built on
+// parser.Parse.
+func StripComments(node parser.Node) (parser.Node, error) {
+	return parser.Transform(node, func(n parser.Node) (parser.Node, error) {
+		if _, ok := n.(*parser.CommentNode); ok {
+			return nil, nil
+		}
+		return n, nil
+	})
+}
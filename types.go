@@ -1,9 +1,95 @@
 package miya
 
-import "github.com/zipreport/miya/loader"
+import (
+	"github.com/zipreport/miya/loader"
+	"github.com/zipreport/miya/runtime"
+)
 
 type Loader = loader.Loader
 
 type FilterFunc func(value interface{}, args ...interface{}) (interface{}, error)
 
+// KeywordFilterFunc is a filter that receives keyword arguments, such as
+// width in {{ x|pad(width=3) }}, separately from positional ones; see
+// Environment.AddFilterKW
+type KeywordFilterFunc func(value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// ContextFilterFunc is a filter that also receives the render applying it;
+// see Environment.AddContextFilter
+type ContextFilterFunc func(ctx FilterContext, value interface{}, args []interface{}, kwargs map[string]interface{}) (interface{}, error)
+
+// FilterContext is what a ContextFilterFunc sees of the render applying it
+type FilterContext interface {
+	// GetVariable looks up a variable in scope where the filter is applied.
+	// It finds nothing when the filter is applied outside a render.
+	GetVariable(name string) (interface{}, bool)
+	// Environment returns the environment the filter was added to
+	Environment() *Environment
+}
+
 type TestFunc func(value interface{}, args ...interface{}) (bool, error)
+
+// UndefinedBehavior selects how undefined variables are handled; see WithUndefinedBehavior
+type UndefinedBehavior = runtime.UndefinedBehavior
+
+const (
+	UndefinedSilent    = runtime.UndefinedSilent
+	UndefinedStrict    = runtime.UndefinedStrict
+	UndefinedDebug     = runtime.UndefinedDebug
+	UndefinedChainFail = runtime.UndefinedChainFail
+)
+
+// Undefined is the value a template sees for a missing variable
+type Undefined = runtime.Undefined
+
+// UndefinedFactory creates Undefined values; see WithUndefinedFactory
+type UndefinedFactory = runtime.UndefinedFactory
+
+// Finalizer transforms every output value; see WithFinalizer
+type Finalizer = runtime.Finalizer
+
+// AutoescapeSelector decides from a template's name whether its output is
+// escaped; see WithAutoEscape and SelectAutoescape. The name is "" for
+// templates created with FromString.
+type AutoescapeSelector func(templateName string) bool
+
+// EscapeContext selects how output is escaped; see RenderEscapeContext
+type EscapeContext = runtime.EscapeContext
+
+const (
+	EscapeContextHTML  = runtime.EscapeContextHTML
+	EscapeContextXHTML = runtime.EscapeContextXHTML
+	EscapeContextXML   = runtime.EscapeContextXML
+	EscapeContextJS    = runtime.EscapeContextJS
+	EscapeContextCSS   = runtime.EscapeContextCSS
+	EscapeContextURL   = runtime.EscapeContextURL
+	EscapeContextJSON  = runtime.EscapeContextJSON
+	EscapeContextNone  = runtime.EscapeContextNone
+)
+
+// ChildContentPolicy selects how content outside the blocks of a child
+// template is treated; see WithChildContentPolicy
+type ChildContentPolicy = runtime.ChildContentPolicy
+
+const (
+	ChildContentIgnore = runtime.ChildContentIgnore
+	ChildContentWarn   = runtime.ChildContentWarn
+	ChildContentError  = runtime.ChildContentError
+)
+
+// TemplateWarning is a problem in a template that doesn't stop it rendering
+type TemplateWarning = runtime.TemplateWarning
+
+// WarningHandler receives template warnings; see WithWarningHandler
+type WarningHandler = runtime.WarningHandler
+
+// Tracer receives render timings; see Environment.SetTracer
+type Tracer = runtime.Tracer
+
+// TraceTiming is the timing of one traced operation
+type TraceTiming = runtime.TraceTiming
+
+// SecurityError is the error a render of a sandboxed environment fails with
+// when the template does something the sandbox forbids or reaches a limit;
+// see NewSandboxedEnvironment
+type SecurityError = runtime.SecurityError
@@ -2,29 +2,39 @@ package whitespace
 
 import (
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Pre-compiled regex patterns for whitespace processing (performance optimization)
+// Tags may span lines, so the patterns matching them let . match newlines
 var (
 	// Block tags with both strips: {%- ... -%}
-	reBothStripsBlock = regexp.MustCompile(`(\s*)\{%-\s*(.*?)\s*-%\}(\s*)`)
+	reBothStripsBlock = regexp.MustCompile(`(?s)(\s*)\{%-\s*(.*?)\s*-%\}(\s*)`)
 	// Block tags with left strip only: {%- ... %}
-	reLeftStripBlock = regexp.MustCompile(`(\s*)\{%-\s*(.*?)\s*%\}`)
+	reLeftStripBlock = regexp.MustCompile(`(?s)(\s*)\{%-\s*(.*?)\s*%\}`)
 	// Block tags with right strip only: {% ... -%}
-	reRightStripBlock = regexp.MustCompile(`\{%\s*(.*?)\s*-%\}(\s*)`)
+	reRightStripBlock = regexp.MustCompile(`(?s)\{%\s*(.*?)\s*-%\}(\s*)`)
 	// Variable tags with both strips: {{- ... -}}
-	reBothStripsVar = regexp.MustCompile(`(\s*)\{\{-\s*(.*?)\s*-\}\}(\s*)`)
+	reBothStripsVar = regexp.MustCompile(`(?s)(\s*)\{\{-\s*(.*?)\s*-\}\}(\s*)`)
 	// Variable tags with left strip only: {{- ... }}
-	reLeftStripVar = regexp.MustCompile(`(\s*)\{\{-\s*(.*?)\s*\}\}`)
+	reLeftStripVar = regexp.MustCompile(`(?s)(\s*)\{\{-\s*(.*?)\s*\}\}`)
 	// Variable tags with right strip only: {{ ... -}}
-	reRightStripVar = regexp.MustCompile(`\{\{\s*(.*?)\s*-\}\}(\s*)`)
-	// Comment tags: {#- ... -#} or {# ... #}
-	reCommentTag = regexp.MustCompile(`(\s*)\{#-?\s*(.*?)\s*-?#\}(\s*)`)
+	reRightStripVar = regexp.MustCompile(`(?s)\{\{\s*(.*?)\s*-\}\}(\s*)`)
+	// Comment tags: {#- ... -#} or {# ... #}, possibly spanning lines
+	reCommentTag = regexp.MustCompile(`(?s)\{#(-?).*?(-?)#\}`)
+	// Raw blocks: {% raw %}...{% endraw %}, with optional strip modifiers
+	reRawBlock = regexp.MustCompile(`(?s)\{%(-?)\s*raw\s*(-?)%\}(.*?)\{%(-?)\s*endraw\s*(-?)%\}`)
+	// Placeholders standing in for raw block bodies during processing
+	reRawPlaceholder = regexp.MustCompile("\x00([0-9]+)\x00")
 	// Trim blocks: remove newline after block statements
-	reTrimBlocks = regexp.MustCompile(`(\{%.*?%\})\r?\n`)
+	reTrimBlocks = regexp.MustCompile(`(?s)(\{%.*?%\})\r?\n`)
 	// Lstrip blocks: remove whitespace before block statements
-	reLstripBlocks = regexp.MustCompile(`\n[ \t]*(\{%.*?%\})`)
+	reLstripBlocks = regexp.MustCompile(`(?s)\n[ \t]*(\{%.*?%\})`)
+	// Include and filter tags with "indent content", which need the
+	// indentation of their line
+	reIndentContentTag = regexp.MustCompile(`^\{%\s*(include|filter)\s.*\bindent\s+content\b`)
 	// Compact whitespace patterns
 	reMultipleSpaces   = regexp.MustCompile(`[ \t]+`)
 	reMultipleNewlines = regexp.MustCompile(`\n\s*\n`)
@@ -48,8 +58,11 @@ func NewAdvancedWhitespaceProcessor(trimBlocks, lstripBlocks, keepTrailingNewlin
 
 // ProcessTemplate processes a template string and applies whitespace control
 func (a *AdvancedWhitespaceProcessor) ProcessTemplate(template string) string {
+	// Set raw block bodies aside so that tags inside them are left alone
+	result, rawBodies := a.extractRawBodies(template)
+
 	// Process {%- ... -%} syntax for inline whitespace control
-	result := a.processInlineWhitespaceControl(template)
+	result = a.processInlineWhitespaceControl(result)
 
 	// Apply global whitespace settings
 	if a.trimBlocks || a.lstripBlocks {
@@ -61,7 +74,82 @@ func (a *AdvancedWhitespaceProcessor) ProcessTemplate(template string) string {
 		result = strings.TrimSuffix(result, "\n")
 	}
 
-	return result
+	return restoreRawBodies(result, rawBodies)
+}
+
+// extractRawBodies replaces the body of every raw block with a placeholder,
+// returning the bodies with the whitespace control of the raw and endraw tags
+// already applied: -%} on raw and {%- on endraw strip the body's edges, and
+// trim_blocks and lstrip_blocks act on them as they would after and before
+// any other block tag.
+func (a *AdvancedWhitespaceProcessor) extractRawBodies(template string) (string, []string) {
+	matches := reRawBlock.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 {
+		return template, nil
+	}
+
+	var result strings.Builder
+	result.Grow(len(template))
+	bodies := make([]string, 0, len(matches))
+	lastEnd := 0
+
+	for _, match := range matches {
+		group := func(n int) string { return template[match[2*n]:match[2*n+1]] }
+		body := group(3)
+
+		if group(2) == "-" {
+			body = strings.TrimLeftFunc(body, unicode.IsSpace)
+		} else if a.trimBlocks {
+			body = body[newlineLength(body):]
+		}
+		if group(4) == "-" {
+			body = strings.TrimRightFunc(body, unicode.IsSpace)
+		} else if a.lstripBlocks {
+			body = lstripLine(body)
+		}
+
+		result.WriteString(template[lastEnd:match[0]])
+		result.WriteString("{%" + group(1) + " raw %}")
+		result.WriteString("\x00" + strconv.Itoa(len(bodies)) + "\x00")
+		result.WriteString("{% endraw " + group(5) + "%}")
+		bodies = append(bodies, body)
+		lastEnd = match[1]
+	}
+
+	result.WriteString(template[lastEnd:])
+	return result.String(), bodies
+}
+
+// restoreRawBodies puts the bodies set aside by extractRawBodies back
+func restoreRawBodies(template string, bodies []string) string {
+	if len(bodies) == 0 {
+		return template
+	}
+	return reRawPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		index, _ := strconv.Atoi(placeholder[1 : len(placeholder)-1])
+		return bodies[index]
+	})
+}
+
+// newlineLength returns the length of the newline s starts with, if any
+func newlineLength(s string) int {
+	switch {
+	case strings.HasPrefix(s, "\r\n"):
+		return 2
+	case strings.HasPrefix(s, "\n"):
+		return 1
+	}
+	return 0
+}
+
+// lstripLine removes the spaces and tabs at the end of s when nothing else
+// follows the last newline, or the start of s
+func lstripLine(s string) string {
+	trimmed := strings.TrimRight(s, " \t")
+	if trimmed == "" || strings.HasSuffix(trimmed, "\n") {
+		return trimmed
+	}
+	return s
 }
 
 // replaceWithSubmatch efficiently replaces regex matches using submatch index
@@ -128,33 +216,81 @@ func (a *AdvancedWhitespaceProcessor) processInlineWhitespaceControl(template st
 	// Process variable tags with right strip only: capture group 1 has content
 	result = replaceWithSubmatch(reRightStripVar, result, 1, "{{ ", " }}")
 
-	// Process comment tags (they get removed anyway)
-	result = reCommentTag.ReplaceAllString(result, "")
+	// Remove comments, stripping whitespace on the sides marked with -
+	result = a.removeComments(result)
 
 	return result
 }
 
+// removeComments removes comment tags. {#- strips the whitespace before the
+// comment and -#} the whitespace after it. Unmarked comments are treated like
+// block tags: lstrip_blocks removes their indentation and trim_blocks the
+// newline that follows them.
+func (a *AdvancedWhitespaceProcessor) removeComments(template string) string {
+	matches := reCommentTag.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 {
+		return template
+	}
+
+	var result strings.Builder
+	result.Grow(len(template))
+	lastEnd := 0
+
+	for _, match := range matches {
+		before := template[lastEnd:match[0]]
+		if match[3] > match[2] {
+			before = strings.TrimRightFunc(before, unicode.IsSpace)
+		} else if a.lstripBlocks {
+			// Only strip indentation when the comment starts its line
+			if lastEnd == 0 || strings.Contains(before, "\n") {
+				before = lstripLine(before)
+			}
+		}
+		result.WriteString(before)
+
+		lastEnd = match[1]
+		rest := template[lastEnd:]
+		if match[5] > match[4] {
+			lastEnd += len(rest) - len(strings.TrimLeftFunc(rest, unicode.IsSpace))
+		} else if a.trimBlocks {
+			lastEnd += newlineLength(rest)
+		}
+	}
+
+	result.WriteString(template[lastEnd:])
+	return result.String()
+}
+
 // applyGlobalWhitespace applies global trim_blocks and lstrip_blocks settings
 func (a *AdvancedWhitespaceProcessor) applyGlobalWhitespace(template string) string {
 	result := template
 
+	// lstrip_blocks goes first: it needs the newlines before the tags that
+	// trim_blocks removes after the tags of preceding lines
+	if a.lstripBlocks {
+		// Remove whitespace before block statements, except where the
+		// indentation is used
+		result = reLstripBlocks.ReplaceAllStringFunc(result, func(match string) string {
+			tag := match[strings.Index(match, "{%"):]
+			if reIndentContentTag.MatchString(tag) {
+				return match
+			}
+			return "\n" + tag
+		})
+	}
+
 	if a.trimBlocks {
 		// Remove newlines after block statements
 		result = reTrimBlocks.ReplaceAllString(result, "$1")
 	}
 
-	if a.lstripBlocks {
-		// Remove whitespace before block statements
-		result = reLstripBlocks.ReplaceAllString(result, "\n$1")
-	}
-
 	return result
 }
 
 // StripWhitespaceAroundTags strips whitespace around template tags based on control modifiers
 func StripWhitespaceAroundTags(template string) string {
 	processor := NewAdvancedWhitespaceProcessor(false, false, true)
-	return processor.processInlineWhitespaceControl(template)
+	return processor.ProcessTemplate(template)
 }
 
 // CompactWhitespace removes excessive whitespace while preserving structure
@@ -58,6 +58,50 @@ func TestAdvancedProcessTemplate(t *testing.T) {
 			t.Errorf("Expected 'Hello World\\n', got '%s'", result)
 		}
 	})
+
+	t.Run("Leaves raw block bodies alone", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(true, true, true)
+		template := "{% raw %} {{- x -}} {# c #}\n  {% if %}\n{% endraw %}"
+
+		result := processor.ProcessTemplate(template)
+
+		if result != template {
+			t.Errorf("Expected %q, got %q", template, result)
+		}
+	})
+
+	t.Run("Raw tag modifiers strip the body", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(false, false, true)
+		template := "a\n{%- raw -%}\n  {{ x }}\n{%- endraw -%}\nb"
+
+		result := processor.ProcessTemplate(template)
+
+		if result != "a{% raw %}{{ x }}{% endraw %}b" {
+			t.Errorf("Expected 'a{%% raw %%}{{ x }}{%% endraw %%}b', got %q", result)
+		}
+	})
+
+	t.Run("Raw tags follow trimBlocks and lstripBlocks", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(true, true, true)
+		template := "a:\n  {% raw %}\n  {{ x }}\n  {% endraw %}\nb"
+
+		result := processor.ProcessTemplate(template)
+
+		if result != "a:\n{% raw %}  {{ x }}\n{% endraw %}b" {
+			t.Errorf("Expected 'a:\\n{%% raw %%}  {{ x }}\\n{%% endraw %%}b', got %q", result)
+		}
+	})
+
+	t.Run("Comments follow trimBlocks and lstripBlocks", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(true, true, true)
+		template := "  {# first #}\na:\n  {# note #}\n  b: {# inline #}1\n"
+
+		result := processor.ProcessTemplate(template)
+
+		if result != "a:\n  b: 1\n" {
+			t.Errorf("Expected 'a:\\n  b: 1\\n', got %q", result)
+		}
+	})
 }
 
 // Test inline whitespace control processing
@@ -128,14 +172,25 @@ func TestProcessInlineWhitespaceControl(t *testing.T) {
 		}
 	})
 
-	t.Run("Comment tags are removed entirely", func(t *testing.T) {
+	t.Run("Comment tags without modifiers keep surrounding whitespace", func(t *testing.T) {
 		processor := NewAdvancedWhitespaceProcessor(false, false, true)
 		template := "Before  {# comment #}  After"
 
 		result := processor.processInlineWhitespaceControl(template)
 
-		if result != "BeforeAfter" {
-			t.Errorf("Expected 'BeforeAfter', got '%s'", result)
+		if result != "Before    After" {
+			t.Errorf("Expected 'Before    After', got '%s'", result)
+		}
+	})
+
+	t.Run("Comment tags with one modifier strip one side", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(false, false, true)
+		template := "a\n  {#- one\nline two #}\n b {# c -#}\n\n d"
+
+		result := processor.processInlineWhitespaceControl(template)
+
+		if result != "a\n b d" {
+			t.Errorf("Expected 'a\\n b d', got %q", result)
 		}
 	})
 
@@ -235,6 +290,30 @@ func TestApplyGlobalWhitespace(t *testing.T) {
 			t.Errorf("Expected '%s', got '%s'", expected, result)
 		}
 	})
+
+	t.Run("lstripBlocks strips block tags on consecutive lines", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(true, true, true)
+		template := "a\n  {% if x %}\n  {% if y %}\nb\n  {% endif %}\n  {% endif %}\nc"
+
+		result := processor.applyGlobalWhitespace(template)
+
+		expected := "a\n{% if x %}{% if y %}b\n{% endif %}{% endif %}c"
+		if result != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, result)
+		}
+	})
+
+	t.Run("lstripBlocks keeps the indentation of indent content tags", func(t *testing.T) {
+		processor := NewAdvancedWhitespaceProcessor(false, true, true)
+		template := "a:\n  {% include \"b.yaml\" indent content %}\n  {% filter upper indent content %}c{% endfilter %}\n  {% include \"d\" %}"
+
+		result := processor.applyGlobalWhitespace(template)
+
+		expected := "a:\n  {% include \"b.yaml\" indent content %}\n  {% filter upper indent content %}c{% endfilter %}\n{% include \"d\" %}"
+		if result != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, result)
+		}
+	})
 }
 
 // Test whitespace control parsing